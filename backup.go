@@ -22,10 +22,12 @@ import (
 	"context"
 	"encoding/binary"
 	"io"
+	"math"
 
 	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
 )
 
 // Backup is a wrapper function over Stream.Backup to generate full and incremental backups of the
@@ -37,14 +39,47 @@ func (db *DB) Backup(w io.Writer, since uint64) (uint64, error) {
 	return stream.Backup(w, since)
 }
 
+// BackupSince writes an incremental backup of the DB to w, holding only the versions newer than
+// sinceVersion. It returns the maximum version written, or sinceVersion if nothing changed, so the
+// returned value can be passed to the next invocation. A full backup, followed by the increments
+// in the order they were taken, can be restored by calling DB.Load on each of them.
+//
+// Deletions and expirations are written like any other version, and so are the range deletes of
+// Txn.DeleteRange, so that a restored DB converges to the same state. If a key changed more than
+// once since sinceVersion, its versions are written newest first, within the same KVList, but not
+// all of them: the versions older than the newest deletion or expiration are skipped, and so are
+// the ones older than a version set to discard them, which is followed by a delete marker instead.
+// The versions already discarded by the compactions, see Options.NumVersionsToKeep, are gone too,
+// and so are the range tombstones garbage collected once the versions they deleted were discarded.
+// Different keys are written in no particular order. Load keeps the original versions, so the
+// order in which entries are replayed doesn't matter.
+func (db *DB) BackupSince(w io.Writer, sinceVersion uint64) (uint64, error) {
+	if sinceVersion == math.MaxUint64 {
+		return sinceVersion, nil
+	}
+	stream := db.NewStream()
+	stream.LogPrefix = "DB.BackupSince"
+	maxVersion, err := stream.Backup(w, sinceVersion+1)
+	if err != nil {
+		return 0, err
+	}
+	if maxVersion < sinceVersion {
+		maxVersion = sinceVersion
+	}
+	return maxVersion, nil
+}
+
 // Backup dumps a protobuf-encoded list of all entries in the database into the
 // given writer, that are newer than the specified version. It returns a
 // timestamp indicating when the entries were dumped which can be passed into a
 // later invocation to generate an incremental dump, of entries that have been
 // added/modified since the last invocation of Stream.Backup().
 //
-// This can be used to backup the data in a database at a given point in time.
+// This can be used to backup the data in a database at a given point in time. The range
+// tombstones written by Txn.DeleteRange since the given version are dumped as well, and Load
+// applies them.
 func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
+	stream.sinceTs = since
 	stream.KeyToList = func(key []byte, itr *Iterator) (*pb.KVList, error) {
 		list := &pb.KVList{}
 		for ; itr.Valid(); itr.Next() {
@@ -64,10 +99,19 @@ func (stream *Stream) Backup(w io.Writer, since uint64) (uint64, error) {
 				var err error
 				valCopy, err = item.ValueCopy(nil)
 				if err != nil {
-					stream.db.opt.Errorf("Key [%x, %d]. Error while fetching value [%v]\n",
+					stream.db.opt.logger(LogComponentStream).Errorf(
+						"Key [%x, %d]. Error while fetching value [%v]\n",
 						item.Key(), item.Version(), err)
 					return nil, err
 				}
+				// Keep the metadata in front of the value, as it is stored.
+				if item.meta&bitMetadata > 0 {
+					md, err := item.Metadata()
+					if err != nil {
+						return nil, err
+					}
+					valCopy = encodeMetadata(md, valCopy)
+				}
 			}
 
 			// clear txn bits
@@ -161,6 +205,15 @@ func (l *KVLoader) Set(kv *pb.KV) error {
 		ExpiresAt: kv.ExpiresAt,
 		meta:      meta,
 	}
+	if start, end, ok := ParseDeleteRange(kv.Key); ok && meta&bitDelete == 0 {
+		// The stored range tombstones are only read when the DB is opened.
+		l.db.rangeDels.add(rangeTombstone{
+			start:   y.SafeCopy(nil, start),
+			end:     y.SafeCopy(nil, end),
+			version: kv.Version,
+			cmp:     l.db.cmp,
+		})
+	}
 	estimatedSize := int64(e.estimateSize(l.db.opt.ValueThreshold))
 	// Flush entries if inserting the next entry would overflow the transactional limits.
 	if int64(len(l.entries))+1 >= l.db.opt.maxBatchCount ||
@@ -207,10 +260,41 @@ func (l *KVLoader) Finish() error {
 // DB.Load() should be called on a database that is not running any other
 // concurrent transactions while it is running.
 func (db *DB) Load(r io.Reader, maxPendingWrites int) error {
+	return db.LoadWithOptions(r, LoadOptions{MaxPendingWrites: maxPendingWrites})
+}
+
+// LoadAction tells LoadWithOptions what to do with an entry which couldn't be loaded.
+type LoadAction int
+
+const (
+	// LoadAbort stops the load, which then returns the error.
+	LoadAbort LoadAction = iota
+	// LoadSkip drops the entry, and goes on with the next one.
+	LoadSkip
+)
+
+// LoadOptions is used to configure DB.LoadWithOptions.
+type LoadOptions struct {
+	// MaxPendingWrites is the number of batches which can be written concurrently.
+	// Defaults to 16 if not set.
+	MaxPendingWrites int
+	// Progress, if set, is called after each list of entries read from the backup, with the number
+	// of entries and bytes processed so far. Skipped entries are counted as processed.
+	Progress func(entries, bytes uint64)
+	// OnError, if set, is called with an entry which is not valid, along with the reason. The kv
+	// is nil if a whole list of entries couldn't be decoded. The returned action decides whether
+	// to skip the entry or to abort the load. Without OnError, the load is aborted. Errors while
+	// reading the backup or writing to the DB always abort the load.
+	OnError func(kv *pb.KV, err error) LoadAction
+}
+
+// LoadWithOptions works like DB.Load, but can report its progress and skip invalid entries. See
+// LoadOptions.
+func (db *DB) LoadWithOptions(r io.Reader, opt LoadOptions) error {
 	br := bufio.NewReaderSize(r, 16<<10)
 	unmarshalBuf := make([]byte, 1<<10)
 
-	ldr := db.NewKVLoader(maxPendingWrites)
+	l := db.newLoader(opt)
 	for {
 		var sz uint64
 		err := binary.Read(br, binary.LittleEndian, &sz)
@@ -230,25 +314,94 @@ func (db *DB) Load(r io.Reader, maxPendingWrites int) error {
 
 		list := &pb.KVList{}
 		if err := proto.Unmarshal(unmarshalBuf[:sz], list); err != nil {
+			if err := l.onError(nil, errors.Wrap(err, "while decoding a list of entries")); err != nil {
+				return err
+			}
+			list.Kv = nil
+		}
+		if err := l.add(list.Kv, 8+sz); err != nil {
 			return err
 		}
+	}
+	return l.finish()
+}
 
-		for _, kv := range list.Kv {
-			if err := ldr.Set(kv); err != nil {
+// loader writes the entries read by LoadWithOptions or ImportJSON to the DB.
+type loader struct {
+	db         *DB
+	opt        LoadOptions
+	ldr        *KVLoader
+	numEntries uint64
+	numBytes   uint64
+}
+
+func (db *DB) newLoader(opt LoadOptions) *loader {
+	maxPendingWrites := opt.MaxPendingWrites
+	if maxPendingWrites <= 0 {
+		maxPendingWrites = 16
+	}
+	return &loader{db: db, opt: opt, ldr: db.NewKVLoader(maxPendingWrites)}
+}
+
+// onError returns the error to abort the load with, or nil if the entry is to be skipped.
+func (l *loader) onError(kv *pb.KV, err error) error {
+	if l.opt.OnError != nil && l.opt.OnError(kv, err) == LoadSkip {
+		return nil
+	}
+	return err
+}
+
+// add writes kvs, read from numBytes bytes of input, to the DB.
+func (l *loader) add(kvs []*pb.KV, numBytes uint64) error {
+	l.numBytes += numBytes
+	for _, kv := range kvs {
+		l.numEntries++
+		if err := l.db.validateKV(kv); err != nil {
+			if err := l.onError(kv, err); err != nil {
 				return err
 			}
+			continue
+		}
+		if err := l.ldr.Set(kv); err != nil {
+			return err
+		}
 
-			// Update nextTxnTs, memtable stores this
-			// timestamp in badger head when flushed.
-			if kv.Version >= db.orc.nextTxnTs {
-				db.orc.nextTxnTs = kv.Version + 1
-			}
+		// Update nextTxnTs, memtable stores this
+		// timestamp in badger head when flushed.
+		if kv.Version >= l.db.orc.nextTxnTs {
+			l.db.orc.nextTxnTs = kv.Version + 1
 		}
 	}
+	if l.opt.Progress != nil {
+		l.opt.Progress(l.numEntries, l.numBytes)
+	}
+	return nil
+}
+
+// finish waits for the entries to be written, and makes them visible to the transactions.
+func (l *loader) finish() error {
+	if err := l.ldr.Finish(); err != nil {
+		return err
+	}
+	l.db.orc.txnMark.Done(l.db.orc.nextTxnTs - 1)
+	return nil
+}
 
-	if err := ldr.Finish(); err != nil {
+// validateKV checks that kv can be written to the DB.
+func (db *DB) validateKV(kv *pb.KV) error {
+	switch {
+	case len(kv.Key) == 0:
+		return ErrEmptyKey
+	case len(kv.Key) > maxKeySize:
+		return exceedsSize("Key", maxKeySize, kv.Key)
+	case bytes.HasPrefix(kv.Key, rangeDelPrefix):
+		// The range tombstones sent by Stream, see ParseDeleteRange.
+		_, _, err := parseRangeDelKey(kv.Key)
 		return err
+	case bytes.HasPrefix(kv.Key, badgerPrefix):
+		return ErrInvalidKey
+	case int64(len(kv.Value)) > db.opt.maxValueSize():
+		return exceedsSize("Value", db.opt.maxValueSize(), kv.Value)
 	}
-	db.orc.txnMark.Done(db.orc.nextTxnTs - 1)
 	return nil
 }
@@ -18,6 +18,7 @@ package badger
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -501,3 +502,200 @@ func TestBackupLoadIncremental(t *testing.T) {
 	})
 	require.NoError(t, err, "%v %v", updates, actual)
 }
+
+func TestBackupSince(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(tmpdir)
+
+	set := func(db *DB, e *Entry) {
+		require.NoError(t, db.Update(func(txn *Txn) error { return txn.SetEntry(e) }))
+	}
+	// latest returns the live keys of the DB, along with their values.
+	latest := func(db *DB) map[string]string {
+		kvs := make(map[string]string)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				val, err := it.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				kvs[string(it.Item().Key())] = string(val)
+			}
+			return nil
+		}))
+		return kvs
+	}
+
+	db1, err := Open(getTestOptions(filepath.Join(tmpdir, "backup")))
+	require.NoError(t, err)
+	defer db1.Close()
+	for i := 0; i < 10; i++ {
+		set(db1, NewEntry([]byte(fmt.Sprintf("key%d", i)), []byte("base")))
+	}
+	var base, incr bytes.Buffer
+	since, err := db1.BackupSince(&base, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), since)
+
+	set(db1, NewEntry([]byte("key0"), []byte("v1")))
+	set(db1, NewEntry([]byte("key0"), []byte("v2")))
+	set(db1, NewEntry([]byte("key1"), []byte("v1")).WithTTL(-time.Hour))
+	require.NoError(t, db1.Update(func(txn *Txn) error { return txn.Delete([]byte("key2")) }))
+	set(db1, NewEntry([]byte("key10"), []byte("new")))
+	next, err := db1.BackupSince(&incr, since)
+	require.NoError(t, err)
+	require.Equal(t, uint64(15), next)
+
+	// Nothing changed, so nothing is written and the version stays the same.
+	var empty bytes.Buffer
+	v, err := db1.BackupSince(&empty, next)
+	require.NoError(t, err)
+	require.Equal(t, next, v)
+	require.Zero(t, empty.Len())
+
+	// The increment alone only holds the keys which changed.
+	incrCopy := bytes.NewReader(incr.Bytes())
+	db2, err := Open(getTestOptions(filepath.Join(tmpdir, "incr")))
+	require.NoError(t, err)
+	defer db2.Close()
+	require.NoError(t, db2.Load(incrCopy, 16))
+	require.Equal(t, map[string]string{"key0": "v2", "key10": "new"}, latest(db2))
+
+	// The base backup followed by the increment converges to the same state.
+	db3, err := Open(getTestOptions(filepath.Join(tmpdir, "restore")))
+	require.NoError(t, err)
+	defer db3.Close()
+	require.NoError(t, db3.Load(&base, 16))
+	require.NoError(t, db3.Load(&incr, 16))
+	require.Equal(t, latest(db1), latest(db3))
+	require.Len(t, latest(db3), 9)
+}
+
+func TestLoadWithOptions(t *testing.T) {
+	kv := func(key string, version uint64) *pb.KV {
+		return &pb.KV{Key: []byte(key), Value: []byte("val"), Version: version}
+	}
+	var bb bytes.Buffer
+	require.NoError(t, writeTo(&pb.KVList{Kv: []*pb.KV{kv("a", 1), kv("b", 2)}}, &bb))
+	require.NoError(t, writeTo(&pb.KVList{Kv: []*pb.KV{kv("", 3), kv("c", 4)}}, &bb))
+	// A list which can't be decoded.
+	require.NoError(t, binary.Write(&bb, binary.LittleEndian, uint64(3)))
+	bb.Write([]byte{0xff, 0xff, 0xff})
+	require.NoError(t, writeTo(&pb.KVList{Kv: []*pb.KV{kv("d", 5)}}, &bb))
+	backup := bb.Bytes()
+
+	t.Run("skip", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			var numErrors int
+			var entries, numBytes uint64
+			require.NoError(t, db.LoadWithOptions(bytes.NewReader(backup), LoadOptions{
+				Progress: func(e, b uint64) {
+					require.True(t, e >= entries && b > numBytes)
+					entries, numBytes = e, b
+				},
+				OnError: func(kv *pb.KV, err error) LoadAction {
+					numErrors++
+					return LoadSkip
+				},
+			}))
+			require.Equal(t, 2, numErrors)
+			require.Equal(t, uint64(5), entries)
+			require.Equal(t, uint64(len(backup)), numBytes)
+			for _, key := range []string{"a", "b", "c", "d"} {
+				require.NoError(t, db.View(func(txn *Txn) error {
+					_, err := txn.Get([]byte(key))
+					return err
+				}))
+			}
+		})
+	})
+	t.Run("abort", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			err := db.LoadWithOptions(bytes.NewReader(backup), LoadOptions{})
+			require.Equal(t, ErrEmptyKey, err)
+
+			// Skip the invalid entry, but not the list which can't be decoded.
+			err = db.LoadWithOptions(bytes.NewReader(backup), LoadOptions{
+				OnError: func(kv *pb.KV, err error) LoadAction {
+					if kv != nil {
+						return LoadSkip
+					}
+					return LoadAbort
+				},
+			})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "while decoding a list of entries")
+		})
+	})
+}
+
+func TestBackupSinceDeleteRange(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(tmpdir)
+
+	keys := func(db *DB) []string {
+		var res []string
+		require.NoError(t, db.View(func(txn *Txn) error {
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				res = append(res, string(it.Item().Key()))
+			}
+			return nil
+		}))
+		return res
+	}
+	open := func(name string) *DB {
+		db, err := Open(getTestOptions(filepath.Join(tmpdir, name)))
+		require.NoError(t, err)
+		return db
+	}
+
+	db1 := open("backup")
+	defer db1.Close()
+	for _, key := range []string{"k1", "k2", "m1"} {
+		txnSet(t, db1, []byte(key), []byte("val"), 0)
+	}
+	var base, incr bytes.Buffer
+	since, err := db1.BackupSince(&base, 0)
+	require.NoError(t, err)
+	require.NoError(t, db1.Update(func(txn *Txn) error {
+		return txn.DeleteRange([]byte("k"), []byte("l"))
+	}))
+	next, err := db1.BackupSince(&incr, since)
+	require.NoError(t, err)
+	require.Equal(t, since+1, next)
+	var export bytes.Buffer
+	require.NoError(t, db1.ExportJSON(&export, ExportOptions{AllVersions: true}))
+
+	// The range delete is restored from the increment, and from the export.
+	for _, name := range []string{"load", "import"} {
+		db2 := open(name)
+		require.NoError(t, db2.Load(bytes.NewReader(base.Bytes()), 16))
+		require.Equal(t, []string{"k1", "k2", "m1"}, keys(db2))
+		if name == "load" {
+			require.NoError(t, db2.Load(bytes.NewReader(incr.Bytes()), 16))
+		} else {
+			require.NoError(t, db2.ImportJSON(bytes.NewReader(export.Bytes())))
+		}
+		require.Equal(t, []string{"m1"}, keys(db2), name)
+
+		// It was written to the DB too.
+		require.NoError(t, db2.Close())
+		db2 = open(name)
+		require.Equal(t, []string{"m1"}, keys(db2), name)
+		require.NoError(t, db2.Close())
+	}
+
+	// A stream of other keys doesn't carry the range delete.
+	var other bytes.Buffer
+	stream := db1.NewStream()
+	stream.Prefix = []byte("m")
+	_, err = stream.Backup(&other, since+1)
+	require.NoError(t, err)
+	require.Zero(t, other.Len())
+}
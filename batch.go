@@ -30,6 +30,39 @@ type WriteBatch struct {
 	throttle *y.Throttle
 	err      error
 	commitTs uint64
+
+	count    int    // Number of entries added to txn.
+	maxKey   []byte // Highest key added to txn.
+	txnBytes int64  // Bytes of the entries added to txn.
+
+	flushMu   sync.Mutex // Guards the fields below, which are updated by the commit callbacks.
+	flushCond *sync.Cond // Signaled when the commit of a batch completes.
+	onFlush   func(WriteBatchFlush)
+	flushes   []*pendingFlush // Batches being committed, in the order of the commits.
+	committed WriteBatchFlush
+	failed    bool // Set once a batch fails, no batch is reported from then on.
+
+	maxPendingBytes int64
+	pendingBytes    int64 // Bytes of the entries of txn and of the batches being committed.
+}
+
+// WriteBatchFlush describes a batch of entries committed by a WriteBatch.
+type WriteBatchFlush struct {
+	// Entries is the number of calls to Set, SetEntry and Delete committed by the batch.
+	Entries int
+	// MaxKey is the highest key of the batch.
+	MaxKey []byte
+	// TotalEntries is the number of entries committed by this batch and all the previous ones.
+	// The batches are reported in the order of the calls, so these are the first TotalEntries
+	// entries written to the WriteBatch.
+	TotalEntries int
+}
+
+type pendingFlush struct {
+	WriteBatchFlush
+	bytes int64
+	done  bool
+	err   error
 }
 
 // NewWriteBatch creates a new WriteBatch. This provides a way to conveniently do a lot of writes,
@@ -45,11 +78,13 @@ func (db *DB) NewWriteBatch() *WriteBatch {
 }
 
 func (db *DB) newWriteBatch() *WriteBatch {
-	return &WriteBatch{
+	wb := &WriteBatch{
 		db:       db,
 		txn:      db.newTransaction(true, true),
 		throttle: y.NewThrottle(16),
 	}
+	wb.flushCond = sync.NewCond(&wb.flushMu)
+	return wb
 }
 
 // SetMaxPendingTxns sets a limit on maximum number of pending transactions while writing batches.
@@ -59,6 +94,45 @@ func (wb *WriteBatch) SetMaxPendingTxns(max int) {
 	wb.throttle = y.NewThrottle(max)
 }
 
+// SetMaxPendingBytes limits the memory used by the WriteBatch to about max bytes. The bytes of an
+// entry are the bytes of its key and value, plus the header written to the value log. They are
+// held from the call to Set, SetEntry or Delete until the batch holding the entry is committed.
+// Once the limit is reached, these calls commit the entries buffered so far, and block until
+// enough bytes are released by the commits in progress. An entry bigger than max is only added
+// once no other entry is pending.
+//
+// This limit applies on top of the one set by SetMaxPendingTxns, and is independent of the
+// maximum size of a batch, given by DB.MaxBatchSize. By default, only SetMaxPendingTxns limits
+// the memory used. This function should be called before using WriteBatch.
+func (wb *WriteBatch) SetMaxPendingBytes(max int64) {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	wb.maxPendingBytes = max
+}
+
+// SetFlushCallback sets a function called each time a batch of entries has been committed. The
+// calls are not concurrent, and are made in the order of the batches, after the commit of the
+// batch and of all the previous ones completed: each entry has been written to the value log
+// (synced to disk if Options.SyncWrites is set) and the LSM tree. This makes the callback a safe
+// place to checkpoint the progress of a load. Once a batch fails, neither it nor the next batches
+// are reported, even if some of them got committed. This function should be called before using
+// WriteBatch.
+func (wb *WriteBatch) SetFlushCallback(fn func(WriteBatchFlush)) {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	wb.onFlush = fn
+}
+
+// Committed returns the last batch reported to the flush callback. Its TotalEntries is the number
+// of entries, counted from the first one, which are known to be committed. After Flush returns an
+// error, a load can be resumed from there, the entries committed past this point being written
+// again.
+func (wb *WriteBatch) Committed() WriteBatchFlush {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	return wb.committed
+}
+
 // Cancel function must be called if there's a chance that Flush might not get
 // called. If neither Flush or Cancel is called, the transaction oracle would
 // never get a chance to clear out the row commit timestamp map, thus causing an
@@ -68,7 +142,8 @@ func (wb *WriteBatch) SetMaxPendingTxns(max int) {
 // Note that any committed writes would still go through despite calling Cancel.
 func (wb *WriteBatch) Cancel() {
 	if err := wb.throttle.Finish(); err != nil {
-		wb.db.opt.Errorf("WatchBatch.Cancel error while finishing: %v", err)
+		wb.db.opt.logger(LogComponentWrite).Errorf(
+			"WatchBatch.Cancel error while finishing: %v", err)
 	}
 	wb.txn.Discard()
 }
@@ -88,12 +163,84 @@ func (wb *WriteBatch) callback(err error) {
 	wb.err = err
 }
 
+// flushed records the result of the commit of pf, and reports the batches whose commit, and the
+// commit of all the previous batches, completed.
+func (wb *WriteBatch) flushed(pf *pendingFlush, err error) {
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	pf.done, pf.err = true, err
+	wb.pendingBytes -= pf.bytes
+	wb.flushCond.Broadcast()
+	for !wb.failed && len(wb.flushes) > 0 && wb.flushes[0].done {
+		f := wb.flushes[0]
+		wb.flushes = wb.flushes[1:]
+		if f.err != nil {
+			wb.failed = true
+			break
+		}
+		if f.Entries == 0 {
+			continue
+		}
+		f.TotalEntries = wb.committed.TotalEntries + f.Entries
+		wb.committed = f.WriteBatchFlush
+		if wb.onFlush != nil {
+			wb.onFlush(wb.committed)
+		}
+	}
+}
+
+// batchEntrySize returns the bytes of e accounted by SetMaxPendingBytes.
+func batchEntrySize(e *Entry) int64 {
+	return int64(len(e.Key)+len(e.Value)) + maxHeaderSize
+}
+
+// waitForRoom blocks until size more bytes fit in the limit set by SetMaxPendingBytes, committing
+// the entries of txn if they are needed to make room. Caller must hold a write lock.
+func (wb *WriteBatch) waitForRoom(size int64) error {
+	wb.flushMu.Lock()
+	max, pending := wb.maxPendingBytes, wb.pendingBytes
+	wb.flushMu.Unlock()
+	if max <= 0 || pending == 0 || pending+size <= max {
+		return nil
+	}
+	if wb.count > 0 {
+		if err := wb.commit(); err != nil {
+			return err
+		}
+	}
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+	for wb.pendingBytes > 0 && wb.pendingBytes+size > max {
+		wb.flushCond.Wait()
+	}
+	return nil
+}
+
+// added records an entry of the given size added to txn.
+func (wb *WriteBatch) added(key []byte, size int64) {
+	wb.count++
+	if len(wb.maxKey) == 0 || wb.db.cmp.Compare(key, wb.maxKey) > 0 {
+		wb.maxKey = append(wb.maxKey[:0], key...)
+	}
+	wb.txnBytes += size
+	wb.flushMu.Lock()
+	wb.pendingBytes += size
+	wb.flushMu.Unlock()
+}
+
 // SetEntry is the equivalent of Txn.SetEntry.
 func (wb *WriteBatch) SetEntry(e *Entry) error {
 	wb.Lock()
 	defer wb.Unlock()
 
+	size := batchEntrySize(e)
+	if err := wb.waitForRoom(size); err != nil {
+		return err
+	}
 	if err := wb.txn.SetEntry(e); err != ErrTxnTooBig {
+		if err == nil {
+			wb.added(e.Key, size)
+		}
 		return err
 	}
 	// Txn has reached it's zenith. Commit now.
@@ -106,6 +253,7 @@ func (wb *WriteBatch) SetEntry(e *Entry) error {
 		wb.err = err
 		return err
 	}
+	wb.added(e.Key, size)
 	return nil
 }
 
@@ -120,7 +268,14 @@ func (wb *WriteBatch) Delete(k []byte) error {
 	wb.Lock()
 	defer wb.Unlock()
 
+	size := batchEntrySize(&Entry{Key: k})
+	if err := wb.waitForRoom(size); err != nil {
+		return err
+	}
 	if err := wb.txn.Delete(k); err != ErrTxnTooBig {
+		if err == nil {
+			wb.added(k, size)
+		}
 		return err
 	}
 	if err := wb.commit(); err != nil {
@@ -130,6 +285,7 @@ func (wb *WriteBatch) Delete(k []byte) error {
 		wb.err = err
 		return err
 	}
+	wb.added(k, size)
 	return nil
 }
 
@@ -141,15 +297,29 @@ func (wb *WriteBatch) commit() error {
 	if err := wb.throttle.Do(); err != nil {
 		return err
 	}
-	wb.txn.CommitWith(wb.callback)
+	pf := &pendingFlush{
+		WriteBatchFlush: WriteBatchFlush{Entries: wb.count, MaxKey: wb.maxKey},
+		bytes:           wb.txnBytes,
+	}
+	wb.count, wb.maxKey, wb.txnBytes = 0, nil, 0
+	wb.flushMu.Lock()
+	wb.flushes = append(wb.flushes, pf)
+	wb.flushMu.Unlock()
+	wb.txn.CommitWith(func(err error) {
+		// Report the batch before the throttle lets Flush return.
+		wb.flushed(pf, err)
+		wb.callback(err)
+	})
+	closing := wb.txn.closing
 	wb.txn = wb.db.newTransaction(true, true)
-	wb.txn.readTs = 0 // We're not reading anything.
+	wb.txn.closing = closing // A batch started before DB.BeginDrain can keep committing.
+	wb.txn.readTs = 0        // We're not reading anything.
 	wb.txn.commitTs = wb.commitTs
 	return wb.err
 }
 
 // Flush must be called at the end to ensure that any pending writes get committed to Badger. Flush
-// returns any error stored by WriteBatch.
+// returns any error stored by WriteBatch. If it fails, Committed tells which entries made it.
 func (wb *WriteBatch) Flush() error {
 	wb.Lock()
 	_ = wb.commit()
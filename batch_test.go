@@ -80,3 +80,68 @@ func TestWriteBatch(t *testing.T) {
 		require.NoError(t, db.Close())
 	})
 }
+
+func TestWriteBatchFlushCallback(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := func(i int) []byte {
+			return []byte(fmt.Sprintf("%10d", i))
+		}
+		wb := db.NewWriteBatch()
+		defer wb.Cancel()
+		var flushes []WriteBatchFlush
+		wb.SetFlushCallback(func(f WriteBatchFlush) {
+			// The entries of the batch are committed.
+			require.NoError(t, db.View(func(txn *Txn) error {
+				_, err := txn.Get(f.MaxKey)
+				return err
+			}))
+			flushes = append(flushes, f)
+		})
+
+		const N = 20000
+		for i := 0; i < N; i++ {
+			require.NoError(t, wb.Set(key(i), make([]byte, 128)))
+		}
+		require.NoError(t, wb.Flush())
+
+		require.True(t, len(flushes) > 1, "flushes=%d", len(flushes))
+		var total int
+		for _, f := range flushes {
+			total += f.Entries
+			require.Equal(t, total, f.TotalEntries)
+			// The keys are set in increasing order, and the batches are reported in order.
+			require.Equal(t, key(total-1), f.MaxKey)
+		}
+		require.Equal(t, N, total)
+		require.Equal(t, flushes[len(flushes)-1], wb.Committed())
+	})
+}
+
+func TestWriteBatchMaxPendingBytes(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		const max = 256 << 10
+		wb := db.NewWriteBatch()
+		defer wb.Cancel()
+		wb.SetMaxPendingBytes(max)
+
+		// The producer is much faster than the commits, which go through the value log.
+		val := make([]byte, 4<<10)
+		var maxPending int64
+		for i := 0; i < 2000; i++ {
+			require.NoError(t, wb.Set([]byte(fmt.Sprintf("%10d", i)), val))
+			wb.flushMu.Lock()
+			if wb.pendingBytes > maxPending {
+				maxPending = wb.pendingBytes
+			}
+			wb.flushMu.Unlock()
+		}
+		require.NoError(t, wb.Flush())
+		require.True(t, maxPending <= max, "maxPending=%d", maxPending)
+		require.Equal(t, int64(0), wb.pendingBytes)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte(fmt.Sprintf("%10d", 1999)))
+			return err
+		}))
+	})
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/ristretto"
+)
+
+// NewCache returns the ristretto cache used by default for the blocks of the tables, holding
+// approximately maxCost bytes. It can be set as Options.BlockCache or Options.IndexCache of several
+// DBs to share it between them, in which case it must be closed once all of them are closed.
+func NewCache(maxCost int64) (table.Cache, error) {
+	config := ristretto.Config{
+		// Use 5% of cache memory for storing counters.
+		NumCounters: int64(float64(maxCost) * 0.05 * 2),
+		MaxCost:     int64(float64(maxCost) * 0.95),
+		BufferItems: 64,
+		Metrics:     true,
+	}
+	cache, err := ristretto.NewCache(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &table.RistrettoCache{Cache: cache, MaxCost: config.MaxCost}, nil
+}
+
+// CacheMetrics holds the statistics of the block cache and of the index cache of a DB. Hits and
+// Misses count the lookups of the DB since it was opened. The other statistics are those of the
+// whole cache, which may be shared with other DBs, and are only known if the cache implements
+// table.StatsCache. They are reset by DB.DropAll if the cache is the default one.
+type CacheMetrics struct {
+	BlockCache table.CacheStats
+	IndexCache table.CacheStats // Zero if Options.IndexCache isn't set.
+}
+
+// CacheMetrics returns a snapshot of the statistics of the caches of the DB. It is cheap enough to
+// be polled frequently.
+func (db *DB) CacheMetrics() CacheMetrics {
+	var m CacheMetrics
+	m.BlockCache = db.blockCache.stats()
+	if db.indexCache != nil {
+		m.IndexCache = db.indexCache.stats()
+	}
+	return m
+}
+
+// countingCache counts the hits and misses of the lookups of a DB in a cache.
+type countingCache struct {
+	table.Cache
+	hits   uint64 // Atomic.
+	misses uint64 // Atomic.
+}
+
+func (c *countingCache) Get(key []byte) (interface{}, bool) {
+	val, ok := c.Cache.Get(key)
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	return val, ok
+}
+
+func (c *countingCache) stats() table.CacheStats {
+	var stats table.CacheStats
+	if sc, ok := c.Cache.(table.StatsCache); ok {
+		stats = sc.Stats()
+	}
+	stats.Hits = atomic.LoadUint64(&c.hits)
+	stats.Misses = atomic.LoadUint64(&c.misses)
+	return stats
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// checksumPageSize is the size of the records a range reader hands over to the hasher at once.
+const checksumPageSize = 1 << 20
+
+// ChecksumOptions are the options of DB.SnapshotChecksum.
+type ChecksumOptions struct {
+	// Prefix restricts the checksum to the keys with this prefix.
+	Prefix []byte
+	// ReadTs is the timestamp the keys are read at. It must be set in managed mode, and left zero
+	// otherwise, where the latest committed state is read.
+	ReadTs uint64
+	// NumGo is the number of goroutines reading the keys. Defaults to 8.
+	NumGo int
+}
+
+// SnapshotChecksum returns a SHA-256 digest of the logical state of the DB, that is the latest
+// version of each live key, along with its value, as of the read timestamp. Deleted and expired
+// keys are skipped. The keys are hashed in sorted order, so the digest doesn't depend on how the
+// data is laid out in the tables, and two DBs holding the same keys, values and versions give the
+// same digest. It can be used to check that a replica matches its primary.
+//
+// The keys are read concurrently, over ranges split along the tables, but the ranges are hashed
+// one after the other, in key order. SnapshotChecksum stops early, returning the error of ctx, if
+// ctx is canceled.
+func (db *DB) SnapshotChecksum(ctx context.Context, opt ChecksumOptions) ([]byte, error) {
+	var txn *Txn
+	switch {
+	case db.opt.managedTxns && opt.ReadTs == 0:
+		return nil, errors.New("SnapshotChecksum: ReadTs must be set in managed mode")
+	case db.opt.managedTxns:
+		txn = db.NewTransactionAt(opt.ReadTs, false)
+	case opt.ReadTs != 0:
+		return nil, errors.New("SnapshotChecksum: ReadTs can only be set in managed mode")
+	default:
+		txn = db.NewTransaction(false)
+	}
+	defer txn.Discard()
+	numGo := opt.NumGo
+	if numGo <= 0 {
+		numGo = 8
+	}
+
+	splits, err := db.SplitKeys(4 * numGo)
+	if err != nil {
+		return nil, err
+	}
+	// The ranges are [bounds[i-1], bounds[i]), the first one starting at the prefix and the last
+	// one unbounded.
+	var bounds [][]byte
+	for _, split := range splits {
+		if bytes.HasPrefix(split, opt.Prefix) && db.cmp.Compare(split, opt.Prefix) > 0 {
+			bounds = append(bounds, split)
+		}
+	}
+	bounds = append(bounds, nil)
+
+	read := func(ctx context.Context, left, right []byte, out chan<- []byte) error {
+		iopt := DefaultIteratorOptions
+		iopt.Prefix = opt.Prefix
+		itr := txn.NewIterator(iopt)
+		defer itr.Close()
+
+		send := func(page []byte) error {
+			select {
+			case out <- page:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		var page []byte
+		var buf [binary.MaxVarintLen64]byte
+		for itr.Seek(left); itr.Valid(); itr.Next() {
+			item := itr.Item()
+			if right != nil && db.cmp.Compare(item.Key(), right) >= 0 {
+				break
+			}
+			// A record is the length prefixed key, the version and the length prefixed value.
+			page = append(page, buf[:binary.PutUvarint(buf[:], uint64(len(item.Key())))]...)
+			page = append(page, item.Key()...)
+			binary.BigEndian.PutUint64(buf[:8], item.Version())
+			page = append(page, buf[:8]...)
+			if err := item.Value(func(val []byte) error {
+				// Unlike ValueSize, the length of the value read doesn't depend on where it's stored.
+				page = append(page, buf[:binary.PutUvarint(buf[:], uint64(len(val)))]...)
+				page = append(page, val...)
+				return nil
+			}); err != nil {
+				return err
+			}
+			if len(page) >= checksumPageSize {
+				if err := send(page); err != nil {
+					return err
+				}
+				page = nil
+			}
+		}
+		if len(page) > 0 {
+			return send(page)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	// Stop the readers before the transaction is discarded.
+	defer wg.Wait()
+	defer cancel()
+
+	pages := make([]chan []byte, len(bounds))
+	errs := make([]error, len(bounds))
+	for i := range pages {
+		pages[i] = make(chan []byte, 4)
+	}
+	// The readers are started in key order, so the range being hashed always has its reader
+	// running, while at most numGo-1 of the next ones read ahead.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sem := make(chan struct{}, numGo)
+		for i := range bounds {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				for ; i < len(bounds); i++ {
+					errs[i] = ctx.Err()
+					close(pages[i])
+				}
+				return
+			}
+			left := opt.Prefix
+			if i > 0 {
+				left = bounds[i-1]
+			}
+			wg.Add(1)
+			go func(i int, left []byte) {
+				defer wg.Done()
+				errs[i] = read(ctx, left, bounds[i], pages[i])
+				close(pages[i])
+				<-sem
+			}(i, left)
+		}
+	}()
+
+	h := sha256.New()
+	for i := range pages {
+		for page := range pages[i] {
+			h.Write(page)
+		}
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+	}
+	return h.Sum(nil), nil
+}
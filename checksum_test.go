@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotChecksum(t *testing.T) {
+	write := func(db *DB, ts uint64, keys []int, fn func(wb *WriteBatch, key []byte) error) {
+		wb := db.NewWriteBatchAt(ts)
+		for _, k := range keys {
+			require.NoError(t, fn(wb, []byte(fmt.Sprintf("%d-%05d", k%3, k))))
+		}
+		require.NoError(t, wb.Flush())
+	}
+	set := func(wb *WriteBatch, key []byte) error {
+		return wb.Set(key, append([]byte("value-"), key...))
+	}
+	var keys, reversed []int
+	for i := 0; i < 5000; i++ {
+		keys = append(keys, i)
+		reversed = append(reversed, 4999-i)
+	}
+
+	// The same keys, written in a different order, flushed to tables in the primary only.
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	primary, err := OpenManaged(getTestOptions(dir))
+	require.NoError(t, err)
+	defer primary.Close()
+	dir2, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir2)
+	replica, err := OpenManaged(getTestOptions(dir2).WithMaxTableSize(1 << 20).WithValueThreshold(8))
+	require.NoError(t, err)
+	defer replica.Close()
+	write(primary, 5, keys, set)
+	write(replica, 5, reversed, set)
+	write(primary, 7, keys[:100], func(wb *WriteBatch, key []byte) error { return wb.Delete(key) })
+	write(replica, 7, keys[:100], func(wb *WriteBatch, key []byte) error { return wb.Delete(key) })
+	require.NoError(t, primary.Flatten(1))
+	require.NotEmpty(t, primary.Tables(false))
+	require.Empty(t, replica.Tables(false))
+
+	checksum := func(db *DB, opt ChecksumOptions) []byte {
+		sum, err := db.SnapshotChecksum(context.Background(), opt)
+		require.NoError(t, err)
+		return sum
+	}
+	for _, opt := range []ChecksumOptions{
+		{ReadTs: 5}, {ReadTs: 7}, {ReadTs: 7, NumGo: 1}, {ReadTs: 7, Prefix: []byte("1-")},
+	} {
+		require.Equal(t, checksum(primary, opt), checksum(replica, opt), "%+v", opt)
+	}
+	require.NotEqual(t, checksum(primary, ChecksumOptions{ReadTs: 5}),
+		checksum(primary, ChecksumOptions{ReadTs: 7}))
+	require.NotEqual(t, checksum(primary, ChecksumOptions{ReadTs: 7}),
+		checksum(primary, ChecksumOptions{ReadTs: 7, Prefix: []byte("1-")}))
+
+	// A diverging value is detected.
+	write(replica, 9, keys[4000:4001], func(wb *WriteBatch, key []byte) error {
+		return wb.Set(key, []byte("diverged"))
+	})
+	write(primary, 9, keys[4000:4001], set)
+	require.NotEqual(t, checksum(primary, ChecksumOptions{ReadTs: 9}),
+		checksum(replica, ChecksumOptions{ReadTs: 9}))
+
+	// The values with metadata, inline in the primary and in the value log in the replica.
+	withMetadata := func(wb *WriteBatch, key []byte) error {
+		return wb.SetEntry(NewEntry(key, []byte("value")).WithMetadata([]byte("metadata")))
+	}
+	write(primary, 11, keys[:10], withMetadata)
+	write(replica, 11, keys[:10], withMetadata)
+	require.Equal(t, checksum(primary, ChecksumOptions{ReadTs: 11, Prefix: []byte("0-")}),
+		checksum(replica, ChecksumOptions{ReadTs: 11, Prefix: []byte("0-")}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = primary.SnapshotChecksum(ctx, ChecksumOptions{ReadTs: 9})
+	require.Equal(t, context.Canceled, err)
+	_, err = primary.SnapshotChecksum(context.Background(), ChecksumOptions{})
+	require.Error(t, err)
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// The values larger than Options.ValueChunkSize are split into chunks, each written to the value
+// log as an entry of its own, under the key of the value prefixed with badgerChunk. The chunks
+// can span several value log files. Once they are written, the entry of the value is written
+// with the manifest of the chunks as its value, and bitValuePointer set in its meta, which is
+// otherwise never set in the value log. The LSM tree points to the manifest entry, and
+// valueLog.Read reassembles the value from the chunks.
+//
+// The chunks are written before the entries of their transaction, and aren't part of it. They
+// are skipped on replay, so the chunks written before a crash which lost their transaction are
+// only garbage in the value log.
+//
+// The manifest is the total size of the value, followed by the value pointers of the chunks:
+//
+// +-----------------+----------------------+-----+----------------------+
+// | size (8 bytes)  | chunk 0 (vptrSize)   | ... | chunk n-1 (vptrSize) |
+// +-----------------+----------------------+-----+----------------------+
+
+// chunkSize returns the size of the chunks of the chunked values.
+func (vlog *valueLog) chunkSize() int64 {
+	if vlog.opt.ValueChunkSize > 0 {
+		return vlog.opt.ValueChunkSize
+	}
+	// The values written without ValueChunkSize fit in a value log file, so they are never chunked.
+	// The chunked values written while it was set can be larger, the GC moves them in chunks of
+	// the size of a value log file.
+	return vlog.opt.ValueLogFileSize
+}
+
+// isChunked returns true if the value of the entry e is to be written in chunks.
+func (vlog *valueLog) isChunked(e *Entry) bool {
+	return !e.skipVlog && int64(e.valueSize()) > vlog.chunkSize()
+}
+
+// chunkKey returns the key of the chunks of the value of key, which includes the timestamp.
+func chunkKey(key []byte) []byte {
+	ck := make([]byte, len(badgerChunk)+len(key))
+	n := copy(ck, badgerChunk)
+	copy(ck[n:], key)
+	return ck
+}
+
+// encodeChunkManifest returns the manifest of the chunks ptrs of a value of the given size.
+func encodeChunkManifest(size int, ptrs []valuePointer) []byte {
+	b := make([]byte, 8, 8+len(ptrs)*int(vptrSize))
+	binary.BigEndian.PutUint64(b, uint64(size))
+	for _, p := range ptrs {
+		b = append(b, p.Encode()...)
+	}
+	return b
+}
+
+// decodeChunkManifest returns the size of the value and the pointers of its chunks.
+func decodeChunkManifest(b []byte) (int, []valuePointer, error) {
+	if len(b) < 8 || (len(b)-8)%int(vptrSize) != 0 {
+		return 0, nil, errors.Errorf("Invalid chunk manifest of length %d", len(b))
+	}
+	size := int(binary.BigEndian.Uint64(b))
+	b = b[8:]
+	ptrs := make([]valuePointer, len(b)/int(vptrSize))
+	for i := range ptrs {
+		ptrs[i].Decode(b[i*int(vptrSize):])
+	}
+	return size, ptrs, nil
+}
+
+// writeChunks writes the chunks of the chunked values of the entries of b, with write, which
+// flushes them to the current value log file, and rotates it if full. It returns the manifest
+// entries to write in place of the entries, nil for the entries which aren't chunked.
+func (vlog *valueLog) writeChunks(b *request,
+	write func(e *Entry) (valuePointer, error)) ([]*Entry, error) {
+	var manifests []*Entry
+	for i, e := range b.Entries {
+		if !vlog.isChunked(e) {
+			continue
+		}
+		if manifests == nil {
+			manifests = make([]*Entry, len(b.Entries))
+		}
+		val := e.encodedValue()
+		ck := chunkKey(e.Key)
+		var ptrs []valuePointer
+		for len(val) > 0 {
+			n := int(vlog.chunkSize())
+			if n > len(val) {
+				n = len(val)
+			}
+			p, err := write(&Entry{Key: ck, Value: val[:n]})
+			if err != nil {
+				return nil, err
+			}
+			ptrs = append(ptrs, p)
+			val = val[n:]
+		}
+		// The metadata, if any, is in the chunks, and bitMetadata in the manifest.
+		manifests[i] = &Entry{
+			Key:       e.Key,
+			Value:     encodeChunkManifest(e.valueSize(), ptrs),
+			meta:      e.encodedMeta() | bitValuePointer,
+			UserMeta:  e.UserMeta,
+			ExpiresAt: e.ExpiresAt,
+		}
+	}
+	return manifests, nil
+}
+
+// readChunks reads and reassembles the value of the given size from its chunks ptrs into s. It
+// returns ErrRetry if a chunk is in a value log file deleted by the GC, in which case the value
+// was moved. The chunks are read one after the other, without holding the lock of the file of
+// the manifest, which can hold chunks too.
+func (vlog *valueLog) readChunks(size int, ptrs []valuePointer, s *y.Slice) ([]byte, error) {
+	out := s.Resize(size)
+	var cs y.Slice
+	var n int
+	for _, p := range ptrs {
+		chunk, _, cb, err := vlog.read(p, &cs)
+		if err == nil && n+len(chunk) > size {
+			err = errors.Errorf("Chunks %+v exceed the value size %d", ptrs, size)
+		}
+		if err == nil {
+			n += copy(out[n:], chunk)
+		}
+		runCallback(cb)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if n != size {
+		return nil, errors.Errorf("Chunks %+v are short of the value size %d", ptrs, size)
+	}
+	return out, nil
+}
+
+// liveChunk returns true if the chunk entry e, at vp, is part of a value which is still live.
+// It returns the key of the value, and the pointer of its manifest.
+func (vlog *valueLog) liveChunk(e Entry, vp valuePointer) ([]byte, y.ValueStruct, bool, error) {
+	key := e.Key[len(badgerChunk):]
+	vs, err := vlog.db.get(key)
+	if err != nil {
+		return nil, vs, false, err
+	}
+	if discardEntry(Entry{Key: key}, vs, vlog.db.opt.now()) {
+		return key, vs, false, nil
+	}
+	var mp valuePointer
+	mp.Decode(vs.Value)
+	var s y.Slice
+	manifest, meta, cb, err := vlog.read(mp, &s)
+	defer runCallback(cb)
+	switch {
+	case err == ErrRetry:
+		// The manifest was moved, along with the chunks.
+		return key, vs, false, nil
+	case err != nil:
+		return nil, vs, false, err
+	case meta&bitValuePointer == 0:
+		return key, vs, false, nil
+	}
+	_, ptrs, err := decodeChunkManifest(manifest)
+	if err != nil {
+		return nil, vs, false, err
+	}
+	for _, p := range ptrs {
+		if p == vp {
+			return key, vs, true, nil
+		}
+	}
+	return key, vs, false, nil
+}
+
+// isChunkKey returns true if key is the key of the chunk of a value.
+func isChunkKey(key []byte) bool {
+	return bytes.HasPrefix(key, badgerChunk)
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import "time"
+
+// Clock tells the time the expiry of the entries is evaluated against. See Options.Clock.
+type Clock interface {
+	// Now returns the current time, in seconds, like the ExpiresAt of the entries. It must not go
+	// backwards.
+	Now() uint64
+}
+
+// now returns the current time of opt.Clock, or of the system clock if it isn't set, as a Unix
+// time.
+func (opt *Options) now() uint64 {
+	if opt.Clock == nil {
+		return uint64(time.Now().Unix())
+	}
+	return opt.Clock.Now()
+}
+
+// expiresAt returns the expiry of an entry written now with the given time to live.
+func (opt *Options) expiresAt(ttl time.Duration) uint64 {
+	return uint64(int64(opt.now()) + int64(ttl/time.Second))
+}
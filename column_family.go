@@ -0,0 +1,245 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cfPrefix is the prefix of the keys of the column families. The keys of a family are prefixed
+// with cfPrefix, its name and a zero byte, which names can't hold, so that no family prefix is a
+// prefix of another.
+var cfPrefix = []byte("!cf!")
+
+// CFOptions are the options of a column family. The zero value uses the options of the DB.
+//
+// The compression can't be set per family, as the tables hold the keys of all the families.
+type CFOptions struct {
+	// NumVersionsToKeep overrides Options.NumVersionsToKeep for the keys of the family, see
+	// DB.SetVersionRetention. Zero keeps the one of the DB.
+	NumVersionsToKeep int
+	// DefaultTTL overrides Options.DefaultTTL for the entries set through the family without a
+	// TTL. Zero keeps the one of the DB.
+	DefaultTTL time.Duration
+}
+
+// ColumnFamily is a set of keys of the DB, isolated from the other keys by a reserved prefix,
+// with options of its own. See DB.ColumnFamily.
+type ColumnFamily struct {
+	db     *DB
+	name   string
+	prefix []byte
+	opt    CFOptions
+}
+
+// ColumnFamily returns the column family of the given name, with the given options. Its keys are
+// stored under the prefix "!cf!" followed by the name and a zero byte. Once a family is created,
+// the prefix is reserved: the keys set outside of the families can't start with "!cf!" anymore,
+// ErrInvalidKey is returned for them. The DBs which don't use column families can set any key.
+// The transactions of the family work on the keys of the family only, without their prefix.
+//
+// The options aren't persisted, so the family must be created again, along with its options, each
+// time the DB is opened. Its NumVersionsToKeep, if set, is registered with DB.SetVersionRetention
+// and applies to the compactions run from then on. If it isn't set, the one registered when the
+// family was created before, if any, is removed, and the one of the DB applies again.
+func (db *DB) ColumnFamily(name string, opt CFOptions) (*ColumnFamily, error) {
+	if len(name) == 0 || bytes.IndexByte([]byte(name), 0) >= 0 {
+		return nil, errors.Errorf("Invalid column family name %q, it must be non-empty and "+
+			"not contain a zero byte", name)
+	}
+	if opt.NumVersionsToKeep < 0 || opt.DefaultTTL < 0 {
+		return nil, ErrInvalidRequest
+	}
+	prefix := make([]byte, 0, len(cfPrefix)+len(name)+1)
+	prefix = append(prefix, cfPrefix...)
+	prefix = append(prefix, name...)
+	prefix = append(prefix, 0)
+	cf := &ColumnFamily{db: db, name: name, prefix: prefix, opt: opt}
+	atomic.StoreInt32(&db.hasColumnFamilies, 1)
+	if opt.NumVersionsToKeep > 0 {
+		if err := db.SetVersionRetention(prefix, opt.NumVersionsToKeep); err != nil {
+			return nil, err
+		}
+	} else {
+		db.retention.remove(prefix)
+	}
+	return cf, nil
+}
+
+// Name returns the name of the family.
+func (cf *ColumnFamily) Name() string {
+	return cf.name
+}
+
+// Prefix returns the prefix the keys of the family are stored under.
+func (cf *ColumnFamily) Prefix() []byte {
+	return cf.prefix
+}
+
+// key returns the key of the DB of the given key of the family.
+func (cf *ColumnFamily) key(key []byte) []byte {
+	k := make([]byte, len(cf.prefix)+len(key))
+	n := copy(k, cf.prefix)
+	copy(k[n:], key)
+	return k
+}
+
+// NewTransaction creates a new transaction over the keys of the family, see DB.NewTransaction.
+func (cf *ColumnFamily) NewTransaction(update bool) *CFTxn {
+	return &CFTxn{txn: cf.db.NewTransaction(update), cf: cf}
+}
+
+// View runs fn in a read-only transaction over the keys of the family, see DB.View.
+func (cf *ColumnFamily) View(fn func(txn *CFTxn) error) error {
+	return cf.db.View(func(txn *Txn) error {
+		return fn(&CFTxn{txn: txn, cf: cf})
+	})
+}
+
+// Update runs fn in a read-write transaction over the keys of the family, see DB.Update.
+func (cf *ColumnFamily) Update(fn func(txn *CFTxn) error) error {
+	return cf.db.Update(func(txn *Txn) error {
+		return fn(&CFTxn{txn: txn, cf: cf})
+	})
+}
+
+// Drop drops all the keys of the family, see DB.DropPrefix.
+func (cf *ColumnFamily) Drop() error {
+	return cf.db.DropPrefix(cf.prefix)
+}
+
+// CFTxn is a transaction over the keys of a column family. The keys given to its methods are
+// the keys of the family, without its prefix. The keys of the items it returns hold the prefix
+// though, see CFIterator.Key.
+type CFTxn struct {
+	txn *Txn
+	cf  *ColumnFamily
+}
+
+// Txn returns the transaction of the DB, whose methods take the keys of the DB, prefix included.
+func (txn *CFTxn) Txn() *Txn {
+	return txn.txn
+}
+
+// Commit commits the transaction, see Txn.Commit.
+func (txn *CFTxn) Commit() error {
+	return txn.txn.Commit()
+}
+
+// Discard discards the transaction, see Txn.Discard.
+func (txn *CFTxn) Discard() {
+	txn.txn.Discard()
+}
+
+// Get looks up the key in the family, see Txn.Get.
+func (txn *CFTxn) Get(key []byte) (*Item, error) {
+	return txn.txn.Get(txn.cf.key(key))
+}
+
+// Set sets the key in the family, see Txn.Set.
+func (txn *CFTxn) Set(key, val []byte) error {
+	return txn.SetEntry(NewEntry(key, val))
+}
+
+// SetEntry sets the entry, whose key is a key of the family, see Txn.SetEntry. The entry is
+// given the DefaultTTL of the family, if it's set and the entry doesn't expire. The entry isn't
+// modified, the transaction holds a copy of it with the key of the DB.
+func (txn *CFTxn) SetEntry(e *Entry) error {
+	ce := *e
+	ce.Key = txn.cf.key(e.Key)
+	ce.cfKey = true
+	if ttl := txn.cf.opt.DefaultTTL; ttl > 0 && ce.ExpiresAt == 0 && ce.ttl == 0 {
+		ce.ttl = ttl
+	}
+	return txn.txn.SetEntry(&ce)
+}
+
+// Delete deletes the key from the family, see Txn.Delete.
+func (txn *CFTxn) Delete(key []byte) error {
+	return txn.txn.modify(&Entry{Key: txn.cf.key(key), meta: bitDelete, cfKey: true})
+}
+
+// NewIterator returns an iterator over the keys of the family, see Txn.NewIterator. The Prefix
+// and the Bound of the options are keys of the family.
+func (txn *CFTxn) NewIterator(opt IteratorOptions) *CFIterator {
+	opt.Prefix = txn.cf.key(opt.Prefix)
+	if opt.Bound != nil {
+		opt.Bound = txn.cf.key(opt.Bound)
+	}
+	return &CFIterator{it: txn.txn.NewIterator(opt), cf: txn.cf}
+}
+
+// CFIterator iterates over the keys of a column family. It never goes past the keys of the
+// family.
+type CFIterator struct {
+	it *Iterator
+	cf *ColumnFamily
+}
+
+// Rewind rewinds the iterator to the first key of the family, see Iterator.Rewind.
+func (it *CFIterator) Rewind() {
+	it.it.Rewind()
+}
+
+// Seek seeks to the given key of the family, see Iterator.Seek.
+func (it *CFIterator) Seek(key []byte) {
+	if len(key) == 0 {
+		it.it.Rewind()
+		return
+	}
+	it.it.Seek(it.cf.key(key))
+}
+
+// Valid returns false once the iterator is past the keys of the family, see Iterator.Valid.
+func (it *CFIterator) Valid() bool {
+	return it.it.Valid()
+}
+
+// ValidForPrefix returns false once the iterator is past the keys of the family with the given
+// prefix, see Iterator.ValidForPrefix.
+func (it *CFIterator) ValidForPrefix(prefix []byte) bool {
+	return it.it.ValidForPrefix(it.cf.key(prefix))
+}
+
+// Next moves to the next key, see Iterator.Next.
+func (it *CFIterator) Next() {
+	it.it.Next()
+}
+
+// Item returns the current item, whose key holds the prefix of the family, see Iterator.Item.
+func (it *CFIterator) Item() *Item {
+	return it.it.Item()
+}
+
+// Key returns the key of the family of the current item. It's only valid until Next is called.
+func (it *CFIterator) Key() []byte {
+	return it.it.Item().Key()[len(it.cf.prefix):]
+}
+
+// Err returns the error which stopped the iteration, if any, see Iterator.Err.
+func (it *CFIterator) Err() error {
+	return it.it.Err()
+}
+
+// Close closes the iterator, see Iterator.Close.
+func (it *CFIterator) Close() {
+	it.it.Close()
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnFamily(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		for _, name := range []string{"", "a\x00b"} {
+			_, err := db.ColumnFamily(name, CFOptions{})
+			require.Error(t, err)
+		}
+
+		a, err := db.ColumnFamily("a", CFOptions{NumVersionsToKeep: 3, DefaultTTL: time.Hour})
+		require.NoError(t, err)
+		// The prefix of a isn't a prefix of the one of ab.
+		ab, err := db.ColumnFamily("ab", CFOptions{})
+		require.NoError(t, err)
+		require.Equal(t, 3, db.numVersionsToKeep(a.key([]byte("k"))))
+		require.Equal(t, db.opt.NumVersionsToKeep, db.numVersionsToKeep(ab.key([]byte("k"))))
+		// Creating the family again without a NumVersionsToKeep clears the previous one.
+		_, err = db.ColumnFamily("a", CFOptions{})
+		require.NoError(t, err)
+		require.Equal(t, db.opt.NumVersionsToKeep, db.numVersionsToKeep(a.key([]byte("k"))))
+		_, err = db.ColumnFamily("a", CFOptions{NumVersionsToKeep: 3})
+		require.NoError(t, err)
+
+		for _, cf := range []*ColumnFamily{a, ab} {
+			require.NoError(t, cf.Update(func(txn *CFTxn) error {
+				for i := 0; i < 10; i++ {
+					k := []byte(fmt.Sprintf("k%d", i))
+					if err := txn.Set(k, []byte(cf.Name()+string(k))); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		// The keys set outside of the families, before and after their prefixes. The keys with the
+		// prefix of the families can only be set through them.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			require.Equal(t, ErrInvalidKey, txn.Set([]byte("!cf!a"), []byte("outside")))
+			for _, k := range []string{"!cf!", "!cf!a", "!cf!a\x01", "!cf!aa", "k0"} {
+				e := &Entry{Key: []byte(k), Value: []byte("outside"), cfKey: true}
+				if err := txn.SetEntry(e); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		keys := func(cf *ColumnFamily, opt IteratorOptions) []string {
+			var keys []string
+			require.NoError(t, cf.View(func(txn *CFTxn) error {
+				it := txn.NewIterator(opt)
+				defer it.Close()
+				for it.Rewind(); it.Valid(); it.Next() {
+					val, err := it.Item().ValueCopy(nil)
+					require.NoError(t, err)
+					require.Equal(t, cf.Name()+string(it.Key()), string(val))
+					keys = append(keys, string(it.Key()))
+				}
+				return nil
+			}))
+			return keys
+		}
+		all := []string{"k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7", "k8", "k9"}
+		reversed := []string{"k9", "k8", "k7", "k6", "k5", "k4", "k3", "k2", "k1", "k0"}
+		for _, cf := range []*ColumnFamily{a, ab} {
+			require.Equal(t, all, keys(cf, DefaultIteratorOptions))
+			require.Equal(t, reversed, keys(cf, IteratorOptions{Reverse: true}))
+			require.Equal(t, []string{"k3"}, keys(cf, IteratorOptions{Prefix: []byte("k3")}))
+			require.Equal(t, all[:4], keys(cf, IteratorOptions{Bound: []byte("k4")}))
+		}
+
+		require.NoError(t, a.View(func(txn *CFTxn) error {
+			item, err := txn.Get([]byte("k1"))
+			require.NoError(t, err)
+			require.Equal(t, a.key([]byte("k1")), item.Key())
+			// The family TTL applies.
+			require.NotZero(t, item.ExpiresAt())
+
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			it.Seek([]byte("k5"))
+			require.True(t, it.ValidForPrefix([]byte("k5")))
+			require.Equal(t, "k5", string(it.Key()))
+			it.Next()
+			require.False(t, it.ValidForPrefix([]byte("k5")))
+			return nil
+		}))
+		require.NoError(t, ab.View(func(txn *CFTxn) error {
+			item, err := txn.Get([]byte("k1"))
+			require.NoError(t, err)
+			require.Zero(t, item.ExpiresAt())
+			return nil
+		}))
+
+		txn := a.NewTransaction(true)
+		// The entry given to SetEntry isn't modified.
+		e := NewEntry([]byte("k1"), []byte("ak1"))
+		require.NoError(t, txn.SetEntry(e))
+		require.Equal(t, []byte("k1"), e.Key)
+		require.Zero(t, e.ttl)
+		require.NoError(t, txn.Delete([]byte("k0")))
+		require.NoError(t, txn.Commit())
+		require.Equal(t, all[1:], keys(a, DefaultIteratorOptions))
+
+		// Dropping a family leaves the other keys.
+		require.NoError(t, a.Drop())
+		require.Empty(t, keys(a, DefaultIteratorOptions))
+		require.Equal(t, all, keys(ab, DefaultIteratorOptions))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("!cf!a"))
+			return err
+		}))
+	})
+}
+
+func TestColumnFamilyPrefixReserved(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		set := func(key string) error {
+			return db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key), []byte("val"))
+			})
+		}
+		// The DBs without column families can use the prefix.
+		require.NoError(t, set("!cf!a"))
+		_, err := db.ColumnFamily("a", CFOptions{})
+		require.NoError(t, err)
+		require.Equal(t, ErrInvalidKey, set("!cf!a"))
+		require.Equal(t, ErrInvalidKey, set("!cf!b"))
+		require.NoError(t, set("a"))
+	})
+}
@@ -22,6 +22,7 @@ import (
 	"log"
 	"math"
 	"sync"
+	"time"
 
 	"golang.org/x/net/trace"
 
@@ -47,34 +48,34 @@ func (r keyRange) equals(dst keyRange) bool {
 		r.inf == dst.inf
 }
 
-func (r keyRange) overlapsWith(dst keyRange) bool {
+func (r keyRange) overlapsWith(cmp y.Comparator, dst keyRange) bool {
 	if r.inf || dst.inf {
 		return true
 	}
 
 	// If my left is greater than dst right, we have no overlap.
-	if y.CompareKeys(r.left, dst.right) > 0 {
+	if cmp.CompareKeys(r.left, dst.right) > 0 {
 		return false
 	}
 	// If my right is less than dst left, we have no overlap.
-	if y.CompareKeys(r.right, dst.left) < 0 {
+	if cmp.CompareKeys(r.right, dst.left) < 0 {
 		return false
 	}
 	// We have overlap.
 	return true
 }
 
-func getKeyRange(tables ...*table.Table) keyRange {
+func getKeyRange(cmp y.Comparator, tables ...*table.Table) keyRange {
 	if len(tables) == 0 {
 		return keyRange{}
 	}
 	smallest := tables[0].Smallest()
 	biggest := tables[0].Biggest()
 	for i := 1; i < len(tables); i++ {
-		if y.CompareKeys(tables[i].Smallest(), smallest) < 0 {
+		if cmp.CompareKeys(tables[i].Smallest(), smallest) < 0 {
 			smallest = tables[i].Smallest()
 		}
-		if y.CompareKeys(tables[i].Biggest(), biggest) > 0 {
+		if cmp.CompareKeys(tables[i].Biggest(), biggest) > 0 {
 			biggest = tables[i].Biggest()
 		}
 	}
@@ -100,9 +101,9 @@ func (lcs *levelCompactStatus) debug() string {
 	return b.String()
 }
 
-func (lcs *levelCompactStatus) overlapsWith(dst keyRange) bool {
+func (lcs *levelCompactStatus) overlapsWith(cmp y.Comparator, dst keyRange) bool {
 	for _, r := range lcs.ranges {
-		if r.overlapsWith(dst) {
+		if r.overlapsWith(cmp, dst) {
 			return true
 		}
 	}
@@ -126,6 +127,7 @@ func (lcs *levelCompactStatus) remove(dst keyRange) bool {
 type compactStatus struct {
 	sync.RWMutex
 	levels []*levelCompactStatus
+	cmp    y.Comparator // The order of the keys of the DB.
 }
 
 func (cs *compactStatus) toLog(tr trace.Trace) {
@@ -146,7 +148,7 @@ func (cs *compactStatus) overlapsWith(level int, this keyRange) bool {
 	defer cs.RUnlock()
 
 	thisLevel := cs.levels[level]
-	return thisLevel.overlapsWith(this)
+	return thisLevel.overlapsWith(cs.cmp, this)
 }
 
 func (cs *compactStatus) delSize(l int) int64 {
@@ -164,15 +166,24 @@ func (cs *compactStatus) compareAndAdd(_ thisAndNextLevelRLocked, cd compactDef)
 	defer cs.Unlock()
 
 	level := cd.thisLevel.level
+	thisLevel := cs.levels[level]
+	if cd.thisLevel == cd.nextLevel {
+		// A compaction within a level only needs the range of this level.
+		if thisLevel.overlapsWith(cs.cmp, cd.thisRange) {
+			return false
+		}
+		thisLevel.ranges = append(thisLevel.ranges, cd.thisRange)
+		thisLevel.delSize += cd.thisSize
+		return true
+	}
 
 	y.AssertTruef(level < len(cs.levels)-1, "Got level %d. Max levels: %d", level, len(cs.levels))
-	thisLevel := cs.levels[level]
 	nextLevel := cs.levels[level+1]
 
-	if thisLevel.overlapsWith(cd.thisRange) {
+	if thisLevel.overlapsWith(cs.cmp, cd.thisRange) {
 		return false
 	}
-	if nextLevel.overlapsWith(cd.nextRange) {
+	if nextLevel.overlapsWith(cs.cmp, cd.nextRange) {
 		return false
 	}
 	// Check whether this level really needs compaction or not. Otherwise, we'll end up
@@ -191,12 +202,21 @@ func (cs *compactStatus) delete(cd compactDef) {
 	defer cs.Unlock()
 
 	level := cd.thisLevel.level
-	y.AssertTruef(level < len(cs.levels)-1, "Got level %d. Max levels: %d", level, len(cs.levels))
-
 	thisLevel := cs.levels[level]
+	thisLevel.delSize -= cd.thisSize
+	if cd.thisLevel == cd.nextLevel {
+		if !thisLevel.remove(cd.thisRange) {
+			this := cd.thisRange
+			fmt.Printf("Looking for: [%q, %q, %v] in this level.\n", this.left, this.right, this.inf)
+			fmt.Printf("This Level:\n%s\n", thisLevel.debug())
+			log.Fatal("keyRange not found")
+		}
+		return
+	}
+
+	y.AssertTruef(level < len(cs.levels)-1, "Got level %d. Max levels: %d", level, len(cs.levels))
 	nextLevel := cs.levels[level+1]
 
-	thisLevel.delSize -= cd.thisSize
 	found := thisLevel.remove(cd.thisRange)
 	found = nextLevel.remove(cd.nextRange) && found
 
@@ -211,3 +231,74 @@ func (cs *compactStatus) delete(cd compactDef) {
 		log.Fatal("keyRange not found")
 	}
 }
+
+// CompactionEvent describes a compaction which completed successfully. See
+// Options.OnCompaction.
+type CompactionEvent struct {
+	// FromLevel is the level the tables were compacted from, and ToLevel the level the new
+	// tables were added to. They are equal for a compaction within the last level.
+	FromLevel int
+	ToLevel   int
+
+	// InputTables and InputBytes are the number and the total size of the tables compacted.
+	InputTables int
+	InputBytes  int64
+	// OutputTables and OutputBytes are the number and the total size of the tables built.
+	OutputTables int
+	OutputBytes  int64
+
+	// DiscardedBytes is the size of the values in the value log which were discarded, because
+	// the keys pointing to them were deleted, expired or had newer versions.
+	DiscardedBytes int64
+
+	Duration time.Duration
+}
+
+// compactionEventsCapacity is the number of compaction events queued for Options.OnCompaction.
+const compactionEventsCapacity = 100
+
+// sendCompactionEvent queues ev for Options.OnCompaction. It never blocks the compaction: the
+// event is dropped if the queue is full.
+func (db *DB) sendCompactionEvent(ev CompactionEvent) {
+	if db.compactionEvents == nil {
+		return
+	}
+	select {
+	case db.compactionEvents <- ev:
+	default:
+		db.opt.logger(LogComponentCompact).Warningf(
+			"Dropping compaction event, OnCompaction is too slow: %+v", ev)
+	}
+}
+
+// runCompactionEvents calls Options.OnCompaction with the queued compaction events, until lc is
+// closed. The events still queued then are delivered before returning.
+func (db *DB) runCompactionEvents(lc *y.Closer) {
+	defer lc.Done()
+	for {
+		select {
+		case ev := <-db.compactionEvents:
+			db.opt.OnCompaction(ev)
+		case <-lc.HasBeenClosed():
+			for {
+				select {
+				case ev := <-db.compactionEvents:
+					db.opt.OnCompaction(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// SetCompactionRateLimit sets the limit of the IO of the compactions and of the value log GC, in
+// bytes per second, overriding Options.CompactionRateLimit. Zero removes the limit. The new limit
+// applies right away, to the compactions already waiting for the previous one too.
+func (db *DB) SetCompactionRateLimit(rate int64) error {
+	if rate < 0 {
+		return ErrInvalidRequest
+	}
+	db.compactionLimiter.SetRate(rate)
+	return nil
+}
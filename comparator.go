@@ -0,0 +1,90 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// ComparatorFileName is the name of the file storing the name of Options.KeyComparator. It is only
+// written for DBs which don't use the default order.
+const ComparatorFileName = "COMPARATOR"
+
+// KeyComparator orders the keys of a DB. See Options.KeyComparator.
+type KeyComparator interface {
+	// Name identifies the order. It is stored along with the DB, which can then only be opened
+	// with a comparator of the same name.
+	Name() string
+	// Compare returns a negative number, zero or a positive number if a is less than, equal to or
+	// greater than b. The keys don't have a timestamp.
+	Compare(a, b []byte) int
+}
+
+// comparator returns the y.Comparator of opt.KeyComparator, nil for the default order.
+func (opt *Options) comparator() y.Comparator {
+	if opt.KeyComparator == nil {
+		return nil
+	}
+	return opt.KeyComparator.Compare
+}
+
+// checkComparator compares the name of opt.KeyComparator with the one stored in the DB, and stores
+// it if the DB is new. A DB without a stored name uses the default order.
+func checkComparator(opt Options, newDB bool) error {
+	if opt.InMemory {
+		return nil
+	}
+	var name string
+	if opt.KeyComparator != nil {
+		name = opt.KeyComparator.Name()
+		if name == "" {
+			return errors.New("Options.KeyComparator must have a name")
+		}
+	}
+	path := filepath.Join(opt.Dir, ComparatorFileName)
+	stored, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if name == "" {
+			return nil
+		}
+		if !newDB {
+			return errors.Wrapf(ErrComparatorMismatch,
+				"the DB was created with the default order, not %q", name)
+		}
+		if opt.ReadOnly {
+			return nil
+		}
+		return ioutil.WriteFile(path, []byte(name), 0600)
+	case err != nil:
+		return y.Wrapf(err, "While reading %s", path)
+	}
+	if stored = bytes.TrimSpace(stored); string(stored) != name {
+		if name == "" {
+			name = "the default order"
+		}
+		return errors.Wrapf(ErrComparatorMismatch,
+			"the DB was created with the comparator %q, not %q", stored, name)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// segmentComparator orders the keys segment by segment, the segments being separated by '/'.
+// Unlike bytes.Compare, it sorts "a/b" before "a-b".
+type segmentComparator struct{}
+
+func (segmentComparator) Name() string { return "segments" }
+
+func (segmentComparator) Compare(a, b []byte) int {
+	sa, sb := bytes.Split(a, []byte("/")), bytes.Split(b, []byte("/"))
+	for i := 0; i < len(sa) && i < len(sb); i++ {
+		if cmp := bytes.Compare(sa[i], sb[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(sa) - len(sb)
+}
+
+type otherComparator struct{ segmentComparator }
+
+func (otherComparator) Name() string { return "other" }
+
+func TestKeyComparator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	var cmp segmentComparator
+	opt := getTestOptions(dir).WithKeyComparator(cmp).
+		WithCompactL0OnClose(false).WithKeepL0InMemory(false)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	var keys [][]byte
+	for i := 0; i < 3000; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("%d/%04d", i%7, i)),
+			[]byte(fmt.Sprintf("%d-%04d", i%7, i)))
+	}
+	wb := db.NewWriteBatch()
+	for _, k := range keys {
+		require.NoError(t, wb.Set(k, k))
+	}
+	require.NoError(t, wb.Flush())
+	sort.Slice(keys, func(i, j int) bool { return cmp.Compare(keys[i], keys[j]) < 0 })
+	require.True(t, cmp.Compare([]byte("3/0003"), []byte("3-0003")) < 0)
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, reverse := range []bool{false, true} {
+				opt := DefaultIteratorOptions
+				opt.Reverse = reverse
+				itr := txn.NewIterator(opt)
+				var got [][]byte
+				for itr.Rewind(); itr.Valid(); itr.Next() {
+					got = append(got, itr.Item().KeyCopy(nil))
+					require.Equal(t, got[len(got)-1], getItemValue(t, itr.Item()))
+				}
+				itr.Close()
+				if reverse {
+					for i, j := 0, len(got)-1; i < j; i, j = i+1, j-1 {
+						got[i], got[j] = got[j], got[i]
+					}
+				}
+				require.Equal(t, keys, got)
+			}
+
+			opt := DefaultIteratorOptions
+			opt.Prefix = []byte("3/")
+			itr := txn.NewIterator(opt)
+			defer itr.Close()
+			var n int
+			for itr.Seek([]byte("3/1500")); itr.Valid(); itr.Next() {
+				require.True(t, cmp.Compare(itr.Item().Key(), []byte("3/1500")) >= 0)
+				n++
+			}
+			require.Equal(t, 215, n)
+
+			for _, k := range keys {
+				item, err := txn.Get(k)
+				require.NoError(t, err)
+				require.Equal(t, k, getItemValue(t, item))
+			}
+			return nil
+		}))
+	}
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Greater(t, len(db.Tables(false)), 1)
+	require.NoError(t, db.Flatten(1))
+	check(db)
+	require.NoError(t, db.Close())
+
+	_, err = Open(opt.WithKeyComparator(nil))
+	require.Equal(t, ErrComparatorMismatch, errors.Cause(err))
+	_, err = Open(opt.WithKeyComparator(otherComparator{}))
+	require.Equal(t, ErrComparatorMismatch, errors.Cause(err))
+
+	// A DB created with the default order can't be opened with a custom comparator.
+	dir2, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir2)
+	db, err = Open(getTestOptions(dir2))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = Open(getTestOptions(dir2).WithKeyComparator(cmp))
+	require.Equal(t, ErrComparatorMismatch, errors.Cause(err))
+}
+
+func TestDisjointPrefixesComparator(t *testing.T) {
+	// Orders the keys in reverse, so that the keys of a prefix come before the prefix.
+	cmp := y.Comparator(func(a, b []byte) int { return bytes.Compare(b, a) })
+	var got []string
+	for _, p := range disjointPrefixes(cmp, [][]byte{[]byte("a0"), []byte("b"), []byte("a"),
+		[]byte("c"), []byte("b"), []byte("a00")}) {
+		got = append(got, string(p))
+	}
+	require.Equal(t, []string{"c", "b", "a"}, got)
+
+	// The keys out of the bounds are clamped in the order of the comparator.
+	require.Equal(t, 0.0, keyFraction(cmp, []byte("b"), []byte("a"), []byte("c")))
+	require.Equal(t, 1.0, keyFraction(cmp, []byte("b"), []byte("a"), []byte("0")))
+}
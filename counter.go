@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// A counter is stored as an 8 byte big-endian int64. Increments are written as merge entries
+// holding the delta, so they don't need to read the counter and never conflict with each other.
+// Reading the counter sums up the deltas, down to the latest version which is not a merge entry.
+
+const counterSize = 8
+
+func encodeCounter(v int64) []byte {
+	var buf [counterSize]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+	return buf[:]
+}
+
+func decodeCounter(b []byte) (int64, error) {
+	if len(b) != counterSize {
+		return 0, ErrInvalidCounter
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+// addCounter returns a+b, saturated to math.MaxInt64 or math.MinInt64 on overflow.
+func addCounter(a, b int64) int64 {
+	sum := a + b
+	switch {
+	case a > 0 && b > 0 && sum < 0:
+		return math.MaxInt64
+	case a < 0 && b < 0 && sum >= 0:
+		return math.MinInt64
+	}
+	return sum
+}
+
+// CounterMergeFunc is the MergeFunc of counters. It can be passed to GetMergeOperator to
+// periodically fold the deltas written by Increment into a single value, so that reading the
+// counter stays cheap. It should only be used with keys which are exclusively updated with
+// Increment. Values which are not counters are ignored.
+func CounterMergeFunc(existingVal, newVal []byte) []byte {
+	a, err := decodeCounter(existingVal)
+	if err != nil {
+		return newVal
+	}
+	b, err := decodeCounter(newVal)
+	if err != nil {
+		return existingVal
+	}
+	return encodeCounter(addCounter(a, b))
+}
+
+// Increment adds delta to the counter stored at key. The counter is created with a value of delta
+// if the key doesn't exist. Increment doesn't read the counter, so concurrent increments of the
+// same key never conflict with each other. On overflow, the counter saturates to math.MaxInt64 or
+// math.MinInt64.
+//
+// The current transaction keeps a reference to the key byte slice argument. Users must not
+// modify the key until the end of the transaction.
+func (txn *Txn) Increment(key []byte, delta int64) error {
+	if txn.discarded {
+		return ErrDiscardedTxn
+	}
+	if txn.update {
+		if e, has := txn.pendingWrites[string(key)]; has {
+			// Fold the delta into the pending write for the key.
+			if isDeletedOrExpired(e.meta, e.ExpiresAt, txn.db.opt.now()) {
+				return txn.SetEntry(NewEntry(key, encodeCounter(delta)))
+			}
+			v, err := decodeCounter(e.Value)
+			if err != nil {
+				return err
+			}
+			ne := *e
+			ne.Value = encodeCounter(addCounter(v, delta))
+			return txn.modify(&ne)
+		}
+	}
+	return txn.SetEntry(NewEntry(key, encodeCounter(delta)).withMergeBit())
+}
+
+// GetCounter returns the value of the counter stored at key, as seen by the transaction. It folds
+// all the deltas written by Increment, including the ones pending in this transaction. It returns
+// zero if the key doesn't exist, and ErrInvalidCounter if a value of the key is not a counter.
+func (txn *Txn) GetCounter(key []byte) (int64, error) {
+	if txn.discarded {
+		return 0, ErrDiscardedTxn
+	}
+	if len(key) == 0 {
+		return 0, ErrEmptyKey
+	}
+	var sum int64
+	if txn.update {
+		if e, has := txn.pendingWrites[string(key)]; has {
+			if isDeletedOrExpired(e.meta, e.ExpiresAt, txn.db.opt.now()) {
+				return 0, nil
+			}
+			v, err := decodeCounter(e.Value)
+			if err != nil {
+				return 0, err
+			}
+			if e.meta&bitMergeEntry == 0 {
+				return v, nil
+			}
+			sum = v
+		}
+	}
+
+	opt := DefaultIteratorOptions
+	opt.PrefetchValues = false
+	// The pending write, if any, has already been folded above. It would also hide a version
+	// committed at the read timestamp, which has the same internal key.
+	opt.skipPendingWrites = true
+	it := txn.NewKeyIterator(key, opt)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if item.IsDeletedOrExpired() {
+			break
+		}
+		var v int64
+		if err := item.Value(func(val []byte) (err error) {
+			v, err = decodeCounter(val)
+			return err
+		}); err != nil {
+			return 0, err
+		}
+		sum = addCounter(sum, v)
+		if item.meta&bitMergeEntry == 0 || item.DiscardEarlierVersions() {
+			break
+		}
+	}
+	return sum, nil
+}
+
+// GetCounter returns the latest value of the counter stored at key. See Txn.GetCounter.
+func (db *DB) GetCounter(key []byte) (v int64, err error) {
+	err = db.View(func(txn *Txn) error {
+		v, err = txn.GetCounter(key)
+		return err
+	})
+	return v, err
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter(t *testing.T) {
+	key := []byte("counter")
+	incr := func(t *testing.T, db *DB, delta int64) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Increment(key, delta)
+		}))
+	}
+
+	t.Run("missing key", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			v, err := db.GetCounter(key)
+			require.NoError(t, err)
+			require.Equal(t, int64(0), v)
+		})
+	})
+	t.Run("increments", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			incr(t, db, 5)
+			incr(t, db, -2)
+			incr(t, db, 10)
+			v, err := db.GetCounter(key)
+			require.NoError(t, err)
+			require.Equal(t, int64(13), v)
+
+			// A plain set resets the counter, and a delete clears it.
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set(key, encodeCounter(100))
+			}))
+			incr(t, db, 1)
+			v, err = db.GetCounter(key)
+			require.NoError(t, err)
+			require.Equal(t, int64(101), v)
+
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Delete(key)
+			}))
+			incr(t, db, 3)
+			v, err = db.GetCounter(key)
+			require.NoError(t, err)
+			require.Equal(t, int64(3), v)
+		})
+	})
+	t.Run("pending writes", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			incr(t, db, 7)
+			txn := db.NewTransaction(true)
+			defer txn.Discard()
+			require.NoError(t, txn.Increment(key, 1))
+			require.NoError(t, txn.Increment(key, 2))
+			v, err := txn.GetCounter(key)
+			require.NoError(t, err)
+			require.Equal(t, int64(10), v)
+			require.NoError(t, txn.Commit())
+
+			v, err = db.GetCounter(key)
+			require.NoError(t, err)
+			require.Equal(t, int64(10), v)
+		})
+	})
+	t.Run("overflow", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			incr(t, db, math.MaxInt64)
+			incr(t, db, 10)
+			v, err := db.GetCounter(key)
+			require.NoError(t, err)
+			require.Equal(t, int64(math.MaxInt64), v)
+			require.Equal(t, int64(math.MinInt64), addCounter(math.MinInt64, -1))
+		})
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set(key, []byte("foo"))
+			}))
+			incr(t, db, 1)
+			_, err := db.GetCounter(key)
+			require.Equal(t, ErrInvalidCounter, err)
+		})
+	})
+	t.Run("merge operator", func(t *testing.T) {
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			m := db.GetMergeOperator(key, CounterMergeFunc, 10*time.Millisecond)
+			for i := 0; i < 10; i++ {
+				incr(t, db, 2)
+			}
+			m.Stop() // Folds the deltas a last time.
+			v, err := db.GetCounter(key)
+			require.NoError(t, err)
+			require.Equal(t, int64(20), v)
+		})
+	})
+}
+
+func TestCounterConcurrent(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("counter")
+		const numGoroutines, numIncrements = 20, 100
+		var wg sync.WaitGroup
+		for i := 0; i < numGoroutines; i++ {
+			wg.Add(1)
+			go func(delta int64) {
+				defer wg.Done()
+				for j := 0; j < numIncrements; j++ {
+					// Increments never conflict, so none of them needs a retry.
+					require.NoError(t, db.Update(func(txn *Txn) error {
+						return txn.Increment(key, delta)
+					}))
+				}
+			}(int64(i + 1))
+		}
+		wg.Wait()
+		v, err := db.GetCounter(key)
+		require.NoError(t, err)
+		require.Equal(t, int64(numIncrements*numGoroutines*(numGoroutines+1)/2), v)
+	})
+}
@@ -35,7 +35,6 @@ import (
 	"github.com/dgraph-io/badger/v2/skl"
 	"github.com/dgraph-io/badger/v2/table"
 	"github.com/dgraph-io/badger/v2/y"
-	"github.com/dgraph-io/ristretto"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
 	"golang.org/x/net/trace"
@@ -46,16 +45,23 @@ var (
 	head              = []byte("!badger!head")    // For storing value offset for replay.
 	txnKey            = []byte("!badger!txn")     // For indicating end of entries in txn.
 	badgerMove        = []byte("!badger!move")    // For key-value pairs which got moved during GC.
+	badgerChunk       = []byte("!badger!chunk")   // For the chunks of the chunked values.
 	lfDiscardStatsKey = []byte("!badger!discard") // For storing lfDiscardStats
 )
 
 type closers struct {
-	updateSize *y.Closer
-	compactors *y.Closer
-	memtable   *y.Closer
-	writes     *y.Closer
-	valueGC    *y.Closer
-	pub        *y.Closer
+	updateSize       *y.Closer
+	compactors       *y.Closer
+	memtable         *y.Closer
+	writes           *y.Closer
+	valueGC          *y.Closer
+	valueGCLoop      *y.Closer
+	pub              *y.Closer
+	compactionEvents *y.Closer
+	rangeDelGC       *y.Closer
+	dropPrefix       *y.Closer
+	memtableAge      *y.Closer
+	groupCommit      *y.Closer
 }
 
 // DB provides the various functions required to interact with Badger.
@@ -70,6 +76,7 @@ type DB struct {
 	closers   closers
 	elog      trace.EventLog
 	mt        *skl.Skiplist   // Our latest (actively written) in-memory table
+	mtCreated time.Time       // When mt was created, for opt.MaxMemtableAge.
 	imm       []*skl.Skiplist // Add here only AFTER pushing to flushChan.
 	opt       Options
 	manifest  *manifestFile
@@ -87,11 +94,56 @@ type DB struct {
 
 	blockWrites int32
 
+	// diskFull is set to 1 once the size of the DB goes over Options.MaxDiskSize, and back to 0
+	// once it drops under the low watermark. Accessed via atomics.
+	diskFull int32
+
+	// flushRequested is set to 1 by WaitForQuiescence, for the memtable to be flushed before it's
+	// full. Accessed via atomics.
+	flushRequested int32
+
+	// draining is set to 1 by BeginDrain, and activeTxns counts the transactions which haven't
+	// been discarded yet, which Close waits for once draining is set. Accessed via atomics.
+	draining   int32
+	activeTxns int32
+
 	orc *oracle
 
 	pub        *publisher
 	registry   *KeyRegistry
-	blockCache *ristretto.Cache
+	blockCache *countingCache
+	indexCache *countingCache // Nil if opt.IndexCache isn't set.
+	cacheID    uint64         // Tells the tables of the DB apart in the caches shared with other DBs.
+
+	tableFiles *table.FileCache // Nil if opt.MaxOpenTableFiles isn't set.
+
+	compactionLimiter *y.RateLimiter // Limits the IO of the compactions and the value log GC.
+
+	retention versionRetention // Per-prefix overrides of opt.NumVersionsToKeep.
+
+	// hasColumnFamilies is set once DB.ColumnFamily is called, from then on the keys with cfPrefix
+	// can only be set through the families. Atomic.
+	hasColumnFamilies int32
+
+	compactionEvents chan CompactionEvent // Events for opt.OnCompaction, nil if it isn't set.
+
+	rangeDels  rangeTombstones // Range tombstones written by Txn.DeleteRange.
+	rangeDelGC chan struct{}   // Signals compactions to the range tombstone GC.
+
+	// dropLock is held by DropPrefix, and read locked by the compactions of DropPrefixAsync.
+	dropLock sync.RWMutex
+
+	// flushPause is held by WaitForQuiescence until its resume function is called, and read locked
+	// by the memtable flushes.
+	flushPause sync.RWMutex
+
+	repair repairState // What opt.RepairMode dropped while opening the DB.
+
+	cmp y.Comparator // Order of the keys, from opt.KeyComparator.
+
+	hotKeys *hotKeys // Nil unless opt.NumHotKeys is set.
+
+	groupCommit *groupCommitter // Nil unless opt.GroupCommitInterval is set.
 }
 
 const (
@@ -126,6 +178,10 @@ func (db *DB) replayFunction() func(Entry, valuePointer) error {
 			db.orc.nextTxnTs = y.ParseTs(e.Key)
 		}
 		db.orc.Unlock()
+		if isChunkKey(e.Key) {
+			// The chunks are only read through the manifest of their value.
+			return nil
+		}
 
 		nk := make([]byte, len(e.Key))
 		copy(nk, e.Key)
@@ -166,7 +222,8 @@ func (db *DB) replayFunction() func(Entry, valuePointer) error {
 				lastCommit = txnTs
 			}
 			if lastCommit != txnTs {
-				db.opt.Warningf("Found an incomplete txn at timestamp %d. Discarding it.\n",
+				db.opt.logger(LogComponentRecovery).Warningf(
+					"Found an incomplete txn at timestamp %d. Discarding it.\n",
 					lastCommit)
 				txn = txn[:0]
 				lastCommit = txnTs
@@ -186,6 +243,14 @@ func (db *DB) replayFunction() func(Entry, valuePointer) error {
 	}
 }
 
+// lastCacheID is the last ID given to a DB to tell its tables apart from the ones of other DBs in
+// the caches. Atomic.
+var lastCacheID uint64
+
+func newCacheID() uint64 {
+	return atomic.AddUint64(&lastCacheID, 1)
+}
+
 // Open returns a new DB object.
 func Open(opt Options) (db *DB, err error) {
 	if opt.InMemory && (opt.Dir != "" || opt.ValueDir != "") {
@@ -209,18 +274,58 @@ func Open(opt Options) (db *DB, err error) {
 	if !(opt.ValueLogFileSize <= 2<<30 && opt.ValueLogFileSize >= 1<<20) {
 		return nil, ErrValueLogSize
 	}
+	if opt.ValueChunkSize > 0 && (opt.ValueChunkSize < int64(opt.ValueThreshold) ||
+		opt.ValueChunkSize > opt.ValueLogFileSize) {
+		return nil, errors.Errorf("Invalid ValueChunkSize, must be between ValueThreshold (%d) "+
+			"and ValueLogFileSize (%d)", opt.ValueThreshold, opt.ValueLogFileSize)
+	}
+	if opt.ValueLogDirectIO && !opt.InMemory {
+		if !y.DirectIOSupported() {
+			return nil, errors.New("ValueLogDirectIO isn't supported on this platform")
+		}
+		if opt.ValueLogFS != nil {
+			return nil, errors.New("Cannot use ValueLogDirectIO along with ValueLogFS")
+		}
+	}
+	if opt.CompactionRateLimit < 0 {
+		return nil, errors.New("Invalid CompactionRateLimit, must not be negative")
+	}
+	gcLoop := opt.ValueLogGCInterval > 0 && !opt.InMemory && !opt.ReadOnly
+	if gcLoop && (opt.ValueLogGCDiscardRatio <= 0 || opt.ValueLogGCDiscardRatio >= 1) {
+		return nil, errors.Errorf("Invalid ValueLogGCDiscardRatio %v, must be between 0 and 1, "+
+			"exclusive", opt.ValueLogGCDiscardRatio)
+	}
+	if opt.GroupCommitInterval > 0 && opt.SyncWrites && !opt.InMemory {
+		return nil, errors.New("Cannot use GroupCommitInterval along with SyncWrites")
+	}
 	if !(opt.ValueLogLoadingMode == options.FileIO ||
 		opt.ValueLogLoadingMode == options.MemoryMap) {
 		return nil, ErrInvalidLoadingMode
 	}
+	if len(opt.LevelCompression) > opt.MaxLevels {
+		return nil, errors.Errorf("Invalid LevelCompression, must have at most MaxLevels (%d) "+
+			"entries", opt.MaxLevels)
+	}
+	if opt.MetricsRegisterer != nil {
+		if err := opt.MetricsRegisterer.RegisterMetrics(); err != nil {
+			return nil, errors.Wrap(err, "failed to register metrics")
+		}
+	}
 
 	// Compact L0 on close if either it is set or if KeepL0InMemory is set. When
 	// keepL0InMemory is set we need to compact L0 on close otherwise we might lose data.
 	opt.CompactL0OnClose = opt.CompactL0OnClose || opt.KeepL0InMemory
 
+	if opt.ReadOnlySnapshot {
+		opt.ReadOnly = true
+	}
+	if opt.RepairMode {
+		opt.Truncate = true
+	}
 	if opt.ReadOnly {
-		// Can't truncate if the DB is read only.
+		// Can't truncate or repair if the DB is read only.
 		opt.Truncate = false
+		opt.RepairMode = false
 		// Do not perform compaction in read only mode.
 		opt.CompactL0OnClose = false
 	}
@@ -233,9 +338,11 @@ func Open(opt Options) (db *DB, err error) {
 		if err := createDirs(opt); err != nil {
 			return nil, err
 		}
-		dirLockGuard, err = acquireDirectoryLock(opt.Dir, lockFile, opt.ReadOnly)
-		if err != nil {
-			return nil, err
+		if !opt.ReadOnlySnapshot {
+			dirLockGuard, err = acquireDirectoryLock(opt.Dir, lockFile, opt.ReadOnly)
+			if err != nil {
+				return nil, err
+			}
 		}
 		defer func() {
 			if dirLockGuard != nil {
@@ -250,7 +357,7 @@ func Open(opt Options) (db *DB, err error) {
 		if err != nil {
 			return nil, err
 		}
-		if absValueDir != absDir {
+		if absValueDir != absDir && !opt.ReadOnlySnapshot {
 			valueDirLockGuard, err = acquireDirectoryLock(opt.ValueDir, lockFile, opt.ReadOnly)
 			if err != nil {
 				return nil, err
@@ -263,6 +370,13 @@ func Open(opt Options) (db *DB, err error) {
 		}
 	}
 
+	manifestExists, err := exists(filepath.Join(opt.Dir, ManifestFilename))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkComparator(opt, !manifestExists); err != nil {
+		return nil, err
+	}
 	manifestFile, manifest, err := openOrCreateManifestFile(opt)
 	if err != nil {
 		return nil, err
@@ -278,20 +392,15 @@ func Open(opt Options) (db *DB, err error) {
 		elog = trace.NewEventLog("Badger", "DB")
 	}
 
-	config := ristretto.Config{
-		// Use 5% of cache memory for storing counters.
-		NumCounters: int64(float64(opt.MaxCacheSize) * 0.05 * 2),
-		MaxCost:     int64(float64(opt.MaxCacheSize) * 0.95),
-		BufferItems: 64,
-		Metrics:     true,
-	}
-	cache, err := ristretto.NewCache(&config)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create cache")
+	cache := opt.BlockCache
+	if cache == nil {
+		if cache, err = NewCache(opt.MaxCacheSize); err != nil {
+			return nil, errors.Wrap(err, "failed to create cache")
+		}
 	}
 	db = &DB{
 		imm:           make([]*skl.Skiplist, 0, opt.NumMemtables),
-		flushChan:     make(chan flushTask, opt.NumMemtables),
+		flushChan:     make(chan flushTask, opt.maxNumMemtables()),
 		writeCh:       make(chan *request, kvWriteChCapacity),
 		opt:           opt,
 		manifest:      manifestFile,
@@ -299,8 +408,25 @@ func Open(opt Options) (db *DB, err error) {
 		dirLockGuard:  dirLockGuard,
 		valueDirGuard: valueDirLockGuard,
 		orc:           newOracle(opt),
-		pub:           newPublisher(),
-		blockCache:    cache,
+		pub:           newPublisher(opt.comparator()),
+		blockCache:    &countingCache{Cache: cache},
+		cacheID:       newCacheID(),
+		cmp:           opt.comparator(),
+	}
+	if opt.IndexCache != nil {
+		db.indexCache = &countingCache{Cache: opt.IndexCache}
+	}
+	if opt.MaxOpenTableFiles > 0 {
+		db.tableFiles = table.NewFileCache(opt.MaxOpenTableFiles)
+	}
+	db.compactionLimiter = y.NewRateLimiter(opt.CompactionRateLimit)
+	if !opt.InMemory {
+		y.CompactionRateLimitUtilization.Set(opt.Dir, expvar.Func(func() interface{} {
+			return db.compactionLimiter.Utilization()
+		}))
+	}
+	if opt.NumHotKeys > 0 {
+		db.hotKeys = newHotKeys(opt.NumHotKeys, opt.HotKeySampling)
 	}
 
 	if db.opt.InMemory {
@@ -321,13 +447,20 @@ func Open(opt Options) (db *DB, err error) {
 	db.calculateSize()
 	db.closers.updateSize = y.NewCloser(1)
 	go db.updateSize(db.closers.updateSize)
-	db.mt = skl.NewSkiplist(arenaSize(opt))
+	db.mt = db.newMemtable()
+	db.mtCreated = time.Now()
 
 	// newLevelsController potentially loads files in directory.
 	if db.lc, err = newLevelsController(db, &manifest); err != nil {
 		return nil, err
 	}
 
+	if opt.OnCompaction != nil {
+		db.compactionEvents = make(chan CompactionEvent, compactionEventsCapacity)
+		db.closers.compactionEvents = y.NewCloser(1)
+		go db.runCompactionEvents(db.closers.compactionEvents)
+	}
+
 	if !opt.ReadOnly {
 		db.closers.compactors = y.NewCloser(1)
 		db.lc.startCompact(db.closers.compactors)
@@ -366,13 +499,38 @@ func Open(opt Options) (db *DB, err error) {
 	db.orc.readMark.Done(db.orc.nextTxnTs)
 	db.orc.incrementNextTs()
 
+	if err = db.loadRangeTombstones(); err != nil {
+		return db, y.Wrapf(err, "While loading range tombstones")
+	}
+
+	if db.opt.GroupCommitInterval > 0 && !db.opt.InMemory && !db.opt.ReadOnly {
+		db.groupCommit = newGroupCommitter(db)
+		db.closers.groupCommit = y.NewCloser(1)
+		go db.groupCommit.run(db.closers.groupCommit)
+	}
 	db.writeCh = make(chan *request, kvWriteChCapacity)
 	db.closers.writes = y.NewCloser(1)
 	go db.doWrites(db.closers.writes)
 
+	if !db.opt.ReadOnly {
+		db.rangeDelGC = make(chan struct{}, 1)
+		db.closers.rangeDelGC = y.NewCloser(1)
+		go db.runRangeTombstoneGC(db.closers.rangeDelGC)
+		db.closers.dropPrefix = y.NewCloser(0)
+	}
+
 	if !db.opt.InMemory {
 		db.closers.valueGC = y.NewCloser(1)
 		go db.vlog.waitOnGC(db.closers.valueGC)
+
+		if db.opt.ValueLogGCInterval > 0 && !db.opt.ReadOnly {
+			db.closers.valueGCLoop = y.NewCloser(1)
+			go db.runValueLogGCLoop(db.closers.valueGCLoop)
+		}
+	}
+	if db.opt.MaxMemtableAge > 0 && !db.opt.ReadOnly {
+		db.closers.memtableAge = y.NewCloser(1)
+		go db.flushOldMemtables(db.closers.memtableAge)
 	}
 
 	db.closers.pub = y.NewCloser(1)
@@ -384,11 +542,6 @@ func Open(opt Options) (db *DB, err error) {
 	return db, nil
 }
 
-// CacheMetrics returns the metrics for the underlying cache.
-func (db *DB) CacheMetrics() *ristretto.Metrics {
-	return db.blockCache.Metrics
-}
-
 // Close closes a DB. It's crucial to call it to ensure all the pending updates make their way to
 // disk. Calling DB.Close() multiple times would still only close the DB once.
 func (db *DB) Close() error {
@@ -399,15 +552,61 @@ func (db *DB) Close() error {
 	return err
 }
 
+// BeginDrain starts a graceful shutdown of the DB. The transactions created from then on can still
+// read, but their commits fail with ErrDBClosing. The transactions created before, and the write
+// batches, keep working and can commit. Close then waits for all the transactions to be discarded
+// before closing anything, so that the running reads, iterations and commits complete instead of
+// failing, or reading files which are being closed.
+//
+// BeginDrain doesn't wait. After it, a transaction which is never discarded, or a WriteBatch which
+// is never flushed or cancelled, blocks Close forever.
+func (db *DB) BeginDrain() {
+	atomic.StoreInt32(&db.draining, 1)
+}
+
+// waitForTxns waits for all the transactions to be discarded.
+func (db *DB) waitForTxns() {
+	logged := time.Now()
+	for {
+		n := atomic.LoadInt32(&db.activeTxns)
+		if n == 0 {
+			return
+		}
+		if time.Since(logged) > 10*time.Second {
+			db.opt.logger(LogComponentWrite).Infof(
+				"Close is waiting for %d transactions to be discarded", n)
+			logged = time.Now()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func (db *DB) close() (err error) {
 	db.elog.Printf("Closing database")
 
+	if atomic.LoadInt32(&db.draining) == 1 {
+		db.waitForTxns()
+	}
 	atomic.StoreInt32(&db.blockWrites, 1)
+	// Don't let the rate limit hold up the value log GC and the compactions being stopped.
+	db.compactionLimiter.Close()
 
 	if !db.opt.InMemory {
 		// Stop value GC first.
+		if db.closers.valueGCLoop != nil {
+			db.closers.valueGCLoop.SignalAndWait()
+		}
 		db.closers.valueGC.SignalAndWait()
 	}
+	if db.closers.dropPrefix != nil {
+		db.closers.dropPrefix.SignalAndWait()
+	}
+	if db.closers.rangeDelGC != nil {
+		db.closers.rangeDelGC.SignalAndWait()
+	}
+	if db.closers.memtableAge != nil {
+		db.closers.memtableAge.SignalAndWait()
+	}
 
 	// Stop writes next.
 	db.closers.writes.SignalAndWait()
@@ -415,6 +614,11 @@ func (db *DB) close() (err error) {
 	// Don't accept any more write.
 	close(db.writeCh)
 
+	// Acknowledge the last group of commits.
+	if db.closers.groupCommit != nil {
+		db.closers.groupCommit.SignalAndWait()
+	}
+
 	db.closers.pub.SignalAndWait()
 
 	// Now close the value log.
@@ -465,19 +669,30 @@ func (db *DB) close() (err error) {
 			// This error only means that there might be enough tables to do a compaction. So, we
 			// should not report it to the end user to avoid confusing them.
 		case nil:
-			db.opt.Infof("Force compaction on level 0 done")
+			db.opt.logger(LogComponentCompact).Infof("Force compaction on level 0 done")
 		default:
-			db.opt.Warningf("While forcing compaction on level 0: %v", err)
+			db.opt.logger(LogComponentCompact).Warningf(
+				"While forcing compaction on level 0: %v", err)
 		}
 	}
 
 	if lcErr := db.lc.close(); err == nil {
 		err = errors.Wrap(lcErr, "DB.Close")
 	}
+	if db.closers.compactionEvents != nil {
+		db.closers.compactionEvents.SignalAndWait()
+	}
 	db.elog.Printf("Waiting for closer")
 	db.closers.updateSize.SignalAndWait()
 	db.orc.Stop()
-	db.blockCache.Close()
+	if !db.opt.InMemory {
+		// Release the limiter, which the metric would keep alive.
+		y.CompactionRateLimitUtilization.Set(db.opt.Dir, new(expvar.Int))
+	}
+	// Caches set with the options may be shared with other DBs, it is up to the user to close them.
+	if db.opt.BlockCache == nil {
+		db.blockCache.Close()
+	}
 
 	db.elog.Finish()
 	if db.opt.InMemory {
@@ -530,6 +745,60 @@ func (db *DB) Sync() error {
 	return db.vlog.sync(math.MaxUint32)
 }
 
+// WaitForQuiescence brings the directory of the DB to a stable state, for a filesystem level
+// snapshot of it, without closing the DB. It flushes the memtable and waits for the flushes to
+// finish, waits for the running compactions and value log GC to finish, and syncs the value log.
+// The memtable flushes, compactions and value log GC are then paused until resume is called, so
+// no table is written or deleted meanwhile.
+//
+// The writes go on during WaitForQuiescence and until resume is called, but only append to the
+// value log, which is replayed from the state of the tables on a restart, so the snapshot is
+// consistent. The writes stall once the memtables are full, so resume must be called soon after
+// the snapshot is taken. The writes go on after WaitForQuiescence returns an error too, and resume
+// must be called in any case.
+func (db *DB) WaitForQuiescence() (resume func(), err error) {
+	if db.opt.InMemory || db.opt.ReadOnly {
+		return func() {}, nil
+	}
+	// Holding garbageCh rejects the value log GC, once the running one is done.
+	db.vlog.garbageCh <- struct{}{}
+	resume = func() { <-db.vlog.garbageCh }
+
+	atomic.StoreInt32(&db.flushRequested, 1)
+	req, err := db.sendToWriteCh(nil)
+	if err == nil {
+		err = req.Wait()
+	}
+	atomic.StoreInt32(&db.flushRequested, 0)
+	if err != nil {
+		return resume, errors.Wrap(err, "WaitForQuiescence")
+	}
+	for {
+		db.RLock()
+		flushed := len(db.imm) == 0
+		db.RUnlock()
+		if flushed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A flush of the memtables filled since is either finished, or not started, once flushPause
+	// is held.
+	db.flushPause.Lock()
+	db.stopCompactions()
+	vlogResume := resume
+	resume = func() {
+		db.startCompactions()
+		db.flushPause.Unlock()
+		vlogResume()
+	}
+	if err := db.vlog.sync(math.MaxUint32); err != nil {
+		return resume, errors.Wrap(err, "WaitForQuiescence")
+	}
+	return resume, nil
+}
+
 // getMemtables returns the current memtables and get references.
 func (db *DB) getMemTables() ([]*skl.Skiplist, func()) {
 	db.RLock()
@@ -603,6 +872,45 @@ func (db *DB) get(key []byte) (y.ValueStruct, error) {
 	return db.lc.get(key, maxVs, 0)
 }
 
+// getMulti is like get, for several keys which are sorted in increasing order.
+func (db *DB) getMulti(keys [][]byte) ([]y.ValueStruct, error) {
+	vals := make([]y.ValueStruct, len(keys))
+	done := make([]bool, len(keys))
+	for i, key := range keys {
+		if bytes.HasPrefix(key, badgerMove) {
+			// Move keys need to look at all the levels, just use get for them.
+			vs, err := db.get(key)
+			if err != nil {
+				return nil, err
+			}
+			vals[i], done[i] = vs, true
+		}
+	}
+
+	tables, decr := db.getMemTables() // Lock should be released.
+	defer decr()
+
+	y.NumGets.Add(int64(len(keys)))
+	for i, key := range keys {
+		if done[i] {
+			continue
+		}
+		for j := 0; j < len(tables); j++ {
+			vs := tables[j].Get(key)
+			y.NumMemtableGets.Add(1)
+			if vs.Meta == 0 && vs.Value == nil {
+				continue
+			}
+			vals[i], done[i] = vs, true
+			break
+		}
+	}
+	if err := db.lc.getMulti(keys, vals, done); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
 func (db *DB) updateHead(ptrs []valuePointer) {
 	var ptr valuePointer
 	for i := len(ptrs) - 1; i >= 0; i-- {
@@ -629,7 +937,8 @@ var requestPool = sync.Pool{
 }
 
 func (db *DB) shouldWriteValueToLSM(e Entry) bool {
-	return len(e.Value) < db.opt.ValueThreshold
+	// The manifests of the chunked values, replayed from the value log, stay there.
+	return e.meta&bitValuePointer == 0 && e.valueSize() < db.opt.ValueThreshold
 }
 
 func (db *DB) writeToLSM(b *request) error {
@@ -647,8 +956,8 @@ func (db *DB) writeToLSM(b *request) error {
 		if db.shouldWriteValueToLSM(*entry) { // Will include deletion / tombstone case.
 			db.mt.Put(entry.Key,
 				y.ValueStruct{
-					Value:     entry.Value,
-					Meta:      entry.meta,
+					Value:     entry.encodedValue(),
+					Meta:      entry.encodedMeta(),
 					UserMeta:  entry.UserMeta,
 					ExpiresAt: entry.ExpiresAt,
 				})
@@ -656,7 +965,7 @@ func (db *DB) writeToLSM(b *request) error {
 			db.mt.Put(entry.Key,
 				y.ValueStruct{
 					Value:     b.Ptrs[i].Encode(),
-					Meta:      entry.meta | bitValuePointer,
+					Meta:      entry.encodedMeta() | bitValuePointer,
 					UserMeta:  entry.UserMeta,
 					ExpiresAt: entry.ExpiresAt,
 				})
@@ -689,7 +998,9 @@ func (db *DB) writeRequests(reqs []*request) error {
 	db.elog.Printf("Writing to memtable")
 	var count int
 	for _, b := range reqs {
-		if len(b.Entries) == 0 {
+		// The empty requests sent by flushOldMemtables and WaitForQuiescence only make room, which
+		// rotates the memtable due for a flush.
+		if len(b.Entries) == 0 && !db.flushDue() {
 			continue
 		}
 		count += len(b.Entries)
@@ -714,7 +1025,13 @@ func (db *DB) writeRequests(reqs []*request) error {
 		}
 		db.updateHead(b.Ptrs)
 	}
-	done(nil)
+	db.updateDiskFull()
+	if db.groupCommit != nil {
+		// The requests are acknowledged once the value log is synced.
+		db.groupCommit.reqCh <- reqs
+	} else {
+		done(nil)
+	}
 	db.elog.Printf("%d entries written", count)
 	return nil
 }
@@ -751,7 +1068,7 @@ func (db *DB) doWrites(lc *y.Closer) {
 
 	writeRequests := func(reqs []*request) {
 		if err := db.writeRequests(reqs); err != nil {
-			db.opt.Errorf("writeRequests: %v", err)
+			db.opt.logger(LogComponentWrite).Errorf("writeRequests: %v", err)
 		}
 		<-pendingCh
 	}
@@ -858,13 +1175,17 @@ func (db *DB) ensureRoomForWrite() error {
 	// are inserted in Memtable. If we have done >= db.logRotates rotations, then while inserting
 	// first entry in Memtable, below condition will be true and we will endup flushing old value of
 	// db.head. Hence we are limiting no of value log files to be read to db.logRotates only.
-	forceFlush := atomic.LoadInt32(&db.logRotates) >= db.opt.LogRotatesToFlush
+	forceFlush := atomic.LoadInt32(&db.logRotates) >= db.opt.LogRotatesToFlush || db.flushDue()
 
 	if !forceFlush && db.mt.MemSize() < db.opt.MaxTableSize {
 		return nil
 	}
 
 	y.AssertTrue(db.mt != nil) // A nil mt indicates that DB is being closed.
+	// Past NumMemtables, flushChan only takes the extra memtables while level 0 is stalled.
+	if len(db.flushChan) >= db.opt.NumMemtables && !db.WriteStalled() {
+		return errNoRoom
+	}
 	select {
 	case db.flushChan <- flushTask{mt: db.mt, vptr: db.vhead}:
 		// After every memtable flush, let's reset the counter.
@@ -876,11 +1197,13 @@ func (db *DB) ensureRoomForWrite() error {
 			return err
 		}
 
-		db.opt.Debugf("Flushing memtable, mt.size=%d size of flushChan: %d\n",
+		db.opt.logger(LogComponentFlush).Debugf(
+			"Flushing memtable, mt.size=%d size of flushChan: %d\n",
 			db.mt.MemSize(), len(db.flushChan))
 		// We manage to push this task. Let's modify imm.
 		db.imm = append(db.imm, db.mt)
-		db.mt = skl.NewSkiplist(arenaSize(db.opt))
+		db.mt = db.newMemtable()
+		db.mtCreated = time.Now()
 		// New memtable is empty. We certainly have room.
 		return nil
 	default:
@@ -889,10 +1212,63 @@ func (db *DB) ensureRoomForWrite() error {
 	}
 }
 
+// memtableExpired returns true if the memtable holds some writes, and is older than
+// opt.MaxMemtableAge. The caller must hold db's lock, or be the goroutine writing to the memtable.
+func (db *DB) memtableExpired() bool {
+	return db.opt.MaxMemtableAge > 0 && !db.mt.Empty() &&
+		time.Since(db.mtCreated) >= db.opt.MaxMemtableAge
+}
+
+// flushDue returns true if the memtable has to be flushed before it's full, because it expired or
+// WaitForQuiescence asked for it.
+func (db *DB) flushDue() bool {
+	return db.memtableExpired() || atomic.LoadInt32(&db.flushRequested) == 1 && !db.mt.Empty()
+}
+
+// flushOldMemtables makes sure that the memtable is flushed once it's older than
+// opt.MaxMemtableAge, even if no more writes come in to trigger the rotation.
+func (db *DB) flushOldMemtables(lc *y.Closer) {
+	defer lc.Done()
+	// The age is checked four times per MaxMemtableAge, and at most every millisecond.
+	interval := db.opt.MaxMemtableAge / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lc.HasBeenClosed():
+			return
+		case <-ticker.C:
+		}
+		db.RLock()
+		expired := db.mt != nil && db.memtableExpired()
+		db.RUnlock()
+		if !expired {
+			continue
+		}
+		// The memtable is only rotated by the goroutine writing to it, an empty request gets it
+		// to check the age of the memtable.
+		req, err := db.sendToWriteCh(nil)
+		if err != nil {
+			continue // Writes are blocked by DropAll or Close.
+		}
+		if err := req.Wait(); err != nil {
+			db.opt.logger(LogComponentFlush).Warningf("While flushing an old memtable: %v", err)
+		}
+	}
+}
+
 func arenaSize(opt Options) int64 {
 	return opt.MaxTableSize + opt.maxBatchSize + opt.maxBatchCount*int64(skl.MaxNodeSize)
 }
 
+// newMemtable returns a new empty memtable, ordering the keys like the tables.
+func (db *DB) newMemtable() *skl.Skiplist {
+	return skl.NewSkiplistWithComparator(arenaSize(db.opt), db.cmp)
+}
+
 // buildL0Table builds a new table from the memtable.
 func buildL0Table(ft flushTask, bopts table.Options) []byte {
 	iter := ft.mt.NewIterator()
@@ -928,7 +1304,7 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 	}
 
 	// Store badger head even if vptr is zero, need it for readTs
-	db.opt.Debugf("Storing value log head: %+v\n", ft.vptr)
+	db.opt.logger(LogComponentFlush).Debugf("Storing value log head: %+v\n", ft.vptr)
 	db.elog.Printf("Storing offset: %+v\n", ft.vptr)
 	val := ft.vptr.Encode()
 
@@ -941,10 +1317,10 @@ func (db *DB) handleFlushTask(ft flushTask) error {
 	if err != nil {
 		return y.Wrapf(err, "failed to get datakey in db.handleFlushTask")
 	}
-	bopts := buildTableOptions(db.opt)
+	bopts := buildTableOptions(db)
+	bopts.BloomFalsePositive = db.opt.bloomFalsePositive(0)
+	bopts.Compression = db.opt.compression(0)
 	bopts.DataKey = dk
-	// Builder does not need cache but the same options are used for opening table.
-	bopts.Cache = db.blockCache
 	tableData := buildL0Table(ft, bopts)
 
 	fileID := db.lc.reserveFileID()
@@ -995,6 +1371,7 @@ func (db *DB) flushMemtable(lc *y.Closer) error {
 			// We close db.flushChan now, instead of sending a nil ft.mt.
 			continue
 		}
+		db.flushPause.RLock()
 		for {
 			err := db.handleFlushTask(ft)
 			if err == nil {
@@ -1013,9 +1390,11 @@ func (db *DB) flushMemtable(lc *y.Closer) error {
 				break
 			}
 			// Encountered error. Retry indefinitely.
-			db.opt.Errorf("Failure while flushing memtable to disk: %v. Retrying...\n", err)
+			db.opt.logger(LogComponentFlush).Errorf(
+				"Failure while flushing memtable to disk: %v. Retrying...\n", err)
 			time.Sleep(time.Second)
 		}
+		db.flushPause.RUnlock()
 	}
 	return nil
 }
@@ -1119,13 +1498,131 @@ func (db *DB) updateSize(lc *y.Closer) {
 // Note: Every time GC is run, it would produce a spike of activity on the LSM
 // tree.
 func (db *DB) RunValueLogGC(discardRatio float64) error {
+	_, err := db.runValueLogGC(discardRatio)
+	return err
+}
+
+// runValueLogGC is like RunValueLogGC, and also returns an estimate of the bytes it reclaimed.
+func (db *DB) runValueLogGC(discardRatio float64) (int64, error) {
+	if db.opt.InMemory {
+		return 0, ErrGCInMemoryMode
+	}
+	if discardRatio >= 1.0 || discardRatio <= 0.0 {
+		return 0, ErrInvalidRequest
+	}
+	head, err := db.gcHead()
+	if err != nil {
+		return 0, err
+	}
+	// Pick a log file and run GC
+	return db.vlog.runGC(discardRatio, head)
+}
+
+// ValueLogGCStats reports what value log GC would do with the given discardRatio, without running
+// it. It relies on the discard stats collected by the compactions, and doesn't read or change any
+// value log file, nor the discard stats. Note that RunValueLogGC samples a file before rewriting
+// it, so it may still skip a file reported here, or rewrite a file without discard stats.
+//
+// The discardRatio must be in the range (0.0, 1.0), like for RunValueLogGC.
+func (db *DB) ValueLogGCStats(discardRatio float64) (GCStats, error) {
 	if db.opt.InMemory {
-		return ErrGCInMemoryMode
+		return GCStats{}, ErrGCInMemoryMode
 	}
 	if discardRatio >= 1.0 || discardRatio <= 0.0 {
-		return ErrInvalidRequest
+		return GCStats{}, ErrInvalidRequest
+	}
+	head, err := db.gcHead()
+	if err != nil {
+		return GCStats{}, err
+	}
+	return db.vlog.gcStats(discardRatio, head), nil
+}
+
+// SpaceStats is returned by DB.SpaceStats.
+type SpaceStats struct {
+	// LSMSize and ValueLogSize are the sizes of the tables and of the value log files. They are
+	// exact, up to the writes in flight. TotalSize is their sum.
+	LSMSize      int64
+	ValueLogSize int64
+	TotalSize    int64
+
+	// LiveSize estimates the size the DB would have if only the latest live version of every key
+	// was kept. It is the total size minus the two reclaimable estimates below, so it inherits
+	// their bounds.
+	LiveSize int64
+	// SpaceAmplification is TotalSize divided by LiveSize, 1 if the DB is empty.
+	SpaceAmplification float64
+
+	// CompactionReclaimable estimates the bytes of the LSM tree taken by stale versions and
+	// tombstones, which compactions down to the last level would drop. It is computed from the
+	// key ranges of the tables: where the tables of several levels overlap, all but the one in
+	// the lowest level are deemed stale. It is thus an upper bound where the upper levels hold new
+	// keys rather than new versions of the keys below them, and a lower bound where the tables of
+	// the lowest levels still hold stale versions or tombstones themselves. It is zero if the key
+	// ranges of the tables don't overlap.
+	CompactionReclaimable int64
+	// ValueLogGCReclaimable estimates the bytes value log GC would reclaim by rewriting all the
+	// value log files, from the discard stats. These are only updated when compactions drop the
+	// versions pointing to the value log, so this is a lower bound, which lags behind the deletes
+	// and overwrites until they are compacted. Value log GC only rewrites the files whose discard
+	// ratio is high enough, see ValueLogGCStats.
+	ValueLogGCReclaimable int64
+
+	// Recommendation is the maintenance operation which would reclaim the most space, if it is
+	// at least 10% of TotalSize.
+	Recommendation SpaceRecommendation
+}
+
+// SpaceRecommendation is the maintenance operation recommended by DB.SpaceStats.
+type SpaceRecommendation int
+
+const (
+	// RecommendNothing means that neither compactions nor value log GC would reclaim much space.
+	RecommendNothing SpaceRecommendation = iota
+	// RecommendCompaction means that compacting the LSM tree, e.g. with DB.Flatten, would reclaim
+	// the most space.
+	RecommendCompaction
+	// RecommendValueLogGC means that running DB.RunValueLogGC would reclaim the most space.
+	RecommendValueLogGC
+)
+
+// spaceRecommendationRatio is the fraction of the total size an operation must reclaim to be
+// recommended.
+const spaceRecommendationRatio = 0.1
+
+// SpaceStats estimates how much space compactions and value log GC would reclaim, from the sizes
+// of the tables and of the value log files, the key ranges of the tables, and the discard stats of
+// the value log. It doesn't read any table or value log file. See SpaceStats for the accuracy of
+// each figure.
+func (db *DB) SpaceStats() SpaceStats {
+	s := SpaceStats{
+		LSMSize:            db.lc.diskSize(),
+		ValueLogSize:       db.vlog.diskSize(),
+		SpaceAmplification: 1,
+	}
+	s.TotalSize = s.LSMSize + s.ValueLogSize
+	s.CompactionReclaimable = s.LSMSize - db.lc.liveSize()
+	if !db.opt.InMemory {
+		s.ValueLogGCReclaimable = db.vlog.discardable()
+	}
+	s.LiveSize = s.TotalSize - s.CompactionReclaimable - s.ValueLogGCReclaimable
+	if s.LiveSize > 0 {
+		s.SpaceAmplification = float64(s.TotalSize) / float64(s.LiveSize)
 	}
 
+	min := int64(spaceRecommendationRatio * float64(s.TotalSize))
+	switch {
+	case s.ValueLogGCReclaimable >= s.CompactionReclaimable && s.ValueLogGCReclaimable > min:
+		s.Recommendation = RecommendValueLogGC
+	case s.CompactionReclaimable > min:
+		s.Recommendation = RecommendCompaction
+	}
+	return s
+}
+
+// gcHead returns the value log head stored on disk. Value log GC only considers the files
+// before it.
+func (db *DB) gcHead() (valuePointer, error) {
 	// startLevel is the level from which we should search for the head key. When badger is running
 	// with KeepL0InMemory flag, all tables on L0 are kept in memory. This means we should pick head
 	// key from Level 1 onwards because if we pick the headkey from Level 0 we might end up losing
@@ -1139,16 +1636,87 @@ func (db *DB) RunValueLogGC(discardRatio float64) error {
 	// Need to pass with timestamp, lsm get removes the last 8 bytes and compares key
 	val, err := db.lc.get(headKey, nil, startLevel)
 	if err != nil {
-		return errors.Wrap(err, "Retrieving head from on-disk LSM")
+		return valuePointer{}, errors.Wrap(err, "Retrieving head from on-disk LSM")
 	}
 
 	var head valuePointer
 	if len(val.Value) > 0 {
 		head.Decode(val.Value)
 	}
+	return head, nil
+}
 
-	// Pick a log file and run GC
-	return db.vlog.runGC(discardRatio, head)
+// runValueLogGCLoop runs the value log GC every opt.ValueLogGCInterval, until lc is closed.
+func (db *DB) runValueLogGCLoop(lc *y.Closer) {
+	defer lc.Done()
+
+	ticker := time.NewTicker(db.opt.ValueLogGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-lc.HasBeenClosed():
+			return
+		}
+		if db.lc.compactionsBusy() {
+			db.opt.logger(LogComponentVlog).Debugf(
+				"Skipping value log GC, compactions are busy")
+			continue
+		}
+		for i := 0; i < db.opt.ValueLogGCMaxFiles; i++ {
+			reclaimed, err := db.runValueLogGC(db.opt.ValueLogGCDiscardRatio)
+			if db.opt.ValueLogGCCallback != nil {
+				db.opt.ValueLogGCCallback(reclaimed, err)
+			}
+			if err == nil {
+				continue
+			}
+			if err != ErrNoRewrite && err != ErrRejected {
+				db.opt.logger(LogComponentVlog).Warningf(
+					"While running value log GC: %v", err)
+			}
+			break
+		}
+	}
+}
+
+// diskLowWatermark is the fraction of Options.MaxDiskSize under which the DB must shrink before
+// the commits are accepted again, once they have been rejected with ErrDiskFull.
+const diskLowWatermark = 0.9
+
+// updateDiskFull sets or clears db.diskFull, from the sizes of the tables and of the value log
+// files kept up to date in memory. It doesn't touch the file system, so it is cheap enough to be
+// called on every write.
+func (db *DB) updateDiskFull() bool {
+	if db.opt.MaxDiskSize <= 0 {
+		return false
+	}
+	size := db.lc.diskSize() + db.vlog.diskSize()
+	switch {
+	case size > db.opt.MaxDiskSize:
+		atomic.StoreInt32(&db.diskFull, 1)
+	case float64(size) < diskLowWatermark*float64(db.opt.MaxDiskSize):
+		atomic.StoreInt32(&db.diskFull, 0)
+	}
+	return atomic.LoadInt32(&db.diskFull) == 1
+}
+
+// isDiskFull tells if the commits must be rejected with ErrDiskFull. Once the DB is full, the
+// size is computed again on every call, so that the space reclaimed by compactions, value log GC
+// or DropPrefix lets the writes go through again.
+func (db *DB) isDiskFull() bool {
+	if atomic.LoadInt32(&db.diskFull) == 0 {
+		return false
+	}
+	return db.updateDiskFull()
+}
+
+// WriteStalled returns true while the writes are stalled, because level 0 has
+// Options.NumLevelZeroTablesStall tables and a memtable flush waits for the compactions to make
+// room. The commits then wait, or fail with ErrWriteStalled if Options.RejectOnStall is set. The
+// time spent stalled is reported by the badger_write_stall_milliseconds_total metric.
+func (db *DB) WriteStalled() bool {
+	return atomic.LoadInt32(&db.lc.l0Stalled) == 1
 }
 
 // Size returns the size of lsm and value log files in bytes. It can be used to decide how often to
@@ -1163,6 +1731,26 @@ func (db *DB) Size() (lsm, vlog int64) {
 	return
 }
 
+// ValueLogHead returns the position in the value log right after the last entry written to the
+// memtable: the value log file fid holds all the writes up to offset, and the files with a lower
+// id are complete. Files may still be rewritten or deleted by the value log GC. This is a point in
+// time value, the head moves as soon as new writes are made. It returns zeros for an in-memory DB,
+// or if nothing was written since the DB was opened on an empty directory.
+func (db *DB) ValueLogHead() (fid uint32, offset uint32) {
+	db.RLock()
+	defer db.RUnlock()
+	return db.vhead.Fid, db.vhead.Offset + db.vhead.Len
+}
+
+// DiscardWatermark returns the timestamp at or below which compactions may discard the versions
+// of the keys which are overwritten, deleted or expired. In managed mode, this is the timestamp
+// set with SetDiscardTs. Otherwise, it's the highest read timestamp such that all the
+// transactions reading at or below it are done. This is a point in time value, it can advance
+// as soon as transactions are discarded.
+func (db *DB) DiscardWatermark() uint64 {
+	return db.orc.discardAtOrBelow()
+}
+
 // Sequence represents a Badger sequence.
 type Sequence struct {
 	sync.Mutex
@@ -1171,6 +1759,18 @@ type Sequence struct {
 	next      uint64
 	leased    uint64
 	bandwidth uint64
+	opt       SequenceOptions
+	closed    bool
+}
+
+// SequenceOptions is used to configure a sequence created by DB.GetSequenceWithOptions.
+type SequenceOptions struct {
+	// Bandwidth sets the size of the lease, determining how many Next() requests can be served
+	// from memory.
+	Bandwidth uint64
+	// ReleaseOnClose releases the leased but unused integers when the sequence is closed, so that
+	// they are handed out by the next sequence created on the same key.
+	ReleaseOnClose bool
 }
 
 // Next would return the next integer in the sequence, updating the lease by running a transaction
@@ -1178,6 +1778,9 @@ type Sequence struct {
 func (seq *Sequence) Next() (uint64, error) {
 	seq.Lock()
 	defer seq.Unlock()
+	if seq.closed {
+		return 0, ErrSequenceClosed
+	}
 	if seq.next >= seq.leased {
 		if err := seq.updateLease(); err != nil {
 			return 0, err
@@ -1191,13 +1794,31 @@ func (seq *Sequence) Next() (uint64, error) {
 // Release the leased sequence to avoid wasted integers. This should be done right
 // before closing the associated DB. However it is valid to use the sequence after
 // it was released, causing a new lease with full bandwidth.
+//
+// If another sequence on the same key has leased integers since this sequence did, the stored
+// lease is left untouched, so that no integer is handed out twice.
 func (seq *Sequence) Release() error {
 	seq.Lock()
 	defer seq.Unlock()
+	return seq.release()
+}
+
+func (seq *Sequence) release() error {
+	if seq.next >= seq.leased {
+		// Nothing to release.
+		return nil
+	}
 	err := seq.db.Update(func(txn *Txn) error {
+		stored, err := seq.storedLease(txn)
+		if err != nil {
+			return err
+		}
+		if stored != seq.leased {
+			return nil
+		}
 		var buf [8]byte
 		binary.BigEndian.PutUint64(buf[:], seq.next)
-		return txn.SetEntry(NewEntry(seq.key, buf[:]))
+		return txn.SetEntry(seq.leaseEntry(buf[:]))
 	})
 	if err != nil {
 		return err
@@ -1206,28 +1827,77 @@ func (seq *Sequence) Release() error {
 	return nil
 }
 
+// Close closes the sequence, releasing the leased but unused integers if the sequence was created
+// with SequenceOptions.ReleaseOnClose. Next returns ErrSequenceClosed after the sequence is closed.
+func (seq *Sequence) Close() error {
+	seq.Lock()
+	defer seq.Unlock()
+	if seq.closed {
+		return nil
+	}
+	seq.closed = true
+	if seq.opt.ReleaseOnClose {
+		return seq.release()
+	}
+	return nil
+}
+
+// Reset sets the sequence to value, so that the next call to Next returns value. Any integer
+// leased by other sequences on the same key may be handed out again after a Reset.
+func (seq *Sequence) Reset(value uint64) error {
+	seq.Lock()
+	defer seq.Unlock()
+	if seq.closed {
+		return ErrSequenceClosed
+	}
+	err := seq.db.Update(func(txn *Txn) error {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], value)
+		return txn.SetEntry(seq.leaseEntry(buf[:]))
+	})
+	if err != nil {
+		return err
+	}
+	seq.next, seq.leased = value, value
+	return nil
+}
+
+// storedLease returns the lease stored in the DB, or zero if there is none.
+func (seq *Sequence) storedLease(txn *Txn) (uint64, error) {
+	item, err := txn.Get(seq.key)
+	if err == ErrKeyNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var num uint64
+	err = item.Value(func(v []byte) error {
+		num = binary.BigEndian.Uint64(v)
+		return nil
+	})
+	return num, err
+}
+
+// leaseEntry returns the entry storing the lease of the sequence. It never expires, the sequence
+// would hand out the numbers of the expired lease again otherwise.
+func (seq *Sequence) leaseEntry(lease []byte) *Entry {
+	e := NewEntry(seq.key, lease)
+	e.noTTL = true
+	return e
+}
+
 func (seq *Sequence) updateLease() error {
 	return seq.db.Update(func(txn *Txn) error {
-		item, err := txn.Get(seq.key)
-		if err == ErrKeyNotFound {
-			seq.next = 0
-		} else if err != nil {
+		num, err := seq.storedLease(txn)
+		if err != nil {
 			return err
-		} else {
-			var num uint64
-			if err := item.Value(func(v []byte) error {
-				num = binary.BigEndian.Uint64(v)
-				return nil
-			}); err != nil {
-				return err
-			}
-			seq.next = num
 		}
+		seq.next = num
 
 		lease := seq.next + seq.bandwidth
 		var buf [8]byte
 		binary.BigEndian.PutUint64(buf[:], lease)
-		if err = txn.SetEntry(NewEntry(seq.key, buf[:])); err != nil {
+		if err = txn.SetEntry(seq.leaseEntry(buf[:])); err != nil {
 			return err
 		}
 		seq.leased = lease
@@ -1242,6 +1912,13 @@ func (seq *Sequence) updateLease() error {
 //
 // GetSequence is not supported on ManagedDB. Calling this would result in a panic.
 func (db *DB) GetSequence(key []byte, bandwidth uint64) (*Sequence, error) {
+	return db.GetSequenceWithOptions(key, SequenceOptions{Bandwidth: bandwidth})
+}
+
+// GetSequenceWithOptions is like GetSequence, but the sequence is configured by opt.
+//
+// GetSequenceWithOptions is not supported on ManagedDB. Calling this would result in a panic.
+func (db *DB) GetSequenceWithOptions(key []byte, opt SequenceOptions) (*Sequence, error) {
 	if db.opt.managedTxns {
 		panic("Cannot use GetSequence with managedDB=true.")
 	}
@@ -1249,7 +1926,7 @@ func (db *DB) GetSequence(key []byte, bandwidth uint64) (*Sequence, error) {
 	switch {
 	case len(key) == 0:
 		return nil, ErrEmptyKey
-	case bandwidth == 0:
+	case opt.Bandwidth == 0:
 		return nil, ErrZeroBandwidth
 	}
 	seq := &Sequence{
@@ -1257,7 +1934,8 @@ func (db *DB) GetSequence(key []byte, bandwidth uint64) (*Sequence, error) {
 		key:       key,
 		next:      0,
 		leased:    0,
-		bandwidth: bandwidth,
+		bandwidth: opt.Bandwidth,
+		opt:       opt,
 	}
 	err := seq.updateLease()
 	return seq, err
@@ -1269,6 +1947,41 @@ func (db *DB) Tables(withKeysCount bool) []TableInfo {
 	return db.lc.getTableInfo(withKeysCount)
 }
 
+// LevelStats returns the statistics of every level of the LSM tree. It doesn't trigger any
+// compaction.
+func (db *DB) LevelStats() []LevelStat {
+	return db.lc.levelStats()
+}
+
+// EstimateKeyCount returns an estimate of the number of entries whose key has the given prefix.
+// Every version of a key is counted, including deletion markers, so this is an upper bound of the
+// number of keys a Txn would see. It doesn't iterate over the tables, and instead relies on the key
+// count stored in each table, weighted by how much of the table overlaps the prefix. The result is
+// approximate, but the memtables are counted exactly.
+//
+// Tables built by older versions of Badger don't store their key count, so they are counted
+// instead. The returned bool is true only if the estimate was derived from the table metadata.
+func (db *DB) EstimateKeyCount(prefix []byte) (uint64, bool, error) {
+	tables, decr := db.getMemTables()
+	defer decr()
+	var count uint64
+	for _, mt := range tables {
+		it := mt.NewUniIterator(false)
+		for it.Seek(y.KeyWithTs(prefix, math.MaxUint64)); it.Valid(); it.Next() {
+			if !bytes.HasPrefix(y.ParseKey(it.Key()), prefix) {
+				break
+			}
+			count++
+		}
+		it.Close()
+	}
+	lsm, fromIndex, err := db.lc.estimateKeyCount(prefix)
+	if err != nil {
+		return 0, false, err
+	}
+	return count + lsm, fromIndex, nil
+}
+
 // KeySplits can be used to get rough key ranges to divide up iteration over
 // the DB.
 func (db *DB) KeySplits(prefix []byte) []string {
@@ -1281,10 +1994,59 @@ func (db *DB) KeySplits(prefix []byte) []string {
 			splits = append(splits, string(ti.Right))
 		}
 	}
-	sort.Strings(splits)
+	sort.Slice(splits, func(i, j int) bool {
+		return db.cmp.CompareKeys([]byte(splits[i]), []byte(splits[j])) < 0
+	})
 	return splits
 }
 
+// SplitKeys returns up to n-1 sorted keys, without timestamp, which split the keys of the DB into
+// n ranges holding about the same amount of data on disk. A range starts at a split key, included,
+// and ends at the next one, excluded. The first range starts at the smallest key, and the last one
+// ends after the biggest key.
+//
+// The split keys are the first keys of blocks of the tables, picked from the table indexes, so
+// the data isn't read and the ranges are only as even as the blocks allow. The versions of a key
+// are all in the same range. The memtables, and the internal keys of Badger, are not taken into
+// account. Fewer keys are returned if there are not enough blocks.
+func (db *DB) SplitKeys(n int) ([][]byte, error) {
+	if n < 1 {
+		return nil, errors.Errorf("SplitKeys: the number of ranges must be positive, got %d", n)
+	}
+	blocks := db.lc.blocks()
+	var total int64
+	out := blocks[:0]
+	for _, b := range blocks {
+		if bytes.HasPrefix(b.Key, badgerPrefix) {
+			continue
+		}
+		total += int64(b.Len)
+		out = append(out, b)
+	}
+	blocks = out
+
+	var keys [][]byte
+	var size int64
+	for _, b := range blocks {
+		if len(keys) == n-1 {
+			break
+		}
+		// A new range starts at this block once the previous ones hold their share of the data.
+		if size >= total*int64(len(keys)+1)/int64(n) {
+			// The ranges must not be empty.
+			last := y.ParseKey(blocks[0].Key)
+			if len(keys) > 0 {
+				last = keys[len(keys)-1]
+			}
+			if key := y.ParseKey(b.Key); !bytes.Equal(last, key) {
+				keys = append(keys, y.Copy(key))
+			}
+		}
+		size += int64(b.Len)
+	}
+	return keys, nil
+}
+
 // MaxBatchCount returns max possible entries in batch
 func (db *DB) MaxBatchCount() int64 {
 	return db.opt.maxBatchCount
@@ -1303,10 +2065,24 @@ func (db *DB) stopMemoryFlush() {
 	}
 }
 
+// SetNumCompactors changes the number of compaction workers, initially set by
+// Options.NumCompactors, while the DB is running. New workers are started right away when n grows.
+// When n shrinks, the workers in excess finish the compaction they are running, if any, and stop
+// before picking the next one, so SetNumCompactors doesn't wait for them. Setting n to zero stops
+// the compactions, which could eventually cause writes to block forever.
+func (db *DB) SetNumCompactors(n int) error {
+	if n < 0 {
+		return errors.Errorf("SetNumCompactors: the number of compactors can't be negative, got %d",
+			n)
+	}
+	db.lc.setNumCompactors(n)
+	return nil
+}
+
 func (db *DB) stopCompactions() {
 	// Stop compactions.
 	if db.closers.compactors != nil {
-		db.closers.compactors.SignalAndWait()
+		db.lc.stopCompact(db.closers.compactors)
 	}
 }
 
@@ -1321,7 +2097,7 @@ func (db *DB) startCompactions() {
 func (db *DB) startMemoryFlush() {
 	// Start memory fluhser.
 	if db.closers.memtable != nil {
-		db.flushChan = make(chan flushTask, db.opt.NumMemtables)
+		db.flushChan = make(chan flushTask, db.opt.maxNumMemtables())
 		db.closers.memtable = y.NewCloser(1)
 		go func() {
 			_ = db.flushMemtable(db.closers.memtable)
@@ -1329,17 +2105,167 @@ func (db *DB) startMemoryFlush() {
 	}
 }
 
+// CompactRange compacts all the tables which hold keys in [start, end) down to the bottom level of
+// the LSM tree, the deepest level which has tables. An empty end means that there is no upper
+// bound. Compacting discards the deleted and expired keys, and the versions which are no longer
+// visible to any transaction, so this can be used to reclaim space after a DropPrefix or a bulk
+// delete, without waiting for the regular compactions to get to the range.
+//
+// CompactRange blocks until the compactions are done, or until ctx is done. The regular
+// compactions keep running, and tables which are already being compacted by them are waited for.
+// It returns true if any table was compacted.
+func (db *DB) CompactRange(ctx context.Context, start, end []byte) (bool, error) {
+	if len(end) > 0 && db.cmp.Compare(start, end) >= 0 {
+		return false, nil
+	}
+	return db.lc.compactRange(ctx, start, end, nil)
+}
+
+// RebuildBloomFilters rebuilds the bloom filters of the tables which were built with a false
+// positive probability other than the one configured for their level, by BloomFalsePositive and
+// LevelBloomFalsePositive. This includes the tables built by versions of Badger which didn't
+// record it. Unlike a compaction, the blocks of a table are copied as they are into the new table,
+// and only the index holding the bloom filter is rebuilt. The new tables replace the old ones in
+// the MANIFEST.
+//
+// The tables are rebuilt one at a time, throttled to about 64 MB per second, and RebuildBloomFilters
+// blocks until all of them are rebuilt. It can be run in a goroutine while the DB serves reads and
+// writes, and while compactions run: a table being compacted is waited for, and a table compacted
+// away gets a new bloom filter from the compaction. It must not be run concurrently with DropAll,
+// DropPrefix or Close, and returns ErrBlockedWrites if it notices one of them. Tables kept in
+// memory are skipped.
+func (db *DB) RebuildBloomFilters() error {
+	return db.replaceTables(func(level int, t *table.Table) bool {
+		return t.BloomFalsePositive() != db.opt.bloomFalsePositive(level)
+	}, func(lh *levelHandler, t *table.Table) (bool, error) {
+		return db.lc.rebuildBloomFilter(lh, t, db.opt.bloomFalsePositive(lh.level))
+	})
+}
+
+// EncryptTables rewrites the tables which aren't encrypted, encrypting them with the latest data
+// key. These are the tables written before EnableEncryption was called, which no compaction has
+// rewritten since. It runs like RebuildBloomFilters, and the same restrictions apply.
+func (db *DB) EncryptTables() error {
+	if !db.shouldEncrypt() {
+		return ErrNotEncrypted
+	}
+	return db.replaceTables(func(level int, t *table.Table) bool {
+		return t.KeyID() == 0
+	}, db.lc.encryptTable)
+}
+
+// replaceTables replaces the tables picked by pick, one at a time, by calling replace. It retries
+// the tables for which replace returns false, until all of them are replaced.
+func (db *DB) replaceTables(pick func(level int, t *table.Table) bool,
+	replace func(lh *levelHandler, t *table.Table) (bool, error)) error {
+	const rate = 64 << 20 // Bytes per second.
+	for {
+		var pending int
+		for _, lh := range db.lc.levels {
+			lh.RLock()
+			var tables []*table.Table
+			for _, t := range lh.tables {
+				if !t.IsInmemory && pick(lh.level, t) {
+					t.IncrRef()
+					tables = append(tables, t)
+				}
+			}
+			lh.RUnlock()
+
+			for i, t := range tables {
+				if atomic.LoadInt32(&db.blockWrites) == 1 {
+					_ = decrRefs(tables[i:])
+					return ErrBlockedWrites
+				}
+				start := time.Now()
+				done, err := replace(lh, t)
+				if decErr := t.DecrRef(); err == nil {
+					err = decErr
+				}
+				if err != nil {
+					_ = decrRefs(tables[i+1:])
+					return err
+				}
+				if !done {
+					pending++
+					continue
+				}
+				if wait := time.Duration(t.Size()) * time.Second / rate; wait > time.Since(start) {
+					time.Sleep(wait - time.Since(start))
+				}
+			}
+		}
+		if pending == 0 {
+			return nil
+		}
+		// Wait for the compactions of the pending tables to finish.
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// FlattenOptions is used to configure DB.FlattenWith.
+type FlattenOptions struct {
+	// Context, if set, interrupts the flatten once it's done. The compactions in progress are
+	// completed first, so the DB is left consistent, with the tables partly flattened.
+	Context context.Context
+	// Workers is the number of compactions run concurrently on a level. Defaults to 1.
+	Workers int
+	// TargetLevel is the level the tables are flattened into. If the tables are already on a
+	// deeper level, they are flattened into the deepest level holding tables instead.
+	// Defaults to 0, which flattens into the deepest level holding tables.
+	TargetLevel int
+	// Progress, if set, is called before the first compaction and after each round of
+	// compactions.
+	Progress func(FlattenProgress)
+}
+
+// FlattenProgress reports the progress of DB.FlattenWith.
+type FlattenProgress struct {
+	// TablesRemaining is the number of tables which are not yet on the level the tables are
+	// flattened into.
+	TablesRemaining int
+	// BytesWritten is the size of the tables built by the flatten so far.
+	BytesWritten int64
+}
+
 // Flatten can be used to force compactions on the LSM tree so all the tables fall on the same
 // level. This ensures that all the versions of keys are colocated and not split across multiple
 // levels, which is necessary after a restore from backup. During Flatten, live compactions are
 // stopped. Ideally, no writes are going on during Flatten. Otherwise, it would create competition
 // between flattening the tree and new tables being created at level zero.
+//
+// Flatten is a shorthand for FlattenWith with the given number of workers.
 func (db *DB) Flatten(workers int) error {
+	return db.FlattenWith(FlattenOptions{Workers: workers})
+}
+
+// FlattenWith flattens the LSM tree like Flatten, with the given options.
+//
+// Writes can go on during FlattenWith, but the tables flushed to level zero meanwhile have to be
+// flattened too, so a steady stream of writes can keep it running indefinitely. Since live
+// compactions are stopped, the writes stall once level zero holds NumLevelZeroTablesStall tables
+// and until the flatten compacts it. Set FlattenOptions.Context to bound the runtime.
+func (db *DB) FlattenWith(opt FlattenOptions) error {
+	ctx := opt.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	workers := opt.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	target := opt.TargetLevel
+	if target >= len(db.lc.levels) {
+		target = len(db.lc.levels) - 1
+	}
+
 	db.stopCompactions()
 	defer db.startCompactions()
 
+	var stats compactStats
 	compactAway := func(cp compactionPriority) error {
-		db.opt.Infof("Attempting to compact with %+v\n", cp)
+		db.opt.logger(LogComponentCompact).Infof("Attempting to compact with %+v\n", cp)
+		cp.stats = &stats
 		errCh := make(chan error, 1)
 		for i := 0; i < workers; i++ {
 			go func() {
@@ -1352,7 +2278,8 @@ func (db *DB) Flatten(workers int) error {
 			err := <-errCh
 			if err != nil {
 				rerr = err
-				db.opt.Warningf("While running doCompact with %+v. Error: %v\n", cp, err)
+				db.opt.logger(LogComponentCompact).Warningf(
+					"While running doCompact with %+v. Error: %v\n", cp, err)
 			} else {
 				success++
 			}
@@ -1361,7 +2288,8 @@ func (db *DB) Flatten(workers int) error {
 			return rerr
 		}
 		// We could do at least one successful compaction. So, we'll consider this a success.
-		db.opt.Infof("%d compactor(s) succeeded. One or more tables from level %d compacted.\n",
+		db.opt.logger(LogComponentCompact).Infof(
+			"%d compactor(s) succeeded. One or more tables from level %d compacted.\n",
 			success, cp.level)
 		return nil
 	}
@@ -1371,20 +2299,40 @@ func (db *DB) Flatten(workers int) error {
 	}
 
 	for {
-		db.opt.Infof("\n")
+		db.opt.logger(LogComponentCompact).Infof("\n")
 		var levels []int
 		for i, l := range db.lc.levels {
 			sz := l.getTotalSize()
-			db.opt.Infof("Level: %d. %8s Size. %8s Max.\n",
+			db.opt.logger(LogComponentCompact).Infof("Level: %d. %8s Size. %8s Max.\n",
 				i, hbytes(l.getTotalSize()), hbytes(l.maxTotalSize))
 			if sz > 0 {
 				levels = append(levels, i)
 			}
 		}
-		if len(levels) <= 1 {
+		if opt.Progress != nil {
+			dest := target
+			if len(levels) > 0 && levels[len(levels)-1] > dest {
+				dest = levels[len(levels)-1]
+			}
+			var remaining int
+			for _, l := range levels {
+				if l < dest {
+					remaining += db.lc.levels[l].numTables()
+				}
+			}
+			opt.Progress(FlattenProgress{
+				TablesRemaining: remaining,
+				BytesWritten:    atomic.LoadInt64(&stats.written),
+			})
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(levels) == 0 || (len(levels) == 1 && levels[0] >= target) {
 			prios := db.lc.pickCompactLevels()
 			if len(prios) == 0 || prios[0].score <= 1.0 {
-				db.opt.Infof("All tables consolidated into one level. Flattening done.\n")
+				db.opt.logger(LogComponentCompact).Infof(
+					"All tables consolidated into one level. Flattening done.\n")
 				return nil
 			}
 			if err := compactAway(prios[0]); err != nil {
@@ -1392,7 +2340,8 @@ func (db *DB) Flatten(workers int) error {
 			}
 			continue
 		}
-		// Create an artificial compaction priority, to ensure that we compact the level.
+		// Create an artificial compaction priority, to ensure that we compact the level. This also
+		// pushes a single level down, towards the target level.
 		cp := compactionPriority{level: levels[0], score: 1.71}
 		if err := compactAway(cp); err != nil {
 			return err
@@ -1406,7 +2355,7 @@ func (db *DB) blockWrite() {
 
 	// Make all pending writes finish. The following will also close writeCh.
 	db.closers.writes.SignalAndWait()
-	db.opt.Infof("Writes flushed. Stopping compactions now...")
+	db.opt.logger(LogComponentDrop).Infof("Writes flushed. Stopping compactions now...")
 }
 
 func (db *DB) unblockWrite() {
@@ -1432,11 +2381,11 @@ func (db *DB) prepareToDrop() func() {
 			reqs = append(reqs, r)
 		default:
 			if err := db.writeRequests(reqs); err != nil {
-				db.opt.Errorf("writeRequests: %v", err)
+				db.opt.logger(LogComponentWrite).Errorf("writeRequests: %v", err)
 			}
 			db.stopMemoryFlush()
 			return func() {
-				db.opt.Infof("Resuming writes")
+				db.opt.logger(LogComponentDrop).Infof("Resuming writes")
 				db.startMemoryFlush()
 				db.unblockWrite()
 			}
@@ -1465,7 +2414,7 @@ func (db *DB) DropAll() error {
 }
 
 func (db *DB) dropAll() (func(), error) {
-	db.opt.Infof("DropAll called. Blocking writes...")
+	db.opt.logger(LogComponentDrop).Infof("DropAll called. Blocking writes...")
 	f := db.prepareToDrop()
 	// prepareToDrop will stop all the incomming write and flushes any pending flush tasks.
 	// Before we drop, we'll stop the compaction because anyways all the datas are going to
@@ -1485,13 +2434,15 @@ func (db *DB) dropAll() (func(), error) {
 		mt.DecrRef()
 	}
 	db.imm = db.imm[:0]
-	db.mt = skl.NewSkiplist(arenaSize(db.opt)) // Set it up for future writes.
+	db.mt = db.newMemtable() // Set it up for future writes.
+	db.mtCreated = time.Now()
 
 	num, err := db.lc.dropTree()
 	if err != nil {
 		return resume, err
 	}
-	db.opt.Infof("Deleted %d SSTables. Now deleting value logs...\n", num)
+	db.opt.logger(LogComponentDrop).Infof(
+		"Deleted %d SSTables. Now deleting value logs...\n", num)
 
 	num, err = db.vlog.dropAll()
 	if err != nil {
@@ -1499,8 +2450,14 @@ func (db *DB) dropAll() (func(), error) {
 	}
 	db.vhead = valuePointer{} // Zero it out.
 	db.lc.nextFileID = 1
-	db.opt.Infof("Deleted %d value log files. DropAll done.\n", num)
-	db.blockCache.Clear()
+	db.opt.logger(LogComponentDrop).Infof("Deleted %d value log files. DropAll done.\n", num)
+	// The IDs of the dropped tables will be reused, make sure the new tables don't read their
+	// cached blocks and indices.
+	db.cacheID = newCacheID()
+	db.rangeDels.reset()
+	if cache, ok := db.blockCache.Cache.(*table.RistrettoCache); ok && db.opt.BlockCache == nil {
+		cache.Clear()
+	}
 	return resume, nil
 }
 
@@ -1515,7 +2472,41 @@ func (db *DB) dropAll() (func(), error) {
 // - Compact L0->L1, skipping over Kp.
 // - Compact rest of the levels, Li->Li, picking tables which have Kp.
 // - Resume memtable flushes, compactions and writes.
+//
+// The calls to DropPrefix are run one at a time, and wait for the tables of the prefixes dropped
+// by DropPrefixAsync to be rewritten.
 func (db *DB) DropPrefix(prefix []byte) error {
+	_, err := db.DropPrefixWithResult(prefix)
+	return err
+}
+
+// DropPrefixResult describes the tables rewritten to drop a prefix.
+type DropPrefixResult struct {
+	// TablesRewritten is the number of tables rewritten to drop the keys.
+	TablesRewritten int
+	// BytesReclaimed is the difference between the size of the tables rewritten and the size of
+	// the tables written in their place. It's an estimate of the space freed in the LSM tree,
+	// which doesn't account for the memtables, nor for the values in the value log, which are
+	// reclaimed by the value log GC.
+	BytesReclaimed int64
+}
+
+func (cs *compactStats) dropPrefixResult() DropPrefixResult {
+	res := DropPrefixResult{
+		TablesRewritten: int(atomic.LoadInt64(&cs.tables)),
+		BytesReclaimed:  atomic.LoadInt64(&cs.read) - atomic.LoadInt64(&cs.written),
+	}
+	if res.BytesReclaimed < 0 {
+		res.BytesReclaimed = 0
+	}
+	return res
+}
+
+// DropPrefixWithResult is like DropPrefix, and also returns the tables rewritten to drop the keys.
+func (db *DB) DropPrefixWithResult(prefix []byte) (DropPrefixResult, error) {
+	db.dropLock.Lock()
+	defer db.dropLock.Unlock()
+
 	f := db.prepareToDrop()
 	defer f()
 	// Block all foreign interactions with memory tables.
@@ -1534,26 +2525,141 @@ func (db *DB) DropPrefix(prefix []byte) error {
 			vptr:       db.vhead,
 			dropPrefix: prefix,
 		}
-		db.opt.Debugf("Flushing memtable")
+		db.opt.logger(LogComponentFlush).Debugf("Flushing memtable")
 		if err := db.handleFlushTask(task); err != nil {
-			db.opt.Errorf("While trying to flush memtable: %v", err)
-			return err
+			db.opt.logger(LogComponentFlush).Errorf(
+				"While trying to flush memtable: %v", err)
+			return DropPrefixResult{}, err
 		}
 		memtable.DecrRef()
 	}
 	db.stopCompactions()
 	defer db.startCompactions()
 	db.imm = db.imm[:0]
-	db.mt = skl.NewSkiplist(arenaSize(db.opt))
+	db.mt = db.newMemtable()
+	db.mtCreated = time.Now()
 
 	// Drop prefixes from the levels.
-	if err := db.lc.dropPrefix(prefix); err != nil {
-		return err
+	var stats compactStats
+	if err := db.lc.dropPrefix(prefix, &stats); err != nil {
+		return stats.dropPrefixResult(), err
+	}
+	db.opt.logger(LogComponentDrop).Infof("DropPrefix done")
+	return stats.dropPrefixResult(), nil
+}
+
+// DropPrefixHandle tracks a prefix drop started by DropPrefixAsync.
+type DropPrefixHandle struct {
+	done   chan struct{}
+	result DropPrefixResult
+	err    error
+}
+
+// Done returns a channel which is closed once the tables holding the prefix have been rewritten.
+// Polling it with a select tells whether the drop is complete without blocking.
+func (h *DropPrefixHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait blocks until the tables holding the prefix have been rewritten, and returns the tables
+// rewritten. It returns an error if the compactions failed, or were interrupted by DB.Close.
+func (h *DropPrefixHandle) Wait() (DropPrefixResult, error) {
+	<-h.done
+	return h.result, h.err
+}
+
+// prefixEnd returns the smallest key greater than all the keys with the given prefix, or nil if
+// there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := y.SafeCopy(nil, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
 	}
-	db.opt.Infof("DropPrefix done")
 	return nil
 }
 
+// DropPrefixAsync drops all the keys with the provided prefix without blocking writes. Instead of
+// rewriting the tables right away like DropPrefix, it commits a range tombstone covering the
+// prefix, like Txn.DeleteRange, and returns. From then on, reads don't see the keys, including
+// after a crash or a restart, since the tombstone is persisted like any other write. The keys are
+// then discarded in the background, by compacting the tables holding the prefix down to the bottom
+// level, like DB.CompactRange. The returned handle tells when this is done.
+//
+// The compactions start once the transactions which started before the drop, and can still see
+// the keys, are discarded. If the DB is closed before the compactions are done, the keys are
+// discarded by the regular compactions. Keys written after DropPrefixAsync returned are not
+// dropped. Unlike DropPrefix, the internal keys of Badger are never dropped.
+//
+// Several prefixes can be dropped concurrently. DropPrefix waits for the drops started with
+// DropPrefixAsync to complete. DropPrefixAsync cannot be used with managed transactions, and
+// returns ErrReadOnlyTxn if the DB was opened read-only.
+func (db *DB) DropPrefixAsync(prefix []byte) (*DropPrefixHandle, error) {
+	if db.opt.managedTxns {
+		return nil, ErrManagedTxn
+	}
+	prefix = y.SafeCopy(nil, prefix)
+	end := prefixEnd(prefix)
+	txn := db.NewTransaction(true)
+	defer txn.Discard()
+	if !txn.update {
+		return nil, ErrReadOnlyTxn
+	}
+	if err := txn.deleteRange(prefix, end); err != nil {
+		return nil, err
+	}
+	if err := txn.Commit(); err != nil {
+		return nil, err
+	}
+	commitTs := txn.rangeDels[0].version
+
+	h := &DropPrefixHandle{done: make(chan struct{})}
+	lc := db.closers.dropPrefix
+	lc.AddRunning(1)
+	go func() {
+		defer lc.Done()
+		defer close(h.done)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-lc.HasBeenClosed():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		// Wait for the read watermark to move past the tombstone, so that the compactions can
+		// discard the keys it covers. Reading moves it if no older transaction is running.
+		_ = db.View(func(txn *Txn) error { return nil })
+		if err := db.orc.readMark.WaitForMark(ctx, commitTs); err != nil {
+			h.err = err
+			return
+		}
+
+		db.dropLock.RLock()
+		defer db.dropLock.RUnlock()
+		var stats compactStats
+		t := rangeTombstone{start: prefix, end: end, version: commitTs, cmp: db.cmp}
+		for {
+			if _, h.err = db.lc.compactRange(ctx, prefix, end, &stats); h.err != nil {
+				break
+			}
+			// The compactions which started before the watermark moved, and the compactions of
+			// the other drops, can move keys of the prefix to tables compactRange didn't pick.
+			if !db.tablesHaveOlderVersions(t) {
+				break
+			}
+		}
+		h.result = stats.dropPrefixResult()
+		db.opt.logger(LogComponentDrop).Infof(
+			"DropPrefixAsync done for prefix %q: %+v", prefix, h.result)
+	}()
+	return h, nil
+}
+
 // KVList contains a list of key-value pairs.
 type KVList = pb.KVList
 
@@ -1563,6 +2669,8 @@ type KVList = pb.KVList
 // This function blocks until the given context is done or an error occurs.
 // The given function will be called with a new KVList containing the modified keys and the
 // corresponding values.
+// The range deletes of Txn.DeleteRange which overlap the prefixes are delivered as KVs whose key
+// holds the deleted range, see ParseDeleteRange.
 func (db *DB) Subscribe(ctx context.Context, cb func(kv *KVList), prefixes ...[]byte) error {
 	if cb == nil {
 		return ErrNilCallback
@@ -1572,9 +2680,155 @@ func (db *DB) Subscribe(ctx context.Context, cb func(kv *KVList), prefixes ...[]
 	}
 	c := y.NewCloser(1)
 	recvCh, id := db.pub.newSubscriber(c, prefixes...)
+	return db.listenForSubscriber(ctx, c, id, recvCh, 0, cb)
+}
+
+// Subscription is a handle to a subscription created by DB.NewSubscription.
+type Subscription struct {
+	id     uint64
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// NewSubscription is like Subscribe, but it doesn't block. The callback is run in a separate
+// goroutine until the returned Subscription is closed, the given context is done or the DB is
+// closed. Closing a subscription doesn't affect the other subscriptions of the DB.
+func (db *DB) NewSubscription(ctx context.Context, cb func(kv *KVList),
+	prefixes ...[]byte) (*Subscription, error) {
+	if cb == nil {
+		return nil, ErrNilCallback
+	}
+	if len(prefixes) == 0 {
+		return nil, ErrNoPrefixes
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c := y.NewCloser(1)
+	recvCh, id := db.pub.newSubscriber(c, prefixes...)
+	sub := &Subscription{id: id, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(sub.done)
+		sub.err = db.listenForSubscriber(ctx, c, id, recvCh, 0, cb)
+	}()
+	return sub, nil
+}
+
+// ID returns the ID of the subscription, as reported by DB.ActiveSubscriptions.
+func (s *Subscription) ID() uint64 {
+	return s.id
+}
+
+// Close unsubscribes and waits for the callback to return. Close must not be called from the
+// callback of the subscription. It returns the error which stopped the subscription before Close
+// was called, if any, like context.DeadlineExceeded.
+func (s *Subscription) Close() error {
+	s.cancel()
+	<-s.done
+	if s.err == context.Canceled {
+		return nil
+	}
+	return s.err
+}
+
+// ActiveSubscriptions returns the subscriptions of the DB, created by Subscribe, SubscribeFrom or
+// NewSubscription, which haven't stopped yet.
+func (db *DB) ActiveSubscriptions() []SubscriptionInfo {
+	return db.pub.activeSubscriptions()
+}
+
+// SubscribeFrom is like Subscribe, but it first replays the committed changes to the keys with the
+// given prefixes which have a version greater than sinceVersion, and then continues with the live
+// changes. The versions of a key are delivered in increasing order, and a live change is never
+// delivered before the replayed changes of the same key. The replay reads a snapshot of the DB,
+// and only the changes committed after it are delivered live, so no change is delivered twice.
+//
+// Only the versions still present in the DB can be replayed. Compactions discard the versions of
+// a key beyond NumVersionsToKeep (or the limit set by SetVersionRetention), as well as deleted and
+// expired keys, once they are older than the discard timestamp. So, if the subscriber has been
+// away for long enough, some of the intermediate versions of a key may be missing from the replay.
+// The latest version of each key is always replayed, unless the key is deleted and compacted away.
+//
+// In managed mode, the replay reads at math.MaxUint64 and live changes are filtered by
+// sinceVersion only, so a change committed concurrently with the replay may be delivered twice.
+func (db *DB) SubscribeFrom(ctx context.Context, sinceVersion uint64, cb func(kv *KVList),
+	prefixes ...[]byte) error {
+	if cb == nil {
+		return ErrNilCallback
+	}
+	if len(prefixes) == 0 {
+		return ErrNoPrefixes
+	}
+	c := y.NewCloser(1)
+	// The subscriber must be registered before the replay snapshot is taken, so that all the
+	// changes committed after the snapshot are delivered live.
+	recvCh, id := db.pub.newSubscriber(c, prefixes...)
+	var txn *Txn
+	if db.opt.managedTxns {
+		txn = db.NewTransactionAt(math.MaxUint64, false)
+	} else {
+		txn = db.NewTransaction(false)
+	}
+	minVersion := txn.readTs
+	if db.opt.managedTxns {
+		minVersion = sinceVersion
+	}
+
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	replayCh := make(chan *pb.KVList, 16)
+	errCh := make(chan error, 1)
+	go func() {
+		defer txn.Discard()
+		errCh <- db.replayUpdates(rctx, txn, sinceVersion, prefixes, replayCh)
+		close(replayCh)
+	}()
+	waitReplay := func() {
+		cancel()
+		for range replayCh {
+		}
+	}
+
+	// Live changes are buffered until the replay is done.
+	pending := new(pb.KVList)
+	for replaying := true; replaying; {
+		select {
+		case <-c.HasBeenClosed():
+			waitReplay()
+			c.Done()
+			return nil
+		case <-ctx.Done():
+			waitReplay()
+			c.Done()
+			db.pub.deleteSubscriber(id)
+			return ctx.Err()
+		case kvs := <-recvCh:
+			pending.Kv = append(pending.Kv, kvs.Kv...)
+		case kvs, ok := <-replayCh:
+			if ok {
+				cb(kvs)
+				continue
+			}
+			if err := <-errCh; err != nil {
+				c.Done()
+				db.pub.deleteSubscriber(id)
+				return err
+			}
+			replaying = false
+		}
+	}
+	if pending = filterVersions(pending, minVersion); len(pending.Kv) > 0 {
+		cb(pending)
+	}
+	return db.listenForSubscriber(ctx, c, id, recvCh, minVersion, cb)
+}
+
+// listenForSubscriber calls cb with the changes received on recvCh, skipping the ones with a
+// version less than or equal to minVersion, until ctx is done or the DB is closed.
+func (db *DB) listenForSubscriber(ctx context.Context, c *y.Closer, id uint64,
+	recvCh <-chan *pb.KVList, minVersion uint64, cb func(kv *KVList)) error {
 	slurp := func(batch *pb.KVList) {
 		defer func() {
-			if len(batch.GetKv()) > 0 {
+			if batch = filterVersions(batch, minVersion); len(batch.GetKv()) > 0 {
 				cb(batch)
 			}
 		}()
@@ -1607,9 +2861,152 @@ func (db *DB) Subscribe(ctx context.Context, cb func(kv *KVList), prefixes ...[]
 	}
 }
 
+// filterVersions removes the key-value pairs with a version less than or equal to minVersion
+// from list.
+func filterVersions(list *pb.KVList, minVersion uint64) *pb.KVList {
+	if minVersion == 0 {
+		return list
+	}
+	kvs := list.Kv[:0]
+	for _, kv := range list.Kv {
+		if kv.Version > minVersion {
+			kvs = append(kvs, kv)
+		}
+	}
+	list.Kv = kvs
+	return list
+}
+
+// replayUpdates sends the versions greater than sinceVersion of the keys with the given prefixes,
+// as read by txn, on replayCh, along with the range tombstones overlapping the prefixes. The
+// versions of each key are sent in increasing order.
+func (db *DB) replayUpdates(ctx context.Context, txn *Txn, sinceVersion uint64,
+	prefixes [][]byte, replayCh chan<- *pb.KVList) error {
+	send := func(list *pb.KVList) error {
+		select {
+		case replayCh <- list:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// The range tombstones are sent first, as they only delete the versions older than them.
+	list := new(pb.KVList)
+	for _, t := range db.rangeDels.overlapping(nil, nil, 0, txn.readTs) {
+		if t.version <= sinceVersion {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if _, ok := t.clip(prefix, prefixEnd(prefix)); ok {
+				list.Kv = append(list.Kv, t.kv())
+				break
+			}
+		}
+	}
+	for i, prefix := range prefixes {
+		// Skip the prefixes covered by another prefix, so that no key is replayed twice.
+		covered := false
+		for j, other := range prefixes {
+			if bytes.HasPrefix(prefix, other) && (len(other) < len(prefix) || j < i) {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+
+		iopt := DefaultIteratorOptions
+		iopt.AllVersions = true
+		iopt.Prefix = prefix
+		itr := txn.NewIterator(iopt)
+		for itr.Rewind(); itr.Valid(); {
+			key := itr.Item().KeyCopy(nil)
+			// The iterator returns the versions of a key newest first.
+			var versions []*pb.KV
+			for ; itr.Valid() && bytes.Equal(itr.Item().Key(), key); itr.Next() {
+				item := itr.Item()
+				if item.Version() <= sinceVersion {
+					continue
+				}
+				kv := &pb.KV{
+					Key:       key,
+					Meta:      []byte{item.UserMeta()},
+					ExpiresAt: item.ExpiresAt(),
+					Version:   item.Version(),
+				}
+				if item.meta&bitDelete == 0 {
+					var err error
+					if kv.Value, err = item.ValueCopy(nil); err != nil {
+						itr.Close()
+						return err
+					}
+				}
+				versions = append(versions, kv)
+			}
+			for j := len(versions) - 1; j >= 0; j-- {
+				list.Kv = append(list.Kv, versions[j])
+			}
+			if len(list.Kv) >= 1000 {
+				if err := send(list); err != nil {
+					itr.Close()
+					return err
+				}
+				list = new(pb.KVList)
+			}
+		}
+		itr.Close()
+	}
+	if len(list.Kv) > 0 {
+		return send(list)
+	}
+	return nil
+}
+
+// RotateEncryptionKey re-encrypts the data keys of the DB, which encrypt the tables and the value
+// log files, with newKey, without rewriting the tables or the value log files. The key registry
+// is written to a new file, synced and renamed over the old one, so a crash during the rotation
+// leaves the DB openable with either the old or the new key. Once it returns, the DB must be
+// opened with newKey as EncryptionKey. It returns ErrNotEncrypted if the DB isn't encrypted, which
+// EnableEncryption does.
+func (db *DB) RotateEncryptionKey(newKey []byte) error {
+	if db.opt.ReadOnly {
+		return errors.New("Cannot rotate the encryption key of a DB opened in read-only mode")
+	}
+	if !db.shouldEncrypt() {
+		return ErrNotEncrypted
+	}
+	return db.registry.rotate(newKey)
+}
+
+// EnableEncryption encrypts the DB, which was opened without an EncryptionKey, with key. It
+// generates a data key encrypted with key, and from then on the value log and the new tables are
+// encrypted: the value log file being written is replaced by an encrypted one, and the tables
+// built by flushes and compactions are encrypted. Once it returns, the DB must be opened with key
+// as EncryptionKey.
+//
+// The existing data isn't rewritten: the value log files and the tables written before remain in
+// plain text, which the MANIFEST records with a zero key ID, and are read as such.
+// The tables get encrypted as compactions rewrite them, or by calling EncryptTables to rewrite
+// them all at once; TableInfo.KeyID tells which tables remain in plain text. Until then, and
+// until the plain text value log files are garbage collected, some of the data is stored in plain
+// text on disk.
+//
+// It returns ErrEncrypted if the DB is already encrypted; RotateEncryptionKey changes its key.
+func (db *DB) EnableEncryption(key []byte) error {
+	if db.opt.ReadOnly {
+		return errors.New("Cannot enable encryption of a DB opened in read-only mode")
+	}
+	if db.shouldEncrypt() {
+		return ErrEncrypted
+	}
+	return db.registry.rotate(key)
+}
+
 // shouldEncrypt returns bool, which tells whether to encrypt or not.
 func (db *DB) shouldEncrypt() bool {
-	return len(db.opt.EncryptionKey) > 0
+	return db.registry.encrypted()
 }
 
 func (db *DB) syncDir(dir string) error {
@@ -28,6 +28,7 @@ import (
 	"path"
 	"regexp"
 	"runtime"
+	"sync"
 	"testing"
 
 	"github.com/dgraph-io/badger/v2/options"
@@ -530,7 +531,7 @@ func addToManifest(t *testing.T, db *DB, tab *table.Table, level uint32) {
 // createTableWithRange function is used in TestCompactionFilePicking. It creates
 // a table with key starting from start and ending with end.
 func createTableWithRange(t *testing.T, db *DB, start, end int) *table.Table {
-	bopts := buildTableOptions(db.opt)
+	bopts := buildTableOptions(db)
 	b := table.NewTableBuilder(bopts)
 	nums := []int{start, end}
 	for _, i := range nums {
@@ -761,3 +762,41 @@ func TestWindowsDataLoss(t *testing.T) {
 	}
 	require.ElementsMatch(t, keyList, result)
 }
+
+func TestOnCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	var mu sync.Mutex
+	var events []CompactionEvent
+	opt := getTestOptions(dir).WithOnCompaction(func(ev CompactionEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	})
+	db, err := Open(opt)
+	require.NoError(t, err)
+	// Overwrite the keys so that the compaction discards the values of the first versions.
+	val := make([]byte, 100)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 10; j++ {
+			txnSet(t, db, []byte(fmt.Sprintf("key%d", j)), val, 0)
+		}
+	}
+	// Closing the DB flushes the memtable and compacts L0, and delivers the queued events.
+	require.NoError(t, db.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, events, 1)
+	ev := events[0]
+	require.Equal(t, 0, ev.FromLevel)
+	require.Equal(t, 1, ev.ToLevel)
+	require.Equal(t, 1, ev.InputTables)
+	require.Equal(t, 1, ev.OutputTables)
+	require.True(t, ev.InputBytes > ev.OutputBytes)
+	// The discarded entries of the value log also hold their key and header.
+	require.True(t, ev.DiscardedBytes > int64(10*len(val)))
+	require.True(t, ev.Duration > 0)
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"expvar"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -32,14 +33,19 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/require"
 
 	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/promexport"
 	"github.com/dgraph-io/badger/v2/skl"
+	"github.com/dgraph-io/badger/v2/table"
 	"github.com/dgraph-io/badger/v2/y"
 )
 
@@ -1464,6 +1470,82 @@ func TestSequence_Release(t *testing.T) {
 	})
 }
 
+func TestSequence_ReleaseOnClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	key := []byte("key")
+	opt := SequenceOptions{Bandwidth: 1000, ReleaseOnClose: true}
+	for round := 0; round < 3; round++ {
+		db, err := Open(getTestOptions(dir))
+		require.NoError(t, err)
+		seq, err := db.GetSequenceWithOptions(key, opt)
+		require.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			num, err := seq.Next()
+			require.NoError(t, err)
+			// No integers are lost over the restarts.
+			require.Equal(t, uint64(round*5+i), num)
+		}
+		require.NoError(t, seq.Close())
+		_, err = seq.Next()
+		require.Equal(t, ErrSequenceClosed, err)
+		require.NoError(t, db.Close())
+	}
+
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		seq, err := db.GetSequenceWithOptions(key, opt)
+		require.NoError(t, err)
+		num, err := seq.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), num)
+
+		// Another sequence leases after this one, so closing this one mustn't release its lease.
+		other, err := db.GetSequence(key, 10)
+		require.NoError(t, err)
+		num, err = other.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(1000), num)
+		require.NoError(t, seq.Close())
+		require.NoError(t, other.Release())
+
+		seq, err = db.GetSequence(key, 10)
+		require.NoError(t, err)
+		num, err = seq.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(1001), num)
+	})
+}
+
+func TestSequence_Reset(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		seq, err := db.GetSequence(key, 100)
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			_, err := seq.Next()
+			require.NoError(t, err)
+		}
+		require.NoError(t, seq.Reset(500))
+		num, err := seq.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(500), num)
+
+		// The reset value is stored, so a new sequence continues after the lease taken by Next.
+		seq2, err := db.GetSequence(key, 100)
+		require.NoError(t, err)
+		num, err = seq2.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(600), num)
+
+		require.NoError(t, seq.Reset(0))
+		num, err = seq.Next()
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), num)
+	})
+}
+
 func TestReadOnly(t *testing.T) {
 	dir, err := ioutil.TempDir("", "badger-test")
 	require.NoError(t, err)
@@ -1534,6 +1616,52 @@ func TestReadOnly(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestReadOnlySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	// Write the tables of level 0 to disk, so that they are part of the snapshot.
+	opts := getTestOptions(dir).WithKeepL0InMemory(false)
+
+	db, err := Open(opts)
+	require.NoError(t, err)
+	defer db.Close()
+	val := func(i int) []byte {
+		// Half of the values are stored in the value log.
+		return []byte(fmt.Sprintf("%0*d", 10+(i%2)*2*opts.ValueThreshold, i))
+	}
+	const n = 5000
+	for i := 0; i < n; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%05d", i)), val(i), 0x00)
+	}
+
+	// The DB can be opened while it's open read-write.
+	snap, err := Open(opts.WithReadOnlySnapshot(true))
+	require.NoError(t, err)
+	defer snap.Close()
+	// Writes after Open are not visible.
+	txnSet(t, db, []byte("later"), []byte("later"), 0x00)
+	var count int
+	require.NoError(t, snap.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("later"))
+		require.Equal(t, ErrKeyNotFound, err)
+
+		itr := txn.NewIterator(DefaultIteratorOptions)
+		defer itr.Close()
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			// The keys flushed before Open are visible, in a consistent snapshot.
+			require.Equal(t, fmt.Sprintf("key%05d", count), string(itr.Item().Key()))
+			require.Equal(t, val(count), getItemValue(t, itr.Item()))
+			count++
+		}
+		return nil
+	}))
+	require.True(t, count > 0 && count <= n, "count=%d", count)
+	require.Equal(t, ErrReadOnlyTxn, snap.Update(func(txn *Txn) error {
+		return txn.Set([]byte("key"), []byte("value"))
+	}))
+}
+
 func TestLSMOnly(t *testing.T) {
 	dir, err := ioutil.TempDir("", "badger-test")
 	require.NoError(t, err)
@@ -1778,6 +1906,199 @@ func ExampleTxn_NewIterator() {
 	// Counted 1000 elements
 }
 
+func ExampleNewCache() {
+	// A single cache of 256MB holds the blocks and the indices of the tables of both DBs.
+	cache, err := NewCache(256 << 20)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cache.Close()
+
+	for _, name := range []string{"first", "second"} {
+		dir, err := ioutil.TempDir("", "badger-test")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer removeDir(dir)
+
+		opt := DefaultOptions(dir).WithBlockCache(cache).WithIndexCache(cache)
+		db, err := Open(opt)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+
+		err = db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("name"), []byte(name))
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("name"))
+			if err != nil {
+				return err
+			}
+			return item.Value(func(val []byte) error {
+				fmt.Printf("%s\n", val)
+				return nil
+			})
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Output:
+	// first
+	// second
+}
+
+func TestSharedCache(t *testing.T) {
+	cache := newTestCache()
+	open := func(dir string) *DB {
+		opt := getTestOptions(dir).WithBlockCache(cache).WithIndexCache(cache)
+		db, err := Open(opt)
+		require.NoError(t, err)
+		return db
+	}
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		dirs = append(dirs, dir)
+
+		// Both DBs write the same keys to tables with the same IDs.
+		db := open(dir)
+		txnSet(t, db, []byte("key"), []byte(fmt.Sprintf("value%d", i)), 0)
+		require.NoError(t, db.Close())
+	}
+
+	for round := 0; round < 2; round++ {
+		for i, dir := range dirs {
+			db := open(dir)
+			require.Zero(t, db.CacheMetrics().BlockCache.MaxCost)
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get([]byte("key"))
+				require.NoError(t, err)
+				val, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, fmt.Sprintf("value%d", i), string(val))
+				return nil
+			}))
+			require.NoError(t, db.Close())
+		}
+	}
+	require.False(t, cache.closed)
+}
+
+func TestCacheMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+	txnSet(t, db, []byte("key"), []byte("value"), 0)
+	require.NoError(t, db.Close())
+
+	get := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("key"))
+			return err
+		}))
+	}
+
+	// The default block cache reports its capacity, and there is no index cache.
+	db, err = Open(getTestOptions(dir))
+	require.NoError(t, err)
+	get(db)
+	m := db.CacheMetrics()
+	require.True(t, m.BlockCache.MaxCost > 0)
+	require.True(t, m.BlockCache.Misses > 0)
+	require.Zero(t, m.IndexCache)
+	require.NoError(t, db.Close())
+
+	// Hits and misses are counted for the caches which don't track them.
+	blockCache, indexCache := newTestCache(), newTestCache()
+	db, err = Open(getTestOptions(dir).WithBlockCache(blockCache).WithIndexCache(indexCache))
+	require.NoError(t, err)
+	defer db.Close()
+	get(db)
+	before := db.CacheMetrics()
+	get(db)
+	m = db.CacheMetrics()
+	// The second lookup only reads cached blocks and indices.
+	require.True(t, m.BlockCache.Hits > before.BlockCache.Hits)
+	require.Equal(t, before.BlockCache.Misses, m.BlockCache.Misses)
+	require.True(t, m.IndexCache.Hits > before.IndexCache.Hits)
+	require.Equal(t, before.IndexCache.Misses, m.IndexCache.Misses)
+	require.Zero(t, m.BlockCache.MaxCost)
+}
+
+func TestMetricsRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "badger-test")
+		require.NoError(t, err)
+		defer removeDir(dir)
+		// The metrics can be registered by several DBs.
+		opt := getTestOptions(dir).WithMetricsRegisterer(promexport.Registerer{Registerer: reg})
+		db, err := Open(opt)
+		require.NoError(t, err)
+		defer db.Close()
+		txnSet(t, db, []byte("key"), []byte("value"), 0)
+		db.calculateSize()
+	}
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	metrics := make(map[string]*dto.MetricFamily)
+	for _, f := range families {
+		metrics[f.GetName()] = f
+	}
+	require.Equal(t, dto.MetricType_COUNTER, metrics["badger_puts_total"].GetType())
+	require.True(t, metrics["badger_puts_total"].Metric[0].GetCounter().GetValue() >= 2)
+	require.Equal(t, dto.MetricType_GAUGE, metrics["badger_lsm_size_bytes"].GetType())
+	require.True(t, len(metrics["badger_lsm_size_bytes"].Metric) >= 2)
+	require.Equal(t, "dir", metrics["badger_lsm_size_bytes"].Metric[0].Label[0].GetName())
+}
+
+// testCache is a table.Cache which admits every value, to make the cache tests deterministic.
+type testCache struct {
+	sync.Mutex
+	m      map[string]interface{}
+	closed bool
+}
+
+func newTestCache() *testCache { return &testCache{m: make(map[string]interface{})} }
+
+func (c *testCache) Get(key []byte) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.m[string(key)]
+	return v, ok
+}
+
+func (c *testCache) Set(key []byte, value interface{}, cost int64) bool {
+	c.Lock()
+	defer c.Unlock()
+	c.m[string(key)] = value
+	return true
+}
+
+func (c *testCache) Del(key []byte) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.m, string(key))
+}
+
+func (c *testCache) Close() {
+	c.Lock()
+	defer c.Unlock()
+	c.closed = true
+}
+
 func TestSyncForRace(t *testing.T) {
 	dir, err := ioutil.TempDir("", "badger-test")
 	require.NoError(t, err)
@@ -1952,6 +2273,143 @@ func TestVerifyChecksum(t *testing.T) {
 	})
 }
 
+func TestEstimateKeyCount(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		counts := map[string]int{"tenant-a/": 3000, "tenant-b/": 1500, "tenant-c/": 500}
+		val := make([]byte, 64)
+		for prefix, n := range counts {
+			for i := 0; i < n; i += 20 {
+				txn := db.NewTransaction(true)
+				for j := i; j < i+20; j++ {
+					key := []byte(fmt.Sprintf("%s%05d", prefix, j))
+					require.NoError(t, txn.SetEntry(NewEntry(key, val)))
+				}
+				require.NoError(t, txn.Commit())
+			}
+		}
+		counts["tenant-"] = 5000
+		counts["tenant-d/"] = 0
+		for prefix, n := range counts {
+			count, ok, err := db.EstimateKeyCount([]byte(prefix))
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.InEpsilon(t, n+1, count+1, 0.1, "prefix %q: %d", prefix, count)
+		}
+	})
+}
+
+func TestLevelStats(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		val := make([]byte, 128)
+		for i := 0; i < 5000; i += 20 {
+			txn := db.NewTransaction(true)
+			for j := i; j < i+20; j++ {
+				require.NoError(t, txn.SetEntry(NewEntry([]byte(fmt.Sprintf("key%05d", j)), val)))
+			}
+			require.NoError(t, txn.Commit())
+		}
+
+		stats := db.LevelStats()
+		require.Len(t, stats, db.opt.MaxLevels)
+		tables := db.Tables(false)
+		var numTables int
+		for i, stat := range stats {
+			require.Equal(t, i, stat.Level)
+			numTables += stat.NumTables
+			if stat.NumTables == 0 {
+				require.Nil(t, stat.Smallest)
+				require.Zero(t, stat.Size)
+				continue
+			}
+			require.True(t, stat.Size > 0)
+			require.True(t, bytes.Compare(stat.Smallest, stat.Biggest) <= 0)
+			for _, ti := range tables {
+				if ti.Level == i {
+					require.True(t, bytes.Compare(stat.Smallest, y.ParseKey(ti.Left)) <= 0)
+					require.True(t, bytes.Compare(stat.Biggest, y.ParseKey(ti.Right)) >= 0)
+				}
+			}
+			require.True(t, stat.Score > 0)
+		}
+		require.True(t, numTables > 0)
+		require.Zero(t, stats[len(stats)-1].OverlapRatio)
+	})
+}
+
+func TestCompactRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%05d", i))
+	}
+	val := make([]byte, 128)
+	for i := 0; i < 3000; i += 20 {
+		txn := db.NewTransaction(true)
+		for j := i; j < i+20; j++ {
+			require.NoError(t, txn.SetEntry(NewEntry(key(j), val)))
+		}
+		require.NoError(t, txn.Commit())
+	}
+	for i := 0; i < 1500; i += 20 {
+		txn := db.NewTransaction(true)
+		for j := i; j < i+20; j++ {
+			require.NoError(t, txn.Delete(key(j)))
+		}
+		require.NoError(t, txn.Commit())
+	}
+	// Reopen the DB to get the deletes out of the memtable.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// countVersions counts the versions, including the delete markers, below key(1500).
+	countVersions := func() int {
+		var count int
+		require.NoError(t, db.View(func(txn *Txn) error {
+			iopt := DefaultIteratorOptions
+			iopt.AllVersions = true
+			iopt.Bound = key(1500)
+			it := txn.NewIterator(iopt)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				count++
+			}
+			return nil
+		}))
+		return count
+	}
+	require.True(t, countVersions() > 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.CompactRange(ctx, key(0), key(1500))
+	require.Equal(t, context.Canceled, err)
+
+	done, err := db.CompactRange(context.Background(), key(0), key(1500))
+	require.NoError(t, err)
+	require.True(t, done)
+	require.Zero(t, countVersions())
+
+	done, err = db.CompactRange(context.Background(), []byte("zzz"), nil)
+	require.NoError(t, err)
+	require.False(t, done)
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 1500; i < 3000; i++ {
+			if _, err := txn.Get(key(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
 func TestMain(m *testing.M) {
 	// call flag.Parse() here if TestMain uses flags
 	go func() {
@@ -2057,3 +2515,1444 @@ func TestWriteInemory(t *testing.T) {
 	})
 	require.NoError(t, err)
 }
+
+func TestVersionRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.NumVersionsToKeep = 3
+	opt.KeepL0InMemory = false
+	opt.CompactL0OnClose = false
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	keys := []string{"event/1", "event/2", "config/1", "config/a/1", "other/1"}
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for _, key := range keys {
+				if err := txn.Set([]byte(key), []byte(fmt.Sprintf("%d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	// Reopen the DB to get the versions out of the memtable.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	countVersions := func() map[string]int {
+		counts := make(map[string]int)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			iopt := DefaultIteratorOptions
+			iopt.AllVersions = true
+			it := txn.NewIterator(iopt)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				counts[string(it.Item().Key())]++
+			}
+			return nil
+		}))
+		return counts
+	}
+	// Reading also moves the discard timestamp up to the last commit.
+	for _, key := range keys {
+		require.Equal(t, 10, countVersions()[key])
+	}
+
+	require.Equal(t, ErrInvalidRequest, db.SetVersionRetention([]byte("event/"), -1))
+	require.NoError(t, db.SetVersionRetention([]byte("event/"), 0))
+	require.NoError(t, db.SetVersionRetention([]byte("config/"), 2))
+	require.NoError(t, db.SetVersionRetention([]byte("config/"), 1))
+	require.NoError(t, db.SetVersionRetention([]byte("config/a/"), 5))
+	_, err = db.CompactRange(context.Background(), nil, nil)
+	require.NoError(t, err)
+
+	want := map[string]int{"event/1": 10, "event/2": 10, "config/1": 1, "config/a/1": 5, "other/1": 3}
+	require.Equal(t, want, countVersions())
+
+	// The discarded versions are not brought back by a larger retention.
+	require.NoError(t, db.SetVersionRetention([]byte("config/"), 0))
+	_, err = db.CompactRange(context.Background(), nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, want, countVersions())
+}
+
+func TestLevelBloomFalsePositive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithLevelBloomFalsePositive([]float64{0.05, 0}).
+		WithKeepL0InMemory(false).WithCompactL0OnClose(false)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		for i := 0; i < 20; i++ {
+			if err := txn.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	// Reopen the DB to flush the memtable to level 0.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tables := db.Tables(false)
+	require.Len(t, tables, 1)
+	require.Equal(t, 0, tables[0].Level)
+	require.Equal(t, 0.05, tables[0].BloomFalsePositive)
+
+	// Level 1 has no value of its own, so BloomFalsePositive is used.
+	_, err = db.CompactRange(context.Background(), nil, nil)
+	require.NoError(t, err)
+	tables = db.Tables(false)
+	require.Len(t, tables, 1)
+	require.NotEqual(t, 0, tables[0].Level)
+	require.Equal(t, opt.BloomFalsePositive, tables[0].BloomFalsePositive)
+}
+
+func TestTablesInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithMaxTableSize(1 << 20).WithCompression(options.ZSTD).
+		WithKeepL0InMemory(false)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	start := time.Now().Add(-time.Second)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		for i := 0; i < 100; i++ {
+			if err := txn.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	// Reopen the DB to flush the memtable to level 0.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	tables := db.Tables(true)
+	require.Len(t, tables, 1)
+	ti := tables[0]
+	// The table also holds the value log head key, which sorts first.
+	require.Equal(t, head, y.ParseKey(ti.Left))
+	require.Equal(t, []byte("key099"), y.ParseKey(ti.Right))
+	require.Equal(t, uint64(101), ti.KeyCount)
+	require.Equal(t, uint64(101), ti.NumEntries)
+	require.Equal(t, options.ZSTD, ti.Compression)
+	require.False(t, ti.Encrypted)
+	fi, err := os.Stat(table.NewFilename(ti.ID, dir))
+	require.NoError(t, err)
+	require.Equal(t, fi.Size(), ti.Size)
+	require.True(t, ti.CreatedAt.After(start))
+	require.False(t, ti.CreatedAt.After(time.Now()))
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithBlockSize(256).WithCompression(options.None)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for j := 0; j < 20; j++ {
+				key := []byte(fmt.Sprintf("key%d-%03d", i, j))
+				if err := txn.Set(key, []byte(fmt.Sprintf("value%d-%03d", i, j))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	report, err := db.VerifyChecksums(VerifyOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 0, report.NumCorrupt)
+	tables := db.Tables(false)
+	require.Len(t, report.Tables, len(tables))
+	for i, res := range report.Tables {
+		require.True(t, res.OK)
+		require.Equal(t, -1, res.Offset)
+		require.Equal(t, tables[i].ID, res.ID)
+		require.Equal(t, tables[i].Level, res.Level)
+	}
+	require.NoError(t, db.Close())
+
+	// Corrupt a value in a block in the middle of a table, which isn't read by Open.
+	var corruptID uint64
+	var pos int
+	var data []byte
+	for _, ti := range tables {
+		data, err = ioutil.ReadFile(table.NewFilename(ti.ID, dir))
+		require.NoError(t, err)
+		if pos = bytes.Index(data, []byte("value1-010")); pos >= 0 {
+			corruptID = ti.ID
+			break
+		}
+	}
+	require.True(t, pos > 0)
+	data[pos] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(table.NewFilename(corruptID, dir), data, 0666))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	report, err = db.VerifyChecksums(VerifyOptions{Concurrency: 2})
+	require.Error(t, err)
+	require.Equal(t, 1, report.NumCorrupt)
+	require.Len(t, report.Tables, len(tables))
+	for _, res := range report.Tables {
+		if res.ID == corruptID {
+			require.False(t, res.OK)
+			require.True(t, res.Offset > 0 && res.Offset <= pos)
+			require.Error(t, res.Err)
+		} else {
+			require.True(t, res.OK)
+		}
+	}
+}
+
+func TestRebuildBloomFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithKeepL0InMemory(false).WithCompactL0OnClose(false)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%04d", i)) }
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set(key(i), []byte(fmt.Sprintf("value%d", i)))
+			}))
+		}
+	}
+	// Get some of the tables out of level 0.
+	write(0, 500)
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	_, err = db.CompactRange(context.Background(), nil, nil)
+	require.NoError(t, err)
+	write(500, 1000)
+	require.NoError(t, db.Close())
+
+	check := func(db *DB, fp float64) map[uint64]struct{} {
+		ids := make(map[uint64]struct{})
+		levels := make(map[int]struct{})
+		for _, ti := range db.Tables(false) {
+			require.Equal(t, fp, ti.BloomFalsePositive)
+			ids[ti.ID] = struct{}{}
+			levels[ti.Level] = struct{}{}
+		}
+		require.True(t, len(levels) > 1)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 1000; i++ {
+				item, err := txn.Get(key(i))
+				require.NoError(t, err)
+				require.NoError(t, item.Value(func(val []byte) error {
+					require.Equal(t, fmt.Sprintf("value%d", i), string(val))
+					return nil
+				}))
+			}
+			_, err := txn.Get([]byte("missing"))
+			require.Equal(t, ErrKeyNotFound, err)
+			return nil
+		}))
+		return ids
+	}
+
+	opt = opt.WithBloomFalsePositive(0.1)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	before := make(map[uint64]struct{})
+	for _, ti := range db.Tables(false) {
+		require.Equal(t, 0.01, ti.BloomFalsePositive)
+		before[ti.ID] = struct{}{}
+	}
+	require.NoError(t, db.RebuildBloomFilters())
+	after := check(db, 0.1)
+	require.Equal(t, len(before), len(after))
+	for id := range after {
+		require.NotContains(t, before, id)
+	}
+	// The tables which are already rebuilt are left alone.
+	require.NoError(t, db.RebuildBloomFilters())
+	require.Equal(t, after, check(db, 0.1))
+	require.NoError(t, db.Close())
+
+	// The rebuilt tables are in the MANIFEST.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	require.Equal(t, after, check(db, 0.1))
+}
+
+func TestMixedCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	key := func(c options.CompressionType, i int) []byte {
+		return []byte(fmt.Sprintf("key-%d-%03d", c, i))
+	}
+	compressions := []options.CompressionType{options.Snappy, options.ZSTD, options.None}
+	for _, c := range compressions {
+		db, err := Open(getTestOptions(dir).WithCompression(c).WithKeepL0InMemory(false).
+			WithCompactL0OnClose(false))
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := 0; i < 15; i++ {
+				if err := txn.Set(key(c, i), bytes.Repeat([]byte("v"), 200)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		require.NoError(t, db.Close())
+	}
+
+	// Every table is read with the compression it was written with.
+	db, err := Open(getTestOptions(dir).WithMaxTableSize(1 << 20).WithCompression(options.ZSTD).
+		WithKeepL0InMemory(false))
+	require.NoError(t, err)
+	defer db.Close()
+	require.Len(t, db.Tables(false), len(compressions))
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for _, c := range compressions {
+			for i := 0; i < 15; i++ {
+				item, err := txn.Get(key(c, i))
+				require.NoError(t, err)
+				val, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, bytes.Repeat([]byte("v"), 200), val)
+			}
+		}
+		return nil
+	}))
+}
+
+func TestLevelCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithMaxTableSize(1 << 20).WithCompression(options.ZSTD).
+		WithKeepL0InMemory(false).
+		WithCompactL0OnClose(false).WithLevelCompression([]options.CompressionType{options.None})
+	_, err = Open(opt.WithLevelCompression(make([]options.CompressionType, opt.MaxLevels+1)))
+	require.Error(t, err)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%03d", i))
+	}
+	val := bytes.Repeat([]byte("v"), 200)
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		for i := 0; i < 20; i++ {
+			if err := txn.Set(key(i), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	// compressions returns the compression of the tables of each level.
+	compressions := func() map[int][]options.CompressionType {
+		res := make(map[int][]options.CompressionType)
+		for _, l := range db.lc.levels {
+			l.RLock()
+			for _, t := range l.tables {
+				res[l.level] = append(res[l.level], t.CompressionType())
+			}
+			l.RUnlock()
+		}
+		return res
+	}
+	require.Equal(t, map[int][]options.CompressionType{0: {options.None}}, compressions())
+
+	done, err := db.CompactRange(context.Background(), nil, nil)
+	require.NoError(t, err)
+	require.True(t, done)
+	for level, cs := range compressions() {
+		require.NotZero(t, level)
+		for _, c := range cs {
+			require.Equal(t, options.ZSTD, c)
+		}
+	}
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < 20; i++ {
+			item, err := txn.Get(key(i))
+			require.NoError(t, err)
+			got, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, val, got)
+		}
+		return nil
+	}))
+}
+
+func TestZSTDDictionary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir).WithCompression(options.ZSTD).WithZSTDDictionarySize(4 << 10).
+		WithKeepL0InMemory(false).WithCompactL0OnClose(false)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%04d", i))
+	}
+	val := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user%d@example.com"}`,
+			i, i*7, i*13))
+	}
+	wb := db.NewWriteBatch()
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, wb.Set(key(i), val(i)))
+	}
+	require.NoError(t, wb.Flush())
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	done, err := db.CompactRange(context.Background(), nil, nil)
+	require.NoError(t, err)
+	require.True(t, done)
+	require.NoError(t, db.Close())
+
+	// The tables built by the compaction are read with their dictionaries.
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.VerifyChecksums(VerifyOptions{})
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < 2000; i++ {
+			item, err := txn.Get(key(i))
+			require.NoError(t, err)
+			got, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, val(i), got)
+		}
+		return nil
+	}))
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	oldKey := make([]byte, 32)
+	_, err = rand.Read(oldKey)
+	require.NoError(t, err)
+	newKey := make([]byte, 16)
+	_, err = rand.Read(newKey)
+	require.NoError(t, err)
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%03d", i))
+	}
+	val := bytes.Repeat([]byte("v"), 2048)
+	write := func(db *DB, start, end int) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := start; i < end; i++ {
+				if err := txn.Set(key(i), val); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+
+	opt := getTestOptions(dir).WithEncryptionKey(oldKey)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	write(db, 0, 10)
+	require.Error(t, db.RotateEncryptionKey([]byte("short")))
+	require.NoError(t, db.RotateEncryptionKey(newKey))
+	// Data keys generated after the rotation are encrypted with the new key.
+	db.registry.lastCreated = 0
+	write(db, 10, 20)
+	require.NoError(t, db.Close())
+
+	_, err = Open(opt)
+	require.Error(t, err)
+
+	db, err = Open(opt.WithEncryptionKey(newKey))
+	require.NoError(t, err)
+	defer db.Close()
+	require.Len(t, db.registry.dataKeys, 2)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < 20; i++ {
+			item, err := txn.Get(key(i))
+			require.NoError(t, err)
+			got, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, val, got)
+		}
+		return nil
+	}))
+
+	// An unencrypted DB has no key to rotate.
+	dir2, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir2)
+	db2, err := Open(getTestOptions(dir2))
+	require.NoError(t, err)
+	defer db2.Close()
+	require.Equal(t, ErrNotEncrypted, db2.RotateEncryptionKey(newKey))
+}
+
+func TestEnableEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	encryptionKey := make([]byte, 32)
+	_, err = rand.Read(encryptionKey)
+	require.NoError(t, err)
+
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%03d", i))
+	}
+	val := bytes.Repeat([]byte("v"), 2048)
+	write := func(db *DB, start, end int) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			for i := start; i < end; i++ {
+				if err := txn.Set(key(i), val); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 20; i++ {
+				item, err := txn.Get(key(i))
+				require.NoError(t, err)
+				got, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, val, got)
+			}
+			return nil
+		}))
+	}
+	// keyIDs returns the number of plain text and encrypted tables.
+	keyIDs := func(db *DB) (plainTables, encryptedTables int) {
+		for _, ti := range db.Tables(false) {
+			if ti.KeyID == 0 {
+				plainTables++
+			} else {
+				encryptedTables++
+			}
+		}
+		return plainTables, encryptedTables
+	}
+
+	opt := getTestOptions(dir).WithKeepL0InMemory(false).WithCompactL0OnClose(false)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	write(db, 0, 10)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Error(t, db.EncryptTables())
+	require.NoError(t, db.EnableEncryption(encryptionKey))
+	require.Equal(t, ErrEncrypted, db.EnableEncryption(encryptionKey))
+	write(db, 10, 20)
+	// The value log is encrypted from now on.
+	require.True(t, db.vlog.filesMap[db.vlog.maxFid].encryptionEnabled())
+	require.NoError(t, db.Close())
+
+	_, err = Open(opt)
+	require.Error(t, err)
+
+	opt = opt.WithEncryptionKey(encryptionKey)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	plain, encrypted := keyIDs(db)
+	require.Equal(t, 1, plain)
+	require.Equal(t, 1, encrypted)
+	check(db)
+
+	require.NoError(t, db.EncryptTables())
+	plain, encrypted = keyIDs(db)
+	require.Zero(t, plain)
+	require.Equal(t, 2, encrypted)
+	check(db)
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
+
+func TestEncryptTablesCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	encryptionKey := make([]byte, 32)
+	_, err = rand.Read(encryptionKey)
+	require.NoError(t, err)
+
+	opt := getTestOptions(dir).WithBlockSize(256).WithCompression(options.None).
+		WithKeepL0InMemory(false).WithCompactL0OnClose(false).
+		WithChecksumVerificationMode(options.OnBlockRead)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	wb := db.NewWriteBatch()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, wb.Set([]byte(fmt.Sprintf("key%03d", i)),
+			[]byte(fmt.Sprintf("value%03d", i))))
+	}
+	require.NoError(t, wb.Flush())
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	tables := db.Tables(false)
+	require.Len(t, tables, 1)
+	require.NoError(t, db.Close())
+
+	// Corrupt a value in a block in the middle of the table, which isn't read by Open.
+	fname := table.NewFilename(tables[0].ID, dir)
+	data, err := ioutil.ReadFile(fname)
+	require.NoError(t, err)
+	pos := bytes.Index(data, []byte("value025"))
+	require.True(t, pos > 0)
+	data[pos] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(fname, data, 0666))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.EnableEncryption(encryptionKey))
+	// The table isn't replaced by a truncated copy.
+	require.Error(t, db.EncryptTables())
+	tables = db.Tables(false)
+	require.Len(t, tables, 1)
+	require.Zero(t, tables[0].KeyID)
+}
+
+func TestFlattenWith(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := func(i int) []byte { return []byte(fmt.Sprintf("%06d", i)) }
+		val := make([]byte, 128)
+		const n = 3000
+		wb := db.NewWriteBatch()
+		for i := 0; i < n; i++ {
+			require.NoError(t, wb.Set(key(i), val))
+		}
+		require.NoError(t, wb.Flush())
+		numTables := func(l int) int { return db.lc.levels[l].numTables() }
+		require.Eventually(t, func() bool {
+			return numTables(0)+numTables(1) > 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// A canceled flatten leaves the DB usable.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var calls int
+		err := db.FlattenWith(FlattenOptions{
+			Context:  ctx,
+			Progress: func(FlattenProgress) { calls++ },
+		})
+		require.Equal(t, context.Canceled, err)
+		require.Equal(t, 1, calls)
+
+		var progress []FlattenProgress
+		require.NoError(t, db.FlattenWith(FlattenOptions{
+			Workers:     2,
+			TargetLevel: 3,
+			Progress:    func(p FlattenProgress) { progress = append(progress, p) },
+		}))
+		require.True(t, len(progress) > 1)
+		require.True(t, progress[0].TablesRemaining > 0)
+		last := progress[len(progress)-1]
+		require.Zero(t, last.TablesRemaining)
+		require.True(t, last.BytesWritten > 0)
+		for l := 0; l < 3; l++ {
+			require.Zero(t, numTables(l), "level %d", l)
+		}
+		require.True(t, numTables(3) > 0)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < n; i++ {
+				_, err := txn.Get(key(i))
+				require.NoError(t, err)
+			}
+			return nil
+		}))
+	})
+}
+
+func TestValueLogHeadAndDiscardWatermark(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		fid, offset := db.ValueLogHead()
+		require.Equal(t, db.vlog.maxFid, fid)
+		require.Zero(t, db.DiscardWatermark())
+
+		txnSet(t, db, []byte("key"), []byte("value"), 0)
+		fid2, offset2 := db.ValueLogHead()
+		require.Equal(t, fid, fid2)
+		require.True(t, offset2 > offset)
+		// The head is the end of the data written to the value log file.
+		require.Equal(t, db.vlog.woffset(), offset2)
+
+		txn := db.NewTransaction(false)
+		readTs := txn.ReadTs()
+		txnSet(t, db, []byte("key"), []byte("value2"), 0)
+		require.True(t, db.DiscardWatermark() < readTs)
+		txn.Discard()
+		require.Eventually(t, func() bool {
+			return db.DiscardWatermark() >= readTs
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestEntryMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	small := []byte("small")
+	large := bytes.Repeat([]byte("l"), opt.ValueThreshold)
+	md := func(k string) []byte { return []byte("md-" + k) }
+	err = db.Update(func(txn *Txn) error {
+		require.NoError(t, txn.SetEntry(NewEntry([]byte("a"), small).WithMetadata(md("a"))))
+		require.NoError(t, txn.SetEntry(NewEntry([]byte("b"), large).WithMetadata(md("b"))))
+		require.NoError(t, txn.Set([]byte("c"), small))
+		err := txn.SetEntry(NewEntry([]byte("d"), small).WithMetadata(make([]byte, 33)))
+		require.Equal(t, ErrMetadataTooBig, err)
+
+		item, err := txn.Get([]byte("a"))
+		require.NoError(t, err)
+		m, err := item.Metadata()
+		require.NoError(t, err)
+		require.Equal(t, md("a"), m)
+		return nil
+	})
+	require.NoError(t, err)
+
+	vals := map[string][]byte{"a": small, "b": large, "c": small}
+	checkItem := func(item *Item) {
+		k := string(item.Key())
+		m, err := item.Metadata()
+		require.NoError(t, err)
+		if k == "c" {
+			require.Nil(t, m)
+		} else {
+			require.Equal(t, md(k), m)
+		}
+		val, err := item.ValueCopy(nil)
+		require.NoError(t, err)
+		require.Equal(t, vals[k], val)
+	}
+	check := func() {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for k, v := range vals {
+				item, err := txn.Get([]byte(k))
+				require.NoError(t, err)
+				checkItem(item)
+				// The size doesn't include the metadata, in the value log or not.
+				require.Equal(t, int64(len(v)), item.ValueSize())
+			}
+
+			for _, prefetch := range []bool{false, true} {
+				opt := DefaultIteratorOptions
+				opt.PrefetchValues = prefetch
+				it := txn.NewIterator(opt)
+				var n int
+				for it.Rewind(); it.Valid(); it.Next() {
+					checkItem(it.Item())
+					n++
+				}
+				it.Close()
+				require.Equal(t, 3, n)
+			}
+			return nil
+		}))
+	}
+	check()
+
+	// The metadata is kept across reopening the DB, and compactions.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	check()
+	compacted, err := db.CompactRange(context.Background(), nil, nil)
+	require.NoError(t, err)
+	require.True(t, compacted)
+	check()
+}
+
+func TestSplitKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	N := 20000
+	wb := db.NewWriteBatch()
+	for i := 0; i < N; i++ {
+		require.NoError(t, wb.Set([]byte(fmt.Sprintf("key%06d", i)), make([]byte, 50)))
+	}
+	require.NoError(t, wb.Flush())
+	// Write the memtable to a table.
+	require.NoError(t, db.Close())
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	_, err = db.SplitKeys(0)
+	require.Error(t, err)
+	keys, err := db.SplitKeys(1)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	keys, err = db.SplitKeys(4)
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+	prev := -1
+	for _, k := range keys {
+		var i int
+		_, err := fmt.Sscanf(string(k), "key%06d", &i)
+		require.NoError(t, err)
+		require.InDelta(t, N/4, i-prev, float64(N/20), "split key %q", k)
+		prev = i
+	}
+}
+
+func TestSetNumCompactors(t *testing.T) {
+	opt := getTestOptions("").WithNumCompactors(2)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		running := func() int {
+			db.lc.workersLock.Lock()
+			defer db.lc.workersLock.Unlock()
+			var n int
+			for _, r := range db.lc.workers {
+				if r {
+					n++
+				}
+			}
+			return n
+		}
+		require.Equal(t, 2, running())
+		require.Error(t, db.SetNumCompactors(-1))
+
+		require.NoError(t, db.SetNumCompactors(4))
+		require.Equal(t, 4, running())
+
+		// The workers in excess stop on their next tick.
+		require.NoError(t, db.SetNumCompactors(1))
+		require.Eventually(t, func() bool { return running() == 1 }, 5*time.Second,
+			10*time.Millisecond)
+		require.NoError(t, db.SetNumCompactors(3))
+		require.Equal(t, 3, running())
+
+		// The workers are restarted at the new count after the compactions were stopped.
+		for i := 0; i < 100; i++ {
+			txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), []byte("val"), 0)
+		}
+		require.NoError(t, db.Flatten(1))
+		require.Equal(t, 3, running())
+
+		require.NoError(t, db.SetNumCompactors(0))
+		require.Eventually(t, func() bool { return running() == 0 }, 5*time.Second,
+			10*time.Millisecond)
+		require.NoError(t, db.SetNumCompactors(2))
+		require.Equal(t, 2, running())
+	})
+}
+
+func TestMaxDiskSize(t *testing.T) {
+	opt := getTestOptions("").WithMaxDiskSize(64 << 10).WithValueThreshold(32)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		val := make([]byte, 1<<10)
+		var err error
+		var n int
+		for ; n < 1000; n++ {
+			txn := db.NewTransaction(true)
+			require.NoError(t, txn.SetEntry(NewEntry([]byte(fmt.Sprintf("key%d", n)), val)))
+			if err = txn.Commit(); err != nil {
+				break
+			}
+		}
+		require.Equal(t, ErrDiskFull, err)
+		require.True(t, n > 0)
+
+		// The reads keep working.
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("key0"))
+			return err
+		}))
+		// So do the commits without writes, and the ones which only delete keys.
+		require.NoError(t, db.NewTransaction(true).Commit())
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			if err := txn.Delete([]byte("key0")); err != nil {
+				return err
+			}
+			return txn.DeleteRange([]byte("key1"), []byte("key2"))
+		}))
+		require.Equal(t, ErrDiskFull, db.Update(func(txn *Txn) error {
+			if err := txn.Delete([]byte("key2")); err != nil {
+				return err
+			}
+			return txn.Set([]byte("key3"), val)
+		}))
+
+		// The commits are accepted again once the space is reclaimed.
+		require.NoError(t, db.DropAll())
+		txnSet(t, db, []byte("key"), val, 0)
+	})
+}
+
+func TestSpaceStats(t *testing.T) {
+	// Without compactions, the tables flushed from the memtables pile up in level 0.
+	opt := getTestOptions("").WithNumCompactors(0).WithValueLogFileSize(1 << 20).
+		WithKeepL0InMemory(false)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		s := db.SpaceStats()
+		require.Zero(t, s.LSMSize)
+		require.Equal(t, 1.0, s.SpaceAmplification)
+		require.Equal(t, RecommendNothing, s.Recommendation)
+
+		val := make([]byte, 512) // Stored in the value log.
+		write := func() {
+			batch := db.NewWriteBatch()
+			for i := 0; i < 2000; i++ {
+				require.NoError(t, batch.Set([]byte(fmt.Sprintf("key%04d", i)), val))
+			}
+			require.NoError(t, batch.Flush())
+		}
+		write()
+		write()
+		require.Eventually(t, func() bool { return db.SpaceStats().LSMSize > 0 }, 5*time.Second,
+			10*time.Millisecond)
+
+		// The tables of level 0 overlap, the older ones hold stale versions.
+		s = db.SpaceStats()
+		require.Equal(t, s.LSMSize+s.ValueLogSize, s.TotalSize)
+		require.True(t, s.ValueLogSize > 2000*int64(len(val)))
+		require.True(t, s.CompactionReclaimable > 0)
+		require.True(t, s.CompactionReclaimable < s.LSMSize)
+		require.Zero(t, s.ValueLogGCReclaimable)
+		require.True(t, s.SpaceAmplification > 1)
+
+		// Compacting the tables drops the stale versions, once no transaction can read them, and
+		// updates the discard stats of the value log files holding their values.
+		require.NoError(t, db.View(func(txn *Txn) error { return nil }))
+		require.Eventually(t, func() bool {
+			return db.orc.readMark.DoneUntil() == db.orc.nextTs()-1
+		}, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, db.Flatten(1))
+		require.Eventually(t, func() bool {
+			return db.SpaceStats().ValueLogGCReclaimable > 0
+		}, 5*time.Second, 10*time.Millisecond)
+		s = db.SpaceStats()
+		require.Zero(t, s.CompactionReclaimable)
+		require.Equal(t, s.TotalSize-s.ValueLogGCReclaimable, s.LiveSize)
+		require.Equal(t, RecommendValueLogGC, s.Recommendation)
+	})
+}
+
+func TestBeginDrain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := Open(getTestOptions(dir).WithValueThreshold(32))
+	require.NoError(t, err)
+
+	val := make([]byte, 128) // Read from the value log.
+	wb := db.NewWriteBatch()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, wb.Set([]byte(fmt.Sprintf("key%04d", i)), val))
+	}
+	require.NoError(t, wb.Flush())
+
+	// Long iterations started before the drain.
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			txn := db.NewTransaction(false)
+			defer txn.Discard()
+			itr := txn.NewIterator(DefaultIteratorOptions)
+			defer itr.Close()
+			started <- struct{}{}
+			var n int
+			for itr.Rewind(); itr.Valid(); itr.Next() {
+				require.Equal(t, val, getItemValue(t, itr.Item()))
+				time.Sleep(100 * time.Microsecond)
+				n++
+			}
+			require.Equal(t, 1000, n)
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+	// A write started before the drain.
+	before := db.NewTransaction(true)
+	require.NoError(t, before.SetEntry(NewEntry([]byte("before"), val)))
+
+	db.BeginDrain()
+	after := db.NewTransaction(true)
+	require.NoError(t, after.SetEntry(NewEntry([]byte("after"), val)))
+	require.Equal(t, ErrDBClosing, after.Commit())
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("key0000"))
+		return err
+	}))
+
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the transactions were discarded")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, before.Commit())
+	wg.Wait()
+	require.NoError(t, <-closed)
+
+	db, err = Open(getTestOptions(dir))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.View(func(txn *Txn) error {
+		if _, err := txn.Get([]byte("before")); err != nil {
+			return err
+		}
+		_, err := txn.Get([]byte("after"))
+		require.Equal(t, ErrKeyNotFound, err)
+		return nil
+	}))
+}
+
+func TestMaxMemtableAge(t *testing.T) {
+	opt := getTestOptions("").WithMaxMemtableAge(100 * time.Millisecond)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		numTables := func() int {
+			return len(db.Tables(false))
+		}
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.SetEntry(NewEntry([]byte("foo"), []byte("bar")))
+		}))
+		require.Zero(t, numTables())
+		require.Eventually(t, func() bool { return numTables() == 1 },
+			5*time.Second, 10*time.Millisecond)
+
+		// An empty memtable is never flushed.
+		time.Sleep(300 * time.Millisecond)
+		require.Equal(t, 1, numTables())
+
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.SetEntry(NewEntry([]byte("foo"), []byte("baz")))
+		}))
+		require.Eventually(t, func() bool { return numTables() == 2 },
+			5*time.Second, 10*time.Millisecond)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("foo"))
+			require.NoError(t, err)
+			require.Equal(t, []byte("baz"), getItemValue(t, item))
+			return nil
+		}))
+	})
+
+	// An age too short for a ticker of a quarter of it is still enforced.
+	opt = getTestOptions("").WithMaxMemtableAge(time.Nanosecond)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.SetEntry(NewEntry([]byte("foo"), []byte("bar")))
+		}))
+		require.Eventually(t, func() bool { return len(db.Tables(false)) == 1 },
+			5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestMaxNumMemtables(t *testing.T) {
+	// With the compactions stopped, a burst of writes fills level 0 and stalls the flushes. The
+	// writes then block once the memtables waiting for a flush reach the ceiling.
+	test := func(t *testing.T, maxNum, ceiling int) {
+		opt := getTestOptions("").WithNumMemtables(1).WithMaxNumMemtables(maxNum).
+			WithNumLevelZeroTables(1).WithNumLevelZeroTablesStall(2)
+		runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+			db.stopCompactions()
+			var writes int64
+			done := make(chan struct{})
+			errCh := make(chan error, 1)
+			go func() {
+				val := make([]byte, 1<<10)
+				for i := 0; ; i++ {
+					select {
+					case <-done:
+						errCh <- nil
+						return
+					default:
+					}
+					if err := db.Update(func(txn *Txn) error {
+						return txn.Set([]byte(fmt.Sprintf("key%06d", i)), val)
+					}); err != nil {
+						errCh <- err
+						return
+					}
+					atomic.AddInt64(&writes, 1)
+				}
+			}()
+
+			// Wait for the writer to block.
+			var n int64
+			for i := 0; ; i++ {
+				require.Less(t, i, 50, "The writes never blocked")
+				time.Sleep(200 * time.Millisecond)
+				prev := n
+				if n = atomic.LoadInt64(&writes); n > 0 && n == prev {
+					break
+				}
+			}
+			require.Equal(t, ceiling, len(db.flushChan))
+			require.Equal(t, int32(1), atomic.LoadInt32(&db.lc.l0Stalled))
+
+			// Once level 0 drains, the writes resume and the extra memtables are flushed.
+			db.startCompactions()
+			require.Eventually(t, func() bool { return atomic.LoadInt64(&writes) > n },
+				10*time.Second, 10*time.Millisecond)
+			close(done)
+			require.NoError(t, <-errCh)
+			require.Eventually(t, func() bool {
+				return len(db.flushChan) == 0 && atomic.LoadInt32(&db.lc.l0Stalled) == 0
+			}, 10*time.Second, 10*time.Millisecond)
+		})
+	}
+	t.Run("fixed", func(t *testing.T) { test(t, 0, 1) })
+	t.Run("growing", func(t *testing.T) { test(t, 3, 3) })
+}
+
+func TestGroupCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	_, err = Open(getTestOptions(dir).WithSyncWrites(true).WithGroupCommitInterval(time.Second))
+	require.Error(t, err)
+
+	opt := getTestOptions(dir).WithSyncWrites(false).WithGroupCommitInterval(100 * time.Millisecond)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	// A commit waits for the sync of its group.
+	start := time.Now()
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("key"), []byte("value"))
+	}))
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+	require.Equal(t, uint64(1), db.GroupCommitStats().Syncs)
+
+	// The concurrent commits share the syncs. The new transactions wait for the previous commits
+	// to be acknowledged, so they're all created first.
+	var txns []*Txn
+	for i := 0; i < 100; i++ {
+		txn := db.NewTransaction(true)
+		require.NoError(t, txn.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+		txns = append(txns, txn)
+	}
+	var wg sync.WaitGroup
+	for _, txn := range txns {
+		wg.Add(1)
+		txn.CommitWith(func(err error) {
+			require.NoError(t, err)
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	s := db.GroupCommitStats()
+	require.Equal(t, uint64(101), s.Commits)
+	require.True(t, s.Syncs < 10, "%+v", s)
+	require.True(t, s.AvgGroupSize > 10, "%+v", s)
+	require.True(t, s.AvgSyncLatency > 0, "%+v", s)
+	require.NoError(t, db.Close())
+
+	// GroupCommitSize syncs before the interval is up.
+	db, err = Open(opt.WithGroupCommitInterval(time.Hour).WithGroupCommitSize(1))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("key"), []byte("value2"))
+	}))
+	require.Equal(t, uint64(1), db.GroupCommitStats().Syncs)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("key99"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), getItemValue(t, item))
+		return nil
+	}))
+}
+
+func TestWaitForQuiescence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("before"), []byte("value"))
+	}))
+	// The writes go on meanwhile.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100))
+			}))
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	resume, err := db.WaitForQuiescence()
+	require.NoError(t, err)
+	// The memtable holding the first write was flushed.
+	require.NotEmpty(t, db.Tables(false))
+
+	files := func() map[string]int64 {
+		fs := make(map[string]int64)
+		entries, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		for _, e := range entries {
+			if filepath.Ext(e.Name()) != ".vlog" {
+				fs[e.Name()] = e.Size()
+			}
+		}
+		return fs
+	}
+	before := files()
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, before, files())
+
+	resume()
+	close(stop)
+	wg.Wait()
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("before"))
+		return err
+	}))
+}
+
+func TestRejectOnStall(t *testing.T) {
+	opt := getTestOptions("").WithNumMemtables(1).WithNumLevelZeroTables(1).
+		WithNumLevelZeroTablesStall(2).WithRejectOnStall(true)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		require.False(t, db.WriteStalled())
+		// With the compactions stopped, a burst of writes fills level 0 and stalls the flushes.
+		db.stopCompactions()
+		done := make(chan struct{})
+		errCh := make(chan error, 1)
+		go func() {
+			val := make([]byte, 1<<10)
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					errCh <- nil
+					return
+				default:
+				}
+				err := db.Update(func(txn *Txn) error {
+					return txn.Set([]byte(fmt.Sprintf("key%06d", i)), val)
+				})
+				if err == ErrWriteStalled {
+					time.Sleep(10 * time.Millisecond)
+				} else if err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+		for i := 0; !db.WriteStalled(); i++ {
+			require.Less(t, i, 1000, "The writes never stalled")
+			time.Sleep(10 * time.Millisecond)
+		}
+		set := func() error {
+			return db.Update(func(txn *Txn) error {
+				return txn.Set([]byte("key"), []byte("val"))
+			})
+		}
+		require.Equal(t, ErrWriteStalled, set())
+
+		// Once level 0 drains, the commits are accepted again, and the stall is accounted for.
+		db.startCompactions()
+		for i := 0; db.WriteStalled(); i++ {
+			require.Less(t, i, 1000, "The writes never resumed")
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.NoError(t, set())
+		close(done)
+		require.NoError(t, <-errCh)
+		stall, ok := y.WriteStallTime.Get(db.opt.Dir).(*expvar.Int)
+		require.True(t, ok)
+		require.True(t, stall.Value() > 0)
+	})
+}
+
+func TestMaxOpenTableFiles(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("The open files can't be listed on this platform")
+	}
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	// openTables returns the number of table files open by the process.
+	openTables := func() int {
+		fds, err := ioutil.ReadDir("/proc/self/fd")
+		require.NoError(t, err)
+		var n int
+		for _, fd := range fds {
+			path, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+			if err == nil && filepath.Dir(path) == filepath.Clean(dir) &&
+				filepath.Ext(path) == ".sst" {
+				n++
+			}
+		}
+		return n
+	}
+
+	opt := getTestOptions(dir).WithTableLoadingMode(options.FileIO)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%06d", i)) }
+	const n = 30000
+	wb := db.NewWriteBatch()
+	for i := 0; i < n; i++ {
+		require.NoError(t, wb.Set(key(i), []byte(fmt.Sprintf("%020d", i))))
+	}
+	require.NoError(t, wb.Flush())
+	require.NoError(t, db.Close())
+
+	opt = opt.WithMaxOpenTableFiles(2)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.Greater(t, len(db.Tables(false)), 2*opt.MaxOpenTableFiles)
+	require.LessOrEqual(t, openTables(), opt.MaxOpenTableFiles)
+	reopens := y.NumTableReopens.Value()
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for i := 0; i < n; i += 7 {
+			item, err := txn.Get(key(i))
+			require.NoError(t, err)
+			require.Equal(t, []byte(fmt.Sprintf("%020d", i)), getItemValue(t, item))
+		}
+		return nil
+	}))
+	require.LessOrEqual(t, openTables(), opt.MaxOpenTableFiles)
+	require.Greater(t, y.NumTableReopens.Value(), reopens)
+}
+
+func TestCompactionRateLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	var mu sync.Mutex
+	var compacted int64
+	opt := getTestOptions(dir).WithKeepL0InMemory(false).WithCompactL0OnClose(false)
+	opt.NumCompactors = 0
+	opt.NumLevelZeroTables = 1000
+	opt.NumLevelZeroTablesStall = 2000
+	opt.OnCompaction = func(ev CompactionEvent) {
+		mu.Lock()
+		compacted += ev.InputBytes + ev.OutputBytes
+		mu.Unlock()
+	}
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	wb := db.NewWriteBatch()
+	for i := 0; i < 12000; i++ {
+		val := make([]byte, 20)
+		rand.Read(val)
+		require.NoError(t, wb.Set([]byte(fmt.Sprintf("key%08d", i)), val))
+	}
+	require.NoError(t, wb.Flush())
+
+	// The limit is set at runtime, and applies to the compactions of Flatten.
+	const rate = 512 << 10
+	require.Error(t, db.SetCompactionRateLimit(-1))
+	require.NoError(t, db.SetCompactionRateLimit(rate))
+
+	var utilization int64
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+			u := y.CompactionRateLimitUtilization.Get(dir).(expvar.Func).Value().(int64)
+			if u > atomic.LoadInt64(&utilization) {
+				atomic.StoreInt64(&utilization, u)
+			}
+		}
+	}()
+	start := time.Now()
+	require.NoError(t, db.FlattenWith(FlattenOptions{TargetLevel: 1}))
+	elapsed := time.Since(start)
+	close(done)
+	require.NoError(t, db.Close())
+
+	// The events are delivered by Close. The tables read and written account for less than the
+	// limiter, which counts the entries read uncompressed.
+	mu.Lock()
+	defer mu.Unlock()
+	require.Greater(t, compacted, int64(2*rate))
+	require.Greater(t, elapsed.Seconds(), float64(compacted)/rate/2)
+	ceiling := float64(rate)*elapsed.Seconds()*1.1 + rate/10 + compactionIOChunk
+	require.LessOrEqual(t, float64(compacted), ceiling,
+		"compacted %d bytes in %s with a limit of %d bytes per second", compacted, elapsed, rate)
+	require.Greater(t, atomic.LoadInt64(&utilization), int64(0))
+	require.LessOrEqual(t, atomic.LoadInt64(&utilization), int64(200))
+}
@@ -52,8 +52,9 @@ var (
 	ErrEmptyKey = errors.New("Key cannot be empty")
 
 	// ErrInvalidKey is returned if the key has a special !badger! prefix,
-	// reserved for internal usage.
-	ErrInvalidKey = errors.New("Key is using a reserved !badger! prefix")
+	// reserved for internal usage, or, once a column family is created, the
+	// !cf! prefix of the column families.
+	ErrInvalidKey = errors.New("Key is using a reserved !badger! or !cf! prefix")
 
 	// ErrRetry is returned when a log file containing the value is not found.
 	// This usually indicates that it may have been garbage collected, and the
@@ -87,6 +88,9 @@ var (
 	// ErrZeroBandwidth is returned if the user passes in zero bandwidth for sequence.
 	ErrZeroBandwidth = errors.New("Bandwidth must be greater than zero")
 
+	// ErrSequenceClosed is returned if the sequence is used after it was closed.
+	ErrSequenceClosed = errors.New("Sequence has been closed")
+
 	// ErrInvalidLoadingMode is returned when opt.ValueLogLoadingMode option is not
 	// within the valid range
 	ErrInvalidLoadingMode = errors.New("Invalid ValueLogLoadingMode, must be FileIO or MemoryMap")
@@ -113,10 +117,20 @@ var (
 	// ErrNoPrefixes is returned when subscriber doesn't provide any prefix.
 	ErrNoPrefixes = errors.New("At least one key prefix is required")
 
+	// ErrNoCheckpoint is returned by StreamWriter.Resume if the DB holds no checkpoint to resume
+	// from.
+	ErrNoCheckpoint = errors.New("No StreamWriter checkpoint to resume from")
+
 	// ErrEncryptionKeyMismatch is returned when the storage key is not
 	// matched with the key previously given.
 	ErrEncryptionKeyMismatch = errors.New("Encryption key mismatch")
 
+	// ErrNotEncrypted is returned by RotateEncryptionKey if the DB isn't encrypted.
+	ErrNotEncrypted = errors.New("DB is not encrypted")
+
+	// ErrEncrypted is returned by EnableEncryption if the DB is already encrypted.
+	ErrEncrypted = errors.New("DB is already encrypted")
+
 	// ErrInvalidDataKeyID is returned if the datakey id is invalid.
 	ErrInvalidDataKeyID = errors.New("Invalid datakey id")
 
@@ -124,4 +138,38 @@ var (
 		"either 16, 24, or 32 bytes")
 
 	ErrGCInMemoryMode = errors.New("Cannot run value log GC when DB is opened in InMemory mode")
+
+	// ErrVLogIteratorInMemoryMode is returned by DB.ValueLogIterator if the DB is opened in
+	// InMemory mode, which has no value log.
+	ErrVLogIteratorInMemoryMode = errors.New(
+		"Cannot iterate over the value log when DB is opened in InMemory mode")
+
+	// ErrInvalidCounter is returned if a counter is read or incremented, but one of the values
+	// stored for its key is not an 8 byte integer.
+	ErrInvalidCounter = errors.New("Counter value should be an 8 byte big-endian integer")
+
+	// ErrKeyOnlyIteration is returned when the value of an item is read, but the item was
+	// returned by an iterator created with IteratorOptions.KeysOnly.
+	ErrKeyOnlyIteration = errors.New("Value is not available when iterating over keys only")
+
+	// ErrMetadataTooBig is returned if the metadata set with Entry.WithMetadata is longer than
+	// 32 bytes.
+	ErrMetadataTooBig = errors.New("Metadata of an entry can't be longer than 32 bytes")
+
+	// ErrDiskFull is returned by the commits once the size of the DB has gone over
+	// Options.MaxDiskSize. The reads keep working, and so do the commits which only delete keys.
+	// The other commits are accepted again once the size has dropped under 90% of it.
+	ErrDiskFull = errors.New("DB has reached Options.MaxDiskSize, writes are rejected")
+
+	// ErrWriteStalled is returned by the commits while the writes are stalled by level 0, if
+	// Options.RejectOnStall is set.
+	ErrWriteStalled = errors.New("Writes are stalled until level 0 is compacted")
+
+	// ErrComparatorMismatch is returned by Open if Options.KeyComparator isn't the comparator the
+	// DB was created with.
+	ErrComparatorMismatch = errors.New(
+		"Options.KeyComparator doesn't match the comparator the DB was created with")
+
+	// ErrDBClosing is returned by the commits of the transactions created after DB.BeginDrain.
+	ErrDBClosing = errors.New("DB is closing, new transactions can't commit")
 )
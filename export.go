@@ -0,0 +1,161 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/pkg/errors"
+)
+
+// ExportOptions is used to configure DB.ExportJSON.
+type ExportOptions struct {
+	// Prefix, if set, only exports the keys with this prefix.
+	Prefix []byte
+	// AllVersions exports all the versions of the keys, including deletions. By default, only the
+	// latest version of the keys which are neither deleted nor expired is exported.
+	AllVersions bool
+	// NumGo is the number of goroutines used to iterate over the DB. Defaults to 16.
+	NumGo int
+}
+
+// JSONEntry is a single line written by DB.ExportJSON. The names of its JSON fields are stable.
+// Keys and values are encoded in base64.
+type JSONEntry struct {
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+	Version   uint64 `json:"version"`
+	ExpiresAt uint64 `json:"expires_at"`
+	UserMeta  byte   `json:"user_meta"`
+	Deleted   bool   `json:"deleted"`
+	// DeleteRange is set for a range delete of Txn.DeleteRange, which deletes the versions older
+	// than Version of the keys in [Key, RangeEnd). An empty RangeEnd means no upper bound. The
+	// range deletes are only exported along with all the versions.
+	DeleteRange bool   `json:"delete_range,omitempty"`
+	RangeEnd    []byte `json:"range_end,omitempty"`
+}
+
+// ExportJSON writes the key-value pairs of the DB to w as newline-delimited JSON, one JSONEntry
+// per line. The versions of a key are written one after the other, newest first, but the keys are
+// not written in sorted order. Like Backup, ExportJSON reads a consistent snapshot of the DB.
+func (db *DB) ExportJSON(w io.Writer, opt ExportOptions) error {
+	stream := db.NewStream()
+	stream.LogPrefix = "DB.ExportJSON"
+	stream.Prefix = opt.Prefix
+	if opt.NumGo > 0 {
+		stream.NumGo = opt.NumGo
+	}
+	stream.KeyToList = func(key []byte, itr *Iterator) (*pb.KVList, error) {
+		list := &pb.KVList{}
+		for ; itr.Valid(); itr.Next() {
+			item := itr.Item()
+			if !bytes.Equal(item.Key(), key) {
+				break
+			}
+			if !opt.AllVersions && item.IsDeletedOrExpired() {
+				break
+			}
+			kv := &pb.KV{
+				Key:       item.KeyCopy(nil),
+				UserMeta:  []byte{item.UserMeta()},
+				Version:   item.Version(),
+				ExpiresAt: item.ExpiresAt(),
+				Meta:      []byte{item.meta & bitDelete},
+			}
+			if !item.IsDeletedOrExpired() {
+				var err error
+				if kv.Value, err = item.ValueCopy(nil); err != nil {
+					return nil, err
+				}
+			}
+			list.Kv = append(list.Kv, kv)
+			if !opt.AllVersions || item.DiscardEarlierVersions() {
+				break
+			}
+		}
+		return list, nil
+	}
+
+	bw := bufio.NewWriterSize(w, 64<<10)
+	enc := json.NewEncoder(bw)
+	stream.Send = func(list *pb.KVList) error {
+		for _, kv := range list.Kv {
+			je := &JSONEntry{
+				Key:       kv.Key,
+				Value:     kv.Value,
+				Version:   kv.Version,
+				ExpiresAt: kv.ExpiresAt,
+				UserMeta:  kv.UserMeta[0],
+				Deleted:   kv.Meta[0]&bitDelete > 0,
+			}
+			if start, end, ok := ParseDeleteRange(kv.Key); ok {
+				if !opt.AllVersions {
+					continue
+				}
+				je = &JSONEntry{Key: start, Version: kv.Version, DeleteRange: true, RangeEnd: end}
+			}
+			if err := enc.Encode(je); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := stream.Orchestrate(context.Background()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportJSON reads the key-value pairs written by DB.ExportJSON from r, and writes them to the DB
+// with their original versions. The entries are checked like with Load, an empty, internal or too
+// large entry failing the import. Like Load, it should be called on a DB which is not running any
+// other concurrent transactions.
+func (db *DB) ImportJSON(r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReaderSize(r, 64<<10))
+	l := db.newLoader(LoadOptions{})
+	for {
+		var je JSONEntry
+		err := dec.Decode(&je)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "while decoding JSON entry")
+		}
+		kv := &pb.KV{
+			Key:       je.Key,
+			Value:     je.Value,
+			Version:   je.Version,
+			ExpiresAt: je.ExpiresAt,
+			UserMeta:  []byte{je.UserMeta},
+		}
+		if je.Deleted {
+			kv.Meta = []byte{bitDelete}
+		}
+		if je.DeleteRange {
+			kv.Key = rangeDelKey(je.Key, je.RangeEnd)
+		}
+		if err := l.add([]*pb.KV{kv}, 0); err != nil {
+			return err
+		}
+	}
+	return l.finish()
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportJSON(t *testing.T) {
+	export := func(t *testing.T, db *DB, opt ExportOptions) ([]byte, []JSONEntry) {
+		var buf bytes.Buffer
+		require.NoError(t, db.ExportJSON(&buf, opt))
+		var entries []JSONEntry
+		sc := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+		for sc.Scan() {
+			var je JSONEntry
+			require.NoError(t, json.Unmarshal(sc.Bytes(), &je))
+			entries = append(entries, je)
+		}
+		require.NoError(t, sc.Err())
+		sort.SliceStable(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].Key, entries[j].Key) < 0
+		})
+		return buf.Bytes(), entries
+	}
+
+	opt := getTestOptions("")
+	opt.NumVersionsToKeep = 10
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				for _, key := range []string{"a1", "a2", "b1"} {
+					e := NewEntry([]byte(key), []byte(fmt.Sprintf("%s-%d", key, i))).WithMeta(7)
+					if err := txn.SetEntry(e); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+		}
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete([]byte("a2"))
+		}))
+
+		out, entries := export(t, db, ExportOptions{})
+		require.Len(t, entries, 2)
+		require.Equal(t, "a1", string(entries[0].Key))
+		require.Equal(t, "a1-2", string(entries[0].Value))
+		require.Equal(t, uint64(3), entries[0].Version)
+		require.Equal(t, byte(7), entries[0].UserMeta)
+		require.Equal(t, "b1", string(entries[1].Key))
+		// The field names are stable.
+		for _, field := range []string{`"key":`, `"value":`, `"version":`, `"expires_at":`,
+			`"user_meta":`, `"deleted":`} {
+			require.True(t, strings.Contains(string(out), field), field)
+		}
+
+		_, entries = export(t, db, ExportOptions{Prefix: []byte("a"), AllVersions: true})
+		require.Len(t, entries, 7)
+		for _, je := range entries {
+			require.True(t, bytes.HasPrefix(je.Key, []byte("a")))
+		}
+		require.Equal(t, "a2", string(entries[3].Key))
+		require.True(t, entries[3].Deleted)
+		require.Equal(t, uint64(4), entries[3].Version)
+
+		// Importing the export of all versions into a new DB round-trips.
+		all, want := export(t, db, ExportOptions{AllVersions: true})
+		runBadgerTest(t, &opt, func(t *testing.T, db2 *DB) {
+			require.NoError(t, db2.ImportJSON(bytes.NewReader(all)))
+			_, got := export(t, db2, ExportOptions{AllVersions: true})
+			require.Equal(t, want, got)
+		})
+	})
+}
+
+func TestImportJSONInvalidKey(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		for key, want := range map[string]error{
+			"":                ErrEmptyKey,
+			"!badger!head":    ErrInvalidKey,
+			"!badger!txn-key": ErrInvalidKey,
+		} {
+			line, err := json.Marshal(&JSONEntry{Key: []byte(key), Value: []byte("v"), Version: 1})
+			require.NoError(t, err)
+			require.Equal(t, want, db.ImportJSON(bytes.NewReader(line)), key)
+		}
+		// Nothing was imported.
+		require.NoError(t, db.View(func(txn *Txn) error {
+			opt := DefaultIteratorOptions
+			opt.InternalAccess = true
+			it := txn.NewIterator(opt)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				require.False(t, bytes.Equal(it.Item().Key(), []byte("!badger!txn-key")))
+			}
+			return nil
+		}))
+	})
+}
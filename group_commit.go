@@ -0,0 +1,130 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// GroupCommitStats holds the counters of the group commits, as returned by DB.GroupCommitStats.
+type GroupCommitStats struct {
+	// Syncs is the number of syncs of the value log which acknowledged a group of commits.
+	Syncs uint64
+	// Commits is the number of commits acknowledged by the syncs.
+	Commits uint64
+	// AvgGroupSize is the average number of commits acknowledged by a sync.
+	AvgGroupSize float64
+	// AvgSyncLatency is the average time taken by a sync.
+	AvgSyncLatency time.Duration
+}
+
+// groupCommitter acknowledges the written requests once the value log is synced, in groups, for
+// opt.GroupCommitInterval and opt.GroupCommitSize.
+type groupCommitter struct {
+	db *DB
+	// writeRequests hands the written requests over to reqCh, in the order they were written.
+	reqCh chan []*request
+
+	// Accessed via atomics.
+	numSyncs    uint64
+	numCommits  uint64
+	syncLatency int64 // Total, in nanoseconds.
+}
+
+func newGroupCommitter(db *DB) *groupCommitter {
+	return &groupCommitter{db: db, reqCh: make(chan []*request, kvWriteChCapacity)}
+}
+
+// run groups the requests of reqCh, and acknowledges them once the value log is synced.
+func (g *groupCommitter) run(lc *y.Closer) {
+	defer lc.Done()
+
+	var pending []*request
+	var deadline <-chan time.Time // Nil while no request is pending.
+	add := func(reqs []*request) {
+		if len(pending) == 0 {
+			deadline = time.After(g.db.opt.GroupCommitInterval)
+		}
+		pending = append(pending, reqs...)
+	}
+	commit := func() {
+		if len(pending) == 0 {
+			return
+		}
+		start := time.Now()
+		// The value log files before the latest one were synced when they were rotated.
+		err := g.db.vlog.sync(math.MaxUint32)
+		if err != nil {
+			g.db.opt.logger(LogComponentWrite).Errorf("While syncing the group commit: %v", err)
+		} else {
+			atomic.AddUint64(&g.numSyncs, 1)
+			atomic.AddUint64(&g.numCommits, uint64(len(pending)))
+			atomic.AddInt64(&g.syncLatency, int64(time.Since(start)))
+		}
+		for _, r := range pending {
+			r.Err = err
+			r.Wg.Done()
+		}
+		pending = pending[:0]
+		deadline = nil
+	}
+
+	for {
+		select {
+		case reqs := <-g.reqCh:
+			add(reqs)
+			if size := g.db.opt.GroupCommitSize; size > 0 && len(pending) >= size {
+				commit()
+			}
+		case <-deadline:
+			commit()
+		case <-lc.HasBeenClosed():
+			// The writes are stopped before, so reqCh only holds the last requests.
+			for {
+				select {
+				case reqs := <-g.reqCh:
+					add(reqs)
+				default:
+					commit()
+					return
+				}
+			}
+		}
+	}
+}
+
+// GroupCommitStats returns the counters of the group commits. They're all zero unless
+// Options.GroupCommitInterval is set.
+func (db *DB) GroupCommitStats() GroupCommitStats {
+	g := db.groupCommit
+	if g == nil {
+		return GroupCommitStats{}
+	}
+	s := GroupCommitStats{
+		Syncs:   atomic.LoadUint64(&g.numSyncs),
+		Commits: atomic.LoadUint64(&g.numCommits),
+	}
+	if s.Syncs > 0 {
+		s.AvgGroupSize = float64(s.Commits) / float64(s.Syncs)
+		s.AvgSyncLatency = time.Duration(atomic.LoadInt64(&g.syncLatency) / int64(s.Syncs))
+	}
+	return s
+}
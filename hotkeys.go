@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// KeyHeat is one of the most accessed keys reported by DB.HotKeys.
+type KeyHeat struct {
+	Key []byte
+	// Accesses estimates the number of Txn.Get and Txn.Set calls on the key since the DB was
+	// opened. It's extrapolated from the sampled calls, so it's only meaningful for hot keys.
+	Accesses uint64
+}
+
+// The count-min sketch of the sampled accesses holds hotKeysDepth rows of hotKeysWidth counters,
+// 64KB in all.
+const (
+	hotKeysDepth = 4
+	hotKeysWidth = 1 << 12
+)
+
+// hotKeys tracks the most accessed keys, see Options.NumHotKeys. The accesses are sampled, and
+// counted in a count-min sketch, which estimates the count of any key in bounded memory. The keys
+// with the largest estimates are kept aside, up to n of them.
+type hotKeys struct {
+	n        int
+	sampling uint64
+	calls    uint64 // Accessed via atomics.
+
+	sync.Mutex
+	sketch [hotKeysDepth][hotKeysWidth]uint32
+	top    map[string]uint32 // Estimated sampled accesses of the hottest keys.
+}
+
+func newHotKeys(n, sampling int) *hotKeys {
+	if sampling < 1 {
+		sampling = 1
+	}
+	return &hotKeys{n: n, sampling: uint64(sampling), top: make(map[string]uint32, n+1)}
+}
+
+// record counts an access to key, if it's sampled.
+func (h *hotKeys) record(key []byte) {
+	if atomic.AddUint64(&h.calls, 1)%h.sampling != 0 {
+		return
+	}
+	hash := z.MemHash(key)
+	h1, h2 := uint32(hash), uint32(hash>>32)
+
+	h.Lock()
+	defer h.Unlock()
+	// The estimate is the smallest of the counters of the key, the others also count other keys.
+	est := ^uint32(0)
+	for i := range h.sketch {
+		c := &h.sketch[i][(h1+uint32(i)*h2)%hotKeysWidth]
+		if *c < ^uint32(0) {
+			*c++
+		}
+		if *c < est {
+			est = *c
+		}
+	}
+
+	if _, ok := h.top[string(key)]; ok || len(h.top) < h.n {
+		h.top[string(key)] = est
+		return
+	}
+	// Replace the coldest of the hot keys, if this one is now hotter.
+	var coldest string
+	min := est
+	for k, c := range h.top {
+		if c < min {
+			coldest, min = k, c
+		}
+	}
+	if min < est {
+		delete(h.top, coldest)
+		h.top[string(key)] = est
+	}
+}
+
+func (h *hotKeys) load() []KeyHeat {
+	h.Lock()
+	defer h.Unlock()
+	out := make([]KeyHeat, 0, len(h.top))
+	for k, c := range h.top {
+		out = append(out, KeyHeat{Key: []byte(k), Accesses: uint64(c) * h.sampling})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Accesses != out[j].Accesses {
+			return out[i].Accesses > out[j].Accesses
+		}
+		return string(out[i].Key) < string(out[j].Key)
+	})
+	return out
+}
+
+// HotKeys returns the most accessed keys, with Txn.Get and Txn.Set, since the DB was opened, the
+// hottest first. It returns nil unless Options.NumHotKeys is set.
+func (db *DB) HotKeys() []KeyHeat {
+	if db.hotKeys == nil {
+		return nil
+	}
+	return db.hotKeys.load()
+}
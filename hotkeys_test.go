@@ -0,0 +1,73 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotKeys(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("key"), []byte("val"))
+		}))
+		require.Nil(t, db.HotKeys())
+	})
+
+	opt := getTestOptions("").WithNumHotKeys(3).WithHotKeySampling(4)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		wb := db.NewWriteBatch()
+		for i := 0; i < 2000; i++ {
+			require.NoError(t, wb.Set([]byte(fmt.Sprintf("cold%04d", i)), []byte("val")))
+			require.NoError(t, wb.Set([]byte("hot1"), []byte("val")))
+			if i%2 == 0 {
+				require.NoError(t, wb.Set([]byte("hot2"), []byte("val")))
+			}
+		}
+		require.NoError(t, wb.Flush())
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < 300; i++ {
+				if _, err := txn.Get([]byte("hot3")); err != ErrKeyNotFound {
+					return err
+				}
+			}
+			// Exists and GetMulti count as reads too.
+			for i := 0; i < 150; i++ {
+				if _, err := txn.Exists([]byte("hot3")); err != nil {
+					return err
+				}
+				if _, err := txn.GetMulti([][]byte{[]byte("hot3")}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		hot := db.HotKeys()
+		require.Len(t, hot, 3)
+		for i, want := range []struct {
+			key      string
+			accesses uint64
+		}{{"hot1", 2000}, {"hot2", 1000}, {"hot3", 600}} {
+			require.Equal(t, want.key, string(hot[i].Key))
+			require.InDelta(t, want.accesses, hot[i].Accesses, float64(want.accesses)/3)
+		}
+	})
+}
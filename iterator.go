@@ -18,12 +18,14 @@ package badger
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"hash/crc32"
+	"math"
 	"sort"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/table"
@@ -49,12 +51,14 @@ type Item struct {
 	vptr      []byte
 	meta      byte // We need to store meta to know about bitValuePointer.
 	userMeta  byte
+	metadata  []byte // Copied from the value by trimMetadata, unless it is stored in the LSM tree.
 	expiresAt uint64
 	val       []byte
 	slice     *y.Slice // Used only during prefetching.
 	next      *Item
 	version   uint64
 	txn       *Txn
+	keysOnly  bool // Set if the item was returned by a KeysOnly iterator, vptr is then nil.
 }
 
 // String returns a string representation of Item
@@ -93,6 +97,9 @@ func (item *Item) Version() uint64 {
 // instead, or copy it yourself. Value might change once discard or commit is called.
 // Use ValueCopy if you want to do a Set after Get.
 func (item *Item) Value(fn func(val []byte) error) error {
+	if item.keysOnly {
+		return ErrKeyOnlyIteration
+	}
 	item.wg.Wait()
 	if item.status == prefetched {
 		if item.err == nil && fn != nil {
@@ -120,6 +127,9 @@ func (item *Item) Value(fn func(val []byte) error) error {
 // This function is useful in long running iterate/update transactions to avoid a write deadlock.
 // See Github issue: https://github.com/dgraph-io/badger/issues/315
 func (item *Item) ValueCopy(dst []byte) ([]byte, error) {
+	if item.keysOnly {
+		return nil, ErrKeyOnlyIteration
+	}
 	item.wg.Wait()
 	if item.status == prefetched {
 		return y.SafeCopy(dst, item.val), item.err
@@ -129,6 +139,24 @@ func (item *Item) ValueCopy(dst []byte) ([]byte, error) {
 	return y.SafeCopy(dst, buf), err
 }
 
+// ValueCopyTo appends the value of the item to dst and returns the extended slice, following the
+// convention of append: dst is only reallocated if its capacity isn't sufficient. Unlike
+// ValueCopy, which overwrites dst, this allows to accumulate values into a buffer, or to reuse a
+// pooled buffer across calls by passing buf[:0]. The returned slice doesn't share memory with the
+// item, the value log or the block cache.
+func (item *Item) ValueCopyTo(dst []byte) ([]byte, error) {
+	if item.keysOnly {
+		return dst, ErrKeyOnlyIteration
+	}
+	item.wg.Wait()
+	if item.status == prefetched {
+		return append(dst, item.val...), item.err
+	}
+	buf, cb, err := item.yieldItemValue()
+	defer runCallback(cb)
+	return append(dst, buf...), err
+}
+
 func (item *Item) hasValue() bool {
 	if item.meta == 0 && item.vptr == nil {
 		// key not found
@@ -139,7 +167,7 @@ func (item *Item) hasValue() bool {
 
 // IsDeletedOrExpired returns true if item contains deleted or expired value.
 func (item *Item) IsDeletedOrExpired() bool {
-	return isDeletedOrExpired(item.meta, item.expiresAt)
+	return isDeletedOrExpired(item.meta, item.expiresAt, item.db.opt.now())
 }
 
 // DiscardEarlierVersions returns whether the item was created with the
@@ -148,6 +176,16 @@ func (item *Item) DiscardEarlierVersions() bool {
 	return item.meta&bitDiscardEarlierVersions > 0
 }
 
+// moveKey returns the key under which the value log GC moves the value of the item.
+func (item *Item) moveKey() []byte {
+	// Do not put badgerMove on the left in append. It seems to cause some sort of manipulation.
+	keyTs := y.KeyWithTs(item.Key(), item.Version())
+	key := make([]byte, len(badgerMove)+len(keyTs))
+	n := copy(key, badgerMove)
+	copy(key[n:], keyTs)
+	return key
+}
+
 func (item *Item) yieldItemValue() ([]byte, func(), error) {
 	key := item.Key() // No need to copy.
 	for {
@@ -162,13 +200,16 @@ func (item *Item) yieldItemValue() ([]byte, func(), error) {
 		if (item.meta & bitValuePointer) == 0 {
 			val := item.slice.Resize(len(item.vptr))
 			copy(val, item.vptr)
-			return val, nil, nil
+			return item.trimMetadata(val), nil, nil
 		}
 
 		var vp valuePointer
 		vp.Decode(item.vptr)
 		result, cb, err := item.db.vlog.Read(vp, item.slice)
 		if err != ErrRetry {
+			if err == nil {
+				result = item.trimMetadata(result)
+			}
 			return result, cb, err
 		}
 		if bytes.HasPrefix(key, badgerMove) {
@@ -181,11 +222,7 @@ func (item *Item) yieldItemValue() ([]byte, func(), error) {
 		// The value pointer is pointing to a deleted value log. Look for the
 		// move key and read that instead.
 		runCallback(cb)
-		// Do not put badgerMove on the left in append. It seems to cause some sort of manipulation.
-		keyTs := y.KeyWithTs(item.Key(), item.Version())
-		key = make([]byte, len(badgerMove)+len(keyTs))
-		n := copy(key, badgerMove)
-		copy(key[n:], keyTs)
+		key = item.moveKey()
 		// Note that we can't set item.key to move key, because that would
 		// change the key user sees before and after this call. Also, this move
 		// logic is internal logic and should not impact the external behavior
@@ -207,6 +244,16 @@ func (item *Item) yieldItemValue() ([]byte, func(), error) {
 	}
 }
 
+// trimMetadata returns the value val read for the item without its metadata, which it keeps.
+func (item *Item) trimMetadata(val []byte) []byte {
+	if item.meta&bitMetadata == 0 {
+		return val
+	}
+	md, val := splitMetadata(val)
+	item.metadata = y.SafeCopy(item.metadata, md)
+	return val
+}
+
 func runCallback(cb func()) {
 	if cb != nil {
 		cb()
@@ -237,6 +284,9 @@ func (item *Item) prefetchValue() {
 // size of a range of key-value pairs (without fetching the corresponding
 // values).
 func (item *Item) EstimatedSize() int64 {
+	if item.keysOnly {
+		return int64(len(item.key))
+	}
 	if !item.hasValue() {
 		return 0
 	}
@@ -254,24 +304,60 @@ func (item *Item) KeySize() int64 {
 	return int64(len(item.key))
 }
 
-// ValueSize returns the approximate size of the value.
+// ValueSize returns the size of the value, without fetching it. It can be called before Value, to
+// preallocate a buffer, or to handle big values differently than small ones.
 //
-// This can be called to quickly estimate the size of a value without fetching
-// it.
+// The size doesn't include the metadata of the entry. For values stored in the value log, it is read
+// from the header of the entry, and from the manifest of the chunks for the values written in
+// chunks, without reading the value itself. The values moved by the value log GC are looked up
+// like Value does. If the entry can't be read, the size is estimated from the length of the entry
+// found in the value pointer, minus the length of its key, header and checksum. The estimate
+// includes the metadata, if any, plus one byte, and is the size of the manifest for a chunked
+// value.
 func (item *Item) ValueSize() int64 {
-	if !item.hasValue() {
+	if item.keysOnly || !item.hasValue() {
 		return 0
 	}
 	if (item.meta & bitValuePointer) == 0 {
-		return int64(len(item.vptr))
+		_, val := item.splitMetadata()
+		return int64(len(val))
 	}
 	var vp valuePointer
 	vp.Decode(item.vptr)
+	size, err := item.db.vlog.readValueSize(vp)
+	if err == ErrRetry && !bytes.HasPrefix(item.key, badgerMove) {
+		// The value log file was deleted by the GC, which moved the value under the move key.
+		vs, gerr := item.db.get(item.moveKey())
+		switch {
+		case gerr != nil || vs.Version != item.Version():
+		case vs.Meta&bitValuePointer == 0:
+			val := vs.Value
+			if vs.Meta&bitMetadata != 0 {
+				_, val = splitMetadata(val)
+			}
+			return int64(len(val))
+		default:
+			vp.Decode(vs.Value)
+			size, err = item.db.vlog.readValueSize(vp)
+		}
+	}
+	if err == nil {
+		return size
+	}
 
-	klen := int64(len(item.key) + 8) // 8 bytes for timestamp.
-	// 6 bytes are for the approximate length of the header. Since header is encoded in varint, we
-	// cannot find the exact length of header without fetching it.
-	return int64(vp.Len) - klen - 6 - crc32.Size
+	var buf [binary.MaxVarintLen64]byte
+	klen := len(item.key) + 8 // 8 bytes for timestamp.
+	// The header holds the meta and user meta bytes, and the varints of the key length, the value
+	// length and the expiration time. Only the size of the value length varint is unknown.
+	rest := int64(vp.Len) - int64(klen) - crc32.Size - 2 -
+		int64(binary.PutUvarint(buf[:], uint64(klen))) -
+		int64(binary.PutUvarint(buf[:], item.expiresAt))
+	for n := int64(1); n <= binary.MaxVarintLen32; n++ {
+		if rest-n >= 0 && int64(binary.PutUvarint(buf[:], uint64(rest-n))) == n {
+			return rest - n
+		}
+	}
+	return 0
 }
 
 // UserMeta returns the userMeta set by the user. Typically, this byte, optionally set by the user
@@ -280,6 +366,42 @@ func (item *Item) UserMeta() byte {
 	return item.userMeta
 }
 
+// Metadata returns the blob set with Entry.WithMetadata, or nil if none was set.
+//
+// The metadata is stored along with the value. If the value is stored in the value log, and it
+// wasn't read or prefetched yet, the value is read from the value log as by Value. Items returned
+// by an iterator created with IteratorOptions.KeysOnly return ErrKeyOnlyIteration in that case.
+//
+// The returned slice is only valid as long as item is valid, or transaction is valid.
+func (item *Item) Metadata() ([]byte, error) {
+	if item.meta&bitMetadata == 0 || item.metadata != nil {
+		return item.metadata, nil
+	}
+	if item.keysOnly {
+		return nil, ErrKeyOnlyIteration
+	}
+	if (item.meta & bitValuePointer) == 0 {
+		md, _ := item.splitMetadata()
+		return md, nil
+	}
+	item.wg.Wait()
+	if item.status == prefetched {
+		return item.metadata, item.err
+	}
+	_, cb, err := item.yieldItemValue()
+	runCallback(cb)
+	return item.metadata, err
+}
+
+// splitMetadata splits the value of an item stored in the LSM tree into its metadata and the
+// value itself.
+func (item *Item) splitMetadata() (md, val []byte) {
+	if item.meta&bitMetadata == 0 {
+		return nil, item.vptr
+	}
+	return splitMetadata(item.vptr)
+}
+
 // ExpiresAt returns a Unix time value indicating when the item will be
 // considered expired. 0 indicates that the item will never expire.
 func (item *Item) ExpiresAt() uint64 {
@@ -329,8 +451,13 @@ type IteratorOptions struct {
 	PrefetchValues bool
 	// How many KV pairs to prefetch while iterating. Valid only if PrefetchValues is true.
 	PrefetchSize int
-	Reverse      bool // Direction of iteration. False is forward, true is backward.
-	AllVersions  bool // Fetch all valid versions of the same key.
+	// PrefetchConcurrency, if positive, bounds the number of values read at once while
+	// prefetching. The reads are then handed to that many goroutines, run for the lifetime of the
+	// iterator, instead of a goroutine per value, which reads up to PrefetchSize values at once.
+	// The values are still delivered in key order. Valid only if PrefetchValues is true.
+	PrefetchConcurrency int
+	Reverse             bool // Direction of iteration. False is forward, true is backward.
+	AllVersions         bool // Fetch all valid versions of the same key.
 
 	// The following option is used to narrow down the SSTables that iterator picks up. If
 	// Prefix is specified, only tables which could have this prefix are picked based on their range
@@ -338,7 +465,63 @@ type IteratorOptions struct {
 	Prefix      []byte // Only iterate over this given prefix.
 	prefixIsKey bool   // If set, use the prefix for bloom filter lookup.
 
+	skipPendingWrites bool         // If set, don't iterate over the pending writes of the txn.
+	cmp               y.Comparator // The order of the keys of the DB, set by NewIterator.
+
+	// Bound is the key at which iteration stops. Iterating forward, only keys < Bound are
+	// returned, so [start, Bound) can be scanned with Seek(start). Iterating in reverse, only
+	// keys >= Bound are returned. Tables and blocks which are entirely out of bound are not read.
+	Bound []byte
+
+	// Context, if set, stops the iteration once it is done. Valid then returns false, and Err
+	// returns the error of the context. To keep the overhead low, the context is checked once
+	// every ContextCheckInterval internal keys, table.DefaultContextCheckInterval by default.
+	Context              context.Context
+	ContextCheckInterval int
+
 	InternalAccess bool // Used to allow internal access to badger keys.
+
+	// SampleEveryN, if positive, makes the iterator return about one key every SampleEveryN
+	// entries, to cheaply build histograms, estimate sizes or pick split keys for parallel scans.
+	// Instead of stepping over the keys in between, the iterator seeks to the next sample key,
+	// picked among the first keys of the blocks of the tables, as found in the table indices.
+	//
+	// Sampling is approximate: the samples are aligned to block boundaries, and it is
+	// version-unaware, the entries are counted whatever their version, so keys with many versions
+	// or deleted keys weigh as much as live ones, and skipping a deleted key moves the iterator to
+	// the next sample too. The keys only found in the memtables don't make
+	// samples, so they are mostly skipped.
+	SampleEveryN int
+
+	// KeysOnly makes the iterator only return keys, for scans which never read the values, like
+	// building an index of the keys. The value pointers aren't copied into the items, the values
+	// aren't prefetched, and Item.Value, ValueCopy and ValueCopyTo return ErrKeyOnlyIteration.
+	// Item.ValueSize returns 0, and Item.EstimatedSize the size of the key. The deleted and
+	// expired keys are skipped like with any iterator, and the version, user meta and expiration
+	// time of the items are set.
+	KeysOnly bool
+
+	// Offset is the number of items skipped after each Seek or Rewind, and Limit, if positive,
+	// the number of items returned after them, Valid returning false once Limit items have been
+	// consumed with Next. Both count the items within Prefix and Bound, which makes paginating a
+	// prefix or a range a matter of moving Offset by Limit. The skipped items are not filled, so
+	// their values are neither read nor prefetched.
+	//
+	// With AllVersions, every version is an item, so Offset and Limit count versions, not
+	// distinct keys. Otherwise, the deleted and expired keys are not counted.
+	Offset int
+	Limit  int
+
+	// MaxVersion, if positive, makes the iterator only see the versions <= MaxVersion, for scans
+	// as of a point in time older than the read timestamp of the transaction. The versions above
+	// it are skipped in the memtables and the tables alike, like the ones above the read
+	// timestamp, and so are the pending writes of the transaction.
+	//
+	// It can only be used with managed transactions, NewIterator panics otherwise. The versions
+	// are discarded by compactions once they are below the discard timestamp set with
+	// DB.SetDiscardTs, and shadowed by a newer version, so MaxVersion should not be set below the
+	// discard timestamp, the iterator would see a mix of the versions left.
+	MaxVersion uint64
 }
 
 func (opt *IteratorOptions) compareToPrefix(key []byte) int {
@@ -347,10 +530,33 @@ func (opt *IteratorOptions) compareToPrefix(key []byte) int {
 	if len(key) > len(opt.Prefix) {
 		key = key[:len(opt.Prefix)]
 	}
-	return bytes.Compare(key, opt.Prefix)
+	return opt.cmp.Compare(key, opt.Prefix)
+}
+
+// outOfBound returns true if key, which is without timestamp, is beyond opt.Bound.
+func (opt *IteratorOptions) outOfBound(key []byte) bool {
+	if len(opt.Bound) == 0 {
+		return false
+	}
+	cmp := opt.cmp.Compare(key, opt.Bound)
+	if !opt.Reverse {
+		return cmp >= 0
+	}
+	return cmp < 0
+}
+
+// tableOutOfBound returns true if all the keys of the table are beyond opt.Bound.
+func (opt *IteratorOptions) tableOutOfBound(t table.TableInterface) bool {
+	if !opt.Reverse {
+		return opt.outOfBound(y.ParseKey(t.Smallest()))
+	}
+	return opt.outOfBound(y.ParseKey(t.Biggest()))
 }
 
 func (opt *IteratorOptions) pickTable(t table.TableInterface) bool {
+	if opt.tableOutOfBound(t) {
+		return false
+	}
 	if len(opt.Prefix) == 0 {
 		return true
 	}
@@ -371,6 +577,22 @@ func (opt *IteratorOptions) pickTable(t table.TableInterface) bool {
 // pickTables picks the necessary table for the iterator. This function also assumes
 // that the tables are sorted in the right order.
 func (opt *IteratorOptions) pickTables(all []*table.Table) []*table.Table {
+	out := opt.pickTablesForPrefix(all)
+	if len(opt.Bound) == 0 {
+		return out
+	}
+	// The tables don't overlap, so the ones out of bound are at one end.
+	if !opt.Reverse {
+		return out[:sort.Search(len(out), func(i int) bool {
+			return opt.tableOutOfBound(out[i])
+		})]
+	}
+	return out[sort.Search(len(out), func(i int) bool {
+		return !opt.tableOutOfBound(out[i])
+	}):]
+}
+
+func (opt *IteratorOptions) pickTablesForPrefix(all []*table.Table) []*table.Table {
 	if len(opt.Prefix) == 0 {
 		out := make([]*table.Table, len(all))
 		copy(out, all)
@@ -433,6 +655,17 @@ type Iterator struct {
 
 	lastKey []byte // Used to skip over multiple versions of the same key.
 
+	consumed int  // Number of items consumed with Next since the last Seek, for opt.Limit.
+	skipping bool // Set while skipping opt.Offset items, which don't need to be filled.
+
+	// prefetchCh feeds the items to prefetch to the workers, if opt.PrefetchConcurrency is set.
+	prefetchCh chan *Item
+	prefetchWg sync.WaitGroup
+
+	// The key space Progress estimates the position in, computed on its first call.
+	progressLo, progressHi []byte
+	progressInit           bool
+
 	closed bool
 }
 
@@ -447,19 +680,23 @@ func (txn *Txn) NewIterator(opt IteratorOptions) *Iterator {
 	if txn.discarded {
 		panic("Transaction has already been discarded")
 	}
+	if opt.MaxVersion > 0 && !txn.db.opt.managedTxns {
+		panic("IteratorOptions.MaxVersion can only be used with managed transactions")
+	}
 	// Do not change the order of the next if. We must track the number of running iterators.
 	if atomic.AddInt32(&txn.numIterators, 1) > 1 && txn.update {
 		atomic.AddInt32(&txn.numIterators, -1)
 		panic("Only one iterator can be active at one time, for a RW txn.")
 	}
 
+	opt.cmp = txn.db.cmp
 	// TODO: If Prefix is set, only pick those memtables which have keys with
 	// the prefix.
 	tables, decr := txn.db.getMemTables()
 	defer decr()
 	txn.db.vlog.incrIteratorCount()
 	var iters []y.Iterator
-	if itr := txn.newPendingWritesIterator(opt.Reverse); itr != nil {
+	if itr := txn.newPendingWritesIterator(opt.Reverse); itr != nil && !opt.skipPendingWrites {
 		iters = append(iters, itr)
 	}
 	for i := 0; i < len(tables); i++ {
@@ -469,13 +706,51 @@ func (txn *Txn) NewIterator(opt IteratorOptions) *Iterator {
 
 	res := &Iterator{
 		txn:    txn,
-		iitr:   table.NewMergeIterator(iters, opt.Reverse),
+		iitr:   table.NewMergeIteratorWithComparator(iters, opt.Reverse, opt.cmp, nil),
 		opt:    opt,
 		readTs: txn.readTs,
 	}
+	if opt.MaxVersion > 0 && opt.MaxVersion < res.readTs {
+		res.readTs = opt.MaxVersion
+	}
+	if b, ok := res.iitr.(interface{ SetBound([]byte) }); ok && len(opt.Bound) > 0 {
+		// The smallest key with timestamp for opt.Bound.
+		b.SetBound(y.KeyWithTs(opt.Bound, math.MaxUint64))
+	}
+	if opt.SampleEveryN > 0 {
+		samples := txn.db.lc.sampleKeys(&opt, opt.SampleEveryN)
+		res.iitr = table.NewSampleIterator(res.iitr, samples, opt.Reverse, opt.cmp)
+	}
+	if opt.Context != nil {
+		res.iitr = table.NewContextIterator(opt.Context, res.iitr, opt.ContextCheckInterval)
+	}
+	if opt.PrefetchValues && opt.PrefetchConcurrency > 0 && !opt.KeysOnly {
+		res.startPrefetchWorkers()
+	}
 	return res
 }
 
+// startPrefetchWorkers starts the opt.PrefetchConcurrency goroutines prefetching the values.
+func (it *Iterator) startPrefetchWorkers() {
+	n := it.opt.PrefetchConcurrency
+	if it.opt.PrefetchSize > 0 && n > it.opt.PrefetchSize {
+		n = it.opt.PrefetchSize
+	}
+	// The buffer lets fill queue a whole prefetch without waiting for the workers.
+	it.prefetchCh = make(chan *Item, it.opt.PrefetchSize+1)
+	it.prefetchWg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer it.prefetchWg.Done()
+			for item := range it.prefetchCh {
+				// FIXME we are not handling errors here.
+				item.prefetchValue()
+				item.wg.Done()
+			}
+		}()
+	}
+}
+
 // NewKeyIterator is just like NewIterator, but allows the user to iterate over all versions of a
 // single key. Internally, it sets the Prefix option in provided opt, and uses that prefix to
 // additionally run bloom filter lookups before picking tables from the LSM tree.
@@ -510,10 +785,29 @@ func (it *Iterator) Valid() bool {
 	if it.item == nil {
 		return false
 	}
+	if it.opt.Limit > 0 && it.consumed >= it.opt.Limit {
+		return false
+	}
+	return it.inRange(it.item.key)
+}
+
+// inRange returns true if key, which is without timestamp, is within opt.Prefix and opt.Bound.
+func (it *Iterator) inRange(key []byte) bool {
+	if it.opt.outOfBound(key) {
+		// Only needed if the merged iterator doesn't support bounds.
+		return false
+	}
 	if it.opt.prefixIsKey {
-		return bytes.Equal(it.item.key, it.opt.Prefix)
+		return bytes.Equal(key, it.opt.Prefix)
 	}
-	return bytes.HasPrefix(it.item.key, it.opt.Prefix)
+	return bytes.HasPrefix(key, it.opt.Prefix)
+}
+
+// Err returns the error which stopped the iteration, if any. Valid returns false
+// once an error is hit, for example while reading a corrupted table, so Err should
+// be checked after the iteration is done.
+func (it *Iterator) Err() error {
+	return y.IteratorErr(it.iitr)
 }
 
 // ValidForPrefix returns false when iteration is done
@@ -522,6 +816,167 @@ func (it *Iterator) ValidForPrefix(prefix []byte) bool {
 	return it.Valid() && bytes.HasPrefix(it.item.key, prefix)
 }
 
+// Progress returns a rough estimate of how far the iterator went through the keys it iterates
+// over, as a fraction between 0 and 1, for a progress bar. It returns 1 once the iterator isn't
+// valid.
+//
+// The estimate doesn't count the keys. It is the position of the current key between the smallest
+// and the biggest keys of the DB, as told by the bounds of the tables and of the memtables, and
+// narrowed down to IteratorOptions.Prefix and IteratorOptions.Bound. The keys are mapped to a
+// fraction from their first 8 bytes past the prefix shared by these bounds, so the estimate
+// assumes the keys are spread uniformly over that range, and ordered bytewise, which doesn't hold
+// with a custom Options.KeyComparator. A skewed key distribution makes it move unevenly, and it
+// doesn't account for where Seek started from. The bounds are read on the first call, the keys
+// written since aren't taken into account.
+func (it *Iterator) Progress() float64 {
+	if !it.Valid() {
+		return 1
+	}
+	if !it.progressInit {
+		it.progressLo, it.progressHi = it.progressBounds()
+		it.progressInit = true
+	}
+	f := keyFraction(it.txn.db.cmp, it.progressLo, it.progressHi, it.item.key)
+	if it.opt.Reverse {
+		return 1 - f
+	}
+	return f
+}
+
+// progressBounds returns the smallest and the biggest keys of the DB, without timestamp, within
+// opt.Prefix and opt.Bound.
+func (it *Iterator) progressBounds() (lo, hi []byte) {
+	db := it.txn.db
+	// The keys the iterator can return are between from and to, nil when unbounded.
+	var from, to []byte
+	if p := it.opt.Prefix; len(p) > 0 {
+		from = p
+		to = append(y.SafeCopy(nil, p), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	}
+	if b := it.opt.Bound; len(b) > 0 {
+		if it.opt.Reverse && (from == nil || db.cmp.Compare(b, from) > 0) {
+			from = b
+		}
+		if !it.opt.Reverse && (to == nil || db.cmp.Compare(b, to) < 0) {
+			to = b
+		}
+	}
+	inRange := func(key []byte) bool {
+		return !bytes.HasPrefix(key, badgerPrefix) &&
+			(from == nil || db.cmp.Compare(key, from) >= 0) &&
+			(to == nil || db.cmp.Compare(key, to) <= 0)
+	}
+	update := func(l, h []byte) {
+		if l != nil && (lo == nil || db.cmp.Compare(l, lo) < 0) {
+			lo = l
+		}
+		if h != nil && (hi == nil || db.cmp.Compare(h, hi) > 0) {
+			hi = h
+		}
+	}
+
+	tables, decr := db.getMemTables()
+	for _, mt := range tables {
+		if !mt.Empty() {
+			update(keyBound(mt.NewUniIterator(false), false, from, to, db.cmp),
+				keyBound(mt.NewUniIterator(true), true, from, to, db.cmp))
+		}
+	}
+	decr()
+	for _, l := range db.lc.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			smallest, biggest := y.ParseKey(t.Smallest()), y.ParseKey(t.Biggest())
+			if (from != nil && db.cmp.Compare(biggest, from) < 0) ||
+				(to != nil && db.cmp.Compare(smallest, to) > 0) {
+				continue
+			}
+			// The bounds of the table are only looked up if they're out of range.
+			if !inRange(smallest) {
+				smallest = keyBound(t.NewIterator(false), false, from, to, db.cmp)
+			}
+			if !inRange(biggest) {
+				biggest = keyBound(t.NewIterator(true), true, from, to, db.cmp)
+			}
+			update(smallest, biggest)
+		}
+		l.RUnlock()
+	}
+	return lo, hi
+}
+
+// keyBound returns the smallest key without timestamp of itr, a table or memtable iterator,
+// between from and to, or the biggest one if itr is reversed. It skips the internal keys, and
+// returns nil if there's no such key. It closes itr.
+func keyBound(itr y.Iterator, reversed bool, from, to []byte, cmp y.Comparator) []byte {
+	defer itr.Close()
+	// The internal keys all sort between badgerPrefix and after, which sorts right after them.
+	after := y.SafeCopy(nil, badgerPrefix)
+	after[len(after)-1]++
+	switch {
+	case !reversed && from == nil:
+		itr.Rewind()
+	case !reversed:
+		itr.Seek(y.KeyWithTs(from, math.MaxUint64))
+	case to == nil:
+		itr.Rewind()
+	default:
+		itr.Seek(y.KeyWithTs(to, 0))
+	}
+	if itr.Valid() && bytes.HasPrefix(y.ParseKey(itr.Key()), badgerPrefix) {
+		if !reversed {
+			itr.Seek(y.KeyWithTs(after, math.MaxUint64))
+		} else {
+			itr.Seek(y.KeyWithTs(badgerPrefix, math.MaxUint64))
+		}
+	}
+	if !itr.Valid() {
+		return nil
+	}
+	key := y.ParseKey(itr.Key())
+	if (from != nil && cmp.Compare(key, from) < 0) || (to != nil && cmp.Compare(key, to) > 0) {
+		return nil
+	}
+	return y.SafeCopy(nil, key)
+}
+
+// keyFraction returns the position of key between lo and hi, as a fraction between 0 and 1. The
+// keys are mapped to numbers from their first 8 bytes past the prefix shared by lo and hi, the keys
+// out of [lo, hi] in the order of cmp being clamped to 0 or 1.
+func keyFraction(cmp y.Comparator, lo, hi, key []byte) float64 {
+	var n int
+	for n < len(lo) && n < len(hi) && lo[n] == hi[n] {
+		n++
+	}
+	toNum := func(k []byte) float64 {
+		var b [8]byte
+		if len(k) > n {
+			copy(b[:], k[n:])
+		}
+		return float64(binary.BigEndian.Uint64(b[:]))
+	}
+	switch {
+	case cmp.Compare(key, lo) <= 0:
+		return 0
+	case cmp.Compare(key, hi) >= 0:
+		return 1
+	}
+	// Past the checks above, key shares the prefix of lo and hi, unless the keys aren't ordered
+	// bytewise.
+	l, h := toNum(lo), toNum(hi)
+	if h <= l {
+		return 0
+	}
+	f := (toNum(key) - l) / (h - l)
+	switch {
+	case f < 0:
+		return 0
+	case f > 1:
+		return 1
+	}
+	return f
+}
+
 // Close would close the iterator. It is important to call this when you're done with iteration.
 func (it *Iterator) Close() {
 	if it.closed {
@@ -541,6 +996,10 @@ func (it *Iterator) Close() {
 	}
 	waitFor(it.waste)
 	waitFor(it.data)
+	if it.prefetchCh != nil {
+		close(it.prefetchCh)
+		it.prefetchWg.Wait()
+	}
 
 	// TODO: We could handle this error.
 	_ = it.txn.db.vlog.decrIteratorCount()
@@ -557,6 +1016,11 @@ func (it *Iterator) Next() {
 	// Set next item to current
 	it.item = it.data.pop()
 
+	it.consumed++
+	if it.opt.Limit > 0 && it.consumed >= it.opt.Limit {
+		// No need to read ahead, Valid returns false from now on.
+		return
+	}
 	for it.iitr.Valid() {
 		if it.parseItem() {
 			// parseItem calls one extra next.
@@ -566,14 +1030,23 @@ func (it *Iterator) Next() {
 	}
 }
 
-func isDeletedOrExpired(meta byte, expiresAt uint64) bool {
+// isDeletedOrExpired returns true if the entry with the given meta and expiry is deleted, or
+// expired at now, from Options.Clock.
+func isDeletedOrExpired(meta byte, expiresAt, now uint64) bool {
 	if meta&bitDelete > 0 {
 		return true
 	}
 	if expiresAt == 0 {
 		return false
 	}
-	return expiresAt <= uint64(time.Now().Unix())
+	return expiresAt <= now
+}
+
+// rangeDeleted returns true if the given version of key is deleted by a range delete, as seen by
+// the iterator. The range deletes of the transaction are only seen along with its pending writes.
+func (it *Iterator) rangeDeleted(key []byte, version uint64) bool {
+	pending := it.readTs == it.txn.readTs && !it.opt.skipPendingWrites
+	return it.txn.rangeDeleted(key, version, it.readTs, pending)
 }
 
 // parseItem is a complex function because it needs to handle both forward and reverse iteration
@@ -608,6 +1081,11 @@ func (it *Iterator) parseItem() bool {
 	}
 
 	if it.opt.AllVersions {
+		// Versions deleted by a range delete are gone, like versions discarded by compactions.
+		if it.rangeDeleted(y.ParseKey(key), version) {
+			mi.Next()
+			return false
+		}
 		// Return deleted or expired values also, otherwise user can't figure out
 		// whether the key was deleted.
 		item := it.newItem()
@@ -635,7 +1113,8 @@ func (it *Iterator) parseItem() bool {
 FILL:
 	// If deleted, advance and return.
 	vs := mi.Value()
-	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt, it.txn.db.opt.now()) ||
+		it.rangeDeleted(y.ParseKey(mi.Key()), y.ParseTs(mi.Key())) {
 		mi.Next()
 		return false
 	}
@@ -672,10 +1151,20 @@ func (it *Iterator) fill(item *Item) {
 	item.version = y.ParseTs(it.iitr.Key())
 	item.key = y.SafeCopy(item.key, y.ParseKey(it.iitr.Key()))
 
-	item.vptr = y.SafeCopy(item.vptr, vs.Value)
 	item.val = nil
+	item.metadata = nil
+	item.keysOnly = it.opt.KeysOnly
+	if item.keysOnly || it.skipping {
+		item.vptr = nil
+		return
+	}
+	item.vptr = y.SafeCopy(item.vptr, vs.Value)
 	if it.opt.PrefetchValues {
 		item.wg.Add(1)
+		if it.prefetchCh != nil {
+			it.prefetchCh <- item
+			return
+		}
 		go func() {
 			// FIXME we are not handling errors here.
 			item.prefetchValue()
@@ -689,6 +1178,9 @@ func (it *Iterator) prefetch() {
 	if it.opt.PrefetchValues && it.opt.PrefetchSize > 1 {
 		prefetchSize = it.opt.PrefetchSize
 	}
+	if it.opt.Limit > 0 && prefetchSize > it.opt.Limit {
+		prefetchSize = it.opt.Limit
+	}
 
 	i := it.iitr
 	var count int
@@ -706,7 +1198,9 @@ func (it *Iterator) prefetch() {
 
 // Seek would seek to the provided key if present. If absent, it would seek to the next
 // smallest key greater than the provided key if iterating in the forward direction.
-// Behavior would be reversed if iterating backwards.
+// Behavior would be reversed if iterating backwards. When iterating backwards with
+// IteratorOptions.Prefix, seeking to a key past all the keys with the prefix, or to an empty key,
+// moves to the largest key with the prefix.
 func (it *Iterator) Seek(key []byte) {
 	for i := it.data.pop(); i != nil; i = it.data.pop() {
 		i.wg.Wait()
@@ -714,27 +1208,76 @@ func (it *Iterator) Seek(key []byte) {
 	}
 
 	it.lastKey = it.lastKey[:0]
+	it.consumed = 0
+	it.seek(key)
+	it.skipOffset()
+	it.prefetch()
+}
+
+func (it *Iterator) seek(key []byte) {
+	if it.opt.Reverse && len(it.opt.Prefix) > 0 {
+		// Iterating backward, the keys with the prefix are right before the end of the prefix.
+		// Start there if the key is past all of them.
+		end := prefixEnd(it.opt.Prefix)
+		if len(key) == 0 || (len(end) > 0 && it.opt.cmp.Compare(key, end) >= 0) {
+			it.seekBefore(end)
+			return
+		}
+	}
 	if len(key) == 0 {
 		key = it.opt.Prefix
 	}
 	if len(key) == 0 {
 		it.iitr.Rewind()
-		it.prefetch()
 		return
 	}
 
 	if !it.opt.Reverse {
-		key = y.KeyWithTs(key, it.txn.readTs)
+		key = y.KeyWithTs(key, it.readTs)
 	} else {
 		key = y.KeyWithTs(key, 0)
 	}
 	it.iitr.Seek(key)
-	it.prefetch()
+}
+
+// skipOffset steps over the first opt.Offset items, without filling their values. It stops early
+// at the first item out of opt.Prefix or opt.Bound, as all the ones after it are out too.
+func (it *Iterator) skipOffset() {
+	it.item = nil
+	it.skipping = true
+	defer func() { it.skipping = false }()
+	for n := it.opt.Offset; n > 0 && it.iitr.Valid(); {
+		if !it.parseItem() {
+			continue
+		}
+		item := it.item
+		it.item = nil
+		it.waste.push(item)
+		if !it.inRange(item.key) {
+			return
+		}
+		n--
+	}
+}
+
+// seekBefore moves the reverse iterator to the largest key below end, or to the largest key if end
+// is nil.
+func (it *Iterator) seekBefore(end []byte) {
+	if end == nil {
+		it.iitr.Rewind()
+		return
+	}
+	// The smallest version of end is the one with the highest timestamp, skip it if it exists.
+	it.iitr.Seek(y.KeyWithTs(end, math.MaxUint64))
+	for it.iitr.Valid() && bytes.Equal(y.ParseKey(it.iitr.Key()), end) {
+		it.iitr.Next()
+	}
 }
 
 // Rewind would rewind the iterator cursor all the way to zero-th position, which would be the
-// smallest key if iterating forward, and largest if iterating backward. It does not keep track of
-// whether the cursor started with a Seek().
+// smallest key if iterating forward, and largest if iterating backward. With
+// IteratorOptions.Prefix, these are the smallest and the largest keys with the prefix. It does not
+// keep track of whether the cursor started with a Seek().
 func (it *Iterator) Rewind() {
 	it.Seek(nil)
 }
@@ -18,13 +18,17 @@ package badger
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/table"
@@ -300,3 +304,579 @@ func BenchmarkIteratePrefixSingleKey(b *testing.B) {
 		}
 	})
 }
+
+func TestIterateBound(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		bkey := func(i int) []byte {
+			return []byte(fmt.Sprintf("%04d", i))
+		}
+		batch := db.NewWriteBatch()
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, batch.Set(bkey(i), []byte("OK")))
+		}
+		require.NoError(t, batch.Flush())
+
+		keys := func(opt IteratorOptions, seek []byte) []string {
+			var out []string
+			require.NoError(t, db.View(func(txn *Txn) error {
+				itr := txn.NewIterator(opt)
+				defer itr.Close()
+				for itr.Seek(seek); itr.Valid(); itr.Next() {
+					out = append(out, string(itr.Item().Key()))
+				}
+				return nil
+			}))
+			return out
+		}
+
+		opt := DefaultIteratorOptions
+		opt.Bound = bkey(200)
+		got := keys(opt, bkey(190))
+		require.Equal(t, 10, len(got))
+		require.Equal(t, "0190", got[0])
+		require.Equal(t, "0199", got[9])
+
+		opt.Reverse = true
+		got = keys(opt, bkey(209))
+		require.Equal(t, 10, len(got))
+		require.Equal(t, "0209", got[0])
+		require.Equal(t, "0200", got[9])
+
+		opt.Reverse = false
+		opt.Prefix = []byte("01")
+		require.Equal(t, 100, len(keys(opt, nil)))
+		opt.Prefix = []byte("02")
+		require.Equal(t, 0, len(keys(opt, nil)))
+	})
+}
+
+func TestIterateContext(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		batch := db.NewWriteBatch()
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, batch.Set([]byte(fmt.Sprintf("%04d", i)), []byte("OK")))
+		}
+		require.NoError(t, batch.Flush())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		opt := DefaultIteratorOptions
+		opt.Context = ctx
+		opt.ContextCheckInterval = 1
+		require.NoError(t, db.View(func(txn *Txn) error {
+			itr := txn.NewIterator(opt)
+			defer itr.Close()
+			var count int
+			for itr.Rewind(); itr.Valid(); itr.Next() {
+				count++
+				if count == 100 {
+					cancel()
+				}
+			}
+			// Some keys might have been prefetched before the cancellation.
+			require.True(t, count < 1000, "count=%d", count)
+			require.Equal(t, context.Canceled, itr.Err())
+			return nil
+		}))
+	})
+}
+
+func TestIterateSample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+
+	const n = 20000
+	batch := db.NewWriteBatch()
+	for i := 0; i < n; i++ {
+		require.NoError(t, batch.Set([]byte(fmt.Sprintf("%05d", i)), make([]byte, 100)))
+	}
+	require.NoError(t, batch.Flush())
+	// Reopen the DB, so that all the keys are in tables.
+	require.NoError(t, db.Close())
+	db, err = Open(getTestOptions(dir))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sample := func(opt IteratorOptions) []string {
+		var out []string
+		require.NoError(t, db.View(func(txn *Txn) error {
+			itr := txn.NewIterator(opt)
+			defer itr.Close()
+			for itr.Rewind(); itr.Valid(); itr.Next() {
+				out = append(out, string(itr.Item().Key()))
+			}
+			return nil
+		}))
+		return out
+	}
+
+	opt := DefaultIteratorOptions
+	opt.SampleEveryN = 500
+	got := sample(opt)
+	require.True(t, len(got) > n/500/4 && len(got) < n/500*4, "samples=%d", len(got))
+	require.True(t, got[0] < "01000", "first sample=%s", got[0])
+	require.True(t, sort.StringsAreSorted(got))
+
+	opt.Reverse = true
+	got = sample(opt)
+	require.True(t, len(got) > n/500/4 && len(got) < n/500*4, "samples=%d", len(got))
+	require.True(t, got[0] > fmt.Sprintf("%05d", n-1000), "first sample=%s", got[0])
+	require.True(t, sort.SliceIsSorted(got, func(i, j int) bool { return got[i] > got[j] }))
+}
+
+func TestItemValueCopyTo(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		small := []byte("small")
+		big := bytes.Repeat([]byte("b"), 2*db.opt.ValueThreshold) // Stored in the value log.
+		txnSet(t, db, []byte("big"), big, 0)
+		txnSet(t, db, []byte("small"), small, 0)
+
+		for _, prefetch := range []bool{false, true} {
+			opt := DefaultIteratorOptions
+			opt.PrefetchValues = prefetch
+			require.NoError(t, db.View(func(txn *Txn) error {
+				itr := txn.NewIterator(opt)
+				defer itr.Close()
+				buf := make([]byte, 0, 1)
+				buf = append(buf, '>')
+				var err error
+				for itr.Rewind(); itr.Valid(); itr.Next() {
+					buf, err = itr.Item().ValueCopyTo(buf)
+					require.NoError(t, err)
+				}
+				require.Equal(t, append(append([]byte(">"), big...), small...), buf)
+
+				// Reusing the buffer doesn't reallocate it.
+				itr.Rewind()
+				out, err := itr.Item().ValueCopyTo(buf[:0])
+				require.NoError(t, err)
+				require.Equal(t, big, out)
+				require.Equal(t, &buf[0], &out[0])
+				return nil
+			}))
+		}
+	})
+}
+
+func TestItemValueSize(t *testing.T) {
+	opt := getTestOptions("")
+	opt.ValueLogLoadingMode = options.FileIO
+	opt = opt.WithEncryptionKey([]byte("badgerkey16bytes"))
+	test := func(t *testing.T, db *DB) {
+		vals := map[string][]byte{
+			"empty": {},
+			"small": []byte("small"),
+			"big":   bytes.Repeat([]byte("b"), 2*db.opt.ValueThreshold),
+			"huge":  bytes.Repeat([]byte("h"), 1<<20),
+		}
+		for k, v := range vals {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.SetEntry(NewEntry([]byte(k), v).WithTTL(time.Hour))
+			}))
+		}
+		// The metadata isn't part of the size.
+		vals["metadata"] = vals["big"]
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.SetEntry(NewEntry([]byte("metadata"), vals["big"]).
+				WithMetadata([]byte("md")))
+		}))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for k, v := range vals {
+				item, err := txn.Get([]byte(k))
+				require.NoError(t, err)
+				require.Equal(t, int64(len(v)), item.ValueSize(), "key %s", k)
+			}
+			return nil
+		}))
+
+		// Pending writes have a size too.
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set([]byte("pending"), []byte("value")))
+		item, err := txn.Get([]byte("pending"))
+		require.NoError(t, err)
+		require.Equal(t, int64(5), item.ValueSize())
+	}
+	t.Run("default", func(t *testing.T) { runBadgerTest(t, nil, test) })
+	// The size is read from the encrypted value log, without mmap.
+	t.Run("encrypted", func(t *testing.T) { runBadgerTest(t, &opt, test) })
+}
+
+func TestIteratorReversePrefix(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		keys := []string{
+			"a", "a1", "a2", "b", "b1", "\xff", "\xff\x01", "\xff\xff", "\xff\xff\x01"}
+		for _, k := range keys {
+			txnSet(t, db, []byte(k), []byte(k), 0)
+		}
+		txnSet(t, db, []byte("a2"), []byte("a2"), 0) // A second version.
+
+		scan := func(prefix, seek string) []string {
+			var res []string
+			require.NoError(t, db.View(func(txn *Txn) error {
+				opt := DefaultIteratorOptions
+				opt.Reverse = true
+				opt.Prefix = []byte(prefix)
+				itr := txn.NewIterator(opt)
+				defer itr.Close()
+				for itr.Seek([]byte(seek)); itr.Valid(); itr.Next() {
+					res = append(res, string(itr.Item().Key()))
+				}
+				return nil
+			}))
+			return res
+		}
+
+		require.Equal(t, []string{"a2", "a1", "a"}, scan("a", ""))
+		require.Equal(t, []string{"b1", "b"}, scan("b", ""))
+		require.Equal(t, []string{"a1", "a"}, scan("a", "a1"))
+		require.Equal(t, []string{"a2", "a1", "a"}, scan("a", "zz"))
+		require.Equal(t, []string{"a2", "a1", "a"}, scan("a", "a\xff"))
+		require.Empty(t, scan("c", ""))
+		// Prefixes at the end of the key space.
+		require.Equal(t, []string{"\xff\xff\x01", "\xff\xff", "\xff\x01", "\xff"},
+			scan("\xff", ""))
+		require.Equal(t, []string{"\xff\xff\x01", "\xff\xff"}, scan("\xff\xff", ""))
+		// An empty prefix returns all the keys.
+		all := scan("", "")
+		require.Len(t, all, len(keys))
+		for i, k := range all {
+			require.Equal(t, keys[len(keys)-1-i], k)
+		}
+	})
+}
+
+func TestIteratorKeysOnly(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		big := bytes.Repeat([]byte("b"), 2*db.opt.ValueThreshold) // Stored in the value log.
+		txnSet(t, db, []byte("a"), []byte("a"), 0x01)
+		txnSet(t, db, []byte("b"), big, 0)
+		txnSet(t, db, []byte("c"), []byte("c"), 0)
+		txnDelete(t, db, []byte("c"))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			e := NewEntry([]byte("d"), []byte("d"))
+			e.ExpiresAt = 1 // Already expired.
+			return txn.SetEntry(e)
+		}))
+
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		require.NoError(t, txn.Set([]byte("e"), []byte("e")))
+
+		opt := DefaultIteratorOptions
+		opt.KeysOnly = true
+		itr := txn.NewIterator(opt)
+		defer itr.Close()
+		var keys []string
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			item := itr.Item()
+			keys = append(keys, string(item.Key()))
+			require.Equal(t, ErrKeyOnlyIteration, item.Value(nil))
+			_, err := item.ValueCopy(nil)
+			require.Equal(t, ErrKeyOnlyIteration, err)
+			_, err = item.ValueCopyTo(nil)
+			require.Equal(t, ErrKeyOnlyIteration, err)
+			require.Zero(t, item.ValueSize())
+			require.Equal(t, int64(len(item.Key())), item.EstimatedSize())
+		}
+		require.Equal(t, []string{"a", "b", "e"}, keys)
+
+		itr.Rewind()
+		require.Equal(t, byte(0x01), itr.Item().UserMeta())
+	})
+}
+
+func TestIteratorLimitOffset(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("a"), []byte("a"), 0)
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("k%d", i))
+			txnSet(t, db, key, key, 0)
+		}
+		txnDelete(t, db, []byte("k3"))
+		txnSet(t, db, []byte("k5"), []byte("k5-2"), 0) // A second version.
+		txnSet(t, db, []byte("z"), []byte("z"), 0)
+
+		keys := func(opt IteratorOptions, seek string) []string {
+			var keys []string
+			require.NoError(t, db.View(func(txn *Txn) error {
+				itr := txn.NewIterator(opt)
+				defer itr.Close()
+				for itr.Seek([]byte(seek)); itr.Valid(); itr.Next() {
+					item := itr.Item()
+					keys = append(keys, fmt.Sprintf("%s@%d", item.Key(), item.Version()))
+				}
+				return nil
+			}))
+			return keys
+		}
+
+		// Paginate the prefix, the deleted key isn't counted.
+		opt := DefaultIteratorOptions
+		opt.Prefix = []byte("k")
+		opt.Limit = 4
+		require.Equal(t, []string{"k0@2", "k1@3", "k2@4", "k4@6"}, keys(opt, ""))
+		opt.Offset = 4
+		require.Equal(t, []string{"k5@13", "k6@8", "k7@9", "k8@10"}, keys(opt, ""))
+		opt.Offset = 8
+		require.Equal(t, []string{"k9@11"}, keys(opt, ""))
+		opt.Offset = 9
+		require.Empty(t, keys(opt, ""))
+
+		// The offset is applied after Seek.
+		opt.Offset = 1
+		opt.Limit = 2
+		require.Equal(t, []string{"k6@8", "k7@9"}, keys(opt, "k5"))
+
+		// And in reverse.
+		opt.Reverse = true
+		opt.PrefetchValues = false
+		require.Equal(t, []string{"k8@10", "k7@9"}, keys(opt, ""))
+		opt.Reverse = false
+
+		// With AllVersions, the versions are counted, including the deleted ones.
+		opt.AllVersions = true
+		opt.Offset = 3
+		opt.Limit = 4
+		require.Equal(t, []string{"k3@12", "k3@5", "k4@6", "k5@13"}, keys(opt, ""))
+
+		// Without a limit, the iteration goes on after the offset.
+		opt = DefaultIteratorOptions
+		opt.Offset = 10
+		require.Equal(t, []string{"z@14"}, keys(opt, ""))
+	})
+}
+
+func TestIteratorMaxVersion(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		opt := DefaultIteratorOptions
+		opt.MaxVersion = 1
+		require.Panics(t, func() { txn.NewIterator(opt) })
+	})
+
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.managedTxns = true
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+
+	write := func(key, val string, version uint64) {
+		txn := db.NewTransactionAt(version, true)
+		defer txn.Discard()
+		if val == "" {
+			require.NoError(t, txn.Delete([]byte(key)))
+		} else {
+			require.NoError(t, txn.Set([]byte(key), []byte(val)))
+		}
+		require.NoError(t, txn.CommitAt(version, nil))
+	}
+	write("a", "a1", 1)
+	write("b", "b2", 2)
+	write("a", "a3", 3)
+	write("b", "", 4)
+	write("c", "c5", 5)
+
+	scan := func(opt IteratorOptions) []string {
+		txn := db.NewTransactionAt(10, true)
+		defer txn.Discard()
+		// The pending writes are newer than any MaxVersion.
+		require.NoError(t, txn.Set([]byte("a"), []byte("pending")))
+		itr := txn.NewIterator(opt)
+		defer itr.Close()
+		var out []string
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			item := itr.Item()
+			if item.IsDeletedOrExpired() {
+				out = append(out, fmt.Sprintf("%s@%d", item.Key(), item.Version()))
+				continue
+			}
+			out = append(out, fmt.Sprintf("%s=%s@%d", item.Key(), getItemValue(t, item),
+				item.Version()))
+		}
+		return out
+	}
+	check := func() {
+		opt := DefaultIteratorOptions
+		opt.MaxVersion = 3
+		require.Equal(t, []string{"a=a3@3", "b=b2@2"}, scan(opt))
+		opt.MaxVersion = 1
+		require.Equal(t, []string{"a=a1@1"}, scan(opt))
+		opt.MaxVersion = 4
+		opt.Reverse = true
+		require.Equal(t, []string{"a=a3@3"}, scan(opt))
+		opt.Reverse = false
+		opt.AllVersions = true
+		require.Equal(t, []string{"a=a3@3", "a=a1@1", "b@4", "b=b2@2"}, scan(opt))
+
+		txn := db.NewTransactionAt(10, false)
+		defer txn.Discard()
+		opt = DefaultIteratorOptions
+		opt.MaxVersion = 2
+		itr := txn.NewIterator(opt)
+		defer itr.Close()
+		itr.Seek([]byte("b"))
+		require.True(t, itr.Valid())
+		require.Equal(t, uint64(2), itr.Item().Version())
+	}
+	check()
+
+	// The same versions are seen once the memtable is flushed.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NotEmpty(t, db.Tables(false))
+	check()
+}
+
+func TestIteratorMaxVersionDeleteRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.managedTxns = true
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	txn := db.NewTransactionAt(1, true)
+	require.NoError(t, txn.Set([]byte("k1"), []byte("v1")))
+	require.NoError(t, txn.CommitAt(1, nil))
+	txn = db.NewTransactionAt(5, true)
+	require.NoError(t, txn.DeleteRange([]byte("k"), []byte("l")))
+	require.NoError(t, txn.CommitAt(5, nil))
+
+	keys := func(txn *Txn, opt IteratorOptions) []string {
+		itr := txn.NewIterator(opt)
+		defer itr.Close()
+		var out []string
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			out = append(out, fmt.Sprintf("%s@%d", itr.Item().Key(), itr.Item().Version()))
+		}
+		return out
+	}
+	txn = db.NewTransactionAt(10, true)
+	defer txn.Discard()
+	require.Empty(t, keys(txn, DefaultIteratorOptions))
+
+	// The range delete at 5 isn't visible below it.
+	itrOpt := DefaultIteratorOptions
+	itrOpt.MaxVersion = 3
+	require.Equal(t, []string{"k1@1"}, keys(txn, itrOpt))
+	itrOpt.AllVersions = true
+	require.Equal(t, []string{"k1@1"}, keys(txn, itrOpt))
+
+	// Neither are the range deletes of the transaction, like its pending writes.
+	require.NoError(t, txn.DeleteRange([]byte("a"), []byte("z")))
+	require.Empty(t, keys(txn, DefaultIteratorOptions))
+	itrOpt.AllVersions = false
+	require.Equal(t, []string{"k1@1"}, keys(txn, itrOpt))
+}
+
+func TestIteratorPrefetchConcurrency(t *testing.T) {
+	opt := getTestOptions("").WithValueThreshold(32)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		val := func(i int) []byte {
+			return []byte(fmt.Sprintf("%064d", i))
+		}
+		wb := db.NewWriteBatch()
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, wb.Set([]byte(fmt.Sprintf("key%04d", i)), val(i)))
+		}
+		require.NoError(t, wb.Flush())
+
+		for _, reverse := range []bool{false, true} {
+			iopt := DefaultIteratorOptions
+			iopt.PrefetchSize = 50
+			iopt.PrefetchConcurrency = 4
+			iopt.Reverse = reverse
+			require.NoError(t, db.View(func(txn *Txn) error {
+				it := txn.NewIterator(iopt)
+				defer it.Close()
+				var n int
+				for it.Rewind(); it.Valid(); it.Next() {
+					i := n
+					if reverse {
+						i = 999 - n
+					}
+					require.Equal(t, []byte(fmt.Sprintf("key%04d", i)), it.Item().Key())
+					require.Equal(t, val(i), getItemValue(t, it.Item()))
+					n++
+				}
+				require.Equal(t, 1000, n)
+
+				// Seeking discards the values being prefetched.
+				it.Seek([]byte("key0500"))
+				require.True(t, it.Valid())
+				require.Equal(t, val(500), getItemValue(t, it.Item()))
+				return nil
+			}))
+		}
+	})
+}
+
+func TestIteratorProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	// The keys of each prefix are spread uniformly, so the estimate over a prefix is exact.
+	const n = 1000
+	key := func(prefix string, i int) []byte {
+		k := make([]byte, len(prefix)+8)
+		copy(k, prefix)
+		binary.BigEndian.PutUint64(k[len(prefix):], uint64(i)<<40)
+		return k
+	}
+	wb := db.NewWriteBatch()
+	for i := 0; i < n; i++ {
+		require.NoError(t, wb.Set(key("p", i), []byte("val")))
+		require.NoError(t, wb.Set(key("q", i), []byte("val")))
+	}
+	require.NoError(t, wb.Flush())
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, reverse := range []bool{false, true} {
+				for _, prefix := range []string{"", "p", "q"} {
+					iopt := DefaultIteratorOptions
+					iopt.Reverse = reverse
+					iopt.Prefix = []byte(prefix)
+					it := txn.NewIterator(iopt)
+					var i int
+					last := -1.0
+					for it.Rewind(); it.Valid(); it.Next() {
+						p := it.Progress()
+						if prefix != "" {
+							require.InDelta(t, float64(i)/(n-1), p, 1e-6, "%v %q %d", reverse, prefix, i)
+						}
+						require.True(t, p >= last && p <= 1, "%v after %v", p, last)
+						last = p
+						i++
+					}
+					require.Equal(t, 1.0, it.Progress())
+					it.Close()
+				}
+			}
+			return nil
+		}))
+	}
+	// The keys are in the memtable, then in the tables.
+	check(db)
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+}
@@ -313,10 +313,6 @@ func (kr *KeyRegistry) dataKey(id uint64) (*pb.DataKey, error) {
 // period. If the last generated datakey lifetime exceeds the rotation period.
 // It'll create new datakey.
 func (kr *KeyRegistry) latestDataKey() (*pb.DataKey, error) {
-	if len(kr.opt.EncryptionKey) == 0 {
-		// nil is for no encryption.
-		return nil, nil
-	}
 	// validKey return datakey if the last generated key duration less than
 	// rotation duration.
 	validKey := func() (*pb.DataKey, bool) {
@@ -328,6 +324,11 @@ func (kr *KeyRegistry) latestDataKey() (*pb.DataKey, error) {
 		return nil, false
 	}
 	kr.RLock()
+	if len(kr.opt.EncryptionKey) == 0 {
+		kr.RUnlock()
+		// nil is for no encryption.
+		return nil, nil
+	}
 	key, valid := validKey()
 	kr.RUnlock()
 	if valid {
@@ -378,6 +379,58 @@ func (kr *KeyRegistry) latestDataKey() (*pb.DataKey, error) {
 	return dk, nil
 }
 
+// encrypted returns true if the data keys are encrypted, and new data keys are generated.
+func (kr *KeyRegistry) encrypted() bool {
+	kr.RLock()
+	defer kr.RUnlock()
+	return len(kr.opt.EncryptionKey) > 0
+}
+
+// rotate re-encrypts the data keys with the new encryption key. The key registry file is
+// rewritten by WriteKeyRegistry, which renames it over the old one, so a crash leaves the file
+// encrypted with either the old or the new key.
+func (kr *KeyRegistry) rotate(newKey []byte) error {
+	switch len(newKey) {
+	default:
+		return y.Wrapf(ErrInvalidEncryptionKey, "During KeyRegistry.rotate")
+	case 16, 24, 32:
+		break
+	}
+	kr.Lock()
+	defer kr.Unlock()
+	opt := kr.opt
+	opt.EncryptionKey = newKey
+	if opt.InMemory {
+		kr.opt = opt
+		return nil
+	}
+	// storeDataKey encrypts the data keys in place, so the data keys in use by the tables and
+	// the value log files are copied.
+	reg := newKeyRegistry(opt)
+	for id, dk := range kr.dataKeys {
+		k := *dk
+		k.Data = y.Copy(dk.Data)
+		reg.dataKeys[id] = &k
+	}
+	if err := WriteKeyRegistry(reg, opt); err != nil {
+		return y.Wrapf(err, "Error while rewriting key registry in KeyRegistry.rotate")
+	}
+	kr.opt = opt
+
+	// The data keys generated from now on are appended to the new file.
+	fp, err := y.OpenExistingFile(filepath.Join(opt.Dir, KeyRegistryFileName), y.Sync)
+	if err != nil {
+		return y.Wrapf(err, "Error while opening rewritten key registry in KeyRegistry.rotate")
+	}
+	if _, err := fp.Seek(0, io.SeekEnd); err != nil {
+		fp.Close()
+		return y.Wrapf(err, "Error while seeking rewritten key registry in KeyRegistry.rotate")
+	}
+	old := kr.fp
+	kr.fp = fp
+	return old.Close()
+}
+
 // Close closes the key registry.
 func (kr *KeyRegistry) Close() error {
 	if !(kr.opt.ReadOnly || kr.opt.InMemory) {
@@ -71,7 +71,7 @@ func (s *levelHandler) initTables(tables []*table.Table) {
 	} else {
 		// Sort tables by keys.
 		sort.Slice(s.tables, func(i, j int) bool {
-			return y.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
+			return s.db.cmp.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
 		})
 	}
 }
@@ -134,12 +134,38 @@ func (s *levelHandler) replaceTables(toDel, toAdd []*table.Table) error {
 	// Assign tables.
 	s.tables = newTables
 	sort.Slice(s.tables, func(i, j int) bool {
-		return y.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
+		return s.db.cmp.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
 	})
 	s.Unlock() // s.Unlock before we DecrRef tables -- that can be slow.
 	return decrRefs(toDel)
 }
 
+// swapTable replaces toDel with toAdd, which must have the same key range, keeping the position
+// of toDel within the level. This keeps the order of the tables of level 0.
+func (s *levelHandler) swapTable(toDel, toAdd *table.Table) error {
+	s.Lock() // s.Unlock() below
+
+	// Make a copy as iterators might be keeping a slice of tables.
+	newTables := make([]*table.Table, len(s.tables))
+	copy(newTables, s.tables)
+	found := false
+	for i, t := range newTables {
+		if t == toDel {
+			newTables[i] = toAdd
+			s.totalSize += toAdd.Size() - toDel.Size()
+			toAdd.IncrRef()
+			found = true
+		}
+	}
+	s.tables = newTables
+
+	s.Unlock() // Unlock s _before_ we DecrRef our tables, which can be slow.
+	if !found {
+		return errors.Errorf("table %d not found in level %d", toDel.ID(), s.level)
+	}
+	return toDel.DecrRef()
+}
+
 // addTable adds toAdd table to levelHandler. Normally when we add tables to levelHandler, we sort
 // tables based on table.Smallest. This is required for correctness of the system. But in case of
 // stream writer this can be avoided. We can just add tables to levelHandler's table list
@@ -161,7 +187,7 @@ func (s *levelHandler) sortTables() {
 	defer s.RUnlock()
 
 	sort.Slice(s.tables, func(i, j int) bool {
-		return y.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
+		return s.db.cmp.CompareKeys(s.tables[i].Smallest(), s.tables[j].Smallest()) < 0
 	})
 }
 
@@ -174,6 +200,15 @@ func decrRefs(tables []*table.Table) error {
 	return nil
 }
 
+// tablesSize returns the total size of the given tables.
+func tablesSize(tables []*table.Table) int64 {
+	var size int64
+	for _, t := range tables {
+		size += t.Size()
+	}
+	return size
+}
+
 func newLevelHandler(db *DB, level int) *levelHandler {
 	return &levelHandler{
 		level:    level,
@@ -242,7 +277,7 @@ func (s *levelHandler) getTableForKey(key []byte) ([]*table.Table, func() error)
 	}
 	// For level >= 1, we can do a binary search as key range does not overlap.
 	idx := sort.Search(len(s.tables), func(i int) bool {
-		return y.CompareKeys(s.tables[i].Biggest(), key) >= 0
+		return s.db.cmp.CompareKeys(s.tables[i].Biggest(), key) >= 0
 	})
 	if idx >= len(s.tables) {
 		// Given key is strictly > than every element we have.
@@ -284,6 +319,100 @@ func (s *levelHandler) get(key []byte) (y.ValueStruct, error) {
 	return maxVs, decr()
 }
 
+// getMulti looks up the keys which are not done yet in the tables of this level, and
+// marks the ones it finds as done. The keys must be sorted in increasing order, so
+// that a single iterator per table can be reused across keys.
+func (s *levelHandler) getMulti(keys [][]byte, vals []y.ValueStruct, done []bool) error {
+	s.RLock()
+	tables := make([]*table.Table, 0, len(s.tables))
+	if s.level == 0 {
+		// CAUTION: Reverse the tables, newer tables first.
+		for i := len(s.tables) - 1; i >= 0; i-- {
+			tables = append(tables, s.tables[i])
+		}
+	} else {
+		tables = append(tables, s.tables...)
+	}
+	for _, t := range tables {
+		t.IncrRef()
+	}
+	s.RUnlock()
+
+	iters := make([]*table.Iterator, len(tables))
+	defer func() {
+		for _, it := range iters {
+			if it != nil {
+				_ = it.Close()
+			}
+		}
+		for _, t := range tables {
+			_ = t.DecrRef()
+		}
+	}()
+
+	// lookup returns the latest version of key in table i.
+	lookup := func(i int, key []byte, hash uint64) (vs y.ValueStruct, err error) {
+		if tables[i].DoesNotHave(hash) {
+			y.NumLSMBloomHits.Add(s.strLevel, 1)
+			return vs, nil
+		}
+		if iters[i] == nil {
+			iters[i] = tables[i].NewIterator(false)
+		}
+		it := iters[i]
+		y.NumLSMGets.Add(s.strLevel, 1)
+		it.Seek(key)
+		if !it.Valid() {
+			return vs, it.Err()
+		}
+		if y.SameKey(key, it.Key()) {
+			vs = it.ValueCopy()
+			vs.Version = y.ParseTs(it.Key())
+		}
+		return vs, nil
+	}
+
+	var next int // For level >= 1, the first table which could have the current key.
+	for i, key := range keys {
+		if done[i] {
+			continue
+		}
+		hash := farm.Fingerprint64(y.ParseKey(key))
+		var maxVs y.ValueStruct
+		if s.level == 0 {
+			// For level 0, we need to check every table.
+			for j := range tables {
+				vs, err := lookup(j, key, hash)
+				if err != nil {
+					return err
+				}
+				if maxVs.Version < vs.Version {
+					maxVs = vs
+				}
+			}
+		} else {
+			// The key ranges don't overlap, and the keys are sorted, so we only move forward.
+			for next < len(tables) && s.db.cmp.CompareKeys(tables[next].Biggest(), key) < 0 {
+				next++
+			}
+			if next == len(tables) {
+				// All the remaining keys are strictly > than every element we have.
+				return nil
+			}
+			vs, err := lookup(next, key, hash)
+			if err != nil {
+				return err
+			}
+			maxVs = vs
+		}
+		if maxVs.Value == nil && maxVs.Meta == 0 {
+			continue
+		}
+		vals[i], done[i] = maxVs, true
+	}
+	return nil
+}
+
 // appendIterators appends iterators to an array of iterators, for merging.
 // Note: This obtains references for the table handlers. Remember to close these iterators.
 func (s *levelHandler) appendIterators(iters []y.Iterator, opt *IteratorOptions) []y.Iterator {
@@ -310,6 +439,35 @@ func (s *levelHandler) appendIterators(iters []y.Iterator, opt *IteratorOptions)
 	return append(iters, table.NewConcatIterator(tables, opt.Reverse))
 }
 
+// sampleKeys appends to keys the sample keys of the tables of the level which an iterator with
+// the given options would pick, keeping about one key every n entries.
+func (s *levelHandler) sampleKeys(keys [][]byte, opt *IteratorOptions, n int) [][]byte {
+	s.RLock()
+	defer s.RUnlock()
+
+	tables := s.tables
+	if s.level > 0 {
+		tables = opt.pickTables(s.tables)
+	}
+	for _, t := range tables {
+		if s.level == 0 && !opt.pickTable(t) {
+			continue
+		}
+		keys = append(keys, t.SampleKeys(n)...)
+	}
+	return keys
+}
+
+// blocks appends to blocks the blocks of the tables of the level.
+func (s *levelHandler) blocks(blocks []table.BlockInfo) []table.BlockInfo {
+	s.RLock()
+	defer s.RUnlock()
+	for _, t := range s.tables {
+		blocks = append(blocks, t.Blocks()...)
+	}
+	return blocks
+}
+
 type levelHandlerRLocked struct{}
 
 // overlappingTables returns the tables that intersect with key range. Returns a half-interval.
@@ -320,10 +478,10 @@ func (s *levelHandler) overlappingTables(_ levelHandlerRLocked, kr keyRange) (in
 		return 0, 0
 	}
 	left := sort.Search(len(s.tables), func(i int) bool {
-		return y.CompareKeys(kr.left, s.tables[i].Biggest()) <= 0
+		return s.db.cmp.CompareKeys(kr.left, s.tables[i].Biggest()) <= 0
 	})
 	right := sort.Search(len(s.tables), func(i int) bool {
-		return y.CompareKeys(kr.right, s.tables[i].Smallest()) < 0
+		return s.db.cmp.CompareKeys(kr.right, s.tables[i].Smallest()) < 0
 	})
 	return left, right
 }
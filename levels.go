@@ -18,6 +18,7 @@ package badger
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
@@ -29,6 +30,7 @@ import (
 
 	"golang.org/x/net/trace"
 
+	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/table"
 	"github.com/dgraph-io/badger/v2/y"
@@ -36,14 +38,23 @@ import (
 )
 
 type levelsController struct {
-	nextFileID uint64 // Atomic
-	elog       trace.EventLog
+	nextFileID     uint64 // Atomic
+	numCompactions int32  // Atomic. Number of compactions running.
+	elog           trace.EventLog
 
 	// The following are initialized once and const.
 	levels []*levelHandler
 	kv     *DB
 
 	cstatus compactStatus
+
+	// workersLock guards the compaction workers. See DB.SetNumCompactors.
+	workersLock   sync.Mutex
+	numCompactors int32     // Atomic, written under workersLock. Number of workers to run.
+	workers       []bool    // Whether the worker with the given id is running.
+	workersCloser *y.Closer // Closer of the running workers, nil if compactions are stopped.
+
+	l0Stalled int32 // Atomic. Set while a memtable flush waits for room in level 0.
 }
 
 var (
@@ -51,18 +62,32 @@ var (
 	lastUnstalled time.Time
 )
 
+// compactionIOChunk is the size of the IO the compactions account with the rate limiter at once.
+const compactionIOChunk = 256 << 10
+
 // revertToManifest checks that all necessary table files exist and removes all table files not
 // referenced by the manifest. idMap is a set of table file id's that were read from the directory
 // listing.
 func revertToManifest(kv *DB, mf *Manifest, idMap map[uint64]struct{}) error {
 	// 1. Check all files in manifest exist.
-	for id := range mf.Tables {
+	for id, tf := range mf.Tables {
 		if _, ok := idMap[id]; !ok {
-			return fmt.Errorf("file does not exist for table %d", id)
+			err := fmt.Errorf("file does not exist for table %d", id)
+			if !kv.opt.RepairMode {
+				return err
+			}
+			if err := kv.dropTable(id, tf, err); err != nil {
+				return err
+			}
+			delete(mf.Tables, id)
 		}
 	}
 
-	// 2. Delete files that shouldn't exist.
+	// 2. Delete files that shouldn't exist. In read-only mode, they might be tables being written
+	// by another process.
+	if kv.opt.ReadOnly {
+		return nil
+	}
 	for id := range idMap {
 		if _, ok := mf.Tables[id]; !ok {
 			kv.elog.Printf("Table file %d not referenced in MANIFEST\n", id)
@@ -79,11 +104,13 @@ func revertToManifest(kv *DB, mf *Manifest, idMap map[uint64]struct{}) error {
 func newLevelsController(db *DB, mf *Manifest) (*levelsController, error) {
 	y.AssertTrue(db.opt.NumLevelZeroTablesStall > db.opt.NumLevelZeroTables)
 	s := &levelsController{
-		kv:     db,
-		elog:   db.elog,
-		levels: make([]*levelHandler, db.opt.MaxLevels),
+		kv:            db,
+		elog:          db.elog,
+		levels:        make([]*levelHandler, db.opt.MaxLevels),
+		numCompactors: int32(db.opt.NumCompactors),
 	}
 	s.cstatus.levels = make([]*levelCompactStatus, db.opt.MaxLevels)
+	s.cstatus.cmp = db.cmp
 
 	for i := 0; i < db.opt.MaxLevels; i++ {
 		s.levels[i] = newLevelHandler(db, i)
@@ -130,7 +157,8 @@ func newLevelsController(db *DB, mf *Manifest) (*levelsController, error) {
 		fname := table.NewFilename(fileID, db.opt.Dir)
 		select {
 		case <-tick.C:
-			db.opt.Infof("%d tables out of %d opened in %s\n", atomic.LoadInt32(&numOpened),
+			db.opt.logger(LogComponentRecovery).Infof(
+				"%d tables out of %d opened in %s\n", atomic.LoadInt32(&numOpened),
 				len(mf.Tables), time.Since(start).Round(time.Millisecond))
 		default:
 		}
@@ -141,7 +169,7 @@ func newLevelsController(db *DB, mf *Manifest) (*levelsController, error) {
 		if fileID > maxFileID {
 			maxFileID = fileID
 		}
-		go func(fname string, tf TableManifest) {
+		go func(fileID uint64, fname string, tf TableManifest) {
 			var rerr error
 			defer func() {
 				throttle.Done(rerr)
@@ -157,16 +185,19 @@ func newLevelsController(db *DB, mf *Manifest) (*levelsController, error) {
 				rerr = errors.Wrapf(err, "Error while reading datakey")
 				return
 			}
-			topt := buildTableOptions(db.opt)
+			topt := buildTableOptions(db)
 			// Set compression from table manifest.
 			topt.Compression = tf.Compression
 			topt.DataKey = dk
-			topt.Cache = db.blockCache
 			t, err := table.OpenTable(fd, topt)
 			if err != nil {
-				if strings.HasPrefix(err.Error(), "CHECKSUM_MISMATCH:") {
-					db.opt.Errorf(err.Error())
-					db.opt.Errorf("Ignoring table %s", fd.Name())
+				if db.opt.RepairMode {
+					_ = fd.Close()
+					rerr = db.dropTable(fileID, tf, err)
+				} else if strings.HasPrefix(err.Error(), "CHECKSUM_MISMATCH:") {
+					db.opt.logger(LogComponentRecovery).Errorf(err.Error())
+					db.opt.logger(LogComponentRecovery).Errorf(
+						"Ignoring table %s", fd.Name())
 					// Do not set rerr. We will continue without this table.
 				} else {
 					rerr = errors.Wrapf(err, "Opening table: %q", fname)
@@ -177,13 +208,14 @@ func newLevelsController(db *DB, mf *Manifest) (*levelsController, error) {
 			mu.Lock()
 			tables[tf.Level] = append(tables[tf.Level], t)
 			mu.Unlock()
-		}(fname, tf)
+		}(fileID, fname, tf)
 	}
 	if err := throttle.Finish(); err != nil {
 		closeAllTables(tables)
 		return nil, err
 	}
-	db.opt.Infof("All %d tables opened in %s\n", atomic.LoadInt32(&numOpened),
+	db.opt.logger(LogComponentRecovery).Infof(
+		"All %d tables opened in %s\n", atomic.LoadInt32(&numOpened),
 		time.Since(start).Round(time.Millisecond))
 	s.nextFileID = maxFileID + 1
 	for i, tbls := range tables {
@@ -276,7 +308,7 @@ func (s *levelsController) dropTree() (int, error) {
 // tables who only have keys with this prefix are quickly dropped. The ones which have other keys
 // are run through MergeIterator and compacted to create new tables. All the mechanisms of
 // compactions apply, i.e. level sizes and MANIFEST are updated as in the normal flow.
-func (s *levelsController) dropPrefix(prefix []byte) error {
+func (s *levelsController) dropPrefix(prefix []byte, stats *compactStats) error {
 	opt := s.kv.opt
 	for _, l := range s.levels {
 		l.RLock()
@@ -291,9 +323,11 @@ func (s *levelsController) dropPrefix(prefix []byte) error {
 					// A unique number greater than 1.0 does two things. Helps identify this
 					// function in logs, and forces a compaction.
 					dropPrefix: prefix,
+					stats:      stats,
 				}
 				if err := s.doCompact(cp); err != nil {
-					opt.Warningf("While compacting level 0: %v", err)
+					opt.logger(LogComponentCompact).Warningf(
+						"While compacting level 0: %v", err)
 					return nil
 				}
 			}
@@ -306,8 +340,8 @@ func (s *levelsController) dropPrefix(prefix []byte) error {
 			switch {
 			case bytes.HasPrefix(table.Smallest(), prefix):
 			case bytes.HasPrefix(table.Biggest(), prefix):
-			case bytes.Compare(prefix, table.Smallest()) > 0 &&
-				bytes.Compare(prefix, table.Biggest()) < 0:
+			case s.kv.cmp.Compare(prefix, y.ParseKey(table.Smallest())) > 0 &&
+				s.kv.cmp.Compare(prefix, y.ParseKey(table.Biggest())) < 0:
 			default:
 				absent = true
 			}
@@ -327,9 +361,11 @@ func (s *levelsController) dropPrefix(prefix []byte) error {
 			top:        []*table.Table{},
 			bot:        tables,
 			dropPrefix: prefix,
+			stats:      stats,
 		}
 		if err := s.runCompactDef(l.level, cd); err != nil {
-			opt.Warningf("While running compact def: %+v. Error: %v", cd, err)
+			opt.logger(LogComponentCompact).Warningf(
+				"While running compact def: %+v. Error: %v", cd, err)
 			return err
 		}
 	}
@@ -337,14 +373,74 @@ func (s *levelsController) dropPrefix(prefix []byte) error {
 }
 
 func (s *levelsController) startCompact(lc *y.Closer) {
-	n := s.kv.opt.NumCompactors
-	lc.AddRunning(n - 1)
-	for i := 0; i < n; i++ {
-		go s.runWorker(lc)
+	s.workersLock.Lock()
+	defer s.workersLock.Unlock()
+	s.workersCloser = lc
+	// The closer starts with one running goroutine.
+	lc.AddRunning(s.startWorkers() - 1)
+}
+
+// stopCompact stops the compaction workers started with lc, and waits for them to finish their
+// compactions.
+func (s *levelsController) stopCompact(lc *y.Closer) {
+	s.workersLock.Lock()
+	s.workersCloser = nil
+	s.workersLock.Unlock()
+	lc.SignalAndWait()
+}
+
+// setNumCompactors sets the number of compaction workers to n, starting the missing ones if the
+// compactions are running. The workers beyond n stop before their next compaction.
+func (s *levelsController) setNumCompactors(n int) {
+	s.workersLock.Lock()
+	defer s.workersLock.Unlock()
+	atomic.StoreInt32(&s.numCompactors, int32(n))
+	if s.workersCloser != nil {
+		s.workersCloser.AddRunning(s.startWorkers())
+	}
+}
+
+// startWorkers starts the workers with an id below numCompactors which are not running, and
+// returns how many it started. Caller must hold workersLock, and add them to workersCloser.
+func (s *levelsController) startWorkers() int {
+	n := int(atomic.LoadInt32(&s.numCompactors))
+	for len(s.workers) < n {
+		s.workers = append(s.workers, false)
+	}
+	var started int
+	for id := 0; id < n; id++ {
+		if !s.workers[id] {
+			s.workers[id] = true
+			started++
+			go s.runWorker(id, s.workersCloser)
+		}
+	}
+	return started
+}
+
+// retireWorker returns true if the worker with the given id must stop, because the number of
+// compaction workers was reduced below it. The worker is then marked as stopped.
+func (s *levelsController) retireWorker(id int) bool {
+	if id < int(atomic.LoadInt32(&s.numCompactors)) {
+		return false
+	}
+	s.workersLock.Lock()
+	defer s.workersLock.Unlock()
+	if id < int(atomic.LoadInt32(&s.numCompactors)) {
+		return false
 	}
+	s.workers[id] = false
+	return true
+}
+
+// workerStopped marks the worker with the given id as stopped, after the compactions were stopped.
+func (s *levelsController) workerStopped(id int) {
+	s.workersLock.Lock()
+	defer s.workersLock.Unlock()
+	s.workers[id] = false
 }
 
-func (s *levelsController) runWorker(lc *y.Closer) {
+func (s *levelsController) runWorker(id int, lc *y.Closer) {
 	defer lc.Done()
 
 	randomDelay := time.NewTimer(time.Duration(rand.Int31n(1000)) * time.Millisecond)
@@ -352,6 +448,7 @@ func (s *levelsController) runWorker(lc *y.Closer) {
 	case <-randomDelay.C:
 	case <-lc.HasBeenClosed():
 		randomDelay.Stop()
+		s.workerStopped(id)
 		return
 	}
 
@@ -362,6 +459,9 @@ func (s *levelsController) runWorker(lc *y.Closer) {
 		select {
 		// Can add a done channel or other stuff.
 		case <-ticker.C:
+			if s.retireWorker(id) {
+				return
+			}
 			prios := s.pickCompactLevels()
 			for _, p := range prios {
 				if err := s.doCompact(p); err == nil {
@@ -369,15 +469,27 @@ func (s *levelsController) runWorker(lc *y.Closer) {
 				} else if err == errFillTables {
 					// pass
 				} else {
-					s.kv.opt.Warningf("While running doCompact: %v\n", err)
+					s.kv.opt.logger(LogComponentCompact).Warningf(
+						"While running doCompact: %v\n", err)
 				}
 			}
 		case <-lc.HasBeenClosed():
+			s.workerStopped(id)
 			return
 		}
 	}
 }
 
+// compactionsBusy returns true if all the compactors are running, or if level zero has so many
+// tables that writes are about to stall. Other background work should then leave the IO to them.
+func (s *levelsController) compactionsBusy() bool {
+	n := atomic.LoadInt32(&s.numCompactors)
+	if n > 0 && atomic.LoadInt32(&s.numCompactions) >= n {
+		return true
+	}
+	return s.levels[0].numTables() >= s.kv.opt.NumLevelZeroTablesStall
+}
+
 // Returns true if level zero may be compacted, without accounting for compactions that already
 // might be happening.
 func (s *levelsController) isLevel0Compactable() bool {
@@ -395,6 +507,20 @@ type compactionPriority struct {
 	level      int
 	score      float64
 	dropPrefix []byte
+	stats      *compactStats // If set, the compactions are accounted in it.
+}
+
+// compactStats accumulates the sizes of a series of compactions.
+type compactStats struct {
+	tables  int64 // Number of tables compacted.
+	read    int64 // Size of the tables compacted.
+	written int64 // Size of the tables built.
+}
+
+func (cs *compactStats) add(cd *compactDef, newTables []*table.Table) {
+	atomic.AddInt64(&cs.tables, int64(len(cd.top)+len(cd.bot)))
+	atomic.AddInt64(&cs.read, tablesSize(cd.top)+tablesSize(cd.bot))
+	atomic.AddInt64(&cs.written, tablesSize(newTables))
 }
 
 // pickCompactLevel determines which level to compact.
@@ -407,7 +533,7 @@ func (s *levelsController) pickCompactLevels() (prios []compactionPriority) {
 	if !s.cstatus.overlapsWith(0, infRange) && s.isLevel0Compactable() {
 		pri := compactionPriority{
 			level: 0,
-			score: float64(s.levels[0].numTables()) / float64(s.kv.opt.NumLevelZeroTables),
+			score: s.score(0),
 		}
 		prios = append(prios, pri)
 	}
@@ -419,7 +545,7 @@ func (s *levelsController) pickCompactLevels() (prios []compactionPriority) {
 		if l.isCompactable(delSize) {
 			pri := compactionPriority{
 				level: i + 1,
-				score: float64(l.getTotalSize()-delSize) / float64(l.maxTotalSize),
+				score: s.score(i + 1),
 			}
 			prios = append(prios, pri)
 		}
@@ -430,15 +556,31 @@ func (s *levelsController) pickCompactLevels() (prios []compactionPriority) {
 	return prios
 }
 
+// score returns the compaction score of the level. Levels with a score of 1 or more need to be
+// compacted.
+func (s *levelsController) score(level int) float64 {
+	if level == 0 {
+		return float64(s.levels[0].numTables()) / float64(s.kv.opt.NumLevelZeroTables)
+	}
+	l := s.levels[level]
+	return float64(l.getTotalSize()-s.cstatus.delSize(level)) / float64(l.maxTotalSize)
+}
+
 // compactBuildTables merge topTables and botTables to form a list of new tables.
+// compactBuildTables builds the new tables of the compaction. Along with them, it returns a
+// function releasing them, and the size of the values discarded from the value log.
 func (s *levelsController) compactBuildTables(
-	lev int, cd compactDef) ([]*table.Table, func() error, error) {
+	lev int, cd compactDef) ([]*table.Table, func() error, int64, error) {
 	topTables := cd.top
 	botTables := cd.bot
 
 	var hasOverlap bool
 	{
-		kr := getKeyRange(cd.top...)
+		kr := getKeyRange(s.kv.cmp, cd.top...)
+		if len(cd.top) == 0 {
+			// A compaction within a level has no top tables, check the ones being rewritten.
+			kr = getKeyRange(s.kv.cmp, cd.bot...)
+		}
 		for i, lh := range s.levels {
 			if i <= lev { // Skip upper levels.
 				continue
@@ -490,7 +632,7 @@ func (s *levelsController) compactBuildTables(
 		valid = append(valid, table)
 	}
 	iters = append(iters, table.NewConcatIterator(valid, false))
-	it := table.NewMergeIterator(iters, false)
+	it := table.NewMergeIteratorWithComparator(iters, false, s.kv.cmp, nil)
 	defer it.Close() // Important to close the iterator to do ref counting.
 
 	it.Rewind()
@@ -506,23 +648,31 @@ func (s *levelsController) compactBuildTables(
 		err   error
 	}
 	resultCh := make(chan newTableResult)
-	var numBuilds, numVersions int
+	var numBuilds, numVersions, numVersionsToKeep int
 	var lastKey, skipKey []byte
 	var vp valuePointer
+	var ioRead int64 // Read and not accounted with the rate limiter yet.
 	for it.Valid() {
 		timeStart := time.Now()
 		dk, err := s.kv.registry.latestDataKey()
 		if err != nil {
-			return nil, nil,
+			return nil, nil, 0,
 				y.Wrapf(err, "Error while retrieving datakey in levelsController.compactBuildTables")
 		}
-		bopts := buildTableOptions(s.kv.opt)
+		bopts := buildTableOptions(s.kv)
+		bopts.BloomFalsePositive = s.kv.opt.bloomFalsePositive(cd.nextLevel.level)
+		bopts.Compression = s.kv.opt.compression(cd.nextLevel.level)
+		bopts.ZSTDDictionarySize = s.kv.opt.ZSTDDictionarySize
 		bopts.DataKey = dk
-		// Builder does not need cache but the same options are used for opening table.
-		bopts.Cache = s.kv.blockCache
 		builder := table.NewTableBuilder(bopts)
 		var numKeys, numSkips uint64
 		for ; it.Valid(); it.Next() {
+			vs := it.Value()
+			if ioRead += int64(len(it.Key())) + int64(vs.EncodedSize()); ioRead >= compactionIOChunk {
+				s.kv.compactionLimiter.Wait(ioRead)
+				ioRead = 0
+			}
+
 			// See if we need to skip the prefix.
 			if len(cd.dropPrefix) > 0 && bytes.HasPrefix(it.Key(), cd.dropPrefix) {
 				numSkips++
@@ -530,6 +680,13 @@ func (s *levelsController) compactBuildTables(
 				continue
 			}
 
+			// Skip the versions deleted by a range tombstone which no reader can see past.
+			if s.kv.rangeDels.covers(y.ParseKey(it.Key()), y.ParseTs(it.Key()), discardTs) {
+				numSkips++
+				updateStats(it.Value())
+				continue
+			}
+
 			// See if we need to skip this key.
 			if len(skipKey) > 0 {
 				if y.SameKey(it.Key(), skipKey) {
@@ -550,9 +707,9 @@ func (s *levelsController) compactBuildTables(
 				}
 				lastKey = y.SafeCopy(lastKey, it.Key())
 				numVersions = 0
+				numVersionsToKeep = s.kv.numVersionsToKeep(y.ParseKey(lastKey))
 			}
 
-			vs := it.Value()
 			version := y.ParseTs(it.Key())
 			// Do not discard entries inserted by merge operator. These entries will be
 			// discarded once they're merged
@@ -562,8 +719,8 @@ func (s *levelsController) compactBuildTables(
 				// only valid version for a running transaction.
 				numVersions++
 				lastValidVersion := vs.Meta&bitDiscardEarlierVersions > 0
-				if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) ||
-					numVersions > s.kv.opt.NumVersionsToKeep ||
+				if isDeletedOrExpired(vs.Meta, vs.ExpiresAt, s.kv.opt.now()) ||
+					numVersions > numVersionsToKeep ||
 					lastValidVersion {
 					// If this version of the key is deleted or expired, skip all the rest of the
 					// versions. Ensure that we're only removing versions below readTs.
@@ -592,7 +749,8 @@ func (s *levelsController) compactBuildTables(
 		}
 		// It was true that it.Valid() at least once in the loop above, which means we
 		// called Add() at least once, and builder is not Empty().
-		s.kv.opt.Debugf("LOG Compact. Added %d keys. Skipped %d keys. Iteration took: %v",
+		s.kv.opt.logger(LogComponentCompact).Debugf(
+			"LOG Compact. Added %d keys. Skipped %d keys. Iteration took: %v",
 			numKeys, numSkips, time.Since(timeStart))
 		build := func(fileID uint64) (*table.Table, error) {
 			fd, err := y.CreateSyncedFile(table.NewFilename(fileID, s.kv.opt.Dir), true)
@@ -600,8 +758,17 @@ func (s *levelsController) compactBuildTables(
 				return nil, errors.Wrapf(err, "While opening new table: %d", fileID)
 			}
 
-			if _, err := fd.Write(builder.Finish()); err != nil {
-				return nil, errors.Wrapf(err, "Unable to write to file: %d", fileID)
+			data := builder.Finish()
+			for len(data) > 0 {
+				n := len(data)
+				if n > compactionIOChunk {
+					n = compactionIOChunk
+				}
+				s.kv.compactionLimiter.Wait(int64(n))
+				if _, err := fd.Write(data[:n]); err != nil {
+					return nil, errors.Wrapf(err, "Unable to write to file: %d", fileID)
+				}
+				data = data[n:]
 			}
 			tbl, err := table.OpenTable(fd, bopts)
 			// decrRef is added below.
@@ -627,6 +794,8 @@ func (s *levelsController) compactBuildTables(
 		}(builder)
 	}
 
+	s.kv.compactionLimiter.Wait(ioRead)
+
 	newTables := make([]*table.Table, 0, 20)
 	// Wait for all table builders to finish.
 	var firstErr error
@@ -637,6 +806,13 @@ func (s *levelsController) compactBuildTables(
 			firstErr = res.err
 		}
 	}
+	if firstErr == nil {
+		// The iteration could have stopped early because of a corrupt table. The new tables
+		// would be missing keys in that case.
+		if err := y.IteratorErr(it); err != nil {
+			firstErr = errors.Wrap(err, "While iterating over tables")
+		}
+	}
 
 	if firstErr == nil {
 		// Ensure created files' directory entries are visible.  We don't mind the extra latency
@@ -654,15 +830,19 @@ func (s *levelsController) compactBuildTables(
 			}
 		}
 		errorReturn := errors.Wrapf(firstErr, "While running compaction for: %+v", cd)
-		return nil, nil, errorReturn
+		return nil, nil, 0, errorReturn
 	}
 
 	sort.Slice(newTables, func(i, j int) bool {
-		return y.CompareKeys(newTables[i].Biggest(), newTables[j].Biggest()) < 0
+		return s.kv.cmp.CompareKeys(newTables[i].Biggest(), newTables[j].Biggest()) < 0
 	})
 	s.kv.vlog.updateDiscardStats(discardStats)
-	s.kv.opt.Debugf("Discard stats: %v", discardStats)
-	return newTables, func() error { return decrRefs(newTables) }, nil
+	s.kv.opt.logger(LogComponentCompact).Debugf("Discard stats: %v", discardStats)
+	var discarded int64
+	for _, size := range discardStats {
+		discarded += size
+	}
+	return newTables, func() error { return decrRefs(newTables) }, discarded, nil
 }
 
 func buildChangeSet(cd *compactDef, newTables []*table.Table) pb.ManifestChangeSet {
@@ -698,6 +878,7 @@ type compactDef struct {
 	thisSize int64
 
 	dropPrefix []byte
+	stats      *compactStats
 }
 
 func (cd *compactDef) lockLevels() {
@@ -721,7 +902,7 @@ func (s *levelsController) fillTablesL0(cd *compactDef) bool {
 	}
 	cd.thisRange = infRange
 
-	kr := getKeyRange(cd.top...)
+	kr := getKeyRange(s.kv.cmp, cd.top...)
 	left, right := cd.nextLevel.overlappingTables(levelHandlerRLocked{}, kr)
 	cd.bot = make([]*table.Table, right-left)
 	copy(cd.bot, cd.nextLevel.tables[left:right])
@@ -729,7 +910,7 @@ func (s *levelsController) fillTablesL0(cd *compactDef) bool {
 	if len(cd.bot) == 0 {
 		cd.nextRange = kr
 	} else {
-		cd.nextRange = getKeyRange(cd.bot...)
+		cd.nextRange = getKeyRange(s.kv.cmp, cd.bot...)
 	}
 
 	if !s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, *cd) {
@@ -748,7 +929,7 @@ func (s *levelsController) sortByOverlap(tables []*table.Table, cd *compactDef)
 	tableOverlap := make([]int, len(tables))
 	for i := range tables {
 		// get key range for table
-		tableRange := getKeyRange(tables[i])
+		tableRange := getKeyRange(s.kv.cmp, tables[i])
 		// get overlap with next level
 		left, right := cd.nextLevel.overlappingTables(levelHandlerRLocked{}, tableRange)
 		tableOverlap[i] = right - left
@@ -765,6 +946,54 @@ func (s *levelsController) fillTables(cd *compactDef) bool {
 
 	tables := make([]*table.Table, len(cd.thisLevel.tables))
 	copy(tables, cd.thisLevel.tables)
+	return s.fillTablesFrom(cd, tables)
+}
+
+// fillTablesInRange is like fillTables, but only picks one of the tables in ids.
+func (s *levelsController) fillTablesInRange(cd *compactDef, ids map[uint64]struct{}) bool {
+	cd.lockLevels()
+	defer cd.unlockLevels()
+
+	var tables []*table.Table
+	for _, t := range cd.thisLevel.tables {
+		if _, ok := ids[t.ID()]; ok {
+			tables = append(tables, t)
+		}
+	}
+	return s.fillTablesFrom(cd, tables)
+}
+
+// fillTablesWithinLevel picks the tables of cd.thisLevel in ids, to compact them within the level.
+// Tables which are not in ids, but sit between two of them, are picked too, so that the new tables
+// don't overlap the rest of the level.
+func (s *levelsController) fillTablesWithinLevel(cd *compactDef, ids map[uint64]struct{}) bool {
+	// thisLevel and nextLevel are the same, don't lock them twice.
+	cd.thisLevel.RLock()
+	defer cd.thisLevel.RUnlock()
+
+	first, last := -1, -1
+	for i, t := range cd.thisLevel.tables {
+		if _, ok := ids[t.ID()]; ok {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return false
+	}
+	cd.top = []*table.Table{}
+	cd.bot = make([]*table.Table, last-first+1)
+	copy(cd.bot, cd.thisLevel.tables[first:last+1])
+	cd.thisRange = getKeyRange(s.kv.cmp, cd.bot...)
+	cd.nextRange = cd.thisRange
+	return s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, *cd)
+}
+
+// fillTablesFrom picks one of the given tables of cd.thisLevel for compaction, along with the
+// tables it overlaps in cd.nextLevel. Both levels must be RLocked.
+func (s *levelsController) fillTablesFrom(cd *compactDef, tables []*table.Table) bool {
 	if len(tables) == 0 {
 		return false
 	}
@@ -776,7 +1005,7 @@ func (s *levelsController) fillTables(cd *compactDef) bool {
 
 	for _, t := range tables {
 		cd.thisSize = t.Size()
-		cd.thisRange = getKeyRange(t)
+		cd.thisRange = getKeyRange(s.kv.cmp, t)
 		if s.cstatus.overlapsWith(cd.thisLevel.level, cd.thisRange) {
 			continue
 		}
@@ -794,7 +1023,7 @@ func (s *levelsController) fillTables(cd *compactDef) bool {
 			}
 			return true
 		}
-		cd.nextRange = getKeyRange(cd.bot...)
+		cd.nextRange = getKeyRange(s.kv.cmp, cd.bot...)
 
 		if s.cstatus.overlapsWith(cd.nextLevel.level, cd.nextRange) {
 			continue
@@ -809,6 +1038,8 @@ func (s *levelsController) fillTables(cd *compactDef) bool {
 
 func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
 	timeStart := time.Now()
+	atomic.AddInt32(&s.numCompactions, 1)
+	defer atomic.AddInt32(&s.numCompactions, -1)
 
 	thisLevel := cd.thisLevel
 	nextLevel := cd.nextLevel
@@ -816,7 +1047,7 @@ func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
 	// Table should never be moved directly between levels, always be rewritten to allow discarding
 	// invalid versions.
 
-	newTables, decr, err := s.compactBuildTables(l, cd)
+	newTables, decr, discarded, err := s.compactBuildTables(l, cd)
 	if err != nil {
 		return err
 	}
@@ -827,6 +1058,8 @@ func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
 		}
 	}()
 	changeSet := buildChangeSet(&cd, newTables)
+	// The key range is computed while the keys of the compacted tables can still be read.
+	compacted := getKeyRange(s.kv.cmp, append(cd.top[:len(cd.top):len(cd.top)], cd.bot...)...)
 
 	// We write to the manifest _before_ we delete files (and after we created files)
 	if err := s.kv.manifest.addChanges(changeSet.Changes); err != nil {
@@ -845,7 +1078,28 @@ func (s *levelsController) runCompactDef(l int, cd compactDef) (err error) {
 	// Note: For level 0, while doCompact is running, it is possible that new tables are added.
 	// However, the tables are added only to the end, so it is ok to just delete the first table.
 
-	s.kv.opt.Infof("LOG Compact %d->%d, del %d tables, add %d tables, took %v\n",
+	y.NumCompactions.Add(thisLevel.strLevel, 1)
+	if cd.stats != nil {
+		cd.stats.add(&cd, newTables)
+	}
+	s.kv.sendCompactionEvent(CompactionEvent{
+		FromLevel:      thisLevel.level,
+		ToLevel:        nextLevel.level,
+		InputTables:    len(cd.top) + len(cd.bot),
+		InputBytes:     tablesSize(cd.top) + tablesSize(cd.bot),
+		OutputTables:   len(newTables),
+		OutputBytes:    tablesSize(newTables),
+		DiscardedBytes: discarded,
+		Duration:       time.Since(timeStart),
+	})
+	// The compaction might have discarded the last versions deleted by a range tombstone.
+	s.kv.rangeDels.touch(compacted)
+	select {
+	case s.kv.rangeDelGC <- struct{}{}:
+	default:
+	}
+	s.kv.opt.logger(LogComponentCompact).with("level", thisLevel.level).Infof(
+		"LOG Compact %d->%d, del %d tables, add %d tables, took %v\n",
 		thisLevel.level, nextLevel.level, len(cd.top)+len(cd.bot),
 		len(newTables), time.Since(timeStart))
 	return nil
@@ -863,11 +1117,12 @@ func (s *levelsController) doCompact(p compactionPriority) error {
 		thisLevel:  s.levels[l],
 		nextLevel:  s.levels[l+1],
 		dropPrefix: p.dropPrefix,
+		stats:      p.stats,
 	}
 	cd.elog.SetMaxEvents(100)
 	defer cd.elog.Finish()
 
-	s.kv.opt.Infof("Got compaction priority: %+v", p)
+	s.kv.opt.logger(LogComponentCompact).Infof("Got compaction priority: %+v", p)
 
 	// While picking tables to be compacted, both levels' tables are expected to
 	// remain unchanged.
@@ -883,19 +1138,263 @@ func (s *levelsController) doCompact(p compactionPriority) error {
 	}
 	defer s.cstatus.delete(cd) // Remove the ranges from compaction status.
 
-	s.kv.opt.Infof("Running for level: %d\n", cd.thisLevel.level)
+	log := s.kv.opt.logger(LogComponentCompact).with("level", cd.thisLevel.level)
+	log.Infof("Running for level: %d\n", cd.thisLevel.level)
 	s.cstatus.toLog(cd.elog)
 	if err := s.runCompactDef(l, cd); err != nil {
 		// This compaction couldn't be done successfully.
-		s.kv.opt.Warningf("LOG Compact FAILED with error: %+v: %+v", err, cd)
+		log.Warningf("LOG Compact FAILED with error: %+v: %+v", err, cd)
 		return err
 	}
 
 	s.cstatus.toLog(cd.elog)
-	s.kv.opt.Infof("Compaction for level: %d DONE", cd.thisLevel.level)
+	log.Infof("Compaction for level: %d DONE", cd.thisLevel.level)
 	return nil
 }
 
+// compactRange compacts all the tables which overlap [start, end) down to the bottom level, the
+// deepest one which has tables, and then rewrites the tables of the bottom level which overlap the
+// range. An empty end means no upper bound. The tables are registered in
+// the compaction status like for any other compaction, so the background compactors never pick
+// the same tables; if they are busy, compactRange waits for them. It returns true if at least one
+// compaction was run. If stats is not nil, the compactions are accounted in it.
+func (s *levelsController) compactRange(ctx context.Context, start, end []byte,
+	stats *compactStats) (bool, error) {
+	overlaps := func(t *table.Table) bool {
+		if s.kv.cmp.Compare(y.ParseKey(t.Biggest()), start) < 0 {
+			return false
+		}
+		return len(end) == 0 || s.kv.cmp.Compare(y.ParseKey(t.Smallest()), end) < 0
+	}
+	// pending returns the tables of level l which overlap the range. If ids is not nil, only
+	// the tables in ids are returned, so that tables which move into the level while it's being
+	// compacted are not picked up again.
+	pending := func(l int, ids map[uint64]struct{}) map[uint64]struct{} {
+		lh := s.levels[l]
+		lh.RLock()
+		defer lh.RUnlock()
+		res := make(map[uint64]struct{})
+		for _, t := range lh.tables {
+			if _, ok := ids[t.ID()]; (ids == nil || ok) && overlaps(t) {
+				res[t.ID()] = struct{}{}
+			}
+		}
+		return res
+	}
+	wait := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		}
+	}
+
+	var compacted bool
+	run := func(cd compactDef) error {
+		err := s.runCompactDef(cd.thisLevel.level, cd)
+		s.cstatus.delete(cd)
+		cd.elog.Finish()
+		if err != nil {
+			s.kv.opt.logger(LogComponentCompact).Warningf(
+				"LOG Compact FAILED with error: %+v: %+v", err, cd)
+			return err
+		}
+		compacted = true
+		return nil
+	}
+
+	bottom := 1
+	for i, l := range s.levels {
+		if l.numTables() > 0 && i > bottom {
+			bottom = i
+		}
+	}
+
+	for l := 0; l < bottom; l++ {
+		for ids := pending(l, nil); len(ids) > 0; ids = pending(l, ids) {
+			if err := ctx.Err(); err != nil {
+				return compacted, err
+			}
+			if l == 0 {
+				// Level 0 tables overlap each other, so they are always compacted together.
+				cp := compactionPriority{level: 0, score: 1.0, stats: stats}
+				switch err := s.doCompact(cp); err {
+				case nil:
+					compacted = true
+				case errFillTables:
+					if err := wait(); err != nil {
+						return compacted, err
+					}
+				default:
+					return compacted, err
+				}
+				continue
+			}
+
+			cd := compactDef{
+				elog:      trace.New(fmt.Sprintf("Badger.L%d", l), "CompactRange"),
+				thisLevel: s.levels[l],
+				nextLevel: s.levels[l+1],
+				stats:     stats,
+			}
+			if !s.fillTablesInRange(&cd, ids) {
+				cd.elog.Finish()
+				if err := wait(); err != nil {
+					return compacted, err
+				}
+				continue
+			}
+			if err := run(cd); err != nil {
+				return compacted, err
+			}
+		}
+	}
+
+	// The compactions into the bottom level had to keep the delete markers, as the level already
+	// had the keys. Rewrite the tables of the range within the level to get rid of them.
+	for ids := pending(bottom, nil); len(ids) > 0; ids = pending(bottom, ids) {
+		if err := ctx.Err(); err != nil {
+			return compacted, err
+		}
+		cd := compactDef{
+			elog:      trace.New(fmt.Sprintf("Badger.L%d", bottom), "CompactRange"),
+			thisLevel: s.levels[bottom],
+			nextLevel: s.levels[bottom],
+			stats:     stats,
+		}
+		if !s.fillTablesWithinLevel(&cd, ids) {
+			cd.elog.Finish()
+			if err := wait(); err != nil {
+				return compacted, err
+			}
+			continue
+		}
+		if err := run(cd); err != nil {
+			return compacted, err
+		}
+	}
+	return compacted, nil
+}
+
+// rebuildBloomFilter replaces the table t of level lh with a copy whose bloom filter is rebuilt
+// with the false positive probability fp. It returns false if t is being compacted, or isn't in
+// the level anymore.
+func (s *levelsController) rebuildBloomFilter(lh *levelHandler, t *table.Table, fp float64) (
+	bool, error) {
+	return s.replaceTable(lh, t, "Rebuilt bloom filter of", func() ([]byte, *pb.DataKey, error) {
+		data, err := t.RebuildBloomFilter(fp)
+		if err != nil {
+			return nil, nil, err
+		}
+		dk, err := s.kv.registry.dataKey(t.KeyID())
+		if err != nil {
+			return nil, nil, y.Wrapf(err, "Error while retrieving datakey in rebuildBloomFilter")
+		}
+		return data, dk, nil
+	})
+}
+
+// encryptTable replaces the table t of level lh with a copy encrypted with the latest data key.
+// It returns false if t is being compacted, or isn't in the level anymore.
+func (s *levelsController) encryptTable(lh *levelHandler, t *table.Table) (bool, error) {
+	return s.replaceTable(lh, t, "Encrypted", func() ([]byte, *pb.DataKey, error) {
+		dk, err := s.kv.registry.latestDataKey()
+		if err != nil {
+			return nil, nil, y.Wrapf(err, "Error while retrieving datakey in encryptTable")
+		}
+		bopts := buildTableOptions(s.kv)
+		bopts.BloomFalsePositive = s.kv.opt.bloomFalsePositive(lh.level)
+		bopts.Compression = t.CompressionType()
+		bopts.DataKey = dk
+		builder := table.NewTableBuilder(bopts)
+		defer builder.Close()
+		it := t.NewIterator(false)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			vs := it.Value()
+			var vp valuePointer
+			if vs.Meta&bitValuePointer > 0 {
+				vp.Decode(vs.Value)
+			}
+			builder.Add(it.Key(), vs, vp.Len)
+		}
+		// Don't replace the table with a truncated copy.
+		if err := it.Err(); err != nil {
+			return nil, nil, y.Wrapf(err, "while reading the table %d to encrypt it", t.ID())
+		}
+		return builder.Finish(), dk, nil
+	})
+}
+
+// replaceTable replaces the table t of level lh with the table built by build, which returns
+// the content of the table file along with the data key it's encrypted with. It returns false if
+// t is being compacted, or isn't in the level anymore.
+func (s *levelsController) replaceTable(lh *levelHandler, t *table.Table, what string,
+	build func() ([]byte, *pb.DataKey, error)) (bool, error) {
+	// Reserve the range of the table within its level, so that no compaction picks it up.
+	cd := compactDef{
+		thisLevel: lh,
+		nextLevel: lh,
+		thisRange: getKeyRange(s.kv.cmp, t),
+	}
+	cd.nextRange = cd.thisRange
+	lh.RLock()
+	found := false
+	for _, lt := range lh.tables {
+		found = found || lt == t
+	}
+	reserved := found && s.cstatus.compareAndAdd(thisAndNextLevelRLocked{}, cd)
+	lh.RUnlock()
+	if !reserved {
+		return false, nil
+	}
+	defer s.cstatus.delete(cd)
+
+	data, dk, err := build()
+	if err != nil {
+		return false, err
+	}
+	fileID := s.reserveFileID()
+	fname := table.NewFilename(fileID, s.kv.opt.Dir)
+	fd, err := y.CreateSyncedFile(fname, true)
+	if err != nil {
+		return false, errors.Wrapf(err, "While creating table: %s", fname)
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		os.Remove(fname)
+		return false, errors.Wrapf(err, "Unable to write to file: %s", fname)
+	}
+	topt := buildTableOptions(s.kv)
+	topt.Compression = t.CompressionType()
+	topt.DataKey = dk
+	newTable, err := table.OpenTable(fd, topt)
+	if err != nil {
+		os.Remove(fname)
+		return false, errors.Wrapf(err, "Unable to open table: %s", fname)
+	}
+	// The new table is referenced by the level, drop the reference of OpenTable once done.
+	defer func() { _ = newTable.DecrRef() }()
+	if err := s.kv.syncDir(s.kv.opt.Dir); err != nil {
+		return false, err
+	}
+
+	changes := []*pb.ManifestChange{
+		newCreateChange(newTable.ID(), lh.level, newTable.KeyID(), newTable.CompressionType()),
+		newDeleteChange(t.ID()),
+	}
+	if err := s.kv.manifest.addChanges(changes); err != nil {
+		return false, err
+	}
+	if err := lh.swapTable(t, newTable); err != nil {
+		return false, err
+	}
+	s.kv.opt.logger(LogComponentCompact).Infof("%s table %d at level %d into table %d\n",
+		what, t.ID(), lh.level, newTable.ID())
+	return true, nil
+}
+
 func (s *levelsController) addLevel0Table(t *table.Table) error {
 	// Add table to manifest file only if it is not opened in memory. We don't want to add a table
 	// to the manifest file if it exists only in memory.
@@ -913,6 +1412,7 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 	}
 
 	for !s.levels[0].tryAddLevel0Table(t) {
+		atomic.StoreInt32(&s.l0Stalled, 1)
 		// Stall. Make sure all levels are healthy before we unstall.
 		var timeStart time.Time
 		{
@@ -946,8 +1446,10 @@ func (s *levelsController) addLevel0Table(t *table.Table) error {
 		{
 			s.elog.Printf("UNSTALLED UNSTALLED UNSTALLED: %v\n", time.Since(timeStart))
 			lastUnstalled = time.Now()
+			y.WriteStallTime.Add(s.kv.opt.Dir, int64(time.Since(timeStart)/time.Millisecond))
 		}
 	}
+	atomic.StoreInt32(&s.l0Stalled, 0)
 
 	return nil
 }
@@ -991,6 +1493,18 @@ func (s *levelsController) get(key []byte, maxVs *y.ValueStruct, startLevel int)
 	return y.ValueStruct{}, nil
 }
 
+// getMulti looks up the keys which are not done yet, like get. The keys must be
+// sorted in increasing order.
+func (s *levelsController) getMulti(keys [][]byte, vals []y.ValueStruct, done []bool) error {
+	// Just like with get, it's important we iterate the levels from 0 on upward.
+	for _, h := range s.levels {
+		if err := h.getMulti(keys, vals, done); err != nil {
+			return errors.Wrapf(err, "get multiple keys")
+		}
+	}
+	return nil
+}
+
 func appendIteratorsReversed(out []y.Iterator, th []*table.Table, reversed bool) []y.Iterator {
 	for i := len(th) - 1; i >= 0; i-- {
 		// This will increment the reference of the table handler.
@@ -1011,14 +1525,180 @@ func (s *levelsController) appendIterators(
 	return iters
 }
 
+// blocks returns the blocks of all the tables of the LSM tree, sorted by key.
+func (s *levelsController) blocks() []table.BlockInfo {
+	var blocks []table.BlockInfo
+	for _, level := range s.levels {
+		blocks = level.blocks(blocks)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return s.kv.cmp.CompareKeys(blocks[i].Key, blocks[j].Key) < 0
+	})
+	return blocks
+}
+
+// diskSize returns the total size of the tables of all the levels.
+func (s *levelsController) diskSize() int64 {
+	var size int64
+	for _, level := range s.levels {
+		size += level.getTotalSize()
+	}
+	return size
+}
+
+// liveSize estimates the size of the tables holding the live data, like RocksDB's
+// estimate-live-data-size: going from the last level up, it adds up the sizes of the tables whose
+// key range doesn't overlap the range of a table already counted. The newer versions of a key,
+// found in the upper levels, are then assumed to have replaced the older versions, rather than
+// added to them.
+func (s *levelsController) liveSize() int64 {
+	var counted []keyRange
+	var size int64
+	for i := len(s.levels) - 1; i >= 0; i-- {
+		lh := s.levels[i]
+		lh.RLock()
+		for _, t := range lh.tables {
+			kr := getKeyRange(s.kv.cmp, t)
+			overlaps := false
+			for _, c := range counted {
+				if c.overlapsWith(s.kv.cmp, kr) {
+					overlaps = true
+					break
+				}
+			}
+			if !overlaps {
+				counted = append(counted, kr)
+				size += t.Size()
+			}
+		}
+		lh.RUnlock()
+	}
+	return size
+}
+
+// sampleKeys returns the sorted keys, without timestamp, an iterator with the given options
+// samples to return about one key every n entries. See IteratorOptions.SampleEveryN.
+func (s *levelsController) sampleKeys(opt *IteratorOptions, n int) [][]byte {
+	var keys [][]byte
+	for _, level := range s.levels {
+		keys = level.sampleKeys(keys, opt, n)
+	}
+	for i := range keys {
+		keys[i] = y.ParseKey(keys[i])
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.kv.cmp.Compare(keys[i], keys[j]) < 0
+	})
+	// The tables of the levels overlap, but a key is a sample only once.
+	out := keys[:0]
+	for _, k := range keys {
+		if len(out) == 0 || !bytes.Equal(out[len(out)-1], k) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
 // TableInfo represents the information about a table.
 type TableInfo struct {
-	ID          uint64
-	Level       int
+	// ID is the ID of the table, from which its file name is built. The IDs grow as the tables are
+	// built, by flushes and compactions alike, so a bigger ID means a newer table.
+	ID    uint64
+	Level int
+	// Left and Right are the smallest and biggest keys of the table, with timestamp.
 	Left        []byte
 	Right       []byte
-	KeyCount    uint64 // Number of keys in the table
+	KeyCount    uint64 // Number of keys in the table, only counted if asked to.
 	EstimatedSz uint64
+	// Size is the size of the table file, or of the level 0 table kept in memory.
+	Size int64
+	// NumEntries is the number of entries of the table, every version of a key counting, as
+	// recorded in its index. It's zero for the tables built before it was recorded.
+	NumEntries uint64
+	// Compression is the compression of the blocks of the table.
+	Compression options.CompressionType
+	// Encrypted is true if the table is encrypted, with the data key KeyID.
+	Encrypted bool
+	// CreatedAt is when the table was written.
+	CreatedAt time.Time
+	// BloomFalsePositive is the false positive probability the bloom filter of the table was built
+	// with. It is zero for the tables built before it was recorded.
+	BloomFalsePositive float64
+	// KeyID is the ID of the data key the table is encrypted with. It is zero if the table isn't
+	// encrypted.
+	KeyID uint64
+}
+
+// LevelStat represents the statistics of a level of the LSM tree.
+type LevelStat struct {
+	Level     int
+	NumTables int
+	Size      int64 // Size of the tables on disk.
+	MaxSize   int64 // Size above which level 1 and beyond get compacted.
+
+	// Smallest and Biggest are the smallest and biggest keys of the level, without timestamp. They
+	// are nil if the level is empty.
+	Smallest []byte
+	Biggest  []byte
+
+	// Score is the score the compaction picker assigns to the level. Levels with a score of 1 or
+	// more are compacted, those with the highest score first.
+	Score float64
+
+	// OverlapRatio is the size of the tables of the next level which overlap the tables of this
+	// level, divided by the size of this level. It approximates how many bytes a compaction of
+	// this level rewrites in the next level, per byte it moves down. It is zero for the last level.
+	OverlapRatio float64
+}
+
+func (s *levelsController) levelStats() []LevelStat {
+	type tableRange struct {
+		left, right []byte
+		size        int64
+	}
+	ranges := make([][]tableRange, len(s.levels))
+	stats := make([]LevelStat, len(s.levels))
+	for i, l := range s.levels {
+		stat := &stats[i]
+		l.RLock()
+		for _, t := range l.tables {
+			// Copy the keys, the tables might go away once the lock is released.
+			left := y.SafeCopy(nil, y.ParseKey(t.Smallest()))
+			right := y.SafeCopy(nil, y.ParseKey(t.Biggest()))
+			ranges[i] = append(ranges[i], tableRange{left: left, right: right, size: t.Size()})
+			if stat.Smallest == nil || s.kv.cmp.Compare(left, stat.Smallest) < 0 {
+				stat.Smallest = left
+			}
+			if stat.Biggest == nil || s.kv.cmp.Compare(right, stat.Biggest) > 0 {
+				stat.Biggest = right
+			}
+		}
+		stat.NumTables = len(l.tables)
+		stat.Size = l.totalSize
+		l.RUnlock()
+
+		stat.Level = i
+		stat.MaxSize = l.maxTotalSize
+		stat.Score = s.score(i)
+	}
+
+	for i := 0; i+1 < len(stats); i++ {
+		if stats[i].Size == 0 {
+			continue
+		}
+		var overlap int64
+		for _, bot := range ranges[i+1] {
+			for _, top := range ranges[i] {
+				if s.kv.cmp.Compare(bot.left, top.right) <= 0 &&
+					s.kv.cmp.Compare(bot.right, top.left) >= 0 {
+					overlap += bot.size
+					break
+				}
+			}
+		}
+		stats[i].OverlapRatio = float64(overlap) / float64(stats[i].Size)
+	}
+	return stats
 }
 
 func (s *levelsController) getTableInfo(withKeysCount bool) (result []TableInfo) {
@@ -1035,12 +1715,19 @@ func (s *levelsController) getTableInfo(withKeysCount bool) (result []TableInfo)
 			}
 
 			info := TableInfo{
-				ID:          t.ID(),
-				Level:       l.level,
-				Left:        t.Smallest(),
-				Right:       t.Biggest(),
-				KeyCount:    count,
-				EstimatedSz: t.EstimatedSize(),
+				ID:                 t.ID(),
+				Level:              l.level,
+				Left:               t.Smallest(),
+				Right:              t.Biggest(),
+				KeyCount:           count,
+				EstimatedSz:        t.EstimatedSize(),
+				BloomFalsePositive: t.BloomFalsePositive(),
+				KeyID:              t.KeyID(),
+				Size:               t.Size(),
+				NumEntries:         t.KeyCount(),
+				Compression:        t.CompressionType(),
+				Encrypted:          t.KeyID() != 0,
+				CreatedAt:          t.CreatedAt(),
 			}
 			result = append(result, info)
 		}
@@ -1055,6 +1742,27 @@ func (s *levelsController) getTableInfo(withKeysCount bool) (result []TableInfo)
 	return
 }
 
+// estimateKeyCount sums up the estimated number of entries with the given prefix of all the
+// tables. It returns false if any of the tables had to be counted because it has no key count.
+func (s *levelsController) estimateKeyCount(prefix []byte) (uint64, bool, error) {
+	var total uint64
+	fromIndex := true
+	for _, l := range s.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			count, ok, err := t.EstimateKeyCount(prefix)
+			if err != nil {
+				l.RUnlock()
+				return 0, false, errors.Wrapf(err, "while estimating key count of table %d", t.ID())
+			}
+			total += count
+			fromIndex = fromIndex && ok
+		}
+		l.RUnlock()
+	}
+	return total, fromIndex, nil
+}
+
 // verifyChecksum verifies checksum for all tables on all levels.
 func (s *levelsController) verifyChecksum() error {
 	var tables []*table.Table
@@ -1070,8 +1778,8 @@ func (s *levelsController) verifyChecksum() error {
 		for _, t := range tables {
 			errChkVerify := t.VerifyChecksum()
 			if err := t.DecrRef(); err != nil {
-				s.kv.opt.Errorf("unable to decrease reference of table: %s while "+
-					"verifying checksum with error: %s", t.Filename(), err)
+				s.kv.opt.logger(LogComponentVerify).Errorf("unable to decrease reference of "+
+					"table: %s while verifying checksum with error: %s", t.Filename(), err)
 			}
 
 			if errChkVerify != nil {
@@ -17,8 +17,10 @@
 package badger
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strings"
 )
 
 // Logger is implemented by any logging system that is used for standard logs.
@@ -29,6 +31,98 @@ type Logger interface {
 	Debugf(string, ...interface{})
 }
 
+// StructuredLogger is implemented by logging systems which record the messages along with key/value
+// pairs, e.g. to output them as JSON. When one is given in Options, Badger uses it for its own
+// messages instead of the Logger.
+//
+// The keys are strings. The first pair is always the component of Badger which logged the message,
+// with the key "component" and one of the LogComponent values, so that the messages of a component
+// can be filtered. Some messages have further pairs, e.g. the "level" of a compaction.
+type StructuredLogger interface {
+	Error(msg string, keyvals ...interface{})
+	Warning(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Debug(msg string, keyvals ...interface{})
+}
+
+// The components of Badger, given to a StructuredLogger with the key "component".
+const (
+	// LogComponentCompact logs the compactions of the LSM tree.
+	LogComponentCompact = "compact"
+	// LogComponentFlush logs the flushes of the memtables to level 0.
+	LogComponentFlush = "flush"
+	// LogComponentVlog logs the value log, and its garbage collection.
+	LogComponentVlog = "vlog"
+	// LogComponentRecovery logs the opening of the DB, including the replay of the value log.
+	LogComponentRecovery = "recovery"
+	// LogComponentWrite logs the writes of the transactions and write batches.
+	LogComponentWrite = "write"
+	// LogComponentDrop logs DropAll and DropPrefix.
+	LogComponentDrop = "drop"
+	// LogComponentStream logs Stream, backups and StreamWriter.
+	LogComponentStream = "stream"
+	// LogComponentMerge logs the MergeOperator.
+	LogComponentMerge = "merge"
+	// LogComponentVerify logs the verification of checksums.
+	LogComponentVerify = "verify"
+)
+
+// componentLogger logs the messages of a component of Badger to the StructuredLogger specified in
+// opts, or to its Logger if there is none, in which case the key/value pairs are dropped.
+type componentLogger struct {
+	opt     *Options
+	keyvals []interface{}
+}
+
+// logger returns the componentLogger for the given component.
+func (opt *Options) logger(component string) componentLogger {
+	return componentLogger{opt: opt, keyvals: []interface{}{"component", component}}
+}
+
+// with returns a componentLogger which adds the given key/value pairs to those of l.
+func (l componentLogger) with(keyvals ...interface{}) componentLogger {
+	kv := make([]interface{}, 0, len(l.keyvals)+len(keyvals))
+	kv = append(append(kv, l.keyvals...), keyvals...)
+	return componentLogger{opt: l.opt, keyvals: kv}
+}
+
+// msg formats a message of the printf style Logger for a StructuredLogger.
+func (l componentLogger) msg(format string, v []interface{}) string {
+	return strings.TrimSpace(fmt.Sprintf(format, v...))
+}
+
+func (l componentLogger) Errorf(format string, v ...interface{}) {
+	if sl := l.opt.StructuredLogger; sl != nil {
+		sl.Error(l.msg(format, v), l.keyvals...)
+		return
+	}
+	l.opt.Errorf(format, v...)
+}
+
+func (l componentLogger) Warningf(format string, v ...interface{}) {
+	if sl := l.opt.StructuredLogger; sl != nil {
+		sl.Warning(l.msg(format, v), l.keyvals...)
+		return
+	}
+	l.opt.Warningf(format, v...)
+}
+
+func (l componentLogger) Infof(format string, v ...interface{}) {
+	if sl := l.opt.StructuredLogger; sl != nil {
+		sl.Info(l.msg(format, v), l.keyvals...)
+		return
+	}
+	l.opt.Infof(format, v...)
+}
+
+func (l componentLogger) Debugf(format string, v ...interface{}) {
+	if sl := l.opt.StructuredLogger; sl != nil {
+		sl.Debug(l.msg(format, v), l.keyvals...)
+		return
+	}
+	l.opt.Debugf(format, v...)
+}
+
 // Errorf logs an ERROR log message to the logger specified in opts or to the
 // global logger if no logger is specified in opts.
 func (opt *Options) Errorf(format string, v ...interface{}) {
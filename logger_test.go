@@ -69,3 +69,47 @@ func TestNoDbLog(t *testing.T) {
 	opt.Warningf("test")
 	require.Equal(t, "WARNING: test", l.output)
 }
+
+type mockStructuredLogger struct {
+	msg     string
+	keyvals []interface{}
+}
+
+func (l *mockStructuredLogger) Error(msg string, keyvals ...interface{}) {
+	l.msg, l.keyvals = "ERROR: "+msg, keyvals
+}
+
+func (l *mockStructuredLogger) Warning(msg string, keyvals ...interface{}) {
+	l.msg, l.keyvals = "WARNING: "+msg, keyvals
+}
+
+func (l *mockStructuredLogger) Info(msg string, keyvals ...interface{}) {
+	l.msg, l.keyvals = "INFO: "+msg, keyvals
+}
+
+func (l *mockStructuredLogger) Debug(msg string, keyvals ...interface{}) {
+	l.msg, l.keyvals = "DEBUG: "+msg, keyvals
+}
+
+// Test that the messages of the components go to the structured logger, falling back to the
+// printf style logger.
+func TestComponentLog(t *testing.T) {
+	l := &mockLogger{}
+	opt := Options{Logger: l}
+	opt.logger(LogComponentCompact).with("level", 1).Infof("test %d\n", 1)
+	require.Equal(t, "INFO: test 1\n", l.output)
+
+	sl := &mockStructuredLogger{}
+	opt = opt.WithStructuredLogger(sl)
+	l.output = ""
+	log := opt.logger(LogComponentCompact)
+	log.Errorf("test %d\n", 1)
+	require.Equal(t, "ERROR: test 1", sl.msg)
+	require.Equal(t, []interface{}{"component", "compact"}, sl.keyvals)
+	log.with("level", 1).Warningf("test")
+	require.Equal(t, "WARNING: test", sl.msg)
+	require.Equal(t, []interface{}{"component", "compact", "level", 1}, sl.keyvals)
+	log.Debugf("test")
+	require.Equal(t, []interface{}{"component", "compact"}, sl.keyvals)
+	require.Empty(t, l.output)
+}
@@ -423,6 +423,68 @@ func TestDropPrefix(t *testing.T) {
 	db2.Close()
 }
 
+func TestDropPrefixAsync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opts := getTestOptions(dir)
+	db, err := Open(opts)
+	require.NoError(t, err)
+
+	N := 2000
+	writer := db.NewWriteBatch()
+	for _, prefix := range []string{"a", "b", "c"} {
+		for i := 0; i < N; i++ {
+			require.NoError(t, writer.Set([]byte(key(prefix, i)), val(false)))
+		}
+	}
+	require.NoError(t, writer.Flush())
+	// Reopen the DB to flush the memtables to tables.
+	require.NoError(t, db.Close())
+	db, err = Open(opts)
+	require.NoError(t, err)
+
+	ha, err := db.DropPrefixAsync([]byte("a"))
+	require.NoError(t, err)
+	hc, err := db.DropPrefixAsync([]byte("c"))
+	require.NoError(t, err)
+	// The keys are dropped right away, the tables are rewritten in the background.
+	require.Equal(t, N, numKeys(db))
+
+	for _, h := range []*DropPrefixHandle{ha, hc} {
+		_, err := h.Wait()
+		require.NoError(t, err)
+		select {
+		case <-h.Done():
+		default:
+			t.Fatal("Done should be closed once Wait returns")
+		}
+	}
+	require.Equal(t, N, numKeys(db))
+	// No version of the keys is left in the LSM tree.
+	for _, prefix := range []string{"a", "c"} {
+		all := rangeTombstone{start: []byte(prefix), end: prefixEnd([]byte(prefix)),
+			version: math.MaxUint64}
+		require.False(t, db.rangeHasOlderVersions(all))
+	}
+
+	// The keys written after the drop are kept.
+	txnSet(t, db, []byte(key("a", 0)), val(false), 0)
+	require.Equal(t, N+1, numKeys(db))
+	require.NoError(t, db.Close())
+
+	db, err = Open(opts)
+	require.NoError(t, err)
+	require.Equal(t, N+1, numKeys(db))
+
+	res, err := db.DropPrefixWithResult([]byte("b"))
+	require.NoError(t, err)
+	require.True(t, res.TablesRewritten > 0)
+	require.True(t, res.BytesReclaimed > 0)
+	require.Equal(t, 1, numKeys(db))
+	require.NoError(t, db.Close())
+}
+
 func TestDropPrefixWithPendingTxn(t *testing.T) {
 	dir, err := ioutil.TempDir("", "badger-test")
 	require.NoError(t, err)
@@ -638,3 +700,99 @@ func TestWriteBatchManagedMode(t *testing.T) {
 		require.NoError(t, err)
 	})
 }
+
+func TestTxnGetVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	db, err := OpenManaged(opt)
+	require.NoError(t, err)
+
+	key := []byte("key")
+	write := func(version uint64, val string) {
+		txn := db.NewTransactionAt(version, true)
+		defer txn.Discard()
+		if val == "" {
+			require.NoError(t, txn.Delete(key))
+		} else {
+			require.NoError(t, txn.Set(key, []byte(val)))
+		}
+		require.NoError(t, txn.CommitAt(version, nil))
+	}
+	// The first versions are flushed to the tables, the last ones stay in the memtable.
+	write(10, "v10")
+	write(20, "v20")
+	require.NoError(t, db.Close())
+	db, err = OpenManaged(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	write(30, "")
+	write(40, "v40")
+
+	get := func(readTs, maxVersion uint64) string {
+		txn := db.NewTransactionAt(readTs, false)
+		defer txn.Discard()
+		item, err := txn.GetVersion(key, maxVersion)
+		if err == ErrKeyNotFound {
+			return ""
+		}
+		require.NoError(t, err)
+		require.True(t, item.Version() <= maxVersion)
+		return string(getItemValue(t, item))
+	}
+	for _, tc := range []struct {
+		maxVersion uint64
+		want       string
+	}{
+		{5, ""}, {10, "v10"}, {15, "v10"}, {20, "v20"}, {25, "v20"}, {30, ""}, {35, ""},
+		{40, "v40"}, {math.MaxUint64, "v40"},
+	} {
+		require.Equal(t, tc.want, get(100, tc.maxVersion), "version %d", tc.maxVersion)
+	}
+	// The versions above the read timestamp are never seen.
+	require.Equal(t, "v20", get(25, 100))
+
+	normal, err := Open(DefaultOptions("").WithInmemory(true))
+	require.NoError(t, err)
+	defer normal.Close()
+	require.Panics(t, func() {
+		_ = normal.View(func(txn *Txn) error {
+			_, err := txn.GetVersion(key, 1)
+			return err
+		})
+	})
+}
+
+func TestTxnGetVersionDeleteRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := OpenManaged(getTestOptions(dir))
+	require.NoError(t, err)
+	defer db.Close()
+
+	key := []byte("k1")
+	txn := db.NewTransactionAt(1, true)
+	require.NoError(t, txn.Set(key, []byte("v1")))
+	require.NoError(t, txn.CommitAt(1, nil))
+	txn = db.NewTransactionAt(5, true)
+	require.NoError(t, txn.DeleteRange([]byte("k"), []byte("l")))
+	require.NoError(t, txn.CommitAt(5, nil))
+
+	txn = db.NewTransactionAt(10, true)
+	defer txn.Discard()
+	_, err = txn.GetVersion(key, 10)
+	require.Equal(t, ErrKeyNotFound, err)
+	// The range delete at 5 isn't visible below it.
+	item, err := txn.GetVersion(key, 3)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), item.Version())
+
+	// Neither are the range deletes of the transaction, like its pending writes.
+	require.NoError(t, txn.DeleteRange([]byte("a"), []byte("z")))
+	_, err = txn.Get(key)
+	require.Equal(t, ErrKeyNotFound, err)
+	_, err = txn.GetVersion(key, 3)
+	require.NoError(t, err)
+}
@@ -18,6 +18,7 @@ package badger
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v2/y"
@@ -31,6 +32,11 @@ type MergeOperator struct {
 	db     *DB
 	key    []byte
 	closer *y.Closer
+
+	// pending is the number of values added since the last merge, and maxPending the number at
+	// which Add merges them right away. Both are accessed via atomics.
+	pending    int32
+	maxPending int32
 }
 
 // MergeFunc accepts two byte slices, one representing an existing value, and
@@ -100,13 +106,25 @@ func (op *MergeOperator) iterateAndMerge() (newVal []byte, latest uint64, err er
 }
 
 func (op *MergeOperator) compact() error {
+	_, err := op.merge(false)
+	if err == ErrKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// merge applies the merge function to the values added since the last merge, and writes the
+// result back to the DB. If wait is set, it returns once the result has been written.
+func (op *MergeOperator) merge(wait bool) ([]byte, error) {
 	op.Lock()
 	defer op.Unlock()
+	// The values added from now on are left for the next merge.
+	atomic.StoreInt32(&op.pending, 0)
 	val, version, err := op.iterateAndMerge()
-	if err == ErrKeyNotFound || err == errNoMerge {
-		return nil
+	if err == errNoMerge {
+		return val, nil
 	} else if err != nil {
-		return err
+		return nil, err
 	}
 	entries := []*Entry{
 		{
@@ -117,9 +135,13 @@ func (op *MergeOperator) compact() error {
 	}
 	// Write value back to the DB. It is important that we do not set the bitMergeEntry bit
 	// here. When compaction happens, all the older merged entries will be removed.
-	return op.db.batchSetAsync(entries, func(err error) {
+	if wait {
+		return val, op.db.batchSet(entries)
+	}
+	return val, op.db.batchSetAsync(entries, func(err error) {
 		if err != nil {
-			op.db.opt.Errorf("failed to insert the result of merge compaction: %s", err)
+			op.db.opt.logger(LogComponentMerge).Errorf(
+				"failed to insert the result of merge compaction: %s", err)
 		}
 	})
 }
@@ -135,7 +157,8 @@ func (op *MergeOperator) runCompactions(dur time.Duration) {
 		case <-ticker.C: // wait for tick
 		}
 		if err := op.compact(); err != nil {
-			op.db.opt.Errorf("failure while running merge operation: %s", err)
+			op.db.opt.logger(LogComponentMerge).Errorf(
+				"failure while running merge operation: %s", err)
 		}
 		if stop {
 			ticker.Stop()
@@ -146,14 +169,42 @@ func (op *MergeOperator) runCompactions(dur time.Duration) {
 
 // Add records a value in Badger which will eventually be merged by a background
 // routine into the values that were recorded by previous invocations to Add().
+//
+// If SetMaxPending was called, Add also merges the values right away once that many have been
+// added since the last merge.
 func (op *MergeOperator) Add(val []byte) error {
-	return op.db.Update(func(txn *Txn) error {
+	err := op.db.Update(func(txn *Txn) error {
 		return txn.SetEntry(NewEntry(op.key, val).withMergeBit())
 	})
+	if err != nil {
+		return err
+	}
+	max := atomic.LoadInt32(&op.maxPending)
+	if max > 0 && atomic.AddInt32(&op.pending, 1) >= max {
+		_, err = op.merge(true)
+	}
+	return err
+}
+
+// SetMaxPending bounds the number of values added but not yet merged to n. Once n values have
+// been added since the last merge, Add merges them and writes the result, without waiting for
+// the background routine. The merge function is applied in the same order as it would have
+// been otherwise, so it only needs to be associative. Zero, the default, disables the bound.
+func (op *MergeOperator) SetMaxPending(n int) {
+	atomic.StoreInt32(&op.maxPending, int32(n))
+}
+
+// Flush merges the values added so far, writes the result to the DB and returns it once it has
+// been written. After Flush, reading the key with a transaction returns the merged value.
+//
+// If Add has not been called even once, Flush will return ErrKeyNotFound.
+func (op *MergeOperator) Flush() ([]byte, error) {
+	return op.merge(true)
 }
 
 // Get returns the latest value for the merge operator, which is derived by
-// applying the merge function to all the values added so far.
+// applying the merge function to all the values added so far, including the ones
+// which have not been merged by the background routine yet.
 //
 // If Add has not been called even once, Get will return ErrKeyNotFound.
 func (op *MergeOperator) Get() ([]byte, error) {
@@ -150,7 +150,70 @@ func TestGetMergeOperator(t *testing.T) {
 		// compaction
 		require.Equal(t, 1, keyCount)
 	})
+	t.Run("Max pending and Flush", func(t *testing.T) {
+		// Concatenation is associative but not commutative, so it catches merges applied out of
+		// order.
+		concat := func(existing, new []byte) []byte {
+			return append(append([]byte{}, existing...), new...)
+		}
+		key := []byte("merge")
+		runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+			m := db.GetMergeOperator(key, concat, time.Hour)
+			defer m.Stop()
+			m.SetMaxPending(3)
+
+			// Counts the versions the next merge goes through.
+			versions := func() int {
+				var n int
+				require.NoError(t, db.View(func(txn *Txn) error {
+					iopt := DefaultIteratorOptions
+					iopt.AllVersions = true
+					it := txn.NewKeyIterator(key, iopt)
+					defer it.Close()
+					for it.Rewind(); it.Valid(); it.Next() {
+						n++
+						if it.Item().DiscardEarlierVersions() {
+							break
+						}
+					}
+					return nil
+				}))
+				return n
+			}
+
+			_, err := m.Flush()
+			require.Equal(t, ErrKeyNotFound, err)
+
+			var want string
+			for _, c := range "abcdefgh" {
+				require.NoError(t, m.Add([]byte{byte(c)}))
+				want += string(c)
+				require.True(t, versions() <= 3)
 
+				// Get includes the values which haven't been merged yet.
+				res, err := m.Get()
+				require.NoError(t, err)
+				require.Equal(t, want, string(res))
+			}
+
+			res, err := m.Flush()
+			require.NoError(t, err)
+			require.Equal(t, "abcdefgh", string(res))
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get(key)
+				require.NoError(t, err)
+				val, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, "abcdefgh", string(val))
+				return nil
+			}))
+
+			require.NoError(t, m.Add([]byte("i")))
+			res, err = m.Get()
+			require.NoError(t, err)
+			require.Equal(t, "abcdefghi", string(res))
+		})
+	})
 }
 
 func uint64ToBytes(i uint64) []byte {
@@ -42,15 +42,24 @@ type Options struct {
 	// Usually modified options.
 
 	SyncWrites          bool
+	GroupCommitInterval time.Duration
+	GroupCommitSize     int
 	TableLoadingMode    options.FileLoadingMode
 	ValueLogLoadingMode options.FileLoadingMode
 	NumVersionsToKeep   int
+	DefaultTTL          time.Duration
 	ReadOnly            bool
+	ReadOnlySnapshot    bool
 	Truncate            bool
+	RepairMode          bool
 	Logger              Logger
+	StructuredLogger    StructuredLogger
 	Compression         options.CompressionType
 	EventLogging        bool
 	InMemory            bool
+	MetricsRegisterer   MetricsRegisterer
+	KeyComparator       KeyComparator
+	Clock               Clock
 
 	// Fine tuning options.
 
@@ -59,28 +68,65 @@ type Options struct {
 	MaxLevels           int
 	ValueThreshold      int
 	NumMemtables        int
+	MaxNumMemtables     int
 	// Changing BlockSize across DB runs will not break badger. The block size is
 	// read from the block index stored at the end of the table.
 	BlockSize          int
 	BloomFalsePositive float64
 	KeepL0InMemory     bool
 	MaxCacheSize       int64
+	BlockCache         table.Cache
+	IndexCache         table.Cache
+	MaxOpenTableFiles  int
+
+	// DeterministicTables makes the tables built from the same entries byte-identical.
+	DeterministicTables bool
+	// LevelBloomFalsePositive overrides BloomFalsePositive for the tables built for each level.
+	LevelBloomFalsePositive []float64
+	// LevelCompression overrides Compression for the tables built for each level.
+	LevelCompression []options.CompressionType
 
 	NumLevelZeroTables      int
 	NumLevelZeroTablesStall int
+	RejectOnStall           bool
 
 	LevelOneSize       int64
 	ValueLogFileSize   int64
 	ValueLogMaxEntries uint32
+	ValueLogFS         ValueLogFS
+	MaxDiskSize        int64
 
 	NumCompactors        int
+	BatchGetConcurrency  int
 	CompactL0OnClose     bool
 	LogRotatesToFlush    int32
+	MaxMemtableAge       time.Duration
 	ZSTDCompressionLevel int
+	ZSTDDictionarySize   int
+	OnCompaction         func(CompactionEvent)
+	OnReplayProgress     func(ReplayProgress)
+	NumHotKeys           int
+	HotKeySampling       int
+	CompactionRateLimit  int64
 
 	// When set, checksum will be validated for each entry read from the value log file.
 	VerifyValueChecksum bool
 
+	// Values larger than ValueChunkSize are split into chunks, up to MaxChunkedValueSize.
+	ValueChunkSize      int64
+	MaxChunkedValueSize int64
+
+	// The value log is written through a buffer of ValueLogWriteBufferSize, with direct IO if
+	// ValueLogDirectIO is set.
+	ValueLogWriteBufferSize int64
+	ValueLogDirectIO        bool
+
+	// Value log GC options. The GC loop only runs if ValueLogGCInterval is set.
+	ValueLogGCInterval     time.Duration
+	ValueLogGCDiscardRatio float64
+	ValueLogGCMaxFiles     int
+	ValueLogGCCallback     func(reclaimed int64, err error)
+
 	// Encryption related options.
 	EncryptionKey                 []byte        // encryption key
 	EncryptionKeyRotationDuration time.Duration // key rotation duration
@@ -88,6 +134,10 @@ type Options struct {
 	// ChecksumVerificationMode decides when db should verify checksums for SSTable blocks.
 	ChecksumVerificationMode options.ChecksumVerificationMode
 
+	// Checksum algorithms used for new SSTable blocks and new value log entries.
+	TableChecksumAlgorithm    options.ChecksumAlgorithm
+	ValueLogChecksumAlgorithm options.ChecksumAlgorithm
+
 	// Transaction start and commit timestamps are managed by end-user.
 	// This is only useful for databases built on top of Badger (like Dgraph).
 	// Not recommended for most users.
@@ -119,6 +169,7 @@ func DefaultOptions(path string) Options {
 		MaxLevels:               7,
 		MaxTableSize:            64 << 20,
 		NumCompactors:           2, // Compactions can be expensive. Only run 2.
+		BatchGetConcurrency:     16,
 		NumLevelZeroTables:      5,
 		NumLevelZeroTablesStall: 10,
 		NumMemtables:            5,
@@ -129,6 +180,8 @@ func DefaultOptions(path string) Options {
 		CompactL0OnClose:        true,
 		KeepL0InMemory:          true,
 		VerifyValueChecksum:     false,
+		ValueLogGCDiscardRatio:  0.5,
+		ValueLogGCMaxFiles:      10,
 		Compression:             defaultCompression,
 		MaxCacheSize:            1 << 30, // 1 GB
 		// Benchmarking compression level against performance showed that level 15 gives
@@ -146,25 +199,84 @@ func DefaultOptions(path string) Options {
 		ValueLogFileSize: 1<<30 - 1,
 
 		ValueLogMaxEntries:            1000000,
+		MaxChunkedValueSize:           4 << 30,
 		ValueThreshold:                32,
 		Truncate:                      false,
 		Logger:                        defaultLogger,
 		LogRotatesToFlush:             2,
+		HotKeySampling:                16,
 		EventLogging:                  true,
 		EncryptionKey:                 []byte{},
 		EncryptionKeyRotationDuration: 10 * 24 * time.Hour, // Default 10 days.
 	}
 }
 
-func buildTableOptions(opt Options) table.Options {
-	return table.Options{
+func buildTableOptions(db *DB) table.Options {
+	opt := &db.opt
+	topt := table.Options{
 		BlockSize:            opt.BlockSize,
 		BloomFalsePositive:   opt.BloomFalsePositive,
 		LoadingMode:          opt.TableLoadingMode,
 		ChkMode:              opt.ChecksumVerificationMode,
+		ChecksumAlgorithm:    opt.TableChecksumAlgorithm,
 		Compression:          opt.Compression,
 		ZSTDCompressionLevel: opt.ZSTDCompressionLevel,
+		Cache:                db.blockCache,
+		CacheID:              db.cacheID,
+		Comparator:           db.cmp,
+	}
+	// Keep IndexCache a nil interface if the index cache isn't set.
+	if db.indexCache != nil {
+		topt.IndexCache = db.indexCache
+	}
+	topt.FileCache = db.tableFiles
+	topt.Deterministic = opt.DeterministicTables
+	return topt
+}
+
+// bloomFalsePositive returns the false positive probability of the bloom filter of the tables
+// built for the given level.
+func (opt *Options) bloomFalsePositive(level int) float64 {
+	if level < len(opt.LevelBloomFalsePositive) && opt.LevelBloomFalsePositive[level] > 0 {
+		return opt.LevelBloomFalsePositive[level]
+	}
+	return opt.BloomFalsePositive
+}
+
+// compression returns the compression algorithm of the tables built for the given level.
+func (opt *Options) compression(level int) options.CompressionType {
+	if level < len(opt.LevelCompression) {
+		return opt.LevelCompression[level]
+	}
+	return opt.Compression
+}
+
+// maxValueSize returns the maximum size of a value.
+func (opt *Options) maxValueSize() int64 {
+	if opt.ValueChunkSize > 0 && !opt.InMemory {
+		return opt.MaxChunkedValueSize
 	}
+	return opt.ValueLogFileSize
+}
+
+// valueLogWriteBufferSize returns the size the writes to the value log are buffered up to.
+func (opt *Options) valueLogWriteBufferSize() int64 {
+	switch {
+	case opt.ValueLogWriteBufferSize > 0:
+		return opt.ValueLogWriteBufferSize
+	case opt.ValueLogDirectIO:
+		return 1 << 20
+	}
+	return opt.ValueLogFileSize
+}
+
+// maxNumMemtables returns the number of memtables that can wait for a flush, while level 0 is
+// stalled.
+func (opt *Options) maxNumMemtables() int {
+	if opt.MaxNumMemtables > opt.NumMemtables {
+		return opt.MaxNumMemtables
+	}
+	return opt.NumMemtables
 }
 
 const (
@@ -220,6 +332,71 @@ func (opt Options) WithSyncWrites(val bool) Options {
 	return opt
 }
 
+// WithGroupCommitInterval returns a new Options value with GroupCommitInterval set to the given
+// value.
+//
+// GroupCommitInterval is a middle ground between SyncWrites, which syncs each write, and no sync
+// at all. The commits are written as usual, but only acknowledged, and their CommitWith callbacks
+// only run, once the value log is synced. The value log is synced at most GroupCommitInterval
+// after the first commit waiting for it, or once GroupCommitSize commits wait for it, so that a
+// single sync makes a whole group of commits durable. The commits are acknowledged in the order
+// they were written, and the new transactions only see them once they're acknowledged.
+// DB.GroupCommitStats reports the size of the groups and the sync latency. It can't be set along
+// with SyncWrites.
+//
+// The default value of GroupCommitInterval is 0, which disables the group commits.
+func (opt Options) WithGroupCommitInterval(val time.Duration) Options {
+	opt.GroupCommitInterval = val
+	return opt
+}
+
+// WithGroupCommitSize returns a new Options value with GroupCommitSize set to the given value.
+//
+// GroupCommitSize is the number of commits waiting for the sync of the value log which triggers
+// it, before GroupCommitInterval is up. It's only used along with GroupCommitInterval.
+//
+// The default value of GroupCommitSize is 0, which only syncs every GroupCommitInterval.
+func (opt Options) WithGroupCommitSize(val int) Options {
+	opt.GroupCommitSize = val
+	return opt
+}
+
+// WithDeterministicTables returns a new Options value with DeterministicTables set to the given
+// value.
+//
+// When DeterministicTables is set, the tables built from the same entries with the same options
+// are byte-identical, e.g. for backups deduplicating the files across runs. The plain tables
+// already are: their blocks end once BlockSize is reached, their bloom filters use no random
+// seed, and they store no time. Encrypted tables use a random IV for each block and the index,
+// which DeterministicTables derives from their content and the data key instead. Equal blocks then
+// encrypt the same way, which tells they're equal, but not what they hold. The data keys are still
+// rotated, see WithEncryptionKeyRotationDuration, so the tables are only identical if they're
+// encrypted with the same one. With a ZSTD dictionary, see WithZSTDDictionarySize, they're only
+// identical if built with the same version of the zstd library.
+//
+// Which entries end up in which table is not covered: it depends on the flushes and compactions.
+//
+// The default value of DeterministicTables is false.
+func (opt Options) WithDeterministicTables(b bool) Options {
+	opt.DeterministicTables = b
+	return opt
+}
+
+// WithMaxOpenTableFiles returns a new Options value with MaxOpenTableFiles set to the given
+// value.
+//
+// MaxOpenTableFiles bounds the number of table files open at once, for the DBs with more tables
+// than file descriptors available. Once more files are open, the least recently used ones are
+// closed, and opened again when they're read. Only the tables loaded with options.FileIO read
+// their file, see TableLoadingMode, the memory-mapped tables stay mapped once their file is
+// closed. The reopens are counted by the badger_table_reopens_total metric.
+//
+// The default value of MaxOpenTableFiles is 0, which keeps all the table files open.
+func (opt Options) WithMaxOpenTableFiles(val int) Options {
+	opt.MaxOpenTableFiles = val
+	return opt
+}
+
 // WithTableLoadingMode returns a new Options value with TableLoadingMode set to the given value.
 //
 // TableLoadingMode indicates which file loading mode should be used for the LSM tree data files.
@@ -252,6 +429,20 @@ func (opt Options) WithNumVersionsToKeep(val int) Options {
 	return opt
 }
 
+// WithDefaultTTL returns a new Options value with DefaultTTL set to the given value.
+//
+// When DefaultTTL is positive, the entries committed by transactions without an expiry expire
+// DefaultTTL after the commit. Entries with an expiry, set for example with Entry.WithTTL, keep
+// it. Deletions never get an expiry. The expiry is computed from Options.Clock at the time of the
+// commit, even in managed mode, where the commit timestamp is chosen by the user and is unrelated
+// to time. Entries written by the StreamWriter and by DB.Load are not affected.
+//
+// The default value of DefaultTTL is 0, which disables it.
+func (opt Options) WithDefaultTTL(val time.Duration) Options {
+	opt.DefaultTTL = val
+	return opt
+}
+
 // WithReadOnly returns a new Options value with ReadOnly set to the given value.
 //
 // When ReadOnly is true the DB will be opened on read-only mode.
@@ -265,6 +456,27 @@ func (opt Options) WithReadOnly(val bool) Options {
 	return opt
 }
 
+// WithReadOnlySnapshot returns a new Options value with ReadOnlySnapshot set to the given value.
+//
+// When ReadOnlySnapshot is true the DB is opened in read-only mode, like with ReadOnly, but
+// without taking the directory lock, so the DB can be opened while another process has it open
+// for writing, e.g. to run backups, exports or checksum verifications against a live DB.
+//
+// The DB then reads a snapshot of the data as of the last memtable flush of the writer before
+// Open: the tables listed in the manifest, and the value log entries they point to. The entries
+// of the value log written after that point are not replayed, and nothing written by the other
+// process after Open is visible. The snapshot is consistent, as the writer flushes its memtables
+// in order. If the writer keeps the tables of level 0 in memory, see KeepL0InMemory, the snapshot
+// is as of its last compaction of level 0 instead. Open might fail if the writer deletes a table
+// at the same time, after a compaction, in which case it can be retried. Once open, the tables and
+// value log files are kept open, so they can still be read after the writer deletes them.
+//
+// The default value of ReadOnlySnapshot is false.
+func (opt Options) WithReadOnlySnapshot(val bool) Options {
+	opt.ReadOnlySnapshot = val
+	return opt
+}
+
 // WithTruncate returns a new Options value with Truncate set to the given value.
 //
 // Truncate indicates whether value log files should be truncated to delete corrupt data, if any.
@@ -276,6 +488,21 @@ func (opt Options) WithTruncate(val bool) Options {
 	return opt
 }
 
+// WithRepairMode returns a new Options value with RepairMode set to the given value.
+//
+// RepairMode lets Open bring the DB up when some of its files are damaged, e.g. after an unclean
+// shutdown, instead of failing. The tables which are missing or can't be opened are removed from
+// the LSM tree, and their files are moved to the quarantine directory within Dir. The value log
+// files are truncated after their last valid entry, as with Truncate, which RepairMode implies.
+// Everything which is dropped is logged and listed in the report returned by DB.RepairReport.
+// See also Repair. This option is ignored when ReadOnly is true.
+//
+// The default value of RepairMode is false.
+func (opt Options) WithRepairMode(val bool) Options {
+	opt.RepairMode = val
+	return opt
+}
+
 // WithLogger returns a new Options value with Logger set to the given value.
 //
 // Logger provides a way to configure what logger each value of badger.DB uses.
@@ -286,6 +513,17 @@ func (opt Options) WithLogger(val Logger) Options {
 	return opt
 }
 
+// WithStructuredLogger returns a new Options value with StructuredLogger set to the given value.
+//
+// StructuredLogger is used instead of Logger for the messages of Badger, each tagged with the
+// component which logged it. See StructuredLogger for the key/value pairs.
+//
+// The default value of StructuredLogger is nil, which logs the messages to Logger.
+func (opt Options) WithStructuredLogger(val StructuredLogger) Options {
+	opt.StructuredLogger = val
+	return opt
+}
+
 // WithEventLogging returns a new Options value with EventLogging set to the given value.
 //
 // EventLogging provides a way to enable or disable trace.EventLog logging.
@@ -296,6 +534,31 @@ func (opt Options) WithEventLogging(enabled bool) Options {
 	return opt
 }
 
+// MetricsRegisterer registers the metrics of Badger with a metrics system. See
+// Options.MetricsRegisterer.
+type MetricsRegisterer interface {
+	// RegisterMetrics registers the metrics. It's called by every Open, so registering them again
+	// must not be an error.
+	RegisterMetrics() error
+}
+
+// WithMetricsRegisterer returns a new Options value with MetricsRegisterer set to the given value.
+//
+// When MetricsRegisterer is set, Open registers the metrics of Badger with it. The promexport
+// package provides one for Prometheus, promexport.Registerer. The metrics are the ones exported by
+// the y package through expvar, under the same names: badger_lsm_size_bytes,
+// badger_vlog_size_bytes, badger_disk_reads_total, badger_gets_total, badger_puts_total,
+// badger_blocked_puts_total, badger_compactions_total, etc. They are global to all the DBs of the
+// process, the sizes being labeled with the directory of each DB, so several DBs can register them
+// with the same registerer.
+//
+// The default value of MetricsRegisterer is nil, which means the metrics are only exported
+// through expvar.
+func (opt Options) WithMetricsRegisterer(reg MetricsRegisterer) Options {
+	opt.MetricsRegisterer = reg
+	return opt
+}
+
 // WithMaxTableSize returns a new Options value with MaxTableSize set to the given value.
 //
 // MaxTableSize sets the maximum size in bytes for each LSM table or file.
@@ -350,6 +613,20 @@ func (opt Options) WithNumMemtables(val int) Options {
 	return opt
 }
 
+// WithMaxNumMemtables returns a new Options value with MaxNumMemtables set to the given value.
+//
+// MaxNumMemtables lets the number of tables kept in memory grow past NumMemtables, up to
+// MaxNumMemtables, while the flushes are stalled by a full level 0. Instead of blocking during a
+// write burst, the writes then go on to the extra Memtables, at the cost of their memory. No more
+// tables are added once level 0 drains, and the count goes back to NumMemtables as they are
+// flushed.
+//
+// The default value of MaxNumMemtables is 0, which never grows past NumMemtables.
+func (opt Options) WithMaxNumMemtables(val int) Options {
+	opt.MaxNumMemtables = val
+	return opt
+}
+
 // WithBloomFalsePositive returns a new Options value with BloomFalsePositive set
 // to the given value.
 //
@@ -364,6 +641,24 @@ func (opt Options) WithBloomFalsePositive(val float64) Options {
 	return opt
 }
 
+// WithLevelBloomFalsePositive returns a new Options value with LevelBloomFalsePositive set to the
+// given value.
+//
+// LevelBloomFalsePositive sets the false positive probability of the bloom filter in the SSTables
+// built for a level, by flushing a memtable for level 0 and by compactions for the other levels.
+// The value at index i is used for level i, and BloomFalsePositive is used for the levels without a
+// value, or with a value of zero. Since most of the data sits in the bottom levels, a higher false
+// positive probability there saves memory and disk space, at the cost of more reads from tables
+// which don't have the key. Tables written by StreamWriter always use BloomFalsePositive.
+//
+// The false positive probability used is stored in every table, and reported in TableInfo.
+//
+// The default value of LevelBloomFalsePositive is nil.
+func (opt Options) WithLevelBloomFalsePositive(val []float64) Options {
+	opt.LevelBloomFalsePositive = val
+	return opt
+}
+
 // WithBlockSize returns a new Options value with BlockSize set to the given value.
 //
 // BlockSize sets the size of any block in SSTable. SSTable is divided into multiple blocks
@@ -398,6 +693,19 @@ func (opt Options) WithNumLevelZeroTablesStall(val int) Options {
 	return opt
 }
 
+// WithRejectOnStall returns a new Options value with RejectOnStall set to the given value.
+//
+// When RejectOnStall is true, the commits fail right away with ErrWriteStalled while the writes
+// are stalled, because level 0 reached NumLevelZeroTablesStall tables, instead of waiting for
+// the compactions to catch up. Callers can then shed load, and retry later. DB.WriteStalled tells
+// whether the writes are stalled.
+//
+// The default value of RejectOnStall is false, which makes the commits wait.
+func (opt Options) WithRejectOnStall(val bool) Options {
+	opt.RejectOnStall = val
+	return opt
+}
+
 // WithLevelOneSize returns a new Options value with LevelOneSize set to the given value.
 //
 // LevelOneSize sets the maximum total size for Level 1.
@@ -431,10 +739,143 @@ func (opt Options) WithValueLogMaxEntries(val uint32) Options {
 	return opt
 }
 
+// WithValueChunkSize returns a new Options value with ValueChunkSize set to the given value.
+//
+// ValueChunkSize lifts the limit of ValueLogFileSize on the size of the values. The values larger
+// than ValueChunkSize are split into chunks of ValueChunkSize bytes, written to the value log one
+// after the other, across as many files as needed. The key then points to the list of its chunks,
+// and Item.Value reassembles them, which costs a read per chunk, randomly spread over the value
+// log files. Item.ValueSize and Item.EstimatedSize, which don't read the value, only account for
+// the list of chunks. The value log GC moves all the chunks of a value at once, as soon as one of
+// its files is rewritten. ValueChunkSize must be between ValueThreshold and ValueLogFileSize.
+//
+// The default value of ValueChunkSize is 0, which doesn't chunk the values, and limits their size
+// to ValueLogFileSize.
+func (opt Options) WithValueChunkSize(val int64) Options {
+	opt.ValueChunkSize = val
+	return opt
+}
+
+// WithMaxChunkedValueSize returns a new Options value with MaxChunkedValueSize set to the given
+// value.
+//
+// MaxChunkedValueSize is the maximum size of a value, when ValueChunkSize is set. The whole value
+// is held in memory when it's written and read.
+//
+// The default value of MaxChunkedValueSize is 4GB.
+func (opt Options) WithMaxChunkedValueSize(val int64) Options {
+	opt.MaxChunkedValueSize = val
+	return opt
+}
+
+// WithValueLogWriteBufferSize returns a new Options value with ValueLogWriteBufferSize set to the
+// given value.
+//
+// ValueLogWriteBufferSize sets the size the entries written to the value log are buffered up to
+// before they're written to the file. The entries of a batch of writes are written once the batch
+// is done anyway. With ValueLogDirectIO, it is the size of the aligned buffer the entries are
+// written through, rounded up to a multiple of 4KB.
+//
+// The default value of ValueLogWriteBufferSize is 0, which buffers the entries up to
+// ValueLogFileSize, or 1MB with ValueLogDirectIO.
+func (opt Options) WithValueLogWriteBufferSize(val int64) Options {
+	opt.ValueLogWriteBufferSize = val
+	return opt
+}
+
+// WithValueLogDirectIO returns a new Options value with ValueLogDirectIO set to the given value.
+//
+// When ValueLogDirectIO is set, the value log is written with direct IO (O_DIRECT), bypassing the
+// page cache, so that the writes don't evict the pages the reads need. The writes go through an
+// aligned buffer, see ValueLogWriteBufferSize, and the last 4KB block of the file is padded with
+// zeros and written again by the next write. The padding is truncated once the file is full or the
+// DB is closed, and ignored when the DB is opened after a crash. The syncs, see SyncWrites, still
+// make the writes durable. The reads stay buffered, but the values just written are read from the
+// disk rather than the page cache.
+//
+// Direct IO is only supported on Linux, and not with ValueLogFS. Open returns an error otherwise.
+//
+// The default value of ValueLogDirectIO is false.
+func (opt Options) WithValueLogDirectIO(b bool) Options {
+	opt.ValueLogDirectIO = b
+	return opt
+}
+
+// WithKeyComparator returns a new Options value with KeyComparator set to the given value.
+//
+// KeyComparator orders the keys of the DB, in the memtables, in the tables, and for the
+// iterators, the compactions and Txn.DeleteRange. It must be a total order, consistent over time,
+// and must order every key, including the internal keys of Badger, which start with "!badger!".
+// Its name is stored when the DB is created, and Open fails with ErrComparatorMismatch if the DB is
+// opened again with a comparator of another name, or without one, as the tables written in one
+// order can't be read in another.
+//
+// The features working on prefixes, like IteratorOptions.Prefix, DropPrefix or Stream.Prefix,
+// require the keys sharing a prefix to be contiguous, and to sort after the prefix itself. This
+// holds for comparators ordering keys made of fixed length segments one segment after the other,
+// for prefixes made of whole segments. Iterating backward over a prefix also requires the prefix
+// with its last byte incremented to sort after all the keys with the prefix.
+//
+// The default order is bytes.Compare, which is inlined in the comparisons of the keys. A custom
+// comparator is called through a function value instead, which can't be inlined, so every key
+// comparison costs an indirect call on top of the comparator itself. Seeks and merges of
+// iterators, memtable inserts and compactions are dominated by key comparisons, so expect them to
+// get noticeably slower with a comparator which is not much cheaper than bytes.Compare.
+//
+// The default value of KeyComparator is nil, which stands for bytes.Compare.
+func (opt Options) WithKeyComparator(val KeyComparator) Options {
+	opt.KeyComparator = val
+	return opt
+}
+
+// WithClock returns a new Options value with Clock set to the given value.
+//
+// Clock is the time the expiry of the entries is evaluated against, everywhere: when
+// Entry.WithTTL and DefaultTTL stamp the expiry of the entries written by the transactions, when
+// the reads skip the expired entries, and when the compactions and the value log GC discard
+// them. A fake clock makes the expiry testable without sleeping, and in managed mode, a logical
+// clock can drive the expiry, for a deterministic replay. The clock is not stored in the DB, so
+// the ExpiresAt of the entries written with one clock don't mean anything to another.
+//
+// The default value of Clock is nil, which stands for the system clock, in Unix seconds.
+func (opt Options) WithClock(val Clock) Options {
+	opt.Clock = val
+	return opt
+}
+
+// WithMaxDiskSize returns a new Options value with MaxDiskSize set to the given value.
+//
+// MaxDiskSize is a hard cap, in bytes, on the total size of the LSM tree tables and of the value
+// log files. Once the DB grows over it, the commits fail with ErrDiskFull, while the reads, and
+// the commits which only delete keys, with Delete or DeleteRange, keep working so that space can
+// be reclaimed. The other commits are accepted again once compactions, value log GC or DropPrefix
+// have brought the size under 90% of MaxDiskSize. The size is tracked in memory, so the DB can go
+// slightly over the cap with the writes which were already in flight. Zero disables the cap.
+//
+// The default value of MaxDiskSize is 0.
+func (opt Options) WithMaxDiskSize(val int64) Options {
+	opt.MaxDiskSize = val
+	return opt
+}
+
+// WithBatchGetConcurrency returns a new Options value with BatchGetConcurrency set to the given
+// value.
+//
+// BatchGetConcurrency is the maximum number of values which DB.BatchGet reads from the value log
+// at the same time. Values smaller than ValueThreshold are stored in the LSM tree and don't need
+// these reads.
+//
+// The default value of BatchGetConcurrency is 16.
+func (opt Options) WithBatchGetConcurrency(val int) Options {
+	opt.BatchGetConcurrency = val
+	return opt
+}
+
 // WithNumCompactors returns a new Options value with NumCompactors set to the given value.
 //
 // NumCompactors sets the number of compaction workers to run concurrently.
 // Setting this to zero stops compactions, which could eventually cause writes to block forever.
+// It can be changed while the DB is running with DB.SetNumCompactors.
 //
 // The default value of NumCompactors is 2.
 func (opt Options) WithNumCompactors(val int) Options {
@@ -442,6 +883,26 @@ func (opt Options) WithNumCompactors(val int) Options {
 	return opt
 }
 
+// WithCompactionRateLimit returns a new Options value with CompactionRateLimit set to the given
+// value.
+//
+// CompactionRateLimit limits the IO of the compactions and of the value log GC, in bytes per
+// second, so that they don't starve the reads and writes of the DB on a shared disk. The limit is
+// shared by all the compactors and the GC, and covers the entries read from the tables or the value
+// log, and the tables written. The flushes of the memtables and the writes of the entries moved by
+// the GC aren't limited, as the writes of the DB wait for them. Note that a low limit lets level 0
+// fill up faster than it's compacted, stalling the writes, see WithNumLevelZeroTablesStall.
+//
+// The limit can be changed while the DB is open with DB.SetCompactionRateLimit, and is lifted once
+// the DB is being closed, so that it doesn't hold up Close. The
+// badger_compaction_rate_limit_utilization_percent metric reports how much of it is used.
+//
+// The default value of CompactionRateLimit is 0, which doesn't limit the IO.
+func (opt Options) WithCompactionRateLimit(val int64) Options {
+	opt.CompactionRateLimit = val
+	return opt
+}
+
 // WithCompactL0OnClose returns a new Options value with CompactL0OnClose set to the given value.
 //
 // CompactL0OnClose determines whether Level 0 should be compacted before closing the DB.
@@ -467,6 +928,45 @@ func (opt Options) WithLogRotatesToFlush(val int32) Options {
 	return opt
 }
 
+// WithMaxMemtableAge returns a new Options value with MaxMemtableAge set to the given value.
+//
+// MaxMemtableAge is the time after which the active Memtable is flushed to disk, even if it's
+// far from full. Like LogRotatesToFlush, it bounds the value log replayed on a restart, for the
+// write loads too light to fill the Memtables, and keeps the level 0 tables of bursty loads
+// closer in size. The age counts from the creation of the Memtable, and an empty Memtable is never
+// flushed. The age is checked every quarter of MaxMemtableAge, and at most every millisecond.
+//
+// The default value of MaxMemtableAge is 0, which only flushes the Memtables once they are full.
+func (opt Options) WithMaxMemtableAge(val time.Duration) Options {
+	opt.MaxMemtableAge = val
+	return opt
+}
+
+// WithNumHotKeys returns a new Options value with NumHotKeys set to the given value.
+//
+// NumHotKeys is the number of the most accessed keys tracked by the DB, and reported by
+// DB.HotKeys. The reads of a transaction, with Txn.Get, GetVersion, GetMulti or Exists, and its
+// writes, or the ones of a WriteBatch, are sampled, see HotKeySampling, and counted in a fixed size
+// count-min sketch, so tracking costs a few atomic operations per key, and 64KB plus the tracked
+// keys of memory.
+//
+// The default value of NumHotKeys is 0, which doesn't track anything.
+func (opt Options) WithNumHotKeys(val int) Options {
+	opt.NumHotKeys = val
+	return opt
+}
+
+// WithHotKeySampling returns a new Options value with HotKeySampling set to the given value.
+//
+// HotKeySampling is the number of key accesses for which one is sampled, when NumHotKeys is set.
+// Sampling more accesses makes DB.HotKeys more accurate, but tracking more expensive.
+//
+// The default value of HotKeySampling is 16.
+func (opt Options) WithHotKeySampling(val int) Options {
+	opt.HotKeySampling = val
+	return opt
+}
+
 // WithEncryptionKey return a new Options value with EncryptionKey set to the given value.
 //
 // EncryptionKey is used to encrypt the data with AES. Type of AES is used based on the key
@@ -504,6 +1004,11 @@ func (opt Options) WithKeepL0InMemory(val bool) Options {
 //
 // When compression is enabled, every block will be compressed using the specified algorithm.
 // This option doesn't affect existing tables. Only the newly created tables will be compressed.
+// The compression of every table is recorded in the MANIFEST, so the tables are always read with
+// the algorithm they were written with, and the option can be changed between runs of the DB.
+//
+// Snappy compresses less than zstd, but is much cheaper to decompress, which makes it a better fit
+// for CPU constrained deployments. See BenchmarkCompression in the table package.
 //
 // The default compression algorithm used is zstd when built with Cgo. Without Cgo, the default is
 // snappy. Compression is enabled by default.
@@ -512,6 +1017,24 @@ func (opt Options) WithCompression(cType options.CompressionType) Options {
 	return opt
 }
 
+// WithLevelCompression returns a new Options value with LevelCompression set to the given value.
+//
+// LevelCompression sets the compression algorithm of the SSTables built for a level, by flushing a
+// memtable for level 0 and by compactions for the other levels. The value at index i is used for
+// level i, and Compression is used for the levels beyond the end of the slice, so it can hold
+// fewer than MaxLevels entries, but not more. For example, the upper levels, which are rewritten
+// often, can be left uncompressed, while the bottom levels, which hold most of the data, use zstd.
+// Tables written by StreamWriter always use Compression.
+//
+// Like Compression, this option doesn't affect existing tables, which are always read with the
+// algorithm they were written with.
+//
+// The default value of LevelCompression is nil.
+func (opt Options) WithLevelCompression(val []options.CompressionType) Options {
+	opt.LevelCompression = val
+	return opt
+}
+
 // WithVerifyValueChecksum returns a new Options value with VerifyValueChecksum set to
 // the given value.
 //
@@ -530,12 +1053,55 @@ func (opt Options) WithVerifyValueChecksum(val bool) Options {
 //
 // ChecksumVerificationMode indicates when the db should verify checksums for SSTable blocks.
 //
-// The default value of VerifyValueChecksum is options.NoVerification.
+// With options.OnBlockRead and options.OnTableAndBlockRead, a block is verified when it's read
+// from the table, and then added to the block cache, see WithMaxCacheSize, which serves the next
+// reads of the block without verifying it again. With options.OnEveryBlockRead, the blocks aren't
+// looked up in nor added to the block cache: each read of a block, by a Get or by an iterator
+// moving to the block, reads it from the table again and verifies its checksum, and the tables are
+// verified when opened too. An iterator verifies a block once when it moves to it, not for each of
+// its entries. The block is read from the table file with options.FileIO, possibly served by the
+// page cache of the OS, from the mapped file with options.MemoryMap, and from the copy of the table
+// in memory with options.LoadToRAM and for the tables kept in memory, see WithKeepL0InMemory.
+//
+// The y.NumBlockChecksumVerifications and y.NumBlockChecksumMismatches metrics count the
+// verifications of the blocks, in all the modes and by DB.VerifyChecksum and
+// DB.VerifyChecksums, and the ones which failed.
+//
+// The default value of ChecksumVerificationMode is options.NoVerification.
 func (opt Options) WithChecksumVerificationMode(cvMode options.ChecksumVerificationMode) Options {
 	opt.ChecksumVerificationMode = cvMode
 	return opt
 }
 
+// WithTableChecksumAlgorithm returns a new Options value with TableChecksumAlgorithm set to the
+// given value.
+//
+// TableChecksumAlgorithm is the algorithm used to checksum the blocks and the index of new
+// SSTables. The algorithm is stored along with each checksum, so tables written with another
+// algorithm can still be read and verified. Changing it only affects the tables built from then
+// on, by flushes and compactions, so a DB can hold tables written with both algorithms.
+//
+// The default value of TableChecksumAlgorithm is options.CRC32C.
+func (opt Options) WithTableChecksumAlgorithm(algo options.ChecksumAlgorithm) Options {
+	opt.TableChecksumAlgorithm = algo
+	return opt
+}
+
+// WithValueLogChecksumAlgorithm returns a new Options value with ValueLogChecksumAlgorithm set to
+// the given value.
+//
+// ValueLogChecksumAlgorithm is the algorithm used to checksum new entries of the value log. The
+// header of each entry tells which algorithm was used for it, so value log files written with
+// another algorithm can still be replayed and verified. Existing entries keep their checksum until
+// the value log GC rewrites them with the current algorithm. Note that versions of Badger which
+// don't support this option can't read entries checksummed with options.XXHash64.
+//
+// The default value of ValueLogChecksumAlgorithm is options.CRC32C.
+func (opt Options) WithValueLogChecksumAlgorithm(algo options.ChecksumAlgorithm) Options {
+	opt.ValueLogChecksumAlgorithm = algo
+	return opt
+}
+
 // WithMaxCacheSize returns a new Options value with MaxCacheSize set to the given value.
 //
 // This value specifies how much data cache should hold in memory. A small size of cache means lower
@@ -545,6 +1111,33 @@ func (opt Options) WithMaxCacheSize(size int64) Options {
 	return opt
 }
 
+// WithBlockCache returns a new Options value with BlockCache set to the given value.
+//
+// BlockCache holds the decoded blocks of the tables. The same cache can be set for several DBs to
+// share it between them, the keys of the tables of each DB are distinct. A cache set with this
+// option isn't closed by DB.Close, it must be closed by the user once all the DBs using it are
+// closed. NewCache returns the ristretto cache Badger uses by default.
+//
+// The default value of BlockCache is nil, which means each DB creates its own cache of
+// MaxCacheSize bytes.
+func (opt Options) WithBlockCache(cache table.Cache) Options {
+	opt.BlockCache = cache
+	return opt
+}
+
+// WithIndexCache returns a new Options value with IndexCache set to the given value.
+//
+// IndexCache holds the block offsets and the bloom filters of the tables. When it is set, they
+// are no longer kept in memory for as long as the tables are open, and are read again from the
+// tables on a cache miss, which bounds the memory used by large DBs. It can be the same cache as
+// BlockCache, and can be shared between DBs in the same way.
+//
+// The default value of IndexCache is nil, which means the indices are kept in memory.
+func (opt Options) WithIndexCache(cache table.Cache) Options {
+	opt.IndexCache = cache
+	return opt
+}
+
 // WithInMemory returns a new Options value with Inmemory mode set to the given value.
 //
 // When badger is running in InMemory mode, everything is stored in memory. No value/sst files are
@@ -565,3 +1158,121 @@ func (opt Options) WithZSTDCompressionLevel(cLevel int) Options {
 	opt.ZSTDCompressionLevel = cLevel
 	return opt
 }
+
+// WithZSTDDictionarySize returns a new Options value with ZSTDDictionarySize set to the given
+// value.
+//
+// When ZSTDDictionarySize is greater than zero, compactions train a ZSTD dictionary of at most
+// this many bytes from a sample of the values of each table they build with ZSTD compression, and
+// compress all the blocks of the table against it. The dictionary is stored in the index of the
+// table, and is used whenever its blocks are read. This improves the compression ratio of small,
+// similar values, which small blocks don't compress well on their own. A few kilobytes to tens
+// of kilobytes, e.g. 16KB, is a good size. Tables flushed from memtables and tables written by
+// StreamWriter don't use dictionaries.
+//
+// The blocks of a table are only compressed once the table is complete, so MaxTableSize applies
+// to the uncompressed blocks, and the tables are smaller than without dictionaries.
+//
+// The default value of ZSTDDictionarySize is 0, which disables dictionaries.
+func (opt Options) WithZSTDDictionarySize(val int) Options {
+	opt.ZSTDDictionarySize = val
+	return opt
+}
+
+// WithValueLogGCInterval returns a new Options value with ValueLogGCInterval set to the given
+// value.
+//
+// When ValueLogGCInterval is set, Badger runs the value log GC in the background instead of
+// relying on the user to call DB.RunValueLogGC. Every interval, it rewrites value log files for as
+// long as there are files worth rewriting, up to ValueLogGCMaxFiles files. A cycle is skipped if
+// the compactions are already busy, as the GC would compete with them for IO. The GC doesn't run
+// for the InMemory DBs, which don't have a value log.
+//
+// The default value of ValueLogGCInterval is 0, which means the GC doesn't run in the background.
+func (opt Options) WithValueLogGCInterval(val time.Duration) Options {
+	opt.ValueLogGCInterval = val
+	return opt
+}
+
+// WithValueLogGCDiscardRatio returns a new Options value with ValueLogGCDiscardRatio set to the
+// given value.
+//
+// ValueLogGCDiscardRatio is the discardRatio the background value log GC passes to
+// DB.RunValueLogGC. See DB.RunValueLogGC for more details. It must be between 0 and 1, exclusive,
+// and is only checked when ValueLogGCInterval is set.
+//
+// The default value of ValueLogGCDiscardRatio is 0.5.
+func (opt Options) WithValueLogGCDiscardRatio(val float64) Options {
+	opt.ValueLogGCDiscardRatio = val
+	return opt
+}
+
+// WithValueLogGCMaxFiles returns a new Options value with ValueLogGCMaxFiles set to the given
+// value.
+//
+// ValueLogGCMaxFiles sets the maximum number of value log files the background value log GC
+// rewrites per cycle.
+//
+// The default value of ValueLogGCMaxFiles is 10.
+func (opt Options) WithValueLogGCMaxFiles(val int) Options {
+	opt.ValueLogGCMaxFiles = val
+	return opt
+}
+
+// WithValueLogGCCallback returns a new Options value with ValueLogGCCallback set to the given
+// value.
+//
+// ValueLogGCCallback is called after every attempt of the background value log GC to rewrite a
+// file, with an estimate of the bytes it reclaimed and its error. ErrNoRewrite means that no file
+// was worth rewriting, and ends the cycle.
+//
+// The default value of ValueLogGCCallback is nil.
+func (opt Options) WithValueLogGCCallback(f func(reclaimed int64, err error)) Options {
+	opt.ValueLogGCCallback = f
+	return opt
+}
+
+// WithValueLogFS returns a new Options value with ValueLogFS set to the given value.
+//
+// ValueLogFS is used for all the operations on the value log files, which makes it possible to
+// store some of them on other storage than Options.ValueDir, e.g. to move the files that are not
+// written anymore to a slower and cheaper tier while the LSM tree stays on a fast disk. The value
+// of a key is read from its value log file, through ValueLogFS, each time it is read unless the
+// value is small enough to be stored in the LSM tree (see ValueThreshold), so reads, iterations
+// fetching values and the value log GC are as slow as the files they touch. Files which are not
+// an *os.File are read with ReadAt instead of being memory-mapped. Writes only ever go to the
+// latest file, and each write to it, and each sync if SyncWrites is set, waits for ValueLogFS.
+//
+// The default value of ValueLogFS is nil, which stores the files in Options.ValueDir on the local
+// file system.
+func (opt Options) WithValueLogFS(fs ValueLogFS) Options {
+	opt.ValueLogFS = fs
+	return opt
+}
+
+// WithOnCompaction returns a new Options value with OnCompaction set to the given value.
+//
+// OnCompaction is called after each compaction completes, with the levels and the tables involved
+// and how long it took. It is called from a separate goroutine, one event at a time, so that it
+// doesn't slow the compactions down. Up to 100 events are queued while it runs, further events are
+// dropped with a warning. The events still queued are delivered by DB.Close.
+//
+// The default value of OnCompaction is nil.
+func (opt Options) WithOnCompaction(f func(CompactionEvent)) Options {
+	opt.OnCompaction = f
+	return opt
+}
+
+// WithOnReplayProgress returns a new Options value with OnReplayProgress set to the given value.
+//
+// OnReplayProgress is called by Open while it replays the value log written after the memtables
+// last flushed, which can take long after a crash. It is called once before the replay, then
+// every second while a file is replayed and after each file, and a last time with Done set once
+// the replay is done, including when there's nothing to replay. It is called from the goroutine
+// of Open, which it blocks, so it must return quickly. It isn't called for in-memory DBs.
+//
+// The default value of OnReplayProgress is nil.
+func (opt Options) WithOnReplayProgress(f func(ReplayProgress)) Options {
+	opt.OnReplayProgress = f
+	return opt
+}
@@ -42,6 +42,19 @@ const (
 	// OnTableAndBlockRead indicates checksum should be verified
 	// on SSTable opening and on every block read.
 	OnTableAndBlockRead
+	// OnEveryBlockRead indicates checksum should be verified on SSTable opening and on every block
+	// read, with the blocks read from the SSTable every time, never from the block cache.
+	OnEveryBlockRead
+)
+
+// ChecksumAlgorithm specifies the algorithm used for checksums.
+type ChecksumAlgorithm int
+
+const (
+	// CRC32C uses CRC-32 with the Castagnoli polynomial.
+	CRC32C ChecksumAlgorithm = 0
+	// XXHash64 uses the 64 bit xxHash.
+	XXHash64 ChecksumAlgorithm = 1
 )
 
 // CompressionType specifies how a block should be compressed.
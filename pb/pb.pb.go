@@ -4,6 +4,7 @@
 package pb
 
 import (
+	encoding_binary "encoding/binary"
 	fmt "fmt"
 	proto "github.com/golang/protobuf/proto"
 	io "io"
@@ -448,6 +449,9 @@ type TableIndex struct {
 	Offsets              []*BlockOffset `protobuf:"bytes,1,rep,name=offsets,proto3" json:"offsets,omitempty"`
 	BloomFilter          []byte         `protobuf:"bytes,2,opt,name=bloom_filter,json=bloomFilter,proto3" json:"bloom_filter,omitempty"`
 	EstimatedSize        uint64         `protobuf:"varint,3,opt,name=estimated_size,json=estimatedSize,proto3" json:"estimated_size,omitempty"`
+	KeyCount             uint64         `protobuf:"varint,4,opt,name=key_count,json=keyCount,proto3" json:"key_count,omitempty"`
+	BloomFalsePositive   float64        `protobuf:"fixed64,5,opt,name=bloom_false_positive,json=bloomFalsePositive,proto3" json:"bloom_false_positive,omitempty"`
+	ZstdDictionary       []byte         `protobuf:"bytes,6,opt,name=zstd_dictionary,json=zstdDictionary,proto3" json:"zstd_dictionary,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
 	XXX_unrecognized     []byte         `json:"-"`
 	XXX_sizecache        int32          `json:"-"`
@@ -507,6 +511,27 @@ func (m *TableIndex) GetEstimatedSize() uint64 {
 	return 0
 }
 
+func (m *TableIndex) GetKeyCount() uint64 {
+	if m != nil {
+		return m.KeyCount
+	}
+	return 0
+}
+
+func (m *TableIndex) GetBloomFalsePositive() float64 {
+	if m != nil {
+		return m.BloomFalsePositive
+	}
+	return 0
+}
+
+func (m *TableIndex) GetZstdDictionary() []byte {
+	if m != nil {
+		return m.ZstdDictionary
+	}
+	return nil
+}
+
 type Checksum struct {
 	Algo                 Checksum_Algorithm `protobuf:"varint,1,opt,name=algo,proto3,enum=pb.Checksum_Algorithm" json:"algo,omitempty"`
 	Sum                  uint64             `protobuf:"varint,2,opt,name=sum,proto3" json:"sum,omitempty"`
@@ -650,48 +675,52 @@ func init() {
 func init() { proto.RegisterFile("pb.proto", fileDescriptor_f80abaa17e25ccc8) }
 
 var fileDescriptor_f80abaa17e25ccc8 = []byte{
-	// 653 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x54, 0xcf, 0x6e, 0xda, 0x4e,
-	0x10, 0x66, 0x8d, 0x63, 0x60, 0x08, 0x84, 0xdf, 0xea, 0xd7, 0xc8, 0x55, 0x5b, 0x4a, 0x5d, 0x45,
-	0xa2, 0x51, 0xc4, 0x21, 0xa9, 0x7a, 0xe9, 0x89, 0x10, 0xaa, 0x22, 0x12, 0x21, 0x6d, 0xa2, 0x28,
-	0x37, 0xb4, 0xe0, 0x21, 0x58, 0xf8, 0x9f, 0xbc, 0x8b, 0x15, 0x72, 0xeb, 0x5b, 0xf4, 0x91, 0x7a,
-	0xec, 0xa1, 0x0f, 0x50, 0xa5, 0x0f, 0xd2, 0x6a, 0xd7, 0x06, 0x81, 0xda, 0xdb, 0xcc, 0xf7, 0xcd,
-	0xee, 0xe7, 0xf9, 0x66, 0xd6, 0x50, 0x8e, 0x27, 0x9d, 0x38, 0x89, 0x64, 0x44, 0x8d, 0x78, 0xe2,
-	0xfc, 0x20, 0x60, 0x0c, 0x6f, 0x69, 0x03, 0x8a, 0x0b, 0x5c, 0xd9, 0xa4, 0x45, 0xda, 0xfb, 0x4c,
-	0x85, 0xf4, 0x7f, 0xd8, 0x4b, 0xb9, 0xbf, 0x44, 0xdb, 0xd0, 0x58, 0x96, 0xd0, 0x17, 0x50, 0x59,
-	0x0a, 0x4c, 0xc6, 0x01, 0x4a, 0x6e, 0x17, 0x35, 0x53, 0x56, 0xc0, 0x15, 0x4a, 0x4e, 0x6d, 0x28,
-	0xa5, 0x98, 0x08, 0x2f, 0x0a, 0x6d, 0xb3, 0x45, 0xda, 0x26, 0x5b, 0xa7, 0xf4, 0x15, 0x00, 0x3e,
-	0xc4, 0x5e, 0x82, 0x62, 0xcc, 0xa5, 0xbd, 0xa7, 0xc9, 0x4a, 0x8e, 0x74, 0x25, 0xa5, 0x60, 0xea,
-	0x0b, 0x2d, 0x7d, 0xa1, 0x8e, 0x95, 0x92, 0x90, 0x09, 0xf2, 0x60, 0xec, 0xb9, 0x36, 0xb4, 0x48,
-	0xbb, 0xc6, 0xca, 0x19, 0x30, 0x70, 0xe9, 0x6b, 0xa8, 0xe6, 0xa4, 0x1b, 0x85, 0x68, 0x57, 0x5b,
-	0xa4, 0x5d, 0x66, 0x90, 0x41, 0x17, 0x51, 0x88, 0x4e, 0x0b, 0xac, 0xe1, 0xed, 0xa5, 0x27, 0x24,
-	0x3d, 0x04, 0x63, 0x91, 0xda, 0xa4, 0x55, 0x6c, 0x57, 0x4f, 0xad, 0x4e, 0x3c, 0xe9, 0x0c, 0x6f,
-	0x99, 0xb1, 0x48, 0x9d, 0x2e, 0xfc, 0x77, 0xc5, 0x43, 0x6f, 0x86, 0x42, 0xf6, 0xe6, 0x3c, 0xbc,
-	0xc7, 0x6b, 0x94, 0xf4, 0x04, 0x4a, 0x53, 0x9d, 0x88, 0xfc, 0x04, 0x55, 0x27, 0x76, 0xeb, 0xd8,
-	0xba, 0xc4, 0xf9, 0x4d, 0xa0, 0xbe, 0xcb, 0xd1, 0x3a, 0x18, 0x03, 0x57, 0xdb, 0x68, 0x32, 0x63,
-	0xe0, 0xd2, 0x13, 0x30, 0x46, 0xb1, 0xb6, 0xb0, 0x7e, 0xfa, 0xf2, 0xef, 0xbb, 0x3a, 0xa3, 0x18,
-	0x13, 0x2e, 0xbd, 0x28, 0x64, 0xc6, 0x28, 0x56, 0x9e, 0x5f, 0x62, 0x8a, 0xbe, 0x76, 0xb6, 0xc6,
-	0xb2, 0x84, 0x3e, 0x03, 0x6b, 0x81, 0x2b, 0x65, 0x43, 0xe6, 0xea, 0xde, 0x02, 0x57, 0x03, 0x97,
-	0x7e, 0x84, 0x03, 0x0c, 0xa7, 0xc9, 0x2a, 0x56, 0xc7, 0xc7, 0xdc, 0xbf, 0x8f, 0xb4, 0xb1, 0xf5,
-	0xec, 0x9b, 0xfb, 0x1b, 0xaa, 0xeb, 0xdf, 0x47, 0xac, 0x8e, 0x3b, 0x39, 0x6d, 0x41, 0x75, 0x1a,
-	0x05, 0x71, 0x82, 0x42, 0x8f, 0xcb, 0xd2, 0x7a, 0xdb, 0x90, 0xf3, 0x16, 0x2a, 0x9b, 0x8f, 0xa3,
-	0x00, 0x56, 0x8f, 0xf5, 0xbb, 0x37, 0xfd, 0x46, 0x41, 0xc5, 0x17, 0xfd, 0xcb, 0xfe, 0x4d, 0xbf,
-	0x41, 0x9c, 0x01, 0x54, 0xcf, 0xfd, 0x68, 0xba, 0x18, 0xcd, 0x66, 0x02, 0xe5, 0x3f, 0xb6, 0xe8,
-	0x10, 0xac, 0x48, 0x73, 0xda, 0x83, 0x1a, 0xcb, 0x33, 0x55, 0xe9, 0x63, 0x98, 0xf7, 0xa9, 0x42,
-	0xe7, 0x0b, 0x01, 0xb8, 0xe1, 0x13, 0x1f, 0x07, 0xa1, 0x8b, 0x0f, 0xf4, 0x1d, 0x94, 0xb2, 0xd2,
-	0xf5, 0x24, 0x0e, 0x54, 0x57, 0x5b, 0x62, 0x6c, 0xcd, 0xd3, 0x37, 0xb0, 0x3f, 0xf1, 0xa3, 0x28,
-	0x18, 0xcf, 0x3c, 0x5f, 0x62, 0x92, 0x2f, 0x6c, 0x55, 0x63, 0x9f, 0x34, 0x44, 0x8f, 0xa0, 0x8e,
-	0x42, 0x7a, 0x01, 0x97, 0xe8, 0x8e, 0x85, 0xf7, 0x88, 0x5a, 0xd9, 0x64, 0xb5, 0x0d, 0x7a, 0xed,
-	0x3d, 0xa2, 0x13, 0x41, 0xb9, 0x37, 0xc7, 0xe9, 0x42, 0x2c, 0x03, 0x7a, 0x0c, 0xa6, 0xf6, 0x94,
-	0x68, 0x4f, 0x0f, 0x95, 0xfa, 0x9a, 0xeb, 0x28, 0x0b, 0x13, 0x4f, 0xce, 0x03, 0xa6, 0x6b, 0x54,
-	0x37, 0x62, 0x19, 0x68, 0x61, 0x93, 0xa9, 0xd0, 0x39, 0x82, 0xca, 0xa6, 0x28, 0x73, 0xaf, 0x77,
-	0x76, 0xda, 0x6b, 0x14, 0xe8, 0x3e, 0x94, 0xef, 0xee, 0x3e, 0x73, 0x31, 0xff, 0xf0, 0xbe, 0x41,
-	0x9c, 0x29, 0x94, 0x2e, 0xb8, 0xe4, 0x43, 0x5c, 0x6d, 0x4d, 0x99, 0x6c, 0x4f, 0x99, 0x82, 0xe9,
-	0x72, 0xc9, 0xf3, 0xa6, 0x74, 0xac, 0x96, 0xcc, 0x4b, 0xf3, 0xd7, 0x67, 0x78, 0xa9, 0x7a, 0x5d,
-	0xd3, 0x04, 0x75, 0x6f, 0x5c, 0xea, 0x25, 0x29, 0xb2, 0x4a, 0x8e, 0x74, 0xe5, 0xf1, 0x73, 0xa8,
-	0xef, 0x6e, 0x03, 0x2d, 0x41, 0x91, 0xa3, 0x68, 0x14, 0xce, 0x1b, 0xdf, 0x9e, 0x9a, 0xe4, 0xfb,
-	0x53, 0x93, 0xfc, 0x7c, 0x6a, 0x92, 0xaf, 0xbf, 0x9a, 0x85, 0x89, 0xa5, 0x7f, 0x0d, 0x67, 0x7f,
-	0x02, 0x00, 0x00, 0xff, 0xff, 0x8f, 0xf6, 0xa8, 0xfe, 0x26, 0x04, 0x00, 0x00,
+	// 713 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x54, 0xdd, 0x6e, 0xe3, 0x44,
+	0x14, 0xee, 0x38, 0xae, 0x93, 0x9c, 0xb4, 0x6e, 0x18, 0x2d, 0x95, 0x11, 0x50, 0x82, 0xd1, 0x8a,
+	0xb0, 0x5a, 0x45, 0xa8, 0x8b, 0xb8, 0xe1, 0x2a, 0x9b, 0x06, 0x11, 0xb5, 0xab, 0xa2, 0xd9, 0xaa,
+	0xda, 0x3b, 0x6b, 0x62, 0x9f, 0xb6, 0x23, 0xff, 0x8c, 0xe5, 0x99, 0x58, 0x4d, 0x9f, 0x84, 0x47,
+	0xe2, 0x92, 0x0b, 0x1e, 0x00, 0x95, 0xa7, 0xe0, 0x0a, 0x34, 0x63, 0x27, 0x4a, 0xc4, 0xde, 0x9d,
+	0xf3, 0x7d, 0x67, 0xe6, 0xf8, 0x7c, 0xe7, 0x1b, 0x43, 0xaf, 0x5c, 0x4e, 0xca, 0x4a, 0x6a, 0x49,
+	0x9d, 0x72, 0x19, 0xfe, 0x49, 0xc0, 0xb9, 0xbc, 0xa5, 0x43, 0xe8, 0xa4, 0xb8, 0x0e, 0xc8, 0x88,
+	0x8c, 0x8f, 0x98, 0x09, 0xe9, 0x0b, 0x38, 0xac, 0x79, 0xb6, 0xc2, 0xc0, 0xb1, 0x58, 0x93, 0xd0,
+	0xcf, 0xa1, 0xbf, 0x52, 0x58, 0x45, 0x39, 0x6a, 0x1e, 0x74, 0x2c, 0xd3, 0x33, 0xc0, 0x3b, 0xd4,
+	0x9c, 0x06, 0xd0, 0xad, 0xb1, 0x52, 0x42, 0x16, 0x81, 0x3b, 0x22, 0x63, 0x97, 0x6d, 0x52, 0xfa,
+	0x25, 0x00, 0x3e, 0x96, 0xa2, 0x42, 0x15, 0x71, 0x1d, 0x1c, 0x5a, 0xb2, 0xdf, 0x22, 0x53, 0x4d,
+	0x29, 0xb8, 0xf6, 0x42, 0xcf, 0x5e, 0x68, 0x63, 0xd3, 0x49, 0xe9, 0x0a, 0x79, 0x1e, 0x89, 0x24,
+	0x80, 0x11, 0x19, 0x1f, 0xb3, 0x5e, 0x03, 0x2c, 0x12, 0xfa, 0x15, 0x0c, 0x5a, 0x32, 0x91, 0x05,
+	0x06, 0x83, 0x11, 0x19, 0xf7, 0x18, 0x34, 0xd0, 0x85, 0x2c, 0x30, 0x1c, 0x81, 0x77, 0x79, 0x7b,
+	0x25, 0x94, 0xa6, 0xa7, 0xe0, 0xa4, 0x75, 0x40, 0x46, 0x9d, 0xf1, 0xe0, 0xdc, 0x9b, 0x94, 0xcb,
+	0xc9, 0xe5, 0x2d, 0x73, 0xd2, 0x3a, 0x9c, 0xc2, 0x27, 0xef, 0x78, 0x21, 0xee, 0x50, 0xe9, 0xd9,
+	0x03, 0x2f, 0xee, 0xf1, 0x3d, 0x6a, 0xfa, 0x1a, 0xba, 0xb1, 0x4d, 0x54, 0x7b, 0x82, 0x9a, 0x13,
+	0xfb, 0x75, 0x6c, 0x53, 0x12, 0xfe, 0x4b, 0xc0, 0xdf, 0xe7, 0xa8, 0x0f, 0xce, 0x22, 0xb1, 0x32,
+	0xba, 0xcc, 0x59, 0x24, 0xf4, 0x35, 0x38, 0xd7, 0xa5, 0x95, 0xd0, 0x3f, 0xff, 0xe2, 0xff, 0x77,
+	0x4d, 0xae, 0x4b, 0xac, 0xb8, 0x16, 0xb2, 0x60, 0xce, 0x75, 0x69, 0x34, 0xbf, 0xc2, 0x1a, 0x33,
+	0xab, 0xec, 0x31, 0x6b, 0x12, 0xfa, 0x29, 0x78, 0x29, 0xae, 0x8d, 0x0c, 0x8d, 0xaa, 0x87, 0x29,
+	0xae, 0x17, 0x09, 0xfd, 0x09, 0x4e, 0xb0, 0x88, 0xab, 0x75, 0x69, 0x8e, 0x47, 0x3c, 0xbb, 0x97,
+	0x56, 0x58, 0xbf, 0xf9, 0xe6, 0xf9, 0x96, 0x9a, 0x66, 0xf7, 0x92, 0xf9, 0xb8, 0x97, 0xd3, 0x11,
+	0x0c, 0x62, 0x99, 0x97, 0x15, 0x2a, 0xbb, 0x2e, 0xcf, 0xf6, 0xdb, 0x85, 0xc2, 0x6f, 0xa0, 0xbf,
+	0xfd, 0x38, 0x0a, 0xe0, 0xcd, 0xd8, 0x7c, 0x7a, 0x33, 0x1f, 0x1e, 0x98, 0xf8, 0x62, 0x7e, 0x35,
+	0xbf, 0x99, 0x0f, 0x49, 0xb8, 0x80, 0xc1, 0xdb, 0x4c, 0xc6, 0xe9, 0xf5, 0xdd, 0x9d, 0x42, 0xfd,
+	0x11, 0x17, 0x9d, 0x82, 0x27, 0x2d, 0x67, 0x35, 0x38, 0x66, 0x9e, 0xdc, 0x56, 0x66, 0x58, 0xb4,
+	0x73, 0x9a, 0x30, 0xfc, 0x87, 0x00, 0xdc, 0xf0, 0x65, 0x86, 0x8b, 0x22, 0xc1, 0x47, 0xfa, 0x1d,
+	0x74, 0x9b, 0xd2, 0xcd, 0x26, 0x4e, 0xcc, 0x54, 0x3b, 0xcd, 0xd8, 0x86, 0xa7, 0x5f, 0xc3, 0xd1,
+	0x32, 0x93, 0x32, 0x8f, 0xee, 0x44, 0xa6, 0xb1, 0x6a, 0x0d, 0x3b, 0xb0, 0xd8, 0xcf, 0x16, 0xa2,
+	0x2f, 0xc1, 0x47, 0xa5, 0x45, 0xce, 0x35, 0x26, 0x91, 0x12, 0x4f, 0x68, 0x3b, 0xbb, 0xec, 0x78,
+	0x8b, 0xbe, 0x17, 0x4f, 0xd6, 0xdd, 0x46, 0xe9, 0x58, 0xae, 0x0a, 0xdd, 0x8a, 0xdd, 0x4b, 0x71,
+	0x3d, 0x33, 0x39, 0xfd, 0x1e, 0x5e, 0xb4, 0x6d, 0x78, 0xa6, 0x30, 0x2a, 0xa5, 0x12, 0x5a, 0xd4,
+	0x68, 0x45, 0x27, 0x8c, 0x36, 0xed, 0x0c, 0xf5, 0x6b, 0xcb, 0xd0, 0x6f, 0xe1, 0xe4, 0x49, 0xe9,
+	0x24, 0x4a, 0x44, 0x6c, 0x54, 0xe4, 0xd5, 0xba, 0x75, 0xb8, 0x6f, 0xe0, 0x8b, 0x2d, 0x1a, 0x4a,
+	0xe8, 0xcd, 0x1e, 0x30, 0x4e, 0xd5, 0x2a, 0xa7, 0xaf, 0xc0, 0xb5, 0xbb, 0x24, 0x76, 0x97, 0xa7,
+	0x66, 0xea, 0x0d, 0x37, 0x31, 0xab, 0xab, 0x84, 0x7e, 0xc8, 0x99, 0xad, 0x31, 0x2a, 0xaa, 0x55,
+	0x6e, 0x07, 0x76, 0x99, 0x09, 0xc3, 0x97, 0xd0, 0xdf, 0x16, 0x35, 0x5b, 0x9b, 0xbd, 0x39, 0x9f,
+	0x0d, 0x0f, 0xe8, 0x11, 0xf4, 0x3e, 0x7c, 0xf8, 0x85, 0xab, 0x87, 0x1f, 0x7f, 0x18, 0x92, 0x30,
+	0x86, 0xee, 0x05, 0xd7, 0xfc, 0x12, 0xd7, 0x3b, 0xee, 0x22, 0xbb, 0xee, 0xa2, 0xe0, 0x26, 0x5c,
+	0xf3, 0x56, 0x4c, 0x1b, 0x1b, 0x73, 0x8b, 0xba, 0x7d, 0xf5, 0x8e, 0xa8, 0xcd, 0xab, 0x8e, 0x2b,
+	0xb4, 0x9a, 0xf2, 0x46, 0xaf, 0x0e, 0xeb, 0xb7, 0xc8, 0x54, 0xbf, 0xfa, 0x0c, 0xfc, 0x7d, 0x17,
+	0xd2, 0x2e, 0x74, 0x38, 0xaa, 0xe1, 0xc1, 0xdb, 0xe1, 0xef, 0xcf, 0x67, 0xe4, 0x8f, 0xe7, 0x33,
+	0xf2, 0xd7, 0xf3, 0x19, 0xf9, 0xed, 0xef, 0xb3, 0x83, 0xa5, 0x67, 0x7f, 0x49, 0x6f, 0xfe, 0x1b,
+	0x00, 0x32, 0x18, 0x99, 0xd7, 0x9e, 0x04, 0x00, 0x00,
 }
 
 func (m *KV) Marshal() (dAtA []byte, err error) {
@@ -981,6 +1010,24 @@ func (m *TableIndex) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if len(m.ZstdDictionary) > 0 {
+		i -= len(m.ZstdDictionary)
+		copy(dAtA[i:], m.ZstdDictionary)
+		i = encodeVarintPb(dAtA, i, uint64(len(m.ZstdDictionary)))
+		i--
+		dAtA[i] = 0x32
+	}
+	if m.BloomFalsePositive != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.BloomFalsePositive))))
+		i--
+		dAtA[i] = 0x29
+	}
+	if m.KeyCount != 0 {
+		i = encodeVarintPb(dAtA, i, uint64(m.KeyCount))
+		i--
+		dAtA[i] = 0x20
+	}
 	if m.EstimatedSize != 0 {
 		i = encodeVarintPb(dAtA, i, uint64(m.EstimatedSize))
 		i--
@@ -1256,6 +1303,16 @@ func (m *TableIndex) Size() (n int) {
 	if m.EstimatedSize != 0 {
 		n += 1 + sovPb(uint64(m.EstimatedSize))
 	}
+	if m.KeyCount != 0 {
+		n += 1 + sovPb(uint64(m.KeyCount))
+	}
+	if m.BloomFalsePositive != 0 {
+		n += 9
+	}
+	l = len(m.ZstdDictionary)
+	if l > 0 {
+		n += 1 + l + sovPb(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -2165,6 +2222,70 @@ func (m *TableIndex) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field KeyCount", wireType)
+			}
+			m.KeyCount = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.KeyCount |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field BloomFalsePositive", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.BloomFalsePositive = float64(math.Float64frombits(v))
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ZstdDictionary", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowPb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthPb
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthPb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ZstdDictionary = append(m.ZstdDictionary[:0], dAtA[iNdEx:postIndex]...)
+			if m.ZstdDictionary == nil {
+				m.ZstdDictionary = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipPb(dAtA[iNdEx:])
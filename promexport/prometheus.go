@@ -0,0 +1,150 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package promexport exports the metrics of Badger to Prometheus. It's kept apart from the badger
+// package so that only the users of Prometheus depend on its client.
+package promexport
+
+import (
+	"expvar"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// promMetric exports an expvar metric to Prometheus under the same name. The metrics backed by an
+// expvar.Map have one label, whose values are the keys of the map.
+type promMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	v         expvar.Var
+}
+
+// promCollector is a prometheus.Collector reading the expvar metrics, so that both report the
+// same values without duplicating state.
+type promCollector struct {
+	metrics []promMetric
+}
+
+func newPromCollector() *promCollector {
+	c := &promCollector{}
+	add := func(v expvar.Var, name, help string, valueType prometheus.ValueType, label string) {
+		var labels []string
+		if label != "" {
+			labels = []string{label}
+		}
+		c.metrics = append(c.metrics, promMetric{
+			desc:      prometheus.NewDesc(name, help, labels, nil),
+			valueType: valueType,
+			v:         v,
+		})
+	}
+	add(y.LSMSize, "badger_lsm_size_bytes", "Size of the LSM tree in bytes.",
+		prometheus.GaugeValue, "dir")
+	add(y.VlogSize, "badger_vlog_size_bytes", "Size of the value log in bytes.",
+		prometheus.GaugeValue, "dir")
+	add(y.PendingWrites, "badger_pending_writes_total", "Number of pending writes.",
+		prometheus.GaugeValue, "dir")
+	add(y.NumReads, "badger_disk_reads_total", "Number of reads from disk.",
+		prometheus.CounterValue, "")
+	add(y.NumWrites, "badger_disk_writes_total", "Number of writes to disk.",
+		prometheus.CounterValue, "")
+	add(y.NumBytesRead, "badger_read_bytes", "Number of bytes read from disk.",
+		prometheus.CounterValue, "")
+	add(y.NumBytesWritten, "badger_written_bytes", "Number of bytes written to disk.",
+		prometheus.CounterValue, "")
+	add(y.NumLSMGets, "badger_lsm_level_gets_total", "Number of gets from each level of the LSM tree.",
+		prometheus.CounterValue, "level")
+	add(y.NumLSMBloomHits, "badger_lsm_bloom_hits_total",
+		"Number of lookups avoided by the bloom filters of each level of the LSM tree.",
+		prometheus.CounterValue, "level")
+	add(y.NumGets, "badger_gets_total", "Number of gets.", prometheus.CounterValue, "")
+	add(y.NumPuts, "badger_puts_total", "Number of puts.", prometheus.CounterValue, "")
+	add(y.NumBlockedPuts, "badger_blocked_puts_total", "Number of puts blocked by a full memtable.",
+		prometheus.CounterValue, "")
+	add(y.NumMemtableGets, "badger_memtable_gets_total", "Number of gets from the memtables.",
+		prometheus.CounterValue, "")
+	add(y.NumCompactions, "badger_compactions_total", "Number of compactions of each level.",
+		prometheus.CounterValue, "level")
+	add(y.WriteStallTime, "badger_write_stall_milliseconds_total",
+		"Time the writes were stalled by level 0, in milliseconds.", prometheus.CounterValue, "dir")
+	add(y.NumTableReopens, "badger_table_reopens_total",
+		"Number of table files opened again after being closed to bound the open files.",
+		prometheus.CounterValue, "")
+	add(y.NumBlockChecksumVerifications, "badger_block_checksum_verifications_total",
+		"Number of checksums of table blocks verified.", prometheus.CounterValue, "")
+	add(y.NumBlockChecksumMismatches, "badger_block_checksum_mismatches_total",
+		"Number of checksums of table blocks which failed verification.",
+		prometheus.CounterValue, "")
+	add(y.CompactionRateLimitUtilization, "badger_compaction_rate_limit_utilization_percent",
+		"IO of the compactions and the value log GC during the last second, as a percentage of "+
+			"the rate limit.", prometheus.GaugeValue, "dir")
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (c *promCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *promCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics {
+		switch v := m.v.(type) {
+		case *expvar.Int:
+			ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, float64(v.Value()))
+		case *expvar.Map:
+			v.Do(func(kv expvar.KeyValue) {
+				switch i := kv.Value.(type) {
+				case *expvar.Int:
+					ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, float64(i.Value()),
+						kv.Key)
+				case expvar.Func:
+					// The gauges computed when read.
+					if v, ok := i().(int64); ok {
+						ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, float64(v), kv.Key)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Register registers the metrics of Badger with reg. The metrics are global to all the DBs of the
+// process, so registering them again with the same registerer is not an error.
+func Register(reg prometheus.Registerer) error {
+	if err := reg.Register(newPromCollector()); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Registerer registers the metrics of Badger with a prometheus.Registerer when a DB is opened. It
+// can be set as badger.Options.MetricsRegisterer, e.g.:
+//
+//	opt = opt.WithMetricsRegisterer(promexport.Registerer{Registerer: prometheus.DefaultRegisterer})
+type Registerer struct {
+	prometheus.Registerer
+}
+
+// RegisterMetrics implements badger.MetricsRegisterer.
+func (r Registerer) RegisterMetrics() error {
+	return Register(r.Registerer)
+}
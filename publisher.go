@@ -17,6 +17,7 @@
 package badger
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/dgraph-io/badger/v2/pb"
@@ -28,6 +29,9 @@ type subscriber struct {
 	prefixes  [][]byte
 	sendCh    chan<- *pb.KVList
 	subCloser *y.Closer
+	// unsubCh is closed when the subscriber is deleted, so that the publisher doesn't block
+	// on sending to it.
+	unsubCh chan struct{}
 }
 
 type publisher struct {
@@ -36,14 +40,16 @@ type publisher struct {
 	subscribers map[uint64]subscriber
 	nextID      uint64
 	indexer     *trie.Trie
+	cmp         y.Comparator // The order of the keys of the DB.
 }
 
-func newPublisher() *publisher {
+func newPublisher(cmp y.Comparator) *publisher {
 	return &publisher{
 		pubCh:       make(chan requests, 1000),
 		subscribers: make(map[uint64]subscriber),
 		nextID:      0,
 		indexer:     trie.NewTrie(),
+		cmp:         cmp,
 	}
 }
 
@@ -74,16 +80,20 @@ func (p *publisher) listenForUpdates(c *y.Closer) {
 }
 
 func (p *publisher) publishUpdates(reqs requests) {
+	// Release all the request.
+	defer reqs.DecrRef()
 	p.Lock()
-	defer func() {
-		p.Unlock()
-		// Release all the request.
-		reqs.DecrRef()
-	}()
 	batchedUpdates := make(map[uint64]*pb.KVList)
 	for _, req := range reqs {
 		for _, e := range req.Entries {
 			ids := p.indexer.Get(e.Key)
+			if start, end, ok := ParseDeleteRange(y.ParseKey(e.Key)); ok {
+				// The garbage collection of a range tombstone isn't published.
+				if e.meta&bitDelete > 0 {
+					continue
+				}
+				ids = p.rangeSubscribers(start, end)
+			}
 			if len(ids) > 0 {
 				k := y.SafeCopy(nil, e.Key)
 				kv := &pb.KV{
@@ -103,11 +113,39 @@ func (p *publisher) publishUpdates(reqs requests) {
 		}
 	}
 
+	subs := make(map[uint64]subscriber, len(batchedUpdates))
+	for id := range batchedUpdates {
+		subs[id] = p.subscribers[id]
+	}
+	p.Unlock()
+
+	// Send the updates without holding the lock, so that a slow subscriber can still be deleted.
+	// The updates are only published by listenForUpdates, so they are still sent in order.
 	for id, kvs := range batchedUpdates {
-		p.subscribers[id].sendCh <- kvs
+		s := subs[id]
+		select {
+		case s.sendCh <- kvs:
+		case <-s.unsubCh:
+		}
 	}
 }
 
+// rangeSubscribers returns the subscribers to a prefix which overlaps the keys in [start, end),
+// which a range tombstone deletes.
+func (p *publisher) rangeSubscribers(start, end []byte) map[uint64]struct{} {
+	t := rangeTombstone{start: start, end: end, cmp: p.cmp}
+	ids := make(map[uint64]struct{})
+	for id, s := range p.subscribers {
+		for _, prefix := range s.prefixes {
+			if _, ok := t.clip(prefix, prefixEnd(prefix)); ok {
+				ids[id] = struct{}{}
+				break
+			}
+		}
+	}
+	return ids
+}
+
 func (p *publisher) newSubscriber(c *y.Closer, prefixes ...[]byte) (<-chan *pb.KVList, uint64) {
 	p.Lock()
 	defer p.Unlock()
@@ -119,6 +157,7 @@ func (p *publisher) newSubscriber(c *y.Closer, prefixes ...[]byte) (<-chan *pb.K
 		prefixes:  prefixes,
 		sendCh:    ch,
 		subCloser: c,
+		unsubCh:   make(chan struct{}),
 	}
 	for _, prefix := range prefixes {
 		p.indexer.Add(prefix, id)
@@ -146,6 +185,7 @@ func (p *publisher) deleteSubscriber(id uint64) {
 		for _, prefix := range s.prefixes {
 			p.indexer.Delete(prefix, id)
 		}
+		close(s.unsubCh)
 	}
 	delete(p.subscribers, id)
 }
@@ -157,6 +197,32 @@ func (p *publisher) sendUpdates(reqs requests) {
 	}
 }
 
+// SubscriptionInfo describes an active subscription, as returned by DB.ActiveSubscriptions.
+type SubscriptionInfo struct {
+	// ID of the subscription, same as Subscription.ID.
+	ID uint64
+	// Prefixes the subscription is watching.
+	Prefixes [][]byte
+	// Backlog is the number of batches of updates waiting to be delivered to the subscription. A
+	// backlog which keeps growing indicates a slow subscriber.
+	Backlog int
+}
+
+func (p *publisher) activeSubscriptions() []SubscriptionInfo {
+	p.Lock()
+	defer p.Unlock()
+	infos := make([]SubscriptionInfo, 0, len(p.subscribers))
+	for id, s := range p.subscribers {
+		info := SubscriptionInfo{ID: id, Backlog: len(s.sendCh)}
+		for _, prefix := range s.prefixes {
+			info.Prefixes = append(info.Prefixes, y.SafeCopy(nil, prefix))
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
 func (p *publisher) noOfSubscribers() int {
 	p.Lock()
 	defer p.Unlock()
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -99,3 +100,186 @@ func TestMultiplePrefix(t *testing.T) {
 		wg.Wait()
 	})
 }
+
+func TestSubscribeFrom(t *testing.T) {
+	opt := getTestOptions("")
+	opt.NumVersionsToKeep = 10
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		set := func(key, val string) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.SetEntry(NewEntry([]byte(key), []byte(val)))
+			}))
+		}
+		for i := 1; i <= 3; i++ {
+			set("a", fmt.Sprintf("a%d", i))
+		}
+		set("b", "b4")
+		set("c", "c5")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		kvCh := make(chan *pb.KV, 100)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- db.SubscribeFrom(ctx, 1, func(kvs *pb.KVList) {
+				for _, kv := range kvs.GetKv() {
+					kvCh <- kv
+				}
+			}, []byte("a"), []byte("b"), []byte("a"))
+		}()
+		next := func() *pb.KV {
+			select {
+			case kv := <-kvCh:
+				return kv
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for an update")
+			}
+			return nil
+		}
+
+		// The replay delivers the versions of each key in increasing order, and each key once.
+		got := make(map[string][]uint64)
+		for i := 0; i < 3; i++ {
+			kv := next()
+			got[string(kv.Key)] = append(got[string(kv.Key)], kv.Version)
+			require.Equal(t, fmt.Sprintf("%s%d", kv.Key, kv.Version), string(kv.Value))
+		}
+		require.Equal(t, map[string][]uint64{"a": {2, 3}, "b": {4}}, got)
+
+		// Then the live changes follow.
+		set("a", "a6")
+		set("c", "c7")
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete([]byte("b"))
+		}))
+		kv := next()
+		require.Equal(t, "a", string(kv.Key))
+		require.Equal(t, uint64(6), kv.Version)
+		kv = next()
+		require.Equal(t, "b", string(kv.Key))
+		require.Equal(t, uint64(8), kv.Version)
+		require.Len(t, kv.Value, 0)
+
+		cancel()
+		require.Equal(t, context.Canceled, <-errCh)
+		require.Len(t, kvCh, 0)
+	})
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		set := func(i int) {
+			require.NoError(t, db.Update(func(txn *Txn) error {
+				return txn.SetEntry(NewEntry([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+			}))
+		}
+
+		// The first subscriber is stuck in its callback.
+		release := make(chan struct{})
+		slow, err := db.NewSubscription(context.Background(), func(kvs *pb.KVList) {
+			<-release
+		}, []byte("key"))
+		require.NoError(t, err)
+		var mu sync.Mutex
+		var received int
+		fast, err := db.NewSubscription(context.Background(), func(kvs *pb.KVList) {
+			mu.Lock()
+			received += len(kvs.GetKv())
+			mu.Unlock()
+		}, []byte("ke"), []byte("x"))
+		require.NoError(t, err)
+		require.NotEqual(t, slow.ID(), fast.ID())
+
+		for i := 0; i < 10; i++ {
+			set(i)
+		}
+		// The slow subscriber doesn't block the delivery to the other one.
+		numReceived := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return received
+		}
+		require.Eventually(t, func() bool { return numReceived() == 10 }, 5*time.Second,
+			10*time.Millisecond)
+
+		infos := db.ActiveSubscriptions()
+		require.Len(t, infos, 2)
+		require.Equal(t, slow.ID(), infos[0].ID)
+		require.Equal(t, [][]byte{[]byte("key")}, infos[0].Prefixes)
+		require.True(t, infos[0].Backlog > 0)
+		require.Equal(t, fast.ID(), infos[1].ID)
+		require.Equal(t, [][]byte{[]byte("ke"), []byte("x")}, infos[1].Prefixes)
+		require.Equal(t, 0, infos[1].Backlog)
+
+		close(release)
+		require.NoError(t, slow.Close())
+		infos = db.ActiveSubscriptions()
+		require.Len(t, infos, 1)
+		require.Equal(t, fast.ID(), infos[0].ID)
+
+		for i := 10; i < 20; i++ {
+			set(i)
+		}
+		require.Eventually(t, func() bool { return numReceived() == 20 }, 5*time.Second,
+			10*time.Millisecond)
+		require.NoError(t, fast.Close())
+		require.Len(t, db.ActiveSubscriptions(), 0)
+	})
+}
+
+func TestSubscribeDeleteRange(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txnSet(t, db, []byte("k1"), []byte("val"), 0)
+
+		subscribe := func(ctx context.Context, prefix string, from bool) chan *pb.KV {
+			kvCh := make(chan *pb.KV, 10)
+			cb := func(kvs *pb.KVList) {
+				for _, kv := range kvs.GetKv() {
+					kvCh <- kv
+				}
+			}
+			if from {
+				go func() { _ = db.SubscribeFrom(ctx, 1, cb, []byte(prefix)) }()
+			} else {
+				_, err := db.NewSubscription(ctx, cb, []byte(prefix))
+				require.NoError(t, err)
+			}
+			return kvCh
+		}
+		next := func(kvCh chan *pb.KV) *pb.KV {
+			select {
+			case kv := <-kvCh:
+				return kv
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for an update")
+			}
+			return nil
+		}
+		checkRange := func(kv *pb.KV) {
+			start, end, ok := ParseDeleteRange(kv.Key)
+			require.True(t, ok)
+			require.Equal(t, "a", string(start))
+			require.Equal(t, "l", string(end))
+			require.Equal(t, uint64(2), kv.Version)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		kCh, zCh := subscribe(ctx, "k", false), subscribe(ctx, "z", false)
+		// Wait for the subscriptions to be registered.
+		for len(db.ActiveSubscriptions()) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.DeleteRange([]byte("a"), []byte("l"))
+		}))
+		txnSet(t, db, []byte("z"), []byte("val"), 0)
+
+		// The range delete is only delivered to the subscribers of the keys it deletes.
+		checkRange(next(kCh))
+		require.Equal(t, "z", string(next(zCh).Key))
+		require.Len(t, zCh, 0)
+
+		// And it is replayed.
+		checkRange(next(subscribe(ctx, "k", true)))
+	})
+}
@@ -0,0 +1,403 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// rangeDelPrefix is the prefix of the keys storing the range tombstones written by
+// Txn.DeleteRange. The rest of the key holds the length of the start key as a big-endian uint16,
+// the start key and the end key.
+var rangeDelPrefix = []byte("!badger!rangedel!")
+
+// rangeTombstone deletes the versions below version of the keys in [start, end). An empty end
+// means no upper bound, which is only used by DB.DropPrefixAsync.
+type rangeTombstone struct {
+	start, end []byte
+	version    uint64
+	cmp        y.Comparator // The order of the keys of the DB.
+}
+
+func (t rangeTombstone) contains(key []byte) bool {
+	return t.cmp.Compare(t.start, key) <= 0 && t.before(key)
+}
+
+// before returns true if key is below the end of the range.
+func (t rangeTombstone) before(key []byte) bool {
+	return len(t.end) == 0 || t.cmp.Compare(key, t.end) < 0
+}
+
+func rangeDelKey(start, end []byte) []byte {
+	key := make([]byte, 0, len(rangeDelPrefix)+2+len(start)+len(end))
+	key = append(key, rangeDelPrefix...)
+	key = append(key, byte(len(start)>>8), byte(len(start)))
+	key = append(key, start...)
+	return append(key, end...)
+}
+
+func parseRangeDelKey(key []byte) (start, end []byte, err error) {
+	key = key[len(rangeDelPrefix):]
+	if len(key) < 2 {
+		return nil, nil, errors.Errorf("Invalid range tombstone key: %q", key)
+	}
+	n := int(binary.BigEndian.Uint16(key))
+	key = key[2:]
+	if n > len(key) {
+		return nil, nil, errors.Errorf("Invalid range tombstone key: %q", key)
+	}
+	return key[:n], key[n:], nil
+}
+
+// ParseDeleteRange returns the range [start, end) deleted by Txn.DeleteRange, if key is the key of
+// a range tombstone. Streams, backups and subscriptions carry the range tombstones as KVs whose key
+// uses an internal prefix, and DB.Load writes them back. An empty end means no upper bound.
+func ParseDeleteRange(key []byte) (start, end []byte, ok bool) {
+	if !bytes.HasPrefix(key, rangeDelPrefix) {
+		return nil, nil, false
+	}
+	start, end, err := parseRangeDelKey(key)
+	return start, end, err == nil
+}
+
+// kv returns the KV which carries the tombstone in streams and subscriptions.
+func (t rangeTombstone) kv() *pb.KV {
+	return &pb.KV{
+		Key:      rangeDelKey(t.start, t.end),
+		UserMeta: []byte{0},
+		Version:  t.version,
+		Meta:     []byte{0},
+	}
+}
+
+// clip returns the part of the tombstone within [start, end), and false if there's none. An empty
+// end means no upper bound.
+func (t rangeTombstone) clip(start, end []byte) (rangeTombstone, bool) {
+	if t.cmp.Compare(start, t.start) > 0 {
+		t.start = start
+	}
+	if len(end) > 0 && (len(t.end) == 0 || t.cmp.Compare(end, t.end) < 0) {
+		t.end = end
+	}
+	return t, t.before(t.start)
+}
+
+// tombstoneID identifies a range tombstone.
+type tombstoneID struct {
+	start, end string
+	version    uint64
+}
+
+func (t rangeTombstone) id() tombstoneID {
+	return tombstoneID{start: string(t.start), end: string(t.end), version: t.version}
+}
+
+// rangeTombstones holds the range tombstones committed to the DB which haven't been garbage
+// collected yet.
+type rangeTombstones struct {
+	sync.RWMutex
+	cmp  y.Comparator
+	list []rangeTombstone // Sorted by start.
+	// fragments split the key space at the starts and the ends of the tombstones, so that finding
+	// the tombstones covering a key is a binary search. Rebuilt each time the list changes.
+	fragments []rangeFragment
+	// unchecked holds the tombstones which the GC has to check, as they are new or a compaction
+	// touched their range since they were last checked.
+	unchecked map[tombstoneID]struct{}
+}
+
+// rangeFragment is the range of keys from start up to the start of the next fragment, all of which
+// are covered by the same tombstones.
+type rangeFragment struct {
+	start    []byte
+	versions []uint64 // The versions of the tombstones covering the fragment, ascending.
+}
+
+func (r *rangeTombstones) add(t rangeTombstone) {
+	r.Lock()
+	defer r.Unlock()
+	r.cmp = t.cmp
+	i := sort.Search(len(r.list), func(i int) bool {
+		return t.cmp.Compare(r.list[i].start, t.start) > 0
+	})
+	r.list = append(r.list, rangeTombstone{})
+	copy(r.list[i+1:], r.list[i:])
+	r.list[i] = t
+	r.mark(t)
+	r.index()
+}
+
+func (r *rangeTombstones) remove(t rangeTombstone) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.unchecked, t.id())
+	for i, rt := range r.list {
+		if rt.version == t.version && bytes.Equal(rt.start, t.start) &&
+			bytes.Equal(rt.end, t.end) {
+			r.list = append(r.list[:i], r.list[i+1:]...)
+			r.index()
+			return
+		}
+	}
+}
+
+func (r *rangeTombstones) reset() {
+	r.Lock()
+	defer r.Unlock()
+	r.list = nil
+	r.fragments = nil
+	r.unchecked = nil
+}
+
+// index rebuilds the fragments from the list of tombstones.
+func (r *rangeTombstones) index() {
+	r.fragments = nil
+	bounds := make([][]byte, 0, 2*len(r.list))
+	for _, t := range r.list {
+		bounds = append(bounds, t.start)
+		if len(t.end) > 0 {
+			bounds = append(bounds, t.end)
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return r.cmp.Compare(bounds[i], bounds[j]) < 0 })
+	for i, b := range bounds {
+		if i > 0 && r.cmp.Compare(bounds[i-1], b) == 0 {
+			continue
+		}
+		// No bound falls within a fragment, so a tombstone covers either all of it or none of it.
+		f := rangeFragment{start: b}
+		for _, t := range r.list {
+			if r.cmp.Compare(t.start, b) > 0 {
+				break
+			}
+			if t.before(b) {
+				f.versions = append(f.versions, t.version)
+			}
+		}
+		sort.Slice(f.versions, func(i, j int) bool { return f.versions[i] < f.versions[j] })
+		r.fragments = append(r.fragments, f)
+	}
+}
+
+func (r *rangeTombstones) load() []rangeTombstone {
+	r.RLock()
+	defer r.RUnlock()
+	return append([]rangeTombstone(nil), r.list...)
+}
+
+// overlapping returns the tombstones with a version in [sinceTs, readTs], clipped to [start, end).
+// An empty end means no upper bound.
+func (r *rangeTombstones) overlapping(start, end []byte, sinceTs, readTs uint64) []rangeTombstone {
+	var out []rangeTombstone
+	for _, t := range r.load() {
+		if t.version < sinceTs || t.version > readTs {
+			continue
+		}
+		if t, ok := t.clip(start, end); ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// covers returns true if the given version of the user key is deleted by a range tombstone visible
+// at readTs. The keys with the !badger! prefix are never deleted by range tombstones.
+func (r *rangeTombstones) covers(key []byte, version, readTs uint64) bool {
+	r.RLock()
+	defer r.RUnlock()
+	if len(r.fragments) == 0 || bytes.HasPrefix(key, badgerPrefix) {
+		return false
+	}
+	i := sort.Search(len(r.fragments), func(i int) bool {
+		return r.cmp.Compare(r.fragments[i].start, key) > 0
+	}) - 1
+	if i < 0 {
+		return false
+	}
+	// The oldest tombstone newer than version deletes it, if readTs sees it.
+	vs := r.fragments[i].versions
+	j := sort.Search(len(vs), func(j int) bool { return vs[j] > version })
+	return j < len(vs) && vs[j] <= readTs
+}
+
+// touch marks the tombstones overlapping kr to be checked by the GC, as a compaction of kr might
+// have discarded the last versions they deleted.
+func (r *rangeTombstones) touch(kr keyRange) {
+	r.Lock()
+	defer r.Unlock()
+	left, right := y.ParseKey(kr.left), y.ParseKey(kr.right)
+	for _, t := range r.list {
+		if !kr.inf && r.cmp.Compare(t.start, right) > 0 {
+			break
+		}
+		if kr.inf || t.before(left) {
+			r.mark(t)
+		}
+	}
+}
+
+// check returns the tombstones to be checked by the GC, which no reader can see past anymore, and
+// unmarks them.
+func (r *rangeTombstones) check(discardTs uint64) []rangeTombstone {
+	r.Lock()
+	defer r.Unlock()
+	var out []rangeTombstone
+	for _, t := range r.list {
+		if _, ok := r.unchecked[t.id()]; ok && t.version <= discardTs {
+			delete(r.unchecked, t.id())
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// uncheck marks the tombstones to be checked again by the GC.
+func (r *rangeTombstones) uncheck(ts []rangeTombstone) {
+	r.Lock()
+	defer r.Unlock()
+	for _, t := range ts {
+		r.mark(t)
+	}
+}
+
+func (r *rangeTombstones) mark(t rangeTombstone) {
+	if r.unchecked == nil {
+		r.unchecked = make(map[tombstoneID]struct{})
+	}
+	r.unchecked[t.id()] = struct{}{}
+}
+
+// loadRangeTombstones reads the range tombstones stored in the DB.
+func (db *DB) loadRangeTombstones() error {
+	return db.View(func(txn *Txn) error {
+		opt := DefaultIteratorOptions
+		opt.InternalAccess = true
+		opt.PrefetchValues = false
+		opt.Prefix = rangeDelPrefix
+		// The tombstones are read even if expired, as a range delete never expires: the ones
+		// written with an expiry by earlier versions must not bring the deleted keys back.
+		opt.AllVersions = true
+		itr := txn.NewIterator(opt)
+		defer itr.Close()
+
+		var last []byte
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			item := itr.Item()
+			// Only the latest version of each tombstone counts, and the deleted ones were garbage
+			// collected.
+			if bytes.Equal(item.Key(), last) {
+				continue
+			}
+			last = item.KeyCopy(last)
+			if item.meta&bitDelete > 0 {
+				continue
+			}
+			start, end, err := parseRangeDelKey(item.Key())
+			if err != nil {
+				return err
+			}
+			db.rangeDels.add(rangeTombstone{
+				start:   y.SafeCopy(nil, start),
+				end:     y.SafeCopy(nil, end),
+				version: item.Version(),
+				cmp:     db.cmp,
+			})
+		}
+		return nil
+	})
+}
+
+// rangeHasOlderVersions returns true if the LSM tree still holds versions of keys in the range of
+// t which are older than t.
+func (db *DB) rangeHasOlderVersions(t rangeTombstone) bool {
+	tables, decr := db.getMemTables()
+	defer decr()
+	var iters []y.Iterator
+	for _, mt := range tables {
+		iters = append(iters, mt.NewUniIterator(false))
+	}
+	return hasOlderVersions(db.lc.appendIterators(iters, &IteratorOptions{}), t)
+}
+
+// tablesHaveOlderVersions is like rangeHasOlderVersions, but only looks at the tables.
+func (db *DB) tablesHaveOlderVersions(t rangeTombstone) bool {
+	return hasOlderVersions(db.lc.appendIterators(nil, &IteratorOptions{}), t)
+}
+
+func hasOlderVersions(iters []y.Iterator, t rangeTombstone) bool {
+	it := table.NewMergeIteratorWithComparator(iters, false, t.cmp, nil)
+	defer it.Close()
+
+	for it.Seek(y.KeyWithTs(t.start, math.MaxUint64)); it.Valid(); it.Next() {
+		if !t.before(y.ParseKey(it.Key())) {
+			break
+		}
+		if y.ParseTs(it.Key()) < t.version {
+			return true
+		}
+	}
+	return false
+}
+
+// gcRangeTombstones deletes the range tombstones which no reader can see past anymore, and whose
+// deleted versions have all been discarded by compactions. Only the tombstones added, or whose range
+// was compacted, since they were last checked are looked at.
+func (db *DB) gcRangeTombstones() {
+	ts := db.rangeDels.check(db.orc.discardAtOrBelow())
+	for i, t := range ts {
+		if db.rangeHasOlderVersions(t) {
+			// The compaction discarding these versions will mark the tombstone again.
+			continue
+		}
+		// Delete the tombstone at its own version, the delete marker shadows it like the
+		// discard stats which are always written at version 1.
+		e := &Entry{Key: y.KeyWithTs(rangeDelKey(t.start, t.end), t.version), meta: bitDelete}
+		req, err := db.sendToWriteCh([]*Entry{e})
+		if err == nil {
+			err = req.Wait()
+		}
+		if err != nil {
+			db.opt.logger(LogComponentCompact).Warningf(
+				"While deleting range tombstone [%q, %q): %v", t.start, t.end, err)
+			db.rangeDels.uncheck(ts[i:])
+			return
+		}
+		db.rangeDels.remove(t)
+	}
+}
+
+// runRangeTombstoneGC garbage collects the range tombstones each time a compaction completes.
+func (db *DB) runRangeTombstoneGC(lc *y.Closer) {
+	defer lc.Done()
+	for {
+		select {
+		case <-lc.HasBeenClosed():
+			return
+		case <-db.rangeDelGC:
+			db.gcRangeTombstones()
+		}
+	}
+}
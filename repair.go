@@ -0,0 +1,166 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/badger/v2/table"
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// quarantineDir is the directory, within Options.Dir, to which the tables dropped by the repair
+// mode are moved.
+const quarantineDir = "quarantine"
+
+// RepairReport lists the data dropped while opening the DB with Options.RepairMode.
+type RepairReport struct {
+	// Tables are the tables which couldn't be opened, and were removed from the LSM tree.
+	Tables []DroppedTable
+	// ValueLogs are the value log files which were truncated.
+	ValueLogs []TruncatedValueLog
+}
+
+// Empty returns true if nothing was dropped.
+func (r RepairReport) Empty() bool {
+	return len(r.Tables) == 0 && len(r.ValueLogs) == 0
+}
+
+// DroppedTable is a table dropped by the repair mode.
+type DroppedTable struct {
+	ID    uint64
+	Level int
+	// Path is the path of the file in the quarantine directory, empty if it was missing.
+	Path string
+	// Smallest and Biggest are the keys, without timestamp, of the table. They are only known
+	// if its index could still be read, skipping the checksum verification.
+	Smallest []byte
+	Biggest  []byte
+	// Err is the error hit while opening the table.
+	Err error
+}
+
+// TruncatedValueLog is a value log file truncated by the repair mode. The entries from Offset to
+// Size were dropped.
+type TruncatedValueLog struct {
+	Fid  uint32
+	Path string
+	// Offset is the end of the last valid entry of the file, where the file was truncated. The
+	// file is deleted if it has no valid entry, unless it is the last one.
+	Offset int64
+	// Size is the size of the file before it was truncated.
+	Size int64
+}
+
+// repairState collects the RepairReport while the DB is being opened.
+type repairState struct {
+	sync.Mutex
+	report RepairReport
+}
+
+// RepairReport returns what was dropped while opening the DB with Options.RepairMode. It is empty
+// if nothing was, or if the repair mode wasn't enabled.
+func (db *DB) RepairReport() RepairReport {
+	db.repair.Lock()
+	defer db.repair.Unlock()
+	return db.repair.report
+}
+
+// Repair opens the DB with Options.RepairMode, closes it and returns what was dropped.
+func Repair(opt Options) (RepairReport, error) {
+	opt.RepairMode = true
+	db, err := Open(opt)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	report := db.RepairReport()
+	return report, db.Close()
+}
+
+// dropTable moves a table which couldn't be opened to the quarantine directory, removes it from
+// the manifest, and records it in the RepairReport.
+func (db *DB) dropTable(id uint64, tf TableManifest, openErr error) error {
+	dt := DroppedTable{ID: id, Level: int(tf.Level), Err: openErr}
+	fname := table.NewFilename(id, db.opt.Dir)
+	if _, err := os.Stat(fname); err == nil {
+		dt.Smallest, dt.Biggest = db.tableRange(fname, tf)
+		dir := filepath.Join(db.opt.Dir, quarantineDir)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return y.Wrapf(err, "While creating the quarantine directory")
+		}
+		dt.Path = filepath.Join(dir, filepath.Base(fname))
+		if err := os.Rename(fname, dt.Path); err != nil {
+			return y.Wrapf(err, "While moving table %d to the quarantine directory", id)
+		}
+	}
+	if err := db.manifest.addChanges([]*pb.ManifestChange{newDeleteChange(id)}); err != nil {
+		return err
+	}
+	db.opt.logger(LogComponentRecovery).with("table", id).Warningf(
+		"Dropped table %d of level %d, with keys from %q to %q, moved to %q: %v",
+		id, tf.Level, dt.Smallest, dt.Biggest, dt.Path, openErr)
+
+	db.repair.Lock()
+	defer db.repair.Unlock()
+	db.repair.report.Tables = append(db.repair.report.Tables, dt)
+	return nil
+}
+
+// tableRange returns the smallest and biggest keys of a table which couldn't be opened, if its
+// index can still be read without verifying the checksums.
+func (db *DB) tableRange(fname string, tf TableManifest) (smallest, biggest []byte) {
+	fd, err := y.OpenExistingFile(fname, y.ReadOnly)
+	if err != nil {
+		return nil, nil
+	}
+	dk, err := db.registry.dataKey(tf.KeyID)
+	if err != nil {
+		_ = fd.Close()
+		return nil, nil
+	}
+	topt := buildTableOptions(db)
+	topt.Compression = tf.Compression
+	topt.DataKey = dk
+	topt.ChkMode = options.NoVerification
+	topt.LoadingMode = options.FileIO
+	t, err := table.OpenTable(fd, topt)
+	if err != nil {
+		_ = fd.Close()
+		return nil, nil
+	}
+	defer func() { _ = t.Close() }()
+	return y.SafeCopy(nil, y.ParseKey(t.Smallest())), y.SafeCopy(nil, y.ParseKey(t.Biggest()))
+}
+
+// truncatedValueLog records a value log file truncated at offset in the RepairReport.
+func (db *DB) truncatedValueLog(lf *logFile, offset uint32, size int64) {
+	db.opt.logger(LogComponentRecovery).with("fid", lf.fid).Warningf(
+		"Truncated value log file %q from offset %d to %d", lf.path, size, offset)
+
+	db.repair.Lock()
+	defer db.repair.Unlock()
+	db.repair.report.ValueLogs = append(db.repair.report.ValueLogs, TruncatedValueLog{
+		Fid:    lf.fid,
+		Path:   lf.path,
+		Offset: int64(offset),
+		Size:   size,
+	})
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithChecksumVerificationMode(options.OnTableRead).
+		WithCompactL0OnClose(false).WithKeepL0InMemory(false).WithMaxTableSize(1 << 20)
+
+	// Write three batches of keys, each flushed to its own table of several blocks on close.
+	for _, prefix := range []string{"a", "b", "c"} {
+		db, err := Open(opt)
+		require.NoError(t, err)
+		batch := db.NewWriteBatch()
+		for i := 0; i < 1000; i++ {
+			key := []byte(fmt.Sprintf("%s%04d", prefix, i))
+			require.NoError(t, batch.Set(key, key))
+		}
+		require.NoError(t, batch.Flush())
+		require.NoError(t, db.Close())
+	}
+	tables, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+	require.NoError(t, err)
+	require.Len(t, tables, 3)
+	sort.Strings(tables)
+
+	// Corrupt a block of the first table, remove the second one, and append garbage to the
+	// value log.
+	f, err := os.OpenFile(tables[0], os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("garbage"), 20)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(tables[1]))
+	vlogs, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	require.NoError(t, err)
+	sort.Strings(vlogs)
+	vf, err := os.OpenFile(vlogs[len(vlogs)-1], os.O_RDWR|os.O_APPEND, 0)
+	require.NoError(t, err)
+	fi, err := vf.Stat()
+	require.NoError(t, err)
+	_, err = vf.Write([]byte("garbage"))
+	require.NoError(t, err)
+	require.NoError(t, vf.Close())
+
+	_, err = Open(opt)
+	require.Error(t, err)
+
+	report, err := Repair(opt)
+	require.NoError(t, err)
+	require.False(t, report.Empty())
+
+	require.Len(t, report.Tables, 2)
+	sort.Slice(report.Tables, func(i, j int) bool {
+		return report.Tables[i].ID < report.Tables[j].ID
+	})
+	corrupt, missing := report.Tables[0], report.Tables[1]
+	require.Error(t, corrupt.Err)
+	// The tables flushed from the memtables also hold the value log head.
+	require.Equal(t, string(head), string(corrupt.Smallest))
+	require.Equal(t, "a0999", string(corrupt.Biggest))
+	require.Equal(t, filepath.Join(dir, quarantineDir, filepath.Base(tables[0])), corrupt.Path)
+	_, err = os.Stat(corrupt.Path)
+	require.NoError(t, err)
+	require.Error(t, missing.Err)
+	require.Empty(t, missing.Path)
+	require.Nil(t, missing.Smallest)
+
+	require.Len(t, report.ValueLogs, 1)
+	require.Equal(t, vlogs[len(vlogs)-1], report.ValueLogs[0].Path)
+	require.Equal(t, fi.Size(), report.ValueLogs[0].Offset)
+	require.Equal(t, fi.Size()+int64(len("garbage")), report.ValueLogs[0].Size)
+
+	// The DB opens normally once repaired, with the keys of the tables which were kept.
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.True(t, db.RepairReport().Empty())
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("a0500"))
+		require.Equal(t, ErrKeyNotFound, err)
+		_, err = txn.Get([]byte("b0500"))
+		require.Equal(t, ErrKeyNotFound, err)
+		_, err = txn.Get([]byte("c0500"))
+		return err
+	}))
+}
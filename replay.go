@@ -0,0 +1,155 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// ReplayProgress describes the progress of the replay of the value log by Open. See
+// Options.OnReplayProgress.
+type ReplayProgress struct {
+	// Files is the number of value log files to replay, and FilesDone the number of them replayed.
+	Files     int
+	FilesDone int
+	// Bytes is the size of the value log to replay, from the value head persisted in the LSM tree
+	// to the end of the last file, and BytesDone the size replayed.
+	Bytes     int64
+	BytesDone int64
+	// Entries is the number of entries replayed.
+	Entries int64
+	// Fid is the ID of the file being replayed, or of the last one replayed.
+	Fid uint32
+
+	Elapsed time.Duration
+	// Done is only set once the replay is done, in the last call.
+	Done bool
+}
+
+// replayProgressInterval is the interval between the calls of Options.OnReplayProgress during
+// the replay of a file.
+const replayProgressInterval = time.Second
+
+// replayProgress tracks the progress of the replay of the value log for Options.OnReplayProgress.
+// Its methods do nothing on a nil replayProgress, which is used if the option isn't set.
+type replayProgress struct {
+	fn          func(ReplayProgress)
+	p           ReplayProgress
+	sizes       map[uint32]int64 // The size to replay of each file.
+	base        int64            // The size of the files replayed.
+	offset      uint32           // The offset the replay of the current file started at.
+	start, last time.Time
+}
+
+// newReplayProgress returns the progress of the replay of the files fids, from ptr on, and
+// reports it. It returns nil if Options.OnReplayProgress isn't set.
+func (vlog *valueLog) newReplayProgress(fids []uint32, ptr valuePointer) *replayProgress {
+	if vlog.opt.OnReplayProgress == nil {
+		return nil
+	}
+	rp := &replayProgress{
+		fn:    vlog.opt.OnReplayProgress,
+		sizes: make(map[uint32]int64),
+		start: time.Now(),
+	}
+	// The sizes are only used for the progress, so they're left out if the files can't be listed.
+	files, err := vlog.fs.ReadDir(vlog.dirPath)
+	if err != nil {
+		vlog.db.opt.logger(LogComponentRecovery).Warningf(
+			"Unable to list the value log files for the replay progress: %v", err)
+	}
+	size := make(map[string]int64, len(files))
+	for _, fi := range files {
+		size[fi.Name()] = fi.Size()
+	}
+	for _, fid := range fids {
+		if vlog.opt.ReadOnlySnapshot || fid < ptr.Fid {
+			continue
+		}
+		sz := size[filepath.Base(vlog.fpath(fid))]
+		if fid == ptr.Fid {
+			sz -= int64(ptr.Offset + ptr.Len)
+		}
+		if sz < 0 {
+			sz = 0
+		}
+		rp.sizes[fid] = sz
+		rp.p.Files++
+		rp.p.Bytes += sz
+	}
+	rp.report()
+	return rp
+}
+
+// report calls Options.OnReplayProgress with the current progress.
+func (rp *replayProgress) report() {
+	rp.last = time.Now()
+	rp.p.Elapsed = rp.last.Sub(rp.start)
+	rp.fn(rp.p)
+}
+
+// startFile starts the replay of the file fid, at offset.
+func (rp *replayProgress) startFile(fid, offset uint32) {
+	if rp == nil {
+		return
+	}
+	rp.p.Fid = fid
+	rp.offset = offset
+}
+
+// wrap returns replayFn, counting the entries it replays.
+func (rp *replayProgress) wrap(replayFn logEntry) logEntry {
+	if rp == nil {
+		return replayFn
+	}
+	return func(e Entry, vp valuePointer) error {
+		if err := replayFn(e, vp); err != nil {
+			return err
+		}
+		rp.p.Entries++
+		done := rp.base + int64(vp.Offset+vp.Len) - int64(rp.offset)
+		if done > rp.base+rp.sizes[rp.p.Fid] {
+			done = rp.base + rp.sizes[rp.p.Fid]
+		}
+		rp.p.BytesDone = done
+		if time.Since(rp.last) >= replayProgressInterval {
+			rp.report()
+		}
+		return nil
+	}
+}
+
+// doneFile ends the replay of the current file.
+func (rp *replayProgress) doneFile() {
+	if rp == nil {
+		return
+	}
+	rp.base += rp.sizes[rp.p.Fid]
+	rp.p.BytesDone = rp.base
+	rp.p.FilesDone++
+	rp.report()
+}
+
+// done ends the replay.
+func (rp *replayProgress) done() {
+	if rp == nil {
+		return
+	}
+	rp.p.Done = true
+	rp.report()
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+type retentionRule struct {
+	prefix []byte
+	keep   int
+}
+
+// versionRetention holds the number of versions to keep for the keys with a given prefix.
+type versionRetention struct {
+	sync.RWMutex
+	rules []retentionRule // Sorted by decreasing length of prefix.
+}
+
+func (r *versionRetention) set(prefix []byte, keep int) {
+	r.Lock()
+	defer r.Unlock()
+	for i := range r.rules {
+		if bytes.Equal(r.rules[i].prefix, prefix) {
+			r.rules[i].keep = keep
+			return
+		}
+	}
+	r.rules = append(r.rules, retentionRule{prefix: y.SafeCopy(nil, prefix), keep: keep})
+	sort.SliceStable(r.rules, func(i, j int) bool {
+		return len(r.rules[i].prefix) > len(r.rules[j].prefix)
+	})
+}
+
+func (r *versionRetention) remove(prefix []byte) {
+	r.Lock()
+	defer r.Unlock()
+	for i := range r.rules {
+		if bytes.Equal(r.rules[i].prefix, prefix) {
+			r.rules = append(r.rules[:i], r.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// numVersionsToKeep returns the number of versions to keep for key, which is the one of the rule
+// with the longest matching prefix, or def if no rule matches.
+func (r *versionRetention) numVersionsToKeep(key []byte, def int) int {
+	r.RLock()
+	defer r.RUnlock()
+	for _, rule := range r.rules {
+		if bytes.HasPrefix(key, rule.prefix) {
+			return rule.keep
+		}
+	}
+	return def
+}
+
+// SetVersionRetention sets the number of versions to keep for the keys with the given prefix,
+// overriding Options.NumVersionsToKeep. If several prefixes match a key, the longest one is used.
+// A keep of 0 keeps all the versions. Setting a prefix again replaces its previous value.
+//
+// Old versions are discarded by compactions, so a new value only applies to the compactions run
+// from then on. Versions which have already been discarded are not brought back. The prefixes are
+// not persisted, so they must be set again each time the DB is opened.
+func (db *DB) SetVersionRetention(prefix []byte, keep int) error {
+	if keep < 0 {
+		return ErrInvalidRequest
+	}
+	if keep == 0 {
+		keep = math.MaxInt32
+	}
+	db.retention.set(prefix, keep)
+	return nil
+}
+
+// numVersionsToKeep returns the number of versions to keep for the given user key.
+func (db *DB) numVersionsToKeep(key []byte) int {
+	return db.retention.numVersionsToKeep(key, db.opt.NumVersionsToKeep)
+}
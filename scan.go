@@ -0,0 +1,210 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// ParallelScanOptions is used to configure DB.ParallelScan.
+type ParallelScanOptions struct {
+	// Prefix, if set, only scans the keys with this prefix.
+	Prefix []byte
+	// NumGo is the number of goroutines scanning the key ranges. Defaults to 16.
+	NumGo int
+	// PrefetchValues prefetches the values of the items, like IteratorOptions.PrefetchValues.
+	PrefetchValues bool
+}
+
+// ParallelScan calls fn for the latest version of every key which is neither deleted nor expired,
+// like iterating over the DB with the default iterator options, but using NumGo goroutines. The
+// key space is split into disjoint ranges at the boundaries of the tables, and each goroutine
+// iterates over its own ranges, so the calls to fn are concurrent, and the keys are not passed in
+// sorted order. fn must do its own synchronization. Like with Iterator, the item is only valid
+// during the call to fn.
+//
+// ParallelScan reads a consistent snapshot of the DB. If fn returns an error, the scan stops as
+// soon as possible in all the goroutines, and the first error is returned.
+func (db *DB) ParallelScan(opt ParallelScanOptions, fn func(*Item) error) error {
+	numGo := opt.NumGo
+	if numGo <= 0 {
+		numGo = 16
+	}
+	ranges := db.scanRanges(opt.Prefix, numGo)
+	return db.scanConcurrently(len(ranges), numGo,
+		func(ctx context.Context, txn *Txn, i int) error {
+			kr := ranges[i]
+			iopt := DefaultIteratorOptions
+			iopt.Prefix = opt.Prefix
+			iopt.Bound = kr.right
+			iopt.PrefetchValues = opt.PrefetchValues
+			iopt.Context = ctx
+			itr := txn.NewIterator(iopt)
+			defer itr.Close()
+			for itr.Seek(kr.left); itr.ValidForPrefix(opt.Prefix); itr.Next() {
+				if err := fn(itr.Item()); err != nil {
+					return err
+				}
+			}
+			return itr.Err()
+		})
+}
+
+// ScanPrefixesOptions is used to configure DB.ScanPrefixes.
+type ScanPrefixesOptions struct {
+	// NumGo is the number of prefixes scanned at the same time. Defaults to 16.
+	NumGo int
+	// PrefetchValues prefetches the values of the items, like IteratorOptions.PrefetchValues.
+	PrefetchValues bool
+}
+
+// ScanPrefixes calls fn for the latest version of every key with one of the given prefixes which
+// is neither deleted nor expired. All the prefixes are read from the same snapshot of the DB, as
+// if they were iterated over one after the other in a single read-only transaction, which keeps
+// the versions of this snapshot from being discarded by compactions until the scan is done.
+//
+// Up to NumGo prefixes are scanned at the same time, each by its own goroutine. The keys of a
+// prefix are passed to fn in sorted order, from a single goroutine, but the calls for different
+// prefixes are interleaved and concurrent, so fn must do its own synchronization. A prefix which
+// starts with another one of the prefixes is ignored, as its keys are already scanned. Like with
+// Iterator, the item is only valid during the call to fn.
+//
+// If fn returns an error, the scan stops as soon as possible for all the prefixes, and the first
+// error is returned.
+func (db *DB) ScanPrefixes(prefixes [][]byte, fn func(*Item) error,
+	opt ScanPrefixesOptions) error {
+	numGo := opt.NumGo
+	if numGo <= 0 {
+		numGo = 16
+	}
+	prefixes = disjointPrefixes(db.cmp, prefixes)
+	return db.scanConcurrently(len(prefixes), numGo,
+		func(ctx context.Context, txn *Txn, i int) error {
+			iopt := DefaultIteratorOptions
+			iopt.Prefix = prefixes[i]
+			iopt.PrefetchValues = opt.PrefetchValues
+			iopt.Context = ctx
+			itr := txn.NewIterator(iopt)
+			defer itr.Close()
+			for itr.Rewind(); itr.Valid(); itr.Next() {
+				if err := fn(itr.Item()); err != nil {
+					return err
+				}
+			}
+			return itr.Err()
+		})
+}
+
+// disjointPrefixes returns the prefixes without the ones starting with another prefix, sorted in
+// the order of cmp.
+func disjointPrefixes(cmp y.Comparator, prefixes [][]byte) [][]byte {
+	var out [][]byte
+	for i, p := range prefixes {
+		covered := false
+		for j, other := range prefixes {
+			if bytes.HasPrefix(p, other) && (len(other) < len(p) || j < i) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return cmp.Compare(out[i], out[j]) < 0 })
+	return out
+}
+
+// scanConcurrently calls scan for the tasks 0 to n-1 from numGo goroutines, with a read-only
+// transaction shared by all of them. If a call fails, the context given to the other ones is
+// canceled, no task is started anymore, and the first error is returned.
+func (db *DB) scanConcurrently(n, numGo int,
+	scan func(ctx context.Context, txn *Txn, i int) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var once sync.Once
+	var firstErr error
+	setErr := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	return db.View(func(txn *Txn) error {
+		taskCh := make(chan int, n)
+		for i := 0; i < n; i++ {
+			taskCh <- i
+		}
+		close(taskCh)
+
+		var wg sync.WaitGroup
+		for i := 0; i < numGo; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for task := range taskCh {
+					if ctx.Err() != nil {
+						return
+					}
+					if err := scan(ctx, txn, task); err != nil {
+						setErr(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		return firstErr
+	})
+}
+
+// scanRanges splits the keys with the given prefix into about numGo ranges, at the biggest keys
+// of the tables.
+func (db *DB) scanRanges(prefix []byte, numGo int) []keyRange {
+	var splits [][]byte
+	for _, split := range db.KeySplits(prefix) {
+		key := y.ParseKey([]byte(split))
+		if db.cmp.Compare(key, prefix) <= 0 {
+			continue
+		}
+		if len(splits) > 0 && bytes.Equal(splits[len(splits)-1], key) {
+			continue
+		}
+		splits = append(splits, key)
+	}
+	pickEvery := len(splits) / numGo
+	if pickEvery < 1 {
+		pickEvery = 1
+	}
+
+	var ranges []keyRange
+	start := y.SafeCopy(nil, prefix)
+	for i, split := range splits {
+		if (i+1)%pickEvery != 0 {
+			continue
+		}
+		ranges = append(ranges, keyRange{left: start, right: split})
+		start = split
+	}
+	return append(ranges, keyRange{left: start})
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelScan(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		batch := db.NewWriteBatch()
+		for i := 0; i < 5000; i++ {
+			for _, prefix := range []string{"a", "b"} {
+				key := []byte(fmt.Sprintf("%s%05d", prefix, i))
+				require.NoError(t, batch.Set(key, key))
+			}
+		}
+		require.NoError(t, batch.Flush())
+		txnDelete(t, db, []byte("b00042"))
+
+		scan := func(opt ParallelScanOptions) []string {
+			var mu sync.Mutex
+			var keys []string
+			require.NoError(t, db.ParallelScan(opt, func(item *Item) error {
+				val, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, item.Key(), val)
+				mu.Lock()
+				defer mu.Unlock()
+				keys = append(keys, string(item.Key()))
+				return nil
+			}))
+			sort.Strings(keys)
+			return keys
+		}
+
+		keys := scan(ParallelScanOptions{NumGo: 4})
+		require.Len(t, keys, 9999)
+		for i := 1; i < len(keys); i++ {
+			require.True(t, keys[i-1] < keys[i], "duplicate key %s", keys[i])
+		}
+		keys = scan(ParallelScanOptions{Prefix: []byte("b"), PrefetchValues: true})
+		require.Len(t, keys, 4999)
+		require.Equal(t, "b00000", keys[0])
+		require.Equal(t, "b04999", keys[len(keys)-1])
+
+		// The first error stops the scan.
+		errStop := errors.New("stop")
+		var calls int32
+		err := db.ParallelScan(ParallelScanOptions{NumGo: 4}, func(item *Item) error {
+			atomic.AddInt32(&calls, 1)
+			return errStop
+		})
+		require.Equal(t, errStop, err)
+		require.True(t, atomic.LoadInt32(&calls) <= 4, "calls=%d", calls)
+	})
+}
+
+func TestScanPrefixes(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		batch := db.NewWriteBatch()
+		for i := 0; i < 1000; i++ {
+			for _, prefix := range []string{"a", "b", "c", "d"} {
+				key := []byte(fmt.Sprintf("%s%04d", prefix, i))
+				require.NoError(t, batch.Set(key, key))
+			}
+		}
+		require.NoError(t, batch.Flush())
+
+		var mu sync.Mutex
+		last := make(map[byte]string)
+		var count int
+		var once sync.Once
+		prefixes := [][]byte{[]byte("c"), []byte("a"), []byte("a00"), []byte("b")}
+		err := db.ScanPrefixes(prefixes, func(item *Item) error {
+			// The writes made during the scan are not seen.
+			once.Do(func() {
+				txnSet(t, db, []byte("b9999"), []byte("b9999"), 0)
+				txnDelete(t, db, []byte("c0500"))
+			})
+			key := string(item.Key())
+			mu.Lock()
+			defer mu.Unlock()
+			// The keys of each prefix are in order.
+			require.True(t, last[key[0]] < key, "%s after %s", key, last[key[0]])
+			last[key[0]] = key
+			count++
+			return nil
+		}, ScanPrefixesOptions{NumGo: 2, PrefetchValues: true})
+		require.NoError(t, err)
+		require.Equal(t, 3000, count)
+		require.Equal(t, map[byte]string{'a': "a0999", 'b': "b0999", 'c': "c0999"}, last)
+
+		// The first error stops the scan.
+		errStop := errors.New("stop")
+		var calls int32
+		err = db.ScanPrefixes(prefixes, func(item *Item) error {
+			atomic.AddInt32(&calls, 1)
+			return errStop
+		}, ScanPrefixesOptions{})
+		require.Equal(t, errStop, err)
+		require.True(t, atomic.LoadInt32(&calls) <= 3, "calls=%d", calls)
+	})
+}
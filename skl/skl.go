@@ -79,6 +79,7 @@ type Skiplist struct {
 	head   *node
 	ref    int32
 	arena  *Arena
+	cmp    y.Comparator
 }
 
 // IncrRef increases the refcount
@@ -125,6 +126,12 @@ func decodeValue(value uint64) (valOffset uint32, valSize uint32) {
 
 // NewSkiplist makes a new empty skiplist, with a given arena size
 func NewSkiplist(arenaSize int64) *Skiplist {
+	return NewSkiplistWithComparator(arenaSize, nil)
+}
+
+// NewSkiplistWithComparator makes a new empty skiplist, with a given arena size, ordering the
+// keys with the given comparator.
+func NewSkiplistWithComparator(arenaSize int64, cmp y.Comparator) *Skiplist {
 	arena := newArena(arenaSize)
 	head := newNode(arena, nil, y.ValueStruct{}, maxHeight)
 	return &Skiplist{
@@ -132,6 +139,7 @@ func NewSkiplist(arenaSize int64) *Skiplist {
 		head:   head,
 		arena:  arena,
 		ref:    1,
+		cmp:    cmp,
 	}
 }
 
@@ -208,7 +216,7 @@ func (s *Skiplist) findNear(key []byte, less bool, allowEqual bool) (*node, bool
 		}
 
 		nextKey := next.key(s.arena)
-		cmp := y.CompareKeys(key, nextKey)
+		cmp := s.cmp.CompareKeys(key, nextKey)
 		if cmp > 0 {
 			// x.key < next.key < key. We can continue to move right.
 			x = next
@@ -263,7 +271,7 @@ func (s *Skiplist) findSpliceForLevel(key []byte, before *node, level int) (*nod
 			return before, next
 		}
 		nextKey := next.key(s.arena)
-		cmp := y.CompareKeys(key, nextKey)
+		cmp := s.cmp.CompareKeys(key, nextKey)
 		if cmp == 0 {
 			// Equality case.
 			return next, next
@@ -504,6 +512,12 @@ func (s *UniIterator) Seek(key []byte) {
 	}
 }
 
+// SeekToFirst implements y.Interface
+func (s *UniIterator) SeekToFirst() { s.iter.SeekToFirst() }
+
+// SeekToLast implements y.Interface
+func (s *UniIterator) SeekToLast() { s.iter.SeekToLast() }
+
 // Key implements y.Interface
 func (s *UniIterator) Key() []byte { return s.iter.Key() }
 
@@ -513,5 +527,23 @@ func (s *UniIterator) Value() y.ValueStruct { return s.iter.Value() }
 // Valid implements y.Interface
 func (s *UniIterator) Valid() bool { return s.iter.Valid() }
 
+// Peek implements y.Peeker. Peeking in a reversed UniIterator requires a search
+// in the skiplist, which costs about as much as a Seek.
+func (s *UniIterator) Peek() ([]byte, bool) {
+	if !s.iter.Valid() {
+		return nil, false
+	}
+	var n *node
+	if !s.reversed {
+		n = s.iter.list.getNext(s.iter.n, 0)
+	} else {
+		n, _ = s.iter.list.findNear(s.iter.Key(), true, false)
+	}
+	if n == nil {
+		return nil, false
+	}
+	return s.iter.list.arena.getKey(n.keyOffset, n.keySize), true
+}
+
 // Close implements y.Interface (and frees up the iter's resources)
 func (s *UniIterator) Close() error { return s.iter.Close() }
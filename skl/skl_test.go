@@ -382,6 +382,33 @@ func TestIteratorPrev(t *testing.T) {
 	require.False(t, it.Valid())
 }
 
+// TestUniIteratorPeek tests that Peek returns the key Next moves to.
+func TestUniIteratorPeek(t *testing.T) {
+	const n = 100
+	l := NewSkiplist(arenaSize)
+	defer l.DecrRef()
+	for i := 0; i < n; i++ {
+		l.Put(y.KeyWithTs([]byte(fmt.Sprintf("%05d", i)), 0),
+			y.ValueStruct{Value: newValue(i), Meta: 0, UserMeta: 0})
+	}
+	for _, reversed := range []bool{false, true} {
+		it := l.NewUniIterator(reversed)
+		_, ok := it.Peek()
+		require.False(t, ok)
+		var count int
+		for it.Rewind(); it.Valid(); count++ {
+			key, ok := it.Peek()
+			it.Next()
+			require.Equal(t, it.Valid(), ok)
+			if ok {
+				require.EqualValues(t, it.Key(), key)
+			}
+		}
+		require.Equal(t, n, count)
+		it.Close()
+	}
+}
+
 // TestIteratorSeek tests Seek and SeekForPrev.
 func TestIteratorSeek(t *testing.T) {
 	const n = 100
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sync/atomic"
+)
+
+// Snapshot pins a read timestamp, so that all the transactions created from it read the same
+// consistent view of the DB, regardless of the transactions committed in between. While a
+// Snapshot is held, compactions keep the versions visible at its read timestamp.
+type Snapshot struct {
+	db       *DB
+	readTs   uint64
+	released int32
+}
+
+// NewSnapshot pins the current read timestamp of the DB. It is absolutely essential to call
+// Release once the Snapshot is no longer needed, otherwise compactions would never discard the
+// versions written after it.
+//
+//  snap, err := db.NewSnapshot()
+//  handle(err)
+//  defer snap.Release()
+//
+// NewSnapshot cannot be used with managed transactions, which can instead pass the same read
+// timestamp to NewTransactionAt and SetDiscardTs.
+func (db *DB) NewSnapshot() (*Snapshot, error) {
+	if db.opt.managedTxns {
+		return nil, ErrManagedTxn
+	}
+	// readTs marks the timestamp as in use in the read watermark, which holds the discard
+	// timestamp of the compactions below it until Release.
+	return &Snapshot{db: db, readTs: db.orc.readTs()}, nil
+}
+
+// ReadTs returns the read timestamp pinned by the Snapshot.
+func (s *Snapshot) ReadTs() uint64 {
+	return s.readTs
+}
+
+// NewTransaction creates a read-only transaction reading at the timestamp of the Snapshot. Like
+// any transaction, it must be discarded. It must not be used after the Snapshot is released.
+func (s *Snapshot) NewTransaction() *Txn {
+	if atomic.LoadInt32(&s.released) == 1 {
+		panic("Snapshot has already been released")
+	}
+	txn := s.db.newTransaction(false, true)
+	txn.readTs = s.readTs
+	// Balances the readMark.Done called by Txn.Discard.
+	s.db.orc.readMark.Begin(s.readTs)
+	return txn
+}
+
+// View executes a function in a read-only transaction reading at the timestamp of the Snapshot.
+// Error returned by the function is relayed by the View method.
+func (s *Snapshot) View(fn func(txn *Txn) error) error {
+	txn := s.NewTransaction()
+	defer txn.Discard()
+	return fn(txn)
+}
+
+// Release unpins the read timestamp of the Snapshot, letting compactions discard the versions
+// only it could see. The transactions created from the Snapshot keep their own pin until they are
+// discarded. Calling Release more than once is a no-op.
+func (s *Snapshot) Release() {
+	if !atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		return
+	}
+	s.db.orc.readMark.Done(s.readTs)
+}
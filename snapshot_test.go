@@ -0,0 +1,70 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		txnSet(t, db, key, []byte("v1"), 0)
+
+		snap, err := db.NewSnapshot()
+		require.NoError(t, err)
+		txnSet(t, db, key, []byte("v2"), 0)
+
+		read := func(txn *Txn) string {
+			item, err := txn.Get(key)
+			require.NoError(t, err)
+			return string(getItemValue(t, item))
+		}
+		for i := 0; i < 2; i++ {
+			require.NoError(t, snap.View(func(txn *Txn) error {
+				require.Equal(t, snap.ReadTs(), txn.ReadTs())
+				require.Equal(t, "v1", read(txn))
+				return nil
+			}))
+		}
+		require.NoError(t, db.View(func(txn *Txn) error {
+			require.Equal(t, "v2", read(txn))
+			return nil
+		}))
+
+		// Compactions can't discard the versions visible to the snapshot.
+		require.Less(t, db.orc.discardAtOrBelow(), snap.ReadTs())
+		snap.Release()
+		snap.Release()
+		require.Eventually(t, func() bool {
+			return db.orc.discardAtOrBelow() >= snap.ReadTs()
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Panics(t, func() { snap.NewTransaction() })
+	})
+}
+
+func TestSnapshotManaged(t *testing.T) {
+	opt := getTestOptions("")
+	opt.managedTxns = true
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		_, err := db.NewSnapshot()
+		require.Equal(t, ErrManagedTxn, err)
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,11 +37,19 @@ const pageSize = 4 << 20 // 4MB
 // key-values, batch them up and call Send. Stream does concurrent iteration over many smaller key
 // ranges. It does NOT send keys in lexicographical sorted order. To get keys in sorted
 // order, use Iterator.
+//
+// The range tombstones written by Txn.DeleteRange, which hide the keys they deleted from the
+// stream, are sent too, as KVs which are never passed to ChooseKey or KeyToList. See
+// ParseDeleteRange.
 type Stream struct {
 	// Prefix to only iterate over certain range of keys. If set to nil (default), Stream would
 	// iterate over the entire DB.
 	Prefix []byte
 
+	// KeyRange to only iterate over the keys in [KeyRange.Start, KeyRange.End). It can be combined
+	// with Prefix, in which case only the keys within both are iterated over.
+	KeyRange KeyRange
+
 	// Number of goroutines to use for iterating over key ranges. Defaults to 16.
 	NumGo int
 
@@ -65,17 +74,37 @@ type Stream struct {
 	// Note: Calls to KeyToList are concurrent.
 	KeyToList func(key []byte, itr *Iterator) (*pb.KVList, error)
 
+	// Transform, if set, is invoked on each KV generated by KeyToList before it's sent. It can
+	// modify the key or the value of the KV, or return a new KV in its place. Returning nil drops
+	// the KV from the output. Transform can be used to redact the data while streaming it.
+	//
+	// Note: Calls to Transform are concurrent.
+	Transform func(kv *pb.KV) (*pb.KV, error)
+
 	// This is the method where Stream sends the final output. All calls to Send are done by a
 	// single goroutine, i.e. logic within Send method can expect single threaded execution.
 	Send func(*pb.KVList) error
 
 	readTs       uint64
+	sinceTs      uint64 // The range tombstones older than sinceTs are not sent.
 	db           *DB
 	rangeCh      chan keyRange
 	kvChan       chan *pb.KVList
 	nextStreamId uint32
 }
 
+// KeyRange is the range of keys [Start, End), including Start and excluding End. A nil Start or
+// End leaves the range unbounded on that side.
+type KeyRange struct {
+	Start []byte
+	End   []byte
+}
+
+// contains returns true if key is within the range, in the order of cmp.
+func (r KeyRange) contains(cmp y.Comparator, key []byte) bool {
+	return cmp.Compare(key, r.Start) >= 0 && (len(r.End) == 0 || cmp.Compare(key, r.End) < 0)
+}
+
 // ToList is a default implementation of KeyToList. It picks up all valid versions of the key,
 // skipping over deleted or expired keys.
 func (st *Stream) ToList(key []byte, itr *Iterator) (*pb.KVList, error) {
@@ -102,7 +131,7 @@ func (st *Stream) ToList(key []byte, itr *Iterator) (*pb.KVList, error) {
 			ExpiresAt: item.ExpiresAt(),
 		}
 		list.Kv = append(list.Kv, kv)
-		if st.db.opt.NumVersionsToKeep == 1 {
+		if st.db.numVersionsToKeep(key) == 1 {
 			break
 		}
 
@@ -117,6 +146,21 @@ func (st *Stream) ToList(key []byte, itr *Iterator) (*pb.KVList, error) {
 // end byte slices are owned by keyRange struct.
 func (st *Stream) produceRanges(ctx context.Context) {
 	splits := st.db.KeySplits(st.Prefix)
+	start := y.SafeCopy(nil, st.Prefix)
+	if st.db.cmp.Compare(st.KeyRange.Start, start) > 0 {
+		start = y.SafeCopy(nil, st.KeyRange.Start)
+	}
+	{
+		// Don't create key ranges outside of KeyRange.
+		filtered := splits[:0]
+		for _, split := range splits {
+			key := y.ParseKey([]byte(split))
+			if st.db.cmp.Compare(key, start) > 0 && st.KeyRange.contains(st.db.cmp, key) {
+				filtered = append(filtered, split)
+			}
+		}
+		splits = filtered
+	}
 
 	// We don't need to create more key ranges than NumGo goroutines. This way, we will have limited
 	// number of "streams" coming out, which then helps limit the memory used by SSWriter.
@@ -134,14 +178,13 @@ func (st *Stream) produceRanges(ctx context.Context) {
 		splits = filtered
 	}
 
-	start := y.SafeCopy(nil, st.Prefix)
 	for _, key := range splits {
 		st.rangeCh <- keyRange{left: start, right: y.SafeCopy(nil, []byte(key))}
 		start = y.SafeCopy(nil, []byte(key))
 	}
 	// Edge case: prefix is empty and no splits exist. In that case, we should have at least one
 	// keyRange output.
-	st.rangeCh <- keyRange{left: start}
+	st.rangeCh <- keyRange{left: start, right: y.SafeCopy(nil, st.KeyRange.End)}
 	close(st.rangeCh)
 }
 
@@ -160,6 +203,7 @@ func (st *Stream) produceKVs(ctx context.Context) error {
 		iterOpts := DefaultIteratorOptions
 		iterOpts.AllVersions = true
 		iterOpts.Prefix = st.Prefix
+		iterOpts.Bound = kr.right
 		iterOpts.PrefetchValues = false
 		itr := txn.NewIterator(iterOpts)
 		defer itr.Close()
@@ -179,7 +223,7 @@ func (st *Stream) produceKVs(ctx context.Context) error {
 			prevKey = append(prevKey[:0], item.Key()...)
 
 			// Check if we reached the end of the key range.
-			if len(kr.right) > 0 && bytes.Compare(item.Key(), kr.right) >= 0 {
+			if len(kr.right) > 0 && st.db.cmp.Compare(item.Key(), kr.right) >= 0 {
 				break
 			}
 			// Check if we should pick this key.
@@ -192,6 +236,11 @@ func (st *Stream) produceKVs(ctx context.Context) error {
 			if err != nil {
 				return err
 			}
+			if list != nil && st.Transform != nil {
+				if list.Kv, err = st.transform(list.Kv); err != nil {
+					return err
+				}
+			}
 			if list == nil || len(list.Kv) == 0 {
 				continue
 			}
@@ -240,6 +289,65 @@ func (st *Stream) produceKVs(ctx context.Context) error {
 	}
 }
 
+// produceRangeTombstones sends the range tombstones written by Txn.DeleteRange which overlap the
+// keys of the stream to kvChan, in a stream of their own. They are clipped to the keys of the
+// stream. Their key has an internal prefix, see ParseDeleteRange.
+func (st *Stream) produceRangeTombstones() error {
+	readTs := st.readTs
+	if readTs == 0 {
+		txn := st.db.NewTransaction(false)
+		readTs = txn.readTs
+		txn.Discard()
+	}
+	start, end := st.Prefix, prefixEnd(st.Prefix)
+	if st.db.cmp.Compare(st.KeyRange.Start, start) > 0 {
+		start = st.KeyRange.Start
+	}
+	if len(st.KeyRange.End) > 0 && (len(end) == 0 || st.db.cmp.Compare(st.KeyRange.End, end) < 0) {
+		end = st.KeyRange.End
+	}
+
+	list := &pb.KVList{}
+	for _, t := range st.db.rangeDels.overlapping(start, end, st.sinceTs, readTs) {
+		list.Kv = append(list.Kv, t.kv())
+	}
+	// Like the other streams, the keys are sent in sorted order.
+	sort.Slice(list.Kv, func(i, j int) bool {
+		return st.db.cmp.CompareKeys(y.KeyWithTs(list.Kv[i].Key, list.Kv[i].Version),
+			y.KeyWithTs(list.Kv[j].Key, list.Kv[j].Version)) < 0
+	})
+	if st.Transform != nil {
+		var err error
+		if list.Kv, err = st.transform(list.Kv); err != nil {
+			return err
+		}
+	}
+	if len(list.Kv) == 0 {
+		return nil
+	}
+	streamId := atomic.AddUint32(&st.nextStreamId, 1)
+	for _, kv := range list.Kv {
+		kv.StreamId = streamId
+	}
+	st.kvChan <- list // kvChan is buffered, and nothing was sent to it yet.
+	return nil
+}
+
+// transform calls Transform on kvs, and returns the KVs to send.
+func (st *Stream) transform(kvs []*pb.KV) ([]*pb.KV, error) {
+	out := kvs[:0]
+	for _, kv := range kvs {
+		kv, err := st.Transform(kv)
+		if err != nil {
+			return nil, err
+		}
+		if kv != nil {
+			out = append(out, kv)
+		}
+	}
+	return out, nil
+}
+
 func (st *Stream) streamKVs(ctx context.Context) error {
 	var count int
 	var bytesSent uint64
@@ -268,7 +376,7 @@ func (st *Stream) streamKVs(ctx context.Context) error {
 		if err := st.Send(batch); err != nil {
 			return err
 		}
-		st.db.opt.Infof("%s Created batch of size: %s in %s.\n",
+		st.db.opt.logger(LogComponentStream).Infof("%s Created batch of size: %s in %s.\n",
 			st.LogPrefix, humanize.Bytes(sz), time.Since(t))
 		return nil
 	}
@@ -287,7 +395,8 @@ outer:
 				continue
 			}
 			speed := bytesSent / durSec
-			st.db.opt.Infof("%s Time elapsed: %s, bytes sent: %s, speed: %s/sec\n", st.LogPrefix,
+			st.db.opt.logger(LogComponentStream).Infof(
+				"%s Time elapsed: %s, bytes sent: %s, speed: %s/sec\n", st.LogPrefix,
 				y.FixedDuration(dur), humanize.Bytes(bytesSent), humanize.Bytes(speed))
 
 		case kvs, ok := <-st.kvChan:
@@ -302,7 +411,7 @@ outer:
 		}
 	}
 
-	st.db.opt.Infof("%s Sent %d keys\n", st.LogPrefix, count)
+	st.db.opt.logger(LogComponentStream).Infof("%s Sent %d keys\n", st.LogPrefix, count)
 	return nil
 }
 
@@ -323,6 +432,9 @@ func (st *Stream) Orchestrate(ctx context.Context) error {
 	if st.KeyToList == nil {
 		st.KeyToList = st.ToList
 	}
+	if err := st.produceRangeTombstones(); err != nil {
+		return err
+	}
 
 	// Picks up ranges from Badger, and sends them to rangeCh.
 	go st.produceRanges(ctx)
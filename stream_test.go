@@ -17,7 +17,9 @@
 package badger
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -159,3 +161,118 @@ func TestStream(t *testing.T) {
 	}
 	require.NoError(t, db.Close())
 }
+
+func TestStreamKeyRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := DefaultOptions(dir).WithMaxTableSize(1 << 15).WithKeepL0InMemory(false).
+		WithCompactL0OnClose(false)
+	db, err := OpenManaged(opt)
+	require.NoError(t, err)
+
+	for _, prefix := range []string{"p0", "p1", "p2"} {
+		wb := db.NewWriteBatchAt(5)
+		for i := 1; i <= 1000; i++ {
+			require.NoError(t, wb.SetEntry(NewEntry(keyWithPrefix(prefix, i), value(i))))
+		}
+		require.NoError(t, wb.Flush())
+	}
+	// Reopen the DB so that the keys are split over tables.
+	require.NoError(t, db.Close())
+	db, err = OpenManaged(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	require.True(t, len(db.KeySplits(nil)) > 1)
+
+	orchestrate := func(prefix []byte, kr KeyRange) []*bpb.KV {
+		stream := db.NewStreamAt(math.MaxUint64)
+		stream.LogPrefix = "Testing"
+		stream.Prefix = prefix
+		stream.KeyRange = kr
+		c := &collector{}
+		stream.Send = c.Send
+		require.NoError(t, stream.Orchestrate(ctxb))
+		return c.kv
+	}
+	count := func(prefix []byte, kr KeyRange) int {
+		var n int
+		for _, p := range []string{"p0", "p1", "p2"} {
+			for i := 1; i <= 1000; i++ {
+				key := keyWithPrefix(p, i)
+				if bytes.HasPrefix(key, prefix) && kr.contains(nil, key) {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	kr := KeyRange{Start: keyWithPrefix("p0", 500), End: keyWithPrefix("p2", 200)}
+	kvs := orchestrate(nil, kr)
+	require.Equal(t, count(nil, kr), len(kvs))
+	require.True(t, len(kvs) > 1000)
+	for _, kv := range kvs {
+		require.True(t, kr.contains(nil, kv.Key), "%s is out of range", kv.Key)
+		_, ki := keyToInt(kv.Key)
+		require.Equal(t, value(ki), kv.Value)
+	}
+
+	// Combined with Prefix, only the keys within both are streamed.
+	kvs = orchestrate([]byte("p2"), kr)
+	require.Equal(t, count([]byte("p2"), kr), len(kvs))
+	for _, kv := range kvs {
+		require.True(t, bytes.HasPrefix(kv.Key, []byte("p2")))
+	}
+	kr = KeyRange{Start: keyWithPrefix("p1", 250)}
+	kvs = orchestrate([]byte("p1"), kr)
+	require.Equal(t, count([]byte("p1"), kr), len(kvs))
+
+	// An empty range streams nothing.
+	kvs = orchestrate(nil, KeyRange{Start: keyWithPrefix("p1", 1), End: keyWithPrefix("p1", 1)})
+	require.Equal(t, 0, len(kvs))
+}
+
+func TestStreamTransform(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	db, err := OpenManaged(DefaultOptions(dir))
+	require.NoError(t, err)
+	defer db.Close()
+
+	wb := db.NewWriteBatchAt(5)
+	for i := 1; i <= 1000; i++ {
+		require.NoError(t, wb.SetEntry(NewEntry(keyWithPrefix("p", i), value(i))))
+	}
+	require.NoError(t, wb.Flush())
+
+	stream := db.NewStreamAt(math.MaxUint64)
+	stream.LogPrefix = "Testing"
+	// Drop the odd keys, and redact the values of the others.
+	stream.Transform = func(kv *bpb.KV) (*bpb.KV, error) {
+		if _, k := keyToInt(kv.Key); k%2 == 1 {
+			return nil, nil
+		}
+		kv.Value = []byte("redacted")
+		return kv, nil
+	}
+	c := &collector{}
+	stream.Send = c.Send
+	require.NoError(t, stream.Orchestrate(ctxb))
+	require.Equal(t, 500, len(c.kv))
+	for _, kv := range c.kv {
+		_, k := keyToInt(kv.Key)
+		require.Equal(t, 0, k%2)
+		require.Equal(t, []byte("redacted"), kv.Value)
+	}
+
+	// An error from Transform stops the stream.
+	errRedact := errors.New("cannot redact")
+	stream = db.NewStreamAt(math.MaxUint64)
+	stream.Transform = func(kv *bpb.KV) (*bpb.KV, error) { return nil, errRedact }
+	stream.Send = (&collector{}).Send
+	require.Equal(t, errRedact, stream.Orchestrate(ctxb))
+}
@@ -17,6 +17,7 @@
 package badger
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"sync"
@@ -30,6 +31,9 @@ import (
 
 const headStreamId uint32 = math.MaxUint32
 
+// streamCheckpointKey stores the progress of a StreamWriter, as of its last checkpoint.
+var streamCheckpointKey = []byte("!badger!streamcheckpoint")
+
 // StreamWriter is used to write data coming from multiple streams. The streams must not have any
 // overlapping key ranges. Within each stream, the keys must be sorted. Badger Stream framework is
 // capable of generating such an output. So, this StreamWriter can be used at the other end to build
@@ -49,6 +53,43 @@ type StreamWriter struct {
 	maxVersion uint64
 	writers    map[uint32]*sortedWriter
 	maxHead    valuePointer
+
+	changesLock sync.Mutex
+	// Tables which are written but not yet added to the MANIFEST. They are added all at once, by
+	// Checkpoint and Flush, so that the MANIFEST only holds the tables of complete checkpoints.
+	changes []*pb.ManifestChange
+
+	progress      map[uint32]*StreamProgress // Progress of the streams, as of the last checkpoint.
+	resumed       map[uint32]StreamProgress  // Progress the writes were resumed from. Read-only.
+	checkpointSeq uint64
+	headTable     *table.Table // Table holding the head written by the last checkpoint.
+}
+
+// StreamCheckpoint holds the progress of the streams written by a StreamWriter.
+type StreamCheckpoint struct {
+	// Streams holds the progress of each stream, by stream id.
+	Streams map[uint32]StreamProgress
+}
+
+// StreamProgress is the progress of a single stream.
+type StreamProgress struct {
+	// Key and Version of the last entry of the stream written to the DB.
+	Key     []byte
+	Version uint64
+	// Done is true if the stream has been marked as done.
+	Done bool
+}
+
+func (p StreamProgress) internalKey() []byte {
+	return y.KeyWithTs(p.Key, p.Version)
+}
+
+// streamCheckpoint is the value of streamCheckpointKey.
+type streamCheckpoint struct {
+	StreamCheckpoint
+	Seq        uint64
+	MaxVersion uint64
+	Head       valuePointer
 }
 
 // NewStreamWriter creates a StreamWriter. Right after creating StreamWriter, Prepare must be
@@ -62,6 +103,7 @@ func (db *DB) NewStreamWriter() *StreamWriter {
 		// concurrent streams being processed.
 		throttle: y.NewThrottle(16),
 		writers:  make(map[uint32]*sortedWriter),
+		progress: make(map[uint32]*StreamProgress),
 	}
 }
 
@@ -78,6 +120,48 @@ func (sw *StreamWriter) Prepare() error {
 	return err
 }
 
+// Resume can be called instead of Prepare, to continue writing to a DB after a crash or restart
+// which interrupted a StreamWriter. Unlike Prepare, it keeps the data written up to the last call
+// to Checkpoint, and drops what was written after it. It returns the progress of the streams as of
+// that checkpoint, so that each stream can be restarted from its last written key. Entries which
+// are sent again are skipped, as long as they are sent on the same stream as before, with the same
+// versions. Writes to a stream which was done are skipped as well.
+//
+// Resume returns ErrNoCheckpoint if the DB holds no checkpoint, in which case Prepare must be
+// called instead.
+func (sw *StreamWriter) Resume() (*StreamCheckpoint, error) {
+	sw.writeLock.Lock()
+	defer sw.writeLock.Unlock()
+
+	vs, err := sw.db.get(y.KeyWithTs(streamCheckpointKey, math.MaxUint64))
+	if err != nil {
+		return nil, err
+	}
+	if len(vs.Value) == 0 || isDeletedOrExpired(vs.Meta, vs.ExpiresAt, sw.db.opt.now()) {
+		return nil, ErrNoCheckpoint
+	}
+	var cp streamCheckpoint
+	if err := json.Unmarshal(vs.Value, &cp); err != nil {
+		return nil, errors.Wrap(err, "while decoding stream checkpoint")
+	}
+
+	f := sw.db.prepareToDrop()
+	sw.db.stopCompactions()
+	sw.done = func() {
+		sw.db.startCompactions()
+		f()
+	}
+	sw.checkpointSeq = cp.Seq
+	sw.maxVersion = cp.MaxVersion
+	sw.maxHead = cp.Head
+	sw.resumed = cp.Streams
+	for id, p := range cp.Streams {
+		p := p
+		sw.progress[id] = &p
+	}
+	return &cp.StreamCheckpoint, nil
+}
+
 // Write writes KVList to DB. Each KV within the list contains the stream id which StreamWriter
 // would use to demux the writes. Write is thread safe and can be called concurrently by multiple
 // goroutines.
@@ -92,6 +176,13 @@ func (sw *StreamWriter) Write(kvs *pb.KVList) error {
 	closedStreams := make(map[uint32]struct{})
 	streamReqs := make(map[uint32]*request)
 	for _, kv := range kvs.Kv {
+		if p, ok := sw.resumed[kv.StreamId]; ok {
+			// Skip what has been written before the checkpoint the writes were resumed from.
+			if p.Done || (!kv.StreamDone &&
+				sw.db.cmp.CompareKeys(y.KeyWithTs(kv.Key, kv.Version), p.internalKey()) <= 0) {
+				continue
+			}
+		}
 		if kv.StreamDone {
 			closedStreams[kv.StreamId] = struct{}{}
 			continue
@@ -167,6 +258,11 @@ func (sw *StreamWriter) Write(kvs *pb.KVList) error {
 	for streamId := range closedStreams {
 		writer, ok := sw.writers[streamId]
 		if !ok {
+			if p, ok := sw.progress[streamId]; ok {
+				// Nothing has been written to the stream since the writes were resumed.
+				p.Done = true
+				continue
+			}
 			sw.db.opt.Logger.Warningf("Trying to close stream: %d, but no sorted "+
 				"writer found for it", streamId)
 			continue
@@ -180,12 +276,138 @@ func (sw *StreamWriter) Write(kvs *pb.KVList) error {
 		if sw.maxHead.Less(writer.head) {
 			sw.maxHead = writer.head
 		}
+		sw.updateProgress(streamId, writer)
+		sw.progress[streamId].Done = true
 
 		sw.writers[streamId] = nil
 	}
 	return nil
 }
 
+// Checkpoint writes out all the entries received so far, and persists the progress of the streams
+// along with them, so that the writes can be continued by Resume if they get interrupted. It
+// returns the persisted progress. Checkpoint blocks concurrent calls to Write until it is done.
+//
+// All the versions of a key are always written together. So the last key of a stream is only
+// written out once a key following it is received, or once the stream is done.
+func (sw *StreamWriter) Checkpoint() (*StreamCheckpoint, error) {
+	sw.writeLock.Lock()
+	defer sw.writeLock.Unlock()
+
+	for streamID, writer := range sw.writers {
+		if writer == nil {
+			continue
+		}
+		writer.closer.SignalAndWait()
+		if !writer.builder.Empty() {
+			if err := writer.send(false); err != nil {
+				return nil, err
+			}
+		}
+		if sw.maxHead.Less(writer.head) {
+			sw.maxHead = writer.head
+		}
+		sw.updateProgress(streamID, writer)
+	}
+	// Wait for all the tables to be written.
+	if err := sw.throttle.Finish(); err != nil {
+		return nil, err
+	}
+	sw.throttle = y.NewThrottle(16)
+
+	sw.checkpointSeq++
+	cp := streamCheckpoint{
+		StreamCheckpoint: StreamCheckpoint{Streams: make(map[uint32]StreamProgress)},
+		Seq:              sw.checkpointSeq,
+		MaxVersion:       sw.maxVersion,
+		Head:             sw.maxHead,
+	}
+	for id, p := range sw.progress {
+		cp.Streams[id] = *p
+	}
+	data, err := json.Marshal(&cp)
+	if err != nil {
+		return nil, err
+	}
+	if err := sw.writeHead(data); err != nil {
+		return nil, err
+	}
+
+	for _, writer := range sw.writers {
+		if writer == nil {
+			continue
+		}
+		writer.throttle = sw.throttle
+		writer.reqCh = make(chan *request, 3)
+		writer.closer = y.NewCloser(1)
+		go writer.handleRequests()
+	}
+	return &cp.StreamCheckpoint, nil
+}
+
+// updateProgress records the last key written out by writer to the given stream.
+func (sw *StreamWriter) updateProgress(streamID uint32, writer *sortedWriter) {
+	if len(writer.lastAdded) == 0 {
+		if _, ok := sw.progress[streamID]; !ok {
+			sw.progress[streamID] = &StreamProgress{}
+		}
+		return
+	}
+	sw.progress[streamID] = &StreamProgress{
+		Key:     y.SafeCopy(nil, y.ParseKey(writer.lastAdded)),
+		Version: y.ParseTs(writer.lastAdded),
+	}
+}
+
+// writeHead writes the value log head into a new table at level 0, along with the given
+// checkpoint. If checkpoint is nil, any previous checkpoint is deleted instead. The new table and
+// all the tables written before it are then added to the MANIFEST at once.
+func (sw *StreamWriter) writeHead(checkpoint []byte) error {
+	dk, err := sw.db.registry.latestDataKey()
+	if err != nil {
+		return err
+	}
+	bopts := buildTableOptions(sw.db)
+	bopts.DataKey = dk
+	w := &sortedWriter{
+		db:       sw.db,
+		sw:       sw,
+		streamID: headStreamId,
+		builder:  table.NewTableBuilder(bopts),
+	}
+	w.builder.Add(y.KeyWithTs(head, sw.maxVersion), y.ValueStruct{Value: sw.maxHead.Encode()}, 0)
+	switch {
+	case checkpoint != nil:
+		w.builder.Add(y.KeyWithTs(streamCheckpointKey, sw.checkpointSeq),
+			y.ValueStruct{Value: checkpoint}, 0)
+	case sw.checkpointSeq > 0:
+		w.builder.Add(y.KeyWithTs(streamCheckpointKey, sw.checkpointSeq+1),
+			y.ValueStruct{Meta: bitDelete}, 0)
+	}
+	tbl, err := w.createTable(w.builder)
+	if err != nil {
+		return errors.Wrap(err, "failed to write head")
+	}
+
+	sw.changesLock.Lock()
+	changes := sw.changes
+	sw.changes = nil
+	sw.changesLock.Unlock()
+	prevHead := sw.headTable
+	if prevHead != nil {
+		// The new head replaces the head of the previous checkpoint.
+		changes = append(changes, newDeleteChange(prevHead.ID()))
+	}
+	if err := sw.db.manifest.addChanges(changes); err != nil {
+		return err
+	}
+	sw.headTable = tbl
+	if prevHead != nil {
+		return sw.db.lc.levels[0].deleteTables([]*table.Table{prevHead})
+	}
+	return nil
+}
+
 // Flush is called once we are done writing all the entries. It syncs DB directories. It also
 // updates Oracle with maxVersion found in all entries (if DB is not managed).
 func (sw *StreamWriter) Flush() error {
@@ -212,18 +434,12 @@ func (sw *StreamWriter) Flush() error {
 		}
 	}
 
-	// Encode and write the value log head into a new table.
-	data := sw.maxHead.Encode()
-	headWriter, err := sw.newWriter(headStreamId)
-	if err != nil {
-		return errors.Wrap(err, "failed to create head writer")
-	}
-	if err := headWriter.Add(
-		y.KeyWithTs(head, sw.maxVersion),
-		y.ValueStruct{Value: data}); err != nil {
+	// Wait for all files to be written.
+	if err := sw.throttle.Finish(); err != nil {
 		return err
 	}
-	if err := headWriter.Done(); err != nil {
+	// Write the value log head into a new table, and add all the tables to the MANIFEST.
+	if err := sw.writeHead(nil); err != nil {
 		return err
 	}
 
@@ -238,13 +454,8 @@ func (sw *StreamWriter) Flush() error {
 		sw.db.orc.incrementNextTs()
 	}
 
-	// Wait for all files to be written.
-	if err := sw.throttle.Finish(); err != nil {
-		return err
-	}
-
-	// Sort tables at the end.
-	for _, l := range sw.db.lc.levels {
+	// Sort tables at the end. Tables at level 0 stay in the order they were added.
+	for _, l := range sw.db.lc.levels[1:] {
 		l.sortTables()
 	}
 
@@ -257,18 +468,26 @@ func (sw *StreamWriter) Flush() error {
 	if err := sw.db.syncDir(sw.db.opt.Dir); err != nil {
 		return err
 	}
+	// Apply the range tombstones sent by the streams, see Stream.
+	sw.db.rangeDels.reset()
+	if err := sw.db.loadRangeTombstones(); err != nil {
+		return err
+	}
 	return sw.db.lc.validate()
 }
 
 type sortedWriter struct {
 	db       *DB
+	sw       *StreamWriter
 	throttle *y.Throttle
 
-	builder  *table.Builder
-	lastKey  []byte
-	streamID uint32
-	reqCh    chan *request
-	head     valuePointer
+	builder   *table.Builder
+	lastKey   []byte
+	lastAdded []byte         // Last key added to builder.
+	pending   []pendingEntry // Versions of lastKey, not yet added to builder.
+	streamID  uint32
+	reqCh     chan *request
+	head      valuePointer
 	// Have separate closer for each writer, as it can be closed at any time.
 	closer *y.Closer
 }
@@ -279,10 +498,11 @@ func (sw *StreamWriter) newWriter(streamID uint32) (*sortedWriter, error) {
 		return nil, err
 	}
 
-	bopts := buildTableOptions(sw.db.opt)
+	bopts := buildTableOptions(sw.db)
 	bopts.DataKey = dk
 	w := &sortedWriter{
 		db:       sw.db,
+		sw:       sw,
 		streamID: streamID,
 		throttle: sw.throttle,
 		builder:  table.NewTableBuilder(bopts),
@@ -347,26 +567,50 @@ func (w *sortedWriter) handleRequests() {
 	}
 }
 
+type pendingEntry struct {
+	key []byte
+	vs  y.ValueStruct
+}
+
 // Add adds key and vs to sortedWriter.
 func (w *sortedWriter) Add(key []byte, vs y.ValueStruct) error {
-	if len(w.lastKey) > 0 && y.CompareKeys(key, w.lastKey) <= 0 {
+	if len(w.lastKey) > 0 && w.db.cmp.CompareKeys(key, w.lastKey) <= 0 {
 		return ErrUnsortedKey
 	}
 
-	sameKey := y.SameKey(key, w.lastKey)
-	// Same keys should go into the same SSTable.
-	if !sameKey && w.builder.ReachedCapacity(w.db.opt.MaxTableSize) {
-		if err := w.send(false); err != nil {
+	if !y.SameKey(key, w.lastKey) {
+		if err := w.addPending(); err != nil {
 			return err
 		}
 	}
 
 	w.lastKey = y.SafeCopy(w.lastKey, key)
-	var vp valuePointer
-	if vs.Meta&bitValuePointer > 0 {
-		vp.Decode(vs.Value)
+	vs.Value = y.SafeCopy(nil, vs.Value)
+	w.pending = append(w.pending, pendingEntry{key: y.SafeCopy(nil, key), vs: vs})
+	return nil
+}
+
+// addPending adds the pending versions of a key to the builder. They are held back until the next
+// key shows up, so that a checkpoint never splits the versions of a key.
+func (w *sortedWriter) addPending() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	// Same keys should go into the same SSTable.
+	if w.builder.ReachedCapacity(w.db.opt.MaxTableSize) {
+		if err := w.send(false); err != nil {
+			return err
+		}
+	}
+	for _, p := range w.pending {
+		var vp valuePointer
+		if p.vs.Meta&bitValuePointer > 0 {
+			vp.Decode(p.vs.Value)
+		}
+		w.builder.Add(p.key, p.vs, vp.Len)
 	}
-	w.builder.Add(key, vs, vp.Len)
+	w.lastAdded = w.pending[len(w.pending)-1].key
+	w.pending = w.pending[:0]
 	return nil
 }
 
@@ -375,7 +619,7 @@ func (w *sortedWriter) send(done bool) error {
 		return err
 	}
 	go func(builder *table.Builder) {
-		err := w.createTable(builder)
+		_, err := w.createTable(builder)
 		w.throttle.Done(err)
 	}(w.builder)
 	// If done is true, this indicates we can close the writer.
@@ -389,7 +633,7 @@ func (w *sortedWriter) send(done bool) error {
 	if err != nil {
 		return y.Wrapf(err, "Error while retriving datakey in sortedWriter.send")
 	}
-	bopts := buildTableOptions(w.db.opt)
+	bopts := buildTableOptions(w.db)
 	bopts.DataKey = dk
 	w.builder = table.NewTableBuilder(bopts)
 	return nil
@@ -398,6 +642,9 @@ func (w *sortedWriter) send(done bool) error {
 // Done is called once we are done writing all keys and valueStructs
 // to sortedWriter. It completes writing current SST to disk.
 func (w *sortedWriter) Done() error {
+	if err := w.addPending(); err != nil {
+		return err
+	}
 	if w.builder.Empty() {
 		// Assign builder as nil, so that underlying memory can be garbage collected.
 		w.builder = nil
@@ -407,31 +654,32 @@ func (w *sortedWriter) Done() error {
 	return w.send(true)
 }
 
-func (w *sortedWriter) createTable(builder *table.Builder) error {
+// createTable writes the table built by builder, and adds it to its level. The table is added to
+// the MANIFEST by the next call to StreamWriter.writeHead.
+func (w *sortedWriter) createTable(builder *table.Builder) (*table.Table, error) {
 	data := builder.Finish()
 	if len(data) == 0 {
-		return nil
+		return nil, nil
 	}
 	fileID := w.db.lc.reserveFileID()
-	opts := buildTableOptions(w.db.opt)
+	opts := buildTableOptions(w.db)
 	opts.DataKey = builder.DataKey()
-	opts.Cache = w.db.blockCache
 	var tbl *table.Table
 	if w.db.opt.InMemory {
 		var err error
 		if tbl, err = table.OpenInMemoryTable(data, fileID, &opts); err != nil {
-			return err
+			return nil, err
 		}
 	} else {
 		fd, err := y.CreateSyncedFile(table.NewFilename(fileID, w.db.opt.Dir), true)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if _, err := fd.Write(data); err != nil {
-			return err
+			return nil, err
 		}
 		if tbl, err = table.OpenTable(fd, opts); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	lc := w.db.lc
@@ -458,7 +706,7 @@ func (w *sortedWriter) createTable(builder *table.Builder) error {
 		// other keys to avoid an overlap.
 		lhandler = lc.levels[0]
 	}
-	// Now that table can be opened successfully, let's add this to the MANIFEST.
+	// Now that table can be opened successfully, let's queue it for the MANIFEST.
 	change := &pb.ManifestChange{
 		Id:          tbl.ID(),
 		KeyId:       tbl.KeyID(),
@@ -466,9 +714,9 @@ func (w *sortedWriter) createTable(builder *table.Builder) error {
 		Level:       uint32(lhandler.level),
 		Compression: uint32(tbl.CompressionType()),
 	}
-	if err := w.db.manifest.addChanges([]*pb.ManifestChange{change}); err != nil {
-		return err
-	}
+	w.sw.changesLock.Lock()
+	w.sw.changes = append(w.sw.changes, change)
+	w.sw.changesLock.Unlock()
 
 	// We are not calling lhandler.replaceTables() here, as it sorts tables on every addition.
 	// We can sort all tables only once during Flush() call.
@@ -476,7 +724,8 @@ func (w *sortedWriter) createTable(builder *table.Builder) error {
 
 	// Release the ref held by OpenTable.
 	_ = tbl.DecrRef()
-	w.db.opt.Infof("Table created: %d at level: %d for stream: %d. Size: %s\n",
+	w.db.opt.logger(LogComponentStream).Infof(
+		"Table created: %d at level: %d for stream: %d. Size: %s\n",
 		fileID, lhandler.level, w.streamID, humanize.Bytes(uint64(tbl.Size())))
-	return nil
+	return tbl, nil
 }
@@ -24,6 +24,7 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -526,3 +527,107 @@ func TestStreamWriterEncrypted(t *testing.T) {
 	require.NoError(t, db.Close())
 
 }
+
+func TestStreamWriterCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	crashDir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(crashDir)
+
+	// list returns the versions 2 and 1 of the keys [from, to) of the given stream.
+	list := func(stream uint32, from, to int) *pb.KVList {
+		l := &pb.KVList{}
+		for i := from; i < to; i++ {
+			for version := uint64(2); version > 0; version-- {
+				l.Kv = append(l.Kv, &pb.KV{
+					Key:      []byte(fmt.Sprintf("%c%04d", 'a'+stream, i)),
+					Value:    []byte(fmt.Sprintf("%d-%d", i, version)),
+					Version:  version,
+					StreamId: stream,
+				})
+			}
+		}
+		return l
+	}
+	done := func(streams ...uint32) *pb.KVList {
+		l := &pb.KVList{}
+		for _, stream := range streams {
+			l.Kv = append(l.Kv, &pb.KV{StreamId: stream, StreamDone: true})
+		}
+		return l
+	}
+
+	opt := getTestOptions(dir)
+	opt.Truncate = true
+	opt.MaxTableSize = 1 << 15
+	db, err := Open(opt)
+	require.NoError(t, err)
+	sw := db.NewStreamWriter()
+	require.NoError(t, sw.Prepare())
+	require.NoError(t, sw.Write(list(0, 0, 1000)))
+	require.NoError(t, sw.Write(done(0)))
+	require.NoError(t, sw.Write(list(1, 0, 500)))
+	cp, err := sw.Checkpoint()
+	require.NoError(t, err)
+	// The versions of the last key are only written once the next key shows up.
+	require.Equal(t, map[uint32]StreamProgress{
+		0: {Key: []byte("a0999"), Version: 1, Done: true},
+		1: {Key: []byte("b0498"), Version: 1},
+	}, cp.Streams)
+
+	// Crash after writing some more. These writes are lost.
+	require.NoError(t, sw.Write(list(1, 500, 800)))
+	require.NoError(t, sw.Write(list(2, 0, 100)))
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	for _, fi := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(crashDir, fi.Name()), data, 0666))
+	}
+	require.NoError(t, sw.Flush())
+	require.NoError(t, db.Close())
+
+	opt.Dir, opt.ValueDir = crashDir, crashDir
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	sw = db.NewStreamWriter()
+	resumed, err := sw.Resume()
+	require.NoError(t, err)
+	require.Equal(t, cp, resumed)
+
+	// Send the streams again from their start. What was checkpointed is skipped.
+	require.NoError(t, sw.Write(list(0, 0, 1000)))
+	require.NoError(t, sw.Write(done(0)))
+	require.NoError(t, sw.Write(list(1, 0, 1000)))
+	require.NoError(t, sw.Write(list(2, 0, 1000)))
+	require.NoError(t, sw.Write(done(1, 2)))
+	require.NoError(t, sw.Flush())
+
+	require.NoError(t, db.View(func(txn *Txn) error {
+		iopt := DefaultIteratorOptions
+		iopt.AllVersions = true
+		it := txn.NewIterator(iopt)
+		defer it.Close()
+		var i int
+		for it.Rewind(); it.Valid(); it.Next() {
+			stream, idx, version := i/2000, i%2000/2, uint64(2-i%2)
+			item := it.Item()
+			require.Equal(t, fmt.Sprintf("%c%04d", 'a'+stream, idx), string(item.Key()))
+			require.Equal(t, version, item.Version())
+			val, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, fmt.Sprintf("%d-%d", idx, version), string(val))
+			i++
+		}
+		require.Equal(t, 6000, i)
+		return nil
+	}))
+
+	// The checkpoint is gone once the writes are flushed.
+	_, err = db.NewStreamWriter().Resume()
+	require.Equal(t, ErrNoCheckpoint, err)
+}
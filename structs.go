@@ -135,28 +135,81 @@ func (h *header) DecodeFrom(reader *hashReader) (int, error) {
 	return reader.bytesRead, nil
 }
 
-// Entry provides Key, Value, UserMeta and ExpiresAt. This struct can be used by
+// maxMetadataSize is the maximum length of the metadata of an entry.
+const maxMetadataSize = 32
+
+// Entry provides Key, Value, UserMeta, Metadata and ExpiresAt. This struct can be used by
 // the user to set data.
 type Entry struct {
 	Key       []byte
 	Value     []byte
 	UserMeta  byte
+	Metadata  []byte // See WithMetadata.
 	ExpiresAt uint64 // time.Unix
 	meta      byte
 
 	// Fields maintained internally.
 	offset   uint32
 	skipVlog bool
-	hlen     int // Length of the header.
+	hlen     int           // Length of the header.
+	ttl      time.Duration // Set by WithTTL, for the expiry to be stamped with Options.Clock.
+	noTTL    bool          // Set for the entries which must not get Options.DefaultTTL.
+	cfKey    bool          // Set for the entries of a column family, whose key has cfPrefix.
 }
 
 func (e *Entry) estimateSize(threshold int) int {
-	if len(e.Value) < threshold {
-		return len(e.Key) + len(e.Value) + 2 // Meta, UserMeta
+	if sz := e.valueSize(); sz < threshold {
+		return len(e.Key) + sz + 2 // Meta, UserMeta
 	}
 	return len(e.Key) + 12 + 2 // 12 for ValuePointer, 2 for metas.
 }
 
+// valueSize returns the length of the value of e, as written by encodedValue.
+func (e *Entry) valueSize() int {
+	if len(e.Metadata) == 0 {
+		return len(e.Value)
+	}
+	return 1 + len(e.Metadata) + len(e.Value)
+}
+
+// encodedMeta returns the meta of e as written to the LSM tree and the value log.
+func (e *Entry) encodedMeta() byte {
+	if len(e.Metadata) == 0 {
+		return e.meta
+	}
+	return e.meta | bitMetadata
+}
+
+// encodedValue returns the value of e as written to the LSM tree and the value log. The entries
+// read back from the value log hold their value in this form, with bitMetadata set in their meta.
+func (e *Entry) encodedValue() []byte {
+	if len(e.Metadata) == 0 {
+		return e.Value
+	}
+	return encodeMetadata(e.Metadata, e.Value)
+}
+
+// encodeMetadata returns the value val preceded by the metadata md, and the length of md.
+func encodeMetadata(md, val []byte) []byte {
+	b := make([]byte, 1+len(md)+len(val))
+	b[0] = byte(len(md))
+	n := 1 + copy(b[1:], md)
+	copy(b[n:], val)
+	return b
+}
+
+// splitMetadata splits a value encoded by encodeMetadata into the metadata and the value.
+func splitMetadata(b []byte) (md, val []byte) {
+	if len(b) == 0 {
+		return nil, b
+	}
+	n := 1 + int(b[0])
+	if n > len(b) {
+		n = len(b)
+	}
+	return b[1:n], b[n:]
+}
+
 func (e Entry) print(prefix string) {
 	fmt.Printf("%s Key: %s Meta: %d UserMeta: %d Offset: %d len(val)=%d",
 		prefix, e.Key, e.meta, e.UserMeta, e.offset, len(e.Value))
@@ -194,9 +247,21 @@ func (e *Entry) WithDiscard() *Entry {
 }
 
 // WithTTL adds time to live duration to Entry e. Entry stored with a TTL would automatically expire
-// after the time has elapsed, and will be eligible for garbage collection.
+// after the time has elapsed, and will be eligible for garbage collection. The expiry is stamped
+// again with Options.Clock once the entry is set in a transaction.
 func (e *Entry) WithTTL(dur time.Duration) *Entry {
 	e.ExpiresAt = uint64(time.Now().Add(dur).Unix())
+	e.ttl = dur
+	return e
+}
+
+// WithMetadata attaches the blob md, of up to 32 bytes, to Entry e. It is returned by
+// Item.Metadata. Unlike the byte set by WithMeta, which is always stored in the LSM tree, the
+// metadata is stored along with the value: in the LSM tree if both are smaller than
+// Options.ValueThreshold, in the value log otherwise. Entries without metadata don't take any
+// extra space.
+func (e *Entry) WithMetadata(md []byte) *Entry {
+	e.Metadata = md
 	return e
 }
 
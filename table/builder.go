@@ -72,6 +72,10 @@ type Builder struct {
 	tableIndex   *pb.TableIndex
 	keyHashes    []uint64 // Used for building the bloomfilter.
 	opt          *Options
+
+	samples    [][]byte // Values used for training the ZSTD dictionary.
+	sampleSize int
+	zstdDict   *y.ZSTDCDict // Compresses the blocks against the trained dictionary, if any.
 }
 
 // NewTableBuilder makes a new TableBuilder.
@@ -103,6 +107,11 @@ func (b *Builder) keyDiff(newKey []byte) []byte {
 
 func (b *Builder) addHelper(key []byte, v y.ValueStruct, vpLen uint64) {
 	b.keyHashes = append(b.keyHashes, farm.Fingerprint64(y.ParseKey(key)))
+	// ZSTD recommends training a dictionary from about a hundred times its size of samples.
+	if b.useDictionary() && len(v.Value) > 0 && b.sampleSize < 100*b.opt.ZSTDDictionarySize {
+		b.samples = append(b.samples, y.Copy(v.Value))
+		b.sampleSize += len(v.Value)
+	}
 
 	// diffKey stores the difference of key with baseKey.
 	var diffKey []byte
@@ -133,6 +142,7 @@ func (b *Builder) addHelper(key []byte, v y.ValueStruct, vpLen uint64) {
 	sstSz := uint64(uint32(headerSize) + uint32(len(diffKey)) + v.EncodedSize())
 	// Total estimated size = size on SST + size on vlog (length of value pointer).
 	b.tableIndex.EstimatedSize += (sstSz + vpLen)
+	b.tableIndex.KeyCount++
 }
 
 /*
@@ -154,8 +164,9 @@ func (b *Builder) finishBlock() {
 	blockBuf := b.buf.Bytes()[b.baseOffset:] // Store checksum for current block.
 	b.writeChecksum(blockBuf)
 
-	// Compress the block.
-	if b.opt.Compression != options.None {
+	// Compress the block. If a dictionary is used, the blocks are compressed by Finish once the
+	// dictionary is trained.
+	if b.opt.Compression != options.None && !b.useDictionary() {
 		var err error
 		// TODO: Find a way to reuse buffers. Current implementation creates a
 		// new buffer for each compressData call.
@@ -166,7 +177,7 @@ func (b *Builder) finishBlock() {
 		// Write compressed data.
 		b.buf.Write(blockBuf)
 	}
-	if b.shouldEncrypt() {
+	if b.shouldEncrypt() && !b.useDictionary() {
 		block := b.buf.Bytes()[b.baseOffset:]
 		eBlock, err := b.encrypt(block)
 		y.Check(y.Wrapf(err, "Error while encrypting block in table builder."))
@@ -231,6 +242,8 @@ func (b *Builder) Add(key []byte, value y.ValueStruct, valueLen uint32) {
 // at the end. The diff can vary.
 
 // ReachedCapacity returns true if we... roughly (?) reached capacity?
+// If a ZSTD dictionary is used, the blocks are only compressed by Finish, so the capacity is that
+// of the uncompressed blocks.
 func (b *Builder) ReachedCapacity(cap int64) bool {
 	blocksSize := b.buf.Len() + // length of current buffer
 		len(b.entryOffsets)*4 + // all entry offsets size
@@ -263,8 +276,12 @@ func (b *Builder) Finish() []byte {
 	}
 	// Add bloom filter to the index.
 	b.tableIndex.BloomFilter = bf.JSONMarshal()
+	b.tableIndex.BloomFalsePositive = b.opt.BloomFalsePositive
 
 	b.finishBlock() // This will never start a new block.
+	if b.useDictionary() {
+		b.compressBlocks()
+	}
 
 	index, err := proto.Marshal(b.tableIndex)
 	y.Check(err)
@@ -288,17 +305,15 @@ func (b *Builder) Finish() []byte {
 
 func (b *Builder) writeChecksum(data []byte) {
 	// Build checksum for the index.
+	// CRC32 is the default option because it performed better compared to xxHash64.
+	// See the BenchmarkChecksum in table_test.go file
+	// Size     =>   1024 B        2048 B
+	// CRC32    => 63.7 ns/op     112 ns/op
+	// xxHash64 => 87.5 ns/op     158 ns/op
+	algo := pb.Checksum_Algorithm(b.opt.ChecksumAlgorithm)
 	checksum := pb.Checksum{
-		// TODO: The checksum type should be configurable from the
-		// options.
-		// We chose to use CRC32 as the default option because
-		// it performed better compared to xxHash64.
-		// See the BenchmarkChecksum in table_test.go file
-		// Size     =>   1024 B        2048 B
-		// CRC32    => 63.7 ns/op     112 ns/op
-		// xxHash64 => 87.5 ns/op     158 ns/op
-		Sum:  y.CalculateChecksum(data, pb.Checksum_CRC32C),
-		Algo: pb.Checksum_CRC32C,
+		Sum:  y.CalculateChecksum(data, algo),
+		Algo: algo,
 	}
 
 	// Write checksum to the file.
@@ -321,8 +336,13 @@ func (b *Builder) DataKey() *pb.DataKey {
 // encrypt will encrypt the given data and appends IV to the end of the encrypted data.
 // This should be only called only after checking shouldEncrypt method.
 func (b *Builder) encrypt(data []byte) ([]byte, error) {
-	iv, err := y.GenerateIV()
-	if err != nil {
+	var iv []byte
+	var err error
+	if b.opt.Deterministic {
+		if iv, err = y.DeterministicIV(b.DataKey().Data, data); err != nil {
+			return data, y.Wrapf(err, "Error while deriving IV in Builder.encrypt")
+		}
+	} else if iv, err = y.GenerateIV(); err != nil {
 		return data, y.Wrapf(err, "Error while generating IV in Builder.encrypt")
 	}
 	data, err = y.XORBlock(data, b.DataKey().Data, iv)
@@ -333,6 +353,40 @@ func (b *Builder) encrypt(data []byte) ([]byte, error) {
 	return data, nil
 }
 
+// useDictionary tells us whether the blocks are compressed against a ZSTD dictionary trained from
+// the values of the table.
+func (b *Builder) useDictionary() bool {
+	return b.opt.Compression == options.ZSTD && b.opt.ZSTDDictionarySize > 0
+}
+
+// compressBlocks trains the ZSTD dictionary of the table, and compresses and encrypts the blocks.
+// The blocks are compressed without a dictionary if there are too few samples to train one.
+func (b *Builder) compressBlocks() {
+	dict, err := y.ZSTDTrainDictionary(b.samples, b.opt.ZSTDDictionarySize)
+	if err == nil {
+		b.zstdDict, err = y.NewZSTDCDict(dict, b.opt.ZSTDCompressionLevel)
+	}
+	if err == nil {
+		b.tableIndex.ZstdDictionary = dict
+	}
+	b.samples = nil
+
+	data := b.buf.Bytes()
+	buf := newBuffer(b.buf.Len())
+	for _, bo := range b.tableIndex.Offsets {
+		block, err := b.compressData(data[bo.Offset : bo.Offset+bo.Len])
+		y.Check(err)
+		if b.shouldEncrypt() {
+			block, err = b.encrypt(block)
+			y.Check(y.Wrapf(err, "Error while encrypting block in table builder."))
+		}
+		bo.Offset = uint32(buf.Len())
+		bo.Len = uint32(len(block))
+		buf.Write(block)
+	}
+	b.buf = buf
+}
+
 // shouldEncrypt tells us whether to encrypt the data or not.
 // We encrypt only if the data key exist. Otherwise, not.
 func (b *Builder) shouldEncrypt() bool {
@@ -347,6 +401,9 @@ func (b *Builder) compressData(data []byte) ([]byte, error) {
 	case options.Snappy:
 		return snappy.Encode(nil, data), nil
 	case options.ZSTD:
+		if b.zstdDict != nil {
+			return b.zstdDict.Compress(nil, data)
+		}
 		return y.ZSTDCompress(nil, data, b.opt.ZSTDCompressionLevel)
 	}
 	return nil, errors.New("Unsupported compression type")
@@ -38,7 +38,7 @@ func TestTableIndex(t *testing.T) {
 		f := buildTestTable(t, keyPrefix, 1, opts)
 		tbl, err := OpenTable(f, opts)
 		require.NoError(t, err)
-		require.Len(t, tbl.blockIndex, 1)
+		require.Len(t, tbl.fetchIndex().offsets, 1)
 	})
 
 	t.Run("multiple keys", func(t *testing.T) {
@@ -87,8 +87,8 @@ func TestTableIndex(t *testing.T) {
 			require.NoError(t, err, "unable to open table")
 
 			// Ensure index is built correctly
-			require.Equal(t, blockCount, len(tbl.blockIndex))
-			for i, ko := range tbl.blockIndex {
+			require.Equal(t, blockCount, len(tbl.fetchIndex().offsets))
+			for i, ko := range tbl.fetchIndex().offsets {
 				require.Equal(t, ko.Key, blockFirstKeys[i])
 			}
 			f.Close()
@@ -135,3 +135,227 @@ func BenchmarkBuilder(b *testing.B) {
 		_ = builder.Finish()
 	}
 }
+
+// BenchmarkCompression compares the compression algorithms for tables of 200 byte values. The
+// values are made of words, so that they compress like typical user data. It reports the size of
+// the table per entry, and the time to build the table or to read all of its blocks.
+func BenchmarkCompression(b *testing.B) {
+	const n = 20000
+	rng := rand.New(rand.NewSource(0))
+	words := []string{"badger", "table", "block", "value", "key", "level", "user", "id",
+		"timestamp", "status", "ok", "error", "name", "count", "2020", "true", "false"}
+	values := make([][]byte, n)
+	for i := range values {
+		var val []byte
+		for len(val) < 200 {
+			val = append(val, words[rng.Intn(len(words))]...)
+			val = append(val, ' ')
+		}
+		values[i] = val[:200]
+	}
+	build := func(opts Options) []byte {
+		builder := NewTableBuilder(opts)
+		for i, val := range values {
+			k := y.KeyWithTs([]byte(fmt.Sprintf("key%08d", i)), 1)
+			builder.Add(k, y.ValueStruct{Value: val}, 0)
+		}
+		return builder.Finish()
+	}
+
+	for _, c := range []struct {
+		name        string
+		compression options.CompressionType
+	}{
+		{"none", options.None},
+		{"snappy", options.Snappy},
+		{"zstd", options.ZSTD},
+	} {
+		opts := Options{
+			Compression:          c.compression,
+			ZSTDCompressionLevel: 1,
+			BlockSize:            4 * 1024,
+			BloomFalsePositive:   0.01,
+		}
+		b.Run(c.name+"/build", func(b *testing.B) {
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				data = build(opts)
+			}
+			b.ReportMetric(float64(len(data))/n, "bytes/entry")
+		})
+		b.Run(c.name+"/read", func(b *testing.B) {
+			tbl, err := OpenInMemoryTable(build(opts), 0, &opts)
+			require.NoError(b, err)
+			defer tbl.DecrRef()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				it := tbl.NewIterator(false)
+				for it.Rewind(); it.Valid(); it.Next() {
+				}
+				it.Close()
+			}
+		})
+	}
+}
+
+// jsonValue returns a small JSON document, similar to the documents of the other ids.
+func jsonValue(rng *rand.Rand, id int) []byte {
+	statuses := []string{"active", "inactive", "pending", "suspended"}
+	return []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user%d@example.com",`+
+		`"status":"%s","score":%d,"created_at":"2020-%02d-%02dT%02d:%02d:00Z"}`,
+		id, rng.Intn(1e6), rng.Intn(1e6), statuses[rng.Intn(len(statuses))], rng.Intn(1000),
+		1+rng.Intn(12), 1+rng.Intn(28), rng.Intn(24), rng.Intn(60)))
+}
+
+func TestZSTDDictionary(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	dataKey := make([]byte, 32)
+	_, err := rng.Read(dataKey)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		name    string
+		n       int
+		dataKey *pb.DataKey
+		hasDict bool
+	}{
+		{"dictionary", 5000, nil, true},
+		{"encrypted", 5000, &pb.DataKey{Data: dataKey}, true},
+		// Training needs more samples than a single value, so no dictionary is used.
+		{"single value", 1, nil, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			opts := Options{
+				Compression:          options.ZSTD,
+				ZSTDCompressionLevel: 3,
+				ZSTDDictionarySize:   4 << 10,
+				BlockSize:            4 * 1024,
+				BloomFalsePositive:   0.01,
+				DataKey:              c.dataKey,
+			}
+			values := make([][]byte, c.n)
+			builder := NewTableBuilder(opts)
+			for i := range values {
+				values[i] = jsonValue(rng, i)
+				k := y.KeyWithTs([]byte(fmt.Sprintf("key%08d", i)), 1)
+				builder.Add(k, y.ValueStruct{Value: values[i]}, 0)
+			}
+			filename := fmt.Sprintf("%s%c%d.sst", os.TempDir(), os.PathSeparator, rand.Int63())
+			f, err := y.OpenSyncedFile(filename, true)
+			require.NoError(t, err)
+			_, err = f.Write(builder.Finish())
+			require.NoError(t, err)
+			tbl, err := OpenTable(f, opts)
+			require.NoError(t, err)
+			defer tbl.DecrRef()
+			require.Equal(t, c.hasDict, len(tbl.zstdDict) > 0)
+			require.True(t, len(tbl.zstdDict) <= opts.ZSTDDictionarySize)
+
+			require.NoError(t, tbl.VerifyChecksum())
+			it := tbl.NewIterator(false)
+			defer it.Close()
+			var i int
+			for it.Rewind(); it.Valid(); it.Next() {
+				require.Equal(t, values[i], it.Value().Value)
+				i++
+			}
+			require.Equal(t, c.n, i)
+		})
+	}
+}
+
+// BenchmarkZSTDDictionary compares the compression ratio of small JSON documents, compressed
+// with ZSTD with and without a dictionary.
+func BenchmarkZSTDDictionary(b *testing.B) {
+	rng := rand.New(rand.NewSource(0))
+	const n = 100000
+	values := make([][]byte, n)
+	var size int
+	for i := range values {
+		values[i] = jsonValue(rng, i)
+		size += len(values[i])
+	}
+
+	for _, c := range []struct {
+		name     string
+		dictSize int
+	}{
+		{"no-dictionary", 0},
+		{"dictionary-4KB", 4 << 10},
+		{"dictionary-16KB", 16 << 10},
+	} {
+		opts := Options{
+			Compression:          options.ZSTD,
+			ZSTDCompressionLevel: 3,
+			ZSTDDictionarySize:   c.dictSize,
+			BlockSize:            4 * 1024,
+			BloomFalsePositive:   0.01,
+		}
+		b.Run(c.name, func(b *testing.B) {
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				builder := NewTableBuilder(opts)
+				for j, val := range values {
+					k := y.KeyWithTs([]byte(fmt.Sprintf("key%08d", j)), 1)
+					builder.Add(k, y.ValueStruct{Value: val}, 0)
+				}
+				data = builder.Finish()
+			}
+			b.ReportMetric(float64(size)/float64(len(data)), "ratio")
+		})
+	}
+}
+
+func TestDeterministicBuilder(t *testing.T) {
+	dataKey := make([]byte, 32)
+	_, err := rand.Read(dataKey)
+	require.NoError(t, err)
+
+	build := func(opts Options) []byte {
+		rng := rand.New(rand.NewSource(0))
+		builder := NewTableBuilder(opts)
+		for i := 0; i < 5000; i++ {
+			k := y.KeyWithTs([]byte(fmt.Sprintf("key%08d", i)), 1)
+			builder.Add(k, y.ValueStruct{Value: jsonValue(rng, i)}, 0)
+		}
+		return builder.Finish()
+	}
+	for _, c := range []struct {
+		name string
+		opts Options
+	}{
+		{"plain", Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01}},
+		{"zstd", Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01,
+			Compression: options.ZSTD, ZSTDCompressionLevel: 3, ZSTDDictionarySize: 4 << 10}},
+		{"encrypted", Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01,
+			DataKey: &pb.DataKey{Data: dataKey}, Deterministic: true}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			data := build(c.opts)
+			require.Equal(t, data, build(c.opts))
+
+			filename := fmt.Sprintf("%s%c%d.sst", os.TempDir(), os.PathSeparator, rand.Int63())
+			f, err := y.OpenSyncedFile(filename, true)
+			require.NoError(t, err)
+			_, err = f.Write(data)
+			require.NoError(t, err)
+			tbl, err := OpenTable(f, c.opts)
+			require.NoError(t, err)
+			defer tbl.DecrRef()
+			require.NoError(t, tbl.VerifyChecksum())
+			it := tbl.NewIterator(false)
+			defer it.Close()
+			var i int
+			for it.Rewind(); it.Valid(); it.Next() {
+				require.Equal(t, fmt.Sprintf("key%08d", i), string(y.ParseKey(it.Key())))
+				i++
+			}
+			require.Equal(t, 5000, i)
+		})
+	}
+
+	// The IVs are random unless Deterministic is set.
+	opts := Options{BlockSize: 4 * 1024, BloomFalsePositive: 0.01,
+		DataKey: &pb.DataKey{Data: dataKey}}
+	require.NotEqual(t, build(opts), build(opts))
+}
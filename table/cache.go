@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import (
+	"encoding/binary"
+
+	"github.com/dgraph-io/badger/v2/pb"
+	"github.com/dgraph-io/ristretto"
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// Cache is the interface of the caches holding the decoded blocks and indices of tables. An
+// implementation must be safe for concurrent use.
+//
+// Keys are opaque byte slices built by the table, which the cache must not modify or retain past
+// the call without copying. Two keys are equal only if their bytes are equal; blocks and indices
+// use keys of different lengths, so a single cache can hold both. Values are stored and returned
+// as they are, and must not be modified by the cache. The cost of a value is its approximate size
+// in bytes.
+type Cache interface {
+	// Get returns the value cached for key, and whether it was found.
+	Get(key []byte) (interface{}, bool)
+	// Set caches value for key with the given cost. It returns false if the value was not
+	// admitted, which is not an error: the table reads the value again on the next miss.
+	Set(key []byte, value interface{}, cost int64) bool
+	// Del removes the value cached for key, if any.
+	Del(key []byte)
+	// Close releases the resources held by the cache.
+	Close()
+}
+
+// CacheStats holds the statistics of a Cache. The counters are cumulative.
+type CacheStats struct {
+	Hits      uint64 // Number of lookups which found a value.
+	Misses    uint64 // Number of lookups which didn't find a value.
+	Evictions uint64 // Number of values evicted to make room for others.
+	Cost      int64  // Total cost of the values currently cached.
+	MaxCost   int64  // Maximum total cost of the values cached, i.e. the capacity of the cache.
+}
+
+// StatsCache is implemented by the caches which track their own statistics.
+type StatsCache interface {
+	Cache
+	// Stats returns a snapshot of the statistics of the cache. It must be cheap enough to be
+	// called frequently.
+	Stats() CacheStats
+}
+
+// RistrettoCache is a Cache backed by a ristretto cache.
+type RistrettoCache struct {
+	*ristretto.Cache
+	// MaxCost is the MaxCost the ristretto cache was configured with, as ristretto doesn't
+	// expose it.
+	MaxCost int64
+}
+
+// Get implements Cache.
+func (c *RistrettoCache) Get(key []byte) (interface{}, bool) { return c.Cache.Get(key) }
+
+// Set implements Cache.
+func (c *RistrettoCache) Set(key []byte, value interface{}, cost int64) bool {
+	return c.Cache.Set(key, value, cost)
+}
+
+// Del implements Cache.
+func (c *RistrettoCache) Del(key []byte) { c.Cache.Del(key) }
+
+// Stats implements StatsCache. Only MaxCost is known if the ristretto cache doesn't have metrics.
+func (c *RistrettoCache) Stats() CacheStats {
+	stats := CacheStats{MaxCost: c.MaxCost}
+	if m := c.Metrics; m != nil {
+		stats.Hits = m.Hits()
+		stats.Misses = m.Misses()
+		stats.Evictions = m.KeysEvicted()
+		stats.Cost = int64(m.CostAdded() - m.CostEvicted())
+	}
+	return stats
+}
+
+// tableIndex holds the parts of the table index needed to read the table. It is kept in the index
+// cache rather than in the table if Options.IndexCache is set.
+type tableIndex struct {
+	offsets []*pb.BlockOffset
+	bf      *z.Bloom
+	size    int64 // Size of the encoded index, used as the cost in the index cache.
+}
+
+// blockCacheKey returns the key of the block idx of the table in the block cache. It is made of
+// Options.CacheID, the table ID and idx.
+func (t *Table) blockCacheKey(idx int) []byte {
+	key := make([]byte, 20)
+	binary.BigEndian.PutUint64(key[0:8], t.opt.CacheID)
+	binary.BigEndian.PutUint64(key[8:16], t.id)
+	binary.BigEndian.PutUint32(key[16:20], uint32(idx))
+	return key
+}
+
+// indexCacheKey returns the key of the index of the table in the index cache. It is made of
+// Options.CacheID and the table ID.
+func (t *Table) indexCacheKey() []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[0:8], t.opt.CacheID)
+	binary.BigEndian.PutUint64(key[8:16], t.id)
+	return key
+}
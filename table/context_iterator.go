@@ -0,0 +1,128 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import (
+	"context"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// DefaultContextCheckInterval is the number of calls to Next between two checks
+// of the context, used if no interval is given to NewContextIterator.
+const DefaultContextCheckInterval = 128
+
+// contextIterator stops the iterator it wraps once its context is done. Besides y.Iterator, it
+// forwards y.ErrIterator, y.Peeker and SetBound to the wrapped iterator.
+type contextIterator struct {
+	y.Iterator
+	ctx      context.Context
+	interval int
+	count    int
+	err      error
+}
+
+// NewContextIterator returns an iterator which iterates over it until ctx is done.
+// Once ctx is done the iterator becomes invalid, and Err returns ctx.Err().
+// The context is checked by every Rewind and Seek, and every checkInterval calls
+// to Next, to keep the cost of the check low when iterating. If checkInterval is
+// not positive, DefaultContextCheckInterval is used.
+//
+// The returned iterator owns it, and closes it on Close.
+func NewContextIterator(ctx context.Context, it y.Iterator, checkInterval int) y.Iterator {
+	if checkInterval <= 0 {
+		checkInterval = DefaultContextCheckInterval
+	}
+	return &contextIterator{
+		Iterator: it,
+		ctx:      ctx,
+		interval: checkInterval,
+	}
+}
+
+// NewMergeIteratorCtx is like NewMergeIterator, but the returned iterator stops
+// once ctx is done. See NewContextIterator.
+func NewMergeIteratorCtx(ctx context.Context, iters []y.Iterator, reverse bool) y.Iterator {
+	if len(iters) == 0 {
+		return nil
+	}
+	return NewContextIterator(ctx, NewMergeIterator(iters, reverse), DefaultContextCheckInterval)
+}
+
+func (it *contextIterator) check() {
+	it.count = 0
+	if it.err == nil {
+		it.err = it.ctx.Err()
+	}
+}
+
+func (it *contextIterator) Next() {
+	it.Iterator.Next()
+	it.count++
+	if it.count >= it.interval {
+		it.check()
+	}
+}
+
+func (it *contextIterator) Rewind() {
+	it.Iterator.Rewind()
+	it.check()
+}
+
+func (it *contextIterator) Seek(key []byte) {
+	it.Iterator.Seek(key)
+	it.check()
+}
+
+func (it *contextIterator) SeekToFirst() {
+	it.Iterator.SeekToFirst()
+	it.check()
+}
+
+func (it *contextIterator) SeekToLast() {
+	it.Iterator.SeekToLast()
+	it.check()
+}
+
+// Peek implements y.Peeker, the wrapped iterator must implement it too. It returns false if the
+// next call to Next would notice that ctx is done.
+func (it *contextIterator) Peek() ([]byte, bool) {
+	p, ok := it.Iterator.(y.Peeker)
+	y.AssertTruef(ok, "contextIterator.Peek: %T does not implement y.Peeker", it.Iterator)
+	if !it.Valid() || (it.count+1 >= it.interval && it.ctx.Err() != nil) {
+		return nil, false
+	}
+	return p.Peek()
+}
+
+// SetBound passes the bound down to the wrapped iterator, if it supports it.
+func (it *contextIterator) SetBound(bound []byte) {
+	if b, ok := it.Iterator.(bounder); ok {
+		b.SetBound(bound)
+	}
+}
+
+func (it *contextIterator) Valid() bool {
+	return it.err == nil && it.Iterator.Valid()
+}
+
+func (it *contextIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return y.IteratorErr(it.Iterator)
+}
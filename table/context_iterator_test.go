@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextIterator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iters := benchmarkMergeIters(4, 100, false)
+	it := NewContextIterator(ctx, NewMergeIterator(iters, false), 10)
+
+	var count int
+	for it.Rewind(); it.Valid(); it.Next() {
+		count++
+		if count == 25 {
+			cancel()
+		}
+	}
+	// The cancellation is noticed at the next check, after 30 keys.
+	require.Equal(t, 30, count)
+	require.Equal(t, context.Canceled, y.IteratorErr(it))
+
+	// Rewind checks the context right away.
+	it.Rewind()
+	require.False(t, it.Valid())
+	closeAndCheck(t, it, 4)
+}
+
+func TestMergeIteratorCtx(t *testing.T) {
+	it := NewMergeIteratorCtx(context.Background(), benchmarkMergeIters(3, 10, false), false)
+	var count int
+	for it.Rewind(); it.Valid(); it.Next() {
+		count++
+	}
+	require.True(t, count > 0)
+	require.NoError(t, y.IteratorErr(it))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	single := newSimpleIterator([]string{"1"}, []string{"a1"}, false)
+	it = NewMergeIteratorCtx(ctx, []y.Iterator{single}, false)
+	it.Seek([]byte("1"))
+	require.False(t, it.Valid())
+	require.Equal(t, context.Canceled, y.IteratorErr(it))
+}
+
+func TestContextIteratorForwards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iters := benchmarkMergeIters(4, 100, false)
+	mi := NewMergeIterator(iters, false)
+	it := NewContextIterator(ctx, mi, 10)
+
+	it.Rewind()
+	require.True(t, it.Valid())
+	// Peek is forwarded.
+	next, ok := it.(y.Peeker).Peek()
+	require.True(t, ok)
+	it.Next()
+	require.Equal(t, next, it.Key())
+
+	// SetBound is forwarded.
+	it.Next()
+	bound := y.SafeCopy(nil, it.Key())
+	it.(bounder).SetBound(bound)
+	var count int
+	for it.Rewind(); it.Valid(); it.Next() {
+		count++
+	}
+	require.Equal(t, 2, count)
+	it.(bounder).SetBound(nil)
+
+	// Peek returns false once the next Next would notice that ctx is done.
+	it.Rewind()
+	for i := 0; i < 8; i++ {
+		it.Next()
+	}
+	cancel()
+	_, ok = it.(y.Peeker).Peek()
+	require.True(t, ok)
+	it.Next()
+	_, ok = it.(y.Peeker).Peek()
+	require.False(t, ok)
+	it.Next()
+	require.False(t, it.Valid())
+	require.Equal(t, context.Canceled, y.IteratorErr(it))
+	closeAndCheck(t, it, 4)
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// FileCache bounds the number of table files open at once. Once more tables than its capacity
+// have their file open, the least recently used files are closed, and opened again by the next
+// read which needs them.
+//
+// Only the descriptors are closed. The memory maps of the tables opened with options.MemoryMap
+// stay, as the keys and values read from a table point into it, and a mapping doesn't hold a
+// descriptor. Only the tables opened with options.FileIO read their file, the others only open it
+// again to delete it.
+type FileCache struct {
+	sync.Mutex
+	capacity int
+	lru      *list.List // Of *Table whose file is open, the most recently used at the front.
+	reopens  uint64     // Atomic.
+}
+
+// NewFileCache returns a FileCache keeping up to capacity table files open.
+func NewFileCache(capacity int) *FileCache {
+	y.AssertTrue(capacity > 0)
+	return &FileCache{capacity: capacity, lru: list.New()}
+}
+
+// Len returns the number of table files open.
+func (c *FileCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+// Reopens returns the number of times the tables opened their file again, after it was closed.
+func (c *FileCache) Reopens() uint64 {
+	return atomic.LoadUint64(&c.reopens)
+}
+
+// touch marks the file of t as used. It's a no-op if the file is being closed.
+func (c *FileCache) touch(t *Table) {
+	c.Lock()
+	if t.fileElem != nil {
+		c.lru.MoveToFront(t.fileElem)
+	}
+	c.Unlock()
+}
+
+// add adds the file of t, just opened, and closes the least recently used files over capacity.
+func (c *FileCache) add(t *Table) {
+	c.Lock()
+	y.AssertTrue(t.fileElem == nil)
+	t.fileElem = c.lru.PushFront(t)
+	var evicted []*Table
+	for c.lru.Len() > c.capacity {
+		e := c.lru.Back()
+		v := e.Value.(*Table)
+		c.lru.Remove(e)
+		v.fileElem = nil
+		evicted = append(evicted, v)
+	}
+	c.Unlock()
+
+	// The files are closed without the lock, once their pending reads are done. They can't be
+	// added back before, as their file is still open.
+	for _, v := range evicted {
+		v.fdLock.Lock()
+		if v.fd != nil {
+			// The file was only read.
+			_ = v.fd.Close()
+			v.fd = nil
+		}
+		v.fdLock.Unlock()
+	}
+}
+
+// remove removes the file of t, which is being closed.
+func (c *FileCache) remove(t *Table) {
+	c.Lock()
+	if t.fileElem != nil {
+		c.lru.Remove(t.fileElem)
+		t.fileElem = nil
+	}
+	c.Unlock()
+}
+
+// file returns the file of t, opened again if it was closed by opt.FileCache. The file must be
+// released once used.
+func (t *Table) file() (*os.File, func(), error) {
+	c := t.opt.FileCache
+	for {
+		t.fdLock.RLock()
+		if fd := t.fd; fd != nil {
+			if c != nil {
+				c.touch(t)
+			}
+			return fd, t.fdLock.RUnlock, nil
+		}
+		t.fdLock.RUnlock()
+		if c == nil {
+			// Without a FileCache, the file is only closed along with the table.
+			return nil, nil, os.ErrClosed
+		}
+
+		t.fdLock.Lock()
+		var reopened bool
+		if t.fd == nil {
+			fd, err := y.OpenExistingFile(t.filename, 0)
+			if err != nil {
+				t.fdLock.Unlock()
+				return nil, nil, y.Wrapf(err, "while reopening table: %s", t.filename)
+			}
+			t.fd = fd
+			reopened = true
+		}
+		t.fdLock.Unlock()
+		if reopened {
+			atomic.AddUint64(&c.reopens, 1)
+			y.NumTableReopens.Add(1)
+			c.add(t)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import (
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// HeapMergeIterator merges multiple iterators using a single binary heap,
+// instead of the tree of MergeIterators built by NewMergeIterator. Every Next
+// costs O(log n) key comparisons without going through several levels of
+// MergeIterators, which makes it a better fit for merges over many iterators.
+//
+// Like MergeIterator, if several iterators have the same key, the one that
+// comes first in the slice passed to NewHeapMergeIterator wins and the others
+// are skipped.
+// NOTE: HeapMergeIterator owns the array of iterators and is responsible for closing them.
+type HeapMergeIterator struct {
+	nodes   []heapNode
+	heap    []*heapNode // Valid nodes only. heap[0] is the current node.
+	reverse bool
+	bound   []byte // See SetBound.
+	err     error  // See Err.
+	// cmp is the order of the keys. See NewHeapMergeIteratorWithComparator.
+	cmp y.Comparator
+
+	conflicts uint64 // Number of duplicate keys skipped since the last Rewind.
+}
+
+type heapNode struct {
+	node
+	idx int // Position of the iterator in the slice passed to NewHeapMergeIterator.
+}
+
+// NewHeapMergeIterator creates a heap based merge iterator.
+func NewHeapMergeIterator(iters []y.Iterator, reverse bool) y.Iterator {
+	return NewHeapMergeIteratorWithComparator(iters, reverse, nil)
+}
+
+// NewHeapMergeIteratorWithComparator is like NewHeapMergeIterator, but orders the keys with cmp,
+// which must be the order of the source iterators.
+func NewHeapMergeIteratorWithComparator(iters []y.Iterator, reverse bool,
+	cmp y.Comparator) y.Iterator {
+	if len(iters) == 0 {
+		return nil
+	} else if len(iters) == 1 {
+		return iters[0]
+	}
+	mi := &HeapMergeIterator{
+		nodes:   make([]heapNode, len(iters)),
+		heap:    make([]*heapNode, 0, len(iters)),
+		reverse: reverse,
+		cmp:     cmp,
+	}
+	for i, it := range iters {
+		mi.nodes[i].setIterator(it)
+		mi.nodes[i].idx = i
+	}
+	return mi
+}
+
+// less reports whether a should be returned before b.
+func (mi *HeapMergeIterator) less(a, b *heapNode) bool {
+	cmp := mi.cmp.CompareKeys(a.key, b.key)
+	if cmp == 0 {
+		return a.idx < b.idx
+	}
+	if mi.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (mi *HeapMergeIterator) down(i int) {
+	h := mi.heap
+	n := len(h)
+	for {
+		l := 2*i + 1
+		if l >= n {
+			return
+		}
+		j := l
+		if r := l + 1; r < n && mi.less(h[r], h[l]) {
+			j = r
+		}
+		if !mi.less(h[j], h[i]) {
+			return
+		}
+		h[i], h[j] = h[j], h[i]
+		i = j
+	}
+}
+
+// fixAt restores the heap after the node at position i has moved forward,
+// removing it from the heap if it's no longer valid. Nodes only move forward,
+// so they only ever need to go down the heap.
+func (mi *HeapMergeIterator) fixAt(i int) {
+	if !mi.heap[i].valid {
+		if err := mi.heap[i].err; err != nil && mi.err == nil {
+			mi.err = err
+		}
+		last := len(mi.heap) - 1
+		mi.heap[i] = mi.heap[last]
+		mi.heap = mi.heap[:last]
+		if i == last {
+			return
+		}
+		// The moved node came from the bottom of the heap, so it might need to
+		// go up as well.
+		mi.up(i)
+	}
+	mi.down(i)
+}
+
+func (mi *HeapMergeIterator) up(i int) {
+	h := mi.heap
+	for i > 0 {
+		p := (i - 1) / 2
+		if !mi.less(h[i], h[p]) {
+			return
+		}
+		h[i], h[p] = h[p], h[i]
+		i = p
+	}
+}
+
+// skipDuplicates moves forward every node which has the same key as the
+// current node. The current node has the smallest idx among the nodes with the
+// same key, so it stays at the top of the heap.
+func (mi *HeapMergeIterator) skipDuplicates() {
+	for len(mi.heap) > 1 {
+		j := 1
+		if len(mi.heap) > 2 && mi.less(mi.heap[2], mi.heap[1]) {
+			j = 2
+		}
+		if mi.cmp.CompareKeys(mi.heap[0].key, mi.heap[j].key) != 0 {
+			return
+		}
+		mi.conflicts++
+		mi.heap[j].next()
+		mi.fixAt(j)
+	}
+}
+
+// init rebuilds the heap after all the nodes have been repositioned.
+func (mi *HeapMergeIterator) init() {
+	mi.heap = mi.heap[:0]
+	mi.err = nil
+	for i := range mi.nodes {
+		if mi.nodes[i].valid {
+			mi.heap = append(mi.heap, &mi.nodes[i])
+		} else if mi.nodes[i].err != nil && mi.err == nil {
+			mi.err = mi.nodes[i].err
+		}
+	}
+	for i := len(mi.heap)/2 - 1; i >= 0; i-- {
+		mi.down(i)
+	}
+	mi.skipDuplicates()
+}
+
+// check invalidates the iterator if one of the source iterators hit an error, or
+// if it moved out of bound.
+func (mi *HeapMergeIterator) check() {
+	if mi.err != nil ||
+		(len(mi.heap) > 0 && outOfBound(mi.cmp, mi.heap[0].key, mi.bound, mi.reverse)) {
+		mi.heap = mi.heap[:0]
+	}
+}
+
+// SetBound makes the iterator stop at bound, see MergeIterator.SetBound.
+func (mi *HeapMergeIterator) SetBound(bound []byte) {
+	mi.bound = bound
+	for i := range mi.nodes {
+		if b, ok := mi.nodes[i].iter.(bounder); ok {
+			b.SetBound(bound)
+		}
+	}
+}
+
+// Next returns the next element. If it is the same as the current key, ignore it.
+func (mi *HeapMergeIterator) Next() {
+	if len(mi.heap) == 0 {
+		return
+	}
+	mi.heap[0].next()
+	mi.fixAt(0)
+	mi.skipDuplicates()
+	mi.check()
+}
+
+// Rewind seeks to first element (or last element for reverse iterator).
+func (mi *HeapMergeIterator) Rewind() {
+	mi.conflicts = 0
+	for i := range mi.nodes {
+		mi.nodes[i].rewind()
+	}
+	mi.init()
+	mi.check()
+}
+
+// SeekToFirst moves to the smallest key, whatever the direction of the iterator.
+func (mi *HeapMergeIterator) SeekToFirst() {
+	mi.seekToEnd(true)
+}
+
+// SeekToLast moves to the biggest key, whatever the direction of the iterator.
+func (mi *HeapMergeIterator) SeekToLast() {
+	mi.seekToEnd(false)
+}
+
+func (mi *HeapMergeIterator) seekToEnd(first bool) {
+	mi.conflicts = 0
+	for i := range mi.nodes {
+		mi.nodes[i].seekToEnd(first)
+	}
+	if first == mi.reverse {
+		// This is the end of the iteration. Only keep the winner of the comparison in
+		// the other direction, and move the other nodes past their end.
+		var winner *heapNode
+		for i := range mi.nodes {
+			n := &mi.nodes[i]
+			if !n.valid {
+				continue
+			}
+			if winner == nil {
+				winner = n
+				continue
+			}
+			cmp := mi.cmp.CompareKeys(n.key, winner.key)
+			if cmp == 0 {
+				mi.conflicts++
+			}
+			if (cmp < 0 && first) || (cmp > 0 && !first) {
+				winner.next()
+				winner = n
+			} else {
+				n.next()
+			}
+		}
+	}
+	mi.init()
+	mi.check()
+}
+
+// Seek brings us to element with key >= given key.
+func (mi *HeapMergeIterator) Seek(key []byte) {
+	for i := range mi.nodes {
+		mi.nodes[i].seek(key)
+	}
+	mi.init()
+	mi.check()
+}
+
+// Valid returns whether the HeapMergeIterator is at a valid element.
+func (mi *HeapMergeIterator) Valid() bool {
+	return len(mi.heap) > 0
+}
+
+// Key returns the key associated with the current iterator.
+func (mi *HeapMergeIterator) Key() []byte {
+	return mi.heap[0].key
+}
+
+// Peek returns the key a subsequent Next would move to, without moving the
+// iterator. Every source iterator must implement y.Peeker. It's safe to call
+// Peek repeatedly, each call peeks into the source of the current key.
+func (mi *HeapMergeIterator) Peek() ([]byte, bool) {
+	if len(mi.heap) == 0 {
+		return nil, false
+	}
+	key, ok := mi.heap[0].peek()
+	if len(mi.heap) == 1 {
+		if ok && outOfBound(mi.cmp, key, mi.bound, mi.reverse) {
+			return nil, false
+		}
+		return key, ok
+	}
+	// The second node in iteration order is one of the children of the root.
+	// Its key is never equal to the current key, see skipDuplicates.
+	second := mi.heap[1]
+	if len(mi.heap) > 2 && mi.less(mi.heap[2], second) {
+		second = mi.heap[2]
+	}
+	if ok {
+		key = nextOf(mi.cmp, key, second.key, mi.reverse)
+	} else {
+		key = second.key
+	}
+	if outOfBound(mi.cmp, key, mi.bound, mi.reverse) {
+		return nil, false
+	}
+	return key, true
+}
+
+// Value returns the value associated with the iterator.
+func (mi *HeapMergeIterator) Value() y.ValueStruct {
+	return mi.heap[0].iter.Value()
+}
+
+// CurrentIndex returns the index, into the slice of iterators passed to
+// NewHeapMergeIterator, of the iterator the current key comes from. It returns
+// -1 if the HeapMergeIterator is not valid.
+func (mi *HeapMergeIterator) CurrentIndex() int {
+	if len(mi.heap) == 0 {
+		return -1
+	}
+	return mi.heap[0].idx
+}
+
+// ConflictsResolved returns the number of duplicate keys skipped since the last
+// Rewind, including the ones skipped by the merge iterators it merges.
+func (mi *HeapMergeIterator) ConflictsResolved() uint64 {
+	n := mi.conflicts
+	for i := range mi.nodes {
+		n += mi.nodes[i].conflictsResolved()
+	}
+	return n
+}
+
+// Err implements y.ErrIterator. It returns the first error hit by any of the source
+// iterators since the last Rewind or Seek. An error stops the whole merge.
+func (mi *HeapMergeIterator) Err() error {
+	return mi.err
+}
+
+// Close implements y.Iterator.
+func (mi *HeapMergeIterator) Close() error {
+	var firstErr error
+	for i := range mi.nodes {
+		if err := mi.nodes[i].iter.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return errors.Wrap(firstErr, "HeapMergeIterator")
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeapMergeIterator(t *testing.T) {
+	newIters := func(reversed bool) []y.Iterator {
+		return []y.Iterator{
+			newSimpleIterator([]string{"1", "3", "7"}, []string{"a1", "a3", "a7"}, reversed),
+			newSimpleIterator([]string{"2", "3", "5"}, []string{"b2", "b3", "b5"}, reversed),
+			newSimpleIterator([]string{"1"}, []string{"c1"}, reversed),
+			newSimpleIterator([]string{"1", "7", "9"}, []string{"d1", "d7", "d9"}, reversed),
+		}
+	}
+	t.Run("forward", func(t *testing.T) {
+		mi := NewHeapMergeIterator(newIters(false), false)
+		mi.Rewind()
+		k, v := getAll(mi)
+		require.Equal(t, []string{"1", "2", "3", "5", "7", "9"}, k)
+		require.Equal(t, []string{"a1", "b2", "a3", "b5", "a7", "d9"}, v)
+		closeAndCheck(t, mi, 4)
+	})
+	t.Run("reverse", func(t *testing.T) {
+		mi := NewHeapMergeIterator(newIters(true), true)
+		mi.Rewind()
+		k, v := getAll(mi)
+		require.Equal(t, []string{"9", "7", "5", "3", "2", "1"}, k)
+		require.Equal(t, []string{"d9", "a7", "b5", "a3", "b2", "a1"}, v)
+		closeAndCheck(t, mi, 4)
+	})
+	t.Run("seek", func(t *testing.T) {
+		mi := NewHeapMergeIterator(newIters(false), false)
+		mi.Seek([]byte("4"))
+		k, v := getAll(mi)
+		require.Equal(t, []string{"5", "7", "9"}, k)
+		require.Equal(t, []string{"b5", "a7", "d9"}, v)
+		mi.Seek([]byte("f"))
+		require.False(t, mi.Valid())
+	})
+	t.Run("seek reverse", func(t *testing.T) {
+		mi := NewHeapMergeIterator(newIters(true), true)
+		mi.Seek([]byte("5"))
+		k, v := getAll(mi)
+		require.Equal(t, []string{"5", "3", "2", "1"}, k)
+		require.Equal(t, []string{"b5", "a3", "b2", "a1"}, v)
+	})
+}
+
+func TestHeapMergeIteratorComparator(t *testing.T) {
+	// Orders the keys in reverse.
+	cmp := y.Comparator(func(a, b []byte) int { return bytes.Compare(b, a) })
+	mi := NewHeapMergeIteratorWithComparator([]y.Iterator{
+		newSimpleIterator([]string{"7", "3", "1"}, []string{"a7", "a3", "a1"}, false),
+		newSimpleIterator([]string{"5", "3", "2"}, []string{"b5", "b3", "b2"}, false),
+		newSimpleIterator([]string{"9", "7", "1"}, []string{"c9", "c7", "c1"}, false),
+	}, false, cmp)
+	mi.Rewind()
+	k, v := getAll(mi)
+	require.Equal(t, []string{"9", "7", "5", "3", "2", "1"}, k)
+	require.Equal(t, []string{"c9", "a7", "b5", "a3", "b2", "a1"}, v)
+
+	mi.(*HeapMergeIterator).SetBound(y.KeyWithTs([]byte("2"), 0))
+	mi.Rewind()
+	k, _ = getAll(mi)
+	require.Equal(t, []string{"9", "7", "5", "3"}, k)
+	closeAndCheck(t, mi, 3)
+}
+
+// TestHeapMergeIteratorRandom checks that the heap and tree based merge
+// iterators return the same results.
+func TestHeapMergeIteratorRandom(t *testing.T) {
+	for _, n := range []int{2, 3, 7, 16, 33} {
+		for _, reverse := range []bool{false, true} {
+			a := benchmarkMergeIters(n, 50, reverse)
+			b := benchmarkMergeIters(n, 50, reverse)
+			it1 := NewMergeIterator(a, reverse)
+			it2 := NewHeapMergeIterator(b, reverse)
+			it1.Rewind()
+			it2.Rewind()
+			k1, v1 := getAll(it1)
+			k2, v2 := getAll(it2)
+			require.Equal(t, k1, k2, "n=%d reverse=%v", n, reverse)
+			require.Equal(t, v1, v2, "n=%d reverse=%v", n, reverse)
+		}
+	}
+}
+
+// benchmarkMergeIters returns n iterators with m keys each. Keys overlap across
+// iterators, and every value records the iterator it came from.
+func benchmarkMergeIters(n, m int, reversed bool) []y.Iterator {
+	r := rand.New(rand.NewSource(int64(n)))
+	iters := make([]y.Iterator, n)
+	for i := 0; i < n; i++ {
+		set := make(map[string]struct{})
+		for len(set) < m {
+			set[fmt.Sprintf("%08d", r.Intn(n*m))] = struct{}{}
+		}
+		var keys, vals []string
+		for k := range set {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			vals = append(vals, fmt.Sprintf("%d-%s", i, k))
+		}
+		iters[i] = newSimpleIterator(keys, vals, reversed)
+	}
+	return iters
+}
+
+func BenchmarkMergeIterator(b *testing.B) {
+	constructors := []struct {
+		name string
+		fn   func([]y.Iterator, bool) y.Iterator
+	}{
+		{"tree", NewMergeIterator},
+		{"heap", NewHeapMergeIterator},
+	}
+	for _, n := range []int{2, 8, 32, 128} {
+		iters := benchmarkMergeIters(n, 10000/n, false)
+		for _, c := range constructors {
+			b.Run(fmt.Sprintf("%s/iters=%d", c.name, n), func(b *testing.B) {
+				it := c.fn(iters, false)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					for it.Rewind(); it.Valid(); it.Next() {
+					}
+				}
+			})
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"io"
 	"sort"
 
+	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/pkg/errors"
 )
@@ -37,6 +38,8 @@ type blockIterator struct {
 	// prevOverlap stores the overlap of the previous key with the base key.
 	// This avoids unnecessary copy of base key when the overlap is same for multiple keys.
 	prevOverlap uint16
+
+	cmp y.Comparator // Order of the keys, only used by seek.
 }
 
 func (itr *blockIterator) setBlock(b *block) {
@@ -93,6 +96,25 @@ func (itr *blockIterator) setIdx(i int) {
 	itr.val = entryData[valueOff:]
 }
 
+// keyAt returns a copy of the key of the entry at index i, without moving the
+// iterator.
+func (itr *blockIterator) keyAt(i int) []byte {
+	baseKey := itr.baseKey
+	if len(baseKey) == 0 {
+		var baseHeader header
+		baseHeader.Decode(itr.data)
+		baseKey = itr.data[headerSize : headerSize+baseHeader.diff]
+	}
+	startOffset := int(itr.entryOffsets[i])
+	var h header
+	h.Decode(itr.data[startOffset:])
+	keyOff := startOffset + int(headerSize)
+	diffKey := itr.data[keyOff : keyOff+int(h.diff)]
+	key := make([]byte, 0, int(h.overlap)+len(diffKey))
+	key = append(key, baseKey[:h.overlap]...)
+	return append(key, diffKey...)
+}
+
 func (itr *blockIterator) Valid() bool {
 	return itr != nil && itr.err == nil
 }
@@ -126,7 +148,7 @@ func (itr *blockIterator) seek(key []byte, whence int) {
 			return false
 		}
 		itr.setIdx(idx)
-		return y.CompareKeys(itr.key, key) >= 0
+		return itr.cmp.CompareKeys(itr.key, key) >= 0
 	})
 	itr.setIdx(foundEntryIdx)
 }
@@ -151,20 +173,43 @@ func (itr *blockIterator) prev() {
 
 // Iterator is an iterator for a Table.
 type Iterator struct {
-	t    *Table
-	bpos int
-	bi   blockIterator
-	err  error
+	t       *Table
+	offsets []*pb.BlockOffset // Block offsets of t, fetched once for the life of the iterator.
+	bpos    int
+	bi      blockIterator
+	err     error
+	peekErr error // Hit by Peek while reading the previous block, reported by Err until it moves.
 
 	// Internally, Iterator is bidirectional. However, we only expose the
 	// unidirectional functionality for now.
 	reversed bool
+
+	bound []byte // See SetBound.
+}
+
+// outOfBound returns true if key is beyond bound, in the direction of iteration, in the order of c.
+// Moving forward, keys >= bound are out of bound. Moving backwards, keys < bound are.
+func outOfBound(c y.Comparator, key, bound []byte, reversed bool) bool {
+	if bound == nil {
+		return false
+	}
+	cmp := c.CompareKeys(key, bound)
+	if !reversed {
+		return cmp >= 0
+	}
+	return cmp < 0
+}
+
+// bounder is implemented by the iterators which support SetBound.
+type bounder interface {
+	SetBound(bound []byte)
 }
 
 // NewIterator returns a new iterator of the Table
 func (t *Table) NewIterator(reversed bool) *Iterator {
 	t.IncrRef() // Important.
-	ti := &Iterator{t: t, reversed: reversed}
+	ti := &Iterator{t: t, offsets: t.fetchIndex().offsets, reversed: reversed}
+	ti.bi.cmp = t.opt.Comparator
 	ti.next()
 	return ti
 }
@@ -184,8 +229,47 @@ func (itr *Iterator) Valid() bool {
 	return itr.err == nil
 }
 
+// Err follows the y.ErrIterator interface. It returns the error hit while reading
+// or verifying a block of the table, if any.
+func (itr *Iterator) Err() error {
+	if itr.err == nil || itr.err == io.EOF {
+		return itr.peekErr
+	}
+	return itr.err
+}
+
+// SetBound makes the iterator stop at bound, which is a key with timestamp. Moving
+// forward, the iterator becomes invalid as soon as it reaches a key >= bound.
+// Moving backwards, it becomes invalid when it reaches a key < bound. The iterator
+// doesn't read blocks which are entirely out of bound when moving to the next
+// block. A nil bound removes the bound. It takes effect from the next call to
+// Next, Rewind or Seek.
+func (itr *Iterator) SetBound(bound []byte) {
+	itr.bound = bound
+}
+
+// checkBound invalidates the iterator if it moved out of bound.
+func (itr *Iterator) checkBound() {
+	if itr.err == nil && outOfBound(itr.t.opt.Comparator, itr.bi.key, itr.bound, itr.reversed) {
+		itr.err = io.EOF
+	}
+}
+
+// blockOutOfBound returns true if all the keys of block idx are out of bound.
+func (itr *Iterator) blockOutOfBound(idx int) bool {
+	if itr.bound == nil {
+		return false
+	}
+	if !itr.reversed {
+		return itr.t.opt.Comparator.CompareKeys(itr.offsets[idx].Key, itr.bound) >= 0
+	}
+	// All the keys in block idx are smaller than the first key of block idx+1.
+	return idx+1 < len(itr.offsets) &&
+		itr.t.opt.Comparator.CompareKeys(itr.offsets[idx+1].Key, itr.bound) <= 0
+}
+
 func (itr *Iterator) seekToFirst() {
-	numBlocks := len(itr.t.blockIndex)
+	numBlocks := len(itr.offsets)
 	if numBlocks == 0 {
 		itr.err = io.EOF
 		return
@@ -202,7 +286,7 @@ func (itr *Iterator) seekToFirst() {
 }
 
 func (itr *Iterator) seekToLast() {
-	numBlocks := len(itr.t.blockIndex)
+	numBlocks := len(itr.offsets)
 	if numBlocks == 0 {
 		itr.err = io.EOF
 		return
@@ -239,9 +323,9 @@ func (itr *Iterator) seekFrom(key []byte, whence int) {
 	case current:
 	}
 
-	idx := sort.Search(len(itr.t.blockIndex), func(idx int) bool {
-		ko := itr.t.blockIndex[idx]
-		return y.CompareKeys(ko.Key, key) > 0
+	idx := sort.Search(len(itr.offsets), func(idx int) bool {
+		ko := itr.offsets[idx]
+		return itr.t.opt.Comparator.CompareKeys(ko.Key, key) > 0
 	})
 	if idx == 0 {
 		// The smallest key in our table is already strictly > key. We can return that.
@@ -259,8 +343,8 @@ func (itr *Iterator) seekFrom(key []byte, whence int) {
 	itr.seekHelper(idx-1, key)
 	if itr.err == io.EOF {
 		// Case 1. Need to visit block[idx].
-		if idx == len(itr.t.blockIndex) {
-			// If idx == len(itr.t.blockIndex), then input key is greater than ANY element of table.
+		if idx == len(itr.offsets) {
+			// If idx == len(itr.offsets), then input key is greater than ANY element of table.
 			// There's nothing we can do. Valid() should return false as we seek to end of table.
 			return
 		}
@@ -287,12 +371,16 @@ func (itr *Iterator) seekForPrev(key []byte) {
 func (itr *Iterator) next() {
 	itr.err = nil
 
-	if itr.bpos >= len(itr.t.blockIndex) {
+	if itr.bpos >= len(itr.offsets) {
 		itr.err = io.EOF
 		return
 	}
 
 	if len(itr.bi.data) == 0 {
+		if itr.bpos > 0 && itr.blockOutOfBound(itr.bpos) {
+			itr.err = io.EOF
+			return
+		}
 		block, err := itr.t.block(itr.bpos)
 		if err != nil {
 			itr.err = err
@@ -321,6 +409,10 @@ func (itr *Iterator) prev() {
 	}
 
 	if len(itr.bi.data) == 0 {
+		if itr.blockOutOfBound(itr.bpos) {
+			itr.err = io.EOF
+			return
+		}
 		block, err := itr.t.block(itr.bpos)
 		if err != nil {
 			itr.err = err
@@ -361,31 +453,91 @@ func (itr *Iterator) ValueCopy() (ret y.ValueStruct) {
 	return
 }
 
+// Peek implements y.Peeker. It is cheap if the next key is in the current block.
+// Otherwise, moving forward it uses the block index, and moving backwards it has
+// to read the previous block.
+func (itr *Iterator) Peek() ([]byte, bool) {
+	if !itr.Valid() {
+		return nil, false
+	}
+	key := itr.peek()
+	if key == nil || outOfBound(itr.t.opt.Comparator, key, itr.bound, itr.reversed) {
+		return nil, false
+	}
+	return key, true
+}
+
+func (itr *Iterator) peek() []byte {
+	if !itr.reversed {
+		if itr.bi.idx+1 < len(itr.bi.entryOffsets) {
+			return itr.bi.keyAt(itr.bi.idx + 1)
+		}
+		if itr.bpos+1 < len(itr.offsets) {
+			return itr.offsets[itr.bpos+1].Key
+		}
+		return nil
+	}
+	if itr.bi.idx > 0 {
+		return itr.bi.keyAt(itr.bi.idx - 1)
+	}
+	if itr.bpos == 0 || itr.blockOutOfBound(itr.bpos-1) {
+		return nil
+	}
+	block, err := itr.t.block(itr.bpos - 1)
+	if err != nil {
+		itr.peekErr = err
+		return nil
+	}
+	var bi blockIterator
+	bi.setBlock(block)
+	return bi.keyAt(len(bi.entryOffsets) - 1)
+}
+
 // Next follows the y.Iterator interface
 func (itr *Iterator) Next() {
+	itr.peekErr = nil
 	if !itr.reversed {
 		itr.next()
 	} else {
 		itr.prev()
 	}
+	itr.checkBound()
 }
 
 // Rewind follows the y.Iterator interface
 func (itr *Iterator) Rewind() {
+	itr.peekErr = nil
 	if !itr.reversed {
 		itr.seekToFirst()
 	} else {
 		itr.seekToLast()
 	}
+	itr.checkBound()
+}
+
+// SeekToFirst follows the y.Iterator interface
+func (itr *Iterator) SeekToFirst() {
+	itr.peekErr = nil
+	itr.seekToFirst()
+	itr.checkBound()
+}
+
+// SeekToLast follows the y.Iterator interface
+func (itr *Iterator) SeekToLast() {
+	itr.peekErr = nil
+	itr.seekToLast()
+	itr.checkBound()
 }
 
 // Seek follows the y.Iterator interface
 func (itr *Iterator) Seek(key []byte) {
+	itr.peekErr = nil
 	if !itr.reversed {
 		itr.seek(key)
 	} else {
 		itr.seekForPrev(key)
 	}
+	itr.checkBound()
 }
 
 // ConcatIterator concatenates the sequences defined by several iterators.  (It only works with
@@ -396,6 +548,8 @@ type ConcatIterator struct {
 	iters    []*Iterator // Corresponds to tables.
 	tables   []*Table    // Disregarding reversed, this is in ascending order.
 	reversed bool
+	bound    []byte       // See SetBound.
+	cmp      y.Comparator // Order of the keys of the tables.
 }
 
 // NewConcatIterator creates a new concatenated iterator
@@ -409,11 +563,16 @@ func NewConcatIterator(tbls []*Table, reversed bool) *ConcatIterator {
 		// Save cycles by not initializing the iterators until needed.
 		// iters[i] = tbls[i].NewIterator(reversed)
 	}
+	var cmp y.Comparator
+	if len(tbls) > 0 {
+		cmp = tbls[0].opt.Comparator
+	}
 	return &ConcatIterator{
 		reversed: reversed,
 		iters:    iters,
 		tables:   tbls,
 		idx:      -1, // Not really necessary because s.it.Valid()=false, but good to have.
+		cmp:      cmp,
 	}
 }
 
@@ -425,10 +584,30 @@ func (s *ConcatIterator) setIdx(idx int) {
 	}
 	if s.iters[idx] == nil {
 		s.iters[idx] = s.tables[idx].NewIterator(s.reversed)
+		s.iters[idx].SetBound(s.bound)
 	}
 	s.cur = s.iters[s.idx]
 }
 
+// SetBound makes the iterator stop at bound, see Iterator.SetBound. Tables which
+// are entirely out of bound are never opened.
+func (s *ConcatIterator) SetBound(bound []byte) {
+	s.bound = bound
+	for _, it := range s.iters {
+		if it != nil {
+			it.SetBound(bound)
+		}
+	}
+}
+
+// tableOutOfBound returns true if all the keys of table idx are out of bound.
+func (s *ConcatIterator) tableOutOfBound(idx int) bool {
+	if !s.reversed {
+		return outOfBound(s.cmp, s.tables[idx].Smallest(), s.bound, false)
+	}
+	return outOfBound(s.cmp, s.tables[idx].Biggest(), s.bound, true)
+}
+
 // Rewind implements y.Interface
 func (s *ConcatIterator) Rewind() {
 	if len(s.iters) == 0 {
@@ -442,11 +621,49 @@ func (s *ConcatIterator) Rewind() {
 	s.cur.Rewind()
 }
 
+// SeekToFirst implements y.Interface. The iterator is invalid if the smallest key is out of
+// bound, and the table isn't opened if all of its keys are.
+func (s *ConcatIterator) SeekToFirst() {
+	if len(s.iters) == 0 {
+		return
+	}
+	if s.tableOutOfBound(0) {
+		s.setIdx(-1)
+		return
+	}
+	s.setIdx(0)
+	s.cur.SeekToFirst()
+}
+
+// SeekToLast implements y.Interface. The iterator is invalid if the biggest key is out of
+// bound, and the table isn't opened if all of its keys are.
+func (s *ConcatIterator) SeekToLast() {
+	if len(s.iters) == 0 {
+		return
+	}
+	idx := len(s.iters) - 1
+	if s.tableOutOfBound(idx) {
+		s.setIdx(-1)
+		return
+	}
+	s.setIdx(idx)
+	s.cur.SeekToLast()
+}
+
 // Valid implements y.Interface
 func (s *ConcatIterator) Valid() bool {
 	return s.cur != nil && s.cur.Valid()
 }
 
+// Err implements y.ErrIterator. An error in one of the tables stops the iteration,
+// instead of moving on to the next table.
+func (s *ConcatIterator) Err() error {
+	if s.cur == nil {
+		return nil
+	}
+	return s.cur.Err()
+}
+
 // Key implements y.Interface
 func (s *ConcatIterator) Key() []byte {
 	return s.cur.Key()
@@ -457,17 +674,39 @@ func (s *ConcatIterator) Value() y.ValueStruct {
 	return s.cur.Value()
 }
 
+// Peek implements y.Peeker.
+func (s *ConcatIterator) Peek() ([]byte, bool) {
+	if !s.Valid() {
+		return nil, false
+	}
+	if key, ok := s.cur.Peek(); ok {
+		return key, true
+	}
+	var key []byte
+	if !s.reversed {
+		if s.idx+1 < len(s.tables) {
+			key = s.tables[s.idx+1].Smallest()
+		}
+	} else if s.idx > 0 {
+		key = s.tables[s.idx-1].Biggest()
+	}
+	if key == nil || outOfBound(s.cmp, key, s.bound, s.reversed) {
+		return nil, false
+	}
+	return key, true
+}
+
 // Seek brings us to element >= key if reversed is false. Otherwise, <= key.
 func (s *ConcatIterator) Seek(key []byte) {
 	var idx int
 	if !s.reversed {
 		idx = sort.Search(len(s.tables), func(i int) bool {
-			return y.CompareKeys(s.tables[i].Biggest(), key) >= 0
+			return s.cmp.CompareKeys(s.tables[i].Biggest(), key) >= 0
 		})
 	} else {
 		n := len(s.tables)
 		idx = n - 1 - sort.Search(n, func(i int) bool {
-			return y.CompareKeys(s.tables[n-1-i].Smallest(), key) <= 0
+			return s.cmp.CompareKeys(s.tables[n-1-i].Smallest(), key) <= 0
 		})
 	}
 	if idx >= len(s.tables) || idx < 0 {
@@ -483,22 +722,25 @@ func (s *ConcatIterator) Seek(key []byte) {
 // Next advances our concat iterator.
 func (s *ConcatIterator) Next() {
 	s.cur.Next()
-	if s.cur.Valid() {
+	if s.cur.Valid() || s.cur.Err() != nil {
 		// Nothing to do. Just stay with the current table.
 		return
 	}
 	for { // In case there are empty tables.
-		if !s.reversed {
-			s.setIdx(s.idx + 1)
-		} else {
-			s.setIdx(s.idx - 1)
+		next := s.idx + 1
+		if s.reversed {
+			next = s.idx - 1
+		}
+		if next >= 0 && next < len(s.tables) && s.tableOutOfBound(next) {
+			next = -1
 		}
+		s.setIdx(next)
 		if s.cur == nil {
 			// End of list. Valid will become false.
 			return
 		}
 		s.cur.Rewind()
-		if s.cur.Valid() {
+		if s.cur.Valid() || s.cur.Err() != nil {
 			break
 		}
 	}
@@ -17,6 +17,8 @@
 package table
 
 import (
+	"sync"
+
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/pkg/errors"
 )
@@ -28,12 +30,27 @@ type MergeIterator struct {
 	right   node
 	small   *node
 	reverse bool
+
+	// numIters is the number of source iterators the tree rooted at this
+	// MergeIterator was built for. Reset requires the same number of iterators.
+	numIters int
+
+	bound []byte // See SetBound.
+	err   error  // First error hit by the source iterators. See Err.
+
+	// resolve picks the winner when both sides have the same key. See NewMergeIteratorFunc.
+	resolve func(a, b y.ValueStruct) bool
+	// cmp is the order of the keys. See NewMergeIteratorWithComparator.
+	cmp y.Comparator
+
+	conflicts uint64 // Number of duplicate keys skipped by fix() since the last Rewind.
 }
 
 type node struct {
 	valid bool
 	key   []byte
 	iter  y.Iterator
+	err   error // Set if the iterator is invalid because of an error.
 
 	// The two iterators are type asserted from `y.Iterator`, used to inline more function calls.
 	// Calling functions on concrete types is much faster (about 25-30%) than calling the
@@ -51,20 +68,27 @@ func (n *node) setIterator(iter y.Iterator) {
 }
 
 func (n *node) setKey() {
+	n.err = nil
 	if n.merge != nil {
 		n.valid = n.merge.small.valid
 		if n.valid {
 			n.key = n.merge.small.key
+		} else {
+			n.err = n.merge.err
 		}
 	} else if n.concat != nil {
 		n.valid = n.concat.Valid()
 		if n.valid {
 			n.key = n.concat.Key()
+		} else {
+			n.err = n.concat.Err()
 		}
 	} else {
 		n.valid = n.iter.Valid()
 		if n.valid {
 			n.key = n.iter.Key()
+		} else {
+			n.err = y.IteratorErr(n.iter)
 		}
 	}
 }
@@ -85,11 +109,50 @@ func (n *node) rewind() {
 	n.setKey()
 }
 
+func (n *node) seekToEnd(first bool) {
+	if first {
+		n.iter.SeekToFirst()
+	} else {
+		n.iter.SeekToLast()
+	}
+	n.setKey()
+}
+
 func (n *node) seek(key []byte) {
 	n.iter.Seek(key)
 	n.setKey()
 }
 
+func (n *node) conflictsResolved() uint64 {
+	if n.merge != nil {
+		return n.merge.ConflictsResolved()
+	}
+	if hm, ok := n.iter.(*HeapMergeIterator); ok {
+		return hm.ConflictsResolved()
+	}
+	return 0
+}
+
+func (n *node) peek() ([]byte, bool) {
+	if n.merge != nil {
+		return n.merge.Peek()
+	} else if n.concat != nil {
+		return n.concat.Peek()
+	}
+	p, ok := n.iter.(y.Peeker)
+	y.AssertTruef(ok, "MergeIterator.Peek: %T does not implement y.Peeker", n.iter)
+	return p.Peek()
+}
+
+// nextOf returns whichever key comes first in the iteration order, in the order of c.
+func nextOf(c y.Comparator, a, b []byte, reverse bool) []byte {
+	cmp := c.CompareKeys(a, b)
+	if (cmp <= 0) != reverse {
+		return a
+	}
+	return b
+}
+
 func (mi *MergeIterator) fix() {
 	if !mi.bigger().valid {
 		return
@@ -98,9 +161,17 @@ func (mi *MergeIterator) fix() {
 		mi.swapSmall()
 		return
 	}
-	cmp := y.CompareKeys(mi.small.key, mi.bigger().key)
+	cmp := mi.cmp.CompareKeys(mi.small.key, mi.bigger().key)
 	// Both the keys are equal.
 	if cmp == 0 {
+		mi.conflicts++
+		if mi.resolve != nil && !mi.resolve(mi.left.iter.Value(), mi.right.iter.Value()) {
+			// The right iterator wins, move the left iterator ahead instead. Its next key
+			// can't be equal to the current key, so small stays on the right iterator.
+			mi.left.next()
+			mi.small = &mi.right
+			return
+		}
 		// In case of same keys, move the right iterator ahead.
 		mi.right.next()
 		if &mi.right == mi.small {
@@ -143,24 +214,109 @@ func (mi *MergeIterator) swapSmall() {
 	}
 }
 
+// check invalidates the iterator if one of its source iterators hit an error,
+// or if it moved out of bound. Any key after the current one would be out of
+// bound as well. In both cases, the iterator stays invalid until the next Rewind
+// or Seek.
+func (mi *MergeIterator) check() {
+	if mi.err == nil {
+		if mi.left.err != nil {
+			mi.err = mi.left.err
+		} else if mi.right.err != nil {
+			mi.err = mi.right.err
+		}
+	}
+	if mi.err != nil {
+		mi.small.valid = false
+		return
+	}
+	if mi.bound != nil && mi.small.valid && outOfBound(mi.cmp, mi.small.key, mi.bound, mi.reverse) {
+		mi.small.valid = false
+	}
+}
+
+// SetBound makes the iterator stop at bound, which is a key with timestamp. Moving
+// forward, the iterator becomes invalid as soon as it reaches a key >= bound.
+// Moving backwards, it becomes invalid when it reaches a key < bound. The bound is
+// passed down to the source iterators which support it, so that they can avoid
+// reading data which is out of bound. A nil bound removes the bound. It takes
+// effect from the next call to Next, Rewind or Seek.
+func (mi *MergeIterator) SetBound(bound []byte) {
+	mi.bound = bound
+	if b, ok := mi.left.iter.(bounder); ok {
+		b.SetBound(bound)
+	}
+	if b, ok := mi.right.iter.(bounder); ok {
+		b.SetBound(bound)
+	}
+}
+
 // Next returns the next element. If it is the same as the current key, ignore it.
 func (mi *MergeIterator) Next() {
 	mi.small.next()
 	mi.fix()
+	mi.check()
 }
 
 // Rewind seeks to first element (or last element for reverse iterator).
 func (mi *MergeIterator) Rewind() {
+	mi.err = nil
+	mi.conflicts = 0
 	mi.left.rewind()
 	mi.right.rewind()
 	mi.fix()
+	mi.check()
+}
+
+// SeekToFirst moves to the smallest key, whatever the direction of the iterator.
+func (mi *MergeIterator) SeekToFirst() {
+	mi.seekToEnd(true)
+}
+
+// SeekToLast moves to the biggest key, whatever the direction of the iterator.
+func (mi *MergeIterator) SeekToLast() {
+	mi.seekToEnd(false)
+}
+
+func (mi *MergeIterator) seekToEnd(first bool) {
+	mi.err = nil
+	mi.conflicts = 0
+	mi.left.seekToEnd(first)
+	mi.right.seekToEnd(first)
+	if first != mi.reverse {
+		// This is the start of the iteration, just like Rewind.
+		mi.fix()
+		mi.check()
+		return
+	}
+	// This is the end of the iteration. Only the winner of the comparison in the
+	// other direction is left, and the other side is moved past its end, so that
+	// a Next makes the iterator invalid.
+	mi.small = &mi.left
+	if mi.left.valid && mi.right.valid {
+		cmp := mi.cmp.CompareKeys(mi.left.key, mi.right.key)
+		if cmp == 0 {
+			mi.conflicts++
+			if mi.resolve != nil && !mi.resolve(mi.left.iter.Value(), mi.right.iter.Value()) {
+				mi.small = &mi.right
+			}
+		} else if (cmp > 0) == first {
+			mi.small = &mi.right
+		}
+		mi.bigger().next()
+	} else if !mi.left.valid {
+		mi.small = &mi.right
+	}
+	mi.check()
 }
 
 // Seek brings us to element with key >= given key.
 func (mi *MergeIterator) Seek(key []byte) {
+	mi.err = nil
 	mi.left.seek(key)
 	mi.right.seek(key)
 	mi.fix()
+	mi.check()
 }
 
 // Valid returns whether the MergeIterator is at a valid element.
@@ -173,11 +329,78 @@ func (mi *MergeIterator) Key() []byte {
 	return mi.small.key
 }
 
+// Peek returns the key a subsequent Next would move to, without moving the
+// iterator. Every source iterator must implement y.Peeker.
+//
+// Peek doesn't cache anything, so it's safe to call repeatedly, but every call
+// peeks into one source iterator per level of the merge tree. See the Peek
+// methods of the source iterators for their cost.
+func (mi *MergeIterator) Peek() ([]byte, bool) {
+	if !mi.small.valid {
+		return nil, false
+	}
+	// After fix(), the keys of small and bigger() are never equal. So the next
+	// key is either the next key of small, or the current key of bigger().
+	key, ok := mi.small.peek()
+	bigger := mi.bigger()
+	if !bigger.valid {
+		if ok && outOfBound(mi.cmp, key, mi.bound, mi.reverse) {
+			return nil, false
+		}
+		return key, ok
+	}
+	if ok {
+		key = nextOf(mi.cmp, key, bigger.key, mi.reverse)
+	} else {
+		key = bigger.key
+	}
+	if outOfBound(mi.cmp, key, mi.bound, mi.reverse) {
+		return nil, false
+	}
+	return key, true
+}
+
 // Value returns the value associated with the iterator.
 func (mi *MergeIterator) Value() y.ValueStruct {
 	return mi.small.iter.Value()
 }
 
+// CurrentIndex returns the index, into the slice of iterators the MergeIterator
+// was created (or last reset) with, of the iterator the current key comes from.
+// When several iterators have the same key, that's the first one of them.
+// It returns -1 if the MergeIterator is not valid.
+func (mi *MergeIterator) CurrentIndex() int {
+	if !mi.small.valid {
+		return -1
+	}
+	mid := mi.numIters / 2
+	if mi.small == &mi.left {
+		if mid == 1 {
+			return 0
+		}
+		return mi.left.merge.CurrentIndex()
+	}
+	if mi.numIters == 2 {
+		return 1
+	}
+	return mid + mi.right.merge.CurrentIndex()
+}
+
+// ConflictsResolved returns the number of duplicate keys skipped since the last
+// Rewind, because the same key was returned by more than one source iterator.
+// Conflicts resolved by the MergeIterators (or HeapMergeIterators) it merges are
+// included.
+func (mi *MergeIterator) ConflictsResolved() uint64 {
+	return mi.conflicts + mi.left.conflictsResolved() + mi.right.conflictsResolved()
+}
+
+// Err implements y.ErrIterator. It returns the first error hit by any of the source
+// iterators since the last Rewind or Seek. An error stops the whole merge, so
+// that keys of the failed iterator aren't silently skipped.
+func (mi *MergeIterator) Err() error {
+	return mi.err
+}
+
 // Close implements y.Iterator.
 func (mi *MergeIterator) Close() error {
 	err1 := mi.left.iter.Close()
@@ -194,20 +417,229 @@ func NewMergeIterator(iters []y.Iterator, reverse bool) y.Iterator {
 		return nil
 	} else if len(iters) == 1 {
 		return iters[0]
-	} else if len(iters) == 2 {
-		mi := &MergeIterator{
-			reverse: reverse,
-		}
+	}
+	mi := newMergeTree(len(iters), reverse)
+	mi.bind(iters)
+	return mi
+}
+
+// NewMergeIteratorFunc creates a merge iterator which calls resolve whenever two
+// source iterators have the same key, to decide which of the two values is
+// returned. a comes from an iterator that is before the one b comes from in
+// iters. If resolve returns true, a wins, otherwise b wins. The other value is
+// skipped. When more than two iterators have the same key, resolve is called
+// once per pair of winners, until a single value is left.
+//
+// A nil resolve, or one that always returns true, behaves like NewMergeIterator.
+func NewMergeIteratorFunc(iters []y.Iterator, reverse bool,
+	resolve func(a, b y.ValueStruct) bool) y.Iterator {
+	if len(iters) == 0 {
+		return nil
+	} else if len(iters) == 1 {
+		return iters[0]
+	}
+	mi := newMergeTree(len(iters), reverse)
+	mi.setResolve(resolve)
+	mi.bind(iters)
+	return mi
+}
+
+// NewMergeIteratorWithComparator is like NewMergeIteratorFunc, but orders the keys with cmp,
+// which must be the order of the source iterators.
+func NewMergeIteratorWithComparator(iters []y.Iterator, reverse bool, cmp y.Comparator,
+	resolve func(a, b y.ValueStruct) bool) y.Iterator {
+	if len(iters) == 0 {
+		return nil
+	} else if len(iters) == 1 {
+		return iters[0]
+	}
+	mi := newMergeTree(len(iters), reverse)
+	mi.setResolve(resolve)
+	mi.setComparator(cmp)
+	mi.bind(iters)
+	return mi
+}
+
+// setComparator sets the order of the keys of the whole tree.
+func (mi *MergeIterator) setComparator(cmp y.Comparator) {
+	mi.cmp = cmp
+	if mi.numIters == 2 {
+		return
+	}
+	if mi.numIters/2 > 1 {
+		mi.left.merge.setComparator(cmp)
+	}
+	mi.right.merge.setComparator(cmp)
+}
+
+// setResolve sets the resolve function of the whole tree.
+func (mi *MergeIterator) setResolve(resolve func(a, b y.ValueStruct) bool) {
+	mi.resolve = resolve
+	if mi.numIters == 2 {
+		return
+	}
+	if mi.numIters/2 > 1 {
+		mi.left.merge.setResolve(resolve)
+	}
+	mi.right.merge.setResolve(resolve)
+}
+
+// newMergeTree allocates an unbound tree of MergeIterators for n >= 2 source
+// iterators. The shape of the tree only depends on n, which is what allows
+// Reset to rebind it to a different set of iterators.
+func newMergeTree(n int, reverse bool) *MergeIterator {
+	y.AssertTrue(n >= 2)
+	mi := &MergeIterator{
+		reverse:  reverse,
+		numIters: n,
+	}
+	// Assign left iterator randomly. This will be fixed when user calls rewind/seek.
+	mi.small = &mi.left
+	if n == 2 {
+		return mi
+	}
+	mid := n / 2
+	if mid > 1 {
+		mi.left.setIterator(newMergeTree(mid, reverse))
+	}
+	// n > 2, so the right half always has at least 2 iterators.
+	mi.right.setIterator(newMergeTree(n-mid, reverse))
+	return mi
+}
+
+// bind attaches iters to the leaves of the tree. It must be kept in sync with
+// the shape built by newMergeTree.
+func (mi *MergeIterator) bind(iters []y.Iterator) {
+	if mi.numIters == 2 {
 		mi.left.setIterator(iters[0])
 		mi.right.setIterator(iters[1])
-		// Assign left iterator randomly. This will be fixed when user calls rewind/seek.
-		mi.small = &mi.left
-		return mi
+		return
+	}
+	mid := mi.numIters / 2
+	if mid == 1 {
+		mi.left.setIterator(iters[0])
+	} else {
+		mi.left.merge.bind(iters[:mid])
+	}
+	mi.right.merge.bind(iters[mid:])
+}
+
+// setReverse sets the direction of the whole tree.
+func (mi *MergeIterator) setReverse(reverse bool) {
+	mi.reverse = reverse
+	if mi.numIters == 2 {
+		return
+	}
+	if mi.numIters/2 > 1 {
+		mi.left.merge.setReverse(reverse)
+	}
+	mi.right.merge.setReverse(reverse)
+}
+
+// settle recomputes the cached keys of the tree from the current position of
+// the source iterators, bottom up, and picks the smallest node at every level.
+func (mi *MergeIterator) settle() {
+	if mi.numIters > 2 {
+		if mi.numIters/2 > 1 {
+			mi.left.merge.settle()
+		}
+		mi.right.merge.settle()
+	}
+	mi.err = nil
+	mi.conflicts = 0
+	mi.left.setKey()
+	mi.right.setKey()
+	mi.small = &mi.left
+	mi.fix()
+	mi.check()
+}
+
+// Reset rebinds the MergeIterator to a new set of source iterators without
+// allocating a new tree. The number of iterators must match the number the
+// MergeIterator was created for. After Reset, Valid and Key reflect the current
+// position of the given iterators, so callers that pass already positioned
+// iterators don't need to call Rewind or Seek.
+//
+// The MergeIterator takes ownership of the new iterators. The previous ones are
+// not closed by Reset. The bound set by SetBound, if any, is kept.
+func (mi *MergeIterator) Reset(iters []y.Iterator, reverse bool) error {
+	if len(iters) != mi.numIters {
+		return errors.Errorf("MergeIterator: cannot reset a merge of %d iterators with %d iterators",
+			mi.numIters, len(iters))
+	}
+	mi.bind(iters)
+	mi.setReverse(reverse)
+	if mi.bound != nil {
+		// Pass the bound down to the new iterators.
+		mi.SetBound(mi.bound)
+	}
+	mi.settle()
+	return nil
+}
+
+// release drops the references to the source iterators, so that a pooled tree
+// doesn't keep them alive.
+func (mi *MergeIterator) release() {
+	if mi.numIters == 2 {
+		mi.left.setIterator(nil)
+		mi.right.setIterator(nil)
+	} else {
+		if mi.numIters/2 > 1 {
+			mi.left.merge.release()
+		} else {
+			mi.left.setIterator(nil)
+		}
+		mi.right.merge.release()
+	}
+	mi.left.valid, mi.left.key, mi.left.err = false, nil, nil
+	mi.right.valid, mi.right.key, mi.right.err = false, nil, nil
+	mi.small = &mi.left
+	mi.bound = nil
+	mi.err = nil
+}
+
+// MergeIteratorPool keeps MergeIterator trees alive between uses, so that
+// callers creating many short lived merge iterators don't need to allocate a
+// new tree every time. Trees are pooled by the number of source iterators.
+// A MergeIteratorPool is safe for concurrent use.
+type MergeIteratorPool struct {
+	pools sync.Map // int -> *sync.Pool
+}
+
+func (p *MergeIteratorPool) pool(n int) *sync.Pool {
+	if sp, ok := p.pools.Load(n); ok {
+		return sp.(*sync.Pool)
+	}
+	sp, _ := p.pools.LoadOrStore(n, &sync.Pool{
+		New: func() interface{} {
+			return newMergeTree(n, false)
+		},
+	})
+	return sp.(*sync.Pool)
+}
+
+// Get returns an iterator merging iters, reusing a pooled tree if one is
+// available. Like NewMergeIterator, it returns nil for no iterators and the
+// iterator itself if there's only one.
+func (p *MergeIteratorPool) Get(iters []y.Iterator, reverse bool) y.Iterator {
+	if len(iters) == 0 {
+		return nil
+	} else if len(iters) == 1 {
+		return iters[0]
+	}
+	mi := p.pool(len(iters)).Get().(*MergeIterator)
+	y.Check(mi.Reset(iters, reverse))
+	return mi
+}
+
+// Put returns a MergeIterator obtained from Get to the pool. The iterator must
+// have been closed already, and must not be used after Put. Iterators that
+// aren't MergeIterators are ignored.
+func (p *MergeIteratorPool) Put(it y.Iterator) {
+	mi, ok := it.(*MergeIterator)
+	if !ok || mi.numIters < 2 {
+		return
 	}
-	mid := len(iters) / 2
-	return NewMergeIterator(
-		[]y.Iterator{
-			NewMergeIterator(iters[:mid], reverse),
-			NewMergeIterator(iters[mid:], reverse),
-		}, reverse)
+	mi.release()
+	p.pool(mi.numIters).Put(mi)
 }
@@ -17,6 +17,7 @@
 package table
 
 import (
+	"fmt"
 	"sort"
 	"testing"
 
@@ -53,6 +54,9 @@ func (s *SimpleIterator) Rewind() {
 	}
 }
 
+func (s *SimpleIterator) SeekToFirst() { s.idx = 0 }
+func (s *SimpleIterator) SeekToLast()  { s.idx = len(s.keys) - 1 }
+
 func (s *SimpleIterator) Seek(key []byte) {
 	key = y.KeyWithTs(key, 0)
 	if !s.reversed {
@@ -79,6 +83,18 @@ func (s *SimpleIterator) Valid() bool {
 	return s.idx >= 0 && s.idx < len(s.keys)
 }
 
+// Peek implements y.Peeker.
+func (s *SimpleIterator) Peek() ([]byte, bool) {
+	i := s.idx + 1
+	if s.reversed {
+		i = s.idx - 1
+	}
+	if !s.Valid() || i < 0 || i >= len(s.keys) {
+		return nil, false
+	}
+	return s.keys[i], true
+}
+
 var _ y.Iterator = &SimpleIterator{}
 
 func newSimpleIterator(keys []string, vals []string, reversed bool) *SimpleIterator {
@@ -304,3 +320,262 @@ func TestMergeIteratorDuplicate(t *testing.T) {
 		require.Equal(t, expectedVals, v)
 	})
 }
+
+func TestMergeIteratorReset(t *testing.T) {
+	newIters := func(reversed bool) []y.Iterator {
+		return []y.Iterator{
+			newSimpleIterator([]string{"1", "3", "7"}, []string{"a1", "a3", "a7"}, reversed),
+			newSimpleIterator([]string{"2", "3", "5"}, []string{"b2", "b3", "b5"}, reversed),
+			newSimpleIterator([]string{"1"}, []string{"c1"}, reversed),
+		}
+	}
+	mi := NewMergeIterator(newIters(false), false).(*MergeIterator)
+	mi.Rewind()
+	k, _ := getAll(mi)
+	require.Equal(t, []string{"1", "2", "3", "5", "7"}, k)
+
+	t.Run("forward", func(t *testing.T) {
+		iters := newIters(false)
+		for _, it := range iters {
+			it.Rewind()
+		}
+		require.NoError(t, mi.Reset(iters, false))
+		// No Rewind required, the iterators are already positioned.
+		require.True(t, mi.Valid())
+		k, v := getAll(mi)
+		require.Equal(t, []string{"1", "2", "3", "5", "7"}, k)
+		require.Equal(t, []string{"a1", "b2", "a3", "b5", "a7"}, v)
+	})
+	t.Run("reverse", func(t *testing.T) {
+		require.NoError(t, mi.Reset(newIters(true), true))
+		mi.Rewind()
+		k, v := getAll(mi)
+		require.Equal(t, []string{"7", "5", "3", "2", "1"}, k)
+		require.Equal(t, []string{"a7", "b5", "a3", "b2", "a1"}, v)
+	})
+	t.Run("arity mismatch", func(t *testing.T) {
+		require.Error(t, mi.Reset(newIters(false)[:2], false))
+	})
+}
+
+func TestMergeIteratorPool(t *testing.T) {
+	var pool MergeIteratorPool
+	for i := 0; i < 3; i++ {
+		it1 := newSimpleIterator([]string{"1", "3"}, []string{"a1", "a3"}, false)
+		it2 := newSimpleIterator([]string{"2", "3"}, []string{"b2", "b3"}, false)
+		mi := pool.Get([]y.Iterator{it1, it2}, false)
+		mi.Rewind()
+		k, v := getAll(mi)
+		require.Equal(t, []string{"1", "2", "3"}, k)
+		require.Equal(t, []string{"a1", "b2", "a3"}, v)
+		closeAndCheck(t, mi, 2)
+		pool.Put(mi)
+	}
+}
+
+func TestMergeIteratorCurrentIndex(t *testing.T) {
+	// The value of every key is prefixed with the index of its iterator.
+	newIters := func(reversed bool) []y.Iterator {
+		return []y.Iterator{
+			newSimpleIterator([]string{"1", "3", "7"}, []string{"0", "0", "0"}, reversed),
+			newSimpleIterator([]string{"2", "3", "5"}, []string{"1", "1", "1"}, reversed),
+			newSimpleIterator([]string{"1", "4"}, []string{"2", "2"}, reversed),
+			newSimpleIterator([]string{"1", "7", "9"}, []string{"3", "3", "3"}, reversed),
+			newSimpleIterator([]string{"0", "9"}, []string{"4", "4"}, reversed),
+		}
+	}
+	check := func(t *testing.T, it interface {
+		y.Iterator
+		CurrentIndex() int
+	}) {
+		var n int
+		for ; it.Valid(); it.Next() {
+			require.Equal(t, string(it.Value().Value), fmt.Sprintf("%d", it.CurrentIndex()))
+			n++
+		}
+		require.True(t, n > 0)
+		require.Equal(t, -1, it.CurrentIndex())
+	}
+	for _, reverse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reverse=%v", reverse), func(t *testing.T) {
+			mi := NewMergeIterator(newIters(reverse), reverse).(*MergeIterator)
+			mi.Rewind()
+			check(t, mi)
+			mi.Seek([]byte("3"))
+			check(t, mi)
+
+			hi := NewHeapMergeIterator(newIters(reverse), reverse).(*HeapMergeIterator)
+			hi.Rewind()
+			check(t, hi)
+			hi.Seek([]byte("3"))
+			check(t, hi)
+		})
+	}
+}
+
+// checkPeek walks it and verifies that Peek always returns the key the following
+// Next moves to.
+func checkPeek(t *testing.T, it interface {
+	y.Iterator
+	y.Peeker
+}) int {
+	var n int
+	for ; it.Valid(); n++ {
+		peeked, ok := it.Peek()
+		// Peek must not change anything.
+		peeked2, ok2 := it.Peek()
+		require.Equal(t, ok, ok2)
+		require.Equal(t, peeked, peeked2)
+		peeked = y.Copy(peeked)
+		it.Next()
+		require.Equal(t, it.Valid(), ok)
+		if ok {
+			require.Equal(t, it.Key(), peeked)
+		}
+	}
+	_, ok := it.Peek()
+	require.False(t, ok)
+	return n
+}
+
+func TestMergeIteratorPeek(t *testing.T) {
+	newIters := func(reversed bool) []y.Iterator {
+		return []y.Iterator{
+			newSimpleIterator([]string{"1", "3", "7"}, []string{"a1", "a3", "a7"}, reversed),
+			newSimpleIterator([]string{"2", "3", "5"}, []string{"b2", "b3", "b5"}, reversed),
+			newSimpleIterator([]string{"1"}, []string{"c1"}, reversed),
+			newSimpleIterator([]string{"1", "7", "9"}, []string{"d1", "d7", "d9"}, reversed),
+		}
+	}
+	for _, reverse := range []bool{false, true} {
+		mi := NewMergeIterator(newIters(reverse), reverse).(*MergeIterator)
+		mi.Rewind()
+		require.Equal(t, 6, checkPeek(t, mi))
+
+		hi := NewHeapMergeIterator(newIters(reverse), reverse).(*HeapMergeIterator)
+		hi.Rewind()
+		require.Equal(t, 6, checkPeek(t, hi))
+	}
+}
+
+func TestMergeIteratorFunc(t *testing.T) {
+	newIters := func(reversed bool) []y.Iterator {
+		return []y.Iterator{
+			newSimpleIterator([]string{"1", "3", "7"}, []string{"a", "aaa", "a"}, reversed),
+			newSimpleIterator([]string{"2", "3", "5"}, []string{"bb", "b", "b"}, reversed),
+			newSimpleIterator([]string{"1"}, []string{"ccc"}, reversed),
+			newSimpleIterator([]string{"1", "3", "7", "9"}, []string{"dd", "dddd", "dd", "d"}, reversed),
+		}
+	}
+	var calls int
+	// Keep the longest value.
+	longest := func(a, b y.ValueStruct) bool {
+		calls++
+		return len(a.Value) >= len(b.Value)
+	}
+	t.Run("forward", func(t *testing.T) {
+		calls = 0
+		it := NewMergeIteratorFunc(newIters(false), false, longest)
+		it.Rewind()
+		k, v := getAll(it)
+		require.Equal(t, []string{"1", "2", "3", "5", "7", "9"}, k)
+		require.Equal(t, []string{"ccc", "bb", "dddd", "b", "dd", "d"}, v)
+		// Key 1 and 3 are in three iterators, key 7 in two.
+		require.Equal(t, 5, calls)
+	})
+	t.Run("reverse", func(t *testing.T) {
+		it := NewMergeIteratorFunc(newIters(true), true, longest)
+		it.Rewind()
+		k, v := getAll(it)
+		require.Equal(t, []string{"9", "7", "5", "3", "2", "1"}, k)
+		require.Equal(t, []string{"d", "dd", "b", "dddd", "bb", "ccc"}, v)
+	})
+	t.Run("default", func(t *testing.T) {
+		it1 := NewMergeIteratorFunc(newIters(false), false, nil)
+		it2 := NewMergeIterator(newIters(false), false)
+		it1.Rewind()
+		it2.Rewind()
+		k1, v1 := getAll(it1)
+		k2, v2 := getAll(it2)
+		require.Equal(t, k2, k1)
+		require.Equal(t, v2, v1)
+	})
+}
+
+func TestMergeIteratorConflictsResolved(t *testing.T) {
+	newIters := func() []y.Iterator {
+		return []y.Iterator{
+			newSimpleIterator([]string{"1", "3", "7"}, []string{"a1", "a3", "a7"}, false),
+			newSimpleIterator([]string{"2", "3", "5"}, []string{"b2", "b3", "b5"}, false),
+			newSimpleIterator([]string{"1"}, []string{"c1"}, false),
+			newSimpleIterator([]string{"1", "7", "9"}, []string{"d1", "d7", "d9"}, false),
+		}
+	}
+	// Key 1 is in three iterators, keys 3 and 7 in two.
+	const expected = 4
+	for _, it := range []interface {
+		y.Iterator
+		ConflictsResolved() uint64
+	}{
+		NewMergeIterator(newIters(), false).(*MergeIterator),
+		NewHeapMergeIterator(newIters(), false).(*HeapMergeIterator),
+	} {
+		it.Rewind()
+		getAll(it)
+		require.EqualValues(t, expected, it.ConflictsResolved())
+		it.Seek([]byte("1"))
+		getAll(it)
+		require.EqualValues(t, 2*expected, it.ConflictsResolved())
+		// Rewind lands on key 1, skipping its two duplicates.
+		it.Rewind()
+		require.EqualValues(t, 2, it.ConflictsResolved())
+	}
+
+	// The conflicts resolved by nested merge iterators are included.
+	nested := NewMergeIterator([]y.Iterator{
+		NewMergeIterator(newIters(), false),
+		newSimpleIterator([]string{"3", "4"}, []string{"e3", "e4"}, false),
+	}, false).(*MergeIterator)
+	nested.Rewind()
+	getAll(nested)
+	require.EqualValues(t, expected+1, nested.ConflictsResolved())
+}
+
+func TestMergeIteratorSeekToFirstLast(t *testing.T) {
+	newIters := func(reversed bool) []y.Iterator {
+		return []y.Iterator{
+			newSimpleIterator([]string{"1", "3", "7"}, []string{"a1", "a3", "a7"}, reversed),
+			newSimpleIterator([]string{"2", "3", "5"}, []string{"b2", "b3", "b5"}, reversed),
+			newSimpleIterator([]string{"1"}, []string{"c1"}, reversed),
+			newSimpleIterator([]string{"1", "7", "9"}, []string{"d1", "d7", "d9"}, reversed),
+			newSimpleIterator([]string{"0", "9"}, []string{"e0", "e9"}, reversed),
+		}
+	}
+	all := []string{"0", "1", "2", "3", "5", "7", "9"}
+	constructors := map[string]func([]y.Iterator, bool) y.Iterator{
+		"tree": NewMergeIterator,
+		"heap": NewHeapMergeIterator,
+	}
+	for name, fn := range constructors {
+		t.Run(name, func(t *testing.T) {
+			it := fn(newIters(false), false)
+			it.SeekToFirst()
+			k, v := getAll(it)
+			require.Equal(t, all, k)
+			require.Equal(t, "a1", v[1])
+			it.SeekToLast()
+			k, v = getAll(it)
+			require.Equal(t, []string{"9"}, k)
+			require.Equal(t, []string{"d9"}, v)
+
+			it = fn(newIters(true), true)
+			it.SeekToLast()
+			k, _ = getAll(it)
+			require.Equal(t, reversed(all), k)
+			it.SeekToFirst()
+			k, v = getAll(it)
+			require.Equal(t, []string{"0"}, k)
+			require.Equal(t, []string{"e0"}, v)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package table
+
+import (
+	"bytes"
+	"math"
+	"sort"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// sampleIterator seeks to the next sample key each time the iterator it wraps moves past all the
+// versions of a key.
+type sampleIterator struct {
+	y.Iterator
+	samples  [][]byte // Sorted keys, without timestamp.
+	reversed bool
+	cmp      y.Comparator
+	done     bool   // Set once there are no samples left in the direction of iteration.
+	key      []byte // Key the iterator was at before the last Next, without timestamp.
+}
+
+// NewSampleIterator returns an iterator which only visits the keys of it at or after each of the
+// given sample keys. Once it moves past all the versions of a key, it seeks to the next sample
+// key, instead of stepping over the keys in between. The samples are keys without timestamp, and
+// must be sorted in increasing order of cmp, the order of it, whatever the direction of iteration.
+//
+// The returned iterator owns it, and closes it on Close.
+func NewSampleIterator(it y.Iterator, samples [][]byte, reversed bool,
+	cmp y.Comparator) y.Iterator {
+	return &sampleIterator{Iterator: it, samples: samples, reversed: reversed, cmp: cmp}
+}
+
+func (it *sampleIterator) Next() {
+	it.key = y.SafeCopy(it.key, y.ParseKey(it.Iterator.Key()))
+	it.Iterator.Next()
+	if !it.Iterator.Valid() {
+		return
+	}
+	key := y.ParseKey(it.Iterator.Key())
+	if bytes.Equal(key, it.key) {
+		return // Still on the versions of the same key.
+	}
+	if !it.reversed {
+		i := sort.Search(len(it.samples), func(i int) bool {
+			return it.cmp.Compare(it.samples[i], it.key) > 0
+		})
+		if i == len(it.samples) {
+			it.done = true
+		} else if it.cmp.Compare(it.samples[i], key) > 0 {
+			it.Iterator.Seek(y.KeyWithTs(it.samples[i], math.MaxUint64))
+		}
+		return
+	}
+	i := sort.Search(len(it.samples), func(i int) bool {
+		return it.cmp.Compare(it.samples[i], it.key) >= 0
+	}) - 1
+	if i < 0 {
+		it.done = true
+	} else if it.cmp.Compare(it.samples[i], key) < 0 {
+		it.Iterator.Seek(y.KeyWithTs(it.samples[i], 0))
+	}
+}
+
+func (it *sampleIterator) Rewind() {
+	it.done = false
+	it.Iterator.Rewind()
+}
+
+func (it *sampleIterator) Seek(key []byte) {
+	it.done = false
+	it.Iterator.Seek(key)
+}
+
+func (it *sampleIterator) SeekToFirst() {
+	it.done = false
+	it.Iterator.SeekToFirst()
+}
+
+func (it *sampleIterator) SeekToLast() {
+	it.done = false
+	it.Iterator.SeekToLast()
+}
+
+func (it *sampleIterator) Valid() bool {
+	return !it.done && it.Iterator.Valid()
+}
@@ -17,6 +17,8 @@
 package table
 
 import (
+	"bytes"
+	"container/list"
 	"crypto/aes"
 	"fmt"
 	"io"
@@ -28,8 +30,10 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
+	"github.com/dgryski/go-farm"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/snappy"
 	"github.com/pkg/errors"
@@ -37,7 +41,6 @@ import (
 	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/y"
-	"github.com/dgraph-io/ristretto"
 	"github.com/dgraph-io/ristretto/z"
 )
 
@@ -65,13 +68,42 @@ type Options struct {
 	// DataKey is the key used to decrypt the encrypted text.
 	DataKey *pb.DataKey
 
+	// ChecksumAlgorithm is the algorithm used to checksum blocks and the index.
+	ChecksumAlgorithm options.ChecksumAlgorithm
+
 	// Compression indicates the compression algorithm used for block compression.
 	Compression options.CompressionType
 
-	Cache *ristretto.Cache
+	// Cache is the cache of decoded blocks. Blocks are not cached if it is nil.
+	Cache Cache
+
+	// IndexCache is the cache of table indices. If it is nil, the index is kept in memory for as
+	// long as the table is open, otherwise it is read again from the table on a cache miss.
+	IndexCache Cache
+
+	// CacheID distinguishes the tables of different DBs sharing Cache or IndexCache, whose table
+	// IDs overlap. It is part of every key the table uses in the caches.
+	CacheID uint64
 
 	// ZSTDCompressionLevel is the ZSTD compression level used for compressing blocks.
 	ZSTDCompressionLevel int
+
+	// ZSTDDictionarySize is the maximum size of the ZSTD dictionary trained from the values of the
+	// table, against which all its blocks are compressed. Zero disables dictionaries.
+	ZSTDDictionarySize int
+
+	// Comparator is the order of the keys in the table, which its iterators rely on. Nil stands
+	// for the default order.
+	Comparator y.Comparator
+
+	// Deterministic makes the builder derive the IVs of the encrypted blocks and index from their
+	// content instead of drawing them at random, so that the same entries always build the same
+	// table.
+	Deterministic bool
+
+	// FileCache bounds the number of table files open at once. All the files stay open if it is
+	// nil.
+	FileCache *FileCache
 }
 
 // TableInterface is useful for testing.
@@ -85,11 +117,14 @@ type TableInterface interface {
 type Table struct {
 	sync.Mutex
 
-	fd        *os.File // Own fd.
-	tableSize int      // Initialized in OpenTable, using fd.Stat().
+	fdLock    sync.RWMutex // Guards fd, which opt.FileCache closes. Use file() to read it.
+	fd        *os.File     // Own fd.
+	filename  string
+	fileElem  *list.Element // In the LRU of opt.FileCache while fd is open. Guarded by its lock.
+	tableSize int           // Initialized in OpenTable, using fd.Stat().
 
-	blockIndex []*pb.BlockOffset
-	ref        int32 // For file garbage collection. Atomic.
+	index *tableIndex // Nil if opt.IndexCache is set. Use fetchIndex instead.
+	ref   int32       // For file garbage collection. Atomic.
 
 	mmap []byte // Memory mapped.
 
@@ -97,13 +132,21 @@ type Table struct {
 	smallest, biggest []byte // Smallest and largest keys (with timestamps).
 	id                uint64 // file id, part of filename
 
-	bf       *z.Bloom
 	Checksum []byte
 	// Stores the total size of key-values stored in this table (including the size on vlog).
 	estimatedSize uint64
+	// Number of entries in the table. Zero for tables built before it was stored in the index.
+	keyCount uint64
+	// False positive probability of bf. Zero for tables built before it was stored in the index.
+	bloomFalsePositive float64
+	// ZSTD dictionary the blocks are compressed against. Nil if the table has no dictionary.
+	zstdDict    []byte
+	zstdDecoder *y.ZSTDDDict // Decompresses the blocks against zstdDict.
 
 	IsInmemory bool // Set to true if the table is on level 0 and opened in memory.
 	opt        *Options
+
+	createdAt time.Time // Modification time of the file, or opening time of an in-memory table.
 }
 
 // CompressionType returns the compression algorithm used for block compression.
@@ -132,18 +175,33 @@ func (t *Table) DecrRef() error {
 		}
 		// fd can be nil if the table belongs to L0 and it is opened in memory. See
 		// OpenTableInMemory method.
-		if t.fd == nil {
+		if t.IsInmemory {
 			return nil
 		}
+		// Take the file out of the FileCache first, so that no eviction closes it meanwhile.
+		if t.opt.FileCache != nil {
+			t.opt.FileCache.remove(t)
+		}
+		t.fdLock.Lock()
+		defer t.fdLock.Unlock()
+		if t.fd == nil {
+			// The FileCache closed the file.
+			fd, err := y.OpenExistingFile(t.filename, 0)
+			if err != nil {
+				return y.Wrapf(err, "while reopening table: %s", t.filename)
+			}
+			t.fd = fd
+		}
 		if err := t.fd.Truncate(0); err != nil {
 			// This is very important to let the FS know that the file is deleted.
 			return err
 		}
-		filename := t.fd.Name()
-		if err := t.fd.Close(); err != nil {
+		err := t.fd.Close()
+		t.fd = nil
+		if err != nil {
 			return err
 		}
-		if err := os.Remove(filename); err != nil {
+		if err := os.Remove(t.filename); err != nil {
 			return err
 		}
 	}
@@ -165,11 +223,38 @@ func (b *block) size() int64 {
 }
 
 func (b block) verifyCheckSum() error {
+	y.NumBlockChecksumVerifications.Add(1)
 	cs := &pb.Checksum{}
-	if err := proto.Unmarshal(b.checksum, cs); err != nil {
-		return y.Wrapf(err, "unable to unmarshal checksum for block")
+	err := proto.Unmarshal(b.checksum, cs)
+	if err != nil {
+		err = y.Wrapf(err, "unable to unmarshal checksum for block")
+	} else {
+		err = y.VerifyChecksum(b.data, cs)
 	}
-	return y.VerifyChecksum(b.data, cs)
+	if err != nil {
+		y.NumBlockChecksumMismatches.Add(1)
+	}
+	return err
+}
+
+// verifyOnTableRead returns true if the checksums of the blocks are verified when the table is
+// opened.
+func (o *Options) verifyOnTableRead() bool {
+	return o.ChkMode == options.OnTableRead || o.ChkMode == options.OnTableAndBlockRead ||
+		o.ChkMode == options.OnEveryBlockRead
+}
+
+// verifyOnBlockRead returns true if the checksum of a block is verified when the block is read.
+func (o *Options) verifyOnBlockRead() bool {
+	return o.ChkMode == options.OnBlockRead || o.ChkMode == options.OnTableAndBlockRead ||
+		o.ChkMode == options.OnEveryBlockRead
+}
+
+// useBlockCache returns true if the blocks are read from and added to the block cache. The
+// blocks are never cached with OnEveryBlockRead, so that each read verifies the block read from
+// the table.
+func (o *Options) useBlockCache() bool {
+	return o.Cache != nil && o.ChkMode != options.OnEveryBlockRead
 }
 
 // OpenTable assumes file has only one table and opens it. Takes ownership of fd upon function
@@ -193,10 +278,12 @@ func OpenTable(fd *os.File, opts Options) (*Table, error) {
 	}
 	t := &Table{
 		fd:         fd,
+		filename:   fd.Name(),
 		ref:        1, // Caller is given one reference.
 		id:         id,
 		opt:        &opts,
 		IsInmemory: false,
+		createdAt:  fileInfo.ModTime(),
 	}
 
 	t.tableSize = int(fileInfo.Size())
@@ -232,13 +319,15 @@ func OpenTable(fd *os.File, opts Options) (*Table, error) {
 	if err := t.initBiggestAndSmallest(); err != nil {
 		return nil, errors.Wrapf(err, "failed to initialize table")
 	}
-	if opts.ChkMode == options.OnTableRead || opts.ChkMode == options.OnTableAndBlockRead {
+	if opts.verifyOnTableRead() {
 		if err := t.VerifyChecksum(); err != nil {
 			_ = fd.Close()
 			return nil, errors.Wrapf(err, "failed to verify checksum")
 		}
 	}
-
+	if opts.FileCache != nil {
+		opts.FileCache.add(t)
+	}
 	return t, nil
 }
 
@@ -253,6 +342,7 @@ func OpenInMemoryTable(data []byte, id uint64, opt *Options) (*Table, error) {
 		tableSize:  len(data),
 		IsInmemory: true,
 		id:         id, // It is important that each table gets a unique ID.
+		createdAt:  time.Now(),
 	}
 
 	if err := t.initBiggestAndSmallest(); err != nil {
@@ -262,11 +352,17 @@ func OpenInMemoryTable(data []byte, id uint64, opt *Options) (*Table, error) {
 }
 
 func (t *Table) initBiggestAndSmallest() error {
-	if err := t.readIndex(); err != nil {
+	index, err := t.readIndex()
+	if err != nil {
 		return errors.Wrapf(err, "failed to read index.")
 	}
+	if t.opt.IndexCache == nil {
+		t.index = index
+	} else {
+		t.opt.IndexCache.Set(t.indexCacheKey(), index, index.size)
+	}
 
-	t.smallest = t.blockIndex[0].Key
+	t.smallest = index.offsets[0].Key
 
 	it2 := t.NewIterator(true)
 	defer it2.Close()
@@ -286,10 +382,22 @@ func (t *Table) Close() error {
 		}
 		t.mmap = nil
 	}
+	return t.closeFile()
+}
+
+// closeFile closes the file of the table, if it's open.
+func (t *Table) closeFile() error {
+	if t.opt.FileCache != nil {
+		t.opt.FileCache.remove(t)
+	}
+	t.fdLock.Lock()
+	defer t.fdLock.Unlock()
 	if t.fd == nil {
 		return nil
 	}
-	return t.fd.Close()
+	err := t.fd.Close()
+	t.fd = nil
+	return err
 }
 
 func (t *Table) read(off, sz int) ([]byte, error) {
@@ -300,8 +408,13 @@ func (t *Table) read(off, sz int) ([]byte, error) {
 		return t.mmap[off : off+sz], nil
 	}
 
+	fd, release, err := t.file()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	res := make([]byte, sz)
-	nbr, err := t.fd.ReadAt(res, int64(off))
+	nbr, err := fd.ReadAt(res, int64(off))
 	y.NumReads.Add(1)
 	y.NumBytesRead.Add(int64(nbr))
 	return res, err
@@ -313,20 +426,31 @@ func (t *Table) readNoFail(off, sz int) []byte {
 	return res
 }
 
-func (t *Table) readIndex() error {
+// readIndexData reads the index of the table, and verifies its checksum. It returns the index,
+// which is still encrypted if the table is, along with its offset in the table.
+func (t *Table) readIndexData() ([]byte, int, error) {
 	readPos := t.tableSize
+	errInvalid := func() error {
+		return errors.Errorf("invalid table footer for table: %s", t.Filename())
+	}
 
 	// Read checksum len from the last 4 bytes.
 	readPos -= 4
+	if readPos < 0 {
+		return nil, t.tableSize - 4, errInvalid()
+	}
 	buf := t.readNoFail(readPos, 4)
 	checksumLen := int(y.BytesToU32(buf))
 
 	// Read checksum.
 	expectedChk := &pb.Checksum{}
 	readPos -= checksumLen
+	if readPos < 4 {
+		return nil, t.tableSize - 4, errInvalid()
+	}
 	buf = t.readNoFail(readPos, checksumLen)
 	if err := proto.Unmarshal(buf, expectedChk); err != nil {
-		return err
+		return nil, readPos, err
 	}
 
 	// Read index size from the footer.
@@ -335,50 +459,112 @@ func (t *Table) readIndex() error {
 	indexLen := int(y.BytesToU32(buf))
 	// Read index.
 	readPos -= indexLen
+	if readPos < 0 {
+		return nil, t.tableSize - 4, errInvalid()
+	}
 	data := t.readNoFail(readPos, indexLen)
 
 	if err := y.VerifyChecksum(data, expectedChk); err != nil {
-		return y.Wrapf(err, "failed to verify checksum for table: %s", t.Filename())
+		return nil, readPos, y.Wrapf(err, "failed to verify checksum for table: %s", t.Filename())
 	}
+	return data, readPos, nil
+}
+
+// readIndex reads the index of the table, sets the fields of the table which are always kept in
+// memory and returns the block offsets and the bloom filter.
+func (t *Table) readIndex() (*tableIndex, error) {
+	data, _, err := t.readIndexData()
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(data))
 
 	index := pb.TableIndex{}
 	// Decrypt the table index if it is encrypted.
 	if t.shouldDecrypt() {
-		var err error
 		if data, err = t.decrypt(data); err != nil {
-			return y.Wrapf(err,
+			return nil, y.Wrapf(err,
 				"Error while decrypting table index for the table %d in Table.readIndex", t.id)
 		}
 	}
-	err := proto.Unmarshal(data, &index)
+	err = proto.Unmarshal(data, &index)
 	y.Check(err)
 
 	t.estimatedSize = index.EstimatedSize
-	t.bf = z.JSONUnmarshal(index.BloomFilter)
-	t.blockIndex = index.Offsets
-	return nil
+	t.keyCount = index.KeyCount
+	t.bloomFalsePositive = index.BloomFalsePositive
+	// The index is read again on index cache misses, the dictionary is only loaded once, when the
+	// table is opened.
+	if t.zstdDecoder == nil && len(index.ZstdDictionary) > 0 {
+		if t.zstdDecoder, err = y.NewZSTDDDict(index.ZstdDictionary); err != nil {
+			return nil, y.Wrapf(err, "Error while loading the ZSTD dictionary of the table %d", t.id)
+		}
+		t.zstdDict = index.ZstdDictionary
+	}
+	return &tableIndex{
+		offsets: index.Offsets,
+		bf:      z.JSONUnmarshal(index.BloomFilter),
+		size:    size,
+	}, nil
+}
+
+// fetchIndex returns the block offsets and the bloom filter of the table, from the index cache if
+// Options.IndexCache is set. On a cache miss, the index is read again from the table.
+func (t *Table) fetchIndex() *tableIndex {
+	if t.opt.IndexCache == nil {
+		return t.index
+	}
+	key := t.indexCacheKey()
+	if index, ok := t.opt.IndexCache.Get(key); ok && index != nil {
+		return index.(*tableIndex)
+	}
+	// The index was read successfully when the table was opened.
+	index, err := t.readIndex()
+	y.Check(err)
+	t.opt.IndexCache.Set(key, index, index.size)
+	return index
 }
 
 func (t *Table) block(idx int) (*block, error) {
 	y.AssertTruef(idx >= 0, "idx=%d", idx)
-	if idx >= len(t.blockIndex) {
+	if idx >= len(t.fetchIndex().offsets) {
 		return nil, errors.New("block out of index")
 	}
-	if t.opt.Cache != nil {
+	if t.opt.useBlockCache() {
 		key := t.blockCacheKey(idx)
 		blk, ok := t.opt.Cache.Get(key)
 		if ok && blk != nil {
 			return blk.(*block), nil
 		}
 	}
-	ko := t.blockIndex[idx]
+	blk, err := t.readBlock(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify checksum on if checksum verification mode is OnRead on OnStartAndRead.
+	if t.opt.verifyOnBlockRead() {
+		if err = blk.verifyCheckSum(); err != nil {
+			return nil, err
+		}
+	}
+	if t.opt.useBlockCache() {
+		key := t.blockCacheKey(idx)
+		t.opt.Cache.Set(key, blk, blk.size())
+	}
+	return blk, nil
+}
+
+// readBlock reads the block at idx from the table file, without verifying its checksum.
+func (t *Table) readBlock(idx int) (*block, error) {
+	ko := t.fetchIndex().offsets[idx]
 	blk := &block{
 		offset: int(ko.Offset),
 	}
 	var err error
 	if blk.data, err = t.read(blk.offset, int(ko.Len)); err != nil {
 		return nil, errors.Wrapf(err,
-			"failed to read from file: %s at offset: %d, len: %d", t.fd.Name(), blk.offset, ko.Len)
+			"failed to read from file: %s at offset: %d, len: %d", t.Filename(), blk.offset, ko.Len)
 	}
 
 	if t.shouldDecrypt() {
@@ -392,11 +578,15 @@ func (t *Table) block(idx int) (*block, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err,
 			"failed to decode compressed data in file: %s at offset: %d, len: %d",
-			t.fd.Name(), blk.offset, ko.Len)
+			t.Filename(), blk.offset, ko.Len)
 	}
 
 	// Read meta data related to block.
 	readPos := len(blk.data) - 4 // First read checksum length.
+	if readPos < 0 {
+		return nil, errors.New("invalid block size. Either the data is " +
+			"corrupted or the table options are incorrectly set")
+	}
 	blk.chkLen = int(y.BytesToU32(blk.data[readPos : readPos+4]))
 
 	// Checksum length greater than block size could happen if the table was compressed and
@@ -411,9 +601,17 @@ func (t *Table) block(idx int) (*block, error) {
 	blk.checksum = blk.data[readPos : readPos+blk.chkLen]
 	// Move back and read numEntries in the block.
 	readPos -= 4
+	if readPos < 0 {
+		return nil, errors.New("invalid checksum length. Either the data is " +
+			"corrupted or the table options are incorrectly set")
+	}
 	numEntries := int(y.BytesToU32(blk.data[readPos : readPos+4]))
 	entriesIndexStart := readPos - (numEntries * 4)
 	entriesIndexEnd := entriesIndexStart + numEntries*4
+	if entriesIndexStart < 0 {
+		return nil, errors.New("invalid number of entries. Either the data is " +
+			"corrupted or the table options are incorrectly set")
+	}
 
 	blk.entryOffsets = y.BytesToU32Slice(blk.data[entriesIndexStart:entriesIndexEnd])
 
@@ -422,26 +620,9 @@ func (t *Table) block(idx int) (*block, error) {
 	// Drop checksum and checksum length.
 	// The checksum is calculated for actual data + entry index + index length
 	blk.data = blk.data[:readPos+4]
-
-	// Verify checksum on if checksum verification mode is OnRead on OnStartAndRead.
-	if t.opt.ChkMode == options.OnBlockRead || t.opt.ChkMode == options.OnTableAndBlockRead {
-		if err = blk.verifyCheckSum(); err != nil {
-			return nil, err
-		}
-	}
-	if t.opt.Cache != nil {
-		key := t.blockCacheKey(idx)
-		t.opt.Cache.Set(key, blk, blk.size())
-	}
 	return blk, nil
 }
 
-func (t *Table) blockCacheKey(idx int) uint64 {
-	y.AssertTrue(t.ID() < math.MaxUint32)
-	y.AssertTrue(uint32(idx) < math.MaxUint32)
-	return (t.ID() << 32) | uint64(idx)
-}
-
 // EstimatedSize returns the total size of key-values stored in this table (including the
 // disk space occupied on the value log).
 func (t *Table) EstimatedSize() uint64 { return t.estimatedSize }
@@ -449,6 +630,141 @@ func (t *Table) EstimatedSize() uint64 { return t.estimatedSize }
 // Size is its file size in bytes
 func (t *Table) Size() int64 { return int64(t.tableSize) }
 
+// CreatedAt returns when the table was written. It's the modification time of the file, which
+// isn't written again once built, or the time an in-memory table was opened.
+func (t *Table) CreatedAt() time.Time { return t.createdAt }
+
+// KeyCount returns the number of entries in the table, counting every version of a key. It
+// returns zero if the table was built before the count was stored in the table index.
+func (t *Table) KeyCount() uint64 { return t.keyCount }
+
+// SampleKeys returns the first key of the blocks of the table, keeping about one block every n
+// entries. The number of entries of a block is estimated from the key count of the table. Every
+// block is kept if its entries are more than n, or if the table doesn't record its key count.
+func (t *Table) SampleKeys(n int) [][]byte {
+	offsets := t.fetchIndex().offsets
+	step := 1
+	if len(offsets) > 0 {
+		if perBlock := int(t.keyCount) / len(offsets); perBlock > 0 && n > perBlock {
+			step = n / perBlock
+		}
+	}
+	keys := make([][]byte, 0, len(offsets)/step+1)
+	for i := 0; i < len(offsets); i += step {
+		keys = append(keys, offsets[i].Key)
+	}
+	return keys
+}
+
+// BlockInfo describes a block of a table, as recorded in the table index.
+type BlockInfo struct {
+	// Key is the first key of the block, with its timestamp.
+	Key    []byte
+	Offset uint32
+	Len    uint32
+}
+
+// Blocks returns the blocks of the table, in the order of their keys. They are read from the table
+// index, without reading the blocks. The first key of a block is a boundary between the keys of
+// the previous blocks and the keys of the next ones, so the keys of the blocks are split points
+// which divide the table in parts of about the same size.
+func (t *Table) Blocks() []BlockInfo {
+	offsets := t.fetchIndex().offsets
+	blocks := make([]BlockInfo, 0, len(offsets))
+	for _, ko := range offsets {
+		blocks = append(blocks, BlockInfo{Key: ko.Key, Offset: ko.Offset, Len: ko.Len})
+	}
+	return blocks
+}
+
+// BloomFalsePositive returns the false positive probability the bloom filter of the table was
+// built with, or zero if the table doesn't record it.
+func (t *Table) BloomFalsePositive() float64 { return t.bloomFalsePositive }
+
+// EstimateKeyCount estimates the number of entries in the table whose key has the given prefix.
+// The entries of the blocks which are entirely covered by the prefix are estimated from the key
+// count stored in the table index, the others are counted. Tables without a key count are counted
+// entirely, and the returned bool is false in that case.
+func (t *Table) EstimateKeyCount(prefix []byte) (uint64, bool, error) {
+	cmp := t.opt.Comparator
+	smallest, biggest := y.ParseKey(t.smallest), y.ParseKey(t.biggest)
+	if !beforePrefixEnd(cmp, smallest, prefix) || cmp.Compare(biggest, prefix) < 0 {
+		return 0, true, nil
+	}
+	seek := y.KeyWithTs(prefix, math.MaxUint64)
+	if t.keyCount == 0 {
+		count, err := t.countKeys(seek, prefix, -1)
+		return count, false, err
+	}
+	if bytes.HasPrefix(smallest, prefix) && bytes.HasPrefix(biggest, prefix) {
+		return t.keyCount, true, nil
+	}
+
+	offsets := t.fetchIndex().offsets
+	// Block i holds the keys in [offsets[i].Key, offsets[i+1].Key). Find the first and the
+	// last block which overlap the prefix.
+	first, last := -1, -1
+	for i, ko := range offsets {
+		if !beforePrefixEnd(cmp, y.ParseKey(ko.Key), prefix) {
+			break
+		}
+		if i+1 < len(offsets) && cmp.Compare(y.ParseKey(offsets[i+1].Key), prefix) < 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	if last-first < 4 {
+		count, err := t.countKeys(seek, prefix, -1)
+		return count, true, err
+	}
+	// The blocks in between only hold keys with the prefix. Estimate their entries from their share
+	// of the size of the table.
+	var size, inner uint64
+	for i, ko := range offsets {
+		size += uint64(ko.Len)
+		if i > first && i < last {
+			inner += uint64(ko.Len)
+		}
+	}
+	count := t.keyCount * inner / size
+	head, err := t.countKeys(seek, prefix, first)
+	if err != nil {
+		return 0, true, err
+	}
+	tail, err := t.countKeys(offsets[last].Key, prefix, last)
+	if err != nil {
+		return 0, true, err
+	}
+	return count + head + tail, true, nil
+}
+
+// beforePrefixEnd returns true if key, or a key bigger than it in the order of cmp, can still have
+// the given prefix.
+func beforePrefixEnd(cmp y.Comparator, key, prefix []byte) bool {
+	return bytes.HasPrefix(key, prefix) || cmp.Compare(key, prefix) < 0
+}
+
+// countKeys counts the entries with the given prefix, starting from the seek key. If block isn't
+// negative, it stops at the end of that block.
+func (t *Table) countKeys(seek, prefix []byte, block int) (uint64, error) {
+	it := t.NewIterator(false)
+	defer it.Close()
+	var count uint64
+	for it.Seek(seek); it.Valid(); it.Next() {
+		if block >= 0 && it.bpos != block {
+			break
+		}
+		if !bytes.HasPrefix(y.ParseKey(it.Key()), prefix) {
+			break
+		}
+		count++
+	}
+	return count, it.Err()
+}
+
 // Smallest is its smallest key, or nil if there are none
 func (t *Table) Smallest() []byte { return t.smallest }
 
@@ -456,28 +772,126 @@ func (t *Table) Smallest() []byte { return t.smallest }
 func (t *Table) Biggest() []byte { return t.biggest }
 
 // Filename is NOT the file name.  Just kidding, it is.
-func (t *Table) Filename() string { return t.fd.Name() }
+func (t *Table) Filename() string { return t.filename }
 
 // ID is the table's ID number (used to make the file name).
 func (t *Table) ID() uint64 { return t.id }
 
 // DoesNotHave returns true if (but not "only if") the table does not have the key hash.
 // It does a bloom filter lookup.
-func (t *Table) DoesNotHave(hash uint64) bool { return !t.bf.Has(hash) }
+func (t *Table) DoesNotHave(hash uint64) bool { return !t.fetchIndex().bf.Has(hash) }
+
+// RebuildBloomFilter returns the content of a table file with the same blocks as the table, and an
+// index with a bloom filter rebuilt from the keys of the table with the false positive probability
+// fp. The blocks are copied as they are, without being decoded again.
+func (t *Table) RebuildBloomFilter(fp float64) ([]byte, error) {
+	data, indexOffset, err := t.readIndexData()
+	if err != nil {
+		return nil, err
+	}
+	if t.shouldDecrypt() {
+		if data, err = t.decrypt(data); err != nil {
+			return nil, y.Wrapf(err, "while decrypting table index for the table %d", t.id)
+		}
+	}
+	index := &pb.TableIndex{}
+	if err := proto.Unmarshal(data, index); err != nil {
+		return nil, y.Wrapf(err, "while decoding table index for the table %d", t.id)
+	}
+
+	var keyHashes []uint64
+	var lastKey []byte
+	it := t.NewIterator(false)
+	for it.Rewind(); it.Valid(); it.Next() {
+		key := y.ParseKey(it.Key())
+		if len(keyHashes) > 0 && bytes.Equal(key, lastKey) {
+			continue
+		}
+		lastKey = append(lastKey[:0], key...)
+		keyHashes = append(keyHashes, farm.Fingerprint64(key))
+	}
+	if err := it.err; err != nil && err != io.EOF {
+		it.Close()
+		return nil, err
+	}
+	it.Close()
+
+	bf := z.NewBloomFilter(float64(len(keyHashes)), fp)
+	for _, h := range keyHashes {
+		bf.Add(h)
+	}
+	index.BloomFilter = bf.JSONMarshal()
+	index.BloomFalsePositive = fp
+	if data, err = proto.Marshal(index); err != nil {
+		return nil, err
+	}
+	if t.shouldDecrypt() {
+		iv, err := y.GenerateIV()
+		if err != nil {
+			return nil, y.Wrapf(err, "while generating IV for the table %d", t.id)
+		}
+		if data, err = y.XORBlock(data, t.opt.DataKey.Data, iv); err != nil {
+			return nil, y.Wrapf(err, "while encrypting table index for the table %d", t.id)
+		}
+		data = append(data, iv...)
+	}
+
+	blocks, err := t.read(0, indexOffset)
+	if err != nil {
+		return nil, err
+	}
+	algo := pb.Checksum_Algorithm(t.opt.ChecksumAlgorithm)
+	checksum, err := proto.Marshal(&pb.Checksum{
+		Sum:  y.CalculateChecksum(data, algo),
+		Algo: algo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(blocks)+len(data)+len(checksum)+8)
+	buf = append(buf, blocks...)
+	buf = append(buf, data...)
+	buf = append(buf, y.U32ToBytes(uint32(len(data)))...)
+	buf = append(buf, checksum...)
+	buf = append(buf, y.U32ToBytes(uint32(len(checksum)))...)
+	return buf, nil
+}
+
+// VerifyOnDisk reads the index and all the blocks of the table, bypassing the block cache, and
+// verifies their checksums irrespective of the checksum verification mode. If the table is
+// corrupt, it returns the offset of the corrupt index or block along with the error, otherwise
+// it returns -1.
+func (t *Table) VerifyOnDisk() (int, error) {
+	if _, offset, err := t.readIndexData(); err != nil {
+		return offset, err
+	}
+	for i, ko := range t.fetchIndex().offsets {
+		blk, err := t.readBlock(i)
+		if err == nil {
+			err = blk.verifyCheckSum()
+		}
+		if err != nil {
+			return int(ko.Offset), y.Wrapf(err,
+				"checksum validation failed for table: %s, block: %d, offset:%d",
+				t.Filename(), i, ko.Offset)
+		}
+	}
+	return -1, nil
+}
 
 // VerifyChecksum verifies checksum for all blocks of table. This function is called by
 // OpenTable() function. This function is also called inside levelsController.VerifyChecksum().
 func (t *Table) VerifyChecksum() error {
-	for i, os := range t.blockIndex {
+	for i, os := range t.fetchIndex().offsets {
 		b, err := t.block(i)
 		if err != nil {
 			return y.Wrapf(err, "checksum validation failed for table: %s, block: %d, offset:%d",
 				t.Filename(), i, os.Offset)
 		}
 
-		// OnBlockRead or OnTableAndBlockRead, we don't need to call verify checksum
-		// on block, verification would be done while reading block itself.
-		if !(t.opt.ChkMode == options.OnBlockRead || t.opt.ChkMode == options.OnTableAndBlockRead) {
+		// OnBlockRead, OnTableAndBlockRead or OnEveryBlockRead, we don't need to call verify
+		// checksum on block, verification would be done while reading block itself.
+		if !t.opt.verifyOnBlockRead() {
 			if err = b.verifyCheckSum(); err != nil {
 				return y.Wrapf(err,
 					"checksum validation failed for table: %s, block: %d, offset:%d",
@@ -548,6 +962,9 @@ func (t *Table) decompressData(data []byte) ([]byte, error) {
 	case options.Snappy:
 		return snappy.Decode(nil, data)
 	case options.ZSTD:
+		if t.zstdDecoder != nil {
+			return t.zstdDecoder.Decompress(nil, data)
+		}
 		return y.ZSTDDecompress(nil, data)
 	}
 	return nil, errors.New("Unsupported compression type")
@@ -21,17 +21,23 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"hash/crc32"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cespare/xxhash"
 	"github.com/dgraph-io/badger/v2/options"
+	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/dgraph-io/ristretto"
+	"github.com/dgryski/go-farm"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -500,6 +506,169 @@ func TestConcatIterator(t *testing.T) {
 	}
 }
 
+func TestIteratorPeek(t *testing.T) {
+	opts := getTestTableOptions()
+	// Small blocks, so that peeking has to cross block boundaries.
+	opts.BlockSize = 100
+	var tables []*Table
+	for _, prefix := range []string{"keya", "keyb", "keyc"} {
+		tbl, err := OpenTable(buildTestTable(t, prefix, 100, opts), opts)
+		require.NoError(t, err)
+		defer tbl.DecrRef()
+		tables = append(tables, tbl)
+	}
+	for _, reversed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reversed=%v", reversed), func(t *testing.T) {
+			ti := tables[0].NewIterator(reversed)
+			defer ti.Close()
+			ti.Rewind()
+			require.Equal(t, 100, checkPeek(t, ti))
+
+			ci := NewConcatIterator(tables, reversed)
+			defer ci.Close()
+			ci.Rewind()
+			require.Equal(t, 300, checkPeek(t, ci))
+
+			mi := NewMergeIterator([]y.Iterator{
+				NewConcatIterator(tables[1:], reversed),
+				tables[0].NewIterator(reversed),
+				tables[2].NewIterator(reversed),
+			}, reversed).(*MergeIterator)
+			defer mi.Close()
+			mi.Rewind()
+			require.Equal(t, 300, checkPeek(t, mi))
+		})
+	}
+}
+
+func TestIteratorBound(t *testing.T) {
+	opts := getTestTableOptions()
+	opts.BlockSize = 100
+	var tables []*Table
+	for _, prefix := range []string{"keya", "keyb", "keyc"} {
+		tbl, err := OpenTable(buildTestTable(t, prefix, 100, opts), opts)
+		require.NoError(t, err)
+		defer tbl.DecrRef()
+		tables = append(tables, tbl)
+	}
+	count := func(it y.Iterator) (n int) {
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return n
+	}
+
+	t.Run("block boundary", func(t *testing.T) {
+		tbl := tables[0]
+		require.True(t, len(tbl.fetchIndex().offsets) > 3)
+		bound := tbl.fetchIndex().offsets[2].Key
+		it := tbl.NewIterator(false)
+		defer it.Close()
+		var before int
+		for it.Rewind(); it.Valid() && y.CompareKeys(it.Key(), bound) < 0; it.Next() {
+			before++
+		}
+		it.SetBound(bound)
+		require.Equal(t, before, count(it))
+		_, ok := it.Peek()
+		require.False(t, ok)
+
+		rit := tbl.NewIterator(true)
+		defer rit.Close()
+		rit.SetBound(bound)
+		require.Equal(t, 100-before, count(rit))
+		rit.Seek(bound)
+		require.True(t, rit.Valid())
+		require.Equal(t, bound, rit.Key())
+		rit.Next()
+		require.False(t, rit.Valid())
+	})
+	t.Run("table boundary", func(t *testing.T) {
+		bound := tables[1].Smallest()
+		it := NewConcatIterator(tables, false)
+		defer it.Close()
+		it.SetBound(bound)
+		require.Equal(t, 100, count(it))
+		// The tables out of bound are never opened.
+		require.Nil(t, it.iters[1])
+		require.Nil(t, it.iters[2])
+
+		rit := NewConcatIterator(tables, true)
+		defer rit.Close()
+		rit.SetBound(bound)
+		require.Equal(t, 200, count(rit))
+		require.Nil(t, rit.iters[0])
+
+		// SeekToFirst and SeekToLast stop at the bound too.
+		it.SeekToLast()
+		require.False(t, it.Valid())
+		require.Nil(t, it.iters[2])
+		it.SeekToFirst()
+		require.True(t, it.Valid())
+		require.Equal(t, tables[0].Smallest(), it.Key())
+		rit.SeekToFirst()
+		require.False(t, rit.Valid())
+		require.Nil(t, rit.iters[0])
+		rit.SeekToLast()
+		require.True(t, rit.Valid())
+		require.Equal(t, tables[2].Biggest(), rit.Key())
+	})
+	t.Run("merge", func(t *testing.T) {
+		bound := y.KeyWithTs([]byte(key("keyb", 50)), 0)
+		for _, reversed := range []bool{false, true} {
+			it := NewMergeIterator([]y.Iterator{
+				NewConcatIterator(tables[:2], reversed),
+				tables[2].NewIterator(reversed),
+			}, reversed).(*MergeIterator)
+			defer it.Close()
+			it.SetBound(bound)
+			// keya0000..keyb0049 forward, keyb0050..keyc0099 in reverse.
+			require.Equal(t, 150, count(it))
+			it.Rewind()
+			checkPeek(t, it)
+		}
+	})
+}
+
+func TestConcatIteratorSeekToFirstLast(t *testing.T) {
+	opts := getTestTableOptions()
+	var tables []*Table
+	for _, prefix := range []string{"keya", "keyb"} {
+		tbl, err := OpenTable(buildTestTable(t, prefix, 1000, opts), opts)
+		require.NoError(t, err)
+		defer tbl.DecrRef()
+		tables = append(tables, tbl)
+	}
+	for _, reversed := range []bool{false, true} {
+		it := NewConcatIterator(tables, reversed)
+		defer it.Close()
+		it.SeekToFirst()
+		require.True(t, it.Valid())
+		require.Equal(t, "keya0000", string(y.ParseKey(it.Key())))
+		it.SeekToLast()
+		require.True(t, it.Valid())
+		require.Equal(t, "keyb0999", string(y.ParseKey(it.Key())))
+
+		var count int
+		if !reversed {
+			it.SeekToFirst()
+		}
+		for ; it.Valid(); it.Next() {
+			count++
+		}
+		require.Equal(t, 2000, count)
+		// For a reversed iterator, the smallest key is the last one. The other way
+		// around for a forward iterator.
+		if reversed {
+			it.SeekToFirst()
+		} else {
+			it.SeekToLast()
+		}
+		it.Next()
+		require.False(t, it.Valid())
+	}
+}
+
 func TestMergingIterator(t *testing.T) {
 	opts := getTestTableOptions()
 	f1 := buildTable(t, [][]string{
@@ -751,6 +920,141 @@ func TestTableChecksum(t *testing.T) {
 	}
 }
 
+func TestIteratorErr(t *testing.T) {
+	opts := getTestTableOptions()
+	opts.LoadingMode = options.FileIO
+	opts.ChkMode = options.OnBlockRead
+	tbl, err := OpenTable(buildTestTable(t, "k", 10000, opts), opts)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+	tbl2, err := OpenTable(buildTestTable(t, "l", 100, opts), opts)
+	require.NoError(t, err)
+	defer tbl2.DecrRef()
+
+	// Corrupt a block in the middle of the first table, after it has been opened.
+	require.True(t, len(tbl.fetchIndex().offsets) > 3)
+	ko := tbl.fetchIndex().offsets[2]
+	_, err = tbl.fd.WriteAt(make([]byte, ko.Len), int64(ko.Offset))
+	require.NoError(t, err)
+
+	check := func(t *testing.T, it y.Iterator) {
+		defer it.Close()
+		var count int
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+		require.Error(t, y.IteratorErr(it))
+		// The keys after the corrupt block, including the ones of the second table,
+		// must not be returned.
+		require.True(t, count < 10000, "count=%d", count)
+	}
+	t.Run("table", func(t *testing.T) {
+		check(t, tbl.NewIterator(false))
+	})
+	t.Run("concat", func(t *testing.T) {
+		check(t, NewConcatIterator([]*Table{tbl, tbl2}, false))
+	})
+	t.Run("merge", func(t *testing.T) {
+		check(t, NewMergeIterator([]y.Iterator{
+			tbl2.NewIterator(false), NewConcatIterator([]*Table{tbl}, false),
+		}, false))
+		check(t, NewHeapMergeIterator([]y.Iterator{
+			tbl2.NewIterator(false), NewConcatIterator([]*Table{tbl}, false),
+		}, false))
+	})
+	t.Run("no error", func(t *testing.T) {
+		it := tbl2.NewIterator(false)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+		}
+		require.NoError(t, it.Err())
+	})
+}
+
+func TestEstimateKeyCount(t *testing.T) {
+	opts := getTestTableOptions()
+	var kvs [][]string
+	for prefix, n := range map[string]int{"a": 1000, "b": 5000, "c": 1000} {
+		for i := 0; i < n; i++ {
+			kvs = append(kvs, []string{key(prefix, i), fmt.Sprintf("%d", i)})
+		}
+	}
+	tbl, err := OpenTable(buildTable(t, kvs, opts), opts)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+	require.Equal(t, uint64(7000), tbl.KeyCount())
+
+	tests := []struct {
+		prefix string
+		count  uint64
+	}{
+		{"", 7000},
+		{"a", 1000},
+		{"b", 5000},
+		{"b1", 1000},
+		{"b12", 100},
+		{"b123", 10},
+		{"c0999", 1},
+		{"0", 0},
+		{"d", 0},
+	}
+	for _, tt := range tests {
+		count, ok, err := tbl.EstimateKeyCount([]byte(tt.prefix))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.InEpsilon(t, tt.count+1, count+1, 0.1, "prefix %q: %d", tt.prefix, count)
+	}
+
+	// Tables without a key count are counted, unless they don't overlap the prefix.
+	tbl.keyCount = 0
+	for _, tt := range tests {
+		count, ok, err := tbl.EstimateKeyCount([]byte(tt.prefix))
+		require.NoError(t, err)
+		require.Equal(t, tt.count == 0, ok)
+		require.Equal(t, tt.count, count, "prefix %q", tt.prefix)
+	}
+}
+
+func TestEstimateKeyCountComparator(t *testing.T) {
+	opts := getTestTableOptions()
+	// Orders the keys by their first byte in reverse, keeping the keys with a prefix together.
+	opts.Comparator = func(a, b []byte) int {
+		if len(a) == 0 || len(b) == 0 || a[0] == b[0] {
+			return bytes.Compare(a, b)
+		}
+		return int(b[0]) - int(a[0])
+	}
+	var keys []string
+	for prefix, n := range map[string]int{"a": 1000, "b": 5000, "c": 1000} {
+		for i := 0; i < n; i++ {
+			keys = append(keys, key(prefix, i))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return opts.Comparator.Compare([]byte(keys[i]), []byte(keys[j])) < 0
+	})
+	b := NewTableBuilder(opts)
+	for i, k := range keys {
+		b.Add(y.KeyWithTs([]byte(k), 0), y.ValueStruct{Value: []byte(fmt.Sprintf("%d", i))}, 0)
+	}
+	tbl, err := OpenInMemoryTable(b.Finish(), 1, &opts)
+	b.Close()
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+
+	for _, tt := range []struct {
+		prefix string
+		count  uint64
+	}{
+		{"", 7000}, {"a", 1000}, {"b", 5000}, {"b1", 1000}, {"c", 1000}, {"c0999", 1}, {"d", 0},
+	} {
+		count, ok, err := tbl.EstimateKeyCount([]byte(tt.prefix))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.InEpsilon(t, tt.count+1, count+1, 0.1, "prefix %q: %d", tt.prefix, count)
+	}
+}
+
 var cacheConfig = ristretto.Config{
 	NumCounters: 1000000 * 10,
 	MaxCost:     1000000,
@@ -787,7 +1091,7 @@ func BenchmarkReadAndBuild(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		func() {
 			opts := Options{Compression: options.ZSTD, BlockSize: 4 * 0124, BloomFalsePositive: 0.01}
-			opts.Cache = cache
+			opts.Cache = &RistrettoCache{Cache: cache}
 			newBuilder := NewTableBuilder(opts)
 			it := tbl.NewIterator(false)
 			defer it.Close()
@@ -813,7 +1117,7 @@ func BenchmarkReadMerged(b *testing.B) {
 	for i := 0; i < m; i++ {
 		filename := fmt.Sprintf("%s%s%d.sst", os.TempDir(), string(os.PathSeparator), rand.Int63())
 		opts := Options{Compression: options.ZSTD, BlockSize: 4 * 1024, BloomFalsePositive: 0.01}
-		opts.Cache = cache
+		opts.Cache = &RistrettoCache{Cache: cache}
 		builder := NewTableBuilder(opts)
 		f, err := y.OpenSyncedFile(filename, true)
 		y.Check(err)
@@ -905,7 +1209,7 @@ func getTableForBenchmarks(b *testing.B, count int, cache *ristretto.Cache) *Tab
 		cache, err = ristretto.NewCache(&cacheConfig)
 		require.NoError(b, err)
 	}
-	opts.Cache = cache
+	opts.Cache = &RistrettoCache{Cache: cache}
 	builder := NewTableBuilder(opts)
 	filename := fmt.Sprintf("%s%s%d.sst", os.TempDir(), string(os.PathSeparator), rand.Int63())
 	f, err := y.OpenSyncedFile(filename, true)
@@ -937,3 +1241,413 @@ func TestOpenKVSize(t *testing.T) {
 	var entrySize uint64 = 15 /* DiffKey len */ + 4 /* Header Size */ + 4 /* Encoded vp */
 	require.Equal(t, entrySize, table.EstimatedSize())
 }
+
+func TestTableChecksumAlgorithm(t *testing.T) {
+	for _, algo := range []options.ChecksumAlgorithm{options.CRC32C, options.XXHash64} {
+		opts := getTestTableOptions()
+		opts.ChkMode = options.OnTableAndBlockRead
+		opts.ChecksumAlgorithm = algo
+		tbl, err := OpenTable(buildTestTable(t, "k", 1000, opts), opts)
+		require.NoError(t, err)
+
+		// Tables are verified with the algorithm they were written with.
+		opts.ChecksumAlgorithm = options.CRC32C
+		tbl2, err := OpenTable(buildTestTable(t, "l", 1000, opts), opts)
+		require.NoError(t, err)
+		for _, tb := range []*Table{tbl, tbl2} {
+			require.NoError(t, tb.VerifyChecksum())
+		}
+
+		b, err := tbl.block(0)
+		require.NoError(t, err)
+		cs := &pb.Checksum{}
+		require.NoError(t, proto.Unmarshal(b.checksum, cs))
+		require.Equal(t, pb.Checksum_Algorithm(algo), cs.Algo)
+		require.NoError(t, tbl.DecrRef())
+		require.NoError(t, tbl2.DecrRef())
+	}
+}
+
+// falsePositiveRate returns the fraction of n keys absent from tbl which its bloom filter doesn't
+// rule out.
+func falsePositiveRate(tbl *Table, n int) float64 {
+	var fps int
+	for i := 0; i < n; i++ {
+		if !tbl.DoesNotHave(farm.Fingerprint64([]byte(key("absent", i)))) {
+			fps++
+		}
+	}
+	return float64(fps) / float64(n)
+}
+
+func TestBloomFalsePositive(t *testing.T) {
+	var prevSize int64
+	for _, fp := range []float64{0.001, 0.01, 0.1} {
+		opts := getTestTableOptions()
+		opts.BloomFalsePositive = fp
+		f := buildTestTable(t, "k", 10000, opts)
+		fi, err := f.Stat()
+		require.NoError(t, err)
+		tbl, err := OpenTable(f, opts)
+		require.NoError(t, err)
+
+		// The table records the false positive probability it was built with.
+		require.Equal(t, fp, tbl.BloomFalsePositive())
+		for i := 0; i < 10000; i++ {
+			require.False(t, tbl.DoesNotHave(farm.Fingerprint64([]byte(key("k", i)))))
+		}
+		require.True(t, falsePositiveRate(tbl, 10000) < 2*fp)
+		// A higher false positive probability makes a smaller table.
+		if prevSize > 0 {
+			require.True(t, fi.Size() < prevSize)
+		}
+		prevSize = fi.Size()
+		require.NoError(t, tbl.DecrRef())
+	}
+}
+
+// BenchmarkBloomFalsePositive reports the measured false positive rate, which is the fraction of
+// lookups of absent keys reading the table, against the size of the table per key. The keys and
+// values are small, so the size of the table is dominated by the bloom filter.
+func BenchmarkBloomFalsePositive(b *testing.B) {
+	const n = 10000
+	for _, fp := range []float64{0.001, 0.01, 0.05, 0.1, 0.2} {
+		b.Run(fmt.Sprintf("fp=%v", fp), func(b *testing.B) {
+			opts := Options{BlockSize: 4 * 1024, BloomFalsePositive: fp}
+			builder := NewTableBuilder(opts)
+			for i := 0; i < n; i++ {
+				k := y.KeyWithTs([]byte(key("k", i)), 0)
+				builder.Add(k, y.ValueStruct{Value: []byte("v")}, 0)
+			}
+			data := builder.Finish()
+			tbl, err := OpenInMemoryTable(data, 0, &opts)
+			require.NoError(b, err)
+			defer tbl.DecrRef()
+
+			b.ResetTimer()
+			var fps int
+			for i := 0; i < b.N; i++ {
+				if !tbl.DoesNotHave(farm.Fingerprint64([]byte(key("absent", i%n)))) {
+					fps++
+				}
+			}
+			b.ReportMetric(float64(fps)/float64(b.N), "fp-rate")
+			b.ReportMetric(float64(len(data))/n, "bytes/key")
+		})
+	}
+}
+
+func TestRebuildBloomFilter(t *testing.T) {
+	opts := getTestTableOptions()
+	tbl, err := OpenTable(buildTestTable(t, "k", 1000, opts), opts)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+
+	data, err := tbl.RebuildBloomFilter(0.1)
+	require.NoError(t, err)
+	tbl2, err := OpenInMemoryTable(data, 1, &opts)
+	require.NoError(t, err)
+	defer tbl2.DecrRef()
+	require.Equal(t, 0.1, tbl2.BloomFalsePositive())
+	_, err = tbl2.VerifyOnDisk()
+	require.NoError(t, err)
+
+	// The blocks are the same.
+	it, it2 := tbl.NewIterator(false), tbl2.NewIterator(false)
+	defer it.Close()
+	defer it2.Close()
+	var count int
+	it2.Rewind()
+	for it.Rewind(); it.Valid(); it.Next() {
+		require.True(t, it2.Valid())
+		require.Equal(t, it.Key(), it2.Key())
+		require.Equal(t, it.Value(), it2.Value())
+		require.False(t, tbl2.DoesNotHave(farm.Fingerprint64(y.ParseKey(it.Key()))))
+		it2.Next()
+		count++
+	}
+	require.False(t, it2.Valid())
+	require.Equal(t, 1000, count)
+}
+
+// mapCache is a Cache which admits every value, to make the cache tests deterministic.
+type mapCache struct {
+	sync.Mutex
+	m map[string]interface{}
+}
+
+func newMapCache() *mapCache { return &mapCache{m: make(map[string]interface{})} }
+
+func (c *mapCache) Get(key []byte) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.m[string(key)]
+	return v, ok
+}
+
+func (c *mapCache) Set(key []byte, value interface{}, cost int64) bool {
+	c.Lock()
+	defer c.Unlock()
+	c.m[string(key)] = value
+	return true
+}
+
+func (c *mapCache) Del(key []byte) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.m, string(key))
+}
+
+func (c *mapCache) Close() {}
+
+func TestTableCaches(t *testing.T) {
+	n := 10000
+	cache := newMapCache()
+	opts := getTestTableOptions()
+	opts.Cache = cache
+	opts.IndexCache = cache
+	tbl, err := OpenTable(buildTestTable(t, "key", n, opts), opts)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+	require.Nil(t, tbl.index)
+
+	// A table of another DB sharing the cache with the same ID must not see the cached values.
+	other := opts
+	other.CacheID = 1
+	f := buildTestTable(t, "other", 10, other)
+	data, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(f.Name()))
+	otherTbl, err := OpenInMemoryTable(data, tbl.ID(), &other)
+	require.NoError(t, err)
+	defer otherTbl.DecrRef()
+
+	check := func() {
+		it := tbl.NewIterator(false)
+		defer it.Close()
+		count := 0
+		for it.Rewind(); it.Valid(); it.Next() {
+			require.EqualValues(t, y.KeyWithTs([]byte(key("key", count)), 0), it.Key())
+			count++
+		}
+		require.Equal(t, n, count)
+		require.False(t, tbl.DoesNotHave(farm.Fingerprint64([]byte(key("key", 10)))))
+	}
+	check()
+	it := otherTbl.NewIterator(false)
+	it.Rewind()
+	require.EqualValues(t, y.KeyWithTs([]byte(key("other", 0)), 0), it.Key())
+	require.NoError(t, it.Close())
+
+	numBlocks := len(tbl.fetchIndex().offsets)
+	require.True(t, numBlocks > 1)
+	_, ok := cache.Get(tbl.indexCacheKey())
+	require.True(t, ok)
+	for i := 0; i < numBlocks; i++ {
+		_, ok := cache.Get(tbl.blockCacheKey(i))
+		require.True(t, ok)
+	}
+
+	// The index and the blocks are read again from the table once evicted.
+	cache.Del(tbl.indexCacheKey())
+	cache.Del(tbl.blockCacheKey(0))
+	check()
+	_, ok = cache.Get(tbl.indexCacheKey())
+	require.True(t, ok)
+}
+
+func TestBlocks(t *testing.T) {
+	opts := getTestTableOptions()
+	var kvs [][]string
+	for i := 0; i < 5000; i++ {
+		kvs = append(kvs, []string{key("k", i), fmt.Sprintf("%d", i)})
+	}
+	tbl, err := OpenTable(buildTable(t, kvs, opts), opts)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+
+	blocks := tbl.Blocks()
+	require.Greater(t, len(blocks), 1)
+	require.Equal(t, tbl.Smallest(), blocks[0].Key)
+	var offset uint32
+	for i, b := range blocks {
+		require.Equal(t, offset, b.Offset)
+		offset += b.Len
+		if i > 0 {
+			require.Less(t, y.CompareKeys(blocks[i-1].Key, b.Key), 0)
+		}
+		// The first key of the block is found by seeking to it.
+		it := tbl.NewIterator(false)
+		it.Seek(b.Key)
+		require.True(t, it.Valid())
+		require.Equal(t, b.Key, it.Key())
+		it.Close()
+	}
+	require.LessOrEqual(t, y.CompareKeys(blocks[len(blocks)-1].Key, tbl.Biggest()), 0)
+}
+
+func TestFileCache(t *testing.T) {
+	opts := getTestTableOptions()
+	opts.LoadingMode = options.FileIO
+	opts.FileCache = NewFileCache(2)
+
+	var tables []*Table
+	for i := 0; i < 5; i++ {
+		tbl, err := OpenTable(buildTestTable(t, fmt.Sprintf("t%d-", i), 1000, opts), opts)
+		require.NoError(t, err)
+		tables = append(tables, tbl)
+		require.LessOrEqual(t, opts.FileCache.Len(), 2)
+	}
+	require.Zero(t, opts.FileCache.Reopens())
+
+	// readAll reads the tables concurrently, their files being closed and opened again meanwhile.
+	readAll := func(ids ...int) <-chan error {
+		errCh := make(chan error, 4)
+		go func() {
+			defer close(errCh)
+			var wg sync.WaitGroup
+			for r := 0; r < 4; r++ {
+				wg.Add(1)
+				go func(r int) {
+					defer wg.Done()
+					for n := 0; n < 5; n++ {
+						i := ids[(r+n)%len(ids)]
+						it := tables[i].NewIterator(false)
+						count := 0
+						for it.Rewind(); it.Valid(); it.Next() {
+							want := y.KeyWithTs([]byte(key(fmt.Sprintf("t%d-", i), count)), 0)
+							if !bytes.Equal(want, it.Key()) {
+								errCh <- fmt.Errorf("got key %q, want %q", it.Key(), want)
+								return
+							}
+							count++
+						}
+						if err := it.Close(); err != nil {
+							errCh <- err
+							return
+						}
+						if count != 1000 {
+							errCh <- fmt.Errorf("got %d keys, want 1000", count)
+							return
+						}
+					}
+				}(r)
+			}
+			wg.Wait()
+		}()
+		return errCh
+	}
+	for err := range readAll(0, 1, 2, 3, 4) {
+		require.NoError(t, err)
+	}
+	require.LessOrEqual(t, opts.FileCache.Len(), 2)
+	require.NotZero(t, opts.FileCache.Reopens())
+
+	// The tables whose file was closed are deleted all the same, while the others are read.
+	errCh := readAll(3, 4)
+	for _, tbl := range tables[:3] {
+		require.NoError(t, tbl.DecrRef())
+		_, err := os.Stat(tbl.Filename())
+		require.True(t, os.IsNotExist(err))
+	}
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+	for _, tbl := range tables[3:] {
+		require.NoError(t, tbl.DecrRef())
+		_, err := os.Stat(tbl.Filename())
+		require.True(t, os.IsNotExist(err))
+	}
+	require.Zero(t, opts.FileCache.Len())
+}
+
+func TestFileClosedWithoutFileCache(t *testing.T) {
+	opts := getTestTableOptions()
+	opts.LoadingMode = options.FileIO
+	f := buildTestTable(t, "k", 100, opts)
+	tbl, err := OpenTable(f, opts)
+	require.NoError(t, err)
+	filename := tbl.Filename()
+	defer os.Remove(filename)
+
+	// Without a FileCache, the file isn't opened again once closed.
+	require.NoError(t, tbl.closeFile())
+	_, err = tbl.read(0, 10)
+	require.Equal(t, os.ErrClosed, err)
+}
+
+func TestIteratorPeekErr(t *testing.T) {
+	opts := getTestTableOptions()
+	opts.LoadingMode = options.FileIO
+	opts.BlockSize = 100
+	f := buildTestTable(t, "k", 100, opts)
+	tbl, err := OpenTable(f, opts)
+	require.NoError(t, err)
+	filename := tbl.Filename()
+	defer os.Remove(filename)
+
+	// The first key of the second block, peeking from it in reverse reads the first block.
+	offsets := tbl.fetchIndex().offsets
+	require.True(t, len(offsets) > 2)
+	it := tbl.NewIterator(true)
+	defer it.Close()
+	it.Seek(offsets[1].Key)
+	require.True(t, it.Valid())
+	require.Equal(t, offsets[1].Key, it.Key())
+	require.NoError(t, it.Err())
+
+	require.NoError(t, tbl.closeFile())
+	_, ok := it.Peek()
+	require.False(t, ok)
+	require.Equal(t, os.ErrClosed, errors.Cause(it.Err()))
+}
+
+func TestChecksumOnEveryBlockRead(t *testing.T) {
+	opts := getTestTableOptions()
+	opts.Compression = options.None
+	opts.LoadingMode = options.FileIO
+	opts.ChkMode = options.OnEveryBlockRead
+	cache := newMapCache()
+	opts.Cache = cache
+	f := buildTestTable(t, "k", 10000, opts)
+	verified := y.NumBlockChecksumVerifications.Value()
+	tbl, err := OpenTable(f, opts)
+	require.NoError(t, err)
+	defer tbl.DecrRef()
+	numBlocks := int64(len(tbl.fetchIndex().offsets))
+	require.True(t, numBlocks > 3)
+	// The blocks are verified when the table is opened, and the first and the last ones are read
+	// for the smallest and the biggest keys.
+	require.Equal(t, verified+numBlocks+2, y.NumBlockChecksumVerifications.Value())
+
+	read := func() error {
+		it := tbl.NewIterator(false)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+		}
+		return it.Err()
+	}
+	// Every read verifies the blocks again, as they aren't cached. The first block is read twice,
+	// by NewIterator and by Rewind.
+	for i := 0; i < 2; i++ {
+		verified = y.NumBlockChecksumVerifications.Value()
+		require.NoError(t, read())
+		require.Equal(t, verified+numBlocks+1, y.NumBlockChecksumVerifications.Value())
+	}
+	require.Empty(t, cache.m)
+
+	// A block corrupted after it was read is caught by the next read.
+	mismatches := y.NumBlockChecksumMismatches.Value()
+	offset := int64(tbl.fetchIndex().offsets[2].Offset) + 10
+	b := make([]byte, 1)
+	_, err = tbl.fd.ReadAt(b, offset)
+	require.NoError(t, err)
+	b[0]++
+	_, err = tbl.fd.WriteAt(b, offset)
+	require.NoError(t, err)
+	err = read()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "checksum")
+	require.Equal(t, mismatches+1, y.NumBlockChecksumMismatches.Value())
+}
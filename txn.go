@@ -21,10 +21,12 @@ import (
 	"context"
 	"encoding/hex"
 	"math"
+	"math/rand"
 	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/dgraph-io/badger/v2/y"
 	"github.com/dgraph-io/ristretto/z"
@@ -54,6 +56,10 @@ type oracle struct {
 	// refCount is used to clear out commits map to avoid a memory blowup.
 	commits map[uint64]uint64
 
+	// Number of commits which got a commit timestamp, and which failed with ErrConflict.
+	numCommitted uint64
+	numConflicts uint64
+
 	// closer is used to stop watermarks.
 	closer *y.Closer
 }
@@ -146,6 +152,8 @@ func (o *oracle) discardAtOrBelow() uint64 {
 		defer o.Unlock()
 		return o.discardTs
 	}
+	// The read watermark stays below the read timestamps of the running transactions and of the
+	// unreleased snapshots.
 	return o.readMark.DoneUntil()
 }
 
@@ -169,8 +177,10 @@ func (o *oracle) newCommitTs(txn *Txn) uint64 {
 	defer o.Unlock()
 
 	if o.hasConflict(txn) {
+		o.numConflicts++
 		return 0
 	}
+	o.numCommitted++
 
 	var ts uint64
 	if !o.isManaged {
@@ -190,6 +200,52 @@ func (o *oracle) newCommitTs(txn *Txn) uint64 {
 	return ts
 }
 
+// TxnMetrics holds the counters of the transactions and of the conflict detection, as returned by
+// DB.TxnMetrics.
+type TxnMetrics struct {
+	// Committed is the number of transactions with writes which passed the conflict detection
+	// and got a commit timestamp since the DB was opened.
+	Committed uint64
+	// Conflicts is the number of commits which failed with ErrConflict since the DB was opened.
+	Conflicts uint64
+	// ReadLag is the gap between the latest read timestamp handed out and the read watermark,
+	// below which all the transactions are done reading. A growing ReadLag means long running
+	// transactions, which hold back the versions compactions can discard. It's 0 with managed
+	// transactions.
+	ReadLag uint64
+	// CommitLag is the number of commits which got a commit timestamp but aren't written yet,
+	// which the new transactions wait for before reading. It's 0 with managed transactions.
+	CommitLag uint64
+	// TrackedKeys is the number of key fingerprints the oracle holds the latest commit timestamp
+	// of, to detect the conflicts. It's only reset once no read-write transaction is running.
+	TrackedKeys int
+}
+
+// TxnMetrics returns the counters of the transactions and of the conflict detection, to see how
+// much the transactions contend on the same keys.
+func (db *DB) TxnMetrics() TxnMetrics {
+	o := db.orc
+	o.Lock()
+	m := TxnMetrics{
+		Committed:   o.numCommitted,
+		Conflicts:   o.numConflicts,
+		TrackedKeys: len(o.commits),
+	}
+	o.Unlock()
+	if o.isManaged {
+		return m
+	}
+	lag := func(w *y.WaterMark) uint64 {
+		if last, done := w.LastIndex(), w.DoneUntil(); done < last {
+			return last - done
+		}
+		return 0
+	}
+	m.ReadLag = lag(o.readMark)
+	m.CommitLag = lag(o.txnMark)
+	return m
+}
+
 func (o *oracle) doneCommit(cts uint64) {
 	if o.isManaged {
 		// No need to update anything.
@@ -200,17 +256,22 @@ func (o *oracle) doneCommit(cts uint64) {
 
 // Txn represents a Badger transaction.
 type Txn struct {
-	readTs   uint64
-	commitTs uint64
+	readTs      uint64
+	commitTs    uint64
+	committedTs uint64 // Set once the commit succeeded, see CommitTs.
 
 	update bool     // update is used to conditionally keep track of reads.
 	reads  []uint64 // contains fingerprints of keys read.
 	writes []uint64 // contains fingerprints of keys written.
 
 	pendingWrites map[string]*Entry // cache stores any writes done by txn.
+	rangeDels     []rangeTombstone  // ranges deleted by txn, versioned on commit.
 
 	db        *DB
 	discarded bool
+	closing   bool // Set if the txn was created after DB.BeginDrain, it can't commit.
+
+	skipConflicts bool // Set by SetDetectConflicts(false), the keys are not tracked.
 
 	size         int64
 	count        int64
@@ -222,6 +283,7 @@ type pendingWritesIterator struct {
 	nextIdx  int
 	readTs   uint64
 	reversed bool
+	cmp      y.Comparator
 }
 
 func (pi *pendingWritesIterator) Next() {
@@ -235,7 +297,7 @@ func (pi *pendingWritesIterator) Rewind() {
 func (pi *pendingWritesIterator) Seek(key []byte) {
 	key = y.ParseKey(key)
 	pi.nextIdx = sort.Search(len(pi.entries), func(idx int) bool {
-		cmp := bytes.Compare(pi.entries[idx].Key, key)
+		cmp := pi.cmp.Compare(pi.entries[idx].Key, key)
 		if !pi.reversed {
 			return cmp >= 0
 		}
@@ -243,6 +305,24 @@ func (pi *pendingWritesIterator) Seek(key []byte) {
 	})
 }
 
+// The entries are sorted in the direction of iteration, so the first entry is the
+// biggest one for a reversed iterator.
+func (pi *pendingWritesIterator) SeekToFirst() {
+	if !pi.reversed {
+		pi.nextIdx = 0
+	} else {
+		pi.nextIdx = len(pi.entries) - 1
+	}
+}
+
+func (pi *pendingWritesIterator) SeekToLast() {
+	if !pi.reversed {
+		pi.nextIdx = len(pi.entries) - 1
+	} else {
+		pi.nextIdx = 0
+	}
+}
+
 func (pi *pendingWritesIterator) Key() []byte {
 	y.AssertTrue(pi.Valid())
 	entry := pi.entries[pi.nextIdx]
@@ -279,7 +359,7 @@ func (txn *Txn) newPendingWritesIterator(reversed bool) *pendingWritesIterator {
 	}
 	// Number of pending writes per transaction shouldn't be too big in general.
 	sort.Slice(entries, func(i, j int) bool {
-		cmp := bytes.Compare(entries[i].Key, entries[j].Key)
+		cmp := txn.db.cmp.Compare(entries[i].Key, entries[j].Key)
 		if !reversed {
 			return cmp < 0
 		}
@@ -289,6 +369,7 @@ func (txn *Txn) newPendingWritesIterator(reversed bool) *pendingWritesIterator {
 		readTs:   txn.readTs,
 		entries:  entries,
 		reversed: reversed,
+		cmp:      txn.db.cmp,
 	}
 }
 
@@ -303,14 +384,49 @@ func (txn *Txn) checkSize(e *Entry) error {
 	return nil
 }
 
+// txnOverhead returns the count and the size accounted for the entry added to the transaction by
+// Commit, which a new transaction starts with.
+func txnOverhead() (int64, int64) {
+	return 1, int64(len(txnKey) + 10)
+}
+
+// PendingWrites returns the number of writes staged by the transaction and their estimated size,
+// as accounted against DB.MaxBatchCount and DB.MaxBatchSize to return ErrTxnTooBig. Every write is
+// counted, even if it overwrites an earlier write of the transaction. The size of a write is the
+// size of its key, plus the size of its value if the value is smaller than Options.ValueThreshold
+// or 12 bytes for its pointer in the value log otherwise, plus 12 bytes for the metadata and the
+// version. The entry added by Commit isn't included.
+func (txn *Txn) PendingWrites() (count int, size int64) {
+	overheadCount, overheadSize := txnOverhead()
+	return int(txn.count - overheadCount), txn.size - overheadSize
+}
+
+// Headroom returns how many more writes the transaction can stage, and how large their total size
+// can be, before it returns ErrTxnTooBig. The size of a write is estimated as described in
+// PendingWrites. A transaction which runs out of headroom can be committed, and the remaining
+// writes staged in a new transaction.
+func (txn *Txn) Headroom() (count int, size int64) {
+	// A write fails once the count or the size reaches its limit.
+	return int(txn.db.opt.maxBatchCount - txn.count - 1), txn.db.opt.maxBatchSize - txn.size - 1
+}
+
 func exceedsSize(prefix string, max int64, key []byte) error {
 	return errors.Errorf("%s with size %d exceeded %d limit. %s:\n%s",
 		prefix, len(key), max, prefix, hex.Dump(key[:1<<10]))
 }
 
+const maxKeySize = 65000
+
 func (txn *Txn) modify(e *Entry) error {
-	const maxKeySize = 65000
+	if err := txn.stage(e); err != nil {
+		return err
+	}
+	txn.addWriteKey(e.Key)
+	return nil
+}
 
+// stage adds e to the pending writes, without registering its key for conflict detection.
+func (txn *Txn) stage(e *Entry) error {
 	switch {
 	case !txn.update:
 		return ErrReadOnlyTxn
@@ -320,20 +436,29 @@ func (txn *Txn) modify(e *Entry) error {
 		return ErrEmptyKey
 	case bytes.HasPrefix(e.Key, badgerPrefix):
 		return ErrInvalidKey
+	case bytes.HasPrefix(e.Key, cfPrefix) && !e.cfKey &&
+		atomic.LoadInt32(&txn.db.hasColumnFamilies) == 1:
+		return ErrInvalidKey
 	case len(e.Key) > maxKeySize:
 		// Key length can't be more than uint16, as determined by table::header.  To
 		// keep things safe and allow badger move prefix and a timestamp suffix, let's
 		// cut it down to 65000, instead of using 65536.
 		return exceedsSize("Key", maxKeySize, e.Key)
-	case int64(len(e.Value)) > txn.db.opt.ValueLogFileSize:
-		return exceedsSize("Value", txn.db.opt.ValueLogFileSize, e.Value)
+	case int64(len(e.Value)) > txn.db.opt.maxValueSize():
+		return exceedsSize("Value", txn.db.opt.maxValueSize(), e.Value)
+	case len(e.Metadata) > maxMetadataSize:
+		return ErrMetadataTooBig
 	}
 
+	if e.ttl != 0 {
+		e.ExpiresAt = txn.db.opt.expiresAt(e.ttl)
+	}
 	if err := txn.checkSize(e); err != nil {
 		return err
 	}
-	fp := z.MemHash(e.Key) // Avoid dealing with byte arrays.
-	txn.writes = append(txn.writes, fp)
+	if txn.db.hotKeys != nil {
+		txn.db.hotKeys.record(e.Key)
+	}
 	txn.pendingWrites[string(e.Key)] = e
 	return nil
 }
@@ -372,6 +497,174 @@ func (txn *Txn) Delete(key []byte) error {
 	return txn.modify(e)
 }
 
+// DeleteBatch deletes keys, like calling Delete for each of them, but registers only conflictKey
+// for conflict detection, instead of every key. This keeps the conflict tracking of the
+// transactions deleting many keys cheap, at the cost of coarser conflicts: the transactions which
+// read some of the keys are not aborted by this one unless they also read conflictKey, e.g. with
+// a Get, which works whether conflictKey exists or not. A conflictKey shared by all the
+// transactions working on a set of keys, like a common prefix, makes them conflict with each
+// other whatever keys they touch, which also brings false conflicts. conflictKey itself is not
+// written. A nil conflictKey registers every key, like Delete.
+//
+// If a key is invalid, or the transaction gets too big, DeleteBatch returns the error and the
+// keys before it stay deleted, like with a sequence of Delete calls.
+func (txn *Txn) DeleteBatch(keys [][]byte, conflictKey []byte) error {
+	if conflictKey == nil {
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(conflictKey) == 0 {
+		return ErrEmptyKey
+	}
+	var staged bool
+	defer func() {
+		if staged {
+			txn.addWriteKey(conflictKey)
+		}
+	}()
+	for _, key := range keys {
+		if err := txn.stage(&Entry{Key: key, meta: bitDelete}); err != nil {
+			return err
+		}
+		staged = true
+	}
+	return nil
+}
+
+// DeleteRange deletes all the keys in the range [start, end).
+//
+// Instead of writing a delete marker for each key, a single range tombstone is written at commit
+// timestamp. It deletes the versions of the keys in the range older than the commit timestamp, so
+// reads happening after this commit see all the keys in the range as deleted. The deleted versions
+// are discarded by compactions, and the tombstone itself is deleted once no reader can see past it
+// and all the versions it deleted have been discarded.
+// Streams, backups and subscriptions carry the tombstone, see ParseDeleteRange, and Load applies
+// it.
+//
+// Within the transaction, the keys in the range are deleted from the pending writes. Keys set after
+// the call to DeleteRange are written at the commit timestamp too, so they are not deleted.
+//
+// NOTE: A range delete is a blind write, it doesn't conflict with anything. It doesn't cause the
+// transactions which read keys in the range to be aborted, and point writes to keys in the range
+// committed concurrently survive the range delete if they get a higher commit timestamp, or are
+// deleted by it otherwise. Overlapping range deletes don't conflict either, each of them deletes
+// the versions older than its own commit timestamp. In managed mode, versions committed later
+// with a timestamp lower than the range delete are deleted by it only as long as the tombstone
+// hasn't been garbage collected.
+func (txn *Txn) DeleteRange(start, end []byte) error {
+	switch {
+	case !txn.update:
+		return ErrReadOnlyTxn
+	case txn.discarded:
+		return ErrDiscardedTxn
+	case len(start) == 0:
+		return ErrEmptyKey
+	case txn.db.cmp.Compare(start, end) >= 0:
+		return ErrInvalidRequest
+	}
+	return txn.deleteRange(start, end)
+}
+
+// deleteRange is DeleteRange without the checks of the range, an empty end meaning no upper bound.
+func (txn *Txn) deleteRange(start, end []byte) error {
+	// A range delete never expires, the deleted keys would come back otherwise.
+	e := &Entry{Key: rangeDelKey(start, end), ExpiresAt: 0, noTTL: true}
+	if len(e.Key) > maxKeySize {
+		return exceedsSize("Key", maxKeySize, e.Key)
+	}
+	_, had := txn.pendingWrites[string(e.Key)]
+	if !had {
+		if err := txn.checkSize(e); err != nil {
+			return err
+		}
+	}
+	t := rangeTombstone{start: y.SafeCopy(nil, start), end: y.SafeCopy(nil, end),
+		cmp: txn.db.cmp}
+	for k, pe := range txn.pendingWrites {
+		if !bytes.HasPrefix(pe.Key, badgerPrefix) && t.contains(pe.Key) {
+			delete(txn.pendingWrites, k)
+		}
+	}
+	if had {
+		return nil // The range was already deleted by the transaction.
+	}
+	// Nobody reads the tombstone key, it's not registered for conflict detection.
+	txn.pendingWrites[string(e.Key)] = e
+	txn.rangeDels = append(txn.rangeDels, t)
+	return nil
+}
+
+// rangeDeleted returns true if the given version of key, read from the DB at readTs, is deleted by
+// a range tombstone visible at readTs, or, if pending is set, by a range delete of the transaction.
+// Reads below the read timestamp of the transaction must not set pending, as they don't see its
+// pending writes either.
+func (txn *Txn) rangeDeleted(key []byte, version, readTs uint64, pending bool) bool {
+	if pending && txn.pendingRangeDeleted(key) {
+		return true
+	}
+	return txn.db.rangeDels.covers(key, version, readTs)
+}
+
+// pendingRangeDeleted returns true if key falls in a range deleted by the transaction, and hasn't
+// been set again since.
+func (txn *Txn) pendingRangeDeleted(key []byte) bool {
+	if len(txn.rangeDels) == 0 || bytes.HasPrefix(key, badgerPrefix) {
+		return false
+	}
+	if _, has := txn.pendingWrites[string(key)]; has {
+		return false
+	}
+	for _, t := range txn.rangeDels {
+		if t.contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareAndSet sets key to newValue if its current value, as seen by the transaction, is
+// expected. A nil expected means that the key must not exist; an empty but non-nil expected
+// matches an existing empty value. It returns whether the set was staged in the transaction.
+// It returns ErrReadOnlyTxn for a read-only transaction.
+//
+// NOTE: The comparison is only validated at commit time, not at call time. The read of the key is
+// tracked like with Get, so Commit returns ErrConflict if another transaction changed the key in
+// the meantime, and the set is not applied.
+//
+// The current transaction keeps a reference to the key and newValue byte slice arguments. Users
+// must not modify them until the end of the transaction.
+func (txn *Txn) CompareAndSet(key, expected, newValue []byte) (bool, error) {
+	if !txn.update {
+		return false, ErrReadOnlyTxn
+	}
+	var match bool
+	item, err := txn.Get(key)
+	switch {
+	case err == ErrKeyNotFound:
+		match = expected == nil
+	case err != nil:
+		return false, err
+	case expected != nil:
+		if err := item.Value(func(val []byte) error {
+			match = bytes.Equal(val, expected)
+			return nil
+		}); err != nil {
+			return false, err
+		}
+	}
+	if !match {
+		return false, nil
+	}
+	if err := txn.Set(key, newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // Get looks for key and returns corresponding Item.
 // If key is not found, ErrKeyNotFound is returned.
 func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
@@ -380,27 +673,15 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 	} else if txn.discarded {
 		return nil, ErrDiscardedTxn
 	}
+	if txn.db.hotKeys != nil {
+		txn.db.hotKeys.record(key)
+	}
 
-	item = new(Item)
-	if txn.update {
-		if e, has := txn.pendingWrites[string(key)]; has && bytes.Equal(key, e.Key) {
-			if isDeletedOrExpired(e.meta, e.ExpiresAt) {
-				return nil, ErrKeyNotFound
-			}
-			// Fulfill from cache.
-			item.meta = e.meta
-			item.val = e.Value
-			item.userMeta = e.UserMeta
-			item.key = key
-			item.status = prefetched
-			item.version = txn.readTs
-			item.expiresAt = e.ExpiresAt
-			// We probably don't need to set db on item here.
-			return item, nil
+	if item, ok := txn.getPending(key); ok {
+		if item == nil {
+			return nil, ErrKeyNotFound
 		}
-		// Only track reads if this is update txn. No need to track read if txn serviced it
-		// internally.
-		txn.addReadKey(key)
+		return item, nil
 	}
 
 	seek := y.KeyWithTs(key, txn.readTs)
@@ -408,13 +689,125 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "DB::Get key: %q", key)
 	}
-	if vs.Value == nil && vs.Meta == 0 {
+	if item = txn.newItem(key, vs, txn.readTs, true); item == nil {
 		return nil, ErrKeyNotFound
 	}
-	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+	return item, nil
+}
+
+// GetVersion looks for the newest version of key which is at most maxVersion, and returns its
+// Item. Unlike iterating over all the versions, it seeks the version right away, in the memtables
+// and in the tables. The versions above the read timestamp of the transaction are never seen, and
+// neither are its pending writes. If the version found is deleted or expired, or if there's no
+// version at or below maxVersion, ErrKeyNotFound is returned.
+//
+// It can only be used with managed transactions, GetVersion panics otherwise. The read is tracked
+// for conflict detection just like with Get.
+func (txn *Txn) GetVersion(key []byte, maxVersion uint64) (*Item, error) {
+	if !txn.db.opt.managedTxns {
+		panic("GetVersion can only be used with managed transactions")
+	}
+	if len(key) == 0 {
+		return nil, ErrEmptyKey
+	} else if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	if txn.db.hotKeys != nil {
+		txn.db.hotKeys.record(key)
+	}
+	if maxVersion > txn.readTs {
+		maxVersion = txn.readTs
+	}
+	txn.addReadKey(key)
+
+	vs, err := txn.db.get(y.KeyWithTs(key, maxVersion))
+	if err != nil {
+		return nil, errors.Wrapf(err, "DB::GetVersion key: %q", key)
+	}
+	item := txn.newItem(key, vs, maxVersion, false)
+	if item == nil {
 		return nil, ErrKeyNotFound
 	}
+	return item, nil
+}
+
+// getPending looks for key in the pending writes of an update transaction. It
+// returns false if the key has to be looked up in the DB, and tracks the read
+// in that case. It returns a nil item if the key was deleted in the transaction.
+func (txn *Txn) getPending(key []byte) (*Item, bool) {
+	if !txn.update {
+		return nil, false
+	}
+	if e, has := txn.pendingWrites[string(key)]; has && bytes.Equal(key, e.Key) {
+		if isDeletedOrExpired(e.meta, e.ExpiresAt, txn.db.opt.now()) {
+			return nil, true
+		}
+		// Fulfill from cache.
+		item := new(Item)
+		item.meta = e.meta
+		item.val = e.Value
+		item.vptr = e.Value // For ValueSize and EstimatedSize.
+		item.userMeta = e.UserMeta
+		item.metadata = e.Metadata
+		item.key = key
+		item.status = prefetched
+		item.version = txn.readTs
+		item.expiresAt = e.ExpiresAt
+		// We probably don't need to set db on item here.
+		return item, true
+	}
+	if txn.pendingRangeDeleted(key) {
+		return nil, true
+	}
+	// Only track reads if this is update txn. No need to track read if txn serviced it
+	// internally.
+	txn.addReadKey(key)
+	return nil, false
+}
 
+// Exists returns true if key is set, and neither deleted nor expired, at the read timestamp of
+// the transaction. Unlike Get, it doesn't create an Item, and never reads the value of the key.
+//
+// The read is tracked for conflict detection just like with Get.
+func (txn *Txn) Exists(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrEmptyKey
+	} else if txn.discarded {
+		return false, ErrDiscardedTxn
+	}
+	if txn.db.hotKeys != nil {
+		txn.db.hotKeys.record(key)
+	}
+
+	if item, ok := txn.getPending(key); ok {
+		return item != nil, nil
+	}
+
+	seek := y.KeyWithTs(key, txn.readTs)
+	vs, err := txn.db.get(seek)
+	if err != nil {
+		return false, errors.Wrapf(err, "DB::Exists key: %q", key)
+	}
+	return valueExists(vs, txn.db.opt.now()) &&
+		!txn.rangeDeleted(key, vs.Version, txn.readTs, true), nil
+}
+
+// valueExists returns true if vs, read from the DB, holds a value which is neither deleted nor
+// expired at now.
+func valueExists(vs y.ValueStruct, now uint64) bool {
+	if vs.Value == nil && vs.Meta == 0 {
+		return false
+	}
+	return !isDeletedOrExpired(vs.Meta, vs.ExpiresAt, now)
+}
+
+// newItem returns the item for key read from the DB at readTs, or nil if it was not found. The
+// range deletes of the transaction are only applied if pending is set, see rangeDeleted.
+func (txn *Txn) newItem(key []byte, vs y.ValueStruct, readTs uint64, pending bool) *Item {
+	if !valueExists(vs, txn.db.opt.now()) || txn.rangeDeleted(key, vs.Version, readTs, pending) {
+		return nil
+	}
+	item := new(Item)
 	item.key = key
 	item.version = vs.Version
 	item.meta = vs.Meta
@@ -423,16 +816,86 @@ func (txn *Txn) Get(key []byte) (item *Item, rerr error) {
 	item.vptr = y.SafeCopy(item.vptr, vs.Value)
 	item.txn = txn
 	item.expiresAt = vs.ExpiresAt
-	return item, nil
+	return item
+}
+
+// GetMulti looks up several keys at once. It returns the items in the same order
+// as keys, with a nil item for every key which is not found. Looking up the keys
+// together is cheaper than calling Get for each of them: the keys are sorted, and
+// then looked up in a single pass over the memtables and the tables of every
+// level, reusing the table iterators (and so their blocks) across keys.
+//
+// The reads are tracked for conflict detection just like with Get.
+func (txn *Txn) GetMulti(keys [][]byte) ([]*Item, error) {
+	if txn.discarded {
+		return nil, ErrDiscardedTxn
+	}
+	items := make([]*Item, len(keys))
+	var lookup []int // Indices of the keys to look up in the DB.
+	for i, key := range keys {
+		if len(key) == 0 {
+			return nil, ErrEmptyKey
+		}
+		if txn.db.hotKeys != nil {
+			txn.db.hotKeys.record(key)
+		}
+		if item, ok := txn.getPending(key); ok {
+			items[i] = item
+			continue
+		}
+		lookup = append(lookup, i)
+	}
+	if len(lookup) == 0 {
+		return items, nil
+	}
+	sort.Slice(lookup, func(i, j int) bool {
+		return txn.db.cmp.Compare(keys[lookup[i]], keys[lookup[j]]) < 0
+	})
+	seeks := make([][]byte, len(lookup))
+	for i, idx := range lookup {
+		seeks[i] = y.KeyWithTs(keys[idx], txn.readTs)
+	}
+	vals, err := txn.db.getMulti(seeks)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DB::GetMulti")
+	}
+	for i, idx := range lookup {
+		items[idx] = txn.newItem(keys[idx], vals[i], txn.readTs, true)
+	}
+	return items, nil
 }
 
 func (txn *Txn) addReadKey(key []byte) {
-	if txn.update {
+	if txn.update && !txn.skipConflicts {
 		fp := z.MemHash(key)
 		txn.reads = append(txn.reads, fp)
 	}
 }
 
+func (txn *Txn) addWriteKey(key []byte) {
+	if !txn.skipConflicts {
+		fp := z.MemHash(key) // Avoid dealing with byte arrays.
+		txn.writes = append(txn.writes, fp)
+	}
+}
+
+// SetDetectConflicts enables or disables the conflict detection of the transaction, which is
+// enabled by default. It must be called before any other operation of the transaction, and
+// panics otherwise.
+//
+// Without conflict detection, the keys read and written by the transaction are not tracked, so
+// its Commit never returns ErrConflict, and it doesn't make any other transaction fail with
+// ErrConflict either. This saves the tracking for the transactions which can't conflict, like
+// the appends of new keys to a log, but the caller takes responsibility for their correctness:
+// if such a transaction writes keys that conflict-tracked transactions read or write too, the
+// lost updates and the write skews that conflict detection prevents can happen.
+func (txn *Txn) SetDetectConflicts(detect bool) {
+	if len(txn.reads) > 0 || len(txn.pendingWrites) > 0 || atomic.LoadInt32(&txn.numIterators) > 0 {
+		panic("SetDetectConflicts must be called before any other operation of the transaction")
+	}
+	txn.skipConflicts = !detect
+}
+
 // Discard discards a created transaction. This method is very important and must be called. Commit
 // method calls this internally, however, calling this multiple times doesn't cause any issues. So,
 // this can safely be called via a defer right when transaction is created.
@@ -446,6 +909,7 @@ func (txn *Txn) Discard() {
 		panic("Unclosed iterator at time of Txn.Discard.")
 	}
 	txn.discarded = true
+	atomic.AddInt32(&txn.db.activeTxns, -1)
 	if !txn.db.orc.isManaged {
 		txn.db.orc.readMark.Done(txn.readTs)
 	}
@@ -454,7 +918,27 @@ func (txn *Txn) Discard() {
 	}
 }
 
+// onlyDeletes returns true if the pending writes of the transaction are all deletes or range
+// deletes. They're accepted even once the disk is full, as they're needed to reclaim the space.
+func (txn *Txn) onlyDeletes() bool {
+	for _, e := range txn.pendingWrites {
+		if e.meta&bitDelete == 0 && !bytes.HasPrefix(e.Key, rangeDelPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
 func (txn *Txn) commitAndSend() (func() error, error) {
+	if txn.closing {
+		return nil, ErrDBClosing
+	}
+	if txn.db.isDiskFull() && !txn.onlyDeletes() {
+		return nil, ErrDiskFull
+	}
+	if txn.db.opt.RejectOnStall && txn.db.WriteStalled() {
+		return nil, ErrWriteStalled
+	}
 	orc := txn.db.orc
 	// Ensure that the order in which we get the commit timestamp is the same as
 	// the order in which we push these updates to the write channel. So, we
@@ -474,9 +958,19 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 	// var b strings.Builder
 	// fmt.Fprintf(&b, "Read: %d. Commit: %d. reads: %v. writes: %v. Keys: ",
 	// 	txn.readTs, commitTs, txn.reads, txn.writes)
+	var defaultExpiresAt uint64
+	if ttl := txn.db.opt.DefaultTTL; ttl > 0 {
+		defaultExpiresAt = txn.db.opt.expiresAt(ttl)
+	}
 	entries := make([]*Entry, 0, len(txn.pendingWrites)+1)
 	for _, e := range txn.pendingWrites {
 		// fmt.Fprintf(&b, "[%q : %q], ", e.Key, e.Value)
+		// Only the entries of the user get the default TTL, not the internal ones like the
+		// range tombstones, nor the leases of the sequences.
+		if e.ExpiresAt == 0 && e.meta&bitDelete == 0 && !e.noTTL &&
+			!bytes.HasPrefix(e.Key, badgerPrefix) {
+			e.ExpiresAt = defaultExpiresAt
+		}
 
 		// Suffix the keys with commit ts, so the key versions are sorted in
 		// descending order of commit timestamp.
@@ -492,13 +986,31 @@ func (txn *Txn) commitAndSend() (func() error, error) {
 	}
 	entries = append(entries, e)
 
+	// Readers only see the range tombstones once commitTs is done, so they can be added before the
+	// entries are written.
+	for i := range txn.rangeDels {
+		txn.rangeDels[i].version = commitTs
+		txn.db.rangeDels.add(txn.rangeDels[i])
+	}
+	removeRangeDels := func() {
+		for _, t := range txn.rangeDels {
+			txn.db.rangeDels.remove(t)
+		}
+	}
+
 	req, err := txn.db.sendToWriteCh(entries)
 	if err != nil {
+		removeRangeDels()
 		orc.doneCommit(commitTs)
 		return nil, err
 	}
 	ret := func() error {
 		err := req.Wait()
+		if err != nil {
+			removeRangeDels()
+		} else {
+			txn.committedTs = commitTs
+		}
 		// Wait before marking commitTs as done.
 		// We can't defer doneCommit above, because it is being called from a
 		// callback here.
@@ -539,7 +1051,7 @@ func (txn *Txn) Commit() error {
 	txn.commitPrecheck() // Precheck before discarding txn.
 	defer txn.Discard()
 
-	if len(txn.writes) == 0 {
+	if len(txn.pendingWrites) == 0 {
 		return nil // Nothing to do.
 	}
 
@@ -588,7 +1100,7 @@ func (txn *Txn) CommitWith(cb func(error)) {
 		panic("Nil callback provided to CommitWith")
 	}
 
-	if len(txn.writes) == 0 {
+	if len(txn.pendingWrites) == 0 {
 		// Do not run these callbacks from here, because the CommitWith and the
 		// callback might be acquiring the same locks. Instead run the callback
 		// from another goroutine.
@@ -610,6 +1122,14 @@ func (txn *Txn) ReadTs() uint64 {
 	return txn.readTs
 }
 
+// CommitTs returns the commit timestamp of the transaction, which is the version of the keys it
+// wrote, once Commit or CommitAt returned successfully, or in the callback of CommitWith. It can be
+// handed to the readers of a change feed, to resume from it. It returns 0 before the commit, if
+// the commit failed, and for the transactions which didn't write anything.
+func (txn *Txn) CommitTs() uint64 {
+	return txn.committedTs
+}
+
 // NewTransaction creates a new transaction. Badger supports concurrent execution of transactions,
 // providing serializable snapshot isolation, avoiding write skews. Badger achieves this by tracking
 // the keys read and at Commit time, ensuring that these read keys weren't concurrently modified by
@@ -641,11 +1161,13 @@ func (db *DB) newTransaction(update, isManaged bool) *Txn {
 	}
 
 	txn := &Txn{
-		update: update,
-		db:     db,
-		count:  1,                       // One extra entry for BitFin.
-		size:   int64(len(txnKey) + 10), // Some buffer for the extra entry.
+		update:  update,
+		db:      db,
+		closing: atomic.LoadInt32(&db.draining) == 1,
 	}
+	atomic.AddInt32(&db.activeTxns, 1)
+	// One extra entry for BitFin.
+	txn.count, txn.size = txnOverhead()
 	if update {
 		txn.pendingWrites = make(map[string]*Entry)
 		txn.db.orc.addRef()
@@ -683,6 +1205,89 @@ func (db *DB) View(fn func(txn *Txn) error) error {
 	return fn(txn)
 }
 
+// KVResult is the result of the lookup of a key by DB.BatchGet.
+type KVResult struct {
+	Key       []byte
+	Value     []byte
+	UserMeta  byte
+	ExpiresAt uint64
+	Version   uint64
+	// NotFound is set if the key doesn't exist, or is deleted or expired. The other fields but Key
+	// are then zero.
+	NotFound bool
+}
+
+// BatchGet looks up the given keys and reads their values, outside of any transaction created by
+// the user. It returns a result for each key, in the same order as keys. All the keys are read at
+// a single read timestamp, like in a read-only transaction, so no read is tracked for conflict
+// detection. If BatchGet is used with managed transactions, it would assume a read timestamp of
+// MaxUint64, like View.
+//
+// This is faster than a Get per key for many keys: the keys are looked up together in the LSM tree
+// as by Txn.GetMulti, then the values stored in the value log are read in the order of their
+// position in the value log, by up to Options.BatchGetConcurrency goroutines. The returned values
+// don't share memory with the DB.
+func (db *DB) BatchGet(keys [][]byte) ([]KVResult, error) {
+	var txn *Txn
+	if db.opt.managedTxns {
+		txn = db.NewTransactionAt(math.MaxUint64, false)
+	} else {
+		txn = db.NewTransaction(false)
+	}
+	defer txn.Discard()
+
+	items, err := txn.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	type vlogRead struct {
+		idx int
+		vp  valuePointer
+	}
+	var reads []vlogRead
+	results := make([]KVResult, len(keys))
+	for i, item := range items {
+		res := &results[i]
+		res.Key = keys[i]
+		if item == nil {
+			res.NotFound = true
+			continue
+		}
+		res.UserMeta, res.ExpiresAt, res.Version = item.UserMeta(), item.ExpiresAt(), item.Version()
+		if item.meta&bitValuePointer == 0 {
+			if res.Value, err = item.ValueCopy(nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		r := vlogRead{idx: i}
+		r.vp.Decode(item.vptr)
+		reads = append(reads, r)
+	}
+
+	// Read the values in the order of the value log, so that the reads of a file are close.
+	sort.Slice(reads, func(i, j int) bool { return reads[i].vp.Less(reads[j].vp) })
+	concurrency := db.opt.BatchGetConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	throttle := y.NewThrottle(concurrency)
+	for _, r := range reads {
+		if err := throttle.Do(); err != nil {
+			break
+		}
+		go func(i int) {
+			var err error
+			results[i].Value, err = items[i].ValueCopy(nil)
+			throttle.Done(err)
+		}(r.idx)
+	}
+	if err := throttle.Finish(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 // Update executes a function, creating and managing a read-write transaction
 // for the user. Error returned by the function is relayed by the Update method.
 // Update cannot be used with managed transactions.
@@ -699,3 +1304,66 @@ func (db *DB) Update(fn func(txn *Txn) error) error {
 
 	return txn.Commit()
 }
+
+// RetryOptions are params for DB.UpdateWithRetry.
+//
+// This package provides DefaultRetryOptions which contains options that should work for most
+// applications.
+type RetryOptions struct {
+	// MaxAttempts is the maximum number of times the function is run. Zero means no limit.
+	MaxAttempts int
+	// InitialBackoff is the time waited before the first retry. It doubles after every retry, up
+	// to MaxBackoff. Each wait is picked randomly between half and all of the backoff, so that
+	// conflicting writers don't retry in lockstep.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// OnDone is called, if set, with the number of attempts made and the error returned by
+	// UpdateWithRetry, e.g. to record metrics.
+	OnDone func(attempts int, err error)
+}
+
+// DefaultRetryOptions contains default options for DB.UpdateWithRetry.
+var DefaultRetryOptions = RetryOptions{
+	MaxAttempts:    10,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+}
+
+// UpdateWithRetry is like Update, but runs fn again in a new transaction when the transaction
+// conflicts with another one, i.e. when fn or the commit returns ErrConflict. It waits between
+// the attempts as configured by opts. Any other error is returned immediately. It returns
+// ErrConflict if the last attempt conflicts, or the error of ctx if ctx is done before an attempt.
+func (db *DB) UpdateWithRetry(ctx context.Context, fn func(txn *Txn) error,
+	opts RetryOptions) (err error) {
+	var attempts int
+	if opts.OnDone != nil {
+		defer func() { opts.OnDone(attempts, err) }()
+	}
+	backoff := opts.InitialBackoff
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		attempts++
+		if err = db.Update(fn); err != ErrConflict {
+			return err
+		}
+		if opts.MaxAttempts > 0 && attempts >= opts.MaxAttempts {
+			return err
+		}
+
+		if backoff > 0 {
+			wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+			if backoff *= 2; opts.MaxBackoff > 0 && backoff > opts.MaxBackoff {
+				backoff = opts.MaxBackoff
+			}
+		}
+	}
+}
@@ -17,11 +17,14 @@
 package badger
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -95,6 +98,186 @@ func TestTxnReadAfterWrite(t *testing.T) {
 	})
 }
 
+func TestTxnGetMulti(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := func(i int) []byte {
+			return []byte(fmt.Sprintf("key=%05d", i))
+		}
+		val := make([]byte, 128)
+		// Write a few versions of every key, so that they end up in the memtable and on
+		// several levels, and delete some of them.
+		N := 2000
+		for round := 0; round < 3; round++ {
+			for i := 0; i < N; i += 20 {
+				txn := db.NewTransaction(true)
+				for j := i; j < i+20; j++ {
+					v := append([]byte(fmt.Sprintf("%d-%d", round, j)), val...)
+					require.NoError(t, txn.SetEntry(NewEntry(key(j), v)))
+				}
+				require.NoError(t, txn.Commit())
+			}
+		}
+		for i := 0; i < N; i += 140 {
+			txn := db.NewTransaction(true)
+			for j := i; j < i+140; j += 7 {
+				require.NoError(t, txn.Delete(key(j)))
+			}
+			require.NoError(t, txn.Commit())
+		}
+
+		checkItems := func(txn *Txn, keys [][]byte) {
+			items, err := txn.GetMulti(keys)
+			require.NoError(t, err)
+			require.Len(t, items, len(keys))
+			for i, k := range keys {
+				want, err := txn.Get(k)
+				if err == ErrKeyNotFound {
+					require.Nil(t, items[i], "key %q", k)
+					continue
+				}
+				require.NoError(t, err)
+				require.NotNil(t, items[i], "key %q", k)
+				require.Equal(t, k, items[i].Key())
+				require.Equal(t, want.Version(), items[i].Version())
+				wv, err := want.ValueCopy(nil)
+				require.NoError(t, err)
+				gv, err := items[i].ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, wv, gv)
+			}
+		}
+
+		var keys [][]byte
+		for i := N + 10; i >= 0; i -= 3 { // Unsorted, and some keys are missing.
+			keys = append(keys, key(i))
+		}
+		keys = append(keys, key(5), key(5))
+
+		txn := db.NewTransaction(false)
+		checkItems(txn, keys)
+		txn.Discard()
+
+		txn = db.NewTransaction(true)
+		require.NoError(t, txn.SetEntry(NewEntry(key(3), []byte("pending"))))
+		require.NoError(t, txn.Delete(key(6)))
+		checkItems(txn, keys)
+		items, err := txn.GetMulti([][]byte{key(3), key(6)})
+		require.NoError(t, err)
+		require.Nil(t, items[1])
+		v, err := items[0].ValueCopy(nil)
+		require.NoError(t, err)
+		require.Equal(t, []byte("pending"), v)
+
+		_, err = txn.GetMulti([][]byte{key(1), nil})
+		require.Equal(t, ErrEmptyKey, err)
+		txn.Discard()
+		_, err = txn.GetMulti(keys)
+		require.Equal(t, ErrDiscardedTxn, err)
+	})
+}
+
+func TestBatchGet(t *testing.T) {
+	opt := getTestOptions("").WithBatchGetConcurrency(4)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		key := func(i int) []byte {
+			return []byte(fmt.Sprintf("key=%05d", i))
+		}
+		// Values alternate between the LSM tree and the value log.
+		val := func(i int) []byte {
+			v := []byte(fmt.Sprintf("%d", i))
+			if i%2 == 0 {
+				v = append(v, make([]byte, db.opt.ValueThreshold)...)
+			}
+			return v
+		}
+		N := 500
+		wb := db.NewWriteBatch()
+		for i := 0; i < N; i++ {
+			require.NoError(t, wb.SetEntry(NewEntry(key(i), val(i)).WithMeta(byte(i))))
+		}
+		require.NoError(t, wb.Flush())
+		txnDelete(t, db, key(7))
+
+		var keys [][]byte
+		for i := N + 10; i >= 0; i -= 3 { // Unsorted, and some keys are missing.
+			keys = append(keys, key(i))
+		}
+		keys = append(keys, key(7))
+		res, err := db.BatchGet(keys)
+		require.NoError(t, err)
+		require.Len(t, res, len(keys))
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i, k := range keys {
+				require.Equal(t, k, res[i].Key)
+				item, err := txn.Get(k)
+				if err == ErrKeyNotFound {
+					require.True(t, res[i].NotFound, "key %q", k)
+					require.Nil(t, res[i].Value)
+					continue
+				}
+				require.NoError(t, err)
+				require.False(t, res[i].NotFound, "key %q", k)
+				require.Equal(t, item.Version(), res[i].Version)
+				require.Equal(t, item.UserMeta(), res[i].UserMeta)
+				v, err := item.ValueCopy(nil)
+				require.NoError(t, err)
+				require.Equal(t, v, res[i].Value)
+			}
+			return nil
+		}))
+		require.True(t, res[len(res)-1].NotFound)
+	})
+}
+
+func TestTxnCompareAndSet(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("leader")
+		cas := func(txn *Txn, expected, val []byte) bool {
+			ok, err := txn.CompareAndSet(key, expected, val)
+			require.NoError(t, err)
+			return ok
+		}
+		get := func() []byte {
+			var val []byte
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get(key)
+				if err != nil {
+					return err
+				}
+				val, err = item.ValueCopy(nil)
+				return err
+			}))
+			return val
+		}
+
+		txn := db.NewTransaction(true)
+		require.False(t, cas(txn, []byte{}, []byte("a"))) // Empty is not missing.
+		require.True(t, cas(txn, nil, []byte("a")))
+		require.True(t, cas(txn, []byte("a"), []byte("b"))) // Sees the pending write.
+		require.NoError(t, txn.Commit())
+		require.Equal(t, []byte("b"), get())
+
+		txn = db.NewTransaction(true)
+		require.False(t, cas(txn, nil, []byte("c")))
+		require.False(t, cas(txn, []byte("a"), []byte("c")))
+		txn.Discard()
+
+		// The comparison is validated at commit time.
+		txn1 := db.NewTransaction(true)
+		txn2 := db.NewTransaction(true)
+		require.True(t, cas(txn1, []byte("b"), []byte("txn1")))
+		require.True(t, cas(txn2, []byte("b"), []byte("txn2")))
+		require.NoError(t, txn1.Commit())
+		require.Equal(t, ErrConflict, txn2.Commit())
+		require.Equal(t, []byte("txn1"), get())
+
+		txn = db.NewTransaction(false)
+		_, err := txn.CompareAndSet(key, nil, []byte("c"))
+		require.Equal(t, ErrReadOnlyTxn, err)
+		txn.Discard()
+	})
+}
+
 func TestTxnCommitAsync(t *testing.T) {
 	key := func(i int) []byte {
 		return []byte(fmt.Sprintf("key=%d", i))
@@ -876,3 +1059,692 @@ func TestArmV7Issue311Fix(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestTxnCommitTs(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(true)
+		require.NoError(t, txn.Set([]byte("key"), []byte("val")))
+		require.Zero(t, txn.CommitTs())
+		require.NoError(t, txn.Commit())
+		require.NotZero(t, txn.CommitTs())
+		require.NoError(t, db.View(func(txn2 *Txn) error {
+			item, err := txn2.Get([]byte("key"))
+			require.NoError(t, err)
+			require.Equal(t, txn.CommitTs(), item.Version())
+			return nil
+		}))
+
+		// The callback of CommitWith sees the commit timestamp.
+		txn = db.NewTransaction(true)
+		require.NoError(t, txn.Set([]byte("key"), []byte("val2")))
+		done := make(chan uint64)
+		txn.CommitWith(func(err error) {
+			require.NoError(t, err)
+			done <- txn.CommitTs()
+		})
+		ts := <-done
+		require.True(t, ts > txn.ReadTs())
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("key"))
+			require.NoError(t, err)
+			require.Equal(t, ts, item.Version())
+			return nil
+		}))
+
+		// Nothing is committed without writes, or on a conflict.
+		txn = db.NewTransaction(true)
+		require.NoError(t, txn.Commit())
+		require.Zero(t, txn.CommitTs())
+
+		txn = db.NewTransaction(true)
+		_, err := txn.Get([]byte("key"))
+		require.NoError(t, err)
+		require.NoError(t, txn.Set([]byte("key"), []byte("val3")))
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("key"), []byte("val4"))
+		}))
+		require.Equal(t, ErrConflict, txn.Commit())
+		require.Zero(t, txn.CommitTs())
+	})
+}
+
+func TestTxnDefaultTTL(t *testing.T) {
+	opt := getTestOptions("")
+	opt.DefaultTTL = time.Second
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		start := uint64(time.Now().Unix())
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			if err := txn.Set([]byte("default"), []byte("val")); err != nil {
+				return err
+			}
+			if err := txn.SetEntry(NewEntry([]byte("explicit"), []byte("val")).
+				WithTTL(time.Hour)); err != nil {
+				return err
+			}
+			return txn.Delete([]byte("deleted"))
+		}))
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("default"))
+			require.NoError(t, err)
+			require.True(t, item.ExpiresAt() >= start+1 && item.ExpiresAt() <= start+2)
+
+			// An explicit TTL wins over the default one.
+			item, err = txn.Get([]byte("explicit"))
+			require.NoError(t, err)
+			require.True(t, item.ExpiresAt() >= start+3600)
+
+			opts := DefaultIteratorOptions
+			opts.AllVersions = true
+			it := txn.NewKeyIterator([]byte("deleted"), opts)
+			defer it.Close()
+			it.Rewind()
+			require.True(t, it.Valid())
+			require.Zero(t, it.Item().ExpiresAt())
+			return nil
+		}))
+
+		time.Sleep(1100 * time.Millisecond)
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("default"))
+			require.Equal(t, ErrKeyNotFound, err)
+			_, err = txn.Get([]byte("explicit"))
+			require.NoError(t, err)
+			return nil
+		}))
+	})
+}
+
+func TestTxnPendingWrites(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		txn := db.NewTransaction(true)
+		defer txn.Discard()
+		count, size := txn.PendingWrites()
+		require.Zero(t, count)
+		require.Zero(t, size)
+
+		// Small values are accounted in full, large ones as a value pointer.
+		require.NoError(t, txn.Set([]byte("key"), []byte("value")))
+		count, size = txn.PendingWrites()
+		require.Equal(t, 1, count)
+		require.Equal(t, int64(3+5+12), size)
+		require.NoError(t, txn.Set([]byte("big"), make([]byte, db.opt.ValueThreshold)))
+		count, size = txn.PendingWrites()
+		require.Equal(t, 2, count)
+		require.Equal(t, int64(3+5+12+3+12+12), size)
+
+		// Writes succeed as long as they fit in the headroom.
+		val := make([]byte, 10)
+		for i := 0; ; i++ {
+			key := []byte(fmt.Sprintf("key%08d", i))
+			headCount, headSize := txn.Headroom()
+			fits := headCount >= 1 && headSize >= int64(len(key)+len(val)+12)
+			count, size = txn.PendingWrites()
+			err := txn.Set(key, val)
+			if !fits {
+				require.Equal(t, ErrTxnTooBig, err)
+				// The write which didn't fit isn't accounted.
+				newCount, newSize := txn.PendingWrites()
+				require.Equal(t, count, newCount)
+				require.Equal(t, size, newSize)
+				break
+			}
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestUpdateWithRetry(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		key := []byte("key")
+		// conflicting reads key, and makes the transaction conflict on its first n runs by writing
+		// key in another transaction.
+		conflicting := func(n int) func(txn *Txn) error {
+			runs := 0
+			return func(txn *Txn) error {
+				runs++
+				if _, err := txn.Get(key); err != nil && err != ErrKeyNotFound {
+					return err
+				}
+				if runs <= n {
+					require.NoError(t, db.Update(func(txn *Txn) error {
+						return txn.Set(key, []byte("other"))
+					}))
+				}
+				return txn.Set(key, []byte("value"))
+			}
+		}
+		var attempts int
+		var doneErr error
+		opts := RetryOptions{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     2 * time.Millisecond,
+			OnDone: func(n int, err error) {
+				attempts, doneErr = n, err
+			},
+		}
+		ctx := context.Background()
+
+		require.NoError(t, db.UpdateWithRetry(ctx, conflicting(2), opts))
+		require.Equal(t, 3, attempts)
+		require.NoError(t, doneErr)
+		txnGet := func() string {
+			var val []byte
+			require.NoError(t, db.View(func(txn *Txn) error {
+				item, err := txn.Get(key)
+				require.NoError(t, err)
+				val, err = item.ValueCopy(nil)
+				return err
+			}))
+			return string(val)
+		}
+		require.Equal(t, "value", txnGet())
+
+		// The conflict is returned once the attempts are exhausted.
+		require.Equal(t, ErrConflict, db.UpdateWithRetry(ctx, conflicting(3), opts))
+		require.Equal(t, 3, attempts)
+		require.Equal(t, ErrConflict, doneErr)
+
+		// Other errors are returned without retrying.
+		errTest := errors.New("test")
+		require.Equal(t, errTest, db.UpdateWithRetry(ctx, func(txn *Txn) error {
+			return errTest
+		}, opts))
+		require.Equal(t, 1, attempts)
+
+		// A canceled context stops the retries.
+		ctx, cancel := context.WithCancel(ctx)
+		opts.MaxAttempts = 0
+		opts.InitialBackoff = time.Hour
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+		require.Equal(t, context.Canceled, db.UpdateWithRetry(ctx, conflicting(100), opts))
+		require.Equal(t, 1, attempts)
+	})
+}
+
+// testClock is a Clock set by the tests.
+type testClock struct {
+	now uint64
+}
+
+func (c *testClock) Now() uint64 { return atomic.LoadUint64(&c.now) }
+
+func TestTxnClock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	clock := &testClock{now: 1000}
+	opt := getTestOptions(dir).WithClock(clock).WithDefaultTTL(20 * time.Second)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		if err := txn.SetEntry(NewEntry([]byte("a"), []byte("val")).
+			WithTTL(10 * time.Second)); err != nil {
+			return err
+		}
+		return txn.Set([]byte("b"), []byte("val"))
+	}))
+	get := func(key string) (*Item, error) {
+		txn := db.NewTransaction(false)
+		defer txn.Discard()
+		return txn.Get([]byte(key))
+	}
+	item, err := get("a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1010), item.ExpiresAt())
+	item, err = get("b")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1020), item.ExpiresAt())
+
+	// The reads skip the entries once the clock reaches their expiry.
+	atomic.StoreUint64(&clock.now, 1010)
+	_, err = get("a")
+	require.Equal(t, ErrKeyNotFound, err)
+	_, err = get("b")
+	require.NoError(t, err)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		it := txn.NewIterator(DefaultIteratorOptions)
+		defer it.Close()
+		var keys []string
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().Key()))
+		}
+		require.Equal(t, []string{"b"}, keys)
+		return nil
+	}))
+
+	// The compactions discard them too, which turning the clock back reveals.
+	atomic.StoreUint64(&clock.now, 1000)
+	_, err = get("a")
+	require.NoError(t, err)
+	atomic.StoreUint64(&clock.now, 1010)
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.NoError(t, db.Flatten(1))
+	atomic.StoreUint64(&clock.now, 1000)
+	_, err = get("a")
+	require.Equal(t, ErrKeyNotFound, err)
+	_, err = get("b")
+	require.NoError(t, err)
+}
+
+func TestTxnDefaultTTLInternalKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	clock := &testClock{now: 1000}
+	opt := getTestOptions(dir).WithClock(clock).WithDefaultTTL(20 * time.Second)
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	seq, err := db.GetSequence([]byte("seq"), 10)
+	require.NoError(t, err)
+	num, err := seq.Next()
+	require.NoError(t, err)
+	require.Zero(t, num)
+	require.NoError(t, seq.Release())
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.SetEntry(NewEntry([]byte("a"), []byte("val")).WithTTL(time.Hour))
+	}))
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.DeleteRange([]byte("a"), []byte("b"))
+	}))
+	require.NoError(t, db.Close())
+
+	// Neither the lease of the sequence nor the range tombstone expire.
+	atomic.StoreUint64(&clock.now, 2000)
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	seq, err = db.GetSequence([]byte("seq"), 10)
+	require.NoError(t, err)
+	num, err = seq.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), num)
+	require.NoError(t, seq.Release())
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("a"))
+		require.Equal(t, ErrKeyNotFound, err)
+		return nil
+	}))
+}
+
+func TestTxnExists(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		big := make([]byte, db.opt.ValueThreshold+1)
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			require.NoError(t, txn.Set([]byte("small"), []byte("value")))
+			require.NoError(t, txn.Set([]byte("big"), big))
+			require.NoError(t, txn.Set([]byte("deleted"), []byte("value")))
+			return txn.SetEntry(NewEntry([]byte("expired"), []byte("value")).WithTTL(time.Second))
+		}))
+		readTxn := db.NewTransaction(false)
+		defer readTxn.Discard()
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Delete([]byte("deleted"))
+		}))
+
+		exists := func(txn *Txn, key string) bool {
+			ok, err := txn.Exists([]byte(key))
+			require.NoError(t, err)
+			return ok
+		}
+		// The transaction reads at its read timestamp.
+		require.True(t, exists(readTxn, "deleted"))
+
+		time.Sleep(time.Second)
+		txn := db.NewTransaction(true)
+		require.True(t, exists(txn, "small"))
+		require.True(t, exists(txn, "big"))
+		require.False(t, exists(txn, "deleted"))
+		require.False(t, exists(txn, "expired"))
+		require.False(t, exists(txn, "missing"))
+
+		// Pending writes are taken into account.
+		require.NoError(t, txn.Set([]byte("missing"), []byte("value")))
+		require.NoError(t, txn.Delete([]byte("small")))
+		require.True(t, exists(txn, "missing"))
+		require.False(t, exists(txn, "small"))
+
+		// The read of big is tracked for conflict detection.
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set([]byte("big"), []byte("value"))
+		}))
+		require.Equal(t, ErrConflict, txn.Commit())
+
+		_, err := txn.Exists([]byte("small"))
+		require.Equal(t, ErrDiscardedTxn, err)
+	})
+}
+
+func TestTxnDeleteRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		txnSet(t, db, []byte(k), []byte(k+"1"), 0)
+	}
+	keys := func(txn *Txn, opt IteratorOptions) []string {
+		var res []string
+		itr := txn.NewIterator(opt)
+		defer itr.Close()
+		for itr.Rewind(); itr.Valid(); itr.Next() {
+			res = append(res, fmt.Sprintf("%s@%s", itr.Item().Key(), getItemValue(t, itr.Item())))
+		}
+		return res
+	}
+	reverse := DefaultIteratorOptions
+	reverse.Reverse = true
+
+	oldTxn := db.NewTransaction(false)
+	defer oldTxn.Discard()
+
+	txn := db.NewTransaction(true)
+	require.NoError(t, txn.Set([]byte("b"), []byte("b2")))
+	require.Equal(t, ErrInvalidRequest, txn.DeleteRange([]byte("d"), []byte("b")))
+	require.Equal(t, ErrEmptyKey, txn.DeleteRange(nil, []byte("b")))
+	require.NoError(t, txn.DeleteRange([]byte("b"), []byte("d")))
+	// Keys set after the range delete are not deleted.
+	require.NoError(t, txn.Set([]byte("c"), []byte("c2")))
+	_, err = txn.Get([]byte("b"))
+	require.Equal(t, ErrKeyNotFound, err)
+	require.Equal(t, []string{"a@a1", "c@c2", "d@d1"}, keys(txn, DefaultIteratorOptions))
+	require.Equal(t, []string{"d@d1", "c@c2", "a@a1"}, keys(txn, reverse))
+	require.NoError(t, txn.Commit())
+
+	check := func() {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			_, err := txn.Get([]byte("b"))
+			require.Equal(t, ErrKeyNotFound, err)
+			ok, err := txn.Exists([]byte("b"))
+			require.NoError(t, err)
+			require.False(t, ok)
+			items, err := txn.GetMulti([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+			require.NoError(t, err)
+			require.NotNil(t, items[0])
+			require.Nil(t, items[1])
+			require.Equal(t, []byte("c2"), getItemValue(t, items[2]))
+			require.Equal(t, []string{"a@a1", "c@c2", "d@d1"}, keys(txn, DefaultIteratorOptions))
+			require.Equal(t, []string{"d@d1", "c@c2", "a@a1"}, keys(txn, reverse))
+
+			// The deleted versions are gone for good.
+			opt := DefaultIteratorOptions
+			opt.AllVersions = true
+			require.Equal(t, []string{"a@a1", "c@c2", "d@d1"}, keys(txn, opt))
+			return nil
+		}))
+	}
+	check()
+	// Transactions reading before the range delete still see the keys.
+	require.Equal(t, []string{"a@a1", "b@b1", "c@c1", "d@d1"}, keys(oldTxn, DefaultIteratorOptions))
+	oldTxn.Discard()
+
+	// The range tombstone survives a restart, and a compaction discards the deleted versions.
+	require.NoError(t, db.Close())
+	db, err = Open(getTestOptions(dir))
+	require.NoError(t, err)
+	require.Len(t, db.rangeDels.load(), 1)
+	check()
+
+	// Once no deleted version is left, the tombstone is garbage collected.
+	db.gcRangeTombstones()
+	require.Len(t, db.rangeDels.load(), 0)
+	check()
+	require.NoError(t, db.Close())
+	db, err = Open(getTestOptions(dir))
+	require.NoError(t, err)
+	defer db.Close()
+	require.Len(t, db.rangeDels.load(), 0)
+	check()
+
+	// Keys written after the range delete are visible.
+	txnSet(t, db, []byte("b"), []byte("b3"), 0)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		require.Equal(t, []string{"a@a1", "b@b3", "c@c2", "d@d1"}, keys(txn, DefaultIteratorOptions))
+		return nil
+	}))
+}
+
+func TestTxnDeleteRangeExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	db, err := Open(getTestOptions(dir))
+	require.NoError(t, err)
+
+	txnSet(t, db, []byte("a"), []byte("a1"), 0)
+	txn := db.NewTransaction(true)
+	require.NoError(t, txn.DeleteRange([]byte("a"), []byte("b")))
+	require.Zero(t, txn.pendingWrites[string(rangeDelKey([]byte("a"), []byte("b")))].ExpiresAt)
+	// Earlier versions could write the tombstones with an expiry, which is ignored.
+	txn.pendingWrites[string(rangeDelKey([]byte("a"), []byte("b")))].ExpiresAt = 1
+	require.NoError(t, txn.Commit())
+	require.NoError(t, db.Close())
+
+	db, err = Open(getTestOptions(dir))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.Len(t, db.rangeDels.load(), 1)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		_, err := txn.Get([]byte("a"))
+		require.Equal(t, ErrKeyNotFound, err)
+		return nil
+	}))
+}
+
+func TestRangeTombstonesIndex(t *testing.T) {
+	var r rangeTombstones
+	var list []rangeTombstone
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%02d", i)) }
+	for i := 0; i < 50; i++ {
+		start := rand.Intn(40)
+		rt := rangeTombstone{start: key(start), version: uint64(rand.Intn(20) + 1)}
+		if i%10 != 0 {
+			rt.end = key(start + rand.Intn(10) + 1)
+		}
+		r.add(rt)
+		list = append(list, rt)
+	}
+	r.remove(list[0])
+	list = list[1:]
+
+	for k := 0; k < 55; k++ {
+		for version := uint64(0); version <= 21; version++ {
+			for _, readTs := range []uint64{5, 10, 21} {
+				want := false
+				for _, rt := range list {
+					if rt.contains(key(k)) && version < rt.version && rt.version <= readTs {
+						want = true
+					}
+				}
+				require.Equal(t, want, r.covers(key(k), version, readTs), "%d %d %d", k, version, readTs)
+			}
+		}
+	}
+}
+
+func TestRangeTombstonesCheck(t *testing.T) {
+	var r rangeTombstones
+	rt := rangeTombstone{start: []byte("b"), end: []byte("d"), version: 5}
+	r.add(rt)
+	ids := func(ts []rangeTombstone) (res []tombstoneID) {
+		for _, t := range ts {
+			res = append(res, t.id())
+		}
+		return res
+	}
+	kr := func(left, right string) keyRange {
+		return keyRange{left: y.KeyWithTs([]byte(left), 0), right: y.KeyWithTs([]byte(right), 0)}
+	}
+
+	// The readers can still see past the tombstone.
+	require.Empty(t, r.check(4))
+	// A new tombstone is checked once.
+	require.Equal(t, []tombstoneID{rt.id()}, ids(r.check(5)))
+	require.Empty(t, r.check(5))
+	// Then again each time a compaction touches its range.
+	r.touch(kr("d", "e"))
+	r.touch(kr("a", "a"))
+	require.Empty(t, r.check(5))
+	r.touch(kr("a", "b"))
+	require.Equal(t, []tombstoneID{rt.id()}, ids(r.check(5)))
+	r.touch(kr("c", "z"))
+	require.Equal(t, []tombstoneID{rt.id()}, ids(r.check(5)))
+	r.touch(infRange)
+	require.Equal(t, []tombstoneID{rt.id()}, ids(r.check(5)))
+}
+
+func TestTxnDeleteBatch(t *testing.T) {
+	opt := getTestOptions("").WithMaxTableSize(1 << 20)
+	runBadgerTest(t, &opt, func(t *testing.T, db *DB) {
+		var keys [][]byte
+		wb := db.NewWriteBatch()
+		for i := 0; i < 500; i++ {
+			keys = append(keys, []byte(fmt.Sprintf("key%04d", (i*7919)%500)))
+			require.NoError(t, wb.Set(keys[i], []byte("val")))
+		}
+		require.NoError(t, wb.Flush())
+		count := func() int {
+			var n int
+			require.NoError(t, db.View(func(txn *Txn) error {
+				itr := txn.NewIterator(DefaultIteratorOptions)
+				defer itr.Close()
+				for itr.Rewind(); itr.Valid(); itr.Next() {
+					n++
+				}
+				return nil
+			}))
+			return n
+		}
+
+		// Reading one of the keys doesn't conflict with the batch, reading conflictKey does.
+		domain := []byte("domain")
+		reader := db.NewTransaction(true)
+		defer reader.Discard()
+		_, err := reader.Get(keys[0])
+		require.NoError(t, err)
+		require.NoError(t, reader.Set([]byte("other"), []byte("val")))
+		domainReader := db.NewTransaction(true)
+		defer domainReader.Discard()
+		_, err = domainReader.Get(domain)
+		require.Equal(t, ErrKeyNotFound, err)
+		require.NoError(t, domainReader.Set([]byte("other"), []byte("val")))
+
+		txn := db.NewTransaction(true)
+		require.NoError(t, txn.DeleteBatch(keys[:250], domain))
+		require.Len(t, txn.writes, 1)
+		_, err = txn.Get(keys[0])
+		require.Equal(t, ErrKeyNotFound, err)
+		require.NoError(t, txn.Commit())
+		require.Equal(t, 250, count())
+
+		require.NoError(t, reader.Commit())
+		require.Equal(t, ErrConflict, domainReader.Commit())
+
+		// Without conflictKey, every key is registered.
+		txn = db.NewTransaction(true)
+		require.NoError(t, txn.DeleteBatch(keys[250:], nil))
+		require.Len(t, txn.writes, 250)
+		require.NoError(t, txn.Commit())
+		require.Equal(t, 1, count()) // Only "other" is left.
+
+		txn = db.NewTransaction(true)
+		defer txn.Discard()
+		require.Equal(t, ErrEmptyKey, txn.DeleteBatch(keys, []byte{}))
+		require.Equal(t, ErrInvalidKey, txn.DeleteBatch([][]byte{badgerPrefix}, domain))
+		require.Empty(t, txn.writes)
+	})
+}
+
+func TestTxnMetrics(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		set := func(key string) error {
+			return db.Update(func(txn *Txn) error {
+				return txn.Set([]byte(key), []byte("val"))
+			})
+		}
+		require.NoError(t, set("a"))
+		require.Equal(t, uint64(1), db.TxnMetrics().Committed)
+
+		// A long running read holds back the read watermark.
+		reader := db.NewTransaction(false)
+		conflicting := db.NewTransaction(true)
+		_, err := conflicting.Get([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, conflicting.Set([]byte("b"), []byte("val")))
+		for i := 0; i < 5; i++ {
+			require.NoError(t, set("a"))
+		}
+		require.Equal(t, ErrConflict, conflicting.Commit())
+
+		m := db.TxnMetrics()
+		require.Equal(t, uint64(6), m.Committed)
+		require.Equal(t, uint64(1), m.Conflicts)
+		require.Equal(t, 1, m.TrackedKeys)
+		require.Eventually(t, func() bool { return db.TxnMetrics().ReadLag >= 5 },
+			5*time.Second, 10*time.Millisecond)
+
+		reader.Discard()
+		require.Eventually(t, func() bool { return db.TxnMetrics().ReadLag == 0 },
+			5*time.Second, 10*time.Millisecond)
+		require.Zero(t, db.TxnMetrics().CommitLag)
+	})
+}
+
+func TestTxnSetDetectConflicts(t *testing.T) {
+	runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+		set := func(txn *Txn, key string) {
+			require.NoError(t, txn.Set([]byte(key), []byte(key)))
+		}
+		txn := db.NewTransaction(true)
+		set(txn, "a")
+		require.NoError(t, txn.Commit())
+
+		// The reads of a conflict-free transaction are not checked.
+		free := db.NewTransaction(true)
+		free.SetDetectConflicts(false)
+		_, err := free.Get([]byte("a"))
+		require.NoError(t, err)
+		set(free, "b")
+		txn = db.NewTransaction(true)
+		set(txn, "a")
+		require.NoError(t, txn.Commit())
+		require.NoError(t, free.Commit())
+
+		// Its writes don't abort the transactions which read the keys.
+		tracked := db.NewTransaction(true)
+		_, err = tracked.Get([]byte("b"))
+		require.NoError(t, err)
+		set(tracked, "c")
+		free = db.NewTransaction(true)
+		free.SetDetectConflicts(false)
+		set(free, "b")
+		require.NoError(t, free.Commit())
+		require.NoError(t, tracked.Commit())
+		require.Zero(t, db.TxnMetrics().Conflicts)
+
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for _, key := range []string{"a", "b", "c"} {
+				if _, err := txn.Get([]byte(key)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+
+		txn = db.NewTransaction(true)
+		defer txn.Discard()
+		set(txn, "d")
+		require.Panics(t, func() { txn.SetDetectConflicts(false) })
+	})
+}
@@ -0,0 +1,69 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import "github.com/pkg/errors"
+
+// The typed helpers encode and decode the values with a Codec on top of the byte slice API. They
+// need type parameters, hence Go 1.18 or newer.
+
+// NewEntryOf returns a new Entry with the given key and the encoding of val as value. It can be
+// used to set the TTL or the user meta of the entry before passing it to Txn.SetEntry.
+func NewEntryOf[T any](c Codec, key []byte, val T) (*Entry, error) {
+	data, err := c.Marshal(val)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while encoding the value of key %q", key)
+	}
+	return NewEntry(key, data), nil
+}
+
+// Set adds the given key and the encoding of val to the transaction, like Txn.Set.
+func Set[T any](txn *Txn, c Codec, key []byte, val T) error {
+	e, err := NewEntryOf(c, key, val)
+	if err != nil {
+		return err
+	}
+	return txn.SetEntry(e)
+}
+
+// Get looks up the key in the transaction, like Txn.Get, and decodes its value. If the key is
+// not found, Get returns the zero value of T along with ErrKeyNotFound.
+func Get[T any](txn *Txn, c Codec, key []byte) (T, error) {
+	item, err := txn.Get(key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return Decode[T](c, item)
+}
+
+// Decode decodes the value of the item. It can be used on the items returned by iterators, or
+// when the user meta or the expiry time of the item are needed along with its value.
+func Decode[T any](c Codec, item *Item) (T, error) {
+	var val T
+	err := item.Value(func(data []byte) error {
+		return c.Unmarshal(data, &val)
+	})
+	if err != nil {
+		var zero T
+		return zero, errors.Wrapf(err, "while decoding the value of key %q", item.Key())
+	}
+	return val, nil
+}
@@ -0,0 +1,86 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type typedTestValue struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+func TestTypedSetGet(t *testing.T) {
+	codecs := map[string]Codec{"json": JSONCodec{}, "gob": GobCodec{}}
+	for name, c := range codecs {
+		t.Run(name, func(t *testing.T) {
+			runBadgerTest(t, nil, func(t *testing.T, db *DB) {
+				want := typedTestValue{Name: "foo", Count: 3, Tags: []string{"a", "b"}}
+				require.NoError(t, db.Update(func(txn *Txn) error {
+					if err := Set(txn, c, []byte("struct"), want); err != nil {
+						return err
+					}
+					if err := Set(txn, c, []byte("int"), 42); err != nil {
+						return err
+					}
+					e, err := NewEntryOf(c, []byte("meta"), "bar")
+					if err != nil {
+						return err
+					}
+					return txn.SetEntry(e.WithMeta(0x12).WithTTL(time.Hour))
+				}))
+
+				require.NoError(t, db.View(func(txn *Txn) error {
+					got, err := Get[typedTestValue](txn, c, []byte("struct"))
+					require.NoError(t, err)
+					require.Equal(t, want, got)
+
+					n, err := Get[int](txn, c, []byte("int"))
+					require.NoError(t, err)
+					require.Equal(t, 42, n)
+
+					item, err := txn.Get([]byte("meta"))
+					require.NoError(t, err)
+					require.Equal(t, byte(0x12), item.UserMeta())
+					require.NotZero(t, item.ExpiresAt())
+					s, err := Decode[string](c, item)
+					require.NoError(t, err)
+					require.Equal(t, "bar", s)
+
+					// The zero value is returned for the keys which are not found.
+					got, err = Get[typedTestValue](txn, c, []byte("missing"))
+					require.Equal(t, ErrKeyNotFound, err)
+					require.Equal(t, typedTestValue{}, got)
+
+					// So it is for the values which can't be decoded.
+					n, err = Get[int](txn, c, []byte("struct"))
+					require.Error(t, err)
+					require.Zero(t, n)
+					return nil
+				}))
+			})
+		})
+	}
+}
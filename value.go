@@ -27,7 +27,6 @@ import (
 	"hash"
 	"hash/crc32"
 	"io"
-	"io/ioutil"
 	"math"
 	"math/rand"
 	"os"
@@ -38,6 +37,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/cespare/xxhash"
 	"github.com/dgraph-io/badger/v2/options"
 	"github.com/dgraph-io/badger/v2/pb"
 	"github.com/dgraph-io/badger/v2/y"
@@ -53,6 +53,11 @@ const (
 	bitDiscardEarlierVersions byte = 1 << 2 // Set if earlier versions can be discarded.
 	// Set if item shouldn't be discarded via compactions (used by merge operator)
 	bitMergeEntry byte = 1 << 3
+	// Set in the header of an entry in the value log, if the entry is checksummed with xxHash64
+	// instead of CRC32C. It is never set in the LSM tree.
+	bitXXHashChecksum byte = 1 << 4
+	// Set if the value is preceded by the metadata of the entry, see Entry.WithMetadata.
+	bitMetadata byte = 1 << 5
 	// The MSB 2 bits are for transactions.
 	bitTxn    byte = 1 << 6 // Set if the entry is part of a txn.
 	bitFinTxn byte = 1 << 7 // Set if the entry is to indicate end of txn in value log.
@@ -77,7 +82,7 @@ type logFile struct {
 	// Use shared ownership when reading/writing the file or memory map, use
 	// exclusive ownership to open/close the descriptor, unmap or remove the file.
 	lock        sync.RWMutex
-	fd          *os.File
+	fd          ValueLogFile
 	fid         uint32
 	fmap        []byte
 	size        uint32
@@ -85,37 +90,63 @@ type logFile struct {
 	dataKey     *pb.DataKey
 	baseIV      []byte
 	registry    *KeyRegistry
+	// checksumAlgo is the algorithm used to checksum new entries.
+	checksumAlgo options.ChecksumAlgorithm
+	// dw writes the file with direct IO while it's the latest, if Options.ValueLogDirectIO.
+	dw *directWriter
+}
+
+// newChecksum returns the hash used to checksum an entry of the value log, given the meta in its
+// header.
+func newChecksum(meta byte) hash.Hash {
+	if meta&bitXXHashChecksum > 0 {
+		return xxhash.New()
+	}
+	return crc32.New(y.CastagnoliCrcTable)
+}
+
+// checksumSize returns the size of the checksum of an entry, given the meta in its header.
+func checksumSize(meta byte) int {
+	if meta&bitXXHashChecksum > 0 {
+		return 8
+	}
+	return crc32.Size
 }
 
 // encodeEntry will encode entry to the buf
 // layout of entry
 // +--------+-----+-------+-------+
-// | header | key | value | crc32 |
-// +--------+-----+-------+-------+
+// | header | key | value | checksum |
+// +--------+-----+-------+----------+
+// The checksum is a crc32 or a xxhash64, as indicated by the header.
 func (lf *logFile) encodeEntry(e *Entry, buf *bytes.Buffer, offset uint32) (int, error) {
+	val := e.encodedValue()
 	h := header{
 		klen:      uint32(len(e.Key)),
-		vlen:      uint32(len(e.Value)),
+		vlen:      uint32(len(val)),
 		expiresAt: e.ExpiresAt,
-		meta:      e.meta,
+		meta:      e.encodedMeta(),
 		userMeta:  e.UserMeta,
 	}
+	if lf.checksumAlgo == options.XXHash64 {
+		h.meta |= bitXXHashChecksum
+	}
 
 	// encode header.
 	var headerEnc [maxHeaderSize]byte
 	sz := h.Encode(headerEnc[:])
 	y.Check2(buf.Write(headerEnc[:sz]))
 	// write hash.
-	hash := crc32.New(y.CastagnoliCrcTable)
+	hash := newChecksum(h.meta)
 	y.Check2(hash.Write(headerEnc[:sz]))
 	// we'll encrypt only key and value.
 	if lf.encryptionEnabled() {
 		// TODO: no need to allocate the bytes. we can calculate the encrypted buf one by one
 		// since we're using ctr mode of AES encryption. Ordering won't changed. Need some
 		// refactoring in XORBlock which will work like stream cipher.
-		eBuf := make([]byte, 0, len(e.Key)+len(e.Value))
+		eBuf := make([]byte, 0, len(e.Key)+len(val))
 		eBuf = append(eBuf, e.Key...)
-		eBuf = append(eBuf, e.Value...)
+		eBuf = append(eBuf, val...)
 		var err error
 		eBuf, err = y.XORBlock(eBuf, lf.dataKey.Data, lf.generateIV(offset))
 		if err != nil {
@@ -132,16 +163,15 @@ func (lf *logFile) encodeEntry(e *Entry, buf *bytes.Buffer, offset uint32) (int,
 		// write key hash.
 		y.Check2(hash.Write(e.Key))
 		// write value.
-		y.Check2(buf.Write(e.Value))
+		y.Check2(buf.Write(val))
 		// write value hash.
-		y.Check2(hash.Write(e.Value))
+		y.Check2(hash.Write(val))
 	}
-	// write crc32 hash.
-	var crcBuf [crc32.Size]byte
-	binary.BigEndian.PutUint32(crcBuf[:], hash.Sum32())
-	y.Check2(buf.Write(crcBuf[:]))
+	// write the checksum.
+	sum := hash.Sum(nil)
+	y.Check2(buf.Write(sum))
 	// return encoded length.
-	return len(headerEnc[:sz]) + len(e.Key) + len(e.Value) + len(crcBuf), nil
+	return len(headerEnc[:sz]) + len(e.Key) + len(val) + len(sum), nil
 }
 
 func (lf *logFile) decodeEntry(buf []byte, offset uint32) (*Entry, error) {
@@ -157,7 +187,7 @@ func (lf *logFile) decodeEntry(buf []byte, offset uint32) (*Entry, error) {
 		}
 	}
 	e := &Entry{
-		meta:      h.meta,
+		meta:      h.meta &^ bitXXHashChecksum,
 		UserMeta:  h.userMeta,
 		ExpiresAt: h.expiresAt,
 		offset:    offset,
@@ -185,7 +215,7 @@ func (lf *logFile) mmap(size int64) (err error) {
 		// Nothing to do
 		return nil
 	}
-	lf.fmap, err = y.Mmap(lf.fd, false, size)
+	lf.fmap, err = y.Mmap(lf.fd.(*os.File), false, size)
 	if err == nil {
 		err = y.Madvise(lf.fmap, false) // Disable readahead
 	}
@@ -254,9 +284,12 @@ func (lf *logFile) generateIV(offset uint32) []byte {
 }
 
 func (lf *logFile) doneWriting(offset uint32) error {
+	if err := lf.closeDirectWriter(); err != nil {
+		return err
+	}
 	// Sync before acquiring lock. (We call this from write() and thus know we have shared access
 	// to the fd.)
-	if err := y.FileSync(lf.fd); err != nil {
+	if err := lf.sync(); err != nil {
 		return errors.Wrapf(err, "Unable to sync value log: %q", lf.path)
 	}
 
@@ -288,9 +321,30 @@ func (lf *logFile) doneWriting(offset uint32) error {
 	return nil
 }
 
+// closeDirectWriter closes the direct writer of lf, if any.
+func (lf *logFile) closeDirectWriter() error {
+	if lf.dw == nil {
+		return nil
+	}
+	err := lf.dw.fd.Close()
+	lf.dw = nil
+	return errors.Wrapf(err, "Unable to close value log file: %q", lf.path)
+}
+
 // You must hold lf.lock to sync()
 func (lf *logFile) sync() error {
-	return y.FileSync(lf.fd)
+	if f, ok := lf.fd.(*os.File); ok {
+		return y.FileSync(f)
+	}
+	return lf.fd.Sync()
+}
+
+// setFile sets the descriptor of the file, falling back to options.FileIO if it can't be mapped.
+func (lf *logFile) setFile(fd ValueLogFile) {
+	lf.fd = fd
+	if _, ok := fd.(*os.File); !ok {
+		lf.loadingMode = options.FileIO
+	}
 }
 
 var errStop = errors.New("Stop iteration")
@@ -311,7 +365,7 @@ type safeRead struct {
 // bytes read. The hashReader writes to h (hash) what it reads from r.
 type hashReader struct {
 	r         io.Reader
-	h         hash.Hash32
+	h         hash.Hash
 	bytesRead int // Number of bytes read.
 }
 
@@ -340,13 +394,14 @@ func (t *hashReader) ReadByte() (byte, error) {
 	return b[0], err
 }
 
-// Sum32 returns the sum32 of the underlying hash.
-func (t *hashReader) Sum32() uint32 {
-	return t.h.Sum32()
+// Sum returns the sum of the underlying hash.
+func (t *hashReader) Sum() []byte {
+	return t.h.Sum(nil)
 }
 
 // Entry reads an entry from the provided reader. It also validates the checksum for every entry
-// read. Returns error on failure.
+// read. Returns error on failure. The meta of the returned entry still holds bitXXHashChecksum,
+// so that the caller can tell the size of its checksum.
 func (r *safeRead) Entry(reader io.Reader) (*Entry, error) {
 	tee := newHashReader(reader)
 	var h header
@@ -357,6 +412,12 @@ func (r *safeRead) Entry(reader io.Reader) (*Entry, error) {
 	if h.klen > uint32(1<<16) { // Key length must be below uint16.
 		return nil, errTruncate
 	}
+	if h.meta&bitXXHashChecksum > 0 {
+		// The header has been hashed with crc32 while decoding it. Hash it again with xxhash64.
+		var headerEnc [maxHeaderSize]byte
+		tee.h = newChecksum(h.meta)
+		y.Check2(tee.h.Write(headerEnc[:h.Encode(headerEnc[:])]))
+	}
 	kl := int(h.klen)
 	if cap(r.k) < kl {
 		r.k = make([]byte, 2*kl)
@@ -383,15 +444,15 @@ func (r *safeRead) Entry(reader io.Reader) (*Entry, error) {
 	}
 	e.Key = buf[:h.klen]
 	e.Value = buf[h.klen:]
-	var crcBuf [crc32.Size]byte
-	if _, err := io.ReadFull(reader, crcBuf[:]); err != nil {
+	var sumBuf [8]byte
+	sum := sumBuf[:checksumSize(h.meta)]
+	if _, err := io.ReadFull(reader, sum); err != nil {
 		if err == io.EOF {
 			err = errTruncate
 		}
 		return nil, err
 	}
-	crc := y.BytesToU32(crcBuf[:])
-	if crc != tee.Sum32() {
+	if !bytes.Equal(sum, tee.Sum()) {
 		return nil, errTruncate
 	}
 	e.meta = h.meta
@@ -449,8 +510,9 @@ func (vlog *valueLog) iterate(lf *logFile, offset uint32, fn logEntry) (uint32,
 		}
 
 		var vp valuePointer
-		vp.Len = uint32(int(e.hlen) + len(e.Key) + len(e.Value) + crc32.Size)
+		vp.Len = uint32(int(e.hlen) + len(e.Key) + len(e.Value) + checksumSize(e.meta))
 		read.recordOffset += vp.Len
+		e.meta &^= bitXXHashChecksum
 
 		vp.Offset = e.offset
 		vp.Fid = lf.fid
@@ -502,17 +564,77 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 
 	y.AssertTrue(vlog.db != nil)
 	var count, moved int
-	fe := func(e Entry) error {
+	var ioRead int64 // Read and not accounted with the rate limiter yet.
+	// moveChunked moves the chunked value of key at once, all its chunks being written again along
+	// with its manifest, as the chunks spread over other files can't be moved one by one.
+	movedChunked := make(map[string]struct{})
+	moveChunked := func(key []byte, vs y.ValueStruct) error {
+		if _, ok := movedChunked[string(key)]; ok {
+			return nil
+		}
+		movedChunked[string(key)] = struct{}{}
+		var mp valuePointer
+		mp.Decode(vs.Value)
+		var s y.Slice
+		manifest, _, cb, err := vlog.read(mp, &s)
+		if err != nil {
+			runCallback(cb)
+			return err
+		}
+		size, ptrs, err := decodeChunkManifest(manifest)
+		runCallback(cb)
+		if err != nil {
+			return err
+		}
+		val, err := vlog.readChunks(size, ptrs, &s)
+		if err == ErrRetry {
+			// Some chunks were already moved, along with the value.
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		ne := &Entry{Value: val, UserMeta: vs.UserMeta, ExpiresAt: vs.ExpiresAt}
+		if !bytes.HasPrefix(key, badgerMove) {
+			ne.Key = append(ne.Key, badgerMove...)
+		}
+		ne.Key = append(ne.Key, key...)
+		if err := vlog.db.batchSet([]*Entry{ne}); err != nil {
+			return err
+		}
+		// The chunks left in the other files can be discarded.
+		stats := make(map[uint32]int64)
+		for _, p := range ptrs {
+			if p.Fid != f.fid {
+				stats[p.Fid] += int64(p.Len)
+			}
+		}
+		vlog.updateDiscardStats(stats)
+		return nil
+	}
+	fe := func(e Entry, ptr valuePointer) error {
 		count++
+		if ioRead += int64(ptr.Len); ioRead >= compactionIOChunk {
+			vlog.db.compactionLimiter.Wait(ioRead)
+			ioRead = 0
+		}
 		if count%100000 == 0 {
 			tr.LazyPrintf("Processing entry %d", count)
 		}
 
+		if isChunkKey(e.Key) {
+			key, vs, live, err := vlog.liveChunk(e, ptr)
+			if err != nil || !live {
+				return err
+			}
+			moved++
+			return moveChunked(key, vs)
+		}
 		vs, err := vlog.db.get(e.Key)
 		if err != nil {
 			return err
 		}
-		if discardEntry(e, vs) {
+		if discardEntry(e, vs, vlog.db.opt.now()) {
 			return nil
 		}
 
@@ -531,6 +653,9 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 		}
 		if vp.Fid == f.fid && vp.Offset == e.offset {
 			moved++
+			if e.meta&bitValuePointer > 0 {
+				return moveChunked(e.Key, vs)
+			}
 			// This new entry only contains the key, and a pointer to the value.
 			ne := new(Entry)
 			ne.meta = 0 // Remove all bits. Different keyspace doesn't need these bits.
@@ -564,17 +689,19 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 			wb = append(wb, ne)
 			size += es
 		} else {
-			vlog.db.opt.Warningf("This entry should have been caught. %+v\n", e)
+			vlog.db.opt.logger(LogComponentVlog).Warningf(
+				"This entry should have been caught. %+v\n", e)
 		}
 		return nil
 	}
 
 	_, err := vlog.iterate(f, 0, func(e Entry, vp valuePointer) error {
-		return fe(e)
+		return fe(e, vp)
 	})
 	if err != nil {
 		return err
 	}
+	vlog.db.compactionLimiter.Wait(ioRead)
 
 	tr.LazyPrintf("request has %d entries, size %d", len(wb), size)
 	batchSize := 1024
@@ -582,7 +709,8 @@ func (vlog *valueLog) rewrite(f *logFile, tr trace.Trace) error {
 	for i := 0; i < len(wb); {
 		loops++
 		if batchSize == 0 {
-			vlog.db.opt.Warningf("We shouldn't reach batch size of zero.")
+			vlog.db.opt.logger(LogComponentVlog).Warningf(
+				"We shouldn't reach batch size of zero.")
 			return ErrNoRewrite
 		}
 		end := i + batchSize
@@ -734,7 +862,7 @@ func (vlog *valueLog) deleteLogFile(lf *logFile) error {
 	if err := lf.fd.Close(); err != nil {
 		return err
 	}
-	return os.Remove(path)
+	return vlog.fs.Remove(path)
 }
 
 func (vlog *valueLog) dropAll() (int, error) {
@@ -761,7 +889,7 @@ func (vlog *valueLog) dropAll() (int, error) {
 		return count, err
 	}
 
-	vlog.db.opt.Infof("Value logs deleted. Creating value log file: 0")
+	vlog.db.opt.logger(LogComponentVlog).Infof("Value logs deleted. Creating value log file: 0")
 	if _, err := vlog.createVlogFile(0); err != nil {
 		return count, err
 	}
@@ -781,6 +909,7 @@ type lfDiscardStats struct {
 
 type valueLog struct {
 	dirPath string
+	fs      ValueLogFS
 	elog    trace.EventLog
 
 	// guards our view of which files exist, which to be deleted, how many active iterators
@@ -811,7 +940,7 @@ func (vlog *valueLog) fpath(fid uint32) string {
 func (vlog *valueLog) populateFilesMap() error {
 	vlog.filesMap = make(map[uint32]*logFile)
 
-	files, err := ioutil.ReadDir(vlog.dirPath)
+	files, err := vlog.fs.ReadDir(vlog.dirPath)
 	if err != nil {
 		return errFile(err, vlog.dirPath, "Unable to open log dir.")
 	}
@@ -832,10 +961,11 @@ func (vlog *valueLog) populateFilesMap() error {
 		found[fid] = struct{}{}
 
 		lf := &logFile{
-			fid:         uint32(fid),
-			path:        vlog.fpath(uint32(fid)),
-			loadingMode: vlog.opt.ValueLogLoadingMode,
-			registry:    vlog.db.registry,
+			fid:          uint32(fid),
+			path:         vlog.fpath(uint32(fid)),
+			loadingMode:  vlog.opt.ValueLogLoadingMode,
+			registry:     vlog.db.registry,
+			checksumAlgo: vlog.opt.ValueLogChecksumAlgorithm,
 		}
 		vlog.filesMap[uint32(fid)] = lf
 		if vlog.maxFid < uint32(fid) {
@@ -845,11 +975,12 @@ func (vlog *valueLog) populateFilesMap() error {
 	return nil
 }
 
-func (lf *logFile) open(path string, flags uint32) error {
-	var err error
-	if lf.fd, err = y.OpenExistingFile(path, flags); err != nil {
+func (lf *logFile) open(fs ValueLogFS, path string, readOnly, sync bool) error {
+	fd, err := fs.Open(path, readOnly, sync)
+	if err != nil {
 		return y.Wrapf(err, "Error while opening file in logfile %s", path)
 	}
+	lf.setFile(fd)
 
 	fi, err := lf.fd.Stat()
 	if err != nil {
@@ -924,29 +1055,34 @@ func (vlog *valueLog) createVlogFile(fid uint32) (*logFile, error) {
 	path := vlog.fpath(fid)
 
 	lf := &logFile{
-		fid:         fid,
-		path:        path,
-		loadingMode: vlog.opt.ValueLogLoadingMode,
-		registry:    vlog.db.registry,
+		fid:          fid,
+		path:         path,
+		loadingMode:  vlog.opt.ValueLogLoadingMode,
+		registry:     vlog.db.registry,
+		checksumAlgo: vlog.opt.ValueLogChecksumAlgorithm,
 	}
 	// writableLogOffset is only written by write func, by read by Read func.
 	// To avoid a race condition, all reads and updates to this variable must be
 	// done via atomics.
-	var err error
-	if lf.fd, err = y.CreateSyncedFile(path, vlog.opt.SyncWrites); err != nil {
+	fd, err := vlog.fs.Create(path, vlog.opt.SyncWrites)
+	if err != nil {
 		return nil, errFile(err, lf.path, "Create value log file")
 	}
+	lf.setFile(fd)
 
 	if err = lf.bootstrap(); err != nil {
 		return nil, err
 	}
 
-	if err = syncDir(vlog.dirPath); err != nil {
+	if err = vlog.fs.SyncDir(vlog.dirPath); err != nil {
 		return nil, errFile(err, vlog.dirPath, "Sync value log dir")
 	}
 	if err = lf.mmap(2 * vlog.opt.ValueLogFileSize); err != nil {
 		return nil, errFile(err, lf.path, "Mmap value log file")
 	}
+	if err = vlog.openDirectWriter(lf, vlogHeaderSize); err != nil {
+		return nil, err
+	}
 	// writableLogOffset is only written by write func, by read by Read func.
 	// To avoid a race condition, all reads and updates to this variable must be
 	// done via atomics.
@@ -978,12 +1114,31 @@ func (vlog *valueLog) replayLog(lf *logFile, offset uint32, replayFn logEntry) e
 	if int64(endOffset) == fi.Size() {
 		return nil
 	}
+	if size := fi.Size() - int64(endOffset); size > 0 && size < y.DirectIOAlignment {
+		tail := make([]byte, size)
+		if _, err := lf.fd.ReadAt(tail, int64(endOffset)); err != nil {
+			return errFile(err, lf.path, "Unable to read end of logfile")
+		}
+		// Nothing is lost by truncating the padding, see directWriter.
+		if isPadding(tail) {
+			if vlog.opt.ReadOnly {
+				return nil
+			}
+			if err := lf.fd.Truncate(int64(endOffset)); err != nil {
+				return errFile(err, lf.path, "Unable to truncate padding")
+			}
+			return nil
+		}
+	}
 
 	// End offset is different from file size. So, we should truncate the file
 	// to that size.
 	if !vlog.opt.Truncate {
 		return ErrTruncateNeeded
 	}
+	if vlog.opt.RepairMode {
+		vlog.db.truncatedValueLog(lf, endOffset, fi.Size())
+	}
 
 	// The entire file should be truncated (i.e. it should be deleted).
 	// If fid == maxFid then it's okay to truncate the entire file since it will be
@@ -1014,6 +1169,10 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 		return nil
 	}
 	vlog.dirPath = vlog.opt.ValueDir
+	vlog.fs = vlog.opt.ValueLogFS
+	if vlog.fs == nil {
+		vlog.fs = osValueLogFS{}
+	}
 	vlog.elog = y.NoEventLog
 	if vlog.opt.EventLogging {
 		vlog.elog = trace.NewEventLog("Badger", "Valuelog")
@@ -1030,30 +1189,37 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 	}
 	// If no files are found, then create a new file.
 	if len(vlog.filesMap) == 0 {
+		vlog.newReplayProgress(nil, ptr).done()
 		_, err := vlog.createVlogFile(0)
 		return y.Wrapf(err, "Error while creating log file in valueLog.open")
 	}
 	fids := vlog.sortedFids()
+	progress := vlog.newReplayProgress(fids, ptr)
 	for _, fid := range fids {
 		lf, ok := vlog.filesMap[fid]
 		y.AssertTrue(ok)
-		var flags uint32
-		switch {
-		case vlog.opt.ReadOnly:
-			// If we have read only, we don't need SyncWrites.
-			flags |= y.ReadOnly
-			// Set sync flag.
-		case vlog.opt.SyncWrites:
-			flags |= y.Sync
-		}
+		// If we have read only, we don't need SyncWrites.
+		readOnly := vlog.opt.ReadOnly
+		sync := !readOnly && vlog.opt.SyncWrites
 
 		// We cannot mmap the files upfront here. Windows does not like mmapped files to be
 		// truncated. We might need to truncate files during a replay.
-		var err error
-		if err = lf.open(vlog.fpath(fid), flags); err != nil {
+		if err := lf.open(vlog.fs, vlog.fpath(fid), readOnly, sync); err != nil {
 			return errors.Wrapf(err, "Open existing file: %q", lf.path)
 		}
 
+		// The entries after the value head pointer might be being written by another process.
+		// Don't replay them, only the values the LSM tree points to are read. The last file is
+		// mapped below.
+		if vlog.opt.ReadOnlySnapshot {
+			if fid < vlog.maxFid {
+				if err := lf.init(); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
 		// This file is before the value head pointer. So, we don't need to
 		// replay it, and can just open it in readonly mode.
 		if fid < ptr.Fid {
@@ -1068,11 +1234,13 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 		if fid == ptr.Fid {
 			offset = ptr.Offset + ptr.Len
 		}
-		vlog.db.opt.Infof("Replaying file id: %d at offset: %d\n", fid, offset)
+		log := vlog.db.opt.logger(LogComponentRecovery).with("fid", fid)
+		log.Infof("Replaying file id: %d at offset: %d\n", fid, offset)
 		now := time.Now()
+		progress.startFile(fid, offset)
 		// Replay and possible truncation done. Now we can open the file as per
 		// user specified options.
-		if err := vlog.replayLog(lf, offset, replayFn); err != nil {
+		if err := vlog.replayLog(lf, offset, progress.wrap(replayFn)); err != nil {
 			// Log file is corrupted. Delete it.
 			if err == errDeleteVlogFile {
 				delete(vlog.filesMap, fid)
@@ -1081,14 +1249,16 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 					return errors.Wrapf(err, "failed to close vlog file %s", lf.fd.Name())
 				}
 				path := vlog.fpath(lf.fid)
-				if err := os.Remove(path); err != nil {
+				if err := vlog.fs.Remove(path); err != nil {
 					return y.Wrapf(err, "failed to delete empty value log file: %q", path)
 				}
+				progress.doneFile()
 				continue
 			}
 			return err
 		}
-		vlog.db.opt.Infof("Replay took: %s\n", time.Since(now))
+		log.Infof("Replay took: %s\n", time.Since(now))
+		progress.doneFile()
 
 		if fid < vlog.maxFid {
 			// This file has been replayed. It can now be mmapped.
@@ -1098,6 +1268,8 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 			}
 		}
 	}
+	progress.done()
+
 	// Seek to the end to start writing.
 	last, ok := vlog.filesMap[vlog.maxFid]
 	y.AssertTrue(ok)
@@ -1128,10 +1300,14 @@ func (vlog *valueLog) open(db *DB, ptr valuePointer, replayFn logEntry) error {
 	if err = last.mmap(2 * vlog.opt.ValueLogFileSize); err != nil {
 		return errFile(err, last.path, "Map log file")
 	}
+	if err = vlog.openDirectWriter(last, uint32(lastOffset)); err != nil {
+		return err
+	}
 	if err := vlog.populateDiscardStats(); err != nil {
 		// Print the error and continue. We don't want to prevent value log open if there's an error
 		// with the fetching discards stats.
-		db.opt.Errorf("Failed to populate discard stats: %s", err)
+		db.opt.logger(LogComponentRecovery).Errorf(
+			"Failed to populate discard stats: %s", err)
 	}
 	return nil
 }
@@ -1173,6 +1349,9 @@ func (vlog *valueLog) Close() error {
 		}
 
 		maxFid := atomic.LoadUint32(&vlog.maxFid)
+		if closeErr := f.closeDirectWriter(); closeErr != nil && err == nil {
+			err = closeErr
+		}
 		if !vlog.opt.ReadOnly && id == maxFid {
 			// truncate writable log file to correct offset.
 			if truncErr := f.fd.Truncate(
@@ -1297,6 +1476,26 @@ func (vlog *valueLog) woffset() uint32 {
 	return atomic.LoadUint32(&vlog.writableLogOffset)
 }
 
+// diskSize returns the total size of the value log files, counting only the part of the current
+// file which has been written.
+func (vlog *valueLog) diskSize() int64 {
+	if vlog.opt.InMemory {
+		return 0
+	}
+	vlog.filesLock.RLock()
+	defer vlog.filesLock.RUnlock()
+	maxFid := atomic.LoadUint32(&vlog.maxFid)
+	var size int64
+	for fid, lf := range vlog.filesMap {
+		if fid == maxFid {
+			size += int64(vlog.woffset())
+			continue
+		}
+		size += int64(atomic.LoadUint32(&lf.size))
+	}
+	return size
+}
+
 // write is thread-unsafe by design and should not be called concurrently.
 func (vlog *valueLog) write(reqs []*request) error {
 	if vlog.db.opt.InMemory {
@@ -1313,7 +1512,13 @@ func (vlog *valueLog) write(reqs []*request) error {
 			return nil
 		}
 		vlog.elog.Printf("Flushing buffer of size %d to vlog", buf.Len())
-		n, err := curlf.fd.Write(buf.Bytes())
+		var n int
+		var err error
+		if curlf.dw != nil {
+			n, err = buf.Len(), curlf.dw.write(buf.Bytes())
+		} else {
+			n, err = curlf.fd.Write(buf.Bytes())
+		}
 		if err != nil {
 			return errors.Wrapf(err, "Unable to write to value log file: %q", curlf.path)
 		}
@@ -1325,30 +1530,59 @@ func (vlog *valueLog) write(reqs []*request) error {
 		atomic.StoreUint32(&curlf.size, vlog.writableLogOffset)
 		return nil
 	}
+	rotate := func() error {
+		if err := curlf.doneWriting(vlog.woffset()); err != nil {
+			return err
+		}
+
+		newid := atomic.AddUint32(&vlog.maxFid, 1)
+		y.AssertTruef(newid > 0, "newid has overflown uint32: %v", newid)
+		newlf, err := vlog.createVlogFile(newid)
+		if err != nil {
+			return err
+		}
+		curlf = newlf
+		atomic.AddInt32(&vlog.db.logRotates, 1)
+		return nil
+	}
 	toDisk := func() error {
 		if err := flushWrites(); err != nil {
 			return err
 		}
 		if vlog.woffset() > uint32(vlog.opt.ValueLogFileSize) ||
 			vlog.numEntriesWritten > vlog.opt.ValueLogMaxEntries {
-			if err := curlf.doneWriting(vlog.woffset()); err != nil {
-				return err
-			}
-
-			newid := atomic.AddUint32(&vlog.maxFid, 1)
-			y.AssertTruef(newid > 0, "newid has overflown uint32: %v", newid)
-			newlf, err := vlog.createVlogFile(newid)
-			if err != nil {
-				return err
-			}
-			curlf = newlf
-			atomic.AddInt32(&vlog.db.logRotates, 1)
+			return rotate()
 		}
 		return nil
 	}
+	// A single vlog file can't have both encrypted entries and plain text entries, so the plain
+	// text file is replaced once encryption is enabled by EnableEncryption.
+	if curlf.encryptionEnabled() != vlog.db.shouldEncrypt() {
+		if err := rotate(); err != nil {
+			return err
+		}
+	}
+	// writeChunk writes the chunk of a value on its own, so that the chunks of a value larger
+	// than a file are spread over several.
+	writeChunk := func(e *Entry) (valuePointer, error) {
+		p := valuePointer{Fid: curlf.fid, Offset: vlog.woffset() + uint32(buf.Len())}
+		plen, err := curlf.encodeEntry(e, &buf, p.Offset)
+		if err != nil {
+			return p, err
+		}
+		p.Len = uint32(plen)
+		vlog.numEntriesWritten++
+		return p, toDisk()
+	}
 	for i := range reqs {
 		b := reqs[i]
 		b.Ptrs = b.Ptrs[:0]
+		// The chunks are written before the entries of the request, which must all go to the
+		// same file.
+		manifests, err := vlog.writeChunks(b, writeChunk)
+		if err != nil {
+			return err
+		}
 		var written int
 		for j := range b.Entries {
 			e := b.Entries[j]
@@ -1356,6 +1590,9 @@ func (vlog *valueLog) write(reqs []*request) error {
 				b.Ptrs = append(b.Ptrs, valuePointer{})
 				continue
 			}
+			if manifests != nil && manifests[j] != nil {
+				e = manifests[j]
+			}
 			var p valuePointer
 
 			p.Fid = curlf.fid
@@ -1372,8 +1609,8 @@ func (vlog *valueLog) write(reqs []*request) error {
 			// It is possible that the size of the buffer grows beyond the max size of the value
 			// log (this happens when a transaction contains entries with large value sizes) and
 			// badger might run into out of memory errors. We flush the buffer here if it's size
-			// grows beyond the max value log size.
-			if int64(buf.Len()) > vlog.db.opt.ValueLogFileSize {
+			// grows beyond the write buffer size.
+			if int64(buf.Len()) > vlog.db.opt.valueLogWriteBufferSize() {
 				if err := flushWrites(); err != nil {
 					return err
 				}
@@ -1408,13 +1645,102 @@ func (vlog *valueLog) getFileRLocked(fid uint32) (*logFile, error) {
 	return ret, nil
 }
 
-// Read reads the value log at a given location.
+// Read reads the value log at a given location. The chunked values are reassembled into s.
 // TODO: Make this read private.
 func (vlog *valueLog) Read(vp valuePointer, s *y.Slice) ([]byte, func(), error) {
+	val, meta, cb, err := vlog.read(vp, s)
+	if err != nil || meta&bitValuePointer == 0 {
+		return val, cb, err
+	}
+	size, ptrs, err := decodeChunkManifest(val)
+	runCallback(cb)
+	if err != nil {
+		return nil, nil, err
+	}
+	val, err = vlog.readChunks(size, ptrs, s)
+	return val, nil, err
+}
+
+// readValuePrefix reads the header of the entry at the given location, and the first n bytes of
+// its value, or the whole value if it is shorter. The rest of the value isn't read.
+func (vlog *valueLog) readValuePrefix(vp valuePointer, n uint32) (header, []byte, error) {
+	var h header
+	lf, err := vlog.getFileRLocked(vp.Fid)
+	if err != nil {
+		return h, nil, err
+	}
+	defer lf.lock.RUnlock()
+
+	var s y.Slice
+	hlen := uint32(maxHeaderSize)
+	if hlen > vp.Len {
+		hlen = vp.Len
+	}
+	buf, err := lf.read(valuePointer{Fid: vp.Fid, Len: hlen, Offset: vp.Offset}, &s)
+	if err != nil {
+		return h, nil, err
+	}
+	headerLen := uint32(h.Decode(buf))
+	if n > h.vlen {
+		n = h.vlen
+	}
+	if headerLen+h.klen+n > vp.Len {
+		return h, nil, errors.Errorf("Invalid entry of length %d at %+v", h.klen+h.vlen, vp)
+	}
+	kv, err := lf.read(valuePointer{Fid: vp.Fid, Len: h.klen + n, Offset: vp.Offset + headerLen},
+		&s)
+	if err != nil {
+		return h, nil, err
+	}
+	if lf.encryptionEnabled() {
+		// The key and the value are encrypted in CTR mode, so a prefix can be decrypted alone.
+		if kv, err = lf.decryptKV(kv, vp.Offset); err != nil {
+			return h, nil, err
+		}
+	}
+	return h, kv[h.klen:], nil
+}
+
+// readValueSize returns the size of the value at the given location, without its metadata. It
+// only reads the header of the entry, and the length of the metadata or the manifest of the
+// chunks at the start of the value.
+func (vlog *valueLog) readValueSize(vp valuePointer) (int64, error) {
+	h, prefix, err := vlog.readValuePrefix(vp, 8+uint32(vptrSize))
+	if err != nil {
+		return 0, err
+	}
+	size := int64(h.vlen)
+	if h.meta&bitValuePointer != 0 {
+		if len(prefix) < 8+int(vptrSize) {
+			return 0, errors.Errorf("Invalid chunk manifest of length %d", h.vlen)
+		}
+		size = int64(binary.BigEndian.Uint64(prefix))
+		if h.meta&bitMetadata == 0 {
+			return size, nil
+		}
+		// The metadata is at the start of the first chunk.
+		var cp valuePointer
+		cp.Decode(prefix[8:])
+		if _, prefix, err = vlog.readValuePrefix(cp, 1); err != nil {
+			return 0, err
+		}
+	}
+	if h.meta&bitMetadata == 0 || len(prefix) == 0 {
+		return size, nil
+	}
+	if size -= 1 + int64(prefix[0]); size < 0 {
+		return 0, errors.Errorf("Invalid metadata of length %d at %+v", prefix[0], vp)
+	}
+	return size, nil
+}
+
+// read reads the value of the entry at the given location, and returns it along with the meta of
+// the entry.
+func (vlog *valueLog) read(vp valuePointer, s *y.Slice) ([]byte, byte, func(), error) {
 	// Check for valid offset if we are reading from writable log.
 	maxFid := atomic.LoadUint32(&vlog.maxFid)
 	if vp.Fid == maxFid && vp.Offset >= vlog.woffset() {
-		return nil, nil, errors.Errorf(
+		return nil, 0, nil, errors.Errorf(
 			"Invalid value pointer offset: %d greater than current offset: %d",
 			vp.Offset, vlog.woffset())
 	}
@@ -1423,32 +1749,33 @@ func (vlog *valueLog) Read(vp valuePointer, s *y.Slice) ([]byte, func(), error)
 	// unlock it, after caller uses it.
 	cb := vlog.getUnlockCallback(lf)
 	if err != nil {
-		return nil, cb, err
+		return nil, 0, cb, err
 	}
 
+	var h header
+	headerLen := h.Decode(buf)
 	if vlog.opt.VerifyValueChecksum {
-		hash := crc32.New(y.CastagnoliCrcTable)
-		if _, err := hash.Write(buf[:len(buf)-crc32.Size]); err != nil {
+		hash := newChecksum(h.meta)
+		if _, err := hash.Write(buf[:len(buf)-hash.Size()]); err != nil {
 			runCallback(cb)
-			return nil, nil, errors.Wrapf(err, "failed to write hash for vp %+v", vp)
+			return nil, 0, nil, errors.Wrapf(err, "failed to write hash for vp %+v", vp)
 		}
 		// Fetch checksum from the end of the buffer.
-		checksum := buf[len(buf)-crc32.Size:]
-		if hash.Sum32() != y.BytesToU32(checksum) {
+		checksum := buf[len(buf)-hash.Size():]
+		if !bytes.Equal(hash.Sum(nil), checksum) {
 			runCallback(cb)
-			return nil, nil, errors.Wrapf(y.ErrChecksumMismatch, "value corrupted for vp: %+v", vp)
+			return nil, 0, nil, errors.Wrapf(y.ErrChecksumMismatch,
+				"value corrupted for vp: %+v", vp)
 		}
 	}
-	var h header
-	headerLen := h.Decode(buf)
 	kv := buf[headerLen:]
 	if lf.encryptionEnabled() {
 		kv, err = lf.decryptKV(kv, vp.Offset)
 		if err != nil {
-			return nil, cb, err
+			return nil, 0, cb, err
 		}
 	}
-	return kv[h.klen : h.klen+h.vlen], cb, nil
+	return kv[h.klen : h.klen+h.vlen], h.meta, cb, nil
 }
 
 // getUnlockCallback will returns a function which unlock the logfile if the logfile is mmaped.
@@ -1457,7 +1784,7 @@ func (vlog *valueLog) getUnlockCallback(lf *logFile) func() {
 	if lf == nil {
 		return nil
 	}
-	if vlog.opt.ValueLogLoadingMode == options.MemoryMap {
+	if lf.loadingMode == options.MemoryMap {
 		return lf.lock.RUnlock
 	}
 	lf.lock.RUnlock()
@@ -1532,12 +1859,90 @@ func (vlog *valueLog) pickLog(head valuePointer, tr trace.Trace) (files []*logFi
 	return files
 }
 
-func discardEntry(e Entry, vs y.ValueStruct) bool {
+// GCStats is returned by DB.ValueLogGCStats.
+type GCStats struct {
+	// Fids are the IDs of the value log files which value log GC would rewrite, as their discard
+	// stats reach the discard ratio. They are sorted in the order GC picks them, the file with the
+	// most discardable data first.
+	Fids []uint32
+	// TotalSize is the total size of the files in Fids.
+	TotalSize int64
+	// Reclaimable is the estimated number of bytes rewriting the files in Fids would reclaim.
+	Reclaimable int64
+
+	// DiscardRatios is a histogram of the discard ratios of all the value log files which can be
+	// rewritten: DiscardRatios[i] is the number of files with a discard ratio in [i/10, (i+1)/10).
+	// The last bucket also holds the files whose ratio is 1.
+	DiscardRatios [10]int
+}
+
+// gcStats computes the GCStats of the files before head, from the discard stats.
+func (vlog *valueLog) gcStats(discardRatio float64, head valuePointer) GCStats {
+	vlog.filesLock.RLock()
+	defer vlog.filesLock.RUnlock()
+	vlog.lfDiscardStats.RLock()
+	defer vlog.lfDiscardStats.RUnlock()
+
+	var stats GCStats
+	for _, fid := range vlog.sortedFids() {
+		if fid >= head.Fid {
+			break
+		}
+		size := int64(atomic.LoadUint32(&vlog.filesMap[fid].size))
+		if size == 0 {
+			continue
+		}
+		discard := vlog.lfDiscardStats.m[fid]
+		if discard > size {
+			discard = size
+		}
+		ratio := float64(discard) / float64(size)
+		bucket := int(ratio * 10)
+		if bucket >= len(stats.DiscardRatios) {
+			bucket = len(stats.DiscardRatios) - 1
+		}
+		stats.DiscardRatios[bucket]++
+		if discard > 0 && ratio >= discardRatio {
+			stats.Fids = append(stats.Fids, fid)
+			stats.TotalSize += size
+			stats.Reclaimable += discard
+		}
+	}
+	sort.SliceStable(stats.Fids, func(i, j int) bool {
+		return vlog.lfDiscardStats.m[stats.Fids[i]] > vlog.lfDiscardStats.m[stats.Fids[j]]
+	})
+	return stats
+}
+
+// discardable returns the number of bytes of the value log files, but the current one, which the
+// discard stats tell are no longer referenced by the LSM tree.
+func (vlog *valueLog) discardable() int64 {
+	vlog.filesLock.RLock()
+	defer vlog.filesLock.RUnlock()
+	vlog.lfDiscardStats.RLock()
+	defer vlog.lfDiscardStats.RUnlock()
+
+	maxFid := atomic.LoadUint32(&vlog.maxFid)
+	var total int64
+	for fid, lf := range vlog.filesMap {
+		if fid == maxFid {
+			continue
+		}
+		discard := vlog.lfDiscardStats.m[fid]
+		if size := int64(atomic.LoadUint32(&lf.size)); discard > size {
+			discard = size
+		}
+		total += discard
+	}
+	return total
+}
+
+func discardEntry(e Entry, vs y.ValueStruct, now uint64) bool {
 	if vs.Version != y.ParseTs(e.Key) {
 		// Version not found. Discard.
 		return true
 	}
-	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt) {
+	if isDeletedOrExpired(vs.Meta, vs.ExpiresAt, now) {
 		return true
 	}
 	if (vs.Meta & bitValuePointer) == 0 {
@@ -1551,7 +1956,10 @@ func discardEntry(e Entry, vs y.ValueStruct) bool {
 	return false
 }
 
-func (vlog *valueLog) doRunGC(lf *logFile, discardRatio float64, tr trace.Trace) (err error) {
+// doRunGC rewrites lf if enough of it can be discarded. It returns an estimate of the bytes the
+// rewrite reclaims.
+func (vlog *valueLog) doRunGC(lf *logFile, discardRatio float64, tr trace.Trace) (
+	reclaimed int64, err error) {
 	// Update stats before exiting
 	defer func() {
 		if err == nil {
@@ -1571,7 +1979,7 @@ func (vlog *valueLog) doRunGC(lf *logFile, discardRatio float64, tr trace.Trace)
 	if err != nil {
 		tr.LazyPrintf("Error while finding file size: %v", err)
 		tr.SetError()
-		return err
+		return 0, err
 	}
 
 	// Set up the sampling window sizes.
@@ -1616,11 +2024,21 @@ func (vlog *valueLog) doRunGC(lf *logFile, discardRatio float64, tr trace.Trace)
 		r.total += esz
 		r.count++
 
+		if isChunkKey(e.Key) {
+			_, _, live, err := vlog.liveChunk(e, vp)
+			if err != nil {
+				return err
+			}
+			if !live {
+				r.discard += esz
+			}
+			return nil
+		}
 		vs, err := vlog.db.get(e.Key)
 		if err != nil {
 			return err
 		}
-		if discardEntry(e, vs) {
+		if discardEntry(e, vs, vlog.db.opt.now()) {
 			r.discard += esz
 			return nil
 		}
@@ -1669,7 +2087,7 @@ func (vlog *valueLog) doRunGC(lf *logFile, discardRatio float64, tr trace.Trace)
 	if err != nil {
 		tr.LazyPrintf("Error while iterating for RunGC: %v", err)
 		tr.SetError()
-		return err
+		return 0, err
 	}
 	tr.LazyPrintf("Fid: %d. Skipped: %5.2fMB Num iterations: %d. Data status=%+v\n",
 		lf.fid, skipped, numIterations, r)
@@ -1678,13 +2096,13 @@ func (vlog *valueLog) doRunGC(lf *logFile, discardRatio float64, tr trace.Trace)
 	// and what we can discard is below the threshold, we should skip the rewrite.
 	if (r.count < countWindow && r.total < sizeWindowM*0.75) || r.discard < discardRatio*r.total {
 		tr.LazyPrintf("Skipping GC on fid: %d", lf.fid)
-		return ErrNoRewrite
+		return 0, ErrNoRewrite
 	}
 	if err = vlog.rewrite(lf, tr); err != nil {
-		return err
+		return 0, err
 	}
 	tr.LazyPrintf("Done rewriting.")
-	return nil
+	return int64(float64(fi.Size()) * r.discard / r.total), nil
 }
 
 func (vlog *valueLog) waitOnGC(lc *y.Closer) {
@@ -1697,7 +2115,7 @@ func (vlog *valueLog) waitOnGC(lc *y.Closer) {
 	vlog.garbageCh <- struct{}{}
 }
 
-func (vlog *valueLog) runGC(discardRatio float64, head valuePointer) error {
+func (vlog *valueLog) runGC(discardRatio float64, head valuePointer) (int64, error) {
 	select {
 	case vlog.garbageCh <- struct{}{}:
 		// Pick a log file for GC.
@@ -1712,7 +2130,7 @@ func (vlog *valueLog) runGC(discardRatio float64, head valuePointer) error {
 		files := vlog.pickLog(head, tr)
 		if len(files) == 0 {
 			tr.LazyPrintf("PickLog returned zero results.")
-			return ErrNoRewrite
+			return 0, ErrNoRewrite
 		}
 		tried := make(map[uint32]bool)
 		for _, lf := range files {
@@ -1720,14 +2138,15 @@ func (vlog *valueLog) runGC(discardRatio float64, head valuePointer) error {
 				continue
 			}
 			tried[lf.fid] = true
-			err = vlog.doRunGC(lf, discardRatio, tr)
+			var reclaimed int64
+			reclaimed, err = vlog.doRunGC(lf, discardRatio, tr)
 			if err == nil {
-				return vlog.deleteMoveKeysFor(lf.fid, tr)
+				return reclaimed, vlog.deleteMoveKeysFor(lf.fid, tr)
 			}
 		}
-		return err
+		return 0, err
 	default:
-		return ErrRejected
+		return 0, ErrRejected
 	}
 }
 
@@ -1739,8 +2158,8 @@ func (vlog *valueLog) updateDiscardStats(stats map[uint32]int64) {
 	select {
 	case vlog.lfDiscardStats.flushChan <- stats:
 	default:
-		vlog.opt.Warningf("updateDiscardStats called: discard stats flushChan full, " +
-			"returning without pushing to flushChan")
+		vlog.opt.logger(LogComponentVlog).Warningf("updateDiscardStats called: discard stats " +
+			"flushChan full, returning without pushing to flushChan")
 	}
 }
 
@@ -1793,7 +2212,8 @@ func (vlog *valueLog) flushDiscardStats() {
 			return
 		case stats := <-vlog.lfDiscardStats.flushChan:
 			if err := process(stats); err != nil {
-				vlog.opt.Errorf("unable to process discardstats with error: %s", err)
+				vlog.opt.logger(LogComponentVlog).Errorf(
+					"unable to process discardstats with error: %s", err)
 			}
 		}
 	}
@@ -1813,7 +2233,7 @@ func (vlog *valueLog) populateDiscardStats() error {
 		}
 		// Value doesn't exist.
 		if vs.Meta == 0 && len(vs.Value) == 0 {
-			vlog.opt.Debugf("Value log discard stats empty")
+			vlog.opt.logger(LogComponentVlog).Debugf("Value log discard stats empty")
 			return nil
 		}
 		vp.Decode(vs.Value)
@@ -1851,7 +2271,7 @@ func (vlog *valueLog) populateDiscardStats() error {
 	if err := json.Unmarshal(val, &statsMap); err != nil {
 		return errors.Wrapf(err, "failed to unmarshal discard stats")
 	}
-	vlog.opt.Debugf("Value Log Discard stats: %v", statsMap)
+	vlog.opt.logger(LogComponentVlog).Debugf("Value Log Discard stats: %v", statsMap)
 	vlog.lfDiscardStats.flushChan <- statsMap
 	return nil
 }
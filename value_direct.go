@@ -0,0 +1,116 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"os"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/pkg/errors"
+)
+
+// directWriter writes the latest value log file with direct IO, for Options.ValueLogDirectIO.
+// The writes must be aligned to y.DirectIOAlignment, in offset, size and memory, so they go
+// through an aligned buffer, and the last block is padded with zeros when it's partial. The block
+// is kept in the buffer, and written again, in full, by the next write.
+//
+// The file is read, truncated and synced through the descriptor of the logFile, which doesn't
+// bypass the page cache. The direct writes invalidate the cached pages they overwrite, and the
+// syncs of the file make them durable too. The padding is truncated by logFile.doneWriting and
+// valueLog.Close, and by valueLog.replayLog after a crash.
+type directWriter struct {
+	fd  *os.File
+	buf []byte // Aligned, of a multiple of y.DirectIOAlignment.
+	off int64  // The offset of buf in the file, aligned.
+	n   int    // The number of bytes of buf to write.
+}
+
+// openDirectWriter opens the direct writer of lf, which is written from offset on.
+func (vlog *valueLog) openDirectWriter(lf *logFile, offset uint32) error {
+	if !vlog.opt.ValueLogDirectIO || vlog.opt.ReadOnly {
+		return nil
+	}
+	fd, err := y.OpenDirectFile(lf.path, vlog.opt.SyncWrites)
+	if err != nil {
+		return errFile(err, lf.path, "Open value log file with direct IO")
+	}
+	const align = y.DirectIOAlignment
+	size := (vlog.opt.valueLogWriteBufferSize() + align - 1) &^ (align - 1)
+	w := &directWriter{
+		fd:  fd,
+		buf: y.AlignedBuffer(int(size)),
+		off: int64(offset) &^ (align - 1),
+	}
+	// The first block is partial, read what it holds to write it again.
+	w.n = int(int64(offset) - w.off)
+	if w.n > 0 {
+		if _, err := lf.fd.ReadAt(w.buf[:w.n], w.off); err != nil {
+			_ = fd.Close()
+			return errFile(err, lf.path, "Read last block of value log file")
+		}
+	}
+	lf.dw = w
+	return nil
+}
+
+// write writes p at the end of the file.
+func (w *directWriter) write(p []byte) error {
+	for len(p) > 0 {
+		c := copy(w.buf[w.n:], p)
+		w.n += c
+		p = p[c:]
+		if w.n == len(w.buf) {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return w.flush()
+}
+
+// flush writes the buffer to the file, and keeps its last block if it's partial.
+func (w *directWriter) flush() error {
+	const align = y.DirectIOAlignment
+	if w.n == 0 {
+		return nil
+	}
+	end := (w.n + align - 1) &^ (align - 1)
+	for i := w.n; i < end; i++ {
+		w.buf[i] = 0
+	}
+	if _, err := w.fd.WriteAt(w.buf[:end], w.off); err != nil {
+		return errors.Wrapf(err, "Unable to write to value log file: %q", w.fd.Name())
+	}
+	full := w.n &^ (align - 1)
+	w.n = copy(w.buf, w.buf[full:w.n])
+	w.off += int64(full)
+	return nil
+}
+
+// isPadding returns true if b, past the end of the entries of a file, is the zero padding of its
+// last block, written with direct IO.
+func isPadding(b []byte) bool {
+	if len(b) >= y.DirectIOAlignment {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/dgraph-io/badger/v2/y"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestValueLogDirectIO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	opt := getTestOptions(dir)
+	opt.ValueLogFileSize = 1 << 20
+	opt.ValueLogDirectIO = true
+	opt.ValueLogWriteBufferSize = 10 << 10 // Rounded up to 12KB.
+
+	_, err = Open(opt.WithValueLogFS(osValueLogFS{}))
+	require.Error(t, err)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+	// The values of odd sizes don't fill the blocks.
+	val := func(i int) []byte { return []byte(fmt.Sprintf("%0*d", 100+i*37%5000, i)) }
+	const n = 1000
+	for i := 0; i < n; i++ {
+		require.NoError(t, db.Update(func(txn *Txn) error {
+			return txn.Set(key(i), val(i))
+		}))
+	}
+	check := func() {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			for i := 0; i < n; i++ {
+				item, err := txn.Get(key(i))
+				require.NoError(t, err)
+				require.Equal(t, val(i), getItemValue(t, item))
+			}
+			return nil
+		}))
+	}
+	check()
+	require.True(t, db.vlog.maxFid > 0, "the value log wasn't rotated")
+	require.NoError(t, db.Close())
+
+	// The padding was truncated.
+	vlogSizes := func() []int64 {
+		files, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+		require.NoError(t, err)
+		var sizes []int64
+		for _, f := range files {
+			fi, err := os.Stat(f)
+			require.NoError(t, err)
+			sizes = append(sizes, fi.Size())
+		}
+		return sizes
+	}
+	sizes := vlogSizes()
+	for _, sz := range sizes {
+		require.NotZero(t, sz%y.DirectIOAlignment)
+	}
+
+	// The padding left by a crash is ignored, even without Truncate.
+	last := db.vlog.fpath(db.vlog.maxFid)
+	fi, err := os.Stat(last)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(last, (fi.Size()+y.DirectIOAlignment-1)&^(y.DirectIOAlignment-1)))
+	opt.Truncate = false
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check()
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		return txn.Set(key(n), val(n))
+	}))
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get(key(n))
+		require.NoError(t, err)
+		require.Equal(t, val(n), getItemValue(t, item))
+		return nil
+	}))
+	require.NoError(t, db.Close())
+	require.Equal(t, len(sizes), len(vlogSizes()))
+}
+
+// residentBytes returns the number of bytes of the value log files of dir in the page cache.
+func residentBytes(b *testing.B, dir string) int64 {
+	files, err := filepath.Glob(filepath.Join(dir, "*.vlog"))
+	require.NoError(b, err)
+	page := int64(os.Getpagesize())
+	var n int64
+	for _, f := range files {
+		fd, err := os.Open(f)
+		require.NoError(b, err)
+		fi, err := fd.Stat()
+		require.NoError(b, err)
+		if fi.Size() > 0 {
+			m, err := y.Mmap(fd, false, fi.Size())
+			require.NoError(b, err)
+			vec := make([]byte, (fi.Size()+page-1)/page)
+			_, _, errno := unix.Syscall(unix.SYS_MINCORE, uintptr(unsafe.Pointer(&m[0])),
+				uintptr(len(m)), uintptr(unsafe.Pointer(&vec[0])))
+			require.Zero(b, errno)
+			for _, v := range vec {
+				n += int64(v&1) * page
+			}
+			require.NoError(b, y.Munmap(m))
+		}
+		require.NoError(b, fd.Close())
+	}
+	return n
+}
+
+// BenchmarkValueLogDirectIO writes values to the value log, and reports how much of it is left in
+// the page cache, with and without direct IO.
+func BenchmarkValueLogDirectIO(b *testing.B) {
+	for _, direct := range []bool{false, true} {
+		b.Run(fmt.Sprintf("direct=%t", direct), func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "vlog-benchmark")
+			require.NoError(b, err)
+			defer removeDir(dir)
+
+			opt := getTestOptions(dir)
+			opt.ValueLogFileSize = 64 << 20
+			opt.ValueLogDirectIO = direct
+			db, err := Open(opt)
+			require.NoError(b, err)
+
+			val := make([]byte, 4<<10)
+			b.SetBytes(int64(len(val)))
+			b.ResetTimer()
+			wb := db.NewWriteBatch()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, wb.Set([]byte(fmt.Sprintf("key%09d", i)), val))
+			}
+			require.NoError(b, wb.Flush())
+			b.StopTimer()
+
+			b.ReportMetric(float64(residentBytes(b, dir))/float64(b.N*len(val)), "cached/written")
+			require.NoError(b, db.Close())
+		})
+	}
+}
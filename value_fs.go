@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// ValueLogFile is a value log file opened by a ValueLogFS. *os.File implements it.
+//
+// Files which are not an *os.File cannot be memory-mapped, they are always read with ReadAt, as
+// if Options.ValueLogLoadingMode was options.FileIO.
+type ValueLogFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Sync() error
+}
+
+// ValueLogFS opens, creates and removes the value log files. Badger goes through it for all the
+// operations on these files, including the reads of values, which makes it possible to store
+// some of them elsewhere than in Options.ValueDir, e.g. to move the older files to a cheaper
+// mount. The paths given to it are always within Options.ValueDir.
+//
+// All the methods may be called concurrently.
+type ValueLogFS interface {
+	// Create creates a new file for reading and writing, and fails if it already exists. If sync
+	// is set, the writes to the file must be synced to disk before they return.
+	Create(path string, sync bool) (ValueLogFile, error)
+	// Open opens an existing file, for reading only if readOnly is set, otherwise for reading
+	// and writing. If sync is set, the writes to the file must be synced to disk before they
+	// return.
+	Open(path string, readOnly, sync bool) (ValueLogFile, error)
+	// Remove removes a file. Badger closes the file before removing it.
+	Remove(path string) error
+	// ReadDir lists the files of a directory.
+	ReadDir(dir string) ([]os.FileInfo, error)
+	// SyncDir makes the creation of the files of a directory durable.
+	SyncDir(dir string) error
+}
+
+// osValueLogFS is the ValueLogFS used by default, which stores the files on the local file system.
+type osValueLogFS struct{}
+
+func (osValueLogFS) Create(path string, sync bool) (ValueLogFile, error) {
+	return y.CreateSyncedFile(path, sync)
+}
+
+func (osValueLogFS) Open(path string, readOnly, sync bool) (ValueLogFile, error) {
+	var flags uint32
+	if readOnly {
+		flags |= y.ReadOnly
+	}
+	if sync {
+		flags |= y.Sync
+	}
+	return y.OpenExistingFile(path, flags)
+}
+
+func (osValueLogFS) Remove(path string) error { return os.Remove(path) }
+
+func (osValueLogFS) ReadDir(dir string) ([]os.FileInfo, error) { return ioutil.ReadDir(dir) }
+
+func (osValueLogFS) SyncDir(dir string) error { return syncDir(dir) }
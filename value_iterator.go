@@ -0,0 +1,244 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"bufio"
+	"io"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v2/y"
+)
+
+// VLogRecord is an entry of the value log, as returned by VLogIterator.Record.
+type VLogRecord struct {
+	// Fid and Offset locate the entry in the value log.
+	Fid    uint32
+	Offset uint32
+	// Key is the key of the entry, without its version. Internal keys, like the ones of the
+	// transaction markers, the values moved by the GC and the chunks of the chunked values, start
+	// with "!badger!".
+	Key     []byte
+	Version uint64
+	// ValueSize is the size of the value as stored in the entry, which is the manifest of the
+	// chunks for a chunked value.
+	ValueSize uint32
+	// Meta holds the internal flags of the entry, as written.
+	Meta      byte
+	UserMeta  byte
+	ExpiresAt uint64
+
+	// Referenced is true if the LSM tree points to the entry, or to the chunked value the entry
+	// is a chunk of.
+	Referenced bool
+	// Dead is true if the value log GC would discard the entry: its version was dropped from the
+	// LSM tree, or is deleted or expired, it's a transaction marker, or its value is stored in the
+	// LSM tree. The older versions of a key stay referenced until a compaction drops them.
+	Dead bool
+}
+
+// VLogIterator walks the entries of the value log files, in the order they were written, for
+// debugging. See DB.ValueLogIterator.
+type VLogIterator struct {
+	db    *DB
+	files []*logFile
+	ends  []uint32 // The offset each file is read up to.
+
+	idx    int // The index of the file being read.
+	reader *bufio.Reader
+	read   *safeRead
+
+	rec    VLogRecord
+	valid  bool
+	err    error
+	closed bool
+}
+
+// ValueLogIterator returns an iterator over all the entries of the value log, including the ones
+// which are stale, overwritten or deleted, for forensics, e.g. after a corruption. It walks the
+// value log files in order, regardless of the LSM tree, up to the end of the value log at the
+// time it's created, and looks up each entry in the LSM tree to tell whether it's still
+// referenced. It only reads the files, and doesn't go through the caches.
+//
+// The value log GC keeps running, but it doesn't delete the files it rewrites until the iterator
+// is closed, like for the other iterators. The iterator must be closed before the DB.
+//
+//	it, err := db.ValueLogIterator()
+//	...
+//	defer it.Close()
+//	for ; it.Valid(); it.Next() {
+//		rec := it.Record()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// An entry which can't be read, e.g. because it's corrupted, ends its file: the iterator logs a
+// warning and moves on to the next file.
+func (db *DB) ValueLogIterator() (*VLogIterator, error) {
+	if db.opt.InMemory {
+		return nil, ErrVLogIteratorInMemoryMode
+	}
+	vlog := &db.vlog
+	// The files found in filesMap once the count is taken are kept until it's released.
+	vlog.incrIteratorCount()
+	it := &VLogIterator{db: db}
+
+	vlog.filesLock.RLock()
+	for _, fid := range vlog.sortedFids() {
+		lf := vlog.filesMap[fid]
+		// The latest file is read up to what's written. The others are done writing, unless a
+		// rotation is in progress, after which they're read up to the end.
+		var end uint32
+		if fid == atomic.LoadUint32(&vlog.maxFid) {
+			end = vlog.woffset()
+		} else {
+			fi, err := lf.fd.Stat()
+			if err != nil {
+				vlog.filesLock.RUnlock()
+				_ = it.Close()
+				return nil, errFile(err, lf.path, "Unable to run file.Stat")
+			}
+			end = uint32(fi.Size())
+		}
+		it.files = append(it.files, lf)
+		it.ends = append(it.ends, end)
+	}
+	vlog.filesLock.RUnlock()
+
+	it.Next()
+	return it, nil
+}
+
+// Valid returns false once the iteration is done, or failed, see Err.
+func (it *VLogIterator) Valid() bool {
+	return it.valid
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *VLogIterator) Err() error {
+	return it.err
+}
+
+// Record returns the current entry. It's only valid while Valid returns true.
+func (it *VLogIterator) Record() VLogRecord {
+	return it.rec
+}
+
+// Next moves to the next entry.
+func (it *VLogIterator) Next() {
+	it.valid = false
+	for it.err == nil && !it.closed && it.idx < len(it.files) {
+		lf, end := it.files[it.idx], it.ends[it.idx]
+		if it.reader == nil {
+			if end <= vlogHeaderSize {
+				it.idx++
+				continue
+			}
+			// The file isn't read through its position, which the writes use.
+			it.reader = bufio.NewReader(
+				io.NewSectionReader(lf.fd, vlogHeaderSize, int64(end-vlogHeaderSize)))
+			it.read = &safeRead{
+				k:            make([]byte, 10),
+				v:            make([]byte, 10),
+				recordOffset: vlogHeaderSize,
+				lf:           lf,
+			}
+		}
+
+		e, err := it.read.Entry(it.reader)
+		switch {
+		case err == io.EOF:
+			it.nextFile()
+			continue
+		case err == io.ErrUnexpectedEOF || err == errTruncate:
+			it.db.opt.logger(LogComponentVlog).Warningf(
+				"Unable to read the entry at offset %d of value log file %d, of size %d. "+
+					"Skipping the rest of the file.", it.read.recordOffset, lf.fid, end)
+			it.nextFile()
+			continue
+		case err != nil:
+			it.err = errFile(err, lf.path, "Unable to read value log entry")
+			return
+		case e == nil:
+			continue
+		}
+
+		vp := valuePointer{
+			Fid:    lf.fid,
+			Offset: e.offset,
+			Len:    uint32(int(e.hlen) + len(e.Key) + len(e.Value) + checksumSize(e.meta)),
+		}
+		it.read.recordOffset += vp.Len
+		e.meta &^= bitXXHashChecksum
+
+		it.rec = VLogRecord{
+			Fid:       vp.Fid,
+			Offset:    vp.Offset,
+			Key:       y.ParseKey(e.Key),
+			Version:   y.ParseTs(e.Key),
+			ValueSize: uint32(len(e.Value)),
+			Meta:      e.meta,
+			UserMeta:  e.UserMeta,
+			ExpiresAt: e.ExpiresAt,
+		}
+		if it.rec.Referenced, it.rec.Dead, err = it.liveness(*e, vp); err != nil {
+			it.err = err
+			return
+		}
+		it.valid = true
+		return
+	}
+}
+
+func (it *VLogIterator) nextFile() {
+	it.idx++
+	it.reader = nil
+	it.read = nil
+}
+
+// liveness returns whether the LSM tree points to the entry e at vp, and whether the GC would
+// discard it.
+func (it *VLogIterator) liveness(e Entry, vp valuePointer) (referenced, dead bool, err error) {
+	vlog := &it.db.vlog
+	if isChunkKey(e.Key) {
+		_, _, live, err := vlog.liveChunk(e, vp)
+		return live, !live, err
+	}
+	vs, err := it.db.get(e.Key)
+	if err != nil {
+		return false, false, err
+	}
+	if vs.Version == y.ParseTs(e.Key) && vs.Meta&bitValuePointer > 0 && len(vs.Value) > 0 {
+		var p valuePointer
+		p.Decode(vs.Value)
+		referenced = p.Fid == vp.Fid && p.Offset == vp.Offset
+	}
+	return referenced, !referenced || discardEntry(e, vs, it.db.opt.now()), nil
+}
+
+// Close releases the value log files, which lets the GC delete the ones it rewrote. It must be
+// called before closing the DB.
+func (it *VLogIterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	it.valid = false
+	return it.db.vlog.decrIteratorCount()
+}
@@ -23,8 +23,10 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -156,6 +158,131 @@ func TestValueGCManaged(t *testing.T) {
 	require.Fail(t, "Unable to GC even a single value log file.")
 }
 
+func TestValueGCLoop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	N := 2000
+	type result struct {
+		reclaimed int64
+		err       error
+	}
+	results := make(chan result, 100)
+	opt := getTestOptions(dir)
+	opt.ValueLogMaxEntries = uint32(N / 10)
+	opt = opt.WithValueLogGCInterval(20 * time.Millisecond).
+		WithValueLogGCDiscardRatio(0.0001).
+		WithValueLogGCCallback(func(reclaimed int64, err error) {
+			select {
+			case results <- result{reclaimed, err}:
+			default:
+			}
+		})
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	sz := 16 << 10
+	for i := 0; i < N; i++ {
+		v := make([]byte, sz)
+		rand.Read(v[:rand.Intn(sz)])
+		txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), v, 0)
+	}
+	for i := 0; i < N; i++ {
+		txnDelete(t, db, []byte(fmt.Sprintf("key%d", i)))
+	}
+
+	timeout := time.After(20 * time.Second)
+	for {
+		select {
+		case r := <-results:
+			if r.err == nil {
+				require.True(t, r.reclaimed > 0)
+				return
+			}
+			require.Equal(t, ErrNoRewrite, r.err)
+		case <-timeout:
+			require.Fail(t, "The GC loop didn't rewrite any value log file.")
+			return
+		}
+	}
+}
+
+func TestValueGCLoopOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	// The ratio is only checked by Open when the loop runs.
+	opt := getTestOptions(dir).WithValueLogGCDiscardRatio(1)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	require.Nil(t, db.closers.valueGCLoop)
+	require.NoError(t, db.Close())
+	_, err = Open(opt.WithValueLogGCInterval(time.Minute))
+	require.Error(t, err)
+
+	// The loop doesn't run without a value log.
+	imdb, err := Open(getTestOptions("").WithInmemory(true).
+		WithValueLogGCInterval(time.Minute).WithValueLogGCDiscardRatio(1))
+	require.NoError(t, err)
+	require.Nil(t, imdb.closers.valueGCLoop)
+	require.NoError(t, imdb.Close())
+}
+
+func TestValueLogGCStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir)
+	opt.ValueLogMaxEntries = 10
+	db, err := Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 50; i++ {
+		txnSet(t, db, []byte(fmt.Sprintf("key%d", i)), make([]byte, 1<<10), 0)
+	}
+	vlog := &db.vlog
+	vlog.filesLock.RLock()
+	fids := vlog.sortedFids()
+	size := func(fid uint32) int64 { return int64(vlog.filesMap[fid].size) }
+	vlog.filesLock.RUnlock()
+	require.True(t, len(fids) > 4)
+
+	vlog.lfDiscardStats.Lock()
+	vlog.lfDiscardStats.m[fids[0]] = size(fids[0]) / 2
+	vlog.lfDiscardStats.m[fids[1]] = size(fids[1]) * 15 / 100
+	vlog.lfDiscardStats.m[fids[2]] = size(fids[2]) * 95 / 100
+	vlog.lfDiscardStats.Unlock()
+
+	head := valuePointer{Fid: fids[len(fids)-1]}
+	stats := vlog.gcStats(0.3, head)
+	require.Equal(t, []uint32{fids[2], fids[0]}, stats.Fids)
+	require.Equal(t, size(fids[0])+size(fids[2]), stats.TotalSize)
+	require.Equal(t, size(fids[0])/2+size(fids[2])*95/100, stats.Reclaimable)
+	var numFiles int
+	for _, n := range stats.DiscardRatios {
+		numFiles += n
+	}
+	require.Equal(t, len(fids)-1, numFiles) // Every file before the head.
+	require.Equal(t, 1, stats.DiscardRatios[1])
+	require.Equal(t, 1, stats.DiscardRatios[5])
+	require.Equal(t, 1, stats.DiscardRatios[9])
+
+	// Nothing changes, so the stats are the same the second time.
+	require.Equal(t, stats, vlog.gcStats(0.3, head))
+	vlog.lfDiscardStats.RLock()
+	require.Len(t, vlog.lfDiscardStats.m, 3)
+	vlog.lfDiscardStats.RUnlock()
+
+	_, err = db.ValueLogGCStats(0)
+	require.Equal(t, ErrInvalidRequest, err)
+	_, err = db.ValueLogGCStats(0.5)
+	require.NoError(t, err)
+}
+
 func TestValueGC(t *testing.T) {
 	dir, err := ioutil.TempDir("", "badger-test")
 	require.NoError(t, err)
@@ -1148,6 +1275,54 @@ func TestBlockedDiscardStatsOnClose(t *testing.T) {
 	require.NoError(t, db.Close())
 }
 
+func TestValueLogChecksumAlgorithm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	v := []byte(fmt.Sprintf("val%100d", 10))
+	opt := getTestOptions(dir)
+	opt.VerifyValueChecksum = true
+	opt.ValueLogChecksumAlgorithm = options.XXHash64
+	db, err := Open(opt)
+	require.NoError(t, err)
+	txnSet(t, db, []byte("key1"), v, 0)
+	require.NoError(t, db.Close())
+
+	// Entries checksummed with both algorithms can be read from the same value log file.
+	opt.ValueLogChecksumAlgorithm = options.CRC32C
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	txnSet(t, db, []byte("key2"), v, 0)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		for _, key := range []string{"key1", "key2"} {
+			item, err := txn.Get([]byte(key))
+			require.NoError(t, err)
+			val, err := item.ValueCopy(nil)
+			require.NoError(t, err)
+			require.Equal(t, v, val)
+		}
+		return nil
+	}))
+
+	lf := db.vlog.filesMap[0]
+	var h header
+	h.Decode(lf.fmap[vlogHeaderSize:])
+	require.NotZero(t, h.meta&bitXXHashChecksum)
+
+	var keys []string
+	_, err = db.vlog.iterate(lf, 0, func(e Entry, vp valuePointer) error {
+		require.Zero(t, e.meta&bitXXHashChecksum)
+		if e.meta&bitFinTxn == 0 {
+			keys = append(keys, string(y.ParseKey(e.Key)))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"key1", "key2"}, keys)
+}
+
 func TestValueEntryChecksum(t *testing.T) {
 	k := []byte("KEY")
 	v := []byte(fmt.Sprintf("val%100d", 10))
@@ -1221,3 +1396,327 @@ func TestValueEntryChecksum(t *testing.T) {
 		require.NoError(t, db.Close())
 	})
 }
+
+// tieredFS stores the value log files in another directory, and wraps them so that they are not
+// an *os.File.
+type tieredFS struct {
+	dir, tier string
+	sync.Mutex
+	reads int
+}
+
+type tieredFile struct {
+	*os.File
+	fs *tieredFS
+}
+
+func (f tieredFile) ReadAt(b []byte, off int64) (int, error) {
+	f.fs.Lock()
+	f.fs.reads++
+	f.fs.Unlock()
+	return f.File.ReadAt(b, off)
+}
+
+func (fs *tieredFS) path(path string) string {
+	return filepath.Join(fs.tier, strings.TrimPrefix(path, fs.dir))
+}
+
+func (fs *tieredFS) Create(path string, sync bool) (ValueLogFile, error) {
+	f, err := osValueLogFS{}.Create(fs.path(path), sync)
+	if err != nil {
+		return nil, err
+	}
+	return tieredFile{f.(*os.File), fs}, nil
+}
+
+func (fs *tieredFS) Open(path string, readOnly, sync bool) (ValueLogFile, error) {
+	f, err := osValueLogFS{}.Open(fs.path(path), readOnly, sync)
+	if err != nil {
+		return nil, err
+	}
+	return tieredFile{f.(*os.File), fs}, nil
+}
+
+func (fs *tieredFS) Remove(path string) error { return os.Remove(fs.path(path)) }
+
+func (fs *tieredFS) ReadDir(dir string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(fs.path(dir))
+}
+
+func (fs *tieredFS) SyncDir(dir string) error { return syncDir(fs.path(dir)) }
+
+func TestValueLogFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	tier, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(tier)
+
+	fs := &tieredFS{dir: dir, tier: tier}
+	opt := getTestOptions(dir).WithValueLogFS(fs)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	v := []byte(fmt.Sprintf("val%100d", 10))
+	require.Greater(t, len(v), db.opt.ValueThreshold)
+	txnSet(t, db, []byte("key"), v, 0)
+	require.NoError(t, db.Close())
+
+	vlogs := func(dir string) (n int) {
+		files, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		for _, f := range files {
+			if strings.HasSuffix(f.Name(), ".vlog") {
+				n++
+			}
+		}
+		return n
+	}
+	require.Zero(t, vlogs(dir))
+	require.Equal(t, 1, vlogs(tier))
+
+	db, err = Open(opt)
+	require.NoError(t, err)
+	require.Equal(t, options.FileIO, db.vlog.filesMap[0].loadingMode)
+	require.NoError(t, db.View(func(txn *Txn) error {
+		item, err := txn.Get([]byte("key"))
+		require.NoError(t, err)
+		val, err := item.ValueCopy(nil)
+		require.NoError(t, err)
+		require.Equal(t, v, val)
+		return nil
+	}))
+	fs.Lock()
+	require.NotZero(t, fs.reads)
+	fs.Unlock()
+
+	require.NoError(t, db.DropAll())
+	require.Zero(t, vlogs(dir))
+	require.Equal(t, 1, vlogs(tier))
+	require.NoError(t, db.Close())
+}
+
+func TestValueChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+	opt := getTestOptions(dir).WithValueLogFileSize(1 << 20).WithValueChunkSize(256 << 10).
+		WithMaxChunkedValueSize(4 << 20)
+
+	_, err = Open(opt.WithValueChunkSize(2 << 20))
+	require.Error(t, err)
+
+	db, err := Open(opt)
+	require.NoError(t, err)
+	big := make([]byte, 3<<20+1000)
+	rand.Read(big)
+	md := []byte("metadata")
+	require.NoError(t, db.Update(func(txn *Txn) error {
+		if err := txn.Set([]byte("big"), big); err != nil {
+			return err
+		}
+		if err := txn.SetEntry(NewEntry([]byte("md"), big[1000:]).WithMetadata(md)); err != nil {
+			return err
+		}
+		return txn.Set([]byte("small"), []byte("value"))
+	}))
+	require.Error(t, db.Update(func(txn *Txn) error {
+		return txn.Set([]byte("huge"), make([]byte, 4<<20+1))
+	}))
+
+	check := func(db *DB) {
+		require.NoError(t, db.View(func(txn *Txn) error {
+			item, err := txn.Get([]byte("big"))
+			require.NoError(t, err)
+			require.Equal(t, big, getItemValue(t, item))
+			require.Equal(t, int64(len(big)), item.ValueSize())
+			item, err = txn.Get([]byte("md"))
+			require.NoError(t, err)
+			require.Equal(t, big[1000:], getItemValue(t, item))
+			require.Equal(t, int64(len(big)-1000), item.ValueSize())
+			m, err := item.Metadata()
+			require.NoError(t, err)
+			require.Equal(t, md, m)
+
+			it := txn.NewIterator(DefaultIteratorOptions)
+			defer it.Close()
+			var keys []string
+			for it.Rewind(); it.Valid(); it.Next() {
+				keys = append(keys, string(it.Item().Key()))
+				val, err := it.Item().ValueCopy(nil)
+				require.NoError(t, err)
+				switch string(it.Item().Key()) {
+				case "big":
+					require.Equal(t, big, val)
+				case "md":
+					require.Equal(t, big[1000:], val)
+				}
+			}
+			require.Equal(t, []string{"big", "md", "small"}, keys)
+			return nil
+		}))
+	}
+	check(db)
+	// The chunks span several value log files.
+	require.Greater(t, len(db.vlog.sortedFids()), 6)
+
+	// The value is replayed from the value log.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer db.Close()
+	check(db)
+
+	// Rewriting a file holding chunks moves the whole value.
+	tr := trace.New("Test", "Test")
+	defer tr.Finish()
+	fids := db.vlog.sortedFids()
+	for _, fid := range fids[:3] {
+		db.vlog.filesLock.RLock()
+		lf := db.vlog.filesMap[fid]
+		db.vlog.filesLock.RUnlock()
+		require.NoError(t, db.vlog.rewrite(lf, tr))
+		check(db)
+	}
+	db.vlog.filesLock.RLock()
+	for _, fid := range fids[:3] {
+		require.NotContains(t, db.vlog.filesMap, fid)
+	}
+	db.vlog.filesLock.RUnlock()
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	check(db)
+}
+
+func TestValueLogIterator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	_, err = (&DB{opt: DefaultOptions("").WithInmemory(true)}).ValueLogIterator()
+	require.Equal(t, ErrVLogIteratorInMemoryMode, err)
+
+	opt := getTestOptions(dir)
+	db, err := Open(opt)
+	require.NoError(t, err)
+	big := func(i int) []byte { return bytes.Repeat([]byte{byte(i)}, 2*opt.ValueThreshold) }
+	set := func(key string, val []byte) {
+		require.NoError(t, db.Update(func(txn *Txn) error { return txn.Set([]byte(key), val) }))
+	}
+	set("a", big(1))
+	set("a", big(2))
+	set("b", big(3))
+	require.NoError(t, db.Update(func(txn *Txn) error { return txn.Delete([]byte("b")) }))
+	set("c", []byte("small"))
+	// The older versions are dropped by the compaction of level 0 on close.
+	require.NoError(t, db.Close())
+	db, err = Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+
+	records := func() []VLogRecord {
+		it, err := db.ValueLogIterator()
+		require.NoError(t, err)
+		defer func() { require.NoError(t, it.Close()) }()
+		var recs []VLogRecord
+		for ; it.Valid(); it.Next() {
+			recs = append(recs, it.Record())
+		}
+		require.NoError(t, it.Err())
+		return recs
+	}
+	type state struct{ referenced, dead bool }
+	got := make(map[string][]state)
+	var last valuePointer
+	for _, r := range records() {
+		vp := valuePointer{Fid: r.Fid, Offset: r.Offset}
+		require.True(t, last.Less(vp), "%+v after %+v", vp, last)
+		last = vp
+		if bytes.HasPrefix(r.Key, txnKey) {
+			require.True(t, r.Dead)
+			continue
+		}
+		got[string(r.Key)] = append(got[string(r.Key)], state{r.Referenced, r.Dead})
+	}
+	require.Equal(t, map[string][]state{
+		"a": {{false, true}, {true, false}},
+		"b": {{false, true}, {false, true}},
+		"c": {{false, true}},
+	}, got)
+
+	// The value log is read up to its end at the time the iterator is created.
+	it, err := db.ValueLogIterator()
+	require.NoError(t, err)
+	set("d", big(4))
+	var n int
+	for ; it.Valid(); it.Next() {
+		require.NotEqual(t, "d", string(it.Record().Key))
+		n++
+	}
+	require.NoError(t, it.Close())
+	require.False(t, it.Valid())
+	require.Equal(t, len(got["a"])+len(got["b"])+len(got["c"])+5, n)
+}
+
+func TestReplayProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "badger-test")
+	require.NoError(t, err)
+	defer removeDir(dir)
+
+	var events []ReplayProgress
+	opt := getTestOptions(dir)
+	opt.ValueLogMaxEntries = 10
+	opt.LogRotatesToFlush = 1000
+	opt.OnReplayProgress = func(p ReplayProgress) { events = append(events, p) }
+
+	// There is nothing to replay in a new DB.
+	db0, err := Open(opt)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.True(t, events[1].Done)
+	require.Zero(t, events[1].Files)
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		require.NoError(t, db0.Update(func(txn *Txn) error {
+			return txn.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 100))
+		}))
+	}
+	// Simulate a crash by not closing db0, but releasing the locks.
+	if db0.dirLockGuard != nil {
+		require.NoError(t, db0.dirLockGuard.release())
+	}
+	if db0.valueDirGuard != nil {
+		require.NoError(t, db0.valueDirGuard.release())
+	}
+
+	events = nil
+	db1, err := Open(opt)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db1.Close()) }()
+
+	first, last := events[0], events[len(events)-1]
+	require.Zero(t, first.FilesDone)
+	require.Zero(t, first.BytesDone)
+	require.True(t, last.Done)
+	require.True(t, last.Files > 1, "files=%d", last.Files)
+	require.Equal(t, last.Files, last.FilesDone)
+	require.True(t, last.Bytes > n*100, "bytes=%d", last.Bytes)
+	require.Equal(t, last.Bytes, last.BytesDone)
+	// Each transaction is an entry followed by the entry ending it.
+	require.Equal(t, int64(2*n), last.Entries)
+	// One event before the replay, one per file and the last one.
+	require.Len(t, events, last.Files+2)
+	for i := 1; i < len(events); i++ {
+		require.True(t, events[i].BytesDone >= events[i-1].BytesDone)
+	}
+
+	require.NoError(t, db1.View(func(txn *Txn) error {
+		for i := 0; i < n; i++ {
+			_, err := txn.Get([]byte(fmt.Sprintf("key%03d", i)))
+			require.NoError(t, err)
+		}
+		return nil
+	}))
+}
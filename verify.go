@@ -0,0 +1,144 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package badger
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v2/table"
+)
+
+// VerifyOptions is used to configure DB.VerifyChecksums.
+type VerifyOptions struct {
+	// Concurrency is the number of tables verified in parallel. Defaults to 4.
+	Concurrency int
+	// StopOnError stops the verification at the first corrupt table.
+	StopOnError bool
+}
+
+// TableVerifyResult is the result of verifying a single table.
+type TableVerifyResult struct {
+	ID    uint64
+	Level int
+	OK    bool
+	// Offset of the first corrupt block, or of the index, in the table file. It is -1 if the
+	// table is not corrupt.
+	Offset int
+	Err    error
+}
+
+// VerifyReport is returned by DB.VerifyChecksums.
+type VerifyReport struct {
+	// Tables contains the results of the verified tables, sorted by level and table ID. If the
+	// verification was stopped at the first corrupt table, the tables which weren't verified are
+	// missing.
+	Tables []TableVerifyResult
+	// NumCorrupt is the number of corrupt tables.
+	NumCorrupt int
+}
+
+// VerifyChecksums reads all the tables of all the levels from disk, and verifies the checksums of
+// their indexes and blocks. Unlike VerifyChecksum, it verifies every table irrespective of
+// opt.ChecksumVerificationMode, reads the blocks from disk instead of the block cache, and
+// reports the result of every table. It doesn't run a transaction, and can be called while the
+// DB is serving reads and writes. Tables compacted away during the verification are still
+// verified, as they are only deleted once verified.
+//
+// If any table is corrupt, the error of the first corrupt table is returned along with the
+// report.
+func (db *DB) VerifyChecksums(opt VerifyOptions) (VerifyReport, error) {
+	if opt.Concurrency <= 0 {
+		opt.Concurrency = 4
+	}
+	type levelTable struct {
+		level int
+		t     *table.Table
+	}
+	var tables []levelTable
+	for _, l := range db.lc.levels {
+		l.RLock()
+		for _, t := range l.tables {
+			t.IncrRef()
+			tables = append(tables, levelTable{level: l.level, t: t})
+		}
+		l.RUnlock()
+	}
+
+	results := make([]TableVerifyResult, len(tables))
+	verified := make([]bool, len(tables))
+	var stop int32
+	idxCh := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opt.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxCh {
+				lt := tables[idx]
+				if atomic.LoadInt32(&stop) == 0 {
+					offset, err := lt.t.VerifyOnDisk()
+					results[idx] = TableVerifyResult{
+						ID:     lt.t.ID(),
+						Level:  lt.level,
+						OK:     err == nil,
+						Offset: offset,
+						Err:    err,
+					}
+					verified[idx] = true
+					if err != nil && opt.StopOnError {
+						atomic.StoreInt32(&stop, 1)
+					}
+				}
+				if err := lt.t.DecrRef(); err != nil {
+					db.opt.logger(LogComponentVerify).Errorf("unable to decrease reference "+
+						"of table: %s while verifying checksums with error: %s",
+						lt.t.Filename(), err)
+				}
+			}
+		}()
+	}
+	for i := range tables {
+		idxCh <- i
+	}
+	close(idxCh)
+	wg.Wait()
+
+	var report VerifyReport
+	for i, res := range results {
+		if verified[i] {
+			report.Tables = append(report.Tables, res)
+		}
+	}
+	sort.Slice(report.Tables, func(i, j int) bool {
+		if report.Tables[i].Level != report.Tables[j].Level {
+			return report.Tables[i].Level < report.Tables[j].Level
+		}
+		return report.Tables[i].ID < report.Tables[j].ID
+	})
+	var firstErr error
+	for _, res := range report.Tables {
+		if !res.OK {
+			report.NumCorrupt++
+			if firstErr == nil {
+				firstErr = res.Err
+			}
+		}
+	}
+	return report, firstErr
+}
@@ -19,7 +19,9 @@ package y
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 )
 
 // XORBlock encrypts the given data with AES and XOR's with IV.
@@ -42,3 +44,44 @@ func GenerateIV() ([]byte, error) {
 	_, err := rand.Read(iv)
 	return iv, err
 }
+
+// ivKeyLabel is the HKDF label of the key the deterministic IVs are derived with.
+const ivKeyLabel = "badger deterministic iv"
+
+// hmacSum returns the HMAC-SHA256 of the given parts with the given key.
+func hmacSum(key []byte, parts ...[]byte) ([]byte, error) {
+	h := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		if _, err := h.Write(p); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// deriveKey derives a key for the given label from the given key with HKDF-SHA256 (RFC 5869),
+// so that the key is never used for two purposes.
+func deriveKey(key []byte, label string) ([]byte, error) {
+	// Extract, with no salt.
+	prk, err := hmacSum(make([]byte, sha256.Size), key)
+	if err != nil {
+		return nil, err
+	}
+	// Expand, one block is enough for a 32 bytes key.
+	return hmacSum(prk, []byte(label), []byte{1})
+}
+
+// DeterministicIV derives an IV from the data to encrypt and the key, so that the same data is
+// always encrypted the same way with the same key. Different data gets a different IV. The IV is
+// not computed with the key itself, but with a key derived from it.
+func DeterministicIV(key, data []byte) ([]byte, error) {
+	ivKey, err := deriveKey(key, ivKeyLabel)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := hmacSum(ivKey, data)
+	if err != nil {
+		return nil, err
+	}
+	return sum[:aes.BlockSize], nil
+}
@@ -88,6 +88,14 @@ type Iterator interface {
 	Next()
 	Rewind()
 	Seek(key []byte)
+
+	// SeekToFirst and SeekToLast move to the smallest and the biggest key, whatever
+	// the direction of the iterator. For a reversed iterator SeekToFirst is the
+	// last position, so a Next after it makes the iterator invalid. The other way
+	// around for SeekToLast.
+	SeekToFirst()
+	SeekToLast()
+
 	Key() []byte
 	Value() ValueStruct
 	Valid() bool
@@ -95,3 +103,26 @@ type Iterator interface {
 	// All iterators should be closed so that file garbage collection works.
 	Close() error
 }
+
+// ErrIterator is implemented by iterators that can fail, like the table iterators on a
+// corrupt block. Err returns the error which made the iterator invalid, if any. An
+// iterator which simply reached its end returns nil.
+type ErrIterator interface {
+	Err() error
+}
+
+// IteratorErr returns the error which made it invalid, if it implements ErrIterator.
+// It returns nil for the iterators which can't fail.
+func IteratorErr(it Iterator) error {
+	if ei, ok := it.(ErrIterator); ok {
+		return ei.Err()
+	}
+	return nil
+}
+
+// Peeker is implemented by iterators that can return the key a subsequent call
+// to Next would move to, without moving the iterator. The returned bool is false
+// if the iterator is not valid or if Next would make it invalid.
+type Peeker interface {
+	Peek() ([]byte, bool)
+}
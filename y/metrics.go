@@ -48,6 +48,20 @@ var (
 	NumBlockedPuts *expvar.Int
 	// NumMemtableGets is number of memtable gets
 	NumMemtableGets *expvar.Int
+	// NumCompactions is number of compactions, by level compacted
+	NumCompactions *expvar.Map
+	// WriteStallTime is the time the writes were stalled by level 0, in milliseconds
+	WriteStallTime *expvar.Map
+	// NumTableReopens is number of table files opened again after being closed to bound the
+	// number of open files
+	NumTableReopens *expvar.Int
+	// NumBlockChecksumVerifications is number of checksums of table blocks verified
+	NumBlockChecksumVerifications *expvar.Int
+	// NumBlockChecksumMismatches is number of checksums of table blocks which failed verification
+	NumBlockChecksumMismatches *expvar.Int
+	// CompactionRateLimitUtilization is the IO of the compactions and of the value log GC during
+	// the last second, as a percentage of Options.CompactionRateLimit
+	CompactionRateLimitUtilization *expvar.Map
 )
 
 // These variables are global and have cumulative values for all kv stores.
@@ -62,6 +76,12 @@ func init() {
 	NumPuts = expvar.NewInt("badger_puts_total")
 	NumBlockedPuts = expvar.NewInt("badger_blocked_puts_total")
 	NumMemtableGets = expvar.NewInt("badger_memtable_gets_total")
+	NumCompactions = expvar.NewMap("badger_compactions_total")
+	WriteStallTime = expvar.NewMap("badger_write_stall_milliseconds_total")
+	NumTableReopens = expvar.NewInt("badger_table_reopens_total")
+	NumBlockChecksumVerifications = expvar.NewInt("badger_block_checksum_verifications_total")
+	NumBlockChecksumMismatches = expvar.NewInt("badger_block_checksum_mismatches_total")
+	CompactionRateLimitUtilization = expvar.NewMap("badger_compaction_rate_limit_utilization_percent")
 	LSMSize = expvar.NewMap("badger_lsm_size_bytes")
 	VlogSize = expvar.NewMap("badger_vlog_size_bytes")
 	PendingWrites = expvar.NewMap("badger_pending_writes_total")
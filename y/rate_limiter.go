@@ -0,0 +1,157 @@
+/*
+ * Copyright 2020 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package y
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the rate of some IO, in bytes per second. The bucket
+// holds up to a tenth of a second of IO, and fills up at the rate. Wait takes the bytes from the
+// bucket, and can take more than it holds, in which case the next calls wait until it's refilled.
+// Use NewRateLimiter to create one.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64 // Bytes per second, unlimited if zero.
+	tokens float64
+	last   time.Time
+	closed bool
+	wake   chan struct{} // Closed to wake the calls to Wait up, when the rate changes.
+
+	// The bytes allowed during the current window of one second, and during the previous one.
+	windowStart time.Time
+	cur, prev   int64
+}
+
+// NewRateLimiter returns a RateLimiter of the given rate, in bytes per second. Zero is unlimited.
+func NewRateLimiter(rate int64) *RateLimiter {
+	l := &RateLimiter{wake: make(chan struct{})}
+	l.SetRate(rate)
+	return l
+}
+
+// Rate returns the rate of the limiter, in bytes per second.
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// SetRate sets the rate of the limiter, in bytes per second. Zero is unlimited. The calls to Wait
+// already waiting wait for the rest of their bytes at the new rate, or return if it's unlimited.
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.refill(now)
+	l.rate = rate
+	if max := l.burst(); l.tokens > max || rate <= 0 {
+		l.tokens = max
+	}
+	l.wakeUp()
+}
+
+// Close makes the calls to Wait return right away, the ones already waiting included, so that the
+// IO being limited isn't held up by the limiter once it's being stopped.
+func (l *RateLimiter) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.wakeUp()
+}
+
+// wakeUp wakes the calls to Wait up. It must be called with the lock held.
+func (l *RateLimiter) wakeUp() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// burst returns the capacity of the bucket.
+func (l *RateLimiter) burst() float64 {
+	return float64(l.rate) / 10
+}
+
+// refill adds the tokens accumulated since the last call. It must be called with the lock held.
+func (l *RateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+		if max := l.burst(); l.tokens > max {
+			l.tokens = max
+		}
+	}
+	l.last = now
+}
+
+// record accounts n bytes allowed at now for Utilization. It must be called with the lock held.
+func (l *RateLimiter) record(now time.Time, n int64) {
+	switch d := now.Sub(l.windowStart); {
+	case d >= 2*time.Second:
+		l.windowStart, l.cur, l.prev = now, 0, 0
+	case d >= time.Second:
+		l.windowStart, l.cur, l.prev = l.windowStart.Add(time.Second), 0, l.cur
+	}
+	l.cur += n
+}
+
+// Wait takes n bytes from the bucket, and blocks until the limiter allows them, or until it's
+// closed.
+func (l *RateLimiter) Wait(n int64) {
+	if n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 || l.closed {
+		return
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	// The bytes to be refilled before n is allowed. They're counted down as the time goes, so that
+	// the rest is waited for at the new rate if the rate changes meanwhile.
+	debt := -l.tokens
+	for debt > 0 && l.rate > 0 && !l.closed {
+		rate, wake := l.rate, l.wake
+		l.mu.Unlock()
+		start := time.Now()
+		timer := time.NewTimer(time.Duration(debt / float64(rate) * float64(time.Second)))
+		select {
+		case <-timer.C:
+		case <-wake:
+			timer.Stop()
+		}
+		l.mu.Lock()
+		debt -= time.Since(start).Seconds() * float64(rate)
+	}
+	l.record(time.Now(), n)
+}
+
+// Utilization returns the bytes allowed during the last second, as a percentage of the rate. It
+// returns zero if the limiter is unlimited.
+func (l *RateLimiter) Utilization() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	l.record(now, 0)
+	// Count the part of the previous window which is within the last second.
+	frac := 1 - float64(now.Sub(l.windowStart))/float64(time.Second)
+	taken := float64(l.prev)*frac + float64(l.cur)
+	return int64(taken * 100 / float64(l.rate))
+}
@@ -48,6 +48,8 @@ const (
 var (
 	// This is O_DSYNC (datasync) on platforms that support it -- see file_unix.go
 	datasyncFileFlag = 0x0
+	// This is O_DIRECT on platforms that support it -- see file_direct.go
+	directFileFlag = 0x0
 
 	// CastagnoliCrcTable is a CRC32 polynomial table
 	CastagnoliCrcTable = crc32.MakeTable(crc32.Castagnoli)
@@ -87,6 +89,38 @@ func OpenSyncedFile(filename string, sync bool) (*os.File, error) {
 	return os.OpenFile(filename, flags, 0600)
 }
 
+// DirectIOAlignment is the alignment, in offset, size and memory, of the writes to the files
+// opened by OpenDirectFile.
+const DirectIOAlignment = 4096
+
+// DirectIOSupported returns true if the files can be opened with direct IO on this platform.
+func DirectIOSupported() bool {
+	return directFileFlag != 0
+}
+
+// OpenDirectFile opens an existing file for writing with direct IO, bypassing the page cache. The
+// writes must be aligned to DirectIOAlignment, see AlignedBuffer.
+func OpenDirectFile(filename string, sync bool) (*os.File, error) {
+	if !DirectIOSupported() {
+		return nil, errors.New("Direct IO isn't supported on this platform")
+	}
+	flags := os.O_WRONLY | directFileFlag
+	if sync {
+		flags |= datasyncFileFlag
+	}
+	return os.OpenFile(filename, flags, 0)
+}
+
+// AlignedBuffer returns a buffer of size n whose memory is aligned to DirectIOAlignment.
+func AlignedBuffer(n int) []byte {
+	b := make([]byte, n+DirectIOAlignment)
+	off := int(uintptr(unsafe.Pointer(&b[0])) & (DirectIOAlignment - 1))
+	if off > 0 {
+		off = DirectIOAlignment - off
+	}
+	return b[off : off+n : off+n]
+}
+
 // OpenTruncFile opens the file with O_RDWR | O_CREATE | O_TRUNC
 func OpenTruncFile(filename string, sync bool) (*os.File, error) {
 	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
@@ -135,6 +169,32 @@ func CompareKeys(key1, key2 []byte) int {
 	return bytes.Compare(key1[len(key1)-8:], key2[len(key2)-8:])
 }
 
+// Comparator orders the keys without timestamp, returning a negative number, zero or a positive
+// number if key1 is less than, equal to or greater than key2. A nil Comparator orders them with
+// bytes.Compare, which is the default order of Badger.
+type Comparator func(key1, key2 []byte) int
+
+// Compare compares two keys without timestamp.
+func (c Comparator) Compare(key1, key2 []byte) int {
+	if c == nil {
+		return bytes.Compare(key1, key2)
+	}
+	return c(key1, key2)
+}
+
+// CompareKeys is like the CompareKeys function, but orders the keys without timestamp with the
+// comparator. The nil comparator goes through CompareKeys, so that the default order only pays
+// for the nil check.
+func (c Comparator) CompareKeys(key1, key2 []byte) int {
+	if c == nil {
+		return CompareKeys(key1, key2)
+	}
+	if cmp := c(key1[:len(key1)-8], key2[:len(key2)-8]); cmp != 0 {
+		return cmp
+	}
+	return bytes.Compare(key1[len(key1)-8:], key2[len(key2)-8:])
+}
+
 // ParseKey parses the actual key from the key bytes.
 func ParseKey(key []byte) []byte {
 	if key == nil {
@@ -2,6 +2,10 @@ package y
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math/rand"
@@ -254,3 +258,77 @@ func TestPagebufferReader4(t *testing.T) {
 	require.Equal(t, err, io.EOF, "should return EOF")
 	require.Equal(t, n, 0)
 }
+
+func TestRateLimiter(t *testing.T) {
+	const rate = 1 << 20
+	l := NewRateLimiter(rate)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		l.Wait(rate / 20)
+	}
+	// The bucket starts empty, so half a second of IO takes at least half a second.
+	require.True(t, time.Since(start) >= 450*time.Millisecond, "took %s", time.Since(start))
+	require.InDelta(t, 50, l.Utilization(), 15)
+
+	// Raising the rate makes the next calls wait less, removing it makes them not wait.
+	l.SetRate(10 * rate)
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		l.Wait(rate / 20)
+	}
+	require.True(t, time.Since(start) < 200*time.Millisecond, "took %s", time.Since(start))
+	l.SetRate(0)
+	start = time.Now()
+	l.Wait(100 * rate)
+	require.True(t, time.Since(start) < 50*time.Millisecond, "took %s", time.Since(start))
+	require.Equal(t, int64(0), l.Utilization())
+
+	// The calls already waiting are woken up by SetRate and by Close.
+	wait := func(l *RateLimiter, fn func()) {
+		done := make(chan time.Duration)
+		go func() {
+			start := time.Now()
+			l.Wait(100 * rate)
+			done <- time.Since(start)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		fn()
+		took := <-done
+		require.True(t, took < time.Second, "took %s", took)
+	}
+	l = NewRateLimiter(rate)
+	wait(l, func() { l.SetRate(0) })
+	l = NewRateLimiter(rate)
+	wait(l, func() { l.SetRate(1000 * rate) })
+	l = NewRateLimiter(rate)
+	wait(l, l.Close)
+	start = time.Now()
+	l.Wait(100 * rate)
+	require.True(t, time.Since(start) < 50*time.Millisecond, "took %s", time.Since(start))
+}
+
+func TestDeterministicIV(t *testing.T) {
+	// RFC 5869, test case 3: no salt and no info.
+	okm, err := deriveKey(bytes.Repeat([]byte{0x0b}, 22), "")
+	require.NoError(t, err)
+	require.Equal(t, "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d",
+		hex.EncodeToString(okm))
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	data := []byte("block")
+	iv, err := DeterministicIV(key, data)
+	require.NoError(t, err)
+	require.Len(t, iv, aes.BlockSize)
+	iv2, err := DeterministicIV(key, data)
+	require.NoError(t, err)
+	require.Equal(t, iv, iv2)
+	other, err := DeterministicIV(key, []byte("other block"))
+	require.NoError(t, err)
+	require.NotEqual(t, iv, other)
+
+	// The data key itself isn't used as the HMAC key.
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	require.NotEqual(t, h.Sum(nil)[:aes.BlockSize], iv)
+}
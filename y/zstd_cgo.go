@@ -19,18 +19,81 @@
 package y
 
 import (
-	"github.com/DataDog/zstd"
+	"github.com/pkg/errors"
+	"github.com/valyala/gozstd"
 )
 
 // CgoEnabled is used to check if CGO is enabled while building badger.
 const CgoEnabled = true
 
+// zstdMinSamples is the number of samples needed to train a dictionary.
+const zstdMinSamples = 10
+
 // ZSTDDecompress decompresses a block using ZSTD algorithm.
 func ZSTDDecompress(dst, src []byte) ([]byte, error) {
-	return zstd.Decompress(dst, src)
+	return gozstd.Decompress(dst[:0], src)
 }
 
 // ZSTDCompress compresses a block using ZSTD algorithm.
 func ZSTDCompress(dst, src []byte, compressionLevel int) ([]byte, error) {
-	return zstd.CompressLevel(dst, src, compressionLevel)
+	return gozstd.CompressLevel(dst[:0], src, compressionLevel), nil
+}
+
+// ZSTDTrainDictionary trains a ZSTD dictionary of at most size bytes from the given samples.
+func ZSTDTrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	var n int
+	for _, s := range samples {
+		if len(s) > 0 {
+			n++
+		}
+	}
+	if n < zstdMinSamples {
+		return nil, errors.Errorf("%d samples is too few to train a zstd dictionary", n)
+	}
+	dict := gozstd.BuildDict(samples, size)
+	if len(dict) == 0 {
+		return nil, errors.New("while training zstd dictionary: the samples are too small")
+	}
+	return dict, nil
+}
+
+// ZSTDCDict compresses blocks against a ZSTD dictionary. The dictionary is only digested once, by
+// NewZSTDCDict. It is safe for concurrent use.
+type ZSTDCDict struct {
+	cd *gozstd.CDict
+}
+
+// NewZSTDCDict returns a ZSTDCDict compressing at the given level against the given dictionary.
+func NewZSTDCDict(dict []byte, compressionLevel int) (*ZSTDCDict, error) {
+	cd, err := gozstd.NewCDictLevel(dict, compressionLevel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while loading zstd dictionary")
+	}
+	return &ZSTDCDict{cd: cd}, nil
+}
+
+// Compress compresses a block using ZSTD algorithm and the dictionary.
+func (d *ZSTDCDict) Compress(dst, src []byte) ([]byte, error) {
+	return gozstd.CompressDict(dst[:0], src, d.cd), nil
+}
+
+// ZSTDDDict decompresses blocks compressed against a ZSTD dictionary. The dictionary is only
+// digested once, by NewZSTDDDict. It is safe for concurrent use.
+type ZSTDDDict struct {
+	dd *gozstd.DDict
+}
+
+// NewZSTDDDict returns a ZSTDDDict decompressing against the given dictionary.
+func NewZSTDDDict(dict []byte) (*ZSTDDDict, error) {
+	dd, err := gozstd.NewDDict(dict)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while loading zstd dictionary")
+	}
+	return &ZSTDDDict{dd: dd}, nil
+}
+
+// Decompress decompresses a block compressed by ZSTDCDict.Compress with the same dictionary.
+func (d *ZSTDDDict) Decompress(dst, src []byte) ([]byte, error) {
+	dst, err := gozstd.DecompressDict(dst[:0], src, d.dd)
+	return dst, errors.Wrapf(err, "while decompressing with zstd dictionary")
 }
@@ -36,3 +36,34 @@ func ZSTDDecompress(dst, src []byte) ([]byte, error) {
 func ZSTDCompress(dst, src []byte, compressionLevel int) ([]byte, error) {
 	return nil, errZstdCgo
 }
+
+// ZSTDTrainDictionary trains a ZSTD dictionary of at most size bytes from the given samples.
+func ZSTDTrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	return nil, errZstdCgo
+}
+
+// ZSTDCDict compresses blocks against a ZSTD dictionary.
+type ZSTDCDict struct{}
+
+// NewZSTDCDict returns a ZSTDCDict compressing at the given level against the given dictionary.
+func NewZSTDCDict(dict []byte, compressionLevel int) (*ZSTDCDict, error) {
+	return nil, errZstdCgo
+}
+
+// Compress compresses a block using ZSTD algorithm and the dictionary.
+func (d *ZSTDCDict) Compress(dst, src []byte) ([]byte, error) {
+	return nil, errZstdCgo
+}
+
+// ZSTDDDict decompresses blocks compressed against a ZSTD dictionary.
+type ZSTDDDict struct{}
+
+// NewZSTDDDict returns a ZSTDDDict decompressing against the given dictionary.
+func NewZSTDDDict(dict []byte) (*ZSTDDDict, error) {
+	return nil, errZstdCgo
+}
+
+// Decompress decompresses a block compressed by ZSTDCDict.Compress with the same dictionary.
+func (d *ZSTDDDict) Decompress(dst, src []byte) ([]byte, error) {
+	return nil, errZstdCgo
+}